@@ -249,6 +249,105 @@ func (ac *AdminClient) DeactivateMaintenance(slug string) error {
 	return err
 }
 
+// ListRollouts is used to list the staged rollouts of the applications.
+func (ac *AdminClient) ListRollouts() ([]interface{}, error) {
+	res, err := ac.Req(&request.Options{
+		Method: "GET",
+		Path:   "/apps/rollouts",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	if err := readJSONAPI(res.Body, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// PutRollout is used to create or replace the staged rollout of an
+// application for a context.
+func (ac *AdminClient) PutRollout(slug, context string, attrs map[string]interface{}) (interface{}, error) {
+	data := map[string]interface{}{"attributes": attrs}
+	body, err := writeJSONAPI(data)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ac.Req(&request.Options{
+		Method:  "PUT",
+		Path:    "/apps/rollouts/" + slug,
+		Queries: rolloutQueries(context, ""),
+		Body:    body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rollout interface{}
+	if err := readJSONAPI(res.Body, &rollout); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+// DeleteRollout is used to remove the staged rollout of an application for a
+// context.
+func (ac *AdminClient) DeleteRollout(slug, context string) error {
+	_, err := ac.Req(&request.Options{
+		Method:     "DELETE",
+		Path:       "/apps/rollouts/" + slug,
+		Queries:    rolloutQueries(context, ""),
+		NoResponse: true,
+	})
+	return err
+}
+
+// ChangeRollout is used to pause, resume or roll back the staged rollout of an
+// application for a context. The action is "pause", "resume" or "rollback".
+func (ac *AdminClient) ChangeRollout(slug, context, action, reason string) (interface{}, error) {
+	res, err := ac.Req(&request.Options{
+		Method:  "POST",
+		Path:    "/apps/rollouts/" + slug + "/" + action,
+		Queries: rolloutQueries(context, reason),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rollout interface{}
+	if err := readJSONAPI(res.Body, &rollout); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+// RollbackApp is used to roll back an application of an instance to its
+// previous version.
+func (ac *AdminClient) RollbackApp(domain, appType, slug string) (*AppManifest, error) {
+	queries := url.Values{"Domain": {domain}}
+	if appType == consts.Konnectors {
+		queries.Add("Type", "konnector")
+	}
+	res, err := ac.Req(&request.Options{
+		Method:  "POST",
+		Path:    "/apps/" + slug + "/rollback",
+		Queries: queries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readAppManifest(res)
+}
+
+func rolloutQueries(context, reason string) url.Values {
+	queries := url.Values{}
+	if context != "" {
+		queries.Add("Context", context)
+	}
+	if reason != "" {
+		queries.Add("Reason", reason)
+	}
+	return queries
+}
+
 func makeAppsPath(appType, path string) string {
 	switch appType {
 	case consts.Apps:
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,8 +15,10 @@ import (
 	"time"
 
 	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/backup"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/move"
+	"github.com/cozy/cozy-stack/model/takeout"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/realtime"
 	"github.com/labstack/echo/v4"
@@ -538,6 +541,123 @@ func (ac *AdminClient) Import(domain string, opts *ImportOptions) error {
 	return err
 }
 
+// ListBackups returns the snapshots of an instance.
+func (ac *AdminClient) ListBackups(domain string) ([]*backup.Snapshot, error) {
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("Invalid domain: %s", domain)
+	}
+	res, err := ac.Req(&request.Options{
+		Method: "GET",
+		Path:   "/instances/" + url.PathEscape(domain) + "/backups",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var snapshots []*backup.Snapshot
+	if err = json.NewDecoder(res.Body).Decode(&snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// CreateBackup launches a job for taking a snapshot of an instance.
+func (ac *AdminClient) CreateBackup(domain string) (*job.Job, error) {
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("Invalid domain: %s", domain)
+	}
+	res, err := ac.Req(&request.Options{
+		Method: "POST",
+		Path:   "/instances/" + url.PathEscape(domain) + "/backups",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var j job.Job
+	if err = json.NewDecoder(res.Body).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// SetBackupKey sets the public key, encoded in base64, used to encrypt the
+// snapshots of an instance. An empty key goes back to the key of the operator.
+func (ac *AdminClient) SetBackupKey(domain, publicKey string) error {
+	if !validDomain(domain) {
+		return fmt.Errorf("Invalid domain: %s", domain)
+	}
+	body, err := json.Marshal(map[string]string{"public_key": publicKey})
+	if err != nil {
+		return err
+	}
+	_, err = ac.Req(&request.Options{
+		Method:     "PUT",
+		Path:       "/instances/" + url.PathEscape(domain) + "/backups/key",
+		Headers:    request.Headers{"Content-Type": "application/json"},
+		Body:       bytes.NewReader(body),
+		NoResponse: true,
+	})
+	return err
+}
+
+// RestoreBackup launches a job for restoring a snapshot of an instance, with
+// the key for decrypting it.
+func (ac *AdminClient) RestoreBackup(domain, snapshotID, decryptorKey string) (*job.Job, error) {
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("Invalid domain: %s", domain)
+	}
+	body, err := json.Marshal(map[string]string{"decryptor_key": decryptorKey})
+	if err != nil {
+		return nil, err
+	}
+	res, err := ac.Req(&request.Options{
+		Method:  "POST",
+		Path:    "/instances/" + url.PathEscape(domain) + "/backups/" + url.PathEscape(snapshotID) + "/restore",
+		Headers: request.Headers{"Content-Type": "application/json"},
+		Body:    bytes.NewReader(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var j job.Job
+	if err = json.NewDecoder(res.Body).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ImportTakeout starts the import of archives exported from another cloud,
+// like Google Takeout. The paths are the paths of the archives on the server.
+func (ac *AdminClient) ImportTakeout(domain string, paths []string, targetDir string) (*takeout.Migration, error) {
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("Invalid domain: %s", domain)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"paths":      paths,
+		"target_dir": targetDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := ac.Req(&request.Options{
+		Method:  "POST",
+		Path:    "/instances/" + url.PathEscape(domain) + "/takeout",
+		Headers: request.Headers{"Content-Type": "application/json"},
+		Body:    bytes.NewReader(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var doc takeout.Migration
+	if err = json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
 // RebuildRedis puts the triggers in redis.
 func (ac *AdminClient) RebuildRedis() error {
 	_, err := ac.Req(&request.Options{
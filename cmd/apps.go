@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/cozy/cozy-stack/client"
@@ -31,6 +32,16 @@ var (
 	flagKonnectorsDisallowManualExec bool
 )
 
+var (
+	flagRolloutContext    string
+	flagRolloutPercentage int
+	flagRolloutPrevious   string
+	flagRolloutCanaries   []string
+	flagRolloutWindows    []string
+	flagRolloutPaused     bool
+	flagRolloutReason     string
+)
+
 var webappsCmdGroup = &cobra.Command{
 	Use:   "apps <command>",
 	Short: "Interact with the applications",
@@ -342,6 +353,201 @@ var deactivateMaintenanceKonnectorsCmd = &cobra.Command{
 	},
 }
 
+var listRolloutsCmd = &cobra.Command{
+	Use:   "ls-rollouts",
+	Short: `List the staged rollouts of the applications`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		ac := newAdminClient()
+		list, err := ac.ListRollouts()
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			json, err := json.MarshalIndent(item, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(json))
+		}
+		return nil
+	},
+}
+
+var rolloutAppCmd = &cobra.Command{
+	Use:   "rollout [slug] [version]",
+	Short: `Stage the rollout of a version of a webapp or konnector`,
+	Long: `
+cozy-stack apps rollout creates or replaces the rollout policy of a webapp or
+konnector for a context (or for all the contexts without their own policy).
+The instances are updated from the registry to the given version only if they
+are in the canary domains or in the percentage of the instances, and during
+the time windows (in UTC). The other instances can only get the previous
+version, given with --previous (by default, the version of the replaced
+rollout).
+
+The rollout is paused automatically when the client side of this version logs
+too many errors, or when its services fail too often.
+`,
+	Example: `$ cozy-stack apps rollout drive 1.2.3 --percentage 10 --canary alice.cozy.example --window mon,tue,wed,thu@08:00-17:00`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		windows := make([]app.RolloutWindow, len(flagRolloutWindows))
+		for i, w := range flagRolloutWindows {
+			windows[i], err = parseRolloutWindow(w)
+			if err != nil {
+				return err
+			}
+		}
+		state := app.RolloutRunning
+		if flagRolloutPaused {
+			state = app.RolloutPaused
+		}
+		attrs := map[string]interface{}{
+			"version":          args[1],
+			"previous_version": flagRolloutPrevious,
+			"percentage":       flagRolloutPercentage,
+			"canary_domains":   flagRolloutCanaries,
+			"windows":          windows,
+			"state":            state,
+		}
+		ac := newAdminClient()
+		rollout, err := ac.PutRollout(args[0], flagRolloutContext, attrs)
+		if err != nil {
+			return err
+		}
+		json, err := json.MarshalIndent(rollout, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(json))
+		return nil
+	},
+}
+
+var pauseRolloutCmd = &cobra.Command{
+	Use:   "pause-rollout [slug]",
+	Short: `Pause the rollout of a webapp or konnector`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return changeRollout(cmd, args, "pause")
+	},
+}
+
+var resumeRolloutCmd = &cobra.Command{
+	Use:   "resume-rollout [slug]",
+	Short: `Resume the rollout of a webapp or konnector`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return changeRollout(cmd, args, "resume")
+	},
+}
+
+var rmRolloutCmd = &cobra.Command{
+	Use:   "rm-rollout [slug]",
+	Short: `Remove the rollout of a webapp or konnector`,
+	Long: `
+cozy-stack apps rm-rollout removes the rollout policy of a webapp or konnector
+for a context. The instances are then updated as soon as a new version is
+available.
+`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 1 {
+			return cmd.Help()
+		}
+		ac := newAdminClient()
+		return ac.DeleteRollout(args[0], flagRolloutContext)
+	},
+}
+
+var rollbackWebappCmd = &cobra.Command{
+	Use:   "rollback [slug]",
+	Short: `Roll back a webapp to its previous version`,
+	Long: `
+cozy-stack apps rollback reinstalls the previous version of a webapp, kept
+with the files of the applications.
+
+With the --context flag, the rollout of this context is rolled back: all the
+instances of the context updated to the version of the rollout go back to
+their previous version the next time the webapp is used. Else, only the webapp
+of the instance given by --domain is rolled back, immediately.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Apps)
+	},
+}
+
+var rollbackKonnectorCmd = &cobra.Command{
+	Use:   "rollback [slug]",
+	Short: `Roll back a konnector to its previous version`,
+	Long: `
+cozy-stack konnectors rollback reinstalls the previous version of a konnector,
+kept with the files of the applications.
+
+With the --context flag, the rollout of this context is rolled back: all the
+instances of the context updated to the version of the rollout go back to
+their previous version the next time the konnector is used. Else, only the
+konnector of the instance given by --domain is rolled back, immediately.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Konnectors)
+	},
+}
+
+func changeRollout(cmd *cobra.Command, args []string, action string) error {
+	if len(args) != 1 {
+		return cmd.Help()
+	}
+	ac := newAdminClient()
+	rollout, err := ac.ChangeRollout(args[0], flagRolloutContext, action, flagRolloutReason)
+	if err != nil {
+		return err
+	}
+	json, err := json.MarshalIndent(rollout, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(json))
+	return nil
+}
+
+func rollbackApp(cmd *cobra.Command, args []string, appType string) error {
+	if len(args) != 1 {
+		return cmd.Help()
+	}
+	if flagRolloutContext != "" {
+		return changeRollout(cmd, args, "rollback")
+	}
+	if flagDomain == "" {
+		errPrintfln("%s", errMissingDomain)
+		return cmd.Usage()
+	}
+	ac := newAdminClient()
+	man, err := ac.RollbackApp(flagDomain, appType, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s rolled back to %s\n", man.Attrs.Slug, man.Attrs.Version)
+	return nil
+}
+
+// parseRolloutWindow parses a time window like "mon,tue@09:00-17:00" or
+// "22:00-06:00".
+func parseRolloutWindow(arg string) (app.RolloutWindow, error) {
+	var w app.RolloutWindow
+	hours := arg
+	if days, rest, ok := strings.Cut(arg, "@"); ok {
+		w.Days = strings.Split(days, ",")
+		hours = rest
+	}
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return w, fmt.Errorf("Invalid window %q: expected [days@]HH:MM-HH:MM", arg)
+	}
+	w.Start = start
+	w.End = end
+	return w, nil
+}
+
 func installApp(cmd *cobra.Command, args []string, appType string) error {
 	if len(args) < 1 {
 		return cmd.Usage()
@@ -669,6 +875,16 @@ func init() {
 	activateMaintenanceKonnectorsCmd.PersistentFlags().BoolVar(&flagKonnectorsShortMaintenance, "short", false, "specify a short maintenance")
 	activateMaintenanceKonnectorsCmd.PersistentFlags().BoolVar(&flagKonnectorsDisallowManualExec, "no-manual-exec", false, "specify a maintenance disallowing manual execution")
 
+	for _, c := range []*cobra.Command{rolloutAppCmd, pauseRolloutCmd, resumeRolloutCmd, rmRolloutCmd, rollbackWebappCmd, rollbackKonnectorCmd} {
+		c.Flags().StringVar(&flagRolloutContext, "context", "", "specify the context of the rollout (default for all the contexts without their own rollout)")
+	}
+	rolloutAppCmd.Flags().IntVar(&flagRolloutPercentage, "percentage", 0, "percentage of the instances to update, in addition to the canary domains")
+	rolloutAppCmd.Flags().StringVar(&flagRolloutPrevious, "previous", "", "version for the instances outside of the rollout")
+	rolloutAppCmd.Flags().StringSliceVar(&flagRolloutCanaries, "canary", nil, "domains of the instances to update first (comma separated list)")
+	rolloutAppCmd.Flags().StringArrayVar(&flagRolloutWindows, "window", nil, "time window in UTC when the instances can be updated, like mon,tue@09:00-17:00 (can be repeated)")
+	rolloutAppCmd.Flags().BoolVar(&flagRolloutPaused, "paused", false, "create the rollout in the paused state")
+	pauseRolloutCmd.Flags().StringVar(&flagRolloutReason, "reason", "", "explain why the rollout is paused")
+
 	triggersCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 	triggersCmdGroup.AddCommand(launchTriggerCmd)
 	triggersCmdGroup.AddCommand(listTriggerCmd)
@@ -679,6 +895,12 @@ func init() {
 	webappsCmdGroup.AddCommand(installWebappCmd)
 	webappsCmdGroup.AddCommand(updateWebappCmd)
 	webappsCmdGroup.AddCommand(uninstallWebappCmd)
+	webappsCmdGroup.AddCommand(rollbackWebappCmd)
+	webappsCmdGroup.AddCommand(listRolloutsCmd)
+	webappsCmdGroup.AddCommand(rolloutAppCmd)
+	webappsCmdGroup.AddCommand(pauseRolloutCmd)
+	webappsCmdGroup.AddCommand(resumeRolloutCmd)
+	webappsCmdGroup.AddCommand(rmRolloutCmd)
 
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagKonnectorsParameters, "parameters", "", "override the parameters of the installed konnector")
@@ -693,6 +915,7 @@ func init() {
 	konnectorsCmdGroup.AddCommand(listMaintenancesCmd)
 	konnectorsCmdGroup.AddCommand(activateMaintenanceKonnectorsCmd)
 	konnectorsCmdGroup.AddCommand(deactivateMaintenanceKonnectorsCmd)
+	konnectorsCmdGroup.AddCommand(rollbackKonnectorCmd)
 
 	RootCmd.AddCommand(triggersCmdGroup)
 	RootCmd.AddCommand(webappsCmdGroup)
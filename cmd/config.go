@@ -11,6 +11,7 @@ import (
 
 	"github.com/cozy/cozy-stack/client/request"
 	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/notification/webpush"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/keyring"
@@ -150,6 +151,29 @@ keyfiles written in:
 	},
 }
 
+var genVAPIDKeysCmd = &cobra.Command{
+	Use:   "gen-vapid-keys",
+	Short: "Generate a key pair for the Web Push notifications",
+	Long: `
+cozy-stack config gen-vapid-keys generates a key pair for the VAPID
+authentication of the Web Push notifications.
+
+The private key is for the notifications.vapid_private_key parameter of the
+configuration file. The public key is given to the browsers by the stack.`,
+	Example: `$ cozy-stack config gen-vapid-keys
+private key: 3b8bG0xHb8ZpE7h0pK2YzNfVgJbqRz3hq1V9ZrJYc1E
+public key: BEl62iUYgUivxIkv69yViEuiBIa-Ib9-SkvMeAtA3LFgDzkrxZJjSgSnfckjBJuBkr3qBUYIHBQFLXYp5Nksh8U
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		private, public, err := webpush.GenerateVAPIDKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "private key: %s\npublic key: %s\n", private, public)
+		return nil
+	},
+}
+
 var encryptCredentialsDataCmd = &cobra.Command{
 	Use:   "encrypt-data <encoding keyfile> <text>",
 	Short: "Encrypt data with the specified encryption keyfile.",
@@ -406,6 +430,7 @@ var listContextsCmd = &cobra.Command{
 func init() {
 	configCmdGroup.AddCommand(adminPasswdCmd)
 	configCmdGroup.AddCommand(genKeysCmd)
+	configCmdGroup.AddCommand(genVAPIDKeysCmd)
 	configCmdGroup.AddCommand(encryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(decryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(encryptCredentialsCmd)
@@ -26,6 +26,7 @@ var (
 	dryRunFlag       bool
 	forceFlag        bool
 	withMetadataFlag bool
+	withHashesFlag   bool
 )
 
 var fixerCmdGroup = &cobra.Command{
@@ -144,8 +145,44 @@ var thumbnailsFixer = &cobra.Command{
 			Worker: "thumbnailck",
 			Arguments: struct {
 				WithMetadata bool `json:"with_metadata"`
+				WithHashes   bool `json:"with_hashes"`
 			}{
 				WithMetadata: withMetadataFlag,
+				WithHashes:   withHashesFlag,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	},
+}
+
+var metadataFixer = &cobra.Command{
+	Use:   "metadata <domain>",
+	Short: "Extract the metadata of the files examined by older extractors",
+	Long: `
+The metadata of the files (pages of the PDFs, duration of the videos, places of
+the photos, etc.) are extracted on upload. This command pushes a job to extract
+them for the files uploaded before their extractor was available or improved.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		c := newClient(domain, "io.cozy.jobs")
+		res, err := c.JobPush(&client.JobOptions{
+			Worker: "metadata",
+			Arguments: struct {
+				Force bool `json:"force"`
+			}{
+				Force: forceFlag,
 			},
 		})
 		if err != nil {
@@ -430,12 +467,15 @@ this instance are correctly set.
 func init() {
 	thumbnailsFixer.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Dry run")
 	thumbnailsFixer.Flags().BoolVar(&withMetadataFlag, "with-metadata", false, "Recalculate images metadata")
+	thumbnailsFixer.Flags().BoolVar(&withHashesFlag, "with-hashes", false, "Compute the missing perceptual hashes, used to find the duplicate photos")
 	redisFixer.Flags().BoolVar(&forceFlag, "force", false, "Do not ask for confirmation before fixing redis on all instances")
+	metadataFixer.Flags().BoolVar(&forceFlag, "force", false, "Extract again the metadata of all the files")
 
 	fixerCmdGroup.AddCommand(jobsFixer)
 	fixerCmdGroup.AddCommand(mimeFixerCmd)
 	fixerCmdGroup.AddCommand(redisFixer)
 	fixerCmdGroup.AddCommand(thumbnailsFixer)
+	fixerCmdGroup.AddCommand(metadataFixer)
 	fixerCmdGroup.AddCommand(contactEmailsFixer)
 	fixerCmdGroup.AddCommand(passwordDefinedFixer)
 	fixerCmdGroup.AddCommand(orphanAccountFixer)
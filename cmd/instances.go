@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"text/tabwriter"
@@ -21,6 +23,7 @@ import (
 	build "github.com/cozy/cozy-stack/pkg/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/keyring"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
@@ -65,6 +68,8 @@ var flagOnboardingApp string
 var flagOnboardingPermissions string
 var flagOnboardingState string
 var flagPath string
+var flagKeyFile string
+var flagTargetDir string
 
 // instanceCmdGroup represents the instances command
 var instanceCmdGroup = &cobra.Command{
@@ -920,6 +925,167 @@ var importCmd = &cobra.Command{
 	},
 }
 
+var backupInstanceCmd = &cobra.Command{
+	Use:   "backup <domain>",
+	Short: "Take a snapshot of an instance",
+	Long: `
+cozy-stack instances backup takes an encrypted snapshot of an instance. The
+snapshot is incremental if a previous snapshot can be used as a base, else it
+is a full snapshot.
+`,
+	Example: "$ cozy-stack instances backup cozy.localhost:8080",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return cmd.Usage()
+		}
+		ac := newAdminClient()
+		j, err := ac.CreateBackup(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "The backup has been queued (job %s)\n", j.ID())
+		return nil
+	},
+}
+
+var backupsInstanceCmd = &cobra.Command{
+	Use:     "backups <domain>",
+	Short:   "List the snapshots of an instance",
+	Example: "$ cozy-stack instances backups cozy.localhost:8080",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return cmd.Usage()
+		}
+		ac := newAdminClient()
+		snapshots, err := ac.ListBackups(args[0])
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "\t")
+			return encoder.Encode(snapshots)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, s := range snapshots {
+			kind := "incremental"
+			if s.IsFull() {
+				kind = "full"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d docs\t%d deletions\t%d contents\t%d bytes\n",
+				s.ID(), s.CreatedAt.Format(time.RFC3339), kind, s.State,
+				s.Docs, s.Deletions, s.Blobs, s.Size)
+		}
+		return w.Flush()
+	},
+}
+
+var restoreInstanceCmd = &cobra.Command{
+	Use:   "restore <domain> <snapshot-id>",
+	Short: "Restore an instance to a snapshot",
+	Long: `
+cozy-stack instances restore resets the instance and restores it to the state
+it had when the given snapshot was taken. The --key flag is the path to the
+file of the key for decrypting the snapshots.
+`,
+	Example: "$ cozy-stack instances restore cozy.localhost:8080 1b8f2a9e --key backup.dec",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return cmd.Usage()
+		}
+		domain, snapshotID := args[0], args[1]
+		if flagKeyFile == "" {
+			return errors.New("The key for decrypting the snapshots is missing")
+		}
+		key, err := os.ReadFile(flagKeyFile)
+		if err != nil {
+			return err
+		}
+
+		if !flagForce {
+			if err := confirmDomain("reset", domain); err != nil {
+				return err
+			}
+		}
+
+		ac := newAdminClient()
+		j, err := ac.RestoreBackup(domain, snapshotID, string(key))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "The restore has been queued (job %s)\n", j.ID())
+		return nil
+	},
+}
+
+var setBackupKeyInstanceCmd = &cobra.Command{
+	Use:   "set-backup-key <domain> [encryptor-key-file]",
+	Short: "Set the key used to encrypt the snapshots of an instance",
+	Long: `
+cozy-stack instances set-backup-key sets the public key used to encrypt the
+snapshots of an instance, for a key held by the user. Only the public part of
+the encryptor key file is sent to the stack. Without a key file, the snapshots
+are encrypted again with the key of the operator.
+
+The next snapshot will be a full snapshot.
+`,
+	Example: "$ cozy-stack instances set-backup-key cozy.localhost:8080 user-backup.enc",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return cmd.Usage()
+		}
+		var publicKey string
+		if len(args) > 1 {
+			encoded, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			key, err := keyring.UnmarshalNACLKey(encoded)
+			if err != nil {
+				return err
+			}
+			publicKey = base64.StdEncoding.EncodeToString(key.PublicKey()[:])
+		}
+		ac := newAdminClient()
+		return ac.SetBackupKey(args[0], publicKey)
+	},
+}
+
+var importTakeoutInstanceCmd = &cobra.Command{
+	Use:   "import-takeout <domain> <archive>...",
+	Short: "Import archives exported from another cloud, like Google Takeout",
+	Long: `
+cozy-stack instances import-takeout imports zip or tar.gz archives exported from
+another cloud, like Google Takeout. The files go in the target directory, the
+photos keep the dates and locations of their JSON sidecars, the vCards become
+contacts and the iCalendar files become calendars.
+
+The archives must be readable by the stack, on the server. When an export is
+split in several parts, all the parts should be given in the same command.
+`,
+	Example: "$ cozy-stack instances import-takeout cozy.localhost:8080 takeout-001.zip takeout-002.zip",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return cmd.Usage()
+		}
+		paths := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			p, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+			paths[i] = p
+		}
+		ac := newAdminClient()
+		doc, err := ac.ImportTakeout(args[0], paths, flagTargetDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "The import has been queued (migration %s)\n", doc.ID())
+		return nil
+	},
+}
+
 var showSwiftPrefixInstanceCmd = &cobra.Command{
 	Use:     "show-swift-prefix <domain>",
 	Short:   "Show the instance swift prefix of the specified domain",
@@ -1080,6 +1246,11 @@ func init() {
 	instanceCmdGroup.AddCommand(findOauthClientCmd)
 	instanceCmdGroup.AddCommand(exportCmd)
 	instanceCmdGroup.AddCommand(importCmd)
+	instanceCmdGroup.AddCommand(backupInstanceCmd)
+	instanceCmdGroup.AddCommand(backupsInstanceCmd)
+	instanceCmdGroup.AddCommand(restoreInstanceCmd)
+	instanceCmdGroup.AddCommand(setBackupKeyInstanceCmd)
+	instanceCmdGroup.AddCommand(importTakeoutInstanceCmd)
 	instanceCmdGroup.AddCommand(showSwiftPrefixInstanceCmd)
 	instanceCmdGroup.AddCommand(instanceAppVersionCmd)
 	instanceCmdGroup.AddCommand(updateInstancePassphraseCmd)
@@ -1150,6 +1321,10 @@ func init() {
 	exportCmd.Flags().StringVar(&flagPath, "path", "", "Specify the local path where to store the export archive")
 	importCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
 	importCmd.Flags().BoolVar(&flagForce, "force", false, "Force the import without asking for confirmation")
+	backupsInstanceCmd.Flags().BoolVar(&flagJSON, "json", false, "Show the snapshots in JSON format")
+	restoreInstanceCmd.Flags().StringVar(&flagKeyFile, "key", "", "Path to the file of the key for decrypting the snapshots")
+	restoreInstanceCmd.Flags().BoolVar(&flagForce, "force", false, "Force the restore without asking for confirmation")
+	importTakeoutInstanceCmd.Flags().StringVar(&flagTargetDir, "target-dir", "", "Directory of the instance where the files are imported (default: /Takeout)")
 	_ = exportCmd.MarkFlagRequired("domain")
 	_ = importCmd.MarkFlagRequired("domain")
 	RootCmd.AddCommand(instanceCmdGroup)
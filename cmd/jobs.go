@@ -21,6 +21,7 @@ var flagJobPrintLogs bool
 var flagJobPrintLogsVerbose bool
 var flagJobWorkers []string
 var flagJobsPurgeDuration string
+var flagJobsDeadLetterWorker string
 
 var jobsCmdGroup = &cobra.Command{
 	Use:   "jobs <command>",
@@ -114,6 +115,86 @@ var jobsPurgeCmd = &cobra.Command{
 	},
 }
 
+var jobsDeadLetterCmdGroup = &cobra.Command{
+	Use:   "dead-letter <command>",
+	Short: "Manage the jobs that have failed permanently",
+	Long: `
+When a job has failed after all its retries, it is kept in the dead-letter
+store of the instance, with its last error. These commands can be used to
+inspect those jobs, push them again, or forget them.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var jobsDeadLetterListCmd = &cobra.Command{
+	Use:     "list <domain>",
+	Aliases: []string{"ls"},
+	Short:   "List the jobs in the dead-letter store of an instance",
+	Example: `$ cozy-stack jobs dead-letter list example.mycozy.cloud --worker sendmail`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		return deadLetterRequest("GET", "/instances/"+url.PathEscape(args[0])+"/jobs/dead-letters")
+	},
+}
+
+var jobsDeadLetterRetryCmd = &cobra.Command{
+	Use:     "retry <domain> <id>",
+	Short:   "Push again a job from the dead-letter store",
+	Example: `$ cozy-stack jobs dead-letter retry example.mycozy.cloud 8b9b3fa0c2e04bbf4fe8a5d9e1c7f3a2`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		path := "/instances/" + url.PathEscape(args[0]) +
+			"/jobs/dead-letters/" + url.PathEscape(args[1]) + "/retry"
+		return deadLetterRequest("POST", path)
+	},
+}
+
+var jobsDeadLetterPurgeCmd = &cobra.Command{
+	Use:     "purge <domain>",
+	Short:   "Delete the jobs in the dead-letter store of an instance",
+	Example: `$ cozy-stack jobs dead-letter purge example.mycozy.cloud`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		return deadLetterRequest("DELETE", "/instances/"+url.PathEscape(args[0])+"/jobs/dead-letters")
+	},
+}
+
+func deadLetterRequest(method, path string) error {
+	var q url.Values
+	if flagJobsDeadLetterWorker != "" {
+		q = url.Values{"worker": {flagJobsDeadLetterWorker}}
+	}
+	ac := newAdminClient()
+	res, err := ac.Req(&request.Options{
+		Method:  method,
+		Path:    path,
+		Queries: q,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var v interface{}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
 func init() {
 	jobsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 
@@ -124,7 +205,14 @@ func init() {
 	jobsPurgeCmd.Flags().StringSliceVar(&flagJobWorkers, "workers", nil, "worker types to iterate over (all workers by default)")
 	jobsPurgeCmd.Flags().StringVar(&flagJobsPurgeDuration, "duration", "", "duration to look for (ie. 3D, 2M)")
 
+	jobsDeadLetterListCmd.Flags().StringVar(&flagJobsDeadLetterWorker, "worker", "", "filter on a worker type")
+	jobsDeadLetterPurgeCmd.Flags().StringVar(&flagJobsDeadLetterWorker, "worker", "", "filter on a worker type")
+	jobsDeadLetterCmdGroup.AddCommand(jobsDeadLetterListCmd)
+	jobsDeadLetterCmdGroup.AddCommand(jobsDeadLetterRetryCmd)
+	jobsDeadLetterCmdGroup.AddCommand(jobsDeadLetterPurgeCmd)
+
 	jobsCmdGroup.AddCommand(jobsRunCmd)
 	jobsCmdGroup.AddCommand(jobsPurgeCmd)
+	jobsCmdGroup.AddCommand(jobsDeadLetterCmdGroup)
 	RootCmd.AddCommand(jobsCmdGroup)
 }
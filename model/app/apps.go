@@ -96,6 +96,14 @@ type Manifest interface {
 	SetVersion(version string)
 	SetAvailableVersion(version string)
 	SetChecksum(shasum string)
+
+	Integrity() string
+	SetIntegrity(hash string)
+
+	PreviousVersion() *PreviousVersion
+	SetPreviousVersion(previous *PreviousVersion)
+	RolledBackVersion() string
+	SetRolledBackVersion(version string)
 }
 
 // GetBySlug returns an app manifest identified by its slug
@@ -1,8 +1,15 @@
 package app
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/stretchr/testify/assert"
 )
@@ -135,3 +142,253 @@ func Test_GetBySlug(t *testing.T) {
 		assert.ErrorIs(t, err, ErrInvalidAppType)
 	})
 }
+
+func TestValidCSPSource(t *testing.T) {
+	for _, src := range []string{
+		"https://api.example.com",
+		"https://*.tile.example.org/",
+		"wss://realtime.example.com:8443/socket",
+		"example.com",
+		"https://cdn.example.com/lib/app.js",
+	} {
+		assert.True(t, ValidCSPSource(src), src)
+	}
+	for _, src := range []string{
+		"",
+		"*",
+		"https:",
+		"'unsafe-eval'",
+		"'self'",
+		"https://example.com; script-src *",
+		"https://example.com,https://other.com",
+		"https://example.com 'unsafe-inline'",
+	} {
+		assert.False(t, ValidCSPSource(src), src)
+	}
+}
+
+func TestCSPPolicy(t *testing.T) {
+	config.UseTestFile(t)
+	manifest := &WebappManifest{}
+	manifest.val.Slug = "maps"
+	manifest.val.CSP = CSPSources{
+		"img":     {"https://tiles.example.org", "'unsafe-inline'"},
+		"connect": {"https://api.example.org"},
+		"form":    {"https://evil.example.com"},
+	}
+
+	policy := manifest.CSPPolicy()
+	assert.False(t, policy.ReportOnly)
+	assert.Equal(t, map[string][]string{
+		"img-src":     {"https://tiles.example.org"},
+		"connect-src": {"https://api.example.org"},
+	}, policy.Sources)
+
+	config.GetConfig().CSPApps = map[string]config.CSPApp{
+		"maps": {
+			Sources:    map[string]string{"img": "https://tiles.example.org https://other.example.org"},
+			ReportOnly: true,
+		},
+	}
+	policy = manifest.CSPPolicy()
+	assert.True(t, policy.ReportOnly)
+	assert.Equal(t, map[string][]string{
+		"img-src":     {"https://tiles.example.org", "https://other.example.org"},
+		"connect-src": {"https://api.example.org"},
+	}, policy.Sources)
+
+	config.GetConfig().CSPApps["maps"] = config.CSPApp{IgnoreManifest: true}
+	policy = manifest.CSPPolicy()
+	assert.Empty(t, policy.Sources)
+}
+
+func TestBlockedOrigin(t *testing.T) {
+	assert.Equal(t, "https://cdn.example.com", blockedOrigin("https://cdn.example.com/lib/app.js?v=2"))
+	assert.Equal(t, "inline", blockedOrigin("inline"))
+	assert.Equal(t, "data", blockedOrigin("data"))
+}
+
+func TestTrustedKeys(t *testing.T) {
+	config.UseTestFile(t)
+	pub1, _, _ := ed25519.GenerateKey(nil)
+	pub2, _, _ := ed25519.GenerateKey(nil)
+	pub3, _, _ := ed25519.GenerateKey(nil)
+	config.GetConfig().AppsSignatures = []config.AppsSignatureKeys{
+		{Keys: []string{base64.StdEncoding.EncodeToString(pub1)}},
+		{Context: "beta", Keys: []string{base64.StdEncoding.EncodeToString(pub2)}, Required: true},
+		{Registry: "https://registry.example.org/", Keys: []string{base64.StdEncoding.EncodeToString(pub3)}},
+	}
+
+	keys, required := trustedKeys("default", nil)
+	assert.False(t, required)
+	assert.Equal(t, []ed25519.PublicKey{pub1}, keys)
+
+	keys, required = trustedKeys("beta", nil)
+	assert.True(t, required)
+	assert.Equal(t, []ed25519.PublicKey{pub1, pub2}, keys)
+
+	reg, _ := url.Parse("https://registry.example.org")
+	keys, required = trustedKeys("default", reg)
+	assert.False(t, required)
+	assert.Equal(t, []ed25519.PublicKey{pub1, pub3}, keys)
+
+	assert.NoError(t, checkUnsignedSource("default"))
+	assert.Equal(t, ErrUnsignedPackage, checkUnsignedSource("beta"))
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	other, _, _ := ed25519.GenerateKey(nil)
+	shasum := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, SignedMessage("drive", "1.2.3", shasum)))
+	keys := []ed25519.PublicKey{other, pub}
+
+	assert.NoError(t, verifySignature(keys, true, "drive", "1.2.3", shasum, sig))
+	assert.NoError(t, verifySignature(keys, true, "drive", "1.2.3", strings.ToUpper(shasum), sig))
+	assert.Equal(t, ErrBadSignature, verifySignature(keys, false, "drive", "1.2.4", shasum, sig))
+	assert.Equal(t, ErrBadSignature, verifySignature(keys, false, "photos", "1.2.3", shasum, sig))
+	assert.Equal(t, ErrBadSignature, verifySignature([]ed25519.PublicKey{other}, false, "drive", "1.2.3", shasum, sig))
+	assert.Equal(t, ErrBadSignature, verifySignature(keys, false, "drive", "1.2.3", shasum, "not base64"))
+	assert.Equal(t, ErrUnsignedPackage, verifySignature(keys, true, "drive", "1.2.3", shasum, ""))
+	assert.NoError(t, verifySignature(keys, false, "drive", "1.2.3", shasum, ""))
+	assert.NoError(t, verifySignature(nil, false, "drive", "1.2.3", shasum, sig))
+}
+
+func TestRolloutAllows(t *testing.T) {
+	r := &Rollout{
+		Slug:          "drive",
+		Version:       "1.2.3",
+		Percentage:    0,
+		CanaryDomains: []string{"alice.cozy.example"},
+		State:         RolloutRunning,
+	}
+	now := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC) // a monday
+
+	t.Run("canary domains", func(t *testing.T) {
+		assert.True(t, r.Allows("alice.cozy.example", now))
+		assert.False(t, r.Allows("bob.cozy.example", now))
+	})
+
+	t.Run("percentage", func(t *testing.T) {
+		domains := make([]string, 1000)
+		for i := range domains {
+			domains[i] = fmt.Sprintf("user%d.cozy.example", i)
+		}
+		allowed := func(pct int) map[string]bool {
+			rr := *r
+			rr.Percentage = pct
+			set := map[string]bool{}
+			for _, d := range domains {
+				if rr.Allows(d, now) {
+					set[d] = true
+				}
+			}
+			return set
+		}
+		none, some, all := allowed(0), allowed(20), allowed(100)
+		assert.Len(t, none, 0)
+		assert.Len(t, all, len(domains))
+		assert.InDelta(t, 200, len(some), 60)
+		// Increasing the percentage only adds instances
+		for d := range allowed(10) {
+			assert.True(t, some[d])
+		}
+	})
+
+	t.Run("state", func(t *testing.T) {
+		rr := *r
+		rr.State = RolloutPaused
+		assert.False(t, rr.Allows("alice.cozy.example", now))
+		rr.State = RolloutRolledBack
+		assert.False(t, rr.Allows("alice.cozy.example", now))
+	})
+
+	t.Run("windows", func(t *testing.T) {
+		rr := *r
+		rr.Windows = []RolloutWindow{{Days: []string{"mon", "tue"}, Start: "09:00", End: "17:00"}}
+		assert.True(t, rr.Allows("alice.cozy.example", now))
+		assert.False(t, rr.Allows("alice.cozy.example", now.Add(8*time.Hour)))
+		assert.False(t, rr.Allows("alice.cozy.example", now.Add(-2*time.Hour)))
+		assert.False(t, rr.Allows("alice.cozy.example", now.Add(48*time.Hour)))
+
+		rr.Windows = []RolloutWindow{{Days: []string{"sun"}, Start: "22:00", End: "06:00"}}
+		assert.True(t, rr.Allows("alice.cozy.example", now.Add(-6*time.Hour)))
+		assert.True(t, rr.Allows("alice.cozy.example", now.Add(-11*time.Hour)))
+		assert.False(t, rr.Allows("alice.cozy.example", now))
+		assert.False(t, rr.Allows("alice.cozy.example", now.Add(18*time.Hour)))
+	})
+}
+
+func TestRolloutValidate(t *testing.T) {
+	r := &Rollout{Slug: "drive", Version: "1.2.3", Percentage: 50, State: RolloutRunning}
+	assert.NoError(t, r.Validate())
+
+	bad := *r
+	bad.Percentage = 101
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+	bad = *r
+	bad.Version = ""
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+	bad = *r
+	bad.PreviousVersion = "1.2.3"
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+	bad = *r
+	bad.State = "unknown"
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+	bad = *r
+	bad.Windows = []RolloutWindow{{Start: "9h", End: "17:00"}}
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+	bad = *r
+	bad.Windows = []RolloutWindow{{Days: []string{"monday"}, Start: "09:00", End: "17:00"}}
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidRollout)
+}
+
+func TestUpdateAllowed(t *testing.T) {
+	rolloutsCache.Lock()
+	rolloutsCache.list = []*Rollout{
+		{Slug: "drive", Context: "default", Version: "1.2.3", Percentage: 100, State: RolloutRunning},
+		{Slug: "drive", Context: "beta", Version: "1.3.0", PreviousVersion: "1.2.3", Percentage: 100, State: RolloutPaused},
+		{Slug: "notes", Context: "default", Version: "2.0.0", Percentage: 0, State: RolloutRunning},
+	}
+	rolloutsCache.fetched = time.Now()
+	rolloutsCache.Unlock()
+	defer func() {
+		rolloutsCache.Lock()
+		rolloutsCache.list = nil
+		rolloutsCache.fetched = time.Time{}
+		rolloutsCache.Unlock()
+	}()
+
+	assert.True(t, updateAllowed("drive", "1.2.3", "", "alice.cozy.example"))
+	assert.True(t, updateAllowed("drive", "1.2.3", "other", "alice.cozy.example"))
+	assert.False(t, updateAllowed("drive", "1.2.4", "other", "alice.cozy.example"))
+	assert.False(t, updateAllowed("drive", "1.3.0", "beta", "alice.cozy.example"))
+	assert.True(t, updateAllowed("drive", "1.2.3", "beta", "alice.cozy.example"))
+	assert.True(t, updateAllowed("photos", "2.0.0", "beta", "alice.cozy.example"))
+
+	assert.Equal(t, "1.2.3", rolloutVersion("drive", "1.4.0", "", "alice.cozy.example"))
+	assert.Equal(t, "1.2.3", rolloutVersion("drive", "1.3.0", "beta", "alice.cozy.example"))
+	assert.Equal(t, "", rolloutVersion("notes", "2.0.0", "", "alice.cozy.example"))
+	assert.Equal(t, "2.0.0", rolloutVersion("photos", "2.0.0", "", "alice.cozy.example"))
+}
+
+func TestPreviousVersionOf(t *testing.T) {
+	oldManifest := &WebappManifest{}
+	oldManifest.val.Version = "1.0.0"
+	oldManifest.val.Checksum = "aaa"
+	oldManifest.val.Source = "registry://drive/stable"
+	oldManifest.val.Integrity = "sha384-xxx"
+	newManifest := &WebappManifest{}
+	newManifest.val.Version = "1.1.0"
+	newManifest.val.Checksum = "bbb"
+
+	prev := previousVersionOf(oldManifest, newManifest)
+	assert.Equal(t, &PreviousVersion{Version: "1.0.0", Checksum: "aaa", Source: "registry://drive/stable", Integrity: "sha384-xxx"}, prev)
+
+	// Updating to the same package keeps the previous version
+	oldManifest.val.PreviousVersion = &PreviousVersion{Version: "0.9.0"}
+	newManifest.val.Version = "1.0.0"
+	newManifest.val.Checksum = "aaa"
+	assert.Equal(t, "0.9.0", previousVersionOf(oldManifest, newManifest).Version)
+}
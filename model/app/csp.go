@@ -0,0 +1,281 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// maxCSPReportField is the maximal length of the fields of a CSP report,
+	// to keep the documents small.
+	maxCSPReportField = 1024
+	// cspReportRetention is the duration a CSP report is kept after the last
+	// violation.
+	cspReportRetention = 30 * 24 * time.Hour
+	// maxCSPReports is the maximal number of CSP reports kept for an
+	// instance.
+	maxCSPReports = 200
+)
+
+// CSPSources are the sources that a webapp can declare in its manifest for
+// its CSP policy. The keys are the same as for the csp_allowlist of the
+// config: default, script, frame, connect, font, img, media, style and
+// worker.
+type CSPSources map[string][]string
+
+var cspDirectives = map[string]string{
+	"default": "default-src",
+	"script":  "script-src",
+	"frame":   "frame-src",
+	"connect": "connect-src",
+	"font":    "font-src",
+	"img":     "img-src",
+	"media":   "media-src",
+	"style":   "style-src",
+	"worker":  "worker-src",
+}
+
+// knownCSPDirectives are the directives that can be reported by the
+// browsers. The reports for the other names are ignored.
+var knownCSPDirectives = map[string]bool{
+	"base-uri":                  true,
+	"block-all-mixed-content":   true,
+	"child-src":                 true,
+	"connect-src":               true,
+	"default-src":               true,
+	"fenced-frame-src":          true,
+	"font-src":                  true,
+	"form-action":               true,
+	"frame-ancestors":           true,
+	"frame-src":                 true,
+	"img-src":                   true,
+	"manifest-src":              true,
+	"media-src":                 true,
+	"object-src":                true,
+	"prefetch-src":              true,
+	"require-trusted-types-for": true,
+	"sandbox":                   true,
+	"script-src":                true,
+	"script-src-attr":           true,
+	"script-src-elem":           true,
+	"style-src":                 true,
+	"style-src-attr":            true,
+	"style-src-elem":            true,
+	"trusted-types":             true,
+	"upgrade-insecure-requests": true,
+	"worker-src":                true,
+}
+
+// IsCSPDirective returns true if the name is a known CSP directive.
+func IsCSPDirective(name string) bool {
+	return knownCSPDirectives[name]
+}
+
+// cspSourceRegexp matches a host, with an optional scheme, port and path. The
+// keywords like 'unsafe-eval', the schemes alone like https:, and the
+// characters that could inject a new directive are not allowed.
+var cspSourceRegexp = regexp.MustCompile(`^(?:[a-z][a-z0-9+.-]*://)?(?:\*\.)?[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*(?::(?:[0-9]+|\*))?(?:/[a-zA-Z0-9._~%!$&()*+=:@/-]*)?$`)
+
+// ValidCSPSource returns true if the source can be added to the CSP policy of
+// a webapp.
+func ValidCSPSource(src string) bool {
+	return cspSourceRegexp.MatchString(src)
+}
+
+// CSPPolicy is what is added to the CSP policy of the stack for a webapp.
+type CSPPolicy struct {
+	// Sources are the sources added by directive, like img-src.
+	Sources map[string][]string
+	// ReportOnly is true when the policy must not be enforced.
+	ReportOnly bool
+}
+
+func (p *CSPPolicy) add(key string, sources []string) {
+	directive, ok := cspDirectives[key]
+	if !ok {
+		return
+	}
+	for _, src := range sources {
+		if !ValidCSPSource(src) {
+			continue
+		}
+		found := false
+		for _, s := range p.Sources[directive] {
+			if s == src {
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.Sources[directive] = append(p.Sources[directive], src)
+		}
+	}
+}
+
+// CSPPolicy returns the additions to the CSP policy of the stack for this
+// webapp: the sources declared in its manifest, and the override of the
+// administrator in the csp_apps of the config. The invalid sources are
+// ignored.
+func (m *WebappManifest) CSPPolicy() *CSPPolicy {
+	override := config.GetConfig().CSPApps[m.Slug()]
+	policy := &CSPPolicy{
+		Sources:    make(map[string][]string),
+		ReportOnly: override.ReportOnly,
+	}
+	if !override.IgnoreManifest {
+		for key, sources := range m.val.CSP {
+			policy.add(key, sources)
+		}
+	}
+	for key, sources := range override.Sources {
+		policy.add(key, strings.Fields(sources))
+	}
+	return policy
+}
+
+// CSPReport is an io.cozy.apps.csp_reports document. The violations of the
+// same directive for the same blocked origin by a webapp are counted in a
+// single document.
+type CSPReport struct {
+	DocID       string    `json:"_id,omitempty"`
+	DocRev      string    `json:"_rev,omitempty"`
+	Slug        string    `json:"slug"`
+	Directive   string    `json:"directive"`
+	BlockedURI  string    `json:"blocked_uri"`
+	DocumentURI string    `json:"document_uri,omitempty"`
+	SourceFile  string    `json:"source_file,omitempty"`
+	LineNumber  int       `json:"line_number,omitempty"`
+	Disposition string    `json:"disposition,omitempty"`
+	Count       int       `json:"count"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// ID implements the couchdb.Doc interface
+func (r *CSPReport) ID() string { return r.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (r *CSPReport) Rev() string { return r.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (r *CSPReport) DocType() string { return consts.CSPReports }
+
+// SetID implements the couchdb.Doc interface
+func (r *CSPReport) SetID(id string) { r.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (r *CSPReport) SetRev(rev string) { r.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (r *CSPReport) Clone() couchdb.Doc {
+	cloned := *r
+	return &cloned
+}
+
+// blockedOrigin keeps only the origin of the blocked URI, to count the
+// violations for a whole domain. The values like inline or eval are kept.
+func blockedOrigin(blocked string) string {
+	u, err := url.Parse(blocked)
+	if err != nil || u.Host == "" {
+		return blocked
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func truncateCSPField(value string) string {
+	if len(value) > maxCSPReportField {
+		return value[:maxCSPReportField]
+	}
+	return value
+}
+
+// AddCSPReport saves a violation of the CSP policy of a webapp, reported by a
+// browser.
+func AddCSPReport(db prefixer.Prefixer, report *CSPReport) error {
+	report.Directive = truncateCSPField(report.Directive)
+	report.BlockedURI = truncateCSPField(blockedOrigin(report.BlockedURI))
+	report.DocumentURI = truncateCSPField(report.DocumentURI)
+	report.SourceFile = truncateCSPField(report.SourceFile)
+	report.Disposition = truncateCSPField(report.Disposition)
+	sum := sha256.Sum256([]byte(report.Slug + "\n" + report.Directive + "\n" + report.BlockedURI))
+	id := hex.EncodeToString(sum[:16])
+	now := time.Now().UTC()
+
+	existing := &CSPReport{}
+	err := couchdb.GetDoc(db, consts.CSPReports, id, existing)
+	if couchdb.IsNotFoundError(err) {
+		report.DocID = id
+		report.DocRev = ""
+		report.Count = 1
+		report.FirstSeenAt = now
+		report.LastSeenAt = now
+		if err := couchdb.CreateNamedDocWithDB(db, report); err != nil {
+			return err
+		}
+		return pruneCSPReports(db, now)
+	}
+	if err != nil {
+		return err
+	}
+	existing.Count++
+	existing.LastSeenAt = now
+	existing.DocumentURI = report.DocumentURI
+	existing.SourceFile = report.SourceFile
+	existing.LineNumber = report.LineNumber
+	existing.Disposition = report.Disposition
+	return couchdb.UpdateDoc(db, existing)
+}
+
+// pruneCSPReports deletes the old CSP reports: they are kept for
+// cspReportRetention after the last violation, and at most maxCSPReports for
+// an instance.
+func pruneCSPReports(db prefixer.Prefixer, now time.Time) error {
+	reports, err := ListCSPReports(db, "")
+	if err != nil {
+		return err
+	}
+	limit := now.Add(-cspReportRetention)
+	var expired []couchdb.Doc
+	for i, r := range reports {
+		if i >= maxCSPReports || r.LastSeenAt.Before(limit) {
+			expired = append(expired, r)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return couchdb.BulkDeleteDocs(db, consts.CSPReports, expired)
+}
+
+// ListCSPReports returns the violations of the CSP policies, the most recent
+// first. If slug is not empty, only the violations for this webapp are
+// returned.
+func ListCSPReports(db prefixer.Prefixer, slug string) ([]*CSPReport, error) {
+	var all []*CSPReport
+	err := couchdb.GetAllDocs(db, consts.CSPReports, nil, &all)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	reports := make([]*CSPReport, 0, len(all))
+	for _, r := range all {
+		if slug == "" || r.Slug == slug {
+			reports = append(reports, r)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].LastSeenAt.After(reports[j].LastSeenAt)
+	})
+	return reports, nil
+}
+
+var _ couchdb.Doc = (*CSPReport)(nil)
@@ -32,6 +32,23 @@ var (
 	// ErrBadChecksum is used when the application checksum does not match the
 	// specified one.
 	ErrBadChecksum = errors.New("Application checksum does not match")
+	// ErrUnsignedPackage is used when the package of an application has no
+	// signature, but a signature is required.
+	ErrUnsignedPackage = errors.New("Application package is not signed")
+	// ErrBadSignature is used when the signature of the package of an
+	// application cannot be verified with the pinned keys.
+	ErrBadSignature = errors.New("Application package signature is invalid")
+	// ErrNoPreviousVersion is used when rolling back an application that has
+	// not been updated since the rollbacks were introduced.
+	ErrNoPreviousVersion = errors.New("Application has no previous version to roll back to")
+	// ErrRolloutNotFound is used when there is no rollout policy for the
+	// application and the context.
+	ErrRolloutNotFound = errors.New("Rollout not found")
+	// ErrDeferredByRollout is used when an instance cannot install or update
+	// an application to a version yet, because of the rollout of this version.
+	ErrDeferredByRollout = errors.New("Application version is not yet available for this instance")
+	// ErrInvalidRollout is used when a rollout policy is not valid.
+	ErrInvalidRollout = errors.New("Rollout is invalid")
 	// ErrLinkedAppExists is used when an OAuth client is linked to this app
 	ErrLinkedAppExists = errors.New("A linked OAuth client exists for this app")
 )
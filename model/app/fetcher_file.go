@@ -13,6 +13,7 @@ import (
 
 type fileFetcher struct {
 	manFilename string
+	context     string
 	log         logger.Logger
 }
 
@@ -20,9 +21,10 @@ type fileFetcher struct {
 // application installed with this mode is appended with a random number so
 // that multiple version can be installed from the same directory without
 // having to increase the version number from the manifest.
-func newFileFetcher(manFilename string, log logger.Logger) *fileFetcher {
+func newFileFetcher(manFilename, context string, log logger.Logger) *fileFetcher {
 	return &fileFetcher{
 		manFilename: manFilename,
+		context:     context,
 		log:         log,
 	}
 }
@@ -39,6 +41,9 @@ func (f *fileFetcher) FetchManifest(src *url.URL) (io.ReadCloser, error) {
 }
 
 func (f *fileFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) (err error) {
+	if err = checkUnsignedSource(f.context); err != nil {
+		return err
+	}
 	version := man.Version() + "-" + utils.RandomString(10)
 	man.SetVersion(version)
 	exists, err := fs.Start(man.Slug(), man.Version(), "")
@@ -37,12 +37,14 @@ var (
 
 type gitFetcher struct {
 	manFilename string
+	context     string
 	log         logger.Logger
 }
 
-func newGitFetcher(manFilename string, log logger.Logger) *gitFetcher {
+func newGitFetcher(manFilename, context string, log logger.Logger) *gitFetcher {
 	return &gitFetcher{
 		manFilename: manFilename,
+		context:     context,
 		log:         log,
 	}
 }
@@ -158,6 +160,10 @@ func (g *gitFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) (err err
 		}
 	}()
 
+	if err = checkUnsignedSource(g.context); err != nil {
+		return err
+	}
+
 	osFs := afero.NewOsFs()
 	gitDir, err := afero.TempDir(osFs, "", "cozy-app-"+man.Slug())
 	if err != nil {
@@ -31,12 +31,14 @@ var httpClient = http.Client{
 type httpFetcher struct {
 	manFilename string
 	prefix      string
+	context     string
 	log         logger.Logger
 }
 
-func newHTTPFetcher(manFilename string, log logger.Logger) *httpFetcher {
+func newHTTPFetcher(manFilename, context string, log logger.Logger) *httpFetcher {
 	return &httpFetcher{
 		manFilename: manFilename,
+		context:     context,
 		log:         log,
 	}
 }
@@ -106,6 +108,20 @@ func (f *httpFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) (err er
 	if frag := src.Fragment; frag != "" {
 		shasum, _ = hex.DecodeString(frag)
 	}
+	// The signature is checked for the sha256 given in the fragment of the
+	// URL, as fetchHTTP ensures that the tarball has this checksum.
+	if keys, required := trustedKeys(f.context, nil); len(keys) > 0 || required {
+		var signature string
+		if len(shasum) > 0 {
+			if signature, err = fetchSignature(src); err != nil {
+				return err
+			}
+		}
+		err = verifySignature(keys, required, man.Slug(), man.Version(), hex.EncodeToString(shasum), signature)
+		if err != nil {
+			return err
+		}
+	}
 	return fetchHTTP(src, shasum, fs, man, f.prefix)
 }
 
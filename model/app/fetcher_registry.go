@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/url"
 	"regexp"
@@ -16,11 +17,16 @@ import (
 type registryFetcher struct {
 	log        logger.Logger
 	registries []*url.URL
+	context    string
+	domain     string
 	version    *registry.Version
+	// deferred is the last version of the channel when the instance cannot
+	// be updated to it yet, because of the rollout of the application.
+	deferred string
 }
 
-func newRegistryFetcher(registries []*url.URL, log logger.Logger) Fetcher {
-	return &registryFetcher{log: log, registries: registries}
+func newRegistryFetcher(registries []*url.URL, context, domain string, log logger.Logger) Fetcher {
+	return &registryFetcher{log: log, registries: registries, context: context, domain: domain}
 }
 
 func (f *registryFetcher) FetchManifest(src *url.URL) (io.ReadCloser, error) {
@@ -36,9 +42,12 @@ func (f *registryFetcher) FetchManifest(src *url.URL) (io.ReadCloser, error) {
 	if vnumber != "" {
 		version, err = registry.GetVersion(slug, vnumber, f.registries)
 	} else {
-		version, err = registry.GetLatestVersion(slug, channel, f.registries)
+		version, err = f.latestVersion(slug, channel)
 	}
 
+	if errors.Is(err, ErrDeferredByRollout) {
+		return nil, err
+	}
 	if err != nil {
 		f.log.Infof("Could not fetch manifest for %s: %s", src.String(), err.Error())
 		return nil, ErrManifestNotReachable
@@ -47,6 +56,25 @@ func (f *registryFetcher) FetchManifest(src *url.URL) (io.ReadCloser, error) {
 	return io.NopCloser(bytes.NewBuffer(version.Manifest)), nil
 }
 
+// latestVersion returns the last version of the channel that the instance
+// can install, following the rollout of the application.
+func (f *registryFetcher) latestVersion(slug, channel string) (*registry.Version, error) {
+	latest, err := registry.GetLatestVersion(slug, channel, f.registries)
+	if err != nil {
+		return nil, err
+	}
+	f.deferred = ""
+	number := rolloutVersion(slug, latest.Version, f.context, f.domain)
+	if number == latest.Version {
+		return latest, nil
+	}
+	f.deferred = latest.Version
+	if number == "" {
+		return nil, ErrDeferredByRollout
+	}
+	return registry.GetVersion(slug, number, f.registries)
+}
+
 func (f *registryFetcher) appVersion() string {
 	return f.version.Version
 }
@@ -61,6 +89,11 @@ func (f *registryFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) err
 	if err != nil {
 		return err
 	}
+	keys, required := trustedKeys(f.context, v.Registry)
+	if err = verifySignature(keys, required, man.Slug(), v.Version, v.Sha256, v.Signature); err != nil {
+		f.log.Errorf("Invalid signature for %s %s: %s", man.Slug(), v.Version, err)
+		return err
+	}
 	man.SetVersion(v.Version)
 	man.SetChecksum(v.Sha256)
 	return fetchHTTP(u, shasum, fs, man, v.TarPrefix)
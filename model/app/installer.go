@@ -48,6 +48,7 @@ type Installer struct {
 	fetcher  Fetcher
 	op       Operation
 	fs       appfs.Copier
+	files    appfs.FileServer
 	db       prefixer.Prefixer
 	endState State
 
@@ -146,23 +147,26 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 	})
 
 	var manFilename string
+	var files appfs.FileServer
 	switch man.AppType() {
 	case consts.WebappType:
 		manFilename = WebappManifestName
+		files = AppsFileServer(in)
 	case consts.KonnectorType:
 		manFilename = KonnectorManifestName
+		files = KonnectorsFileServer(in)
 	}
 
 	var fetcher Fetcher
 	switch src.Scheme {
 	case "git", "git+ssh", "ssh+git", "git+https":
-		fetcher = newGitFetcher(manFilename, log)
+		fetcher = newGitFetcher(manFilename, in.ContextName, log)
 	case "http", "https":
-		fetcher = newHTTPFetcher(manFilename, log)
+		fetcher = newHTTPFetcher(manFilename, in.ContextName, log)
 	case "registry":
-		fetcher = newRegistryFetcher(opts.Registries, log)
+		fetcher = newRegistryFetcher(opts.Registries, in.ContextName, in.Domain, log)
 	case "file":
-		fetcher = newFileFetcher(manFilename, log)
+		fetcher = newFileFetcher(manFilename, in.ContextName, log)
 	default:
 		return nil, ErrNotSupportedSource
 	}
@@ -172,6 +176,7 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 		op:       opts.Operation,
 		db:       in,
 		fs:       fs,
+		files:    files,
 		endState: endState,
 
 		overridenParameters: opts.OverridenParameters,
@@ -320,6 +325,9 @@ func (i *Installer) install() error {
 		i.log.Debugf("Could not fetch tarball")
 		return err
 	}
+	if err := i.recordIntegrity(); err != nil {
+		return err
+	}
 	i.man.SetState(i.endState)
 	return i.man.Create(i.db)
 }
@@ -382,6 +390,11 @@ func (i *Installer) update() error {
 
 	oldManifest := i.man
 	newManifest, err := i.ReadManifest(Upgrading)
+	if errors.Is(err, ErrDeferredByRollout) {
+		if r := findRollout(i.man.Slug(), i.context); r != nil && r.Version == oldManifest.Version() {
+			return nil
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -403,6 +416,21 @@ func (i *Installer) update() error {
 		makeUpdate = (newManifest.Version() != oldManifest.Version())
 	}
 
+	// The updates from the registries follow the staged rollout of the
+	// application, if there is one: the instances outside of the rollout
+	// stay on its previous version.
+	if fetcher, ok := i.fetcher.(*registryFetcher); ok {
+		if makeUpdate && !updateAllowed(newManifest.Slug(), newManifest.Version(), i.context, i.Domain()) {
+			return ErrDeferredByRollout
+		}
+		if fetcher.deferred != "" && !IsMoreRecent(oldManifest.Version(), newManifest.Version()) {
+			if fetcher.deferred != oldManifest.Version() {
+				return ErrDeferredByRollout
+			}
+			makeUpdate = false
+		}
+	}
+
 	// Check the possible permissions changes before updating. If the
 	// verifyPermissions flag is activated (for non manual updates for example),
 	// we cancel out the update and mark the UpdateAvailable field of the
@@ -504,6 +532,10 @@ func (i *Installer) update() error {
 		if err := i.fetcher.Fetch(i.src, i.fs, i.man); err != nil {
 			return err
 		}
+		if err := i.recordIntegrity(); err != nil {
+			return err
+		}
+		i.man.SetPreviousVersion(previousVersionOf(oldManifest, i.man))
 		i.man.SetAvailableVersion("")
 		i.man.SetState(i.endState)
 	} else {
@@ -521,6 +553,19 @@ func (i *Installer) update() error {
 	return i.man.Update(i.db, extraPerms)
 }
 
+// recordIntegrity keeps the hash of the integrity manifest of the installed
+// version on the application document. The manifest is stored with the files
+// of the application, and this hash allows to detect when it has been removed
+// or modified.
+func (i *Installer) recordIntegrity() error {
+	hash, err := appfs.ManifestIntegrity(i.files, i.man.Slug(), i.man.Version(), i.man.Checksum())
+	if err != nil {
+		return err
+	}
+	i.man.SetIntegrity(hash)
+	return nil
+}
+
 func (i *Installer) notifyChannel() {
 	if i.manc != nil {
 		i.manc <- i.man.Clone().(Manifest)
@@ -645,6 +690,14 @@ func DoLazyUpdate(in *instance.Instance, man Manifest, copier appfs.Copier, regi
 	}
 
 	if src.Scheme == "registry" {
+		if r := findRollout(man.Slug(), in.ContextName); r != nil &&
+			r.State == RolloutRolledBack && r.Version == man.Version() {
+			if rolledBack, err := Rollback(in, man); err == nil {
+				return rolledBack
+			}
+			return man
+		}
+
 		var v *registry.Version
 		channel, _ := getRegistryChannel(src)
 		v, errv := registry.GetLatestVersion(man.Slug(), channel, registries)
@@ -679,7 +732,14 @@ func DoLazyUpdate(in *instance.Instance, man Manifest, copier appfs.Copier, regi
 		if man.AvailableVersion() != "" && v.Version == man.AvailableVersion() {
 			return man
 		}
-		if channel == "stable" && !IsMoreRecent(man.Version(), v.Version) {
+		version := rolloutVersion(man.Slug(), v.Version, in.ContextName, in.Domain)
+		if version == "" || version == man.Version() || version == man.RolledBackVersion() {
+			return man
+		}
+		if version != v.Version && !IsMoreRecent(man.Version(), version) {
+			return man
+		}
+		if channel == "stable" && !IsMoreRecent(man.Version(), version) {
 			return man
 		}
 	}
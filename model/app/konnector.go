@@ -30,11 +30,15 @@ type KonnManifest struct {
 		Version          string                 `json:"version"`
 		AvailableVersion string                 `json:"available_version"`
 		Checksum         string                 `json:"checksum"`
+		Integrity        string                 `json:"integrity,omitempty"`
 		Parameters       map[string]interface{} `json:"parameters"`
 		CreatedAt        time.Time              `json:"created_at"`
 		UpdatedAt        time.Time              `json:"updated_at"`
 		Err              string                 `json:"error"`
 
+		PreviousVersion   *PreviousVersion `json:"previous_version,omitempty"`
+		RolledBackVersion string           `json:"rolled_back_version,omitempty"`
+
 		// Just readers
 		Name            string `json:"name"`
 		Icon            string `json:"icon"`
@@ -112,6 +116,26 @@ func (m *KonnManifest) SetAvailableVersion(version string) { m.val.AvailableVers
 // SetChecksum is part of the Manifest interface
 func (m *KonnManifest) SetChecksum(shasum string) { m.val.Checksum = shasum }
 
+// Integrity is part of the Manifest interface
+func (m *KonnManifest) Integrity() string { return m.val.Integrity }
+
+// SetIntegrity is part of the Manifest interface
+func (m *KonnManifest) SetIntegrity(hash string) { m.val.Integrity = hash }
+
+// PreviousVersion is part of the Manifest interface
+func (m *KonnManifest) PreviousVersion() *PreviousVersion { return m.val.PreviousVersion }
+
+// SetPreviousVersion is part of the Manifest interface
+func (m *KonnManifest) SetPreviousVersion(previous *PreviousVersion) {
+	m.val.PreviousVersion = previous
+}
+
+// RolledBackVersion is part of the Manifest interface
+func (m *KonnManifest) RolledBackVersion() string { return m.val.RolledBackVersion }
+
+// SetRolledBackVersion is part of the Manifest interface
+func (m *KonnManifest) SetRolledBackVersion(version string) { m.val.RolledBackVersion = version }
+
 // AppType is part of the Manifest interface
 func (m *KonnManifest) AppType() consts.AppType { return consts.KonnectorType }
 
@@ -189,6 +213,21 @@ func (m *KonnManifest) MarshalJSON() ([]byte, error) {
 		doc.M["available_version"] = m.val.AvailableVersion
 	}
 	doc.M["checksum"] = m.val.Checksum
+	if m.val.Integrity == "" {
+		delete(doc.M, "integrity")
+	} else {
+		doc.M["integrity"] = m.val.Integrity
+	}
+	if m.val.PreviousVersion == nil {
+		delete(doc.M, "previous_version")
+	} else {
+		doc.M["previous_version"] = m.val.PreviousVersion
+	}
+	if m.val.RolledBackVersion == "" {
+		delete(doc.M, "rolled_back_version")
+	} else {
+		doc.M["rolled_back_version"] = m.val.RolledBackVersion
+	}
 	if m.val.Parameters == nil {
 		delete(doc.M, "parameters")
 	} else {
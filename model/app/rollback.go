@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// PreviousVersion is the version of an application installed before its last
+// update. Its files are still in the appfs storage, so the application can be
+// rolled back to it without fetching it again.
+type PreviousVersion struct {
+	Version   string `json:"version"`
+	Checksum  string `json:"checksum,omitempty"`
+	Source    string `json:"source"`
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// previousVersionOf returns the version to keep for a rollback when an
+// application is updated from the old manifest to the new one.
+func previousVersionOf(oldManifest, newManifest Manifest) *PreviousVersion {
+	if oldManifest.Version() == newManifest.Version() &&
+		oldManifest.Checksum() == newManifest.Checksum() {
+		return oldManifest.PreviousVersion()
+	}
+	return &PreviousVersion{
+		Version:   oldManifest.Version(),
+		Checksum:  oldManifest.Checksum(),
+		Source:    oldManifest.Source(),
+		Integrity: oldManifest.Integrity(),
+	}
+}
+
+// Rollback reinstalls the previous version of an application for an
+// instance, with the manifest and the files kept by appfs. The version that
+// has been rolled back is remembered, so that the lazy updates do not install
+// it again.
+func Rollback(inst *instance.Instance, man Manifest) (Manifest, error) {
+	prev := man.PreviousVersion()
+	if prev == nil || prev.Version == "" {
+		return nil, ErrNoPreviousVersion
+	}
+
+	var fs appfs.FileServer
+	var manFilename string
+	if man.AppType() == consts.WebappType {
+		fs = AppsFileServer(inst)
+		manFilename = WebappManifestName
+	} else {
+		fs = KonnectorsFileServer(inst)
+		manFilename = KonnectorManifestName
+	}
+	// The integrity manifest of the previous version must still be the one
+	// recorded when it was installed.
+	if prev.Integrity != "" {
+		hash, err := appfs.ManifestIntegrity(fs, man.Slug(), prev.Version, prev.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if hash != prev.Integrity {
+			return nil, fmt.Errorf("%w: /%s", appfs.ErrTampered, appfs.IntegrityFileName)
+		}
+	}
+
+	r, err := fs.Open(man.Slug(), prev.Version, prev.Checksum, manFilename)
+	if err != nil {
+		return nil, ErrNoPreviousVersion
+	}
+	defer r.Close()
+	oldManifest, err := man.ReadManifest(r, man.Slug(), prev.Source)
+	if err != nil {
+		return nil, err
+	}
+	oldManifest.SetVersion(prev.Version)
+	oldManifest.SetChecksum(prev.Checksum)
+	oldManifest.SetIntegrity(prev.Integrity)
+	oldManifest.SetState(Ready)
+	oldManifest.SetRolledBackVersion(man.Version())
+
+	// Keep the permissions added to the application after its installation,
+	// like in an update.
+	var extraPerms permission.Set
+	var alteredPerms *permission.Permission
+	if man.AppType() == consts.WebappType {
+		alteredPerms, err = permission.GetForWebapp(inst, man.Slug())
+	} else {
+		alteredPerms, err = permission.GetForKonnector(inst, man.Slug())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if alteredPerms != nil {
+		extraPerms = permission.Diff(man.Permissions(), alteredPerms.Permissions)
+	}
+
+	if err := oldManifest.Update(inst, extraPerms); err != nil {
+		return nil, err
+	}
+	return oldManifest, nil
+}
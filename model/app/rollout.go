@@ -0,0 +1,457 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// RolloutRunning is the state of a rollout where the instances are
+	// updated to the new version, following the policy.
+	RolloutRunning = "running"
+	// RolloutPaused is the state of a rollout where no more instances are
+	// updated to the new version. The rollouts are paused by the health
+	// signals, or by an administrator.
+	RolloutPaused = "paused"
+	// RolloutRolledBack is the state of a rollout where the instances already
+	// updated to the new version go back to their previous version.
+	RolloutRolledBack = "rolled_back"
+)
+
+// rolloutsCacheTTL is the time the rollouts are kept in memory before being
+// loaded again from CouchDB, as they are checked on each lazy update.
+const rolloutsCacheTTL = 1 * time.Minute
+
+var weekDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Rollout is the policy for the updates of an application to a new version,
+// for the instances of a context. The rollout with the default context is used
+// for the contexts without their own rollout. The instances are updated
+// progressively: first the canary domains, then a percentage of the instances,
+// and only during the time windows. The other instances get the previous
+// version, if it is known.
+type Rollout struct {
+	DocID           string          `json:"_id,omitempty"`
+	DocRev          string          `json:"_rev,omitempty"`
+	Slug            string          `json:"slug"`
+	Context         string          `json:"context"`
+	Version         string          `json:"version"`
+	PreviousVersion string          `json:"previous_version,omitempty"`
+	Percentage      int             `json:"percentage"`
+	CanaryDomains   []string        `json:"canary_domains,omitempty"`
+	Windows         []RolloutWindow `json:"windows,omitempty"`
+	State           string          `json:"state"`
+	Reason          string          `json:"reason,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// RolloutWindow is a time window, in UTC, when the instances can be updated.
+// The window can span midnight, like 22:00-06:00.
+type RolloutWindow struct {
+	// Days are the days of the week (mon, tue, etc.). All the days when empty.
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (r *Rollout) ID() string { return r.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (r *Rollout) Rev() string { return r.DocRev }
+
+// SetID is used to implement the couchdb.Doc interface
+func (r *Rollout) SetID(id string) { r.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (r *Rollout) SetRev(rev string) { r.DocRev = rev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (r *Rollout) DocType() string { return consts.AppsRollouts }
+
+// Clone implements couchdb.Doc
+func (r *Rollout) Clone() couchdb.Doc {
+	cloned := *r
+	cloned.CanaryDomains = make([]string, len(r.CanaryDomains))
+	copy(cloned.CanaryDomains, r.CanaryDomains)
+	cloned.Windows = make([]RolloutWindow, len(r.Windows))
+	for i, w := range r.Windows {
+		cloned.Windows[i] = w
+		cloned.Windows[i].Days = make([]string, len(w.Days))
+		copy(cloned.Windows[i].Days, w.Days)
+	}
+	return &cloned
+}
+
+// RolloutID returns the identifier of the rollout for an application and a
+// context.
+func RolloutID(slug, context string) string {
+	if context == "" {
+		context = config.DefaultInstanceContext
+	}
+	return slug + ":" + context
+}
+
+// Validate checks that the rollout policy is well-formed.
+func (r *Rollout) Validate() error {
+	if r.Slug == "" {
+		return fmt.Errorf("%w: missing slug", ErrInvalidRollout)
+	}
+	if r.Version == "" {
+		return fmt.Errorf("%w: missing version", ErrInvalidRollout)
+	}
+	if r.PreviousVersion == r.Version {
+		return fmt.Errorf("%w: the previous version must not be the version of the rollout", ErrInvalidRollout)
+	}
+	if r.Percentage < 0 || r.Percentage > 100 {
+		return fmt.Errorf("%w: the percentage must be between 0 and 100", ErrInvalidRollout)
+	}
+	switch r.State {
+	case RolloutRunning, RolloutPaused, RolloutRolledBack:
+	default:
+		return fmt.Errorf("%w: unknown state %q", ErrInvalidRollout, r.State)
+	}
+	for _, w := range r.Windows {
+		if _, err := parseWindowTime(w.Start); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRollout, err)
+		}
+		if _, err := parseWindowTime(w.End); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRollout, err)
+		}
+		for _, day := range w.Days {
+			if !isWeekDay(day) {
+				return fmt.Errorf("%w: unknown day %q", ErrInvalidRollout, day)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the instance with the given domain can be updated to
+// the version of the rollout at the given time.
+func (r *Rollout) Allows(domain string, now time.Time) bool {
+	if r.State != RolloutRunning || !r.inWindows(now) {
+		return false
+	}
+	for _, canary := range r.CanaryDomains {
+		if canary == domain {
+			return true
+		}
+	}
+	return rolloutBucket(r.Slug, domain) < r.Percentage
+}
+
+func (r *Rollout) inWindows(now time.Time) bool {
+	if len(r.Windows) == 0 {
+		return true
+	}
+	now = now.UTC()
+	for _, w := range r.Windows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w RolloutWindow) contains(now time.Time) bool {
+	start, err := parseWindowTime(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseWindowTime(w.End)
+	if err != nil {
+		return false
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	if start <= end {
+		return minutes >= start && minutes < end && w.hasDay(day)
+	}
+	// The window spans midnight: the part after midnight belongs to the day
+	// when the window has started.
+	if minutes >= start {
+		return w.hasDay(day)
+	}
+	return minutes < end && w.hasDay((day+6)%7)
+}
+
+func (w RolloutWindow) hasDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if strings.ToLower(d) == weekDays[day] {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeekDay(day string) bool {
+	for _, d := range weekDays {
+		if strings.ToLower(day) == d {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindowTime returns the number of minutes since midnight for a time
+// like "09:30".
+func parseWindowTime(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// rolloutBucket returns a number between 0 and 99 for an instance. It is
+// stable, so that increasing the percentage of a rollout only adds instances,
+// and it depends on the slug, so that the same instances are not always the
+// first ones to be updated.
+func rolloutBucket(slug, domain string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(slug + ":" + domain))
+	return int(h.Sum32() % 100)
+}
+
+// SaveRollout creates or replaces the rollout policy for an application and a
+// context. When the previous version is not given, it is the version of the
+// replaced rollout.
+func SaveRollout(r *Rollout) error {
+	if r.Context == "" {
+		r.Context = config.DefaultInstanceContext
+	}
+	if r.State == "" {
+		r.State = RolloutRunning
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r.DocID = RolloutID(r.Slug, r.Context)
+	r.UpdatedAt = time.Now().UTC()
+	old, err := GetRollout(r.Slug, r.Context)
+	switch err {
+	case nil:
+		r.DocRev = old.DocRev
+		r.CreatedAt = old.CreatedAt
+		if r.PreviousVersion == "" {
+			if old.Version != r.Version {
+				r.PreviousVersion = old.Version
+			} else {
+				r.PreviousVersion = old.PreviousVersion
+			}
+		}
+		if old.Version != r.Version {
+			resetHealthCounters(r.Slug, r.Version)
+		}
+	case ErrRolloutNotFound:
+		r.DocRev = ""
+		r.CreatedAt = r.UpdatedAt
+		resetHealthCounters(r.Slug, r.Version)
+	default:
+		return err
+	}
+	defer invalidateRollouts()
+	return couchdb.Upsert(prefixer.GlobalPrefixer, r)
+}
+
+// GetRollout returns the rollout policy for an application and a context.
+func GetRollout(slug, context string) (*Rollout, error) {
+	var r Rollout
+	err := couchdb.GetDoc(prefixer.GlobalPrefixer, consts.AppsRollouts, RolloutID(slug, context), &r)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return nil, ErrRolloutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListRollouts returns the rollout policies of all the applications.
+func ListRollouts() ([]*Rollout, error) {
+	list := []*Rollout{}
+	err := couchdb.ForeachDocs(prefixer.GlobalPrefixer, consts.AppsRollouts, func(_ string, raw json.RawMessage) error {
+		var r Rollout
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return err
+		}
+		list = append(list, &r)
+		return nil
+	})
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteRollout removes the rollout policy for an application and a context.
+// The instances are then updated as soon as a new version is available.
+func DeleteRollout(slug, context string) error {
+	r, err := GetRollout(slug, context)
+	if err != nil {
+		return err
+	}
+	defer invalidateRollouts()
+	return couchdb.DeleteDoc(prefixer.GlobalPrefixer, r)
+}
+
+// SetRolloutState pauses, resumes or rolls back a rollout. The reason is
+// kept to explain to the administrators why a rollout has been paused.
+func SetRolloutState(slug, context, state, reason string) (*Rollout, error) {
+	r, err := GetRollout(slug, context)
+	if err != nil {
+		return nil, err
+	}
+	r.State = state
+	r.Reason = reason
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if state == RolloutRunning {
+		resetHealthCounters(r.Slug, r.Version)
+	}
+	r.UpdatedAt = time.Now().UTC()
+	defer invalidateRollouts()
+	if err := couchdb.UpdateDoc(prefixer.GlobalPrefixer, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var rolloutsCache struct {
+	sync.Mutex
+	list    []*Rollout
+	fetched time.Time
+}
+
+// cachedRollouts returns the rollouts kept in memory, and loads them again if
+// they are too old. If CouchDB cannot be reached, the previous list is used
+// until the next reload.
+func cachedRollouts() []*Rollout {
+	rolloutsCache.Lock()
+	defer rolloutsCache.Unlock()
+	if time.Since(rolloutsCache.fetched) < rolloutsCacheTTL {
+		return rolloutsCache.list
+	}
+	rolloutsCache.fetched = time.Now()
+	list, err := ListRollouts()
+	if err != nil {
+		logger.WithNamespace("rollouts").Warnf("Cannot load the rollouts: %s", err)
+		return rolloutsCache.list
+	}
+	rolloutsCache.list = list
+	return list
+}
+
+func invalidateRollouts() {
+	rolloutsCache.Lock()
+	defer rolloutsCache.Unlock()
+	rolloutsCache.fetched = time.Time{}
+}
+
+// findRollout returns the rollout that applies to an application for a
+// context, or nil if the updates of the application are not staged.
+func findRollout(slug, context string) *Rollout {
+	if context == "" {
+		context = config.DefaultInstanceContext
+	}
+	var fallback *Rollout
+	for _, r := range cachedRollouts() {
+		if r.Slug != slug {
+			continue
+		}
+		if r.Context == context {
+			return r
+		}
+		if r.Context == config.DefaultInstanceContext {
+			fallback = r
+		}
+	}
+	return fallback
+}
+
+// rolloutVersion returns the version of an application that an instance can
+// install or update to, when the given version is the last one published on
+// the registry. The instances in a rollout get its version, even if a newer
+// version has been published, and the other instances get the previous
+// version of the rollout. An empty string is returned when this previous
+// version is not known.
+func rolloutVersion(slug, latest, context, domain string) string {
+	r := findRollout(slug, context)
+	if r == nil {
+		return latest
+	}
+	if r.Allows(domain, time.Now()) {
+		return r.Version
+	}
+	return r.PreviousVersion
+}
+
+// updateAllowed returns true if an instance can be updated to the given
+// version of an application.
+func updateAllowed(slug, version, context, domain string) bool {
+	return rolloutVersion(slug, version, context, domain) == version
+}
+
+// ReportFailure is called for the health signals of a version of an
+// application on an instance: the errors logged by its client side and the
+// failures of its services. An instance is counted once per hour for each
+// signal, so that a single instance cannot pause a rollout. When too many
+// instances are failing, the running rollouts of this version are paused.
+func ReportFailure(domain, slug, version string, signal limits.CounterType) {
+	if domain == "" || slug == "" || version == "" {
+		return
+	}
+	var running []*Rollout
+	for _, r := range cachedRollouts() {
+		if r.Slug == slug && r.Version == version && r.State == RolloutRunning {
+			running = append(running, r)
+		}
+	}
+	if len(running) == 0 {
+		return
+	}
+	limiter := config.GetRateLimiter()
+	key := fmt.Sprintf("%s@%s:%d", slug, version, signal)
+	if err := limiter.CheckRateLimitKey(key+":"+domain, limits.RolloutInstanceSignalType); err != nil {
+		return
+	}
+	err := limiter.CheckRateLimitKey(slug+"@"+version, signal)
+	if !limits.IsLimitReachedOrExceeded(err) {
+		return
+	}
+	reason := "too many instances with client errors"
+	if signal == limits.RolloutJobFailureType {
+		reason = "too many instances with service failures"
+	}
+	log := logger.WithNamespace("rollouts")
+	for _, r := range running {
+		if _, err := SetRolloutState(r.Slug, r.Context, RolloutPaused, reason); err != nil {
+			log.Errorf("Cannot pause the rollout of %s@%s for %s: %s", slug, version, r.Context, err)
+			continue
+		}
+		log.Warnf("Rollout of %s@%s for %s paused: %s", slug, version, r.Context, reason)
+	}
+}
+
+func resetHealthCounters(slug, version string) {
+	limiter := config.GetRateLimiter()
+	limiter.ResetCounterKey(slug+"@"+version, limits.RolloutClientErrorType)
+	limiter.ResetCounterKey(slug+"@"+version, limits.RolloutJobFailureType)
+}
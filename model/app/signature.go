@@ -0,0 +1,120 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// maxSignatureSize is the maximal size of a file with the signature of a
+// package.
+const maxSignatureSize = 1024
+
+// SignedMessage returns the message signed by the publisher of an application
+// for a package: the slug, the version and the sha256 of the tarball in
+// hexadecimal, like "drive@1.2.3:<sha256>".
+func SignedMessage(slug, version, shasum string) []byte {
+	return []byte(slug + "@" + version + ":" + strings.ToLower(shasum))
+}
+
+// trustedKeys returns the keys pinned in the config for the context of an
+// instance and for the registry of the package (nil if the package does not
+// come from a registry). It also tells if a valid signature is required.
+func trustedKeys(context string, registry *url.URL) ([]ed25519.PublicKey, bool) {
+	var keys []ed25519.PublicKey
+	required := false
+	for _, pinned := range config.GetConfig().AppsSignatures {
+		if pinned.Registry != "" && !sameRegistry(pinned.Registry, registry) {
+			continue
+		}
+		if pinned.Context != "" && pinned.Context != config.DefaultInstanceContext && pinned.Context != context {
+			continue
+		}
+		pks, err := pinned.PublicKeys()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, pks...)
+		required = required || pinned.Required
+	}
+	return keys, required
+}
+
+func sameRegistry(pinned string, registry *url.URL) bool {
+	if registry == nil {
+		return false
+	}
+	return strings.TrimSuffix(pinned, "/") == strings.TrimSuffix(registry.String(), "/")
+}
+
+// verifySignature checks the signature, in base64, of a package against the
+// trusted keys. A package without signature is accepted only if a signature
+// is not required.
+func verifySignature(keys []ed25519.PublicKey, required bool, slug, version, shasum, signature string) error {
+	if signature == "" {
+		if required {
+			return ErrUnsignedPackage
+		}
+		return nil
+	}
+	if len(keys) == 0 && !required {
+		return nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	msg := SignedMessage(slug, version, shasum)
+	for _, key := range keys {
+		if ed25519.Verify(key, msg, sig) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// checkUnsignedSource returns an error if the packages must be signed for the
+// context, as the git repositories and the local directories have no
+// signature.
+func checkUnsignedSource(context string) error {
+	if _, required := trustedKeys(context, nil); required {
+		return ErrUnsignedPackage
+	}
+	return nil
+}
+
+// fetchSignature downloads the signature of a tarball, published next to it
+// with the .sig extension. An empty string is returned if there is no
+// signature.
+func fetchSignature(src *url.URL) (string, error) {
+	u := *src
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path += ".sig"
+	u.RawPath = ""
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrSourceNotReachable
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -40,15 +40,32 @@ func (c *Route) NotFound() bool { return c.Folder == "" }
 // Routes is a map for routing inside an application.
 type Routes map[string]Route
 
+const (
+	// ServiceTypeNode is the type of the services executed by node, in an
+	// external process.
+	ServiceTypeNode = "node"
+	// ServiceTypeWasm is the type of the services compiled to WebAssembly,
+	// and executed inside the stack process.
+	ServiceTypeWasm = "wasm"
+)
+
 // Service is a struct to define a service executed by the stack.
 type Service struct {
 	name string
 
-	Type           string `json:"type"`
-	File           string `json:"file"`
-	Debounce       string `json:"debounce"`
-	TriggerOptions string `json:"trigger"`
-	TriggerID      string `json:"trigger_id"`
+	Type           string         `json:"type"`
+	File           string         `json:"file"`
+	Debounce       string         `json:"debounce"`
+	TriggerOptions string         `json:"trigger"`
+	TriggerID      string         `json:"trigger_id"`
+	Limits         *ServiceLimits `json:"limits,omitempty"`
+}
+
+// ServiceLimits are the resources that a wasm service is allowed to use. They
+// can only lower the limits of the stack.
+type ServiceLimits struct {
+	Memory  int    `json:"memory,omitempty"`  // in MiB
+	Timeout string `json:"timeout,omitempty"` // a duration, like "30s"
 }
 
 // Services is a map to define services assciated with an application.
@@ -89,10 +106,14 @@ type WebappManifest struct {
 		Version          string    `json:"version"`
 		AvailableVersion string    `json:"available_version"`
 		Checksum         string    `json:"checksum"`
+		Integrity        string    `json:"integrity,omitempty"`
 		CreatedAt        time.Time `json:"created_at"`
 		UpdatedAt        time.Time `json:"updated_at"`
 		Err              string    `json:"error"`
 
+		PreviousVersion   *PreviousVersion `json:"previous_version,omitempty"`
+		RolledBackVersion string           `json:"rolled_back_version,omitempty"`
+
 		// Just readers
 		Name          string `json:"name"`
 		NamePrefix    string `json:"name_prefix"`
@@ -108,6 +129,7 @@ type WebappManifest struct {
 		Services      Services       `json:"services"`
 		Locales       Locales        `json:"locales"`
 		Notifications Notifications  `json:"notifications"`
+		CSP           CSPSources     `json:"csp"`
 	}
 
 	FromAppsDir bool        `json:"-"` // Used in development
@@ -179,6 +201,26 @@ func (m *WebappManifest) SetAvailableVersion(version string) { m.val.AvailableVe
 // SetChecksum is part of the Manifest interface
 func (m *WebappManifest) SetChecksum(shasum string) { m.val.Checksum = shasum }
 
+// Integrity is part of the Manifest interface
+func (m *WebappManifest) Integrity() string { return m.val.Integrity }
+
+// SetIntegrity is part of the Manifest interface
+func (m *WebappManifest) SetIntegrity(hash string) { m.val.Integrity = hash }
+
+// PreviousVersion is part of the Manifest interface
+func (m *WebappManifest) PreviousVersion() *PreviousVersion { return m.val.PreviousVersion }
+
+// SetPreviousVersion is part of the Manifest interface
+func (m *WebappManifest) SetPreviousVersion(previous *PreviousVersion) {
+	m.val.PreviousVersion = previous
+}
+
+// RolledBackVersion is part of the Manifest interface
+func (m *WebappManifest) RolledBackVersion() string { return m.val.RolledBackVersion }
+
+// SetRolledBackVersion is part of the Manifest interface
+func (m *WebappManifest) SetRolledBackVersion(version string) { m.val.RolledBackVersion = version }
+
 // AppType is part of the Manifest interface
 func (m *WebappManifest) AppType() consts.AppType { return consts.WebappType }
 
@@ -293,6 +335,21 @@ func (m *WebappManifest) MarshalJSON() ([]byte, error) {
 		doc.M["available_version"] = m.val.AvailableVersion
 	}
 	doc.M["checksum"] = m.val.Checksum
+	if m.val.Integrity == "" {
+		delete(doc.M, "integrity")
+	} else {
+		doc.M["integrity"] = m.val.Integrity
+	}
+	if m.val.PreviousVersion == nil {
+		delete(doc.M, "previous_version")
+	} else {
+		doc.M["previous_version"] = m.val.PreviousVersion
+	}
+	if m.val.RolledBackVersion == "" {
+		delete(doc.M, "rolled_back_version")
+	} else {
+		doc.M["rolled_back_version"] = m.val.RolledBackVersion
+	}
 	doc.M["created_at"] = m.val.CreatedAt
 	doc.M["updated_at"] = m.val.UpdatedAt
 	if m.val.Err == "" {
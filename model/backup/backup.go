@@ -0,0 +1,348 @@
+// Package backup is used for the incremental backups of the instances: a
+// snapshot records the documents that have changed since the previous
+// snapshot, and the content of the new files, encrypted with a key held by
+// the user or the operator.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/move"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/keyring"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// StateRunning is the state of a snapshot that is being taken.
+	StateRunning = "running"
+	// StateDone is the state of a snapshot that can be restored.
+	StateDone = "done"
+	// StateError is the state of a snapshot that has failed.
+	StateError = "error"
+)
+
+// defaultFullEvery is the number of incremental snapshots before a new full
+// snapshot when it is not configured.
+const defaultFullEvery = 30
+
+// archiveLifetime is used for the expiration of the archives on Swift: the
+// snapshots are removed by the stack when they are pruned, as an incremental
+// snapshot can't be restored without the previous ones.
+const archiveLifetime = 100 * 365 * 24 * time.Hour
+
+var (
+	// ErrNotFound is used when a snapshot could not be found
+	ErrNotFound = echo.NewHTTPError(http.StatusNotFound, "backup: snapshot not found")
+	// ErrNotRestorable is used when the snapshot is not finished, or when a
+	// snapshot it depends on is missing.
+	ErrNotRestorable = echo.NewHTTPError(http.StatusBadRequest, "backup: snapshot cannot be restored")
+	// ErrConflict is used when a snapshot is already being taken.
+	ErrConflict = echo.NewHTTPError(http.StatusConflict, "backup: a snapshot is already being taken")
+	// ErrNoKey is used when there is no key for encrypting the backups.
+	ErrNoKey = echo.NewHTTPError(http.StatusBadRequest, "backup: no encryptor key")
+	// ErrInvalidKey is used when the public key given by the user is not a
+	// valid key.
+	ErrInvalidKey = errors.New("backup: invalid public key")
+	// ErrWrongKey is used when the key given for a restore is not the one
+	// used for encrypting the snapshot.
+	ErrWrongKey = echo.NewHTTPError(http.StatusBadRequest, "backup: wrong decryptor key")
+	// ErrCorrupted is used when an archive cannot be decrypted.
+	ErrCorrupted = errors.New("backup: the archive is corrupted")
+)
+
+// Snapshot is the document, in the global database, for a snapshot of an
+// instance. A full snapshot has no parent, and an incremental snapshot has
+// the changes since its parent.
+type Snapshot struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+	Domain string `json:"domain"`
+	Parent string `json:"parent,omitempty"`
+	Depth  int    `json:"depth"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+
+	// Sequences are the last sequences of the changes feeds of the
+	// databases of the instance, by doctype.
+	Sequences map[string]string `json:"sequences,omitempty"`
+	// KeyID identifies the key used for encrypting the snapshot.
+	KeyID string `json:"key_id"`
+	// StateKey is the key for the state of the VFS, which is read by the
+	// stack for the next snapshots.
+	StateKey []byte `json:"state_key,omitempty"`
+
+	Docs      int   `json:"docs"`
+	Deletions int   `json:"deletions"`
+	Blobs     int   `json:"blobs"`
+	Size      int64 `json:"size"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"`
+}
+
+// ID implements the couchdb.Doc interface
+func (s *Snapshot) ID() string { return s.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (s *Snapshot) Rev() string { return s.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (s *Snapshot) DocType() string { return consts.Backups }
+
+// SetID implements the couchdb.Doc interface
+func (s *Snapshot) SetID(id string) { s.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (s *Snapshot) SetRev(rev string) { s.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (s *Snapshot) Clone() couchdb.Doc {
+	cloned := *s
+	cloned.Sequences = make(map[string]string, len(s.Sequences))
+	for k, v := range s.Sequences {
+		cloned.Sequences[k] = v
+	}
+	cloned.StateKey = append([]byte(nil), s.StateKey...)
+	if s.FinishedAt != nil {
+		finished := *s.FinishedAt
+		cloned.FinishedAt = &finished
+	}
+	if s.RestoredAt != nil {
+		restored := *s.RestoredAt
+		cloned.RestoredAt = &restored
+	}
+	return &cloned
+}
+
+// IsFull returns true for a snapshot that doesn't depend on another one.
+func (s *Snapshot) IsFull() bool {
+	return s.Parent == ""
+}
+
+// archive returns the export document used for storing an archive of the
+// snapshot with the archiver.
+func (s *Snapshot) archive(suffix string) *move.ExportDoc {
+	return &move.ExportDoc{
+		DocID:     "backup-" + s.DocID + suffix,
+		Domain:    s.Domain,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.CreatedAt.Add(archiveLifetime),
+	}
+}
+
+// Get returns the snapshot with the given identifier.
+func Get(id string) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := couchdb.GetDoc(prefixer.GlobalPrefixer, consts.Backups, id, s); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the snapshots of an instance, the most recent first.
+func List(domain string) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	req := &couchdb.FindRequest{
+		UseIndex: "by-domain",
+		Selector: mango.Equal("domain", domain),
+		Sort: mango.SortBy{
+			{Field: "domain", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit: 1000,
+	}
+	err := couchdb.FindDocs(prefixer.GlobalPrefixer, consts.Backups, req, &snapshots)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// RecipientKey returns the public key used for encrypting the snapshots of
+// the instance: the key given by the user if there is one, else the key of the
+// operator. Only the public part of the key is needed, the archive keys are
+// sealed with an ephemeral key.
+func RecipientKey(inst *instance.Instance) (*[32]byte, error) {
+	if len(inst.BackupPublicKey) == 32 {
+		var public [32]byte
+		copy(public[:], inst.BackupPublicKey)
+		return &public, nil
+	}
+	filename := config.GetConfig().Backup.EncryptorKey
+	if filename == "" {
+		return nil, ErrNoKey
+	}
+	encoded, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keyring.UnmarshalNACLKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey(), nil
+}
+
+// SetUserKey saves the public key given by the user, encoded in base64, for
+// encrypting the next snapshots of the instance. It is kept in the instance
+// document, which is not readable by the applications. An empty key goes back
+// to the key of the operator.
+func SetUserKey(inst *instance.Instance, encoded string) error {
+	var public []byte
+	if encoded != "" {
+		var err error
+		public, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(public) != 32 {
+			return ErrInvalidKey
+		}
+	}
+	inst.BackupPublicKey = public
+	return instance.Update(inst)
+}
+
+// recipientKeyID returns the identifier of a recipient key.
+func recipientKeyID(public *[32]byte) string {
+	return keyID(public[:])
+}
+
+// decryptorKeyID returns the identifier of the recipient key that matches
+// the given decryptor key.
+func decryptorKeyID(key *keyring.NACLKey) (string, error) {
+	public, err := decryptorPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return recipientKeyID(public), nil
+}
+
+// decryptorPublicKey returns the public key of the recipient, derived from
+// the private part of the decryptor key.
+func decryptorPublicKey(key *keyring.NACLKey) (*[32]byte, error) {
+	derived, err := curve25519.X25519(key.PrivateKey()[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	var public [32]byte
+	copy(public[:], derived)
+	return &public, nil
+}
+
+func keyID(public []byte) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+// chain returns the snapshots needed for restoring the given snapshot, from
+// the full snapshot to the given one.
+func chain(target *Snapshot) ([]*Snapshot, error) {
+	snapshots := []*Snapshot{target}
+	for current := target; !current.IsFull(); {
+		parent, err := Get(current.Parent)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, ErrNotRestorable
+			}
+			return nil, err
+		}
+		if parent.State != StateDone || parent.Domain != target.Domain {
+			return nil, ErrNotRestorable
+		}
+		snapshots = append(snapshots, parent)
+		current = parent
+	}
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
+	}
+	return snapshots, nil
+}
+
+// parentFor returns the snapshot on which the next snapshot will be based,
+// or nil if the next snapshot must be a full one: when there is no previous
+// snapshot, when the chain is too long, when the key has changed, or when
+// the instance has been restored since the last snapshot (the databases have
+// been recreated and their sequences are no longer valid).
+func parentFor(snapshots []*Snapshot, keyID string) *Snapshot {
+	fullEvery := config.GetConfig().Backup.FullEvery
+	if fullEvery <= 0 {
+		fullEvery = defaultFullEvery
+	}
+	var last *Snapshot
+	for _, s := range snapshots {
+		if s.State == StateDone && (last == nil || s.CreatedAt.After(last.CreatedAt)) {
+			last = s
+		}
+	}
+	if last == nil || last.Depth >= fullEvery || last.KeyID != keyID {
+		return nil
+	}
+	for _, s := range snapshots {
+		if s.RestoredAt != nil && s.RestoredAt.After(last.CreatedAt) {
+			return nil
+		}
+	}
+	return last
+}
+
+// prune removes the snapshots that are older than the retention, unless they
+// are needed for restoring a more recent snapshot.
+func prune(domain string, archiver move.Archiver) error {
+	retention := config.GetConfig().Backup.Retention
+	if retention <= 0 {
+		return nil
+	}
+	snapshots, err := List(domain)
+	if err != nil {
+		return err
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	needed := make(map[string]bool)
+	limit := time.Now().Add(-retention)
+	var removed []*Snapshot
+	for _, s := range snapshots {
+		keep := s.CreatedAt.After(limit) || needed[s.DocID]
+		if s.State == StateRunning && time.Since(s.CreatedAt) < 24*time.Hour {
+			keep = true
+		}
+		if keep {
+			if s.Parent != "" {
+				needed[s.Parent] = true
+			}
+			continue
+		}
+		removed = append(removed, s)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	var archives []*move.ExportDoc
+	docs := make([]couchdb.Doc, len(removed))
+	for i, s := range removed {
+		archives = append(archives, s.archive(""), s.archive(stateSuffix))
+		docs[i] = s
+	}
+	// Some archives may be missing for the snapshots in error
+	_ = archiver.RemoveArchives(archives)
+	return couchdb.BulkDeleteDocs(prefixer.GlobalPrefixer, consts.Backups, docs)
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryption(t *testing.T) {
+	encryptorKey, decryptorKey, err := keyring.GenerateKeyPair(rand.Reader)
+	require.NoError(t, err)
+	key := newArchiveKey()
+	sealed, err := sealKey(encryptorKey.PublicKey(), key)
+	require.NoError(t, err)
+
+	// Several chunks, with the last one not full
+	plain := make([]byte, 3*chunkSize+42)
+	_, err = io.ReadFull(rand.Reader, plain)
+	require.NoError(t, err)
+
+	var archive bytes.Buffer
+	enc, err := newEncrypter(&archive, key, sealed)
+	require.NoError(t, err)
+	_, err = enc.Write(plain[:1000])
+	require.NoError(t, err)
+	_, err = enc.Write(plain[1000:])
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	decrypt := func(data []byte, decryptorKey *keyring.NACLKey) ([]byte, error) {
+		r := bytes.NewReader(data)
+		sealed, err := readSealedKey(r)
+		if err != nil {
+			return nil, err
+		}
+		key, err := openKey(decryptorKey, sealed)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := newDecrypter(r, key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(dec)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		decrypted, err := decrypt(archive.Bytes(), decryptorKey)
+		require.NoError(t, err)
+		assert.Equal(t, plain, decrypted)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		_, otherKey, err := keyring.GenerateKeyPair(rand.Reader)
+		require.NoError(t, err)
+		_, err = decrypt(archive.Bytes(), otherKey)
+		assert.ErrorIs(t, err, ErrWrongKey)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		truncated := archive.Bytes()[:archive.Len()-chunkSize/2]
+		_, err := decrypt(truncated, decryptorKey)
+		assert.Error(t, err)
+
+		// Only the full chunks: the last one is missing
+		truncated = archive.Bytes()[:archive.Len()-100]
+		_, err = decrypt(truncated, decryptorKey)
+		assert.Error(t, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := bytes.Clone(archive.Bytes())
+		tampered[len(tampered)/2] ^= 0xff
+		_, err := decrypt(tampered, decryptorKey)
+		assert.Error(t, err)
+	})
+}
+
+func TestParentFor(t *testing.T) {
+	config.UseTestFile(t)
+	conf := config.GetConfig()
+	fullEvery := conf.Backup.FullEvery
+	conf.Backup.FullEvery = 3
+	t.Cleanup(func() { conf.Backup.FullEvery = fullEvery })
+
+	now := time.Now().UTC()
+	full := &Snapshot{DocID: "full", State: StateDone, KeyID: "k1", CreatedAt: now.Add(-3 * time.Hour)}
+	incr := &Snapshot{DocID: "incr", Parent: "full", Depth: 1, State: StateDone, KeyID: "k1", CreatedAt: now.Add(-2 * time.Hour)}
+	failed := &Snapshot{DocID: "failed", Parent: "incr", Depth: 2, State: StateError, KeyID: "k1", CreatedAt: now.Add(-1 * time.Hour)}
+
+	assert.Nil(t, parentFor(nil, "k1"))
+	assert.Equal(t, incr, parentFor([]*Snapshot{failed, incr, full}, "k1"))
+
+	// The key has changed
+	assert.Nil(t, parentFor([]*Snapshot{failed, incr, full}, "k2"))
+
+	// Too many incremental snapshots
+	deep := &Snapshot{DocID: "deep", Parent: "incr", Depth: 3, State: StateDone, KeyID: "k1", CreatedAt: now}
+	assert.Nil(t, parentFor([]*Snapshot{deep, incr, full}, "k1"))
+
+	// An instance restored after the last snapshot
+	restoredAt := now.Add(-30 * time.Minute)
+	restored := &Snapshot{DocID: "full", State: StateDone, KeyID: "k1", CreatedAt: full.CreatedAt, RestoredAt: &restoredAt}
+	assert.Nil(t, parentFor([]*Snapshot{incr, restored}, "k1"))
+}
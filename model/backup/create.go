@@ -0,0 +1,355 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/move"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// blobsDir is the directory of the archive where the contents of the files
+// are stored, after the documents.
+const blobsDir = "blobs"
+
+// changesLimit is the number of changes fetched by request to CouchDB.
+const changesLimit = 1000
+
+var errContentChanged = errors.New("the content has changed")
+
+// Create takes a new snapshot of the instance. It is a full snapshot if there
+// is no previous snapshot it can be based on, else it is an incremental
+// snapshot with the changes since the previous snapshot.
+func Create(inst *instance.Instance, archiver move.Archiver) (*Snapshot, error) {
+	recipientKey, err := RecipientKey(inst)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := List(inst.Domain)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.State == StateRunning && time.Since(s.CreatedAt) < 24*time.Hour {
+			return nil, ErrConflict
+		}
+	}
+
+	s := &Snapshot{
+		Domain:    inst.Domain,
+		State:     StateRunning,
+		KeyID:     recipientKeyID(recipientKey),
+		CreatedAt: time.Now().UTC(),
+	}
+	since := map[string]string{}
+	var state *vfsState
+	if parent := parentFor(snapshots, s.KeyID); parent != nil {
+		state, err = loadState(inst, archiver, parent)
+		if err != nil {
+			inst.Logger().WithNamespace("backup").
+				Warnf("Cannot load the state of %s, taking a full snapshot: %s", parent.DocID, err)
+		} else {
+			s.Parent = parent.DocID
+			s.Depth = parent.Depth + 1
+			s.StateKey = parent.StateKey
+			since = parent.Sequences
+		}
+	}
+	if s.IsFull() {
+		state = newVFSState()
+		s.StateKey = newArchiveKey()[:]
+	}
+	if err := couchdb.CreateDoc(prefixer.GlobalPrefixer, s); err != nil {
+		return nil, err
+	}
+	realtime.GetHub().Publish(inst, realtime.EventCreate, s.Clone(), nil)
+
+	err = s.write(inst, archiver, recipientKey, state, since)
+	if err == nil {
+		err = saveState(archiver, s, state)
+	}
+	old := s.Clone()
+	finishedAt := time.Now().UTC()
+	s.FinishedAt = &finishedAt
+	if err != nil {
+		s.State = StateError
+		s.Error = err.Error()
+	} else {
+		s.State = StateDone
+	}
+	if errc := couchdb.UpdateDoc(prefixer.GlobalPrefixer, s); errc != nil && err == nil {
+		err = errc
+	}
+	realtime.GetHub().Publish(inst, realtime.EventUpdate, s, old)
+	if err != nil {
+		return nil, err
+	}
+
+	if errp := prune(inst.Domain, archiver); errp != nil {
+		inst.Logger().WithNamespace("backup").
+			Warnf("Cannot prune the snapshots: %s", errp)
+	}
+	return s, nil
+}
+
+// snapshotWriter writes the archive of a snapshot: the documents that have
+// changed since the previous snapshot, then the new contents.
+type snapshotWriter struct {
+	inst    *instance.Instance
+	s       *Snapshot
+	tw      *tar.Writer
+	state   *vfsState
+	pending []pendingBlob
+	now     time.Time
+}
+
+// pendingBlob is a content that must be added to the snapshot, for a file or
+// a version.
+type pendingBlob struct {
+	md5     string
+	file    *vfs.FileDoc
+	version *vfs.Version
+}
+
+func (s *Snapshot) write(inst *instance.Instance, archiver move.Archiver, recipientKey *[32]byte, state *vfsState, since map[string]string) error {
+	_ = note.FlushPendings(inst)
+
+	key := newArchiveKey()
+	sealedKey, err := sealKey(recipientKey, key)
+	if err != nil {
+		return err
+	}
+	out, err := archiver.CreateArchive(s.archive(""))
+	if err != nil {
+		return err
+	}
+	counter := &countingWriter{w: out}
+	enc, err := newEncrypter(counter, key, sealedKey)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	gw := gzip.NewWriter(enc)
+	w := &snapshotWriter{
+		inst:  inst,
+		s:     s,
+		tw:    tar.NewWriter(gw),
+		state: state,
+		now:   s.CreatedAt,
+	}
+	err = w.writeAll(since)
+	for _, closer := range []io.Closer{w.tw, gw, enc, out} {
+		if errc := closer.Close(); err == nil {
+			err = errc
+		}
+	}
+	s.Size = counter.n
+	return err
+}
+
+func (w *snapshotWriter) writeAll(since map[string]string) error {
+	doctypes, err := couchdb.AllDoctypes(w.inst)
+	if err != nil {
+		return err
+	}
+	sort.Strings(doctypes)
+	w.s.Sequences = make(map[string]string, len(doctypes))
+	for _, doctype := range doctypes {
+		seq, err := w.writeChanges(doctype, since[doctype])
+		if err != nil {
+			return err
+		}
+		w.s.Sequences[doctype] = seq
+	}
+	return w.writeBlobs()
+}
+
+// writeChanges writes the documents of a doctype that have changed since the
+// given sequence, and returns the last sequence.
+func (w *snapshotWriter) writeChanges(doctype, since string) (string, error) {
+	full := since == ""
+	dir := url.PathEscape(doctype)
+	req := &couchdb.ChangesRequest{
+		DocType:     doctype,
+		IncludeDocs: true,
+		Since:       since,
+		Limit:       changesLimit,
+	}
+	for {
+		res, err := couchdb.GetChanges(w.inst, req)
+		if err != nil {
+			return "", err
+		}
+		for _, change := range res.Results {
+			if strings.HasPrefix(change.DocID, "_design/") {
+				continue
+			}
+			if change.Deleted {
+				// There is nothing to restore for the deleted documents
+				// in a full snapshot
+				if full {
+					continue
+				}
+				delete(w.state.Contents, change.DocID)
+				doc := map[string]interface{}{"_id": change.DocID, "_deleted": true}
+				if err := w.writeDoc(dir, change.DocID, doc); err != nil {
+					return "", err
+				}
+				w.s.Deletions++
+				continue
+			}
+			if err := w.writeDoc(dir, change.DocID, change.Doc.M); err != nil {
+				return "", err
+			}
+			w.s.Docs++
+			if err := w.track(doctype, change.Doc.M); err != nil {
+				return "", err
+			}
+		}
+		req.Since = res.LastSeq
+		if len(res.Results) == 0 || res.Pending == 0 {
+			return res.LastSeq, nil
+		}
+	}
+}
+
+func (w *snapshotWriter) writeDoc(dir, id string, doc map[string]interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     path.Join(dir, id+".json"),
+		Mode:     0640,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+		ModTime:  w.now,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = w.tw.Write(data)
+	return err
+}
+
+// track updates the state of the VFS for a file or a version, and adds its
+// content to the pending blobs if it is not already in a snapshot.
+func (w *snapshotWriter) track(doctype string, doc map[string]interface{}) error {
+	if doctype != consts.Files && doctype != consts.FilesVersions {
+		return nil
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	blob := pendingBlob{}
+	var id string
+	var sum []byte
+	if doctype == consts.Files {
+		var dirOrFile vfs.DirOrFileDoc
+		if err := json.Unmarshal(raw, &dirOrFile); err != nil {
+			return err
+		}
+		_, file := dirOrFile.Refine()
+		if file == nil {
+			return nil
+		}
+		id, sum, blob.file = file.DocID, file.MD5Sum, file
+	} else {
+		version := &vfs.Version{}
+		if err := json.Unmarshal(raw, version); err != nil {
+			return err
+		}
+		id, sum, blob.version = version.DocID, version.MD5Sum, version
+	}
+	blob.md5 = hex.EncodeToString(sum)
+	w.state.Contents[id] = blob.md5
+	if _, ok := w.state.Blobs[blob.md5]; !ok {
+		w.state.Blobs[blob.md5] = w.s.DocID
+		w.pending = append(w.pending, blob)
+	}
+	return nil
+}
+
+// writeBlobs writes the new contents, after the documents.
+func (w *snapshotWriter) writeBlobs() error {
+	fs := w.inst.VFS()
+	for _, blob := range w.pending {
+		content, size, err := w.openBlob(fs, blob)
+		if err != nil {
+			// The file has been modified or deleted since the changes have
+			// been fetched: the next snapshot will have its new content.
+			w.inst.Logger().WithNamespace("backup").
+				Infof("Cannot add the content %s to the snapshot: %s", blob.md5, err)
+			delete(w.state.Blobs, blob.md5)
+			continue
+		}
+		hdr := &tar.Header{
+			Name:     path.Join(blobsDir, blob.md5),
+			Mode:     0640,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+			ModTime:  w.now,
+		}
+		err = w.tw.WriteHeader(hdr)
+		if err == nil {
+			_, err = io.Copy(w.tw, content)
+		}
+		if errc := content.Close(); err == nil {
+			err = errc
+		}
+		if err != nil {
+			return err
+		}
+		w.s.Blobs++
+	}
+	return nil
+}
+
+func (w *snapshotWriter) openBlob(fs vfs.VFS, blob pendingBlob) (vfs.File, int64, error) {
+	if blob.version != nil {
+		fileID := blob.version.Rels.File.Data.ID
+		file, err := fs.FileByID(fileID)
+		if err != nil {
+			return nil, 0, err
+		}
+		content, err := fs.OpenFileVersion(file, blob.version)
+		return content, blob.version.ByteSize, err
+	}
+	file, err := fs.FileByID(blob.file.DocID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if hex.EncodeToString(file.MD5Sum) != blob.md5 {
+		return nil, 0, errContentChanged
+	}
+	content, err := fs.OpenFile(file)
+	return content, file.ByteSize, err
+}
+
+// countingWriter counts the bytes written in the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,204 @@
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/cozy/cozy-stack/pkg/keyring"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The archives start with a header: a magic string, and the key of the
+// archive, sealed for the recipient key (it is empty for the state of the
+// VFS, whose key is in the snapshot document). The content is then split in
+// chunks of 64KiB, and each chunk is sealed in a NaCl secret box, with a nonce
+// made of a random prefix, the chunk counter, and a flag for the last chunk,
+// so that a reordered or truncated archive is detected.
+const (
+	archiveMagic = "COZYBKP1"
+	chunkSize    = 64 * 1024
+	prefixLen    = 15
+)
+
+// newArchiveKey returns a random key for an archive.
+func newArchiveKey() *[32]byte {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		panic(err)
+	}
+	return &key
+}
+
+// sealKey encrypts the key of an archive for the recipient key. It is an
+// anonymous box: the sender key is an ephemeral key, so that the stack
+// doesn't need any secret for the encryption.
+func sealKey(recipientKey *[32]byte, key *[32]byte) ([]byte, error) {
+	return box.SealAnonymous(nil, key[:], recipientKey, rand.Reader)
+}
+
+// openKey decrypts the key of an archive with the decryptor key.
+func openKey(decryptorKey *keyring.NACLKey, sealed []byte) (*[32]byte, error) {
+	public, err := decryptorPublicKey(decryptorKey)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	plain, ok := box.OpenAnonymous(nil, sealed, public, decryptorKey.PrivateKey())
+	if !ok || len(plain) != 32 {
+		return nil, ErrWrongKey
+	}
+	var key [32]byte
+	copy(key[:], plain)
+	return &key, nil
+}
+
+// encrypter is an io.WriteCloser that encrypts what is written in it. Close
+// must be called to write the last chunk, but it doesn't close the
+// underlying writer.
+type encrypter struct {
+	w       io.Writer
+	key     *[32]byte
+	nonce   [24]byte
+	counter uint64
+	buf     []byte
+}
+
+// newEncrypter writes the header of an archive, and returns an encrypter for
+// its content.
+func newEncrypter(w io.Writer, key *[32]byte, sealedKey []byte) (*encrypter, error) {
+	if len(sealedKey) > 0xffff {
+		return nil, errors.New("backup: sealed key is too long")
+	}
+	header := make([]byte, 0, len(archiveMagic)+2+len(sealedKey)+prefixLen)
+	header = append(header, archiveMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(sealedKey)))
+	header = append(header, sealedKey...)
+	e := &encrypter{w: w, key: key, buf: make([]byte, 0, chunkSize)}
+	if _, err := io.ReadFull(rand.Reader, e.nonce[:prefixLen]); err != nil {
+		return nil, err
+	}
+	header = append(header, e.nonce[:prefixLen]...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encrypter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encrypter) flush(last bool) error {
+	setNonce(&e.nonce, e.counter, last)
+	e.counter++
+	sealed := make([]byte, 4, 4+len(e.buf)+secretbox.Overhead)
+	sealed = secretbox.Seal(sealed, e.buf, &e.nonce, e.key)
+	binary.BigEndian.PutUint32(sealed[:4], uint32(len(sealed)-4))
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func setNonce(nonce *[24]byte, counter uint64, last bool) {
+	binary.BigEndian.PutUint64(nonce[prefixLen:prefixLen+8], counter)
+	nonce[23] = 0
+	if last {
+		nonce[23] = 1
+	}
+}
+
+// decrypter is an io.Reader for the content of an encrypted archive.
+type decrypter struct {
+	r       io.Reader
+	key     *[32]byte
+	nonce   [24]byte
+	counter uint64
+	sealed  []byte
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// readSealedKey reads the header of an archive, and returns the sealed key.
+func readSealedKey(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(archiveMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrCorrupted
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrCorrupted
+	}
+	sealedKey := make([]byte, binary.BigEndian.Uint16(header[len(archiveMagic):]))
+	if _, err := io.ReadFull(r, sealedKey); err != nil {
+		return nil, ErrCorrupted
+	}
+	return sealedKey, nil
+}
+
+// newDecrypter returns a decrypter for the content of an archive, after its
+// header has been read.
+func newDecrypter(r io.Reader, key *[32]byte) (*decrypter, error) {
+	d := &decrypter{r: r, key: key, sealed: make([]byte, 0, chunkSize+secretbox.Overhead)}
+	if _, err := io.ReadFull(r, d.nonce[:prefixLen]); err != nil {
+		return nil, ErrCorrupted
+	}
+	return d, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decrypter) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return ErrCorrupted
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length < secretbox.Overhead || length > chunkSize+secretbox.Overhead {
+		return ErrCorrupted
+	}
+	d.sealed = d.sealed[:length]
+	if _, err := io.ReadFull(d.r, d.sealed); err != nil {
+		return ErrCorrupted
+	}
+	for _, last := range []bool{false, true} {
+		setNonce(&d.nonce, d.counter, last)
+		if plain, ok := secretbox.Open(d.buf[:0], d.sealed, &d.nonce, d.key); ok {
+			d.buf = plain
+			d.plain = plain
+			d.counter++
+			d.done = last
+			return nil
+		}
+	}
+	return ErrCorrupted
+}
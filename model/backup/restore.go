@@ -0,0 +1,479 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/move"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/keyring"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// restoreBatchSize is the number of documents sent to CouchDB in a request.
+const restoreBatchSize = 100
+
+// RestoreOptions is the message of the restore worker.
+type RestoreOptions struct {
+	SnapshotID string `json:"snapshot_id"`
+	// DecryptorKey is the key for decrypting the snapshots. It is encrypted
+	// with the vault key of the stack, as the message is saved in CouchDB.
+	DecryptorKey string `json:"decryptor_key"`
+}
+
+// NewRestoreOptions checks that the snapshot can be restored with the given
+// decryptor key, and returns the options for the restore worker.
+func NewRestoreOptions(inst *instance.Instance, snapshotID string, encodedKey []byte) (*RestoreOptions, error) {
+	decryptorKey, err := keyring.UnmarshalNACLKey(encodedKey)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	if _, _, err := restorable(inst, snapshotID, decryptorKey); err != nil {
+		return nil, err
+	}
+	sealed, err := account.EncryptCredentialsData(string(encodedKey))
+	if err != nil {
+		return nil, err
+	}
+	return &RestoreOptions{SnapshotID: snapshotID, DecryptorKey: sealed}, nil
+}
+
+// Key returns the decryptor key of the options.
+func (o *RestoreOptions) Key() (*keyring.NACLKey, error) {
+	data, err := account.DecryptCredentialsData(o.DecryptorKey)
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := data.(string)
+	if !ok {
+		return nil, ErrWrongKey
+	}
+	return keyring.UnmarshalNACLKey([]byte(encoded))
+}
+
+// Restore resets the instance, and puts back the documents and files as they
+// were when the given snapshot has been taken.
+//
+// The snapshots of the chain are read from the most recent to the full
+// snapshot, so that only the last version of each document is restored,
+// with its revision. The files are then created, with the contents from the
+// snapshots where they have been stored.
+func Restore(inst *instance.Instance, snapshotID string, decryptorKey *keyring.NACLKey, archiver move.Archiver) error {
+	target, snapshots, err := restorable(inst, snapshotID, decryptorKey)
+	if err != nil {
+		return err
+	}
+	state, err := loadState(inst, archiver, target)
+	if err != nil {
+		return err
+	}
+
+	if err = move.GetStore().SetAllowDeleteAccounts(inst); err != nil {
+		return err
+	}
+	if err = lifecycle.Reset(inst); err != nil {
+		return err
+	}
+	if err = move.GetStore().ClearAllowDeleteAccounts(inst); err != nil {
+		return err
+	}
+
+	r := &restorer{
+		inst:         inst,
+		fs:           inst.VFS(),
+		archiver:     archiver,
+		decryptorKey: decryptorKey,
+		state:        state,
+		doctypes:     target.Sequences,
+		seen:         make(map[string]struct{}),
+		files:        make(map[string][]*vfs.FileDoc),
+		versions:     make(map[string][]*vfs.Version),
+	}
+	var errm error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if err := r.readDocs(snapshots[i]); err != nil {
+			return err
+		}
+	}
+	if err := r.flush(); err != nil {
+		errm = multierror.Append(errm, err)
+	}
+	if err := r.createDirs(); err != nil {
+		errm = multierror.Append(errm, err)
+	}
+	for _, s := range snapshots {
+		if err := r.readBlobs(s); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	for md5 := range r.files {
+		inst.Logger().WithNamespace("backup").
+			Warnf("Missing content %s for %d file(s)", md5, len(r.files[md5]))
+	}
+	if err := r.addTriggers(); err != nil {
+		errm = multierror.Append(errm, err)
+	}
+
+	now := time.Now().UTC()
+	target.RestoredAt = &now
+	if err := couchdb.UpdateDoc(prefixer.GlobalPrefixer, target); err != nil {
+		errm = multierror.Append(errm, err)
+	}
+	return errm
+}
+
+// restorable returns the snapshot, and the chain of snapshots needed to
+// restore it, if it can be restored with the given key.
+func restorable(inst *instance.Instance, snapshotID string, decryptorKey *keyring.NACLKey) (*Snapshot, []*Snapshot, error) {
+	target, err := Get(snapshotID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if target.Domain != inst.Domain {
+		return nil, nil, ErrNotFound
+	}
+	if target.State != StateDone {
+		return nil, nil, ErrNotRestorable
+	}
+	if id, err := decryptorKeyID(decryptorKey); err != nil || id != target.KeyID {
+		return nil, nil, ErrWrongKey
+	}
+	snapshots, err := chain(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return target, snapshots, nil
+}
+
+type restorer struct {
+	inst         *instance.Instance
+	fs           vfs.VFS
+	archiver     move.Archiver
+	decryptorKey *keyring.NACLKey
+	state        *vfsState
+	doctypes     map[string]string
+
+	// seen is the set of the documents that have already been found in a
+	// more recent snapshot, by doctype and identifier.
+	seen     map[string]struct{}
+	doctype  string
+	docs     []map[string]interface{}
+	dirs     []*vfs.DirDoc
+	files    map[string][]*vfs.FileDoc
+	versions map[string][]*vfs.Version
+	triggers []*job.TriggerInfos
+}
+
+// open returns a tar reader for the archive of a snapshot.
+func (r *restorer) open(s *Snapshot) (*tar.Reader, func(), error) {
+	in, err := r.archiver.OpenArchive(r.inst, s.archive(""))
+	if err != nil {
+		return nil, nil, err
+	}
+	sealedKey, err := readSealedKey(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	key, err := openKey(r.decryptorKey, sealedKey)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	dec, err := newDecrypter(in, key)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	gr, err := gzip.NewReader(dec)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	closer := func() {
+		_ = gr.Close()
+		_ = in.Close()
+	}
+	return tar.NewReader(gr), closer, nil
+}
+
+// readDocs restores the documents of a snapshot that have not been found in
+// a more recent snapshot. It stops at the contents of the files.
+func (r *restorer) readDocs(s *Snapshot) error {
+	tr, closer, err := r.open(s)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(hdr.Name, blobsDir+"/") {
+			return nil
+		}
+		parts := strings.SplitN(hdr.Name, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		doctype, err := url.PathUnescape(parts[0])
+		if err != nil {
+			return err
+		}
+		id := strings.TrimSuffix(parts[1], ".json")
+		key := doctype + "/" + id
+		if _, ok := r.seen[key]; ok {
+			continue
+		}
+		r.seen[key] = struct{}{}
+		if _, ok := r.doctypes[doctype]; !ok || skipped(doctype, id) {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := json.NewDecoder(tr).Decode(&doc); err != nil {
+			return err
+		}
+		if deleted, _ := doc["_deleted"].(bool); deleted {
+			continue
+		}
+		if err := r.add(doctype, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// skipped returns true for the documents that are not restored: the settings
+// are kept from the instance, the sessions and jobs are outdated, and the
+// indexes are created by the stack.
+func skipped(doctype, id string) bool {
+	switch doctype {
+	case consts.Sessions, consts.Jobs:
+		return true
+	case consts.Settings:
+		return id == consts.InstanceSettingsID || id == consts.BitwardenSettingsID
+	}
+	return strings.HasPrefix(id, "_design/")
+}
+
+func (r *restorer) add(doctype string, doc map[string]interface{}) error {
+	switch doctype {
+	case consts.Files, consts.FilesVersions, consts.Triggers:
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return r.addVFSOrTrigger(doctype, raw)
+	}
+
+	if doctype != r.doctype || len(r.docs) >= restoreBatchSize {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+	r.doctype = doctype
+	r.docs = append(r.docs, doc)
+	return nil
+}
+
+func (r *restorer) addVFSOrTrigger(doctype string, raw []byte) error {
+	switch doctype {
+	case consts.Triggers:
+		// The triggers are added at the end, to register them in the
+		// scheduler without creating jobs for the restored files.
+		infos := &job.TriggerInfos{}
+		if err := json.Unmarshal(raw, infos); err != nil {
+			return err
+		}
+		r.triggers = append(r.triggers, infos)
+	case consts.FilesVersions:
+		version := &vfs.Version{}
+		if err := json.Unmarshal(raw, version); err != nil {
+			return err
+		}
+		md5 := r.state.Contents[version.DocID]
+		r.versions[md5] = append(r.versions[md5], version)
+	default:
+		var doc vfs.DirOrFileDoc
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		dir, file := doc.Refine()
+		if dir != nil {
+			if dir.DocID != consts.RootDirID && dir.DocID != consts.TrashDirID {
+				r.dirs = append(r.dirs, dir)
+			}
+			return nil
+		}
+		md5 := r.state.Contents[file.DocID]
+		r.files[md5] = append(r.files[md5], file)
+	}
+	return nil
+}
+
+func (r *restorer) flush() error {
+	if len(r.docs) == 0 {
+		return nil
+	}
+	if err := couchdb.EnsureDBExist(r.inst, r.doctype); err != nil {
+		return err
+	}
+	if err := couchdb.BulkForceUpdateDocs(r.inst, r.doctype, r.docs); err != nil {
+		return err
+	}
+	r.doctype = ""
+	r.docs = nil
+	return nil
+}
+
+// createDirs creates the directories, the parents before their children.
+func (r *restorer) createDirs() error {
+	sort.Slice(r.dirs, func(i, j int) bool {
+		return r.dirs[i].Fullpath < r.dirs[j].Fullpath
+	})
+	var errm error
+	for _, dir := range r.dirs {
+		dir.SetRev("")
+		if err := r.fs.CreateDir(dir); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
+
+// readBlobs creates the files and versions with the contents of a snapshot.
+func (r *restorer) readBlobs(s *Snapshot) error {
+	needed := false
+	for md5, id := range r.state.Blobs {
+		if id == s.DocID && (len(r.files[md5]) > 0 || len(r.versions[md5]) > 0) {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return nil
+	}
+
+	tr, closer, err := r.open(s)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	var errm error
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return errm
+		}
+		if err != nil {
+			return multierror.Append(errm, err)
+		}
+		md5, ok := strings.CutPrefix(hdr.Name, blobsDir+"/")
+		if !ok {
+			continue
+		}
+		if err := r.restoreBlob(md5, tr); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+}
+
+// restoreBlob writes a content for the files and versions that have it. When
+// there are several of them, the content is first copied in a temporary
+// file.
+func (r *restorer) restoreBlob(md5 string, content io.Reader) error {
+	files, versions := r.files[md5], r.versions[md5]
+	delete(r.files, md5)
+	delete(r.versions, md5)
+	if len(files)+len(versions) == 0 {
+		return nil
+	}
+	if len(files)+len(versions) == 1 {
+		if len(files) == 1 {
+			return r.createFile(files[0], content)
+		}
+		return r.createVersion(versions[0], content)
+	}
+
+	tmp, err := os.CreateTemp("", "backup-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := io.Copy(tmp, content); err != nil {
+		return err
+	}
+	var errm error
+	for _, file := range files {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := r.createFile(file, tmp); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	for _, version := range versions {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := r.createVersion(version, tmp); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
+
+func (r *restorer) createFile(doc *vfs.FileDoc, content io.Reader) error {
+	doc.SetRev("")
+	f, err := r.fs.CreateFile(doc, nil, vfs.AllowCreationInTrash)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, content)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	return err
+}
+
+func (r *restorer) createVersion(version *vfs.Version, content io.Reader) error {
+	version.SetRev("")
+	return r.fs.ImportFileVersion(version, io.NopCloser(content))
+}
+
+func (r *restorer) addTriggers() error {
+	var errm error
+	for _, infos := range r.triggers {
+		infos.SetRev("")
+		t, err := job.NewTrigger(r.inst, *infos, nil)
+		if err != nil {
+			errm = multierror.Append(errm, err)
+			continue
+		}
+		if err = job.System().AddTrigger(t); err != nil && !couchdb.IsConflictError(err) {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/move"
+)
+
+// stateSuffix is the suffix of the archive for the state of the VFS.
+const stateSuffix = "-state"
+
+// vfsState is the state of the VFS when a snapshot has been taken. It is used
+// to know which contents must be added to the next snapshot, and where to
+// find the contents when a snapshot is restored.
+type vfsState struct {
+	// Contents is the md5sum (in hexadecimal) of the content of each file and
+	// version, by identifier.
+	Contents map[string]string `json:"contents"`
+	// Blobs is the identifier of the snapshot where a content has been
+	// stored, by md5sum.
+	Blobs map[string]string `json:"blobs"`
+}
+
+func newVFSState() *vfsState {
+	return &vfsState{
+		Contents: make(map[string]string),
+		Blobs:    make(map[string]string),
+	}
+}
+
+// saveState writes the state of the VFS after the given snapshot. It is
+// encrypted with the state key of the snapshot, as the stack must be able to
+// read it for the next snapshot.
+func saveState(archiver move.Archiver, s *Snapshot, state *vfsState) error {
+	out, err := archiver.CreateArchive(s.archive(stateSuffix))
+	if err != nil {
+		return err
+	}
+	var key [32]byte
+	copy(key[:], s.StateKey)
+	enc, err := newEncrypter(out, &key, nil)
+	if err == nil {
+		gw := gzip.NewWriter(enc)
+		err = json.NewEncoder(gw).Encode(state)
+		if errc := gw.Close(); err == nil {
+			err = errc
+		}
+		if errc := enc.Close(); err == nil {
+			err = errc
+		}
+	}
+	if errc := out.Close(); err == nil {
+		err = errc
+	}
+	return err
+}
+
+// loadState reads the state of the VFS after the given snapshot.
+func loadState(inst *instance.Instance, archiver move.Archiver, s *Snapshot) (*vfsState, error) {
+	if len(s.StateKey) != 32 {
+		return nil, errors.New("backup: invalid state key")
+	}
+	in, err := archiver.OpenArchive(inst, s.archive(stateSuffix))
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	if _, err := readSealedKey(in); err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], s.StateKey)
+	dec, err := newDecrypter(in, &key)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(dec)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	state := newVFSState()
+	if err := json.NewDecoder(gr).Decode(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
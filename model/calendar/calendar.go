@@ -0,0 +1,271 @@
+// Package calendar is for the calendars of the users, with their events and
+// tasks. They can be synchronized with CalDAV, imported and exported in the
+// iCalendar format, and shared with the sharing rules.
+package calendar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// DefaultName is the name of the calendar created for the users that have
+// none.
+const DefaultName = "Calendar"
+
+// validID is the format of the identifiers that can be chosen for a calendar.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// perPage is the number of documents fetched by request to CouchDB.
+const perPage = 1000
+
+var (
+	// ErrInvalidCalendar is used when a calendar has no name.
+	ErrInvalidCalendar = errors.New("Invalid calendar")
+	// ErrInvalidEvent is used when an event or a task is not valid.
+	ErrInvalidEvent = errors.New("Invalid event")
+	// ErrInvalidObject is used when an iCalendar object can't be stored as an
+	// event or a task.
+	ErrInvalidObject = errors.New("Invalid iCalendar object")
+	// ErrUIDConflict is used when another resource of the calendar has the
+	// same UID.
+	ErrUIDConflict = errors.New("Another resource has the same UID")
+)
+
+// Calendar is a collection of events and tasks.
+type Calendar struct {
+	DocID       string    `json:"_id,omitempty"`
+	DocRev      string    `json:"_rev,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Color       string    `json:"color,omitempty"`
+	Timezone    string    `json:"timezone,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ID returns the calendar qualified identifier
+func (c *Calendar) ID() string { return c.DocID }
+
+// Rev returns the calendar revision
+func (c *Calendar) Rev() string { return c.DocRev }
+
+// DocType returns the calendar document type
+func (c *Calendar) DocType() string { return consts.Calendars }
+
+// Clone implements couchdb.Doc
+func (c *Calendar) Clone() couchdb.Doc {
+	cloned := *c
+	return &cloned
+}
+
+// SetID changes the calendar qualified identifier
+func (c *Calendar) SetID(id string) { c.DocID = id }
+
+// SetRev changes the calendar revision
+func (c *Calendar) SetRev(rev string) { c.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (c *Calendar) Fetch(field string) []string {
+	if field == "name" {
+		return []string{c.Name}
+	}
+	return nil
+}
+
+// Location returns the timezone of the calendar, used for the floating times.
+func (c *Calendar) Location() *time.Location {
+	return loadLocation(c.Timezone)
+}
+
+func (c *Calendar) validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return ErrInvalidCalendar
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return ErrInvalidCalendar
+		}
+	}
+	return nil
+}
+
+// Create saves a new calendar. The identifier can be chosen by the caller,
+// like the CalDAV clients do with MKCALENDAR.
+func Create(inst *instance.Instance, c *Calendar) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	c.DocRev = ""
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	if c.DocID != "" {
+		if !validID.MatchString(c.DocID) {
+			return ErrInvalidCalendar
+		}
+		return couchdb.CreateNamedDocWithDB(inst, c)
+	}
+	return couchdb.CreateDoc(inst, c)
+}
+
+// Update saves the changes of a calendar.
+func (c *Calendar) Update(inst *instance.Instance) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	c.UpdatedAt = time.Now().UTC()
+	return couchdb.UpdateDoc(inst, c)
+}
+
+// Delete removes the calendar with its events and tasks.
+func (c *Calendar) Delete(inst *instance.Instance) error {
+	for _, doctype := range []string{consts.CalendarEvents, consts.CalendarTodos} {
+		for {
+			var docs []couchdb.JSONDoc
+			req := &couchdb.FindRequest{
+				UseIndex: "by-calendar-and-uid",
+				Selector: mango.Equal("calendar_id", c.DocID),
+				Limit:    perPage,
+			}
+			err := couchdb.FindDocs(inst, doctype, req, &docs)
+			if couchdb.IsNoDatabaseError(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if len(docs) == 0 {
+				break
+			}
+			toDelete := make([]couchdb.Doc, len(docs))
+			for i := range docs {
+				docs[i].Type = doctype
+				toDelete[i] = &docs[i]
+			}
+			if err := couchdb.BulkDeleteDocs(inst, doctype, toDelete); err != nil {
+				return err
+			}
+		}
+	}
+	return couchdb.DeleteDoc(inst, c)
+}
+
+// Get returns the calendar with the given identifier.
+func Get(inst *instance.Instance, id string) (*Calendar, error) {
+	c := &Calendar{}
+	if err := couchdb.GetDoc(inst, consts.Calendars, id, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// List returns the calendars of the instance, sorted by name.
+func List(inst *instance.Instance) ([]*Calendar, error) {
+	var calendars []*Calendar
+	err := couchdb.GetAllDocs(inst, consts.Calendars, nil, &calendars)
+	if couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars, nil
+}
+
+// EnsureDefault returns the calendars of the instance, after creating a
+// default calendar if there is none.
+func EnsureDefault(inst *instance.Instance) ([]*Calendar, error) {
+	calendars, err := List(inst)
+	if err != nil || len(calendars) > 0 {
+		return calendars, err
+	}
+	c := &Calendar{Name: DefaultName}
+	if doc, err := inst.SettingsDocument(); err == nil {
+		if tz, ok := doc.M["tz"].(string); ok {
+			if _, err := time.LoadLocation(tz); err == nil {
+				c.Timezone = tz
+			}
+		}
+	}
+	if err := Create(inst, c); err != nil {
+		return nil, err
+	}
+	return []*Calendar{c}, nil
+}
+
+// CTag returns a tag that changes each time an event or a task of the
+// calendar is created, updated or deleted. It is used by the CalDAV clients
+// to know if they have to synchronize the calendar. The documents written via
+// the data API and the sharings are taken into account.
+func (c *Calendar) CTag(inst *instance.Instance) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(c.DocRev))
+	for _, doctype := range []string{consts.CalendarEvents, consts.CalendarTodos} {
+		revs, err := listRevs(inst, doctype, c.DocID)
+		if err != nil {
+			return "", err
+		}
+		for _, rev := range revs {
+			h.Write([]byte(rev))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// listRevs returns the identifiers and revisions of the documents of a
+// calendar, sorted.
+func listRevs(inst *instance.Instance, doctype, calendarID string) ([]string, error) {
+	var revs []string
+	var bookmark string
+	for {
+		var docs []struct {
+			ID  string `json:"_id"`
+			Rev string `json:"_rev"`
+		}
+		req := &couchdb.FindRequest{
+			UseIndex: "by-calendar-and-uid",
+			Selector: mango.Equal("calendar_id", calendarID),
+			Fields:   []string{"_id", "_rev"},
+			Limit:    perPage,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(inst, doctype, req, &docs)
+		if couchdb.IsNoDatabaseError(err) {
+			return revs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			revs = append(revs, doc.ID+"/"+doc.Rev)
+		}
+		if len(docs) < perPage {
+			break
+		}
+		bookmark = res.Bookmark
+	}
+	sort.Strings(revs)
+	return revs, nil
+}
+
+func loadLocation(tz string) *time.Location {
+	if tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
@@ -0,0 +1,196 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const meeting = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting@example.org\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261019T100000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"SUMMARY:Weekly meeting\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=5\r\n" +
+	"EXDATE;TZID=Europe/Paris:20261102T100000\r\n" +
+	"ORGANIZER;CN=Jane:mailto:jane@example.org\r\n" +
+	"ATTENDEE;PARTSTAT=TENTATIVE;RSVP=TRUE:mailto:john@example.org\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting@example.org\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Paris:20261026T100000\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261027T140000\r\n" +
+	"DTEND;TZID=Europe/Paris:20261027T150000\r\n" +
+	"SUMMARY:Moved meeting\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:todo@example.org\r\n" +
+	"SUMMARY:Prepare the slides\r\n" +
+	"DUE;VALUE=DATE:20261023\r\n" +
+	"PRIORITY:1\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func parseObjects(t *testing.T, s string) []*Object {
+	vcal, err := ical.Parse(strings.NewReader(s))
+	require.NoError(t, err)
+	objects, err := readObjects(vcal, time.UTC)
+	require.NoError(t, err)
+	return objects
+}
+
+func TestReadObjects(t *testing.T) {
+	objects := parseObjects(t, meeting)
+	require.Len(t, objects, 2)
+
+	e := objects[0].Event
+	require.NotNil(t, e)
+	e.CalendarID = "cal"
+	require.NoError(t, e.prepare(&Calendar{DocID: "cal"}))
+	assert.Equal(t, "meeting@example.org", e.UID)
+	assert.Equal(t, "meeting@example.org.ics", e.Filename)
+	assert.Equal(t, "Europe/Paris", e.Timezone)
+	assert.Equal(t, time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC), e.Start)
+	assert.Equal(t, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), e.End)
+	assert.Equal(t, "FREQ=WEEKLY;COUNT=5", e.RRule)
+	assert.Equal(t, "jane@example.org", e.Organizer.Email)
+	require.Len(t, e.Attendees, 1)
+	assert.True(t, e.Attendees[0].RSVP)
+	require.Len(t, e.Alarms, 1)
+	assert.Equal(t, "-PT15M", e.Alarms[0].Trigger)
+	require.Len(t, e.Exceptions, 1)
+	assert.Equal(t, "Moved meeting", e.Exceptions[0].Summary)
+	// The last occurrence is on 16 November, after the change of time
+	assert.Equal(t, time.Date(2026, 11, 16, 10, 0, 0, 0, time.UTC), e.RangeEnd)
+
+	todo := objects[1].Todo
+	require.NotNil(t, todo)
+	assert.True(t, todo.AllDay)
+	require.NotNil(t, todo.Due)
+	assert.Equal(t, time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC), *todo.Due)
+	assert.Equal(t, 1, todo.Priority)
+
+	_, err := readObjects(ical.NewComponent("VEVENT"), time.UTC)
+	assert.Equal(t, ErrInvalidObject, err)
+}
+
+func TestWriteObject(t *testing.T) {
+	objects := parseObjects(t, meeting)
+	var buf bytes.Buffer
+	require.NoError(t, WriteObject(&buf, objects[0]))
+	out := buf.String()
+	assert.Contains(t, out, "PRODID:"+ProdID)
+	assert.Contains(t, out, "BEGIN:VTIMEZONE\r\nTZID:Europe/Paris")
+	assert.Contains(t, out, "DTSTART;TZID=Europe/Paris:20261019T100000")
+	assert.Contains(t, out, "RECURRENCE-ID;TZID=Europe/Paris:20261026T100000")
+
+	again := parseObjects(t, out)
+	require.Len(t, again, 1)
+	assert.Equal(t, objects[0].Event.Start, again[0].Event.Start)
+	assert.Equal(t, objects[0].Event.End, again[0].Event.End)
+	assert.Equal(t, objects[0].Event.ExDates, again[0].Event.ExDates)
+	assert.Equal(t, objects[0].Event.Exceptions, again[0].Event.Exceptions)
+	assert.Equal(t, objects[0].Event.Attendees, again[0].Event.Attendees)
+
+	buf.Reset()
+	require.NoError(t, WriteObject(&buf, objects[1]))
+	assert.Contains(t, buf.String(), "DUE;VALUE=DATE:20261023")
+}
+
+func TestOccurrences(t *testing.T) {
+	e := parseObjects(t, meeting)[0].Event
+	e.CalendarID = "cal"
+	require.NoError(t, e.prepare(&Calendar{DocID: "cal"}))
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	occurrences := e.Occurrences(from, to)
+	var starts []string
+	for _, occ := range occurrences {
+		starts = append(starts, occ.Start.Format(time.RFC3339)+" "+occ.Summary)
+	}
+	assert.Equal(t, []string{
+		"2026-10-19T08:00:00Z Weekly meeting",
+		"2026-10-27T13:00:00Z Moved meeting",
+		"2026-11-09T09:00:00Z Weekly meeting",
+		"2026-11-16T09:00:00Z Weekly meeting",
+	}, starts)
+
+	// An occurrence that has started before the range is included
+	from = time.Date(2026, 11, 9, 9, 30, 0, 0, time.UTC)
+	to = time.Date(2026, 11, 10, 0, 0, 0, 0, time.UTC)
+	occurrences = e.Occurrences(from, to)
+	require.Len(t, occurrences, 1)
+	assert.Equal(t, time.Date(2026, 11, 9, 9, 0, 0, 0, time.UTC), *occurrences[0].RecurrenceID)
+
+	allDay := &Event{CalendarID: "cal", Start: time.Date(2026, 10, 20, 15, 0, 0, 0, time.UTC), AllDay: true}
+	require.NoError(t, allDay.prepare(nil))
+	assert.Equal(t, time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC), allDay.End)
+	assert.Len(t, allDay.Occurrences(from.AddDate(0, 0, -30), to), 1)
+	assert.Len(t, allDay.Occurrences(to, to.AddDate(0, 0, 1)), 0)
+
+	invalid := &Event{CalendarID: "cal", Start: to, End: from}
+	assert.Equal(t, ErrInvalidEvent, invalid.prepare(nil))
+	invalid = &Event{CalendarID: "cal", Start: from, RRule: "COUNT=2"}
+	assert.Equal(t, ErrInvalidEvent, invalid.prepare(nil))
+}
+
+func TestBusyPeriods(t *testing.T) {
+	day := func(h, m int) time.Time { return time.Date(2026, 10, 19, h, m, 0, 0, time.UTC) }
+	events := []*Event{
+		{Start: day(9, 0), End: day(10, 0)},
+		{Start: day(9, 30), End: day(11, 0), Status: StatusConfirmed},
+		{Start: day(11, 0), End: day(11, 30)},
+		{Start: day(14, 0), End: day(15, 0), Status: StatusTentative},
+		{Start: day(16, 0), End: day(17, 0), Transparency: TransparencyTransparent},
+		{Start: day(17, 0), End: day(18, 0), Status: StatusCancelled},
+		{Start: day(19, 0), End: day(23, 0)},
+	}
+	periods := mergePeriods(busyPeriods(events, time.UTC, day(8, 0), day(20, 0)))
+	require.Len(t, periods, 3)
+	assert.Equal(t, &Period{Start: day(9, 0), End: day(11, 30), Type: FreeBusyBusy}, periods[0])
+	assert.Equal(t, &Period{Start: day(14, 0), End: day(15, 0), Type: FreeBusyTentative}, periods[1])
+	assert.Equal(t, &Period{Start: day(19, 0), End: day(20, 0), Type: FreeBusyBusy}, periods[2])
+
+	vcal := FreeBusyComponent(periods, day(8, 0), day(20, 0))
+	fb := vcal.Children("VFREEBUSY")
+	require.Len(t, fb, 1)
+	props := fb[0].GetAll("FREEBUSY")
+	require.Len(t, props, 3)
+	assert.Equal(t, "20261019T090000Z/20261019T113000Z", props[0].Value)
+	assert.Equal(t, FreeBusyTentative, props[1].Param("FBTYPE"))
+
+	// The all-day events use the timezone of the calendar
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	holiday := &Event{Start: day(0, 0), End: day(0, 0).AddDate(0, 0, 1), AllDay: true}
+	periods = busyPeriods([]*Event{holiday}, paris, day(0, 0).AddDate(0, 0, -1), day(0, 0).AddDate(0, 0, 2))
+	require.Len(t, periods, 1)
+	assert.Equal(t, day(0, 0).Add(-2*time.Hour), periods[0].Start)
+}
+
+func TestSharingRules(t *testing.T) {
+	cal := &Calendar{DocID: "cal-id", Name: "Family"}
+	rules := SharingRules(cal, true)
+	require.Len(t, rules, 3)
+	assert.Equal(t, consts.Calendars, rules[0].DocType)
+	assert.Equal(t, "Family", rules[0].Title)
+	assert.Equal(t, consts.CalendarEvents, rules[1].DocType)
+	assert.Equal(t, "calendar_id", rules[1].Selector)
+	assert.Equal(t, []string{"cal-id"}, rules[2].Values)
+	assert.Equal(t, "sync", rules[2].Update)
+	assert.Equal(t, "push", SharingRules(cal, false)[0].Add)
+}
@@ -0,0 +1,549 @@
+package calendar
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/ical"
+	"github.com/gofrs/uuid/v5"
+)
+
+// maxOccurrences is the maximal number of occurrences of a recurring event
+// that are returned for a time range.
+const maxOccurrences = 1000
+
+// farFuture is used as the end of the infinite recurrences.
+var farFuture = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+const (
+	// StatusTentative is for an event that is not confirmed.
+	StatusTentative = "TENTATIVE"
+	// StatusConfirmed is for a confirmed event.
+	StatusConfirmed = "CONFIRMED"
+	// StatusCancelled is for a cancelled event or task.
+	StatusCancelled = "CANCELLED"
+
+	// TransparencyOpaque is for an event that blocks the time of the user.
+	TransparencyOpaque = "OPAQUE"
+	// TransparencyTransparent is for an event that does not make the user
+	// busy.
+	TransparencyTransparent = "TRANSPARENT"
+)
+
+// Attendee is the organizer or a participant of an event.
+type Attendee struct {
+	Email  string `json:"email"`
+	Name   string `json:"name,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status,omitempty"`
+	RSVP   bool   `json:"rsvp,omitempty"`
+}
+
+// Alarm is a reminder for an event or a task. The trigger is a duration
+// relative to the start (like -PT15M), or an absolute UTC date-time.
+type Alarm struct {
+	Action      string `json:"action"`
+	Trigger     string `json:"trigger"`
+	Description string `json:"description,omitempty"`
+}
+
+// Exception is an occurrence of a recurring event that has been modified or
+// cancelled.
+type Exception struct {
+	RecurrenceID time.Time `json:"recurrence_id"`
+	Cancelled    bool      `json:"cancelled,omitempty"`
+	Summary      string    `json:"summary,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	Location     string    `json:"location,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Status       string    `json:"status,omitempty"`
+}
+
+// Event is an event of a calendar. The start and end are stored in UTC, and
+// the timezone is used for the recurrences. For the all-day events, the end
+// is exclusive (the day after the last day of the event).
+type Event struct {
+	DocID        string       `json:"_id,omitempty"`
+	DocRev       string       `json:"_rev,omitempty"`
+	CalendarID   string       `json:"calendar_id"`
+	UID          string       `json:"uid"`
+	Filename     string       `json:"filename,omitempty"`
+	Summary      string       `json:"summary,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	Location     string       `json:"location,omitempty"`
+	Start        time.Time    `json:"start"`
+	End          time.Time    `json:"end"`
+	AllDay       bool         `json:"all_day,omitempty"`
+	Timezone     string       `json:"timezone,omitempty"`
+	Status       string       `json:"status,omitempty"`
+	Transparency string       `json:"transparency,omitempty"`
+	RRule        string       `json:"rrule,omitempty"`
+	ExDates      []time.Time  `json:"exdates,omitempty"`
+	RDates       []time.Time  `json:"rdates,omitempty"`
+	Exceptions   []*Exception `json:"exceptions,omitempty"`
+	Organizer    *Attendee    `json:"organizer,omitempty"`
+	Attendees    []*Attendee  `json:"attendees,omitempty"`
+	Alarms       []*Alarm     `json:"alarms,omitempty"`
+	Categories   []string     `json:"categories,omitempty"`
+	URL          string       `json:"url,omitempty"`
+	Sequence     int          `json:"sequence,omitempty"`
+	RangeEnd     time.Time    `json:"range_end"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// ID returns the event qualified identifier
+func (e *Event) ID() string { return e.DocID }
+
+// Rev returns the event revision
+func (e *Event) Rev() string { return e.DocRev }
+
+// DocType returns the event document type
+func (e *Event) DocType() string { return consts.CalendarEvents }
+
+// Clone implements couchdb.Doc
+func (e *Event) Clone() couchdb.Doc {
+	cloned := *e
+	cloned.ExDates = append([]time.Time(nil), e.ExDates...)
+	cloned.RDates = append([]time.Time(nil), e.RDates...)
+	cloned.Categories = append([]string(nil), e.Categories...)
+	cloned.Exceptions = make([]*Exception, len(e.Exceptions))
+	for i, ex := range e.Exceptions {
+		copied := *ex
+		cloned.Exceptions[i] = &copied
+	}
+	cloned.Attendees = make([]*Attendee, len(e.Attendees))
+	for i, a := range e.Attendees {
+		copied := *a
+		cloned.Attendees[i] = &copied
+	}
+	cloned.Alarms = make([]*Alarm, len(e.Alarms))
+	for i, a := range e.Alarms {
+		copied := *a
+		cloned.Alarms[i] = &copied
+	}
+	if e.Organizer != nil {
+		organizer := *e.Organizer
+		cloned.Organizer = &organizer
+	}
+	return &cloned
+}
+
+// SetID changes the event qualified identifier
+func (e *Event) SetID(id string) { e.DocID = id }
+
+// SetRev changes the event revision
+func (e *Event) SetRev(rev string) { e.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (e *Event) Fetch(field string) []string {
+	switch field {
+	case "calendar_id":
+		return []string{e.CalendarID}
+	case "uid":
+		return []string{e.UID}
+	}
+	return nil
+}
+
+// loc returns the timezone of the event.
+func (e *Event) loc() *time.Location {
+	if e.AllDay {
+		return time.UTC
+	}
+	return loadLocation(e.Timezone)
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9@._-]`)
+
+// defaultFilename returns the name of the CalDAV resource for a UID.
+func defaultFilename(uid string) string {
+	return unsafeFilenameChars.ReplaceAllString(uid, "_") + ".ics"
+}
+
+// prepare checks the event and normalizes its fields before saving it.
+func (e *Event) prepare(cal *Calendar) error {
+	if e.CalendarID == "" || e.Start.IsZero() {
+		return ErrInvalidEvent
+	}
+	if e.UID == "" {
+		e.UID = uuid.Must(uuid.NewV7()).String()
+	}
+	if e.Filename == "" {
+		e.Filename = defaultFilename(e.UID)
+	}
+	if strings.Contains(e.Filename, "/") {
+		return ErrInvalidEvent
+	}
+	if e.AllDay {
+		e.Timezone = ""
+		e.Start = truncateDay(e.Start)
+		if e.End.IsZero() {
+			e.End = e.Start.AddDate(0, 0, 1)
+		}
+		e.End = truncateDay(e.End)
+	} else {
+		if e.Timezone == "" && cal != nil {
+			e.Timezone = cal.Timezone
+		}
+		if e.Timezone != "" {
+			if _, err := time.LoadLocation(e.Timezone); err != nil {
+				return ErrInvalidEvent
+			}
+		}
+		if e.End.IsZero() {
+			e.End = e.Start
+		}
+		e.Start = e.Start.UTC()
+		e.End = e.End.UTC()
+	}
+	if e.End.Before(e.Start) {
+		return ErrInvalidEvent
+	}
+
+	e.Status = strings.ToUpper(e.Status)
+	switch e.Status {
+	case "", StatusTentative, StatusConfirmed, StatusCancelled:
+	default:
+		return ErrInvalidEvent
+	}
+	e.Transparency = strings.ToUpper(e.Transparency)
+	switch e.Transparency {
+	case "", TransparencyOpaque, TransparencyTransparent:
+	default:
+		return ErrInvalidEvent
+	}
+	if e.RRule != "" {
+		// The rules with unsupported parts are kept, to not lose data from
+		// the CalDAV clients, but only the first occurrence is expanded.
+		if _, err := ical.ParseRRule(e.RRule, e.loc()); err == ical.ErrInvalidFormat {
+			return ErrInvalidEvent
+		}
+	}
+	for i := range e.ExDates {
+		e.ExDates[i] = e.ExDates[i].UTC()
+	}
+	for i := range e.RDates {
+		e.RDates[i] = e.RDates[i].UTC()
+	}
+	for _, ex := range e.Exceptions {
+		if ex.RecurrenceID.IsZero() {
+			return ErrInvalidEvent
+		}
+		ex.RecurrenceID = ex.RecurrenceID.UTC()
+		ex.Start = ex.Start.UTC()
+		ex.End = ex.End.UTC()
+	}
+	e.RangeEnd = e.rangeEnd()
+	return nil
+}
+
+// rangeEnd returns the end of the last occurrence of the event.
+func (e *Event) rangeEnd() time.Time {
+	duration := e.End.Sub(e.Start)
+	end := e.End
+	if e.RRule != "" {
+		rule, err := ical.ParseRRule(e.RRule, e.loc())
+		if err != nil {
+			return farFuture
+		}
+		last, ok := rule.Last(e.Start.In(e.loc()))
+		if !ok {
+			return farFuture
+		}
+		end = last.Add(duration).UTC()
+	}
+	for _, rdate := range e.RDates {
+		if t := rdate.Add(duration); t.After(end) {
+			end = t
+		}
+	}
+	for _, ex := range e.Exceptions {
+		if ex.End.After(end) {
+			end = ex.End
+		}
+	}
+	return end
+}
+
+// Occurrence is an instance of an event in a time range.
+type Occurrence struct {
+	EventID      string     `json:"event_id"`
+	CalendarID   string     `json:"calendar_id"`
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+	Summary      string     `json:"summary,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Start        time.Time  `json:"start"`
+	End          time.Time  `json:"end"`
+	AllDay       bool       `json:"all_day,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Transparency string     `json:"transparency,omitempty"`
+}
+
+// Occurrences returns the occurrences of the event that overlap the [from,
+// to) time range, with the exceptions applied.
+func (e *Event) Occurrences(from, to time.Time) []*Occurrence {
+	duration := e.End.Sub(e.Start)
+	base := func(start, end time.Time) *Occurrence {
+		return &Occurrence{
+			EventID:      e.DocID,
+			CalendarID:   e.CalendarID,
+			Summary:      e.Summary,
+			Location:     e.Location,
+			Start:        start,
+			End:          end,
+			AllDay:       e.AllDay,
+			Status:       e.Status,
+			Transparency: e.Transparency,
+		}
+	}
+	overlaps := func(start, end time.Time) bool {
+		if end.Equal(start) {
+			return !start.Before(from) && start.Before(to)
+		}
+		return start.Before(to) && end.After(from)
+	}
+
+	if e.RRule == "" && len(e.RDates) == 0 {
+		if overlaps(e.Start, e.End) {
+			return []*Occurrence{base(e.Start, e.End)}
+		}
+		return nil
+	}
+
+	var starts []time.Time
+	loc := e.loc()
+	if rule, err := ical.ParseRRule(e.RRule, loc); err == nil {
+		// The occurrences that start before from can still overlap it
+		for _, t := range rule.Between(e.Start.In(loc), from.Add(-duration), to, maxOccurrences) {
+			starts = append(starts, t.UTC())
+		}
+	} else {
+		starts = append(starts, e.Start)
+	}
+	starts = append(starts, e.RDates...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	var occurrences []*Occurrence
+	for i, start := range starts {
+		if i > 0 && start.Equal(starts[i-1]) {
+			continue
+		}
+		if containsTime(e.ExDates, start) || e.exception(start) != nil {
+			continue
+		}
+		end := start.Add(duration)
+		if !overlaps(start, end) {
+			continue
+		}
+		occ := base(start, end)
+		recurrenceID := start
+		occ.RecurrenceID = &recurrenceID
+		occurrences = append(occurrences, occ)
+	}
+	for _, ex := range e.Exceptions {
+		if ex.Cancelled || !overlaps(ex.Start, ex.End) {
+			continue
+		}
+		occ := base(ex.Start, ex.End)
+		recurrenceID := ex.RecurrenceID
+		occ.RecurrenceID = &recurrenceID
+		if ex.Summary != "" {
+			occ.Summary = ex.Summary
+		}
+		if ex.Location != "" {
+			occ.Location = ex.Location
+		}
+		if ex.Status != "" {
+			occ.Status = ex.Status
+		}
+		occurrences = append(occurrences, occ)
+	}
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	if len(occurrences) > maxOccurrences {
+		occurrences = occurrences[:maxOccurrences]
+	}
+	return occurrences
+}
+
+func (e *Event) exception(recurrenceID time.Time) *Exception {
+	for _, ex := range e.Exceptions {
+		if ex.RecurrenceID.Equal(recurrenceID) {
+			return ex
+		}
+	}
+	return nil
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, x := range times {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// SaveEvent creates or updates an event.
+func SaveEvent(inst *instance.Instance, e *Event) error {
+	cal, err := Get(inst, e.CalendarID)
+	if err != nil {
+		return err
+	}
+	if err := e.prepare(cal); err != nil {
+		return err
+	}
+	if err := checkUID(inst, consts.CalendarEvents, e.CalendarID, e.UID, e.DocID); err != nil {
+		return err
+	}
+	e.UpdatedAt = time.Now().UTC()
+	if e.DocRev == "" {
+		e.CreatedAt = e.UpdatedAt
+		if e.DocID != "" {
+			return couchdb.CreateNamedDocWithDB(inst, e)
+		}
+		return couchdb.CreateDoc(inst, e)
+	}
+	return couchdb.UpdateDoc(inst, e)
+}
+
+// DeleteEvent removes an event.
+func DeleteEvent(inst *instance.Instance, e *Event) error {
+	return couchdb.DeleteDoc(inst, e)
+}
+
+// GetEvent returns the event with the given identifier.
+func GetEvent(inst *instance.Instance, id string) (*Event, error) {
+	e := &Event{}
+	if err := couchdb.GetDoc(inst, consts.CalendarEvents, id, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// FindEvents returns the events of a calendar with at least one occurrence
+// in the [from, to) time range.
+func FindEvents(inst *instance.Instance, calendarID string, from, to time.Time) ([]*Event, error) {
+	var events []*Event
+	var bookmark string
+	for {
+		var page []*Event
+		req := &couchdb.FindRequest{
+			UseIndex: "by-calendar-and-start",
+			Selector: mango.And(
+				mango.Equal("calendar_id", calendarID),
+				mango.Lt("start", to.UTC()),
+			),
+			Limit:    perPage,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(inst, consts.CalendarEvents, req, &page)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range page {
+			// The range_end is computed again, as the event may have been
+			// written by the data API or a sharing.
+			if e.rangeEnd().After(from) || e.RangeEnd.After(from) {
+				events = append(events, e)
+			}
+		}
+		if len(page) < perPage {
+			return events, nil
+		}
+		bookmark = res.Bookmark
+	}
+}
+
+// FindAllEvents returns all the events of a calendar.
+func FindAllEvents(inst *instance.Instance, calendarID string) ([]*Event, error) {
+	var events []*Event
+	err := findAll(inst, consts.CalendarEvents, calendarID, func() interface{} {
+		var page []*Event
+		return &page
+	}, func(page interface{}) int {
+		list := *page.(*[]*Event)
+		events = append(events, list...)
+		return len(list)
+	})
+	return events, err
+}
+
+// findAll calls the fill function for each page of the documents of a
+// calendar.
+func findAll(inst *instance.Instance, doctype, calendarID string, newPage func() interface{}, fill func(interface{}) int) error {
+	var bookmark string
+	for {
+		page := newPage()
+		req := &couchdb.FindRequest{
+			UseIndex: "by-calendar-and-uid",
+			Selector: mango.Equal("calendar_id", calendarID),
+			Limit:    perPage,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(inst, doctype, req, page)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fill(page) < perPage {
+			return nil
+		}
+		bookmark = res.Bookmark
+	}
+}
+
+// findOne loads the document of a calendar with the given value for the
+// field (uid or filename), and returns false if there is none.
+func findOne(inst *instance.Instance, doctype, calendarID, field, value string, doc couchdb.Doc) (bool, error) {
+	var docs []couchdb.JSONDoc
+	req := &couchdb.FindRequest{
+		UseIndex: "by-calendar-and-" + field,
+		Selector: mango.And(
+			mango.Equal("calendar_id", calendarID),
+			mango.Equal(field, value),
+		),
+		Limit: 1,
+	}
+	err := couchdb.FindDocs(inst, doctype, req, &docs)
+	if couchdb.IsNoDatabaseError(err) {
+		return false, nil
+	}
+	if err != nil || len(docs) == 0 {
+		return false, err
+	}
+	return true, couchdb.GetDoc(inst, doctype, docs[0].ID(), doc)
+}
+
+// checkUID returns ErrUIDConflict if another document of the calendar has
+// the same UID.
+func checkUID(inst *instance.Instance, doctype, calendarID, uid, id string) error {
+	var doc couchdb.JSONDoc
+	found, err := findOne(inst, doctype, calendarID, "uid", uid, &doc)
+	if err != nil {
+		return err
+	}
+	if found && doc.ID() != id {
+		return ErrUIDConflict
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+)
+
+// icsMime is the mime type of the iCalendar files.
+const icsMime = "text/calendar"
+
+// ImportFile imports the events and tasks of an iCalendar file in the
+// calendar.
+func ImportFile(inst *instance.Instance, cal *Calendar, file *vfs.FileDoc) (*ImportResult, error) {
+	f, err := inst.VFS().OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Import(inst, cal, f)
+}
+
+// ExportToFile writes the calendar as an iCalendar file in the given
+// directory.
+func ExportToFile(inst *instance.Instance, cal *Calendar, dirID string) (*vfs.FileDoc, error) {
+	var buf bytes.Buffer
+	if err := Export(inst, cal, &buf); err != nil {
+		return nil, err
+	}
+
+	fs := inst.VFS()
+	name := strings.ReplaceAll(cal.Name, "/", "-") + ".ics"
+	if exists, err := fs.GetIndexer().DirChildExists(dirID, name); err != nil {
+		return nil, err
+	} else if exists {
+		name = vfs.ConflictName(fs, dirID, name, true)
+	}
+	mime, class := vfs.ExtractMimeAndClass(icsMime)
+	newdoc, err := vfs.NewFileDoc(name, dirID, int64(buf.Len()), nil,
+		mime, class, time.Now(), false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	newdoc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+
+	f, err := fs.CreateFile(newdoc, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newdoc, nil
+}
@@ -0,0 +1,129 @@
+package calendar
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/ical"
+)
+
+const (
+	// FreeBusyBusy is the type of the periods where the user is busy.
+	FreeBusyBusy = "BUSY"
+	// FreeBusyTentative is the type of the periods with tentative events.
+	FreeBusyTentative = "BUSY-TENTATIVE"
+)
+
+// Period is a time range where the user is busy.
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Type  string    `json:"type"`
+}
+
+// FreeBusy returns the busy periods of the calendars in the [from, to) time
+// range. The overlapping periods of the same type are merged. The
+// transparent and cancelled events are ignored, and the all-day events are
+// taken in the timezone of their calendar.
+func FreeBusy(inst *instance.Instance, calendars []*Calendar, from, to time.Time) ([]*Period, error) {
+	var periods []*Period
+	for _, cal := range calendars {
+		events, err := FindEvents(inst, cal.DocID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, busyPeriods(events, cal.Location(), from, to)...)
+	}
+	return mergePeriods(periods), nil
+}
+
+// busyPeriods returns the periods of the occurrences of the events, clamped
+// to the [from, to) time range.
+func busyPeriods(events []*Event, loc *time.Location, from, to time.Time) []*Period {
+	var periods []*Period
+	for _, e := range events {
+		if e.Transparency == TransparencyTransparent {
+			continue
+		}
+		for _, occ := range e.Occurrences(from.AddDate(0, 0, -1), to.AddDate(0, 0, 1)) {
+			if occ.Status == StatusCancelled || !occ.End.After(occ.Start) {
+				continue
+			}
+			start, end := occ.Start, occ.End
+			if occ.AllDay {
+				start = inLoc(start, loc)
+				end = inLoc(end, loc)
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if !end.After(start) {
+				continue
+			}
+			kind := FreeBusyBusy
+			if occ.Status == StatusTentative {
+				kind = FreeBusyTentative
+			}
+			periods = append(periods, &Period{Start: start.UTC(), End: end.UTC(), Type: kind})
+		}
+	}
+	return periods
+}
+
+// inLoc returns the same wall clock of a UTC date in the given location.
+func inLoc(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// mergePeriods merges the overlapping periods of the same type, and returns
+// them sorted by start.
+func mergePeriods(periods []*Period) []*Period {
+	sort.Slice(periods, func(i, j int) bool {
+		if periods[i].Type != periods[j].Type {
+			return periods[i].Type < periods[j].Type
+		}
+		return periods[i].Start.Before(periods[j].Start)
+	})
+	var merged []*Period
+	for _, p := range periods {
+		if n := len(merged); n > 0 {
+			last := merged[n-1]
+			if last.Type == p.Type && !p.Start.After(last.End) {
+				if p.End.After(last.End) {
+					last.End = p.End
+				}
+				continue
+			}
+		}
+		copied := *p
+		merged = append(merged, &copied)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Start.Before(merged[j].Start)
+	})
+	return merged
+}
+
+// FreeBusyComponent returns a VCALENDAR with a VFREEBUSY for the periods.
+func FreeBusyComponent(periods []*Period, from, to time.Time) *ical.Component {
+	vcal := ical.NewComponent("VCALENDAR")
+	vcal.Add(ical.NewProperty("VERSION", "2.0"))
+	vcal.Add(ical.NewProperty("PRODID", ProdID))
+	fb := ical.NewComponent("VFREEBUSY")
+	fb.Add(ical.NewDateTimeProperty("DTSTAMP", time.Now().UTC().Truncate(time.Second), false))
+	fb.Add(ical.NewDateTimeProperty("DTSTART", from.UTC(), false))
+	fb.Add(ical.NewDateTimeProperty("DTEND", to.UTC(), false))
+	const layout = "20060102T150405Z"
+	for _, p := range periods {
+		prop := ical.NewProperty("FREEBUSY", p.Start.UTC().Format(layout)+"/"+p.End.UTC().Format(layout))
+		prop.Params.Set("FBTYPE", p.Type)
+		fb.Add(prop)
+	}
+	vcal.AddComponent(fb)
+	return vcal
+}
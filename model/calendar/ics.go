@@ -0,0 +1,724 @@
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/ical"
+)
+
+// ProdID is the identifier of the product that has created the iCalendar
+// streams.
+const ProdID = "-//Cozy Cloud//Cozy Stack//EN"
+
+// ContentType is the content type of the iCalendar streams.
+const ContentType = "text/calendar; charset=utf-8"
+
+// ErrObjectNotFound is used when there is no event or task for a CalDAV
+// resource.
+var ErrObjectNotFound = errors.New("Calendar object not found")
+
+// Object is a CalDAV resource: an event (with its modified occurrences) or a
+// task.
+type Object struct {
+	Event *Event
+	Todo  *Todo
+}
+
+// UID returns the UID of the event or task.
+func (o *Object) UID() string {
+	if o.Event != nil {
+		return o.Event.UID
+	}
+	return o.Todo.UID
+}
+
+// Filename returns the name of the CalDAV resource.
+func (o *Object) Filename() string {
+	if o.Event != nil {
+		return o.Event.Filename
+	}
+	return o.Todo.Filename
+}
+
+// ETag returns the entity tag of the CalDAV resource.
+func (o *Object) ETag() string {
+	if o.Event != nil {
+		return `"` + o.Event.DocRev + `"`
+	}
+	return `"` + o.Todo.DocRev + `"`
+}
+
+// UpdatedAt returns the date of the last modification of the resource.
+func (o *Object) UpdatedAt() time.Time {
+	if o.Event != nil {
+		return o.Event.UpdatedAt
+	}
+	return o.Todo.UpdatedAt
+}
+
+// FindObject returns the event or the task of a calendar for a CalDAV
+// resource name.
+func FindObject(inst *instance.Instance, calendarID, filename string) (*Object, error) {
+	e := &Event{}
+	found, err := findOne(inst, consts.CalendarEvents, calendarID, "filename", filename, e)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &Object{Event: e}, nil
+	}
+	t := &Todo{}
+	found, err = findOne(inst, consts.CalendarTodos, calendarID, "filename", filename, t)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &Object{Todo: t}, nil
+	}
+	return nil, ErrObjectNotFound
+}
+
+// ListObjects returns all the events and tasks of a calendar.
+func ListObjects(inst *instance.Instance, calendarID string) ([]*Object, error) {
+	events, err := FindAllEvents(inst, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	todos, err := FindAllTodos(inst, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*Object, 0, len(events)+len(todos))
+	for _, e := range events {
+		objects = append(objects, &Object{Event: e})
+	}
+	for _, t := range todos {
+		objects = append(objects, &Object{Todo: t})
+	}
+	return objects, nil
+}
+
+// SaveObject stores a CalDAV resource in a calendar. The existing object is
+// the resource with the same name, if any: it is replaced.
+func SaveObject(inst *instance.Instance, cal *Calendar, filename string, obj, existing *Object) error {
+	if existing != nil && (existing.Event == nil) != (obj.Event == nil) {
+		if err := existing.Delete(inst); err != nil {
+			return err
+		}
+		existing = nil
+	}
+	if e := obj.Event; e != nil {
+		e.CalendarID = cal.DocID
+		e.Filename = filename
+		if existing != nil {
+			e.DocID = existing.Event.DocID
+			e.DocRev = existing.Event.DocRev
+			e.CreatedAt = existing.Event.CreatedAt
+		}
+		return SaveEvent(inst, e)
+	}
+	t := obj.Todo
+	t.CalendarID = cal.DocID
+	t.Filename = filename
+	if existing != nil {
+		t.DocID = existing.Todo.DocID
+		t.DocRev = existing.Todo.DocRev
+		t.CreatedAt = existing.Todo.CreatedAt
+	}
+	return SaveTodo(inst, t)
+}
+
+// Delete removes the event or the task.
+func (o *Object) Delete(inst *instance.Instance) error {
+	if o.Event != nil {
+		return DeleteEvent(inst, o.Event)
+	}
+	return DeleteTodo(inst, o.Todo)
+}
+
+// ParseObject reads a CalDAV resource: an iCalendar stream with a single
+// event (and its modified occurrences) or a single task. The floating times
+// use the location of the calendar.
+func ParseObject(r io.Reader, cal *Calendar) (*Object, error) {
+	vcal, err := ical.Parse(r)
+	if err != nil {
+		return nil, ErrInvalidObject
+	}
+	objects, err := readObjects(vcal, cal.Location())
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) != 1 {
+		return nil, ErrInvalidObject
+	}
+	return objects[0], nil
+}
+
+// readObjects returns the events and tasks of a VCALENDAR, with the
+// components grouped by UID.
+func readObjects(vcal *ical.Component, loc *time.Location) ([]*Object, error) {
+	if vcal.Name != "VCALENDAR" {
+		return nil, ErrInvalidObject
+	}
+	var uids []string
+	byUID := make(map[string][]*ical.Component)
+	for _, c := range vcal.Children("VEVENT") {
+		uid := c.Text("UID")
+		if uid == "" {
+			return nil, ErrInvalidObject
+		}
+		if _, ok := byUID[uid]; !ok {
+			uids = append(uids, uid)
+		}
+		byUID[uid] = append(byUID[uid], c)
+	}
+
+	var objects []*Object
+	for _, uid := range uids {
+		var master *ical.Component
+		var overrides []*ical.Component
+		for _, c := range byUID[uid] {
+			if c.Get("RECURRENCE-ID") != nil {
+				overrides = append(overrides, c)
+			} else if master == nil {
+				master = c
+			} else {
+				return nil, ErrInvalidObject
+			}
+		}
+		if master == nil {
+			// The master is not known, so the overrides are kept as an
+			// event with occurrences given by RDATE.
+			master = overrides[0]
+		}
+		e, err := eventFromComponents(master, overrides, loc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, &Object{Event: e})
+	}
+
+	for _, c := range vcal.Children("VTODO") {
+		if c.Get("RECURRENCE-ID") != nil {
+			continue
+		}
+		t, err := todoFromComponent(c, loc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, &Object{Todo: t})
+	}
+	return objects, nil
+}
+
+func eventFromComponents(master *ical.Component, overrides []*ical.Component, loc *time.Location) (*Event, error) {
+	prop := master.Get("DTSTART")
+	if prop == nil {
+		return nil, ErrInvalidObject
+	}
+	start, allDay, err := prop.DateTime(loc)
+	if err != nil {
+		return nil, ErrInvalidObject
+	}
+	e := &Event{
+		UID:          master.Text("UID"),
+		Summary:      master.Text("SUMMARY"),
+		Description:  master.Text("DESCRIPTION"),
+		Location:     master.Text("LOCATION"),
+		Start:        start,
+		AllDay:       allDay,
+		Status:       strings.ToUpper(master.Text("STATUS")),
+		Transparency: strings.ToUpper(master.Text("TRANSP")),
+		URL:          master.Text("URL"),
+	}
+	if !allDay && start.Location() != time.UTC {
+		e.Timezone = start.Location().String()
+	}
+	if e.End, err = endOf(master, "DTEND", start, allDay, loc); err != nil {
+		return nil, err
+	}
+	if master.Get("RECURRENCE-ID") == nil {
+		if p := master.Get("RRULE"); p != nil {
+			e.RRule = p.Value
+		}
+	} else {
+		e.RDates = append(e.RDates, start)
+	}
+	for _, p := range master.GetAll("EXDATE") {
+		times, _, err := p.DateTimes(loc)
+		if err != nil {
+			return nil, ErrInvalidObject
+		}
+		e.ExDates = append(e.ExDates, times...)
+	}
+	for _, p := range master.GetAll("RDATE") {
+		if strings.EqualFold(p.Param("VALUE"), "PERIOD") {
+			continue
+		}
+		times, _, err := p.DateTimes(loc)
+		if err != nil {
+			return nil, ErrInvalidObject
+		}
+		e.RDates = append(e.RDates, times...)
+	}
+	if p := master.Get("ORGANIZER"); p != nil {
+		e.Organizer = attendeeFromProperty(p)
+	}
+	for _, p := range master.GetAll("ATTENDEE") {
+		e.Attendees = append(e.Attendees, attendeeFromProperty(p))
+	}
+	for _, p := range master.GetAll("CATEGORIES") {
+		e.Categories = append(e.Categories, p.Texts()...)
+	}
+	e.Alarms = alarmsFromComponent(master)
+	e.Sequence, _ = strconv.Atoi(master.Text("SEQUENCE"))
+
+	for _, c := range overrides {
+		if c == master {
+			continue
+		}
+		recurrenceID, _, err := c.Get("RECURRENCE-ID").DateTime(loc)
+		if err != nil {
+			return nil, ErrInvalidObject
+		}
+		ex := &Exception{
+			RecurrenceID: recurrenceID,
+			Summary:      c.Text("SUMMARY"),
+			Description:  c.Text("DESCRIPTION"),
+			Location:     c.Text("LOCATION"),
+			Status:       strings.ToUpper(c.Text("STATUS")),
+			Start:        recurrenceID,
+		}
+		ex.Cancelled = ex.Status == StatusCancelled
+		if p := c.Get("DTSTART"); p != nil {
+			if ex.Start, _, err = p.DateTime(loc); err != nil {
+				return nil, ErrInvalidObject
+			}
+		}
+		if ex.End, err = endOf(c, "DTEND", ex.Start, allDay, loc); err != nil {
+			return nil, err
+		}
+		if ex.End.Equal(ex.Start) && c.Get("DTEND") == nil && c.Get("DURATION") == nil {
+			ex.End = ex.Start.Add(e.End.Sub(e.Start))
+		}
+		e.Exceptions = append(e.Exceptions, ex)
+	}
+	return e, nil
+}
+
+// endOf returns the end of an event (DTEND or DURATION) or the due date of a
+// task (DUE or DURATION).
+func endOf(c *ical.Component, name string, start time.Time, allDay bool, loc *time.Location) (time.Time, error) {
+	if p := c.Get(name); p != nil {
+		end, _, err := p.DateTime(loc)
+		if err != nil {
+			return time.Time{}, ErrInvalidObject
+		}
+		return end, nil
+	}
+	if p := c.Get("DURATION"); p != nil && !start.IsZero() {
+		d, err := ical.ParseDuration(p.Value)
+		if err != nil {
+			return time.Time{}, ErrInvalidObject
+		}
+		return start.Add(d), nil
+	}
+	if allDay && name == "DTEND" {
+		return start.AddDate(0, 0, 1), nil
+	}
+	if name == "DTEND" {
+		return start, nil
+	}
+	return time.Time{}, nil
+}
+
+func attendeeFromProperty(p *ical.Property) *Attendee {
+	email := p.Value
+	if len(email) > 7 && strings.EqualFold(email[:7], "mailto:") {
+		email = email[7:]
+	}
+	return &Attendee{
+		Email:  email,
+		Name:   p.Param("CN"),
+		Role:   p.Param("ROLE"),
+		Status: p.Param("PARTSTAT"),
+		RSVP:   strings.EqualFold(p.Param("RSVP"), "TRUE"),
+	}
+}
+
+func alarmsFromComponent(c *ical.Component) []*Alarm {
+	var alarms []*Alarm
+	for _, a := range c.Children("VALARM") {
+		trigger := a.Get("TRIGGER")
+		if trigger == nil {
+			continue
+		}
+		alarms = append(alarms, &Alarm{
+			Action:      strings.ToUpper(a.Text("ACTION")),
+			Trigger:     trigger.Value,
+			Description: a.Text("DESCRIPTION"),
+		})
+	}
+	return alarms
+}
+
+func todoFromComponent(c *ical.Component, loc *time.Location) (*Todo, error) {
+	t := &Todo{
+		UID:         c.Text("UID"),
+		Summary:     c.Text("SUMMARY"),
+		Description: c.Text("DESCRIPTION"),
+		Status:      strings.ToUpper(c.Text("STATUS")),
+	}
+	if t.UID == "" {
+		return nil, ErrInvalidObject
+	}
+	var start time.Time
+	var err error
+	if p := c.Get("DTSTART"); p != nil {
+		if start, t.AllDay, err = p.DateTime(loc); err != nil {
+			return nil, ErrInvalidObject
+		}
+		t.Start = &start
+	}
+	due, err := endOf(c, "DUE", start, t.AllDay, loc)
+	if err != nil {
+		return nil, err
+	}
+	if !due.IsZero() {
+		t.Due = &due
+	}
+	if p := c.Get("DUE"); p != nil && t.Start == nil {
+		_, t.AllDay, _ = p.DateTime(loc)
+	}
+	if p := c.Get("COMPLETED"); p != nil {
+		completed, _, err := p.DateTime(time.UTC)
+		if err != nil {
+			return nil, ErrInvalidObject
+		}
+		t.Completed = &completed
+	}
+	if p := c.Get("RRULE"); p != nil {
+		t.RRule = p.Value
+	}
+	for _, p := range c.GetAll("CATEGORIES") {
+		t.Categories = append(t.Categories, p.Texts()...)
+	}
+	t.Alarms = alarmsFromComponent(c)
+	t.Priority, _ = strconv.Atoi(c.Text("PRIORITY"))
+	t.PercentComplete, _ = strconv.Atoi(c.Text("PERCENT-COMPLETE"))
+	t.Sequence, _ = strconv.Atoi(c.Text("SEQUENCE"))
+	return t, nil
+}
+
+// newVCalendar returns a VCALENDAR with the timezones used by the objects.
+func newVCalendar(objects []*Object) *ical.Component {
+	vcal := ical.NewComponent("VCALENDAR")
+	vcal.Add(ical.NewProperty("VERSION", "2.0"))
+	vcal.Add(ical.NewProperty("PRODID", ProdID))
+	vcal.Add(ical.NewProperty("CALSCALE", "GREGORIAN"))
+
+	years := make(map[string]int)
+	for _, obj := range objects {
+		if e := obj.Event; e != nil && !e.AllDay && e.Timezone != "" && e.loc() != time.UTC {
+			if y, ok := years[e.Timezone]; !ok || e.Start.Year() < y {
+				years[e.Timezone] = e.Start.Year()
+			}
+		}
+	}
+	tzids := make([]string, 0, len(years))
+	for tzid := range years {
+		tzids = append(tzids, tzid)
+	}
+	sort.Strings(tzids)
+	for _, tzid := range tzids {
+		vcal.AddComponent(ical.VTimezone(loadLocation(tzid), years[tzid]))
+	}
+
+	for _, obj := range objects {
+		if obj.Event != nil {
+			for _, c := range obj.Event.components() {
+				vcal.AddComponent(c)
+			}
+		} else {
+			vcal.AddComponent(obj.Todo.component())
+		}
+	}
+	return vcal
+}
+
+// components returns the VEVENT of the event, followed by the VEVENT of its
+// modified occurrences.
+func (e *Event) components() []*ical.Component {
+	loc := e.loc()
+	at := func(t time.Time) time.Time {
+		if e.AllDay {
+			return t.UTC()
+		}
+		return t.In(loc)
+	}
+
+	master := ical.NewComponent("VEVENT")
+	master.Add(ical.NewProperty("UID", e.UID))
+	addStamps(master, e.CreatedAt, e.UpdatedAt)
+	master.Add(ical.NewDateTimeProperty("DTSTART", at(e.Start), e.AllDay))
+	if !e.End.Equal(e.Start) {
+		master.Add(ical.NewDateTimeProperty("DTEND", at(e.End), e.AllDay))
+	}
+	master.AddText("SUMMARY", e.Summary)
+	master.AddText("DESCRIPTION", e.Description)
+	master.AddText("LOCATION", e.Location)
+	if e.Status != "" {
+		master.Add(ical.NewProperty("STATUS", e.Status))
+	}
+	if e.Transparency != "" {
+		master.Add(ical.NewProperty("TRANSP", e.Transparency))
+	}
+	if e.RRule != "" {
+		master.Add(ical.NewProperty("RRULE", e.RRule))
+	}
+	if len(e.ExDates) > 0 {
+		master.Add(ical.NewDateTimesProperty("EXDATE", inLocation(e.ExDates, at), e.AllDay))
+	}
+	if len(e.RDates) > 0 {
+		master.Add(ical.NewDateTimesProperty("RDATE", inLocation(e.RDates, at), e.AllDay))
+	}
+	if e.Organizer != nil {
+		master.Add(e.Organizer.property("ORGANIZER"))
+	}
+	for _, a := range e.Attendees {
+		master.Add(a.property("ATTENDEE"))
+	}
+	addCategories(master, e.Categories)
+	master.AddText("URL", e.URL)
+	if e.Sequence > 0 {
+		master.Add(ical.NewProperty("SEQUENCE", strconv.Itoa(e.Sequence)))
+	}
+	addAlarms(master, e.Alarms)
+	components := []*ical.Component{master}
+
+	for _, ex := range e.Exceptions {
+		c := ical.NewComponent("VEVENT")
+		c.Add(ical.NewProperty("UID", e.UID))
+		addStamps(c, e.CreatedAt, e.UpdatedAt)
+		c.Add(ical.NewDateTimeProperty("RECURRENCE-ID", at(ex.RecurrenceID), e.AllDay))
+		c.Add(ical.NewDateTimeProperty("DTSTART", at(ex.Start), e.AllDay))
+		c.Add(ical.NewDateTimeProperty("DTEND", at(ex.End), e.AllDay))
+		c.AddText("SUMMARY", firstNonEmpty(ex.Summary, e.Summary))
+		c.AddText("DESCRIPTION", firstNonEmpty(ex.Description, e.Description))
+		c.AddText("LOCATION", firstNonEmpty(ex.Location, e.Location))
+		if ex.Cancelled {
+			c.Add(ical.NewProperty("STATUS", StatusCancelled))
+		} else if ex.Status != "" {
+			c.Add(ical.NewProperty("STATUS", ex.Status))
+		}
+		components = append(components, c)
+	}
+	return components
+}
+
+// component returns the VTODO of the task.
+func (t *Todo) component() *ical.Component {
+	c := ical.NewComponent("VTODO")
+	c.Add(ical.NewProperty("UID", t.UID))
+	addStamps(c, t.CreatedAt, t.UpdatedAt)
+	if t.Start != nil {
+		c.Add(ical.NewDateTimeProperty("DTSTART", t.Start.UTC(), t.AllDay))
+	}
+	if t.Due != nil {
+		c.Add(ical.NewDateTimeProperty("DUE", t.Due.UTC(), t.AllDay))
+	}
+	if t.Completed != nil {
+		c.Add(ical.NewDateTimeProperty("COMPLETED", t.Completed.UTC(), false))
+	}
+	c.AddText("SUMMARY", t.Summary)
+	c.AddText("DESCRIPTION", t.Description)
+	if t.Status != "" {
+		c.Add(ical.NewProperty("STATUS", t.Status))
+	}
+	if t.Priority > 0 {
+		c.Add(ical.NewProperty("PRIORITY", strconv.Itoa(t.Priority)))
+	}
+	if t.PercentComplete > 0 {
+		c.Add(ical.NewProperty("PERCENT-COMPLETE", strconv.Itoa(t.PercentComplete)))
+	}
+	if t.RRule != "" {
+		c.Add(ical.NewProperty("RRULE", t.RRule))
+	}
+	addCategories(c, t.Categories)
+	if t.Sequence > 0 {
+		c.Add(ical.NewProperty("SEQUENCE", strconv.Itoa(t.Sequence)))
+	}
+	addAlarms(c, t.Alarms)
+	return c
+}
+
+func (a *Attendee) property(name string) *ical.Property {
+	p := ical.NewProperty(name, "mailto:"+a.Email)
+	if a.Name != "" {
+		p.Params.Set("CN", a.Name)
+	}
+	if a.Role != "" {
+		p.Params.Set("ROLE", a.Role)
+	}
+	if a.Status != "" {
+		p.Params.Set("PARTSTAT", a.Status)
+	}
+	if a.RSVP {
+		p.Params.Set("RSVP", "TRUE")
+	}
+	return p
+}
+
+func addStamps(c *ical.Component, created, updated time.Time) {
+	stamp := updated
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	c.Add(ical.NewDateTimeProperty("DTSTAMP", stamp.UTC().Truncate(time.Second), false))
+	if !created.IsZero() {
+		c.Add(ical.NewDateTimeProperty("CREATED", created.UTC().Truncate(time.Second), false))
+	}
+	if !updated.IsZero() {
+		c.Add(ical.NewDateTimeProperty("LAST-MODIFIED", updated.UTC().Truncate(time.Second), false))
+	}
+}
+
+func addCategories(c *ical.Component, categories []string) {
+	if len(categories) == 0 {
+		return
+	}
+	escaped := make([]string, len(categories))
+	for i, category := range categories {
+		escaped[i] = ical.EscapeText(category)
+	}
+	c.Add(ical.NewProperty("CATEGORIES", strings.Join(escaped, ",")))
+}
+
+func addAlarms(c *ical.Component, alarms []*Alarm) {
+	for _, a := range alarms {
+		valarm := ical.NewComponent("VALARM")
+		valarm.Add(ical.NewProperty("ACTION", firstNonEmpty(a.Action, "DISPLAY")))
+		trigger := ical.NewProperty("TRIGGER", a.Trigger)
+		if !strings.HasPrefix(a.Trigger, "P") && !strings.HasPrefix(a.Trigger, "-") &&
+			!strings.HasPrefix(a.Trigger, "+") {
+			trigger.Params.Set("VALUE", "DATE-TIME")
+		}
+		valarm.Add(trigger)
+		valarm.AddText("DESCRIPTION", firstNonEmpty(a.Description, "Reminder"))
+		c.AddComponent(valarm)
+	}
+}
+
+func inLocation(times []time.Time, at func(time.Time) time.Time) []time.Time {
+	converted := make([]time.Time, len(times))
+	for i, t := range times {
+		converted[i] = at(t)
+	}
+	return converted
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// WriteObject writes a CalDAV resource in the iCalendar format.
+func WriteObject(w io.Writer, obj *Object) error {
+	return ical.Encode(w, newVCalendar([]*Object{obj}))
+}
+
+// Export writes all the events and tasks of a calendar in the iCalendar
+// format.
+func Export(inst *instance.Instance, cal *Calendar, w io.Writer) error {
+	objects, err := ListObjects(inst, cal.DocID)
+	if err != nil {
+		return err
+	}
+	vcal := newVCalendar(objects)
+	vcal.AddText("X-WR-CALNAME", cal.Name)
+	if cal.Timezone != "" {
+		vcal.Add(ical.NewProperty("X-WR-TIMEZONE", cal.Timezone))
+	}
+	bw := bufio.NewWriter(w)
+	if err := ical.Encode(bw, vcal); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportResult gives the number of events and tasks created and updated by
+// an import, and the number of objects that have been skipped because they
+// are not valid.
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// Import reads an iCalendar stream and saves its events and tasks in the
+// calendar. The objects with a UID already known in the calendar are
+// updated.
+func Import(inst *instance.Instance, cal *Calendar, r io.Reader) (*ImportResult, error) {
+	vcal, err := ical.Parse(r)
+	if err != nil {
+		return nil, ErrInvalidObject
+	}
+	objects, err := readObjects(vcal, cal.Location())
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportResult{}
+	for _, obj := range objects {
+		existing, err := findByUID(inst, cal.DocID, obj)
+		if err != nil {
+			return nil, err
+		}
+		filename := defaultFilename(obj.UID())
+		if existing != nil {
+			filename = existing.Filename()
+		}
+		err = SaveObject(inst, cal, filename, obj, existing)
+		switch {
+		case err == ErrInvalidEvent || err == ErrUIDConflict:
+			res.Skipped++
+		case err != nil:
+			return nil, err
+		case existing != nil:
+			res.Updated++
+		default:
+			res.Created++
+		}
+	}
+	return res, nil
+}
+
+// findByUID returns the object of the calendar with the same UID and the
+// same kind, or nil.
+func findByUID(inst *instance.Instance, calendarID string, obj *Object) (*Object, error) {
+	if obj.Event != nil {
+		e := &Event{}
+		found, err := findOne(inst, consts.CalendarEvents, calendarID, "uid", obj.UID(), e)
+		if err != nil || !found {
+			return nil, err
+		}
+		return &Object{Event: e}, nil
+	}
+	t := &Todo{}
+	found, err := findOne(inst, consts.CalendarTodos, calendarID, "uid", obj.UID(), t)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &Object{Todo: t}, nil
+}
@@ -0,0 +1,32 @@
+package calendar
+
+import (
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// SharingRules returns the rules of a sharing for a calendar: the calendar
+// itself, and its events and tasks selected by calendar_id. The changes made
+// by the recipients are synchronized back when the sharing is two-way.
+func SharingRules(cal *Calendar, twoWay bool) []sharing.Rule {
+	behavior := sharing.ActionRulePush
+	if twoWay {
+		behavior = sharing.ActionRuleSync
+	}
+	rule := func(title, doctype, selector string) sharing.Rule {
+		return sharing.Rule{
+			Title:    title,
+			DocType:  doctype,
+			Selector: selector,
+			Values:   []string{cal.DocID},
+			Add:      behavior,
+			Update:   behavior,
+			Remove:   behavior,
+		}
+	}
+	return []sharing.Rule{
+		rule(cal.Name, consts.Calendars, ""),
+		rule("events", consts.CalendarEvents, "calendar_id"),
+		rule("tasks", consts.CalendarTodos, "calendar_id"),
+	}
+}
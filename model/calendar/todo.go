@@ -0,0 +1,186 @@
+package calendar
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// TodoNeedsAction is the status of a task that has not been started.
+	TodoNeedsAction = "NEEDS-ACTION"
+	// TodoInProcess is the status of a task that has been started.
+	TodoInProcess = "IN-PROCESS"
+	// TodoCompleted is the status of a task that has been done.
+	TodoCompleted = "COMPLETED"
+)
+
+// Todo is a task of a calendar.
+type Todo struct {
+	DocID           string     `json:"_id,omitempty"`
+	DocRev          string     `json:"_rev,omitempty"`
+	CalendarID      string     `json:"calendar_id"`
+	UID             string     `json:"uid"`
+	Filename        string     `json:"filename,omitempty"`
+	Summary         string     `json:"summary,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	Start           *time.Time `json:"start,omitempty"`
+	Due             *time.Time `json:"due,omitempty"`
+	AllDay          bool       `json:"all_day,omitempty"`
+	Completed       *time.Time `json:"completed,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	Priority        int        `json:"priority,omitempty"`
+	PercentComplete int        `json:"percent_complete,omitempty"`
+	RRule           string     `json:"rrule,omitempty"`
+	Alarms          []*Alarm   `json:"alarms,omitempty"`
+	Categories      []string   `json:"categories,omitempty"`
+	Sequence        int        `json:"sequence,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// ID returns the task qualified identifier
+func (t *Todo) ID() string { return t.DocID }
+
+// Rev returns the task revision
+func (t *Todo) Rev() string { return t.DocRev }
+
+// DocType returns the task document type
+func (t *Todo) DocType() string { return consts.CalendarTodos }
+
+// Clone implements couchdb.Doc
+func (t *Todo) Clone() couchdb.Doc {
+	cloned := *t
+	cloned.Start = clonePtr(t.Start)
+	cloned.Due = clonePtr(t.Due)
+	cloned.Completed = clonePtr(t.Completed)
+	cloned.Categories = append([]string(nil), t.Categories...)
+	cloned.Alarms = make([]*Alarm, len(t.Alarms))
+	for i, a := range t.Alarms {
+		copied := *a
+		cloned.Alarms[i] = &copied
+	}
+	return &cloned
+}
+
+// SetID changes the task qualified identifier
+func (t *Todo) SetID(id string) { t.DocID = id }
+
+// SetRev changes the task revision
+func (t *Todo) SetRev(rev string) { t.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (t *Todo) Fetch(field string) []string {
+	switch field {
+	case "calendar_id":
+		return []string{t.CalendarID}
+	case "uid":
+		return []string{t.UID}
+	}
+	return nil
+}
+
+// prepare checks the task and normalizes its fields before saving it.
+func (t *Todo) prepare() error {
+	if t.CalendarID == "" {
+		return ErrInvalidEvent
+	}
+	if t.UID == "" {
+		t.UID = uuid.Must(uuid.NewV7()).String()
+	}
+	if t.Filename == "" {
+		t.Filename = defaultFilename(t.UID)
+	}
+	if strings.Contains(t.Filename, "/") {
+		return ErrInvalidEvent
+	}
+	t.Status = strings.ToUpper(t.Status)
+	switch t.Status {
+	case "", TodoNeedsAction, TodoInProcess, TodoCompleted, StatusCancelled:
+	default:
+		return ErrInvalidEvent
+	}
+	if t.Priority < 0 || t.Priority > 9 || t.PercentComplete < 0 || t.PercentComplete > 100 {
+		return ErrInvalidEvent
+	}
+	if t.Status == TodoCompleted && t.Completed == nil {
+		now := time.Now().UTC()
+		t.Completed = &now
+	}
+	normalize := func(d *time.Time, allDay bool) *time.Time {
+		if d == nil || d.IsZero() {
+			return nil
+		}
+		normalized := d.UTC()
+		if allDay {
+			normalized = truncateDay(normalized)
+		}
+		return &normalized
+	}
+	t.Start = normalize(t.Start, t.AllDay)
+	t.Due = normalize(t.Due, t.AllDay)
+	t.Completed = normalize(t.Completed, false)
+	return nil
+}
+
+// SaveTodo creates or updates a task.
+func SaveTodo(inst *instance.Instance, t *Todo) error {
+	if _, err := Get(inst, t.CalendarID); err != nil {
+		return err
+	}
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	if err := checkUID(inst, consts.CalendarTodos, t.CalendarID, t.UID, t.DocID); err != nil {
+		return err
+	}
+	t.UpdatedAt = time.Now().UTC()
+	if t.DocRev == "" {
+		t.CreatedAt = t.UpdatedAt
+		if t.DocID != "" {
+			return couchdb.CreateNamedDocWithDB(inst, t)
+		}
+		return couchdb.CreateDoc(inst, t)
+	}
+	return couchdb.UpdateDoc(inst, t)
+}
+
+// DeleteTodo removes a task.
+func DeleteTodo(inst *instance.Instance, t *Todo) error {
+	return couchdb.DeleteDoc(inst, t)
+}
+
+// GetTodo returns the task with the given identifier.
+func GetTodo(inst *instance.Instance, id string) (*Todo, error) {
+	t := &Todo{}
+	if err := couchdb.GetDoc(inst, consts.CalendarTodos, id, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// FindAllTodos returns all the tasks of a calendar.
+func FindAllTodos(inst *instance.Instance, calendarID string) ([]*Todo, error) {
+	var todos []*Todo
+	err := findAll(inst, consts.CalendarTodos, calendarID, func() interface{} {
+		var page []*Todo
+		return &page
+	}, func(page interface{}) int {
+		list := *page.(*[]*Todo)
+		todos = append(todos, list...)
+		return len(list)
+	})
+	return todos, err
+}
+
+func clonePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	cloned := *t
+	return &cloned
+}
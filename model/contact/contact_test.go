@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/ical"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/gofrs/uuid/v5"
 	"github.com/stretchr/testify/assert"
@@ -166,3 +168,102 @@ func TestGetAllContacts(t *testing.T) {
 	require.Len(t, contacts, 1)
 	assert.Equal(t, contacts[0].PrimaryName(), "Gaby")
 }
+
+func TestToMailAddressSkipsInvalid(t *testing.T) {
+	c := New()
+	c.M["fullname"] = "Alice"
+	c.M["email"] = []interface{}{
+		map[string]interface{}{"address": "old@example.com", "primary": true, "invalid": true},
+		map[string]interface{}{"address": "alice@example.com"},
+	}
+	addr, err := c.ToMailAddress()
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", addr.Email)
+
+	c.M["email"] = []interface{}{
+		map[string]interface{}{"address": "old@example.com", "invalid": true},
+	}
+	addr, err = c.ToMailAddress()
+	require.NoError(t, err)
+	assert.Equal(t, "old@example.com", addr.Email)
+}
+
+func TestFromVCard(t *testing.T) {
+	cards, err := ical.ParseAll(strings.NewReader("BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Jane Doe\r\n" +
+		"N:Doe;Jane;;;\r\n" +
+		"EMAIL;TYPE=INTERNET;TYPE=WORK:jane@work.example\r\n" +
+		"item1.EMAIL;TYPE=INTERNET;TYPE=pref:jane@example.org\r\n" +
+		"TEL;TYPE=CELL:+33 6 12 34 56 78\r\n" +
+		"ADR;TYPE=HOME:;Apt 2;1 Main St;Paris;;75001;France\r\n" +
+		"BDAY:1980-03-21\r\n" +
+		"ORG:ACME;R&D\r\n" +
+		"CATEGORIES:myContacts,Friends\r\n" +
+		"END:VCARD\r\n"))
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+
+	c := FromVCard(cards[0])
+	assert.Equal(t, "Jane Doe", c.PrimaryName())
+	assert.Equal(t, "Doe", c.M["name"].(map[string]interface{})["familyName"])
+	assert.Equal(t, "1980-03-21", c.M["birthday"])
+	assert.Equal(t, "ACME", c.M["company"])
+	addr, err := c.ToMailAddress()
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.org", addr.Email)
+	assert.Equal(t, "+33 6 12 34 56 78", c.PrimaryPhoneNumber())
+	phone := c.M["phone"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "cell", phone["type"])
+	addresses := c.M["address"].([]interface{})
+	require.Len(t, addresses, 1)
+	address := addresses[0].(map[string]interface{})
+	assert.Equal(t, "1 Main St Apt 2", address["street"])
+	assert.Equal(t, "1 Main St Apt 2, Paris, 75001, France", address["formattedAddress"])
+
+	c.AddGroup("friends")
+	c.AddGroup("friends")
+	data, err := json.Marshal(c.M["relationships"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"groups":{"data":[{"_id":"friends","_type":"io.cozy.contacts.groups"}]}}`, string(data))
+}
+
+func TestSuppress(t *testing.T) {
+	config.UseTestFile(t)
+	instPrefix := prefixer.NewPrefixer(0, "contact-suppress", "contact-suppress")
+	require.NoError(t, couchdb.ResetDB(instPrefix, consts.Contacts))
+	require.NoError(t, couchdb.ResetDB(instPrefix, consts.MailSuppressions))
+	t.Cleanup(func() {
+		_ = couchdb.DeleteDB(instPrefix, consts.Contacts)
+		_ = couchdb.DeleteDB(instPrefix, consts.MailSuppressions)
+	})
+	require.NoError(t, couchdb.DefineView(instPrefix, couchdb.ContactByEmail))
+
+	doc := New()
+	doc.M["fullname"] = "Bob"
+	doc.M["email"] = []map[string]interface{}{
+		{"address": "bob@example.com", "primary": true},
+		{"address": "bob@example.net"},
+	}
+	require.NoError(t, couchdb.CreateDoc(instPrefix, doc))
+
+	suppressed, err := IsSuppressed(instPrefix, "bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, suppressed)
+
+	require.NoError(t, Suppress(instPrefix, "bob@example.com", SuppressionBounce, "550 No such user"))
+	suppressed, err = IsSuppressed(instPrefix, "BOB@example.com")
+	require.NoError(t, err)
+	assert.True(t, suppressed)
+
+	stored, err := Find(instPrefix, doc.ID())
+	require.NoError(t, err)
+	addr, err := stored.ToMailAddress()
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.net", addr.Email)
+
+	require.NoError(t, Unsuppress(instPrefix, "bob@example.com"))
+	suppressed, err = IsSuppressed(instPrefix, "bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, suppressed)
+}
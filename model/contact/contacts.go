@@ -42,7 +42,7 @@ func (c *Contact) ToMailAddress() (*mail.Address, error) {
 	if !ok || len(emails) == 0 {
 		return nil, ErrNoMailAddress
 	}
-	var email string
+	var email, invalid string
 	for i := range emails {
 		obj, ok := emails[i].(map[string]interface{})
 		if !ok {
@@ -52,6 +52,14 @@ func (c *Contact) ToMailAddress() (*mail.Address, error) {
 		if !ok {
 			continue
 		}
+		// The addresses marked as invalid after a bounce are used only if
+		// there is no other address.
+		if bad, _ := obj["invalid"].(bool); bad {
+			if invalid == "" {
+				invalid = address
+			}
+			continue
+		}
 		if primary, ok := obj["primary"].(bool); ok && primary {
 			email = address
 		}
@@ -59,6 +67,9 @@ func (c *Contact) ToMailAddress() (*mail.Address, error) {
 			email = address
 		}
 	}
+	if email == "" {
+		email = invalid
+	}
 	name := c.PrimaryName()
 	return &mail.Address{Name: name, Email: email}, nil
 }
@@ -0,0 +1,145 @@
+package contact
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// SuppressionBounce is the kind of suppression for an email address where
+	// a mail has been rejected permanently.
+	SuppressionBounce = "bounce"
+	// SuppressionComplaint is the kind of suppression for an email address
+	// where the recipient has marked a mail as spam.
+	SuppressionComplaint = "complaint"
+)
+
+// Suppression is a document for an email address where the stack must not
+// send mails anymore. Its identifier is the email address in lower case.
+type Suppression struct {
+	DocID     string    `json:"_id,omitempty"`
+	DocRev    string    `json:"_rev,omitempty"`
+	Email     string    `json:"email"`
+	Kind      string    `json:"kind"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ID returns the suppression qualified identifier
+func (s *Suppression) ID() string { return s.DocID }
+
+// Rev returns the suppression revision
+func (s *Suppression) Rev() string { return s.DocRev }
+
+// DocType returns the suppression document type
+func (s *Suppression) DocType() string { return consts.MailSuppressions }
+
+// Clone implements couchdb.Doc
+func (s *Suppression) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID changes the suppression qualified identifier
+func (s *Suppression) SetID(id string) { s.DocID = id }
+
+// SetRev changes the suppression revision
+func (s *Suppression) SetRev(rev string) { s.DocRev = rev }
+
+func suppressionID(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Suppress adds the email address to the suppression list, and marks it as
+// invalid in the contacts that have it.
+func Suppress(db prefixer.Prefixer, email, kind, reason string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ErrNoMailAddress
+	}
+	doc := &Suppression{}
+	err := couchdb.GetDoc(db, consts.MailSuppressions, suppressionID(email), doc)
+	switch {
+	case err == nil:
+		doc.Kind = kind
+		doc.Reason = reason
+		err = couchdb.UpdateDoc(db, doc)
+	case couchdb.IsNotFoundError(err):
+		doc = &Suppression{
+			DocID:     suppressionID(email),
+			Email:     email,
+			Kind:      kind,
+			Reason:    reason,
+			CreatedAt: time.Now().UTC(),
+		}
+		err = couchdb.CreateNamedDocWithDB(db, doc)
+	}
+	if err != nil {
+		return err
+	}
+	return markEmailAsInvalid(db, email, kind)
+}
+
+// Unsuppress removes the email address from the suppression list, for
+// example when the recipient has fixed their mailbox.
+func Unsuppress(db prefixer.Prefixer, email string) error {
+	doc := &Suppression{}
+	err := couchdb.GetDoc(db, consts.MailSuppressions, suppressionID(email), doc)
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(db, doc)
+}
+
+// IsSuppressed returns true if the stack must not send mails to the given
+// email address.
+func IsSuppressed(db prefixer.Prefixer, email string) (bool, error) {
+	doc := &Suppression{}
+	err := couchdb.GetDoc(db, consts.MailSuppressions, suppressionID(email), doc)
+	if err == nil {
+		return true, nil
+	}
+	if couchdb.IsNotFoundError(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// markEmailAsInvalid adds an invalid flag on the email address in the
+// contacts that have it.
+func markEmailAsInvalid(db prefixer.Prefixer, email, kind string) error {
+	contacts, err := FindAllByEmail(db, email)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		emails, _ := c.Get("email").([]interface{})
+		changed := false
+		for i := range emails {
+			obj, ok := emails[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			address, _ := obj["address"].(string)
+			if !strings.EqualFold(strings.TrimSpace(address), email) {
+				continue
+			}
+			obj["invalid"] = true
+			obj["invalidReason"] = kind
+			changed = true
+		}
+		if changed {
+			if err := couchdb.UpdateDoc(db, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
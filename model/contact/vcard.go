@@ -0,0 +1,223 @@
+package contact
+
+import (
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/ical"
+)
+
+// FromVCard returns a new contact (not saved) with the fields of a vCard. The
+// properties can be prefixed by a group name (like item1.EMAIL), as the
+// exports of Google and Apple do.
+func FromVCard(card *ical.Component) *Contact {
+	doc := New()
+	var emails, phones, addresses []map[string]interface{}
+	name := map[string]interface{}{}
+	for _, p := range card.Props {
+		propName := p.Name
+		if i := strings.LastIndexByte(propName, '.'); i >= 0 {
+			propName = propName[i+1:]
+		}
+		switch propName {
+		case "FN":
+			if fullname := strings.TrimSpace(p.Text()); fullname != "" {
+				doc.M["fullname"] = fullname
+			}
+		case "N":
+			fields := p.Fields()
+			for i, key := range []string{"familyName", "givenName", "additionalName", "namePrefix", "nameSuffix"} {
+				if i < len(fields) && strings.TrimSpace(fields[i]) != "" {
+					name[key] = strings.TrimSpace(fields[i])
+				}
+			}
+		case "EMAIL":
+			if address := strings.TrimSpace(p.Text()); address != "" {
+				emails = append(emails, withType(p, map[string]interface{}{"address": address}))
+			}
+		case "TEL":
+			number := strings.TrimPrefix(strings.TrimSpace(p.Text()), "tel:")
+			if number != "" {
+				phones = append(phones, withType(p, map[string]interface{}{"number": number}))
+			}
+		case "ADR":
+			if address := addressFromFields(p.Fields()); address != nil {
+				addresses = append(addresses, withType(p, address))
+			}
+		case "BDAY":
+			if bday := birthday(p.Value); bday != "" {
+				doc.M["birthday"] = bday
+			}
+		case "ORG":
+			if fields := p.Fields(); strings.TrimSpace(fields[0]) != "" {
+				doc.M["company"] = strings.TrimSpace(fields[0])
+			}
+		case "TITLE":
+			if title := strings.TrimSpace(p.Text()); title != "" {
+				doc.M["jobTitle"] = title
+			}
+		case "NOTE":
+			if note := strings.TrimSpace(p.Text()); note != "" {
+				doc.M["note"] = note
+			}
+		}
+	}
+
+	if len(name) > 0 {
+		doc.M["name"] = name
+	}
+	if len(emails) > 0 {
+		markPrimary(emails)
+		doc.M["email"] = asList(emails)
+	}
+	if len(phones) > 0 {
+		markPrimary(phones)
+		doc.M["phone"] = asList(phones)
+	}
+	if len(addresses) > 0 {
+		markPrimary(addresses)
+		doc.M["address"] = asList(addresses)
+	}
+
+	familyName, _ := name["familyName"].(string)
+	givenName, _ := name["givenName"].(string)
+	if _, ok := doc.M["fullname"]; !ok {
+		if fullname := strings.TrimSpace(givenName + " " + familyName); fullname != "" {
+			doc.M["fullname"] = fullname
+		}
+	}
+	displayName, _ := doc.M["fullname"].(string)
+	var email string
+	if len(emails) > 0 {
+		email, _ = emails[0]["address"].(string)
+		if displayName == "" {
+			displayName = email
+		}
+	}
+	if displayName != "" {
+		doc.M["displayName"] = displayName
+	}
+	doc.M["indexes"] = map[string]interface{}{
+		"byFamilyNameGivenNameEmailCozyUrl": strings.ToLower(familyName + givenName + email),
+	}
+	return doc
+}
+
+// AddGroup adds the group to the relationships of the contact.
+func (c *Contact) AddGroup(groupID string) {
+	rels, _ := c.M["relationships"].(map[string]interface{})
+	if rels == nil {
+		rels = map[string]interface{}{}
+		c.M["relationships"] = rels
+	}
+	groups, _ := rels["groups"].(map[string]interface{})
+	if groups == nil {
+		groups = map[string]interface{}{}
+		rels["groups"] = groups
+	}
+	data, _ := groups["data"].([]interface{})
+	for _, item := range data {
+		if ref, ok := item.(map[string]interface{}); ok && ref["_id"] == groupID {
+			return
+		}
+	}
+	groups["data"] = append(data, map[string]interface{}{
+		"_id":   groupID,
+		"_type": consts.Groups,
+	})
+}
+
+// withType adds the type of an email, phone or address from the TYPE
+// parameter of the vCard property.
+func withType(p *ical.Property, item map[string]interface{}) map[string]interface{} {
+	for _, value := range p.Params["TYPE"] {
+		for _, t := range strings.Split(value, ",") {
+			switch strings.ToLower(t) {
+			case "pref":
+				item["primary"] = true
+			case "home", "work", "cell", "fax", "voice", "other", "mobile":
+				if _, ok := item["type"]; !ok {
+					item["type"] = strings.ToLower(t)
+				}
+			}
+		}
+	}
+	if p.Param("PREF") == "1" {
+		item["primary"] = true
+	}
+	return item
+}
+
+// markPrimary ensures that exactly one item is marked as primary.
+func markPrimary(items []map[string]interface{}) {
+	found := false
+	for _, item := range items {
+		if primary, _ := item["primary"].(bool); primary {
+			if found {
+				delete(item, "primary")
+			}
+			found = true
+		}
+	}
+	if !found {
+		items[0]["primary"] = true
+	}
+}
+
+// addressFromFields returns an address from the fields of an ADR property:
+// post office box, extended address, street, locality, region, postal code
+// and country.
+func addressFromFields(fields []string) map[string]interface{} {
+	for len(fields) < 7 {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	// The extended address (apartment, suite) goes with the street
+	street := strings.TrimSpace(fields[2] + " " + fields[1])
+	address := map[string]interface{}{}
+	var formatted []string
+	for _, kv := range [][2]string{
+		{"pobox", fields[0]},
+		{"street", street},
+		{"city", fields[3]},
+		{"region", fields[4]},
+		{"postcode", fields[5]},
+		{"country", fields[6]},
+	} {
+		if kv[1] != "" {
+			address[kv[0]] = kv[1]
+			formatted = append(formatted, kv[1])
+		}
+	}
+	if len(address) == 0 {
+		return nil
+	}
+	address["formattedAddress"] = strings.Join(formatted, ", ")
+	return address
+}
+
+// asList converts the items to the type they have when the document is
+// read from CouchDB.
+func asList(items []map[string]interface{}) []interface{} {
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+	return list
+}
+
+// birthday returns the date of a BDAY property in the YYYY-MM-DD format, or
+// an empty string if it is not a full date.
+func birthday(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, 'T'); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.ReplaceAll(value, "-", "")
+	if len(value) != 8 {
+		return ""
+	}
+	return value[:4] + "-" + value[4:6] + "-" + value[6:]
+}
@@ -0,0 +1,189 @@
+// Package inbound is for the mails received by an instance: the users can
+// forward receipts and documents to a personal address, and the attachments
+// are stored in their Cozy.
+package inbound
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+const (
+	// DefaultFolder is the folder where the attachments are saved when no
+	// folder has been chosen by the user.
+	DefaultFolder = "/Inbox"
+
+	// DefaultMaxSize is the maximal size of a received mail, when it is not
+	// set in the configuration.
+	DefaultMaxSize = 25 * 1024 * 1024
+
+	tokenLength = 16
+)
+
+var (
+	// ErrNotConfigured is used when the stack has no domain for receiving
+	// mails.
+	ErrNotConfigured = errors.New("The inbound mails are not configured")
+	// ErrNotEnabled is used when the user has not enabled the inbound mails.
+	ErrNotEnabled = errors.New("The inbound mails are not enabled")
+	// ErrInvalidRecipient is used when the recipient address does not match
+	// an instance.
+	ErrInvalidRecipient = errors.New("Invalid recipient")
+	// ErrInvalidMessage is used when the mail cannot be parsed.
+	ErrInvalidMessage = errors.New("Invalid message")
+	// ErrMessageTooBig is used when the mail is larger than the limit.
+	ErrMessageTooBig = errors.New("The message is too big")
+)
+
+// Settings is the document with the parameters of the inbound mails for an
+// instance. The token is the secret part of the address.
+type Settings struct {
+	CouchID    string `json:"_id,omitempty"`
+	CouchRev   string `json:"_rev,omitempty"`
+	Token      string `json:"token"`
+	Folder     string `json:"folder"`
+	CreateNote bool   `json:"create_note"`
+}
+
+// ID returns the settings qualified identifier
+func (s *Settings) ID() string { return s.CouchID }
+
+// Rev returns the settings revision
+func (s *Settings) Rev() string { return s.CouchRev }
+
+// DocType returns the settings document type
+func (s *Settings) DocType() string { return consts.Settings }
+
+// Clone implements couchdb.Doc
+func (s *Settings) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID changes the settings qualified identifier
+func (s *Settings) SetID(id string) { s.CouchID = id }
+
+// SetRev changes the settings revision
+func (s *Settings) SetRev(rev string) { s.CouchRev = rev }
+
+// Address returns the email address where the mails can be sent to be
+// stored in the instance.
+func (s *Settings) Address(inst *instance.Instance) string {
+	domain := config.GetConfig().InboundMail.Domain
+	if domain == "" || s.Token == "" {
+		return ""
+	}
+	return inst.Domain + "+" + s.Token + "@" + domain
+}
+
+// GetSettings returns the settings of the inbound mails for the instance, or
+// ErrNotEnabled.
+func GetSettings(inst *instance.Instance) (*Settings, error) {
+	doc := &Settings{}
+	err := couchdb.GetDoc(inst, consts.Settings, consts.InboundMailSettingsID, doc)
+	if couchdb.IsNotFoundError(err) {
+		return nil, ErrNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Enable turns on the inbound mails for the instance, or updates their
+// parameters. A new token is generated if asked, which invalidates the
+// previous address.
+func Enable(inst *instance.Instance, folder string, createNote, regenerate bool) (*Settings, error) {
+	if config.GetConfig().InboundMail.Domain == "" {
+		return nil, ErrNotConfigured
+	}
+	if folder == "" {
+		folder = DefaultFolder
+	}
+	doc, err := GetSettings(inst)
+	if err == ErrNotEnabled {
+		doc = &Settings{
+			CouchID: consts.InboundMailSettingsID,
+			Token:   newToken(),
+		}
+	} else if err != nil {
+		return nil, err
+	} else if regenerate {
+		doc.Token = newToken()
+	}
+	doc.Folder = folder
+	doc.CreateNote = createNote
+	if doc.CouchRev == "" {
+		err = couchdb.CreateNamedDocWithDB(inst, doc)
+	} else {
+		err = couchdb.UpdateDoc(inst, doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Disable turns off the inbound mails for the instance.
+func Disable(inst *instance.Instance) error {
+	doc, err := GetSettings(inst)
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, doc)
+}
+
+func newToken() string {
+	return strings.ToLower(crypto.GenerateRandomString(tokenLength))
+}
+
+// Resolve returns the instance and its settings for the recipient address
+// of a mail, like alice.cozy.example+token@in.example.
+func Resolve(recipient string) (*instance.Instance, *Settings, error) {
+	domain := config.GetConfig().InboundMail.Domain
+	if domain == "" {
+		return nil, nil, ErrNotConfigured
+	}
+	recipient = strings.Trim(strings.TrimSpace(recipient), "<>")
+	local, host, ok := strings.Cut(recipient, "@")
+	if !ok || !strings.EqualFold(host, domain) {
+		return nil, nil, ErrInvalidRecipient
+	}
+	idx := strings.LastIndex(local, "+")
+	if idx <= 0 {
+		return nil, nil, ErrInvalidRecipient
+	}
+	instDomain, token := strings.ToLower(local[:idx]), strings.ToLower(local[idx+1:])
+
+	inst, err := lifecycle.GetInstance(instDomain)
+	if err != nil {
+		return nil, nil, ErrInvalidRecipient
+	}
+	settings, err := GetSettings(inst)
+	if err == ErrNotEnabled {
+		return nil, nil, ErrInvalidRecipient
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(settings.Token)) != 1 {
+		return nil, nil, ErrInvalidRecipient
+	}
+	return inst, settings, nil
+}
+
+// MaxSize returns the maximal size of a received mail.
+func MaxSize() int64 {
+	if size := config.GetConfig().InboundMail.MaxSize; size > 0 {
+		return size
+	}
+	return DefaultMaxSize
+}
@@ -0,0 +1,180 @@
+package inbound
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/filetype"
+	"github.com/gofrs/uuid/v5"
+)
+
+// Mail is the document created for a received mail. It is created after the
+// attachments have been saved, so that an @event trigger on this doctype can
+// be used by a service to classify them.
+type Mail struct {
+	DocID       string     `json:"_id,omitempty"`
+	DocRev      string     `json:"_rev,omitempty"`
+	MessageID   string     `json:"message_id,omitempty"`
+	From        string     `json:"from"`
+	Subject     string     `json:"subject"`
+	Date        *time.Time `json:"date,omitempty"`
+	DirID       string     `json:"dir_id"`
+	Attachments []string   `json:"attachments"`
+	NoteID      string     `json:"note_id,omitempty"`
+	ReceivedAt  time.Time  `json:"received_at"`
+}
+
+// ID returns the mail qualified identifier
+func (m *Mail) ID() string { return m.DocID }
+
+// Rev returns the mail revision
+func (m *Mail) Rev() string { return m.DocRev }
+
+// DocType returns the mail document type
+func (m *Mail) DocType() string { return consts.InboundMails }
+
+// Clone implements couchdb.Doc
+func (m *Mail) Clone() couchdb.Doc {
+	cloned := *m
+	cloned.Attachments = make([]string, len(m.Attachments))
+	copy(cloned.Attachments, m.Attachments)
+	if m.Date != nil {
+		date := *m.Date
+		cloned.Date = &date
+	}
+	return &cloned
+}
+
+// SetID changes the mail qualified identifier
+func (m *Mail) SetID(id string) { m.DocID = id }
+
+// SetRev changes the mail revision
+func (m *Mail) SetRev(rev string) { m.DocRev = rev }
+
+// Ingest stores a received mail in the instance: the attachments are saved
+// in the folder of the settings, and a note is created with the body if the
+// user has asked for it. A mail is stored only once: if it has already been
+// received (same Message-ID), the existing document is returned.
+func Ingest(inst *instance.Instance, settings *Settings, r io.Reader) (*Mail, error) {
+	body, err := bodyReader(r, MaxSize())
+	if err != nil {
+		return nil, err
+	}
+	msg, err := parseMessage(body)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Mail{
+		MessageID:   msg.messageID,
+		From:        msg.from,
+		Subject:     msg.subject,
+		Date:        msg.date,
+		Attachments: []string{},
+		ReceivedAt:  time.Now().UTC(),
+	}
+	if msg.messageID != "" {
+		sum := sha256.Sum256([]byte(msg.messageID))
+		doc.DocID = hex.EncodeToString(sum[:16])
+		existing := &Mail{}
+		err := couchdb.GetDoc(inst, consts.InboundMails, doc.DocID, existing)
+		if err == nil {
+			return existing, nil
+		}
+		if !couchdb.IsNotFoundError(err) {
+			return nil, err
+		}
+	} else {
+		id, _ := uuid.NewV7()
+		doc.DocID = id.String()
+	}
+
+	folder := settings.Folder
+	if folder == "" {
+		folder = DefaultFolder
+	}
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, folder)
+	if err != nil {
+		return nil, err
+	}
+	doc.DirID = dir.ID()
+
+	for _, att := range msg.attachments {
+		file, err := saveAttachment(inst, doc, att)
+		if err != nil {
+			return nil, err
+		}
+		doc.Attachments = append(doc.Attachments, file.ID())
+	}
+
+	if settings.CreateNote && msg.text != "" {
+		title := msg.subject
+		if title == "" {
+			title = msg.from
+		}
+		file, err := note.ImportText(inst, title, doc.DirID, msg.text)
+		if err != nil {
+			inst.Logger().WithNamespace("inbound").
+				Warnf("Cannot create the note for a mail: %s", err)
+		} else {
+			doc.NoteID = file.ID()
+		}
+	}
+
+	if err := couchdb.CreateNamedDocWithDB(inst, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// saveAttachment creates a file for an attachment, with a reference to the
+// mail.
+func saveAttachment(inst *instance.Instance, doc *Mail, att *attachment) (*vfs.FileDoc, error) {
+	fs := inst.VFS()
+	name := att.name
+	if exists, err := fs.GetIndexer().DirChildExists(doc.DirID, name); err != nil {
+		return nil, err
+	} else if exists {
+		name = vfs.ConflictName(fs, doc.DirID, name, true)
+	}
+
+	mime, class := vfs.ExtractMimeAndClass(att.mime)
+	if mime == filetype.DefaultType {
+		mime, class = vfs.ExtractMimeAndClassFromFilename(name)
+	}
+	cdate := doc.ReceivedAt
+	if doc.Date != nil {
+		cdate = *doc.Date
+	}
+	filedoc, err := vfs.NewFileDoc(name, doc.DirID, int64(len(att.data)), nil,
+		mime, class, cdate, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	filedoc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+	filedoc.AddReferencedBy(couchdb.DocReference{
+		ID:   doc.DocID,
+		Type: consts.InboundMails,
+	})
+
+	file, err := fs.CreateFile(filedoc, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write(att.data)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filedoc, nil
+}
@@ -0,0 +1,232 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxMIMEDepth is the maximal level of nested multipart that is read.
+const maxMIMEDepth = 10
+
+// attachment is a file attached to a received mail.
+type attachment struct {
+	name string
+	mime string
+	data []byte
+}
+
+// message is a received mail, after its MIME structure has been parsed.
+type message struct {
+	messageID   string
+	from        string
+	subject     string
+	date        *time.Time
+	text        string
+	html        string
+	attachments []*attachment
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+			data, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+			return strings.NewReader(latin1ToUTF8(data)), nil
+		}
+		return nil, fmt.Errorf("unhandled charset %q", charset)
+	},
+}
+
+// parseMessage reads a mail in the RFC 5322 format.
+func parseMessage(r io.Reader) (*message, error) {
+	msg, err := netmail.ReadMessage(r)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	m := &message{
+		messageID: strings.Trim(strings.TrimSpace(msg.Header.Get("Message-Id")), "<>"),
+		subject:   decodeHeader(msg.Header.Get("Subject")),
+	}
+	if from, err := msg.Header.AddressList("From"); err == nil && len(from) > 0 {
+		m.from = from[0].Address
+	} else {
+		m.from = decodeHeader(msg.Header.Get("From"))
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		m.date = &date
+	}
+	header := textproto.MIMEHeader(msg.Header)
+	if err := m.walk(header, msg.Body, 0); err != nil {
+		return nil, err
+	}
+	if m.text == "" && m.html != "" {
+		m.text = htmlToText(m.html)
+	}
+	return m, nil
+}
+
+// walk reads a MIME part, and its children for a multipart.
+func (m *message) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return nil
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return ErrInvalidMessage
+			}
+			if err := m.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return ErrInvalidMessage
+	}
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dparams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+
+	isBody := disposition != "attachment" && filename == ""
+	switch {
+	case isBody && mediaType == "text/plain":
+		if m.text == "" {
+			m.text = decodeCharset(params["charset"], data)
+		}
+	case isBody && mediaType == "text/html":
+		if m.html == "" {
+			m.html = decodeCharset(params["charset"], data)
+		}
+	case disposition == "inline" && strings.HasPrefix(mediaType, "image/"):
+		// The inline images are often logos in the signatures
+	case len(data) > 0:
+		m.attachments = append(m.attachments, &attachment{
+			name: attachmentName(filename, mediaType, len(m.attachments)+1),
+			mime: mediaType,
+			data: data,
+		})
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		return latin1ToUTF8(data)
+	}
+	if !utf8.Valid(data) {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(data)
+}
+
+func latin1ToUTF8(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+var (
+	htmlBreaks  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlIgnored = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	htmlTags    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText returns the text of an HTML body, for the mails without a plain
+// text alternative.
+func htmlToText(html string) string {
+	text := htmlIgnored.ReplaceAllString(html, "")
+	text = htmlBreaks.ReplaceAllString(text, "\n")
+	text = htmlTags.ReplaceAllString(text, "")
+	text = strings.NewReplacer("&nbsp;", " ", "&lt;", "<", "&gt;", ">",
+		"&quot;", `"`, "&#39;", "'", "&amp;", "&").Replace(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+// attachmentName returns a valid filename for an attachment.
+func attachmentName(filename, mediaType string, n int) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, path.Base(strings.ReplaceAll(filename, "\\", "/")))
+	name = strings.TrimSpace(name)
+	if name != "" && name != "." && name != ".." {
+		return name
+	}
+	name = fmt.Sprintf("attachment %d", n)
+	if mediaType == "message/rfc822" {
+		return name + ".eml"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return name + exts[0]
+	}
+	return name
+}
+
+// bodyReader returns a reader for the mail with a limit on its size.
+func bodyReader(r io.Reader, max int64) (io.Reader, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrMessageTooBig
+	}
+	return bytes.NewReader(data), nil
+}
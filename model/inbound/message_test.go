@@ -0,0 +1,96 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const receipt = "From: =?UTF-8?Q?Caf=C3=A9_du_coin?= <shop@example.com>\r\n" +
+	"To: alice.cozy.example+token@in.example.org\r\n" +
+	"Subject: =?UTF-8?B?UmXDp3UgbsKwNDI=?=\r\n" +
+	"Date: Mon, 19 Oct 2026 10:00:00 +0200\r\n" +
+	"Message-ID: <42@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Merci pour votre achat =E0 bient=F4t.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Merci</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: inline; filename=logo.png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename*=UTF-8''re%C3%A7u%2042.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"JSVFT0YK\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream; name=\"../../etc/passwd\"\r\n" +
+	"\r\n" +
+	"nope\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	msg, err := parseMessage(strings.NewReader(receipt))
+	require.NoError(t, err)
+	assert.Equal(t, "42@example.com", msg.messageID)
+	assert.Equal(t, "shop@example.com", msg.from)
+	assert.Equal(t, "Reçu n°42", msg.subject)
+	require.NotNil(t, msg.date)
+	assert.Equal(t, 2026, msg.date.Year())
+	assert.Equal(t, "Merci pour votre achat à bientôt.", msg.text)
+	assert.Equal(t, "<p>Merci</p>", msg.html)
+
+	require.Len(t, msg.attachments, 2)
+	assert.Equal(t, "reçu 42.pdf", msg.attachments[0].name)
+	assert.Equal(t, "application/pdf", msg.attachments[0].mime)
+	assert.Equal(t, "%PDF-1.4\n%%EOF\n", string(msg.attachments[0].data))
+	assert.Equal(t, "passwd", msg.attachments[1].name)
+}
+
+func TestParseMessageHTMLOnly(t *testing.T) {
+	raw := "From: shop@example.com\r\n" +
+		"Subject: Hello\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<html><head><style>p { color: red }</style></head>" +
+		"<body><p>Hello &amp; welcome</p><p>Bye<br>Bob</p></body></html>"
+	msg, err := parseMessage(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello & welcome\nBye\nBob", msg.text)
+	assert.Empty(t, msg.attachments)
+
+	_, err = parseMessage(strings.NewReader("not a mail"))
+	assert.Equal(t, ErrInvalidMessage, err)
+}
+
+func TestBodyReader(t *testing.T) {
+	_, err := bodyReader(strings.NewReader("12345"), 4)
+	assert.Equal(t, ErrMessageTooBig, err)
+	_, err = bodyReader(strings.NewReader("1234"), 4)
+	assert.NoError(t, err)
+}
+
+func TestAttachmentName(t *testing.T) {
+	assert.Equal(t, "invoice.pdf", attachmentName("invoice.pdf", "application/pdf", 1))
+	assert.Equal(t, "attachment 2.eml", attachmentName("", "message/rfc822", 2))
+	assert.Equal(t, "attachment 3", attachmentName("..", "application/x-unknown", 3))
+}
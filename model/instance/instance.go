@@ -116,6 +116,10 @@ type Instance struct {
 	// FeatureSets is a list of feature sets from the manager
 	FeatureSets []string `json:"feature_sets,omitempty"`
 
+	// BackupPublicKey is the public key of the user for encrypting the
+	// backups, when the user holds the key instead of the operator.
+	BackupPublicKey []byte `json:"backup_public_key,omitempty"`
+
 	// LastActivityFromDeletedOAuthClients is the date of the last activity for
 	// OAuth clients that have been deleted
 	LastActivityFromDeletedOAuthClients *time.Time `json:"last_activity_from_deleted_oauth_clients,omitempty"`
@@ -168,6 +172,8 @@ func (i *Instance) Clone() couchdb.Doc {
 
 	cloned.CLISecret = make([]byte, len(i.CLISecret))
 	copy(cloned.CLISecret, i.CLISecret)
+
+	cloned.BackupPublicKey = append([]byte(nil), i.BackupPublicKey...)
 	return &cloned
 }
 
@@ -0,0 +1,50 @@
+package job
+
+import (
+	"time"
+)
+
+// BackoffStrategy is the strategy used to compute the delay before retrying a
+// job that has failed.
+type BackoffStrategy string
+
+const (
+	// BackoffExponential doubles the delay after each failure.
+	BackoffExponential BackoffStrategy = "exponential"
+	// BackoffConstant uses the same delay for all the retries.
+	BackoffConstant BackoffStrategy = "constant"
+)
+
+// IsValid returns true if the strategy is one of the known strategies.
+func (b BackoffStrategy) IsValid() bool {
+	return b == BackoffExponential || b == BackoffConstant
+}
+
+// retryDelay returns the delay to wait before the next execution of a job
+// that has already been executed execCount times (execCount >= 1). The random
+// parameter is a number in [0, 1) used for the jitter.
+func (c *WorkerConfig) retryDelay(execCount int, random float64) time.Duration {
+	delay := c.RetryDelay
+	if c.Backoff != BackoffConstant {
+		for i := 1; i < execCount; i++ {
+			delay *= 2
+			if c.MaxRetryDelay > 0 && delay >= c.MaxRetryDelay {
+				break
+			}
+		}
+	}
+	if c.MaxRetryDelay > 0 && delay > c.MaxRetryDelay {
+		delay = c.MaxRetryDelay
+	}
+
+	// The jitter makes the delay a random number between
+	// delay * (1 +/- jitter)
+	if c.Jitter != nil && *c.Jitter > 0 {
+		fuzz := *c.Jitter * float64(delay)
+		delay += time.Duration((2*random - 1) * fuzz)
+	}
+	if c.MaxRetryDelay > 0 && delay > c.MaxRetryDelay {
+		delay = c.MaxRetryDelay
+	}
+	return delay
+}
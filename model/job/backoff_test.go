@@ -0,0 +1,62 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	t.Run("Exponential", func(t *testing.T) {
+		c := &WorkerConfig{
+			RetryDelay:    1 * time.Second,
+			MaxRetryDelay: 10 * time.Second,
+			Backoff:       BackoffExponential,
+		}
+		assert.Equal(t, 1*time.Second, c.retryDelay(1, 0.5))
+		assert.Equal(t, 2*time.Second, c.retryDelay(2, 0.5))
+		assert.Equal(t, 4*time.Second, c.retryDelay(3, 0.5))
+		assert.Equal(t, 8*time.Second, c.retryDelay(4, 0.5))
+		assert.Equal(t, 10*time.Second, c.retryDelay(5, 0.5))
+		assert.Equal(t, 10*time.Second, c.retryDelay(100, 0.5))
+	})
+
+	t.Run("Constant", func(t *testing.T) {
+		c := &WorkerConfig{
+			RetryDelay:    3 * time.Second,
+			MaxRetryDelay: 10 * time.Second,
+			Backoff:       BackoffConstant,
+		}
+		assert.Equal(t, 3*time.Second, c.retryDelay(1, 0.5))
+		assert.Equal(t, 3*time.Second, c.retryDelay(5, 0.5))
+	})
+
+	t.Run("Jitter", func(t *testing.T) {
+		jitter := 0.1
+		c := &WorkerConfig{
+			RetryDelay:    10 * time.Second,
+			MaxRetryDelay: 1 * time.Minute,
+			Backoff:       BackoffExponential,
+			Jitter:        &jitter,
+		}
+		assert.Equal(t, 9*time.Second, c.retryDelay(1, 0))
+		assert.Equal(t, 10*time.Second, c.retryDelay(1, 0.5))
+		assert.Equal(t, 22*time.Second, c.retryDelay(2, 1))
+		assert.Equal(t, 1*time.Minute, c.retryDelay(4, 1))
+	})
+
+	t.Run("DefaultJitter", func(t *testing.T) {
+		w := &Worker{Conf: &WorkerConfig{}}
+		c := w.defaultedConf(nil)
+		if assert.NotNil(t, c.Jitter) {
+			assert.Equal(t, defaultJitter, *c.Jitter)
+		}
+
+		noJitter := 0.0
+		w = &Worker{Conf: &WorkerConfig{RetryDelay: 10 * time.Second, Jitter: &noJitter}}
+		c = w.defaultedConf(nil)
+		assert.Equal(t, 10*time.Second, c.retryDelay(1, 0))
+		assert.Equal(t, 10*time.Second, c.retryDelay(1, 1))
+	})
+}
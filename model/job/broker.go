@@ -26,15 +26,26 @@ const (
 	Done State = "done"
 	// Errored state
 	Errored State = "errored"
+	// Cancelled state
+	Cancelled State = "cancelled"
+)
+
+const (
+	// PriorityInteractive is the priority of the jobs that a user is waiting
+	// for, like a konnector launched manually from an application.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBackground is the priority of the other jobs.
+	PriorityBackground Priority = "background"
 )
 
 // defaultMaxLimits defines the maximum limit of how much jobs will be returned
 // for each job state
 var defaultMaxLimits map[State]int = map[State]int{
-	Queued:  50,
-	Running: 50,
-	Done:    50,
-	Errored: 50,
+	Queued:    50,
+	Running:   50,
+	Done:      50,
+	Errored:   50,
+	Cancelled: 50,
 }
 
 type (
@@ -51,10 +62,18 @@ type (
 		// PushJob will push try to push a new job from the specified job request.
 		// This method is asynchronous.
 		PushJob(db prefixer.Prefixer, request *JobRequest) (*Job, error)
+		// CancelJob removes a queued job from its queue, or asks to the worker
+		// that executes a running job to stop it. The state of the job will be
+		// cancelled when the worker has stopped.
+		CancelJob(job *Job) error
 
 		// WorkerQueueLen returns the total element in the queue of the specified
 		// worker type.
 		WorkerQueueLen(workerType string) (int, error)
+		// WorkerQueueLenByInstance returns the number of elements in the queue
+		// of the specified worker type for each instance, indexed by domain.
+		// The instances without queued jobs are not included.
+		WorkerQueueLenByInstance(workerType string) (map[string]int, error)
 		// WorkerIsReserved returns true if the given worker type is reserved
 		// (ie clients should not push jobs to it, only the stack).
 		WorkerIsReserved(workerType string) (bool, error)
@@ -65,6 +84,9 @@ type (
 	// State represent the state of a job.
 	State string
 
+	// Priority is the priority of a job in the queue of its worker type.
+	Priority string
+
 	// Message is a json encoded job message.
 	Message json.RawMessage
 
@@ -89,6 +111,7 @@ type (
 		Payload     Payload     `json:"payload,omitempty"`
 		Manual      bool        `json:"manual_execution,omitempty"`
 		Debounced   bool        `json:"debounced,omitempty"`
+		Priority    Priority    `json:"priority,omitempty"`
 		Options     *JobOptions `json:"options,omitempty"`
 		State       State       `json:"state"`
 		QueuedAt    time.Time   `json:"queued_at"`
@@ -109,7 +132,10 @@ type (
 		Manual      bool
 		Debounced   bool
 		ForwardLogs bool
-		Options     *JobOptions
+		// Priority is optional: by default, the manual jobs are interactive,
+		// and the other jobs are in background.
+		Priority Priority
+		Options  *JobOptions
 	}
 
 	// JobOptions struct contains the execution properties of the jobs.
@@ -286,6 +312,8 @@ func (j *Job) WaitUntilDone(db prefixer.Prefixer) error {
 				return nil
 			case Errored:
 				return errors.New("The konnector failed on account deletion")
+			case Cancelled:
+				return ErrCancelled
 			}
 		case <-timeout:
 			return nil
@@ -323,8 +351,30 @@ func (m Message) MarshalJSON() ([]byte, error) {
 	return json.Marshal(v)
 }
 
+// IsValid returns true if the priority is one of the known priorities.
+func (p Priority) IsValid() bool {
+	return p == PriorityInteractive || p == PriorityBackground
+}
+
+// IsInteractive returns true if the job should be processed in priority, as
+// a user is waiting for it.
+func (j *Job) IsInteractive() bool {
+	if j.Priority == "" {
+		// Jobs created before the priorities were introduced
+		return j.Manual
+	}
+	return j.Priority == PriorityInteractive
+}
+
 // NewJob creates a new Job instance from a job request.
 func NewJob(db prefixer.Prefixer, req *JobRequest) *Job {
+	priority := req.Priority
+	if !priority.IsValid() {
+		priority = PriorityBackground
+		if req.Manual {
+			priority = PriorityInteractive
+		}
+	}
 	return &Job{
 		Cluster:     db.DBCluster(),
 		Domain:      db.DomainName(),
@@ -334,6 +384,7 @@ func NewJob(db prefixer.Prefixer, req *JobRequest) *Job {
 		Manual:      req.Manual,
 		Message:     req.Message,
 		Debounced:   req.Debounced,
+		Priority:    priority,
 		Event:       req.Event,
 		Payload:     req.Payload,
 		Options:     req.Options,
@@ -456,7 +507,7 @@ func GetLastsJobs(jobs []*Job, workerType string) ([]*Job, error) {
 	// Ordering by QueuedAt before filtering jobs
 	sort.Slice(jobs, func(i, j int) bool { return jobs[i].QueuedAt.Before(jobs[j].QueuedAt) })
 
-	for _, state := range []State{Queued, Running, Done, Errored} {
+	for _, state := range []State{Queued, Running, Done, Errored, Cancelled} {
 		limit := defaultMaxLimits[state]
 
 		filtered := FilterByWorkerAndState(jobs, workerType, state, limit)
@@ -43,6 +43,11 @@ func (m *BrokerMock) PushJob(db prefixer.Prefixer, request *JobRequest) (*Job, e
 	return args.Get(0).(*Job), args.Error(1)
 }
 
+// CancelJob mock method.
+func (m *BrokerMock) CancelJob(job *Job) error {
+	return m.Called(job).Error(0)
+}
+
 // WorkerQueueLen mock method.
 func (m *BrokerMock) WorkerQueueLen(workerType string) (int, error) {
 	args := m.Called(workerType)
@@ -50,6 +55,17 @@ func (m *BrokerMock) WorkerQueueLen(workerType string) (int, error) {
 	return args.Int(0), args.Error(1)
 }
 
+// WorkerQueueLenByInstance mock method.
+func (m *BrokerMock) WorkerQueueLenByInstance(workerType string) (map[string]int, error) {
+	args := m.Called(workerType)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
 // WorkerIsReserved mock method.
 func (m *BrokerMock) WorkerIsReserved(workerType string) (bool, error) {
 	args := m.Called(workerType)
@@ -0,0 +1,96 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// runningJobs is the registry of the jobs executed by the workers of this
+// process, with the functions to cancel their contexts.
+var runningJobs = struct {
+	sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}{cancels: make(map[string]context.CancelCauseFunc)}
+
+func registerRunningJob(jobID string, cancel context.CancelCauseFunc) {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+	runningJobs.cancels[jobID] = cancel
+}
+
+func unregisterRunningJob(jobID string) {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+	delete(runningJobs.cancels, jobID)
+}
+
+// cancelRunningJob cancels the context of a job executed by a worker of this
+// process. It returns false if the job is not executed by this process.
+func cancelRunningJob(jobID string) bool {
+	runningJobs.Lock()
+	cancel, ok := runningJobs.cancels[jobID]
+	runningJobs.Unlock()
+	if ok {
+		cancel(ErrCancelled)
+	}
+	return ok
+}
+
+// IsFinished returns true if the job is done, errored or cancelled.
+func (j *Job) IsFinished() bool {
+	return j.State == Done || j.State == Errored || j.State == Cancelled
+}
+
+// Cancel sets the job infos state to Cancelled and sends the new job infos on
+// the channel.
+func (j *Job) Cancel() error {
+	j.Logger().Debugf("cancel %s", j.ID())
+	j.FinishedAt = time.Now()
+	j.State = Cancelled
+	j.Event = nil
+	j.Payload = nil
+	return couchdb.UpdateDoc(j, j)
+}
+
+// cancelJob is the common part of the cancellation of a job for the brokers.
+// The dequeue function removes a queued job from the queue of the broker, and
+// the notify function asks to the process that executes a running job to
+// cancel it.
+//
+// A job can be taken from the queue by a worker before it is marked as
+// running. If it is cancelled in the meantime, the update of the job by the
+// worker is refused with a conflict, as the job document has a new revision,
+// and the worker skips it. Else, the worker has already registered the job
+// as running in its process when the job is marked as running, and notify can
+// find it.
+func cancelJob(job *Job, dequeue func(*Job) error, notify func(*Job) error) error {
+	for i := 0; i < 3; i++ {
+		if job.IsFinished() {
+			return ErrJobFinished
+		}
+		if job.State == Running {
+			if job.WorkerType == "client" {
+				return job.Cancel()
+			}
+			return notify(job)
+		}
+
+		if err := dequeue(job); err != nil {
+			return err
+		}
+		err := job.Cancel()
+		if !couchdb.IsConflictError(err) {
+			return err
+		}
+		// The job has been taken by a worker in the meantime
+		fresh, err := Get(job, job.ID())
+		if err != nil {
+			return err
+		}
+		*job = *fresh
+	}
+	return ErrJobFinished
+}
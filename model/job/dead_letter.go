@@ -0,0 +1,204 @@
+package job
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// deadLetterRetention is the duration the dead letters are kept.
+	deadLetterRetention = 30 * 24 * time.Hour
+	// maxDeadLetters is the maximal number of dead letters kept for an
+	// instance.
+	maxDeadLetters = 100
+)
+
+// DeadLetter is a job that has failed permanently, ie after all its retries.
+// It keeps the last error, and the payload of the job to be able to replay
+// it.
+type DeadLetter struct {
+	DocID      string      `json:"_id,omitempty"`
+	DocRev     string      `json:"_rev,omitempty"`
+	JobID      string      `json:"job_id"`
+	WorkerType string      `json:"worker"`
+	TriggerID  string      `json:"trigger_id,omitempty"`
+	Message    Message     `json:"message"`
+	Event      Event       `json:"event,omitempty"`
+	Payload    Payload     `json:"payload,omitempty"`
+	Manual     bool        `json:"manual_execution,omitempty"`
+	Priority   Priority    `json:"priority,omitempty"`
+	Options    *JobOptions `json:"options,omitempty"`
+	Error      string      `json:"error"`
+	ExecCount  int         `json:"exec_count"`
+	QueuedAt   time.Time   `json:"queued_at"`
+	FailedAt   time.Time   `json:"failed_at"`
+}
+
+// ID implements the couchdb.Doc interface
+func (d *DeadLetter) ID() string { return d.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (d *DeadLetter) Rev() string { return d.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (d *DeadLetter) DocType() string { return consts.JobsDeadLetters }
+
+// SetID implements the couchdb.Doc interface
+func (d *DeadLetter) SetID(id string) { d.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (d *DeadLetter) SetRev(rev string) { d.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (d *DeadLetter) Clone() couchdb.Doc {
+	cloned := *d
+	if d.Options != nil {
+		tmp := *d.Options
+		cloned.Options = &tmp
+	}
+	cloned.Message = append(Message(nil), d.Message...)
+	cloned.Event = append(Event(nil), d.Event...)
+	cloned.Payload = append(Payload(nil), d.Payload...)
+	return &cloned
+}
+
+// newDeadLetter returns a dead letter for a job that has failed permanently.
+// The event and the payload must be taken before they are removed from the
+// job by Nack.
+func newDeadLetter(job *Job, event Event, payload Payload, errorMessage string, execCount int) *DeadLetter {
+	return &DeadLetter{
+		JobID:      job.ID(),
+		WorkerType: job.WorkerType,
+		TriggerID:  job.TriggerID,
+		Message:    job.Message,
+		Event:      event,
+		Payload:    payload,
+		Manual:     job.Manual,
+		Priority:   job.Priority,
+		Options:    job.Options,
+		Error:      errorMessage,
+		ExecCount:  execCount,
+		QueuedAt:   job.QueuedAt,
+		FailedAt:   time.Now(),
+	}
+}
+
+// saveDeadLetter creates a dead letter, and deletes the old ones: they are
+// kept for deadLetterRetention, and at most maxDeadLetters for an instance.
+func saveDeadLetter(db prefixer.Prefixer, letter *DeadLetter) error {
+	if err := couchdb.CreateDoc(db, letter); err != nil {
+		return err
+	}
+	letters, err := ListDeadLetters(db, "")
+	if err != nil {
+		return err
+	}
+	expired := expiredDeadLetters(letters, time.Now())
+	if len(expired) == 0 {
+		return nil
+	}
+	return couchdb.BulkDeleteDocs(db, consts.JobsDeadLetters, expired)
+}
+
+// expiredDeadLetters returns the dead letters that are too old, or over the
+// maximal number of dead letters.
+func expiredDeadLetters(letters []*DeadLetter, now time.Time) []couchdb.Doc {
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.After(letters[j].FailedAt)
+	})
+	limit := now.Add(-deadLetterRetention)
+	var expired []couchdb.Doc
+	for i, letter := range letters {
+		if i >= maxDeadLetters || letter.FailedAt.Before(limit) {
+			expired = append(expired, letter)
+		}
+	}
+	return expired
+}
+
+// ListDeadLetters returns the dead letters of an instance, optionally
+// filtered by worker type.
+func ListDeadLetters(db prefixer.Prefixer, workerType string) ([]*DeadLetter, error) {
+	var letters []*DeadLetter
+	err := couchdb.ForeachDocs(db, consts.JobsDeadLetters, func(_ string, data json.RawMessage) error {
+		var letter DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return err
+		}
+		if workerType == "" || letter.WorkerType == workerType {
+			letters = append(letters, &letter)
+		}
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns the dead letter with the given identifier.
+func GetDeadLetter(db prefixer.Prefixer, id string) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := couchdb.GetDoc(db, consts.JobsDeadLetters, id, &letter); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrNotFoundDeadLetter
+		}
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// Retry pushes a new job with the same parameters as the failed job, and
+// removes the dead letter. The worker type is checked again, as only the
+// workers that keep their failed jobs can have dead letters.
+func (d *DeadLetter) Retry(db prefixer.Prefixer) (*Job, error) {
+	conf, ok := findWorkerByType(d.WorkerType)
+	if !ok {
+		return nil, ErrUnknownWorker
+	}
+	if conf.NoDeadLetter {
+		return nil, ErrInvalidDeadLetter
+	}
+	req := &JobRequest{
+		WorkerType: d.WorkerType,
+		TriggerID:  d.TriggerID,
+		Message:    d.Message,
+		Event:      d.Event,
+		Payload:    d.Payload,
+		Manual:     d.Manual,
+		Priority:   d.Priority,
+		Options:    d.Options,
+	}
+	job, err := System().PushJob(db, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := couchdb.DeleteDoc(db, d); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// PurgeDeadLetters deletes the dead letters of an instance, optionally
+// filtered by worker type, and returns the number of deleted dead letters.
+func PurgeDeadLetters(db prefixer.Prefixer, workerType string) (int, error) {
+	letters, err := ListDeadLetters(db, workerType)
+	if err != nil {
+		return 0, err
+	}
+	docs := make([]couchdb.Doc, len(letters))
+	for i, letter := range letters {
+		docs[i] = letter
+	}
+	if err := couchdb.BulkDeleteDocs(db, consts.JobsDeadLetters, docs); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+var _ couchdb.Doc = (*DeadLetter)(nil)
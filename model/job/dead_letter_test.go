@@ -0,0 +1,40 @@
+package job
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiredDeadLetters(t *testing.T) {
+	now := time.Now()
+	old := &DeadLetter{DocID: "old", FailedAt: now.Add(-deadLetterRetention - time.Hour)}
+	recent := &DeadLetter{DocID: "recent", FailedAt: now.Add(-time.Hour)}
+	assert.Len(t, expiredDeadLetters([]*DeadLetter{recent}, now), 0)
+	expired := expiredDeadLetters([]*DeadLetter{old, recent}, now)
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, "old", expired[0].ID())
+	}
+
+	letters := make([]*DeadLetter, maxDeadLetters+2)
+	for i := range letters {
+		letters[i] = &DeadLetter{
+			DocID:    fmt.Sprintf("letter-%d", i),
+			FailedAt: now.Add(-time.Duration(i) * time.Minute),
+		}
+	}
+	expired = expiredDeadLetters(letters, now)
+	if assert.Len(t, expired, 2) {
+		assert.Equal(t, fmt.Sprintf("letter-%d", maxDeadLetters), expired[0].ID())
+		assert.Equal(t, fmt.Sprintf("letter-%d", maxDeadLetters+1), expired[1].ID())
+	}
+}
+
+func TestRetryDeadLetterUnknownWorker(t *testing.T) {
+	letter := &DeadLetter{DocID: "letter", WorkerType: "no-such-worker"}
+	_, err := letter.Retry(prefixer.NewPrefixer(0, "cozy.localhost", "cozy.localhost"))
+	assert.ErrorIs(t, err, ErrUnknownWorker)
+}
@@ -20,6 +20,19 @@ var (
 	ErrMessageNil = errors.New("jobs: message is nil")
 	// ErrMessageUnmarshal is used when unmarshalling a message causes an error
 	ErrMessageUnmarshal = errors.New("jobs: message unmarshal")
+	// ErrNotFoundDeadLetter is used when the dead letter could not be found
+	ErrNotFoundDeadLetter = errors.New("jobs: dead letter not found")
+	// ErrInvalidDeadLetter is used when a dead letter is for a worker that
+	// does not keep its failed jobs as dead letters
+	ErrInvalidDeadLetter = errors.New("jobs: invalid dead letter")
+	// ErrInvalidPriority is used when the priority of a job is not known
+	ErrInvalidPriority = errors.New("jobs: invalid priority")
+	// ErrCancelled is used as the cause of the cancellation of the context
+	// of a job that has been cancelled
+	ErrCancelled = errors.New("jobs: cancelled")
+	// ErrJobFinished is used when a job can't be cancelled because it is
+	// already finished
+	ErrJobFinished = errors.New("jobs: the job is already finished")
 	// ErrAbort can be used to abort the execution of the job without causing
 	// errors.
 	ErrAbort = errors.New("jobs: abort")
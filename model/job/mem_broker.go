@@ -17,14 +17,27 @@ import (
 
 type (
 	// memQueue is a queue in-memory implementation of the Queue interface.
+	//
+	// The jobs are stored by priority, and then by instance. The instances are
+	// served in a round-robin fashion, to avoid that an instance with a lot of
+	// jobs starves the other instances.
 	memQueue struct {
 		MaxCapacity int
 		Jobs        chan *Job
 		closed      chan struct{}
 
-		list *list.List
-		run  bool
-		jmu  sync.RWMutex
+		interactive *fairQueue
+		background  *fairQueue
+		served      int
+		run         bool
+		jmu         sync.RWMutex
+	}
+
+	// fairQueue is a list of jobs grouped by instance.
+	fairQueue struct {
+		byDomain map[string]*list.List
+		domains  *list.List // the domains with jobs, in the round-robin order
+		len      int
 	}
 
 	// memBroker is an in-memory broker implementation of the Broker interface.
@@ -39,17 +52,87 @@ type (
 // newMemQueue creates and a new in-memory queue.
 func newMemQueue(workerType string) *memQueue {
 	return &memQueue{
-		list:   list.New(),
-		Jobs:   make(chan *Job),
-		closed: make(chan struct{}),
+		interactive: newFairQueue(),
+		background:  newFairQueue(),
+		Jobs:        make(chan *Job),
+		closed:      make(chan struct{}),
+	}
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		byDomain: make(map[string]*list.List),
+		domains:  list.New(),
+	}
+}
+
+func (f *fairQueue) push(job *Job) {
+	jobs, ok := f.byDomain[job.Domain]
+	if !ok {
+		jobs = list.New()
+		f.byDomain[job.Domain] = jobs
+		f.domains.PushBack(job.Domain)
+	}
+	jobs.PushBack(job)
+	f.len++
+}
+
+// pop takes the first job of the next instance, and puts this instance at the
+// end of the round if it still has jobs.
+func (f *fairQueue) pop() *Job {
+	e := f.domains.Front()
+	if e == nil {
+		return nil
+	}
+	f.domains.Remove(e)
+	domain := e.Value.(string)
+	jobs := f.byDomain[domain]
+	job := jobs.Remove(jobs.Front()).(*Job)
+	if jobs.Len() > 0 {
+		f.domains.PushBack(domain)
+	} else {
+		delete(f.byDomain, domain)
+	}
+	f.len--
+	return job
+}
+
+// remove removes the job with the given identifier from the queue of its
+// instance, and returns true if it was in the queue.
+func (f *fairQueue) remove(domain, jobID string) bool {
+	jobs, ok := f.byDomain[domain]
+	if !ok {
+		return false
+	}
+	for e := jobs.Front(); e != nil; e = e.Next() {
+		if e.Value.(*Job).ID() != jobID {
+			continue
+		}
+		jobs.Remove(e)
+		f.len--
+		if jobs.Len() == 0 {
+			delete(f.byDomain, domain)
+			for d := f.domains.Front(); d != nil; d = d.Next() {
+				if d.Value.(string) == domain {
+					f.domains.Remove(d)
+					break
+				}
+			}
+		}
+		return true
 	}
+	return false
 }
 
 // Enqueue into the queue
 func (q *memQueue) Enqueue(job *Job) error {
 	q.jmu.Lock()
 	defer q.jmu.Unlock()
-	q.list.PushBack(job.Clone())
+	if job.IsInteractive() {
+		q.interactive.push(job.Clone().(*Job))
+	} else {
+		q.background.push(job.Clone().(*Job))
+	}
 	if !q.run {
 		q.run = true
 		go q.send()
@@ -57,21 +140,42 @@ func (q *memQueue) Enqueue(job *Job) error {
 	return nil
 }
 
+// pop returns the next job to send to the workers. The interactive jobs are
+// taken in priority, except for one in three jobs, to avoid a starvation of
+// the background jobs.
+func (q *memQueue) pop() *Job {
+	first, second := q.interactive, q.background
+	if q.served%3 == 2 {
+		first, second = second, first
+	}
+	job := first.pop()
+	if job == nil {
+		job = second.pop()
+	}
+	if job != nil {
+		q.served++
+	}
+	return job
+}
+
 func (q *memQueue) send() {
 	for {
 		q.jmu.Lock()
-		e := q.list.Front()
-		if e == nil || !q.run {
+		if !q.run {
+			q.jmu.Unlock()
+			return
+		}
+		job := q.pop()
+		if job == nil {
 			q.run = false
 			q.jmu.Unlock()
 			return
 		}
-		q.list.Remove(e)
 		q.jmu.Unlock()
 		select {
 		case <-q.closed:
 			return
-		case q.Jobs <- e.Value.(*Job):
+		case q.Jobs <- job:
 		}
 	}
 }
@@ -86,11 +190,33 @@ func (q *memQueue) close() {
 	go func() { q.closed <- struct{}{} }()
 }
 
+// Remove removes a job from the queue, and returns true if it was in the
+// queue.
+func (q *memQueue) Remove(job *Job) bool {
+	q.jmu.Lock()
+	defer q.jmu.Unlock()
+	return q.interactive.remove(job.Domain, job.ID()) ||
+		q.background.remove(job.Domain, job.ID())
+}
+
 // Len returns the length of the queue
 func (q *memQueue) Len() int {
 	q.jmu.RLock()
 	defer q.jmu.RUnlock()
-	return q.list.Len()
+	return q.interactive.len + q.background.len
+}
+
+// LenByDomain returns the length of the queue for each instance
+func (q *memQueue) LenByDomain() map[string]int {
+	q.jmu.RLock()
+	defer q.jmu.RUnlock()
+	lens := make(map[string]int)
+	for _, f := range []*fairQueue{q.interactive, q.background} {
+		for domain, jobs := range f.byDomain {
+			lens[domain] += jobs.Len()
+		}
+	}
+	return lens
 }
 
 // NewMemBroker creates a new in-memory broker system.
@@ -231,6 +357,26 @@ func (b *memBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error)
 	return job, nil
 }
 
+// CancelJob removes the job from its queue if it is queued, or cancels its
+// execution if it is running.
+func (b *memBroker) CancelJob(job *Job) error {
+	dequeue := func(job *Job) error {
+		if q, ok := b.queues[job.WorkerType]; ok {
+			q.Remove(job)
+		}
+		return nil
+	}
+	notify := func(job *Job) error {
+		if cancelRunningJob(job.ID()) {
+			return nil
+		}
+		// The job is not executed by a worker, it may have been interrupted
+		// by a restart of the stack.
+		return job.Cancel()
+	}
+	return cancelJob(job, dequeue, notify)
+}
+
 // WorkerQueueLen returns the size of the number of elements in queue of the
 // specified worker type.
 func (b *memBroker) WorkerQueueLen(workerType string) (int, error) {
@@ -241,6 +387,16 @@ func (b *memBroker) WorkerQueueLen(workerType string) (int, error) {
 	return q.Len(), nil
 }
 
+// WorkerQueueLenByInstance returns the number of elements in the queue of the
+// specified worker type for each instance.
+func (b *memBroker) WorkerQueueLenByInstance(workerType string) (map[string]int, error) {
+	q, ok := b.queues[workerType]
+	if !ok {
+		return nil, ErrUnknownWorker
+	}
+	return q.LenByDomain(), nil
+}
+
 func (b *memBroker) WorkerIsReserved(workerType string) (bool, error) {
 	for _, w := range b.workers {
 		if w.Type == workerType {
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairQueue(t *testing.T) {
+	f := newFairQueue()
+	for i := 0; i < 3; i++ {
+		f.push(&Job{JobID: "a", Domain: "alice.cozy.localhost"})
+	}
+	f.push(&Job{JobID: "b", Domain: "bob.cozy.localhost"})
+	f.push(&Job{JobID: "c", Domain: "charlie.cozy.localhost"})
+	assert.Equal(t, 5, f.len)
+
+	var ids []string
+	for job := f.pop(); job != nil; job = f.pop() {
+		ids = append(ids, job.JobID)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "a"}, ids)
+	assert.Equal(t, 0, f.len)
+	assert.Empty(t, f.byDomain)
+}
+
+func TestMemQueuePriorities(t *testing.T) {
+	q := newMemQueue("test")
+	for i := 0; i < 4; i++ {
+		q.interactive.push(&Job{JobID: "i", Domain: "alice.cozy.localhost"})
+		q.background.push(&Job{JobID: "b", Domain: "bob.cozy.localhost"})
+	}
+	assert.Equal(t, 8, q.Len())
+	assert.Equal(t, map[string]int{
+		"alice.cozy.localhost": 4,
+		"bob.cozy.localhost":   4,
+	}, q.LenByDomain())
+
+	var ids []string
+	for job := q.pop(); job != nil; job = q.pop() {
+		ids = append(ids, job.JobID)
+	}
+	// The background jobs are not starved by the interactive jobs
+	assert.Equal(t, []string{"i", "i", "b", "i", "i", "b", "b", "b"}, ids)
+}
+
+func TestJobPriority(t *testing.T) {
+	db := &Job{Domain: "alice.cozy.localhost"}
+	j := NewJob(db, &JobRequest{WorkerType: "test"})
+	assert.Equal(t, PriorityBackground, j.Priority)
+	assert.False(t, j.IsInteractive())
+
+	j = NewJob(db, &JobRequest{WorkerType: "test", Manual: true})
+	assert.Equal(t, PriorityInteractive, j.Priority)
+	assert.True(t, j.IsInteractive())
+
+	j = NewJob(db, &JobRequest{WorkerType: "test", Manual: true, Priority: PriorityBackground})
+	assert.False(t, j.IsInteractive())
+
+	legacy := &Job{Manual: true}
+	assert.True(t, legacy.IsInteractive())
+}
+
+func TestFairQueueRemove(t *testing.T) {
+	f := newFairQueue()
+	f.push(&Job{JobID: "a1", Domain: "alice.cozy.localhost"})
+	f.push(&Job{JobID: "a2", Domain: "alice.cozy.localhost"})
+	f.push(&Job{JobID: "b1", Domain: "bob.cozy.localhost"})
+
+	assert.False(t, f.remove("alice.cozy.localhost", "b1"))
+	assert.True(t, f.remove("alice.cozy.localhost", "a1"))
+	assert.True(t, f.remove("bob.cozy.localhost", "b1"))
+	assert.Equal(t, 1, f.len)
+	assert.NotContains(t, f.byDomain, "bob.cozy.localhost")
+	assert.Equal(t, 1, f.domains.Len())
+
+	job := f.pop()
+	assert.Equal(t, "a2", job.JobID)
+	assert.Nil(t, f.pop())
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	registerRunningJob("running", cancel)
+	defer unregisterRunningJob("running")
+
+	assert.False(t, cancelRunningJob("unknown"))
+	assert.NoError(t, ctx.Err())
+	assert.True(t, cancelRunningJob("running"))
+	assert.ErrorIs(t, context.Cause(ctx), ErrCancelled)
+}
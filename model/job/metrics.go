@@ -34,6 +34,41 @@ func (i *workersQueuesCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+type instancesQueuesCollector struct {
+	prometheus.Desc
+}
+
+func newInstancesQueuesCollector() prometheus.Collector {
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName("workers", "queues", "instance_len"),
+		`Len of the workers queues by worker type and instance`,
+		[]string{"worker_type", "domain"},
+		prometheus.Labels{},
+	)
+	return &instancesQueuesCollector{*desc}
+}
+
+func (i *instancesQueuesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- &i.Desc
+}
+
+func (i *instancesQueuesCollector) Collect(ch chan<- prometheus.Metric) {
+	broker := globalJobSystem
+	for _, workerType := range broker.WorkersTypes() {
+		lens, err := broker.WorkerQueueLenByInstance(workerType)
+		if err != nil {
+			continue
+		}
+		for domain, count := range lens {
+			ch <- prometheus.MustNewConstMetric(
+				&i.Desc, prometheus.GaugeValue, float64(count),
+				workerType, domain,
+			)
+		}
+	}
+}
+
 func init() {
 	prometheus.MustRegister(newWorkersQueuesCollector())
+	prometheus.MustRegister(newInstancesQueuesCollector())
 }
@@ -23,8 +23,80 @@ const (
 	redisPrefix = "j/"
 	// redisHighPrioritySuffix suffix is the suffix used for prioritized queue.
 	redisHighPrioritySuffix = "/p0"
+	// redisRingSuffix is the suffix of the list of the instances with queued
+	// jobs for a worker type, in the round-robin order.
+	redisRingSuffix = "/r"
+	// redisInstanceInfix is used for the keys of the queues by instance.
+	redisInstanceInfix = "/i/"
+	// redisDepthSuffix is the suffix of the hash with the number of queued
+	// jobs by instance for a worker type.
+	redisDepthSuffix = "/depth"
+	// redisCancelChannel is the pub/sub channel used to ask to the stack
+	// processes to cancel a running job. The process that executes the job
+	// acknowledges the cancellation in a list with this prefix and the job
+	// identifier.
+	redisCancelChannel = "j/cancel"
+	// redisCancelAckTimeout is how long a cancellation waits for the
+	// acknowledgement of the process that executes the job.
+	redisCancelAckTimeout = 2 * time.Second
 )
 
+// The jobs are pushed in a queue by instance (and by priority), and the
+// instances with jobs are in a ring: the poll loop takes an instance from the
+// ring, pops a job from its queue, and puts the instance back at the end of
+// the ring if it still has jobs. It means that an instance with a lot of jobs
+// can't starve the other instances.
+//
+// KEYS[1] is the queue of the instance, KEYS[2] is the ring, and KEYS[3] is
+// the hash with the depths. ARGV[1] is the job, and ARGV[2] the domain.
+var redisPushScript = redis.NewScript(`
+local n = redis.call('LPUSH', KEYS[1], ARGV[1])
+if n == 1 then
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+end
+redis.call('HINCRBY', KEYS[3], ARGV[2], 1)
+return n
+`)
+
+// KEYS are the same as for redisPushScript, and ARGV[1] is the domain.
+var redisPopScript = redis.NewScript(`
+local val = redis.call('RPOP', KEYS[1])
+if not val then
+	return false
+end
+if redis.call('LLEN', KEYS[1]) > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+if redis.call('HINCRBY', KEYS[3], ARGV[1], -1) <= 0 then
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+return val
+`)
+
+// The ring and the queue are not popped atomically, as the poll loop waits
+// for an instance on the ring with BRPOP. If the pop script fails, the
+// instance is put back at the head of the ring when its queue still has jobs
+// and it is not already in the ring. KEYS[1] is the queue of the instance,
+// KEYS[2] is the ring, and ARGV[1] is the domain.
+var redisRequeueScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[1]) > 0 and not redis.call('LPOS', KEYS[2], ARGV[1]) then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// KEYS[1] and KEYS[2] are the queues of the instance, KEYS[3] and KEYS[4] are
+// the legacy queues, and KEYS[5] is the hash with the depths. ARGV[1] is the
+// job, and ARGV[2] the domain. The instance is not removed from the ring, as
+// the pop script can cope with an empty queue.
+var redisRemoveScript = redis.NewScript(`
+local n = redis.call('LREM', KEYS[1], 0, ARGV[1]) + redis.call('LREM', KEYS[2], 0, ARGV[1])
+if n > 0 and redis.call('HINCRBY', KEYS[5], ARGV[2], -n) <= 0 then
+	redis.call('HDEL', KEYS[5], ARGV[2])
+end
+return n + redis.call('LREM', KEYS[3], 0, ARGV[1]) + redis.call('LREM', KEYS[4], 0, ARGV[1])
+`)
+
 type redisBroker struct {
 	client         redis.UniversalClient
 	ctx            context.Context
@@ -33,6 +105,7 @@ type redisBroker struct {
 	workersTypes   []string
 	running        uint32
 	closed         chan struct{}
+	cancelSub      *redis.PubSub
 }
 
 // NewRedisBroker creates a new broker that will use redis to distribute
@@ -67,6 +140,8 @@ func (b *redisBroker) StartWorkers(ws WorkersList) error {
 	}
 
 	if len(b.workersRunning) > 0 {
+		b.cancelSub = b.client.Subscribe(b.ctx, redisCancelChannel)
+		go b.cancelLoop(b.cancelSub.Channel())
 		joblog.Infof("Started redis broker for %d workers type", len(b.workersRunning))
 	}
 
@@ -94,6 +169,9 @@ func (b *redisBroker) ShutdownWorkers(ctx context.Context) error {
 
 	fmt.Print("  shutting down redis broker...")
 	defer b.client.Close()
+	if b.cancelSub != nil {
+		_ = b.cancelSub.Close()
+	}
 
 	for i := 0; i < len(b.workersRunning); i++ {
 		select {
@@ -149,40 +227,48 @@ func (b *redisBroker) pollLoop(key string, ch chan<- *Job) {
 		// many "manual" jobs are pushed. By randomizing the order we make sure we
 		// avoid such starvation. For one in three call, the main queue is
 		// selected.
-		keyP0 := key + redisHighPrioritySuffix
-		keyP1 := key
+		//
+		// The queues without the ring suffix are the legacy queues, where the
+		// jobs were pushed before the queues by instance.
+		ringP0 := key + redisRingSuffix + redisHighPrioritySuffix
+		ringP1 := key + redisRingSuffix
+		legacyP0 := key + redisHighPrioritySuffix
+		legacyP1 := key
 		if rng.Intn(3) == 0 {
-			keyP1, keyP0 = keyP0, keyP1
+			ringP0, ringP1 = ringP1, ringP0
+			legacyP0, legacyP1 = legacyP1, legacyP0
 		}
-		results, err := b.client.BRPop(b.ctx, redisBRPopTimeout, keyP0, keyP1).Result()
+		results, err := b.client.BRPop(b.ctx, redisBRPopTimeout, ringP0, legacyP0, ringP1, legacyP1).Result()
 		if err != nil || len(results) < 2 {
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
-		key, val := results[0], results[1]
-		if len(key) < len(redisPrefix) {
-			joblog.Warnf("Invalid key %s", key)
+		k, val := results[0], results[1]
+		if len(k) < len(redisPrefix) {
+			joblog.Warnf("Invalid key %s", k)
 			continue
 		}
 
-		parts := strings.SplitN(val, "/", 2)
-		if len(parts) != 2 {
-			joblog.Warnf("Invalid val %s", val)
-			continue
+		if strings.HasPrefix(k, key+redisRingSuffix) {
+			domain := val
+			queue := key + redisInstanceInfix + domain
+			if strings.HasSuffix(k, redisHighPrioritySuffix) {
+				queue += redisHighPrioritySuffix
+			}
+			keys := []string{queue, k, key + redisDepthSuffix}
+			val, err = redisPopScript.Run(b.ctx, b.client, keys, domain).Text()
+			if err != nil {
+				if err != redis.Nil {
+					joblog.Warnf("Cannot pop a job for %s: %s", domain, err)
+					b.requeue(keys[:2], domain)
+				}
+				continue
+			}
 		}
 
-		jobID := parts[1]
-		parts = strings.SplitN(parts[0], "%", 2)
-		prefix := parts[0]
-		var cluster int
-		if len(parts) > 1 {
-			cluster, _ = strconv.Atoi(parts[1])
-		}
-		job, err := Get(prefixer.NewPrefixer(cluster, "", prefix), jobID)
+		job, err := b.getJob(val)
 		if err != nil {
-			joblog.Warnf("Cannot find job %s on domain %s (%d): %s",
-				jobID, prefix, cluster, err)
 			continue
 		}
 
@@ -190,6 +276,69 @@ func (b *redisBroker) pollLoop(key string, ch chan<- *Job) {
 	}
 }
 
+// requeue puts back the instance in the ring after a failure of the pop
+// script, else the jobs in its queue would never be executed. It is retried a
+// few times, as the failure is often a transient error of redis.
+func (b *redisBroker) requeue(keys []string, domain string) {
+	var err error
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		}
+		err = redisRequeueScript.Run(b.ctx, b.client, keys, domain).Err()
+		if err == nil {
+			return
+		}
+	}
+	joblog.Errorf("Cannot put back %s in the ring %s: %s", domain, keys[1], err)
+}
+
+// cancelLoop cancels the running jobs asked on the pub/sub channel, if they
+// are executed by this process.
+func (b *redisBroker) cancelLoop(ch <-chan *redis.Message) {
+	for msg := range ch {
+		if !cancelRunningJob(msg.Payload) {
+			continue
+		}
+		joblog.Infof("Job %s has been cancelled", msg.Payload)
+		ackKey := redisCancelChannel + "/" + msg.Payload
+		pipe := b.client.Pipeline()
+		pipe.LPush(b.ctx, ackKey, "ok")
+		pipe.Expire(b.ctx, ackKey, time.Minute)
+		if _, err := pipe.Exec(b.ctx); err != nil {
+			joblog.Warnf("Cannot acknowledge the cancellation of %s: %s", msg.Payload, err)
+		}
+	}
+}
+
+// getJob loads the job from a value of a queue. The format of the value is
+// prefix%cluster/jobID, where the cluster is optional.
+func (b *redisBroker) getJob(val string) (*Job, error) {
+	parts := strings.SplitN(val, "/", 2)
+	if len(parts) != 2 {
+		joblog.Warnf("Invalid val %s", val)
+		return nil, ErrNotFoundJob
+	}
+
+	jobID := parts[1]
+	parts = strings.SplitN(parts[0], "%", 2)
+	prefix := parts[0]
+	var cluster int
+	if len(parts) > 1 {
+		cluster, _ = strconv.Atoi(parts[1])
+	}
+	job, err := Get(prefixer.NewPrefixer(cluster, "", prefix), jobID)
+	if err != nil {
+		joblog.Warnf("Cannot find job %s on domain %s (%d): %s",
+			jobID, prefix, cluster, err)
+		return nil, err
+	}
+	if job.State == Cancelled {
+		return nil, ErrCancelled
+	}
+	return job, nil
+}
+
 // PushJob will produce a new Job with the given options and enqueue the job in
 // the proper queue.
 func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error) {
@@ -245,23 +394,67 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 	}
 
 	key := redisPrefix + job.WorkerType
+	val := redisValue(job)
+
+	// When the job is interactive, it is being pushed in a specific
+	// prioritized queue.
+	queue := key + redisInstanceInfix + job.Domain
+	ring := key + redisRingSuffix
+	if job.IsInteractive() {
+		queue += redisHighPrioritySuffix
+		ring += redisHighPrioritySuffix
+	}
+
+	keys := []string{queue, ring, key + redisDepthSuffix}
+	if err := redisPushScript.Run(b.ctx, b.client, keys, val, job.Domain).Err(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// redisValue returns the value used for the job in the queues. Its format is
+// prefix%cluster/jobID, where the cluster is optional.
+func redisValue(job *Job) string {
 	prefix := job.DBPrefix()
 	if cluster := job.DBCluster(); cluster > 0 {
 		prefix = fmt.Sprintf("%s%%%d", prefix, cluster)
 	}
-	val := prefix + "/" + job.JobID
+	return prefix + "/" + job.JobID
+}
 
-	// When the job is manual, it is being pushed in a specific prioritized
-	// queue.
-	if job.Manual {
-		key += redisHighPrioritySuffix
+// CancelJob removes the job from its queue if it is queued, or asks to the
+// stack processes to cancel it if it is running.
+func (b *redisBroker) CancelJob(job *Job) error {
+	dequeue := func(job *Job) error {
+		key := redisPrefix + job.WorkerType
+		queue := key + redisInstanceInfix + job.Domain
+		keys := []string{
+			queue,
+			queue + redisHighPrioritySuffix,
+			key,
+			key + redisHighPrioritySuffix,
+			key + redisDepthSuffix,
+		}
+		return redisRemoveScript.Run(b.ctx, b.client, keys, redisValue(job), job.Domain).Err()
 	}
-
-	if err := b.client.LPush(b.ctx, key, val).Err(); err != nil {
-		return nil, err
+	notify := func(job *Job) error {
+		if cancelRunningJob(job.ID()) {
+			return nil
+		}
+		if err := b.client.Publish(b.ctx, redisCancelChannel, job.ID()).Err(); err != nil {
+			return err
+		}
+		ackKey := redisCancelChannel + "/" + job.ID()
+		err := b.client.BLPop(b.ctx, redisCancelAckTimeout, ackKey).Err()
+		if err == redis.Nil {
+			// The job is not executed by a process, it may have been
+			// interrupted by a restart of the stack.
+			return job.Cancel()
+		}
+		return err
 	}
-
-	return job, nil
+	return cancelJob(job, dequeue, notify)
 }
 
 // QueueLen returns the size of the number of elements in queue of the
@@ -276,7 +469,32 @@ func (b *redisBroker) WorkerQueueLen(workerType string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return int(l1 + l2), nil
+	total := int(l1 + l2)
+	lens, err := b.WorkerQueueLenByInstance(workerType)
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range lens {
+		total += l
+	}
+	return total, nil
+}
+
+// WorkerQueueLenByInstance returns the number of elements in the queue of the
+// specified worker type for each instance.
+func (b *redisBroker) WorkerQueueLenByInstance(workerType string) (map[string]int, error) {
+	key := redisPrefix + workerType + redisDepthSuffix
+	depths, err := b.client.HGetAll(b.ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	lens := make(map[string]int, len(depths))
+	for domain, depth := range depths {
+		if l, err := strconv.Atoi(depth); err == nil && l > 0 {
+			lens[domain] = l
+		}
+	}
+	return lens, nil
 }
 
 func (b *redisBroker) WorkerIsReserved(workerType string) (bool, error) {
@@ -496,6 +496,10 @@ func (b *mockBroker) PushJob(db prefixer.Prefixer, request *job.JobRequest) (*jo
 	return nil, nil
 }
 
+func (b *mockBroker) CancelJob(job *job.Job) error {
+	return nil
+}
+
 func (b *mockBroker) WorkerQueueLen(workerType string) (int, error) {
 	count := 0
 	b.l.Lock()
@@ -511,6 +515,10 @@ func (b *mockBroker) WorkerQueueLen(workerType string) (int, error) {
 	return count, nil
 }
 
+func (b *mockBroker) WorkerQueueLenByInstance(workerType string) (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
 func (b *mockBroker) WorkerIsReserved(workerType string) (bool, error) {
 	return false, nil
 }
@@ -21,10 +21,12 @@ import (
 )
 
 var (
-	defaultConcurrency  = runtime.NumCPU()
-	defaultMaxExecCount = 1
-	defaultRetryDelay   = 60 * time.Millisecond
-	defaultTimeout      = 10 * time.Second
+	defaultConcurrency   = runtime.NumCPU()
+	defaultMaxExecCount  = 1
+	defaultRetryDelay    = 60 * time.Millisecond
+	defaultMaxRetryDelay = 1 * time.Hour
+	defaultJitter        = 0.1
+	defaultTimeout       = 10 * time.Second
 )
 
 type (
@@ -58,18 +60,22 @@ type (
 	// system. It contains parameters of the worker along with the worker main
 	// function that perform the work against a job's message.
 	WorkerConfig struct {
-		WorkerInit   WorkerInitFunc
-		WorkerStart  WorkerStartFunc
-		WorkerFunc   WorkerFunc
-		WorkerCommit WorkerCommit
-		WorkerType   string
-		BeforeHook   WorkerBeforeHook
-		ErrorHook    JobErrorCheckerHook
-		Concurrency  int
-		MaxExecCount int
-		Reserved     bool // true when the clients must not push jobs for this worker
-		Timeout      time.Duration
-		RetryDelay   time.Duration
+		WorkerInit    WorkerInitFunc
+		WorkerStart   WorkerStartFunc
+		WorkerFunc    WorkerFunc
+		WorkerCommit  WorkerCommit
+		WorkerType    string
+		BeforeHook    WorkerBeforeHook
+		ErrorHook     JobErrorCheckerHook
+		Concurrency   int
+		MaxExecCount  int
+		Reserved      bool // true when the clients must not push jobs for this worker
+		Timeout       time.Duration
+		RetryDelay    time.Duration
+		MaxRetryDelay time.Duration
+		Backoff       BackoffStrategy
+		Jitter        *float64 // the fraction of the retry delay that is randomized (nil for the default)
+		NoDeadLetter  bool     // true when the failed jobs must not be kept as dead letters
 	}
 
 	// Worker is a unit of work that will consume from a queue and execute the do
@@ -93,6 +99,7 @@ type (
 		id       string
 		cookie   interface{}
 		noRetry  bool
+		cancel   context.CancelCauseFunc
 	}
 )
 
@@ -113,7 +120,7 @@ func (w *WorkerConfig) Clone() *WorkerConfig {
 
 // NewTaskContext returns a context.Context usable by a worker.
 func NewTaskContext(workerID string, job *Job, inst *instance.Instance) (*TaskContext, context.CancelFunc) {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancelCause(context.Background())
 	id := fmt.Sprintf("%s/%s", workerID, job.ID())
 	entry := logger.WithDomain(job.Domain).WithNamespace("jobs")
 
@@ -132,7 +139,8 @@ func NewTaskContext(workerID string, job *Job, inst *instance.Instance) (*TaskCo
 		job:      job,
 		log:      log,
 		id:       id,
-	}, cancel
+		cancel:   cancel,
+	}, func() { cancel(nil) }
 }
 
 // WithTimeout returns a clone of the context with a different deadline.
@@ -168,9 +176,15 @@ func (c *TaskContext) clone() *TaskContext {
 		log:      c.log,
 		id:       c.id,
 		cookie:   c.cookie,
+		cancel:   c.cancel,
 	}
 }
 
+// IsCancelled returns true if the job has been cancelled, via the jobs API.
+func (c *TaskContext) IsCancelled() bool {
+	return errors.Is(context.Cause(c), ErrCancelled)
+}
+
 // ID returns a unique identifier for the worker context.
 func (c *TaskContext) ID() string {
 	return c.id
@@ -305,6 +319,10 @@ func (w *Worker) work(workerID string) {
 func (w *Worker) runTask(inst *instance.Instance, workerID string, job *Job) {
 	taskCtx, cancel := NewTaskContext(workerID, job, inst)
 	defer cancel()
+	// The job is registered before being marked as running, so that a
+	// cancellation for a running job always finds it.
+	registerRunningJob(job.ID(), taskCtx.cancel)
+	defer unregisterRunningJob(job.ID())
 	if err := job.AckConsumed(); err != nil {
 		taskCtx.Logger().Errorf("error acking consume job: %s",
 			err.Error())
@@ -336,11 +354,23 @@ func (w *Worker) runTask(inst *instance.Instance, workerID string, job *Job) {
 
 	var runResultLabel string
 	var errAck error
-	if errRun != nil {
+	if errRun != nil && taskCtx.IsCancelled() {
+		taskCtx.Logger().Infof("job cancelled")
+		runResultLabel = metrics.WorkerExecResultCancelled
+		errAck = job.Cancel()
+	} else if errRun != nil {
 		taskCtx.Logger().Errorf("error while performing job: %s",
 			errRun.Error())
 		runResultLabel = metrics.WorkerExecResultErrored
+		event, payload := job.Event, job.Payload
 		errAck = job.Nack(errRun.Error())
+		if _, ok := errRun.(BadTriggerError); !ok && !t.conf.NoDeadLetter {
+			letter := newDeadLetter(job, event, payload, errRun.Error(), t.execCount)
+			if err := saveDeadLetter(job, letter); err != nil {
+				taskCtx.Logger().Errorf("error while saving the dead letter: %s",
+					err.Error())
+			}
+		}
 	} else {
 		runResultLabel = metrics.WorkerExecResultSuccess
 		errAck = job.Ack()
@@ -386,6 +416,16 @@ func (w *Worker) defaultedConf(opts *JobOptions) *WorkerConfig {
 	if c.RetryDelay == 0 {
 		c.RetryDelay = defaultRetryDelay
 	}
+	if c.MaxRetryDelay == 0 {
+		c.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if c.Backoff == "" {
+		c.Backoff = BackoffExponential
+	}
+	if c.Jitter == nil {
+		jitter := defaultJitter
+		c.Jitter = &jitter
+	}
 	if c.Timeout == 0 {
 		c.Timeout = defaultTimeout
 	}
@@ -448,7 +488,14 @@ func (t *task) run() (err error) {
 		}
 
 		if delay > 0 {
-			time.Sleep(delay)
+			select {
+			case <-time.After(delay):
+			case <-t.ctx.Done():
+			}
+		}
+		if t.ctx.IsCancelled() {
+			err = ErrCancelled
+			break
 		}
 
 		t.ctx.Logger().Debugf("Executing job (%d) (timeout set to %s)",
@@ -504,7 +551,7 @@ func (t *task) run() (err error) {
 		cancel()
 		t.execCount++
 
-		if ctx.NoRetry() {
+		if ctx.NoRetry() || t.ctx.IsCancelled() {
 			break
 		}
 	}
@@ -562,11 +609,7 @@ func (t *task) nextDelay(prevError error) (bool, time.Duration, time.Duration) {
 		// on first execution, execute immediately
 		nextDelay = 0
 	} else {
-		nextDelay = c.RetryDelay << uint(t.execCount-1)
-
-		// fuzzDelay number between delay * (1 +/- 0.1)
-		fuzzDelay := int(0.1 * float64(nextDelay))
-		nextDelay += time.Duration((rand.Intn(2*fuzzDelay) - fuzzDelay))
+		nextDelay = c.retryDelay(t.execCount, rand.Float64())
 	}
 
 	return true, nextDelay, timeout
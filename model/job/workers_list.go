@@ -81,6 +81,22 @@ func applyWorkerConfig(w *WorkerConfig, c config.Worker) *WorkerConfig {
 	if c.Timeout != nil {
 		w.Timeout = *c.Timeout
 	}
+	if c.RetryDelay != nil {
+		w.RetryDelay = *c.RetryDelay
+	}
+	if c.MaxRetryDelay != nil {
+		w.MaxRetryDelay = *c.MaxRetryDelay
+	}
+	if c.Backoff != nil {
+		w.Backoff = BackoffStrategy(*c.Backoff)
+	}
+	if c.Jitter != nil {
+		jitter := *c.Jitter
+		w.Jitter = &jitter
+	}
+	if c.DeadLetter != nil {
+		w.NoDeadLetter = !*c.DeadLetter
+	}
 	return w
 }
 
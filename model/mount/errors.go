@@ -0,0 +1,33 @@
+package mount
+
+import "errors"
+
+var (
+	// ErrUnknownProvider is used when the provider of a mount is not webdav
+	// or sftp.
+	ErrUnknownProvider = errors.New("unknown provider for the external mount")
+	// ErrAccountNotFound is used when the account for the mount has not been
+	// found.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrInvalidAccount is used when the account has not the same type as the
+	// provider, or when its auth is incomplete.
+	ErrInvalidAccount = errors.New("invalid account for the external mount")
+	// ErrInvalidAuth is used when the external storage rejects the
+	// credentials.
+	ErrInvalidAuth = errors.New("invalid authentication on the external storage")
+	// ErrHostKeyMismatch is used when the SSH host key of a SFTP server is not
+	// the one recorded when the mount has been created.
+	ErrHostKeyMismatch = errors.New("the host key of the SFTP server has changed")
+	// ErrNotADirectory is used when the remote path of a mount is not a
+	// directory.
+	ErrNotADirectory = errors.New("the remote path is not a directory")
+	// ErrNestedMount is used when trying to create a mount inside another
+	// mount, or in the trash.
+	ErrNestedMount = errors.New("an external mount can't be created here")
+	// ErrSharedParent is used when trying to create a mount inside a shared
+	// directory.
+	ErrSharedParent = errors.New("an external mount can't be created in a shared directory")
+	// ErrCrossMount is used when trying to move a file between a mount and the
+	// rest of the Cozy, or another mount.
+	ErrCrossMount = errors.New("files can't be moved in or out of an external mount")
+)
@@ -0,0 +1,214 @@
+// Package mount gives access to the external storages (WebDAV, SFTP) mounted
+// as virtual folders in the Cozy. The content of these folders is not stored
+// in CouchDB: it is listed, read and written live on the external storage.
+package mount
+
+import (
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// Entry is a file or directory on an external storage.
+type Entry struct {
+	Name      string
+	Dir       bool
+	Size      int64
+	UpdatedAt time.Time
+}
+
+// Provider is the interface implemented by the external storages. The names
+// are relative to the remote path of the mount, with slashes as separators,
+// and "" is the mounted directory. The errors os.ErrNotExist and os.ErrExist
+// are used for the missing and already existing files.
+type Provider interface {
+	Stat(name string) (*Entry, error)
+	List(name string) ([]*Entry, error)
+	Open(name string) (io.ReadCloser, error)
+	Put(name, mime string, size int64, body io.Reader) error
+	Mkdir(name string) error
+	Remove(name string, dir bool) error
+	Rename(oldName, newName string) error
+	Close() error
+}
+
+// Opener creates a provider for a mount, from the auth of its account.
+type Opener func(inst *instance.Instance, auth map[string]interface{}, info *vfs.MountInfo) (Provider, error)
+
+var providers = map[string]Opener{
+	"webdav": openWebDAV,
+	"sftp":   openSFTP,
+}
+
+// IsKnownProvider returns true if the provider can be used for a mount.
+func IsKnownProvider(provider string) bool {
+	_, ok := providers[provider]
+	return ok
+}
+
+// Open returns a provider for the given mount. The caller must close it.
+func Open(inst *instance.Instance, info *vfs.MountInfo) (Provider, error) {
+	opener, ok := providers[info.Provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	var doc couchdb.JSONDoc
+	if err := couchdb.GetDoc(inst, consts.Accounts, info.AccountID, &doc); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+	account.Decrypt(doc)
+	if doc.M == nil || doc.M["account_type"] != info.Provider {
+		return nil, ErrInvalidAccount
+	}
+	auth, ok := doc.M["auth"].(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidAccount
+	}
+	return opener(inst, auth, info)
+}
+
+// Create creates a directory named name in the parent directory, where the
+// external storage is mounted. The remote path must be an existing directory.
+func Create(inst *instance.Instance, name, parentID string, info *vfs.MountInfo) (*vfs.DirDoc, error) {
+	if !IsKnownProvider(info.Provider) {
+		return nil, ErrUnknownProvider
+	}
+	if parentID == "" {
+		parentID = consts.RootDirID
+	}
+	if parentID == consts.TrashDirID || vfs.IsMountedID(parentID) {
+		return nil, ErrNestedMount
+	}
+	fs := inst.VFS()
+	parent, err := fs.DirByID(parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Mount != nil || strings.HasPrefix(parent.Fullpath, vfs.TrashDirName+"/") {
+		return nil, ErrNestedMount
+	}
+	if err := checkNotShared(fs, parent); err != nil {
+		return nil, err
+	}
+
+	info.RemotePath = path.Clean("/" + info.RemotePath)
+	info.HostKey = ""
+	provider, err := Open(inst, info)
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	root, err := provider.Stat("")
+	if err != nil {
+		return nil, err
+	}
+	if !root.Dir {
+		return nil, ErrNotADirectory
+	}
+
+	doc, err := vfs.NewDirDocWithParent(name, parent, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc.Mount = info
+	if err := fs.CreateDir(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// checkNotShared returns an error if the directory or one of its ancestors is
+// shared, as the content of a mount can't be replicated to the members.
+func checkNotShared(fs vfs.VFS, dir *vfs.DirDoc) error {
+	for {
+		if dir.SharingID() != "" {
+			return ErrSharedParent
+		}
+		if dir.DocID == consts.RootDirID || dir.DirID == "" {
+			return nil
+		}
+		parent, err := dir.Parent(fs)
+		if err != nil {
+			return err
+		}
+		dir = parent
+	}
+}
+
+// Resolve returns the mount directory and the relative path for the
+// identifier of a file or directory inside a mount.
+func Resolve(fs vfs.VFS, id string) (*vfs.DirDoc, string, error) {
+	mountID, rel, ok := vfs.SplitMountedID(id)
+	if !ok {
+		return nil, "", vfs.ErrParentDoesNotExist
+	}
+	dir, err := fs.DirByID(mountID)
+	if err != nil {
+		return nil, "", err
+	}
+	if dir.Mount == nil {
+		return nil, "", vfs.ErrParentDoesNotExist
+	}
+	return dir, rel, nil
+}
+
+// DirDoc returns a directory document for an entry of a mount, with a virtual
+// identifier. The relative path of the mount directory itself is "".
+func DirDoc(mountDir *vfs.DirDoc, rel string, e *Entry) *vfs.DirDoc {
+	if rel == "" {
+		return mountDir
+	}
+	return &vfs.DirDoc{
+		Type:      consts.DirType,
+		DocID:     vfs.MountedID(mountDir.DocID, rel),
+		DocName:   e.Name,
+		DirID:     parentID(mountDir, rel),
+		CreatedAt: e.UpdatedAt,
+		UpdatedAt: e.UpdatedAt,
+		Fullpath:  path.Join(mountDir.Fullpath, rel),
+	}
+}
+
+// FileDoc returns a file document for an entry of a mount, with a virtual
+// identifier.
+func FileDoc(mountDir *vfs.DirDoc, rel string, e *Entry) *vfs.FileDoc {
+	mime, class := vfs.ExtractMimeAndClassFromFilename(e.Name)
+	return &vfs.FileDoc{
+		Type:      consts.FileType,
+		DocID:     vfs.MountedID(mountDir.DocID, rel),
+		DocName:   e.Name,
+		DirID:     parentID(mountDir, rel),
+		CreatedAt: e.UpdatedAt,
+		UpdatedAt: e.UpdatedAt,
+		ByteSize:  e.Size,
+		Mime:      mime,
+		Class:     class,
+	}
+}
+
+func parentID(mountDir *vfs.DirDoc, rel string) string {
+	parent := path.Dir(rel)
+	if parent == "." {
+		parent = ""
+	}
+	return vfs.MountedID(mountDir.DocID, parent)
+}
+
+// CheckName returns an error if the name can't be used for a file or
+// directory created inside a mount.
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, vfs.ForbiddenFilenameChars) {
+		return vfs.ErrIllegalFilename
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mount
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/webdav"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocs(t *testing.T) {
+	mountDir := &vfs.DirDoc{
+		Type:     consts.DirType,
+		DocID:    "mount-id",
+		DocName:  "NAS",
+		DirID:    consts.RootDirID,
+		Fullpath: "/NAS",
+		Mount:    &vfs.MountInfo{Provider: "sftp", AccountID: "account-id", RemotePath: "/home/alice"},
+	}
+	now := time.Now().UTC()
+
+	assert.Equal(t, mountDir, DirDoc(mountDir, "", &Entry{Dir: true}))
+
+	dir := DirDoc(mountDir, "Photos", &Entry{Name: "Photos", Dir: true, UpdatedAt: now})
+	assert.Equal(t, vfs.MountedID("mount-id", "Photos"), dir.DocID)
+	assert.Equal(t, "mount-id", dir.DirID)
+	assert.Equal(t, "/NAS/Photos", dir.Fullpath)
+	assert.Nil(t, dir.Mount)
+
+	file := FileDoc(mountDir, "Photos/cat.jpg", &Entry{Name: "cat.jpg", Size: 42, UpdatedAt: now})
+	assert.Equal(t, vfs.MountedID("mount-id", "Photos/cat.jpg"), file.DocID)
+	assert.Equal(t, dir.DocID, file.DirID)
+	assert.Equal(t, int64(42), file.ByteSize)
+	assert.Equal(t, "image/jpeg", file.Mime)
+	assert.Equal(t, "image", file.Class)
+}
+
+func TestCheckName(t *testing.T) {
+	assert.NoError(t, CheckName("report.pdf"))
+	assert.Equal(t, vfs.ErrIllegalFilename, CheckName(""))
+	assert.Equal(t, vfs.ErrIllegalFilename, CheckName(".."))
+	assert.Equal(t, vfs.ErrIllegalFilename, CheckName("a/b"))
+}
+
+func TestWebDAVEntry(t *testing.T) {
+	e := webdavEntry(&webdav.Item{
+		Type:         "directory",
+		Href:         "/Documents/Work/",
+		LastModified: "Mon, 19 Oct 2026 10:00:00 GMT",
+	})
+	assert.Equal(t, "Work", e.Name)
+	assert.True(t, e.Dir)
+	assert.Equal(t, time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC), e.UpdatedAt)
+
+	e = webdavEntry(&webdav.Item{Type: "file", Href: "/Documents/notes.txt", Size: 12})
+	assert.Equal(t, "notes.txt", e.Name)
+	assert.False(t, e.Dir)
+	assert.Equal(t, int64(12), e.Size)
+
+	assert.Equal(t, os.ErrNotExist, wrapWebDAVError(webdav.ErrParentNotFound))
+	assert.Equal(t, os.ErrExist, wrapWebDAVError(webdav.ErrAlreadyExist))
+	assert.Equal(t, ErrInvalidAuth, wrapWebDAVError(webdav.ErrInvalidAuth))
+}
@@ -0,0 +1,185 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpPorts are the ports allowed for the SFTP servers, except for the
+// trusted private networks.
+var sftpPorts = []string{"22", "2222"}
+
+const sftpTimeout = 30 * time.Second
+
+type sftpProvider struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	root   string
+}
+
+// openSFTP returns a provider for a SFTP server. The auth of the account has
+// the URL of the server (sftp://host:port), the login, and a password or a
+// private key. The fingerprint of the host key is recorded in the mount info
+// on the first connection, and checked on the next ones.
+func openSFTP(inst *instance.Instance, auth map[string]interface{}, info *vfs.MountInfo) (Provider, error) {
+	rawURL, _ := auth["url"].(string)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, ErrInvalidAccount
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	login, _ := auth["login"].(string)
+	var methods []ssh.AuthMethod
+	if key, ok := auth["private_key"].(string); ok && key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(key))
+		if err != nil {
+			return nil, ErrInvalidAccount
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if password, ok := auth["password"].(string); ok && password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	if login == "" || len(methods) == 0 {
+		return nil, ErrInvalidAccount
+	}
+
+	config := &ssh.ClientConfig{
+		User: login,
+		Auth: methods,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			if info.HostKey == "" {
+				info.HostKey = fingerprint
+				return nil
+			}
+			if info.HostKey != fingerprint {
+				return ErrHostKeyMismatch
+			}
+			return nil
+		},
+		ClientVersion: "SSH-2.0-" + build.UserAgent(),
+		Timeout:       sftpTimeout,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sftpTimeout)
+	defer cancel()
+	tcp, err := safehttp.NewDialer(sftpPorts...).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		inst.Logger().WithNamespace("mount").Infof("Cannot connect to %s: %s", addr, err)
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(tcp, addr, config)
+	if err != nil {
+		_ = tcp.Close()
+		if errors.Is(err, ErrHostKeyMismatch) {
+			return nil, ErrHostKeyMismatch
+		}
+		inst.Logger().WithNamespace("mount").Infof("SSH handshake with %s: %s", addr, err)
+		return nil, ErrInvalidAuth
+	}
+	conn := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &sftpProvider{conn: conn, client: client, root: info.RemotePath}, nil
+}
+
+func (p *sftpProvider) remote(name string) string {
+	return path.Join(p.root, name)
+}
+
+func (p *sftpProvider) Stat(name string) (*Entry, error) {
+	info, err := p.client.Stat(p.remote(name))
+	if err != nil {
+		return nil, err
+	}
+	return sftpEntry(info), nil
+}
+
+func (p *sftpProvider) List(name string) ([]*Entry, error) {
+	infos, err := p.client.ReadDir(p.remote(name))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*Entry, 0, len(infos))
+	for _, info := range infos {
+		// Symlinks and special files are not followed
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		entries = append(entries, sftpEntry(info))
+	}
+	return entries, nil
+}
+
+func (p *sftpProvider) Open(name string) (io.ReadCloser, error) {
+	return p.client.Open(p.remote(name))
+}
+
+func (p *sftpProvider) Put(name, mime string, size int64, body io.Reader) error {
+	f, err := p.client.OpenFile(p.remote(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.ReadFrom(body); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (p *sftpProvider) Mkdir(name string) error {
+	remote := p.remote(name)
+	if _, err := p.client.Stat(remote); err == nil {
+		return os.ErrExist
+	}
+	return p.client.Mkdir(remote)
+}
+
+func (p *sftpProvider) Remove(name string, dir bool) error {
+	if dir {
+		return p.client.RemoveAll(p.remote(name))
+	}
+	return p.client.Remove(p.remote(name))
+}
+
+func (p *sftpProvider) Rename(oldName, newName string) error {
+	if _, err := p.client.Stat(p.remote(newName)); err == nil {
+		return os.ErrExist
+	}
+	return p.client.Rename(p.remote(oldName), p.remote(newName))
+}
+
+func (p *sftpProvider) Close() error {
+	_ = p.client.Close()
+	return p.conn.Close()
+}
+
+func sftpEntry(info os.FileInfo) *Entry {
+	return &Entry{
+		Name:      info.Name(),
+		Dir:       info.IsDir(),
+		Size:      info.Size(),
+		UpdatedAt: info.ModTime().UTC(),
+	}
+}
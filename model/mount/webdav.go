@@ -0,0 +1,126 @@
+package mount
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/webdav"
+)
+
+type webdavProvider struct {
+	client *webdav.Client
+	root   string
+}
+
+// openWebDAV returns a provider for a WebDAV server. The auth of the account
+// has the URL of the WebDAV endpoint, the login and the password.
+func openWebDAV(inst *instance.Instance, auth map[string]interface{}, info *vfs.MountInfo) (Provider, error) {
+	rawURL, _ := auth["url"].(string)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrInvalidAccount
+	}
+	login, _ := auth["login"].(string)
+	password, _ := auth["password"].(string)
+	client := &webdav.Client{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Username: login,
+		Password: password,
+		BasePath: strings.TrimSuffix(u.Path, "/"),
+		Logger:   inst.Logger().WithNamespace("mount"),
+	}
+	return &webdavProvider{client: client, root: info.RemotePath}, nil
+}
+
+func (p *webdavProvider) remote(name string) string {
+	return path.Join(p.root, name)
+}
+
+func (p *webdavProvider) Stat(name string) (*Entry, error) {
+	item, err := p.client.Stat(p.remote(name))
+	if err != nil {
+		return nil, wrapWebDAVError(err)
+	}
+	return webdavEntry(item), nil
+}
+
+func (p *webdavProvider) List(name string) ([]*Entry, error) {
+	items, err := p.client.List(p.remote(name))
+	if err != nil {
+		return nil, wrapWebDAVError(err)
+	}
+	entries := make([]*Entry, 0, len(items))
+	for i := range items {
+		entries = append(entries, webdavEntry(&items[i]))
+	}
+	return entries, nil
+}
+
+func (p *webdavProvider) Open(name string) (io.ReadCloser, error) {
+	dl, err := p.client.Get(p.remote(name))
+	if err != nil {
+		return nil, wrapWebDAVError(err)
+	}
+	return dl.Content, nil
+}
+
+func (p *webdavProvider) Put(name, mime string, size int64, body io.Reader) error {
+	headers := map[string]string{"Content-Type": mime}
+	return wrapWebDAVError(p.client.Put(p.remote(name), size, headers, body))
+}
+
+func (p *webdavProvider) Mkdir(name string) error {
+	return wrapWebDAVError(p.client.Mkcol(p.remote(name)))
+}
+
+func (p *webdavProvider) Remove(name string, dir bool) error {
+	return wrapWebDAVError(p.client.Delete(p.remote(name)))
+}
+
+func (p *webdavProvider) Rename(oldName, newName string) error {
+	// The MOVE of the WebDAV client overwrites the destination
+	if _, err := p.client.Stat(p.remote(newName)); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, webdav.ErrNotFound) {
+		return wrapWebDAVError(err)
+	}
+	return wrapWebDAVError(p.client.Move(p.remote(oldName), p.remote(newName)))
+}
+
+func (p *webdavProvider) Close() error {
+	return nil
+}
+
+func webdavEntry(item *webdav.Item) *Entry {
+	e := &Entry{
+		Name: path.Base(strings.TrimSuffix(item.Href, "/")),
+		Dir:  item.Type == "directory",
+		Size: int64(item.Size),
+	}
+	if t, err := http.ParseTime(item.LastModified); err == nil {
+		e.UpdatedAt = t.UTC()
+	}
+	return e
+}
+
+func wrapWebDAVError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, webdav.ErrNotFound), errors.Is(err, webdav.ErrParentNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, webdav.ErrAlreadyExist):
+		return os.ErrExist
+	case errors.Is(err, webdav.ErrInvalidAuth):
+		return ErrInvalidAuth
+	}
+	return err
+}
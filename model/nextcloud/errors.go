@@ -9,4 +9,10 @@ var (
 	// ErrInvalidAccount is used when the account cannot be used to connect to
 	// NextCloud.
 	ErrInvalidAccount = errors.New("invalid NextCloud account")
+	// ErrInvalidSync is used when the folders or the interval of a
+	// synchronisation are not valid.
+	ErrInvalidSync = errors.New("invalid synchronisation")
+	// ErrSyncConflict is used when a folder is already synchronised, or is
+	// inside or above a synchronised folder.
+	ErrSyncConflict = errors.New("the folder is already synchronised")
 )
@@ -0,0 +1,243 @@
+package nextcloud
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+// SyncWorkerType is the type of the worker that runs the reconciliation
+// between a Cozy folder and a Nextcloud folder.
+const SyncWorkerType = "nextcloud-sync"
+
+const (
+	// DefaultSyncInterval is the interval between two runs of a
+	// synchronisation when none is given.
+	DefaultSyncInterval = 30 * time.Minute
+	// MinSyncInterval is the shortest interval allowed between two runs.
+	MinSyncInterval = 5 * time.Minute
+)
+
+// Sync is a Cozy folder kept in sync with a folder of a Nextcloud account.
+// The state of the last reconciliation (the ETags and the checksums of the
+// files) is kept in a local document, and the runs are made by an @every
+// trigger.
+type Sync struct {
+	DocID        string     `json:"_id,omitempty"`
+	DocRev       string     `json:"_rev,omitempty"`
+	AccountID    string     `json:"account_id"`
+	RemotePath   string     `json:"remote_path"`
+	DirID        string     `json:"dir_id"`
+	Interval     string     `json:"interval"`
+	TriggerID    string     `json:"trigger_id,omitempty"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastStatus   string     `json:"last_status,omitempty"`
+	LastReportID string     `json:"last_report_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (s *Sync) ID() string        { return s.DocID }
+func (s *Sync) Rev() string       { return s.DocRev }
+func (s *Sync) DocType() string   { return consts.NextcloudSyncs }
+func (s *Sync) SetID(id string)   { s.DocID = id }
+func (s *Sync) SetRev(rev string) { s.DocRev = rev }
+
+func (s *Sync) Clone() couchdb.Doc {
+	cloned := *s
+	if s.LastRunAt != nil {
+		t := *s.LastRunAt
+		cloned.LastRunAt = &t
+	}
+	return &cloned
+}
+
+func (s *Sync) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{
+		Self: "/remote/nextcloud/" + s.AccountID + "/syncs/" + s.DocID,
+	}
+}
+func (s *Sync) Relationships() jsonapi.RelationshipMap { return nil }
+func (s *Sync) Included() []jsonapi.Object             { return nil }
+
+var (
+	_ couchdb.Doc    = (*Sync)(nil)
+	_ jsonapi.Object = (*Sync)(nil)
+)
+
+// SyncMessage is the message of the jobs for the nextcloud-sync worker.
+type SyncMessage struct {
+	SyncID string `json:"sync_id"`
+}
+
+// CreateSync checks the folders on both sides, saves the synchronisation,
+// adds its trigger and pushes a job for the first run.
+func CreateSync(inst *instance.Instance, accountID, remotePath, dirID, interval string) (*Sync, error) {
+	every := DefaultSyncInterval
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < MinSyncInterval {
+			return nil, ErrInvalidSync
+		}
+		every = d
+	}
+	remotePath = path.Clean("/" + remotePath)
+
+	fs := inst.VFS()
+	dir, err := fs.DirByID(dirID)
+	if err != nil {
+		return nil, err
+	}
+	if dir.ID() == consts.RootDirID || dir.ID() == consts.TrashDirID ||
+		strings.HasPrefix(dir.Fullpath, vfs.TrashDirName) {
+		return nil, ErrInvalidSync
+	}
+
+	nc, err := New(inst, accountID)
+	if err != nil {
+		return nil, err
+	}
+	item, err := nc.webdav.Stat("/files/" + nc.userID + remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if item.Type != "directory" {
+		return nil, ErrInvalidSync
+	}
+
+	syncs, err := ListSyncs(inst, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range syncs {
+		if other.AccountID == accountID && isNested(other.RemotePath, remotePath) {
+			return nil, ErrSyncConflict
+		}
+		otherDir, err := fs.DirByID(other.DirID)
+		if err != nil {
+			continue
+		}
+		if isNested(otherDir.Fullpath, dir.Fullpath) {
+			return nil, ErrSyncConflict
+		}
+	}
+
+	now := time.Now().UTC()
+	s := &Sync{
+		AccountID:  accountID,
+		RemotePath: remotePath,
+		DirID:      dir.ID(),
+		Interval:   every.String(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := couchdb.CreateDoc(inst, s); err != nil {
+		return nil, err
+	}
+	if err := s.addTrigger(inst); err != nil {
+		_ = couchdb.DeleteDoc(inst, s)
+		return nil, err
+	}
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return nil, err
+	}
+	if _, err := s.PushJob(inst); err != nil {
+		inst.Logger().WithNamespace("nextcloud").
+			Warnf("Cannot push the first run of the sync %s: %s", s.DocID, err)
+	}
+	return s, nil
+}
+
+// GetSync returns the synchronisation with the given identifier.
+func GetSync(inst *instance.Instance, id string) (*Sync, error) {
+	s := &Sync{}
+	if err := couchdb.GetDoc(inst, consts.NextcloudSyncs, id, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListSyncs returns the synchronisations of the account, or of all the
+// accounts if accountID is empty.
+func ListSyncs(inst *instance.Instance, accountID string) ([]*Sync, error) {
+	var syncs []*Sync
+	err := couchdb.GetAllDocs(inst, consts.NextcloudSyncs, nil, &syncs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if accountID == "" {
+		return syncs, nil
+	}
+	filtered := syncs[:0]
+	for _, s := range syncs {
+		if s.AccountID == accountID {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// PushJob asks for a run of the synchronisation now, without waiting for the
+// trigger.
+func (s *Sync) PushJob(inst *instance.Instance) (*job.Job, error) {
+	msg, err := job.NewMessage(&SyncMessage{SyncID: s.DocID})
+	if err != nil {
+		return nil, err
+	}
+	return job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: SyncWorkerType,
+		Message:    msg,
+	})
+}
+
+// Delete stops the synchronisation. The files are kept on both sides.
+func (s *Sync) Delete(inst *instance.Instance) error {
+	if s.TriggerID != "" {
+		err := job.System().DeleteTrigger(inst, s.TriggerID)
+		if err != nil && err != job.ErrNotFoundTrigger {
+			return err
+		}
+	}
+	if err := deleteSyncState(inst, s.DocID); err != nil {
+		return err
+	}
+	if err := deleteSyncReports(inst, s.DocID); err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, s)
+}
+
+func (s *Sync) addTrigger(inst *instance.Instance) error {
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@every",
+		WorkerType: SyncWorkerType,
+		Arguments:  s.Interval,
+	}, &SyncMessage{SyncID: s.DocID})
+	if err != nil {
+		return err
+	}
+	if err := job.System().AddTrigger(t); err != nil {
+		return err
+	}
+	s.TriggerID = t.Infos().TID
+	return nil
+}
+
+// isNested returns true if one of the paths is the other path, or is inside
+// it.
+func isNested(a, b string) bool {
+	if a == b || a == "/" || b == "/" {
+		return true
+	}
+	return strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
@@ -0,0 +1,231 @@
+package nextcloud
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	syncFile = "file"
+	syncDir  = "directory"
+)
+
+// syncEntry is the state of a path after the last reconciliation: the
+// identifier and checksum on the Cozy side, and the ETag on the Nextcloud
+// side.
+type syncEntry struct {
+	Type   string `json:"type"`
+	CozyID string `json:"cozy_id"`
+	MD5    string `json:"md5,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// syncNode is a file or directory seen on one side during a run. For the
+// Cozy side, ID and MD5 are set, and for the Nextcloud side, ETag is set, and
+// MD5 too when Nextcloud knows the checksum of the file.
+type syncNode struct {
+	Type string
+	ID   string
+	MD5  string
+	ETag string
+	Size int64
+}
+
+type syncActionKind int
+
+const (
+	// actionRecord is used when both sides are the same: only the state is
+	// updated.
+	actionRecord syncActionKind = iota
+	// actionForget is used when the path has been removed on both sides.
+	actionForget
+	actionUpload
+	actionDownload
+	actionMkdirRemote
+	actionMkdirLocal
+	actionTrashLocal
+	actionTrashRemote
+	// actionConflict is used when the path has been modified on both sides:
+	// the Cozy version is renamed and the Nextcloud version is downloaded.
+	actionConflict
+	// actionCompare is used on the first run for a file with the same size
+	// on both sides, but without a checksum on the Nextcloud side: it is
+	// downloaded to know if it is the same file or a conflict.
+	actionCompare
+)
+
+type syncAction struct {
+	Kind syncActionKind
+	Path string
+}
+
+// planSync compares the state of the last run with the two sides, and
+// returns the actions to reconcile them. The paths are relative to the
+// synchronised folders, and the parents come before their children.
+func planSync(entries map[string]*syncEntry, local, remote map[string]*syncNode) []syncAction {
+	paths := make([]string, 0, len(entries)+len(local))
+	seen := make(map[string]struct{}, len(entries)+len(local))
+	for _, m := range []map[string]*syncNode{local, remote} {
+		for p := range m {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				paths = append(paths, p)
+			}
+		}
+	}
+	for p := range entries {
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			paths = append(paths, p)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return lessPath(paths[i], paths[j]) })
+
+	localChanged := make(map[string]bool)
+	remoteChanged := make(map[string]bool)
+	for _, p := range paths {
+		e := entries[p]
+		if l := local[p]; l != nil && l.changedFrom(e, false) {
+			localChanged[p] = true
+		}
+		if r := remote[p]; r != nil && r.changedFrom(e, true) {
+			remoteChanged[p] = true
+		}
+	}
+
+	var actions []syncAction
+	var skipped []string
+	for _, p := range paths {
+		if hasAncestorIn(skipped, p) {
+			continue
+		}
+		e, l, r := entries[p], local[p], remote[p]
+		add := func(kind syncActionKind) {
+			actions = append(actions, syncAction{Kind: kind, Path: p})
+		}
+
+		switch {
+		case l == nil && r == nil:
+			add(actionForget)
+
+		case l != nil && r != nil:
+			switch {
+			case l.Type != r.Type:
+				add(actionConflict)
+				if l.Type == syncDir {
+					// The content of the renamed directory will be sent on
+					// the next run.
+					skipped = append(skipped, p)
+				}
+			case l.Type == syncDir:
+				add(actionRecord)
+			case e == nil:
+				// First run: the files are the same only if their contents
+				// are the same.
+				switch {
+				case l.Size != r.Size:
+					add(actionConflict)
+				case l.MD5 == "" || r.MD5 == "":
+					add(actionCompare)
+				case l.MD5 == r.MD5:
+					add(actionRecord)
+				default:
+					add(actionConflict)
+				}
+			case localChanged[p] && remoteChanged[p]:
+				add(actionConflict)
+			case localChanged[p]:
+				add(actionUpload)
+			case remoteChanged[p]:
+				add(actionDownload)
+			default:
+				add(actionRecord)
+			}
+
+		case l != nil:
+			// Only on the Cozy side: new, or deleted on Nextcloud
+			switch {
+			case e == nil || e.Type != l.Type:
+				if l.Type == syncDir {
+					add(actionMkdirRemote)
+				} else {
+					add(actionUpload)
+				}
+			case l.Type == syncDir:
+				if hasChangeUnder(localChanged, p) {
+					add(actionMkdirRemote)
+				} else {
+					add(actionTrashLocal)
+					skipped = append(skipped, p)
+				}
+			case localChanged[p]:
+				add(actionUpload)
+			default:
+				add(actionTrashLocal)
+			}
+
+		default:
+			// Only on the Nextcloud side: new, or deleted on the Cozy
+			switch {
+			case e == nil || e.Type != r.Type:
+				if r.Type == syncDir {
+					add(actionMkdirLocal)
+				} else {
+					add(actionDownload)
+				}
+			case r.Type == syncDir:
+				if hasChangeUnder(remoteChanged, p) {
+					add(actionMkdirLocal)
+				} else {
+					add(actionTrashRemote)
+					skipped = append(skipped, p)
+				}
+			case remoteChanged[p]:
+				add(actionDownload)
+			default:
+				add(actionTrashRemote)
+			}
+		}
+	}
+	return actions
+}
+
+// changedFrom returns true if the node is new or has been modified since the
+// last run.
+func (n *syncNode) changedFrom(e *syncEntry, remote bool) bool {
+	if e == nil || e.Type != n.Type {
+		return true
+	}
+	if n.Type == syncDir {
+		return false
+	}
+	if remote {
+		return n.ETag != e.ETag
+	}
+	return n.MD5 != e.MD5
+}
+
+// lessPath sorts the paths segment by segment, so that a directory comes
+// just before its children.
+func lessPath(a, b string) bool {
+	return strings.ReplaceAll(a, "/", "\x00") < strings.ReplaceAll(b, "/", "\x00")
+}
+
+func hasAncestorIn(dirs []string, p string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasChangeUnder(changed map[string]bool, dir string) bool {
+	for p := range changed {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
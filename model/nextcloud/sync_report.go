@@ -0,0 +1,155 @@
+package nextcloud
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+const (
+	SyncStatusSuccess = "success"
+	SyncStatusPartial = "partial"
+	SyncStatusFailed  = "failed"
+)
+
+// maxSyncReports is the number of reports kept for a synchronisation.
+const maxSyncReports = 50
+
+// maxReportedErrors is the maximal number of errors kept in a report.
+const maxReportedErrors = 100
+
+// SyncReport is the report of a run of a synchronisation.
+type SyncReport struct {
+	DocID         string           `json:"_id,omitempty"`
+	DocRev        string           `json:"_rev,omitempty"`
+	SyncID        string           `json:"sync_id"`
+	Status        string           `json:"status"`
+	Downloaded    int              `json:"downloaded"`
+	Uploaded      int              `json:"uploaded"`
+	TrashedLocal  int              `json:"trashed_local"`
+	TrashedRemote int              `json:"trashed_remote"`
+	Conflicts     []SyncConflict   `json:"conflicts"`
+	Errors        []MigrationError `json:"errors"`
+	StartedAt     time.Time        `json:"started_at"`
+	FinishedAt    time.Time        `json:"finished_at"`
+}
+
+// SyncConflict is a file or directory modified on both sides. The Cozy
+// version is renamed, and it is sent to Nextcloud with its new name on the
+// next run.
+type SyncConflict struct {
+	Path    string `json:"path"`
+	Renamed string `json:"renamed"`
+}
+
+func (r *SyncReport) ID() string        { return r.DocID }
+func (r *SyncReport) Rev() string       { return r.DocRev }
+func (r *SyncReport) DocType() string   { return consts.NextcloudSyncReports }
+func (r *SyncReport) SetID(id string)   { r.DocID = id }
+func (r *SyncReport) SetRev(rev string) { r.DocRev = rev }
+
+func (r *SyncReport) Clone() couchdb.Doc {
+	cloned := *r
+	cloned.Conflicts = append([]SyncConflict{}, r.Conflicts...)
+	cloned.Errors = append([]MigrationError{}, r.Errors...)
+	return &cloned
+}
+
+func (r *SyncReport) Links() *jsonapi.LinksList              { return nil }
+func (r *SyncReport) Relationships() jsonapi.RelationshipMap { return nil }
+func (r *SyncReport) Included() []jsonapi.Object             { return nil }
+
+var (
+	_ couchdb.Doc    = (*SyncReport)(nil)
+	_ jsonapi.Object = (*SyncReport)(nil)
+)
+
+func (r *SyncReport) addError(p string, err error) {
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, MigrationError{
+			Path:    p,
+			Message: err.Error(),
+			At:      time.Now().UTC(),
+		})
+	}
+}
+
+// ListSyncReports returns the last reports of a synchronisation, the most
+// recent first.
+func ListSyncReports(inst *instance.Instance, syncID string, limit int) ([]*SyncReport, error) {
+	if limit <= 0 || limit > maxSyncReports {
+		limit = maxSyncReports
+	}
+	var reports []*SyncReport
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sync-id",
+		Selector: mango.Equal("sync_id", syncID),
+		Sort: mango.SortBy{
+			{Field: "sync_id", Direction: mango.Desc},
+			{Field: "started_at", Direction: mango.Desc},
+		},
+		Limit: limit,
+	}
+	err := couchdb.FindDocs(inst, consts.NextcloudSyncReports, req, &reports)
+	if couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	return reports, err
+}
+
+// pruneSyncReports removes the oldest reports of a synchronisation when there
+// are more than maxSyncReports.
+func pruneSyncReports(inst *instance.Instance, syncID string) error {
+	var reports []*SyncReport
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sync-id",
+		Selector: mango.Equal("sync_id", syncID),
+		Sort: mango.SortBy{
+			{Field: "sync_id", Direction: mango.Desc},
+			{Field: "started_at", Direction: mango.Desc},
+		},
+		Skip:  maxSyncReports,
+		Limit: 100,
+	}
+	if err := couchdb.FindDocs(inst, consts.NextcloudSyncReports, req, &reports); err != nil {
+		return err
+	}
+	return deleteReports(inst, reports)
+}
+
+// deleteSyncReports removes all the reports of a synchronisation.
+func deleteSyncReports(inst *instance.Instance, syncID string) error {
+	for {
+		var reports []*SyncReport
+		req := &couchdb.FindRequest{
+			UseIndex: "by-sync-id",
+			Selector: mango.Equal("sync_id", syncID),
+			Limit:    1000,
+		}
+		err := couchdb.FindDocs(inst, consts.NextcloudSyncReports, req, &reports)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(reports) == 0 {
+			return nil
+		}
+		if err := deleteReports(inst, reports); err != nil {
+			return err
+		}
+	}
+}
+
+func deleteReports(inst *instance.Instance, reports []*SyncReport) error {
+	docs := make([]couchdb.Doc, len(reports))
+	for i, r := range reports {
+		docs[i] = r
+	}
+	return couchdb.BulkDeleteDocs(inst, consts.NextcloudSyncReports, docs)
+}
@@ -0,0 +1,666 @@
+package nextcloud
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/webdav"
+	"github.com/labstack/echo/v4"
+)
+
+// changesBatchSize is the number of changes of io.cozy.files fetched at once
+// to know if the synchronised folder has been modified.
+const changesBatchSize = 1000
+
+// syncState is the state of a synchronisation after its last run. It is
+// saved in a local document, as it is only used by the stack.
+type syncState struct {
+	Rev      string                `json:"_rev,omitempty"`
+	LastSeq  string                `json:"last_seq,omitempty"`
+	RootETag string                `json:"root_etag,omitempty"`
+	Entries  map[string]*syncEntry `json:"entries"`
+}
+
+func syncStateID(syncID string) string {
+	return "nextcloud-sync/" + syncID
+}
+
+func loadSyncState(inst *instance.Instance, syncID string) (*syncState, error) {
+	state := &syncState{}
+	doc, err := couchdb.GetLocal(inst, consts.NextcloudSyncs, syncStateID(syncID))
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		return nil, err
+	}
+	if err == nil {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, err
+		}
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[string]*syncEntry)
+	}
+	return state, nil
+}
+
+func (st *syncState) save(inst *instance.Instance, syncID string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := couchdb.PutLocal(inst, consts.NextcloudSyncs, syncStateID(syncID), doc); err != nil {
+		return err
+	}
+	st.Rev, _ = doc["_rev"].(string)
+	return nil
+}
+
+func deleteSyncState(inst *instance.Instance, syncID string) error {
+	err := couchdb.DeleteLocal(inst, consts.NextcloudSyncs, syncStateID(syncID))
+	if couchdb.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// RunSync reconciles the Cozy folder and the Nextcloud folder of a
+// synchronisation, and saves a report of the run.
+func RunSync(inst *instance.Instance, syncID string) error {
+	s, err := GetSync(inst, syncID)
+	if err != nil {
+		return err
+	}
+	mu := config.Lock().LongOperation(inst, "nextcloud/sync/"+syncID)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	report := &SyncReport{
+		SyncID:    syncID,
+		Conflicts: []SyncConflict{},
+		Errors:    []MigrationError{},
+		StartedAt: time.Now().UTC(),
+	}
+	runErr := runSync(inst, s, report)
+	report.FinishedAt = time.Now().UTC()
+	switch {
+	case runErr != nil:
+		report.Status = SyncStatusFailed
+		report.addError("", runErr)
+	case len(report.Errors) > 0:
+		report.Status = SyncStatusPartial
+	default:
+		report.Status = SyncStatusSuccess
+	}
+	if err := couchdb.CreateDoc(inst, report); err != nil {
+		return err
+	}
+
+	log := inst.Logger().WithNamespace("nextcloud")
+	if err := pruneSyncReports(inst, syncID); err != nil {
+		log.Warnf("Cannot prune the reports of the sync %s: %s", syncID, err)
+	}
+	// The sync is fetched again, as it may have been deleted during the run
+	s, err = GetSync(inst, syncID)
+	if couchdb.IsNotFoundError(err) {
+		_ = couchdb.DeleteDoc(inst, report)
+		return nil
+	}
+	if err == nil {
+		s.LastRunAt = &report.FinishedAt
+		s.LastStatus = report.Status
+		s.LastReportID = report.DocID
+		err = couchdb.UpdateDoc(inst, s)
+	}
+	if err != nil {
+		log.Warnf("Cannot update the sync %s: %s", syncID, err)
+	}
+	return runErr
+}
+
+type syncRun struct {
+	inst    *instance.Instance
+	fs      vfs.VFS
+	nc      *NextCloud
+	sync    *Sync
+	root    *vfs.DirDoc
+	state   *syncState
+	entries map[string]*syncEntry
+	local   map[string]*syncNode
+	remote  map[string]*syncNode
+	report  *SyncReport
+	failed  []string
+}
+
+func runSync(inst *instance.Instance, s *Sync, report *SyncReport) error {
+	nc, err := New(inst, s.AccountID)
+	if err != nil {
+		return err
+	}
+	fs := inst.VFS()
+	root, err := fs.DirByID(s.DirID)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(root.Fullpath, vfs.TrashDirName) {
+		return ErrInvalidSync
+	}
+	state, err := loadSyncState(inst, s.DocID)
+	if err != nil {
+		return err
+	}
+
+	r := &syncRun{
+		inst:    inst,
+		fs:      fs,
+		nc:      nc,
+		sync:    s,
+		root:    root,
+		state:   state,
+		entries: make(map[string]*syncEntry, len(state.Entries)),
+		report:  report,
+	}
+	seq, err := r.scanLocal()
+	if err != nil {
+		return err
+	}
+	rootETag, err := r.scanRemote()
+	if err != nil {
+		return err
+	}
+
+	for p, e := range state.Entries {
+		r.entries[p] = e
+	}
+	var fatal error
+	for _, action := range planSync(state.Entries, r.local, r.remote) {
+		if hasAncestorIn(r.failed, action.Path) {
+			continue
+		}
+		if err := r.apply(action); err != nil {
+			r.failed = append(r.failed, action.Path)
+			if errors.Is(err, webdav.ErrInvalidAuth) {
+				fatal = err
+				break
+			}
+			r.report.addError(action.Path, err)
+		}
+	}
+
+	// When a path has not been reconciled, the ETags of its parents must not
+	// be used to skip them on the next run, and the changes feed must be read
+	// again from the same point to force the scan of the Cozy folder.
+	if len(r.failed) > 0 || fatal != nil {
+		for _, p := range r.failed {
+			for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+				if e := r.entries[dir]; e != nil {
+					e.ETag = ""
+				}
+			}
+		}
+		rootETag = ""
+		seq = state.LastSeq
+	}
+	state.Entries = r.entries
+	state.RootETag = rootETag
+	state.LastSeq = seq
+	if err := state.save(inst, s.DocID); err != nil {
+		return err
+	}
+	return fatal
+}
+
+// scanLocal reads the changes feed of io.cozy.files since the last run, and
+// walks the Cozy folder only if it has been modified. It returns the sequence
+// number to use for the next run.
+func (r *syncRun) scanLocal() (string, error) {
+	r.local = make(map[string]*syncNode)
+	seq, modified, err := r.changesSince(r.state.LastSeq)
+	if err != nil {
+		return "", err
+	}
+	if !modified {
+		for p, e := range r.state.Entries {
+			r.local[p] = &syncNode{Type: e.Type, ID: e.CozyID, MD5: e.MD5, Size: e.Size}
+		}
+		return seq, nil
+	}
+
+	prefix := r.root.Fullpath + "/"
+	err = vfs.Walk(r.fs, r.root.Fullpath, func(name string, dir *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == r.root.Fullpath {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, prefix)
+		if dir != nil {
+			r.local[rel] = &syncNode{Type: syncDir, ID: dir.ID()}
+		} else {
+			r.local[rel] = &syncNode{
+				Type: syncFile,
+				ID:   file.ID(),
+				MD5:  encodeMD5(file.MD5Sum),
+				Size: file.ByteSize,
+			}
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// changesSince returns true if a file or directory of the synchronised folder
+// has been modified since the given sequence number.
+func (r *syncRun) changesSince(since string) (string, bool, error) {
+	if since == "" {
+		seq, err := r.currentSeq()
+		return seq, true, err
+	}
+	known := map[string]bool{r.root.ID(): true}
+	dirs := map[string]bool{r.root.ID(): true}
+	for _, e := range r.state.Entries {
+		known[e.CozyID] = true
+		if e.Type == syncDir {
+			dirs[e.CozyID] = true
+		}
+	}
+	prefix := r.root.Fullpath + "/"
+	for {
+		res, err := couchdb.GetChanges(r.inst, &couchdb.ChangesRequest{
+			DocType:     consts.Files,
+			IncludeDocs: true,
+			Since:       since,
+			Limit:       changesBatchSize,
+		})
+		if err != nil {
+			return "", false, err
+		}
+		for _, change := range res.Results {
+			if known[change.DocID] {
+				// The sequence number is taken before the walk, so that the
+				// changes made during the run are seen by the next one.
+				seq, err := r.currentSeq()
+				return seq, true, err
+			}
+			if change.Deleted || change.Doc.M == nil {
+				continue
+			}
+			dirID, _ := change.Doc.M["dir_id"].(string)
+			fullpath, _ := change.Doc.M["path"].(string)
+			if dirs[dirID] || strings.HasPrefix(fullpath, prefix) {
+				seq, err := r.currentSeq()
+				return seq, true, err
+			}
+		}
+		since = res.LastSeq
+		if len(res.Results) == 0 || res.Pending == 0 {
+			return since, false, nil
+		}
+	}
+}
+
+func (r *syncRun) currentSeq() (string, error) {
+	res, err := couchdb.GetChanges(r.inst, &couchdb.ChangesRequest{
+		DocType: consts.Files,
+		Since:   "now",
+		Limit:   1,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.LastSeq, nil
+}
+
+// scanRemote lists the Nextcloud folder. The directories with the same ETag
+// as in the last run have not been modified, and are not listed again. It
+// returns the ETag of the Nextcloud folder.
+func (r *syncRun) scanRemote() (string, error) {
+	r.remote = make(map[string]*syncNode)
+	root, err := r.nc.webdav.Stat(r.remotePath(""))
+	if err != nil {
+		return "", err
+	}
+	if root.Type != syncDir {
+		return "", ErrInvalidSync
+	}
+	if root.ETag != "" && root.ETag == r.state.RootETag {
+		r.remoteFromEntries("")
+		return root.ETag, nil
+	}
+	return root.ETag, r.listRemote("")
+}
+
+func (r *syncRun) listRemote(dir string) error {
+	items, err := r.nc.webdav.List(r.remotePath(dir))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		p := path.Join(dir, path.Base(strings.TrimSuffix(item.Href, "/")))
+		if item.Type != syncDir {
+			r.remote[p] = &syncNode{
+				Type: syncFile,
+				ETag: item.ETag,
+				MD5:  decodeHexMD5(item.MD5),
+				Size: int64(item.Size),
+			}
+			continue
+		}
+		r.remote[p] = &syncNode{Type: syncDir, ETag: item.ETag}
+		if e := r.state.Entries[p]; e != nil && e.Type == syncDir && e.ETag != "" && e.ETag == item.ETag {
+			r.remoteFromEntries(p)
+		} else if err := r.listRemote(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteFromEntries uses the state of the last run for the content of an
+// unmodified Nextcloud directory.
+func (r *syncRun) remoteFromEntries(dir string) {
+	for p, e := range r.state.Entries {
+		if dir == "" || strings.HasPrefix(p, dir+"/") {
+			r.remote[p] = &syncNode{Type: e.Type, ETag: e.ETag, Size: e.Size}
+		}
+	}
+}
+
+func (r *syncRun) remotePath(p string) string {
+	return "/files/" + r.nc.userID + path.Join(r.sync.RemotePath, p)
+}
+
+func (r *syncRun) apply(action syncAction) error {
+	p := action.Path
+	switch action.Kind {
+	case actionRecord:
+		l, rn := r.local[p], r.remote[p]
+		r.entries[p] = &syncEntry{Type: l.Type, CozyID: l.ID, MD5: l.MD5, ETag: rn.ETag, Size: l.Size}
+	case actionForget:
+		delete(r.entries, p)
+	case actionUpload:
+		return r.upload(p)
+	case actionDownload:
+		return r.download(p)
+	case actionMkdirRemote:
+		err := r.nc.webdav.Mkcol(r.remotePath(p))
+		if err != nil && !errors.Is(err, webdav.ErrAlreadyExist) {
+			return err
+		}
+		// No ETag, so that the directory is listed on the next run
+		r.entries[p] = &syncEntry{Type: syncDir, CozyID: r.local[p].ID}
+	case actionMkdirLocal:
+		return r.mkdirLocal(p)
+	case actionTrashLocal:
+		return r.trashLocal(p)
+	case actionTrashRemote:
+		// Nextcloud moves the deleted files to its trash
+		err := r.nc.webdav.Delete(r.remotePath(p))
+		if err != nil && !errors.Is(err, webdav.ErrNotFound) {
+			return err
+		}
+		r.forget(p)
+		r.report.TrashedRemote++
+	case actionConflict:
+		return r.conflict(p)
+	case actionCompare:
+		return r.compare(p)
+	}
+	return nil
+}
+
+func (r *syncRun) upload(p string) error {
+	doc, err := r.fs.FileByID(r.local[p].ID)
+	if err != nil {
+		return err
+	}
+	f, err := r.fs.OpenFile(doc)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	headers := map[string]string{
+		echo.HeaderContentType: doc.Mime,
+	}
+	if err := r.nc.webdav.Put(r.remotePath(p), doc.ByteSize, headers, f); err != nil {
+		return err
+	}
+	item, err := r.nc.webdav.Stat(r.remotePath(p))
+	if err != nil {
+		return err
+	}
+	r.entries[p] = &syncEntry{
+		Type:   syncFile,
+		CozyID: doc.ID(),
+		MD5:    encodeMD5(doc.MD5Sum),
+		ETag:   item.ETag,
+		Size:   doc.ByteSize,
+	}
+	r.report.Uploaded++
+	return nil
+}
+
+func (r *syncRun) download(p string) error {
+	dl, err := r.nc.webdav.Get(r.remotePath(p))
+	if err != nil {
+		return err
+	}
+	defer dl.Content.Close()
+	size, err := strconv.ParseInt(dl.Length, 10, 64)
+	if err != nil {
+		size = -1
+	}
+
+	var olddoc, newdoc *vfs.FileDoc
+	if l := r.local[p]; l != nil && l.Type == syncFile {
+		if olddoc, err = r.fs.FileByID(l.ID); err != nil {
+			return err
+		}
+		newdoc = olddoc.Clone().(*vfs.FileDoc)
+		newdoc.ByteSize = size
+		newdoc.MD5Sum = nil
+		newdoc.UpdatedAt = time.Now()
+	} else {
+		dirID, err := r.localDirID(path.Dir(p))
+		if err != nil {
+			return err
+		}
+		name := path.Base(p)
+		mime, class := vfs.ExtractMimeAndClassFromFilename(name)
+		newdoc, err = vfs.NewFileDoc(name, dirID, size, nil, mime, class, time.Now(), false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		newdoc.CozyMetadata = vfs.NewCozyMetadata(r.inst.PageURL("/", nil))
+	}
+
+	file, err := r.fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, dl.Content)
+	if cerr := file.Close(); err == nil && cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	md5 := encodeMD5(newdoc.MD5Sum)
+	r.local[p] = &syncNode{Type: syncFile, ID: newdoc.ID(), MD5: md5, Size: newdoc.ByteSize}
+	r.entries[p] = &syncEntry{
+		Type:   syncFile,
+		CozyID: newdoc.ID(),
+		MD5:    md5,
+		ETag:   r.remote[p].ETag,
+		Size:   newdoc.ByteSize,
+	}
+	r.report.Downloaded++
+	return nil
+}
+
+func (r *syncRun) mkdirLocal(p string) error {
+	parentID, err := r.localDirID(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	parent, err := r.fs.DirByID(parentID)
+	if err != nil {
+		return err
+	}
+	dir, err := vfs.NewDirDocWithParent(path.Base(p), parent, nil)
+	if err != nil {
+		return err
+	}
+	dir.CozyMetadata = vfs.NewCozyMetadata(r.inst.PageURL("/", nil))
+	if err := r.fs.CreateDir(dir); err != nil {
+		return err
+	}
+	r.local[p] = &syncNode{Type: syncDir, ID: dir.ID()}
+	r.entries[p] = &syncEntry{Type: syncDir, CozyID: dir.ID(), ETag: r.remote[p].ETag}
+	return nil
+}
+
+func (r *syncRun) trashLocal(p string) error {
+	l := r.local[p]
+	if l.Type == syncDir {
+		dir, err := r.fs.DirByID(l.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := vfs.TrashDir(r.fs, dir); err != nil {
+			return err
+		}
+	} else {
+		file, err := r.fs.FileByID(l.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := vfs.TrashFile(r.fs, file); err != nil {
+			return err
+		}
+	}
+	r.forget(p)
+	r.report.TrashedLocal++
+	return nil
+}
+
+// conflict renames the Cozy version of a path modified on both sides, and
+// takes the Nextcloud version. The renamed file or directory is new for the
+// next run, and it is sent to Nextcloud then.
+func (r *syncRun) conflict(p string) error {
+	l := r.local[p]
+	var renamed string
+	if l.Type == syncDir {
+		dir, err := r.fs.DirByID(l.ID)
+		if err != nil {
+			return err
+		}
+		renamed = vfs.ConflictName(r.fs, dir.DirID, dir.DocName, false)
+		if _, err := vfs.ModifyDirMetadata(r.fs, dir, &vfs.DocPatch{Name: &renamed}); err != nil {
+			return err
+		}
+	} else {
+		file, err := r.fs.FileByID(l.ID)
+		if err != nil {
+			return err
+		}
+		renamed = vfs.ConflictName(r.fs, file.DirID, file.DocName, true)
+		if _, err := vfs.ModifyFileMetadata(r.fs, file, &vfs.DocPatch{Name: &renamed}); err != nil {
+			return err
+		}
+	}
+	if len(r.report.Conflicts) < maxReportedErrors {
+		r.report.Conflicts = append(r.report.Conflicts, SyncConflict{
+			Path:    p,
+			Renamed: path.Join(path.Dir(p), renamed),
+		})
+	}
+	delete(r.local, p)
+	r.forget(p)
+	if r.remote[p].Type == syncDir {
+		return r.mkdirLocal(p)
+	}
+	return r.download(p)
+}
+
+// compare downloads the Nextcloud version of a file seen on both sides on the
+// first run, and records it if it is the same as the Cozy version. Else, it is
+// a conflict.
+func (r *syncRun) compare(p string) error {
+	dl, err := r.nc.webdav.Get(r.remotePath(p))
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	_, err = io.Copy(h, dl.Content)
+	dl.Content.Close()
+	if err != nil {
+		return err
+	}
+	if encodeMD5(h.Sum(nil)) != r.local[p].MD5 {
+		return r.conflict(p)
+	}
+	return r.apply(syncAction{Kind: actionRecord, Path: p})
+}
+
+// localDirID returns the identifier of the Cozy directory for a relative
+// path.
+func (r *syncRun) localDirID(dir string) (string, error) {
+	if dir == "." || dir == "" {
+		return r.root.ID(), nil
+	}
+	if n := r.local[dir]; n != nil && n.Type == syncDir {
+		return n.ID, nil
+	}
+	return "", vfs.ErrParentDoesNotExist
+}
+
+// forget removes a path and its children from the state.
+func (r *syncRun) forget(p string) {
+	delete(r.entries, p)
+	for q := range r.entries {
+		if strings.HasPrefix(q, p+"/") {
+			delete(r.entries, q)
+		}
+	}
+}
+
+func encodeMD5(sum []byte) string {
+	if len(sum) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// decodeHexMD5 converts a checksum from Nextcloud, in hexadecimal, to the
+// encoding used for the Cozy files.
+func decodeHexMD5(sum string) string {
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return ""
+	}
+	return encodeMD5(raw)
+}
@@ -0,0 +1,171 @@
+package nextcloud
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanSync(t *testing.T) {
+	file := func(id, md5, etag string) *syncEntry {
+		return &syncEntry{Type: syncFile, CozyID: id, MD5: md5, ETag: etag, Size: 3}
+	}
+	dir := func(id, etag string) *syncEntry {
+		return &syncEntry{Type: syncDir, CozyID: id, ETag: etag}
+	}
+	localFile := func(id, md5 string) *syncNode {
+		return &syncNode{Type: syncFile, ID: id, MD5: md5, Size: 3}
+	}
+	remoteFile := func(etag string) *syncNode {
+		return &syncNode{Type: syncFile, ETag: etag, Size: 3}
+	}
+	localDir := func(id string) *syncNode { return &syncNode{Type: syncDir, ID: id} }
+	remoteDir := func(etag string) *syncNode { return &syncNode{Type: syncDir, ETag: etag} }
+
+	t.Run("propagates the changes of files", func(t *testing.T) {
+		entries := map[string]*syncEntry{
+			"same":        file("1", "m1", "e1"),
+			"local-edit":  file("2", "m2", "e2"),
+			"remote-edit": file("3", "m3", "e3"),
+			"both-edit":   file("4", "m4", "e4"),
+		}
+		local := map[string]*syncNode{
+			"same":        localFile("1", "m1"),
+			"local-edit":  localFile("2", "m2-bis"),
+			"remote-edit": localFile("3", "m3"),
+			"both-edit":   localFile("4", "m4-bis"),
+			"local-new":   localFile("5", "m5"),
+		}
+		remote := map[string]*syncNode{
+			"same":        remoteFile("e1"),
+			"local-edit":  remoteFile("e2"),
+			"remote-edit": remoteFile("e3-bis"),
+			"both-edit":   remoteFile("e4-bis"),
+			"remote-new":  remoteFile("e6"),
+		}
+		actions := planSync(entries, local, remote)
+		assert.ElementsMatch(t, []syncAction{
+			{Kind: actionRecord, Path: "same"},
+			{Kind: actionUpload, Path: "local-edit"},
+			{Kind: actionDownload, Path: "remote-edit"},
+			{Kind: actionConflict, Path: "both-edit"},
+			{Kind: actionUpload, Path: "local-new"},
+			{Kind: actionDownload, Path: "remote-new"},
+		}, actions)
+	})
+
+	t.Run("propagates the deletions through the trash", func(t *testing.T) {
+		entries := map[string]*syncEntry{
+			"trashed-on-cozy":      file("1", "m1", "e1"),
+			"trashed-on-nextcloud": file("2", "m2", "e2"),
+			"edited-then-trashed":  file("3", "m3", "e3"),
+			"trashed-on-both":      file("4", "m4", "e4"),
+		}
+		local := map[string]*syncNode{
+			"trashed-on-nextcloud": localFile("2", "m2"),
+		}
+		remote := map[string]*syncNode{
+			"trashed-on-cozy":     remoteFile("e1"),
+			"edited-then-trashed": remoteFile("e3-bis"),
+		}
+		actions := planSync(entries, local, remote)
+		assert.ElementsMatch(t, []syncAction{
+			{Kind: actionTrashRemote, Path: "trashed-on-cozy"},
+			{Kind: actionTrashLocal, Path: "trashed-on-nextcloud"},
+			{Kind: actionDownload, Path: "edited-then-trashed"},
+			{Kind: actionForget, Path: "trashed-on-both"},
+		}, actions)
+	})
+
+	t.Run("trashes a directory only if its content is unchanged", func(t *testing.T) {
+		entries := map[string]*syncEntry{
+			"a":       dir("1", "ea"),
+			"a/x.txt": file("2", "m2", "e2"),
+			"b":       dir("3", "eb"),
+			"b/y.txt": file("4", "m4", "e4"),
+		}
+		local := map[string]*syncNode{
+			"a":       localDir("1"),
+			"a/x.txt": localFile("2", "m2"),
+			"b":       localDir("3"),
+			"b/y.txt": localFile("4", "m4-bis"),
+		}
+		remote := map[string]*syncNode{}
+		actions := planSync(entries, local, remote)
+		assert.Equal(t, []syncAction{
+			{Kind: actionTrashLocal, Path: "a"},
+			{Kind: actionMkdirRemote, Path: "b"},
+			{Kind: actionUpload, Path: "b/y.txt"},
+		}, actions)
+	})
+
+	t.Run("trashes a Nextcloud directory deleted on the Cozy", func(t *testing.T) {
+		entries := map[string]*syncEntry{
+			"a":       dir("1", "ea"),
+			"a/x.txt": file("2", "m2", "e2"),
+		}
+		remote := map[string]*syncNode{
+			"a":       remoteDir("ea"),
+			"a/x.txt": remoteFile("e2"),
+			"new":     remoteDir("en"),
+		}
+		actions := planSync(entries, map[string]*syncNode{}, remote)
+		assert.Equal(t, []syncAction{
+			{Kind: actionTrashRemote, Path: "a"},
+			{Kind: actionMkdirLocal, Path: "new"},
+		}, actions)
+	})
+
+	t.Run("creates the parents before their children", func(t *testing.T) {
+		local := map[string]*syncNode{
+			"a b":     localDir("1"),
+			"a":       localDir("2"),
+			"a/c":     localDir("3"),
+			"a/c/d":   localFile("4", "m4"),
+			"a-b.txt": localFile("5", "m5"),
+		}
+		actions := planSync(map[string]*syncEntry{}, local, map[string]*syncNode{})
+		assert.Equal(t, []syncAction{
+			{Kind: actionMkdirRemote, Path: "a"},
+			{Kind: actionMkdirRemote, Path: "a/c"},
+			{Kind: actionUpload, Path: "a/c/d"},
+			{Kind: actionMkdirRemote, Path: "a b"},
+			{Kind: actionUpload, Path: "a-b.txt"},
+		}, actions)
+	})
+
+	t.Run("detects the conflicts on the first run", func(t *testing.T) {
+		local := map[string]*syncNode{
+			"same-size": localFile("1", "m1"),
+			"same-md5":  localFile("5", "m5"),
+			"diff-md5":  localFile("6", "m6"),
+			"other":     {Type: syncFile, ID: "2", MD5: "m2", Size: 42},
+			"kind":      localDir("3"),
+			"kind/z":    localFile("4", "m4"),
+		}
+		remote := map[string]*syncNode{
+			"same-size": remoteFile("e1"),
+			"same-md5":  {Type: syncFile, ETag: "e5", MD5: "m5", Size: 3},
+			"diff-md5":  {Type: syncFile, ETag: "e6", MD5: "m7", Size: 3},
+			"other":     remoteFile("e2"),
+			"kind":      remoteFile("e3"),
+		}
+		actions := planSync(map[string]*syncEntry{}, local, remote)
+		assert.Equal(t, []syncAction{
+			{Kind: actionConflict, Path: "diff-md5"},
+			{Kind: actionConflict, Path: "kind"},
+			{Kind: actionConflict, Path: "other"},
+			{Kind: actionRecord, Path: "same-md5"},
+			{Kind: actionCompare, Path: "same-size"},
+		}, actions)
+	})
+}
+
+func TestIsNested(t *testing.T) {
+	assert.True(t, isNested("/Documents", "/Documents"))
+	assert.True(t, isNested("/Documents", "/Documents/Work"))
+	assert.True(t, isNested("/Documents/Work", "/Documents"))
+	assert.True(t, isNested("/", "/Photos"))
+	assert.False(t, isNested("/Documents", "/Documents2"))
+	assert.False(t, isNested("/Photos", "/Documents"))
+}
@@ -0,0 +1,589 @@
+package note
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/gofrs/uuid/v5"
+)
+
+// annotationMark is the name of the prosemirror mark used to anchor the
+// discussions in the content of a note.
+const annotationMark = "annotation"
+
+// stackSessionID is the sessionID used for the steps made by the stack, like
+// adding the annotation mark for a new discussion.
+const stackSessionID = "stack"
+
+// Comment is a message in a discussion on a note. The first comment of a
+// thread is anchored to a range of the content via an annotation mark, and
+// the other comments of the thread are the replies to it.
+type Comment struct {
+	DocID      string         `json:"_id,omitempty"`
+	DocRev     string         `json:"_rev,omitempty"`
+	NoteID     string         `json:"note_id"`
+	ThreadID   string         `json:"thread_id"`
+	Author     CommentAuthor  `json:"author"`
+	Content    string         `json:"content"`
+	Mentions   []Mention      `json:"mentions,omitempty"`
+	Anchor     *CommentAnchor `json:"anchor,omitempty"`
+	Resolved   bool           `json:"resolved,omitempty"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+	ResolvedBy *CommentAuthor `json:"resolved_by,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// CommentAuthor is the person who has written a comment: the owner of the
+// instance, or a member of a sharing.
+type CommentAuthor struct {
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Mention is a reference to a person inside the content of a comment.
+type Mention struct {
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// CommentAnchor is the range of the note content that a discussion is about.
+// The positions are given for the version of the note, and the orphan flag is
+// set when the annotated text has been removed from the note.
+type CommentAnchor struct {
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+	Version int64  `json:"version"`
+	Quote   string `json:"quote,omitempty"`
+	Orphan  bool   `json:"orphan,omitempty"`
+}
+
+// ID returns the comment qualified identifier
+func (c *Comment) ID() string { return c.DocID }
+
+// Rev returns the comment revision
+func (c *Comment) Rev() string { return c.DocRev }
+
+// DocType returns the comment type
+func (c *Comment) DocType() string { return consts.NotesComments }
+
+// Clone implements couchdb.Doc
+func (c *Comment) Clone() couchdb.Doc {
+	cloned := *c
+	cloned.Mentions = make([]Mention, len(c.Mentions))
+	copy(cloned.Mentions, c.Mentions)
+	if c.Anchor != nil {
+		anchor := *c.Anchor
+		cloned.Anchor = &anchor
+	}
+	if c.ResolvedAt != nil {
+		at := *c.ResolvedAt
+		cloned.ResolvedAt = &at
+	}
+	if c.ResolvedBy != nil {
+		by := *c.ResolvedBy
+		cloned.ResolvedBy = &by
+	}
+	return &cloned
+}
+
+// SetID changes the comment qualified identifier
+func (c *Comment) SetID(id string) { c.DocID = id }
+
+// SetRev changes the comment revision
+func (c *Comment) SetRev(rev string) { c.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (c *Comment) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (c *Comment) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (c *Comment) Relationships() jsonapi.RelationshipMap { return nil }
+
+// IsThread returns true if the comment is the first one of a discussion.
+func (c *Comment) IsThread() bool { return c.ThreadID == c.DocID }
+
+// IsFrom returns true if the comment has been written by the given author.
+func (c *Comment) IsFrom(author CommentAuthor) bool {
+	if c.Author.Instance != "" || author.Instance != "" {
+		return sameInstance(c.Author.Instance, author.Instance)
+	}
+	return c.Author.Email != "" && c.Author.Email == author.Email
+}
+
+// IsOwner returns true if the mention is about the owner of the instance.
+func (m Mention) IsOwner(inst *instance.Instance) bool {
+	return sameInstance(m.Instance, inst.PageURL("", nil))
+}
+
+func sameInstance(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || ua.Host == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
+}
+
+var cbMention func(inst *instance.Instance, file *vfs.FileDoc, comment *Comment)
+
+// RegisterMentionCallback allows to register a callback function called when
+// the owner of the instance is mentioned in a comment on a note.
+func RegisterMentionCallback(cb func(inst *instance.Instance, file *vfs.FileDoc, comment *Comment)) {
+	cbMention = cb
+}
+
+// notifyMentions calls the mention callback if the owner of the instance is
+// mentioned in the comment, and was not already mentioned before. Notifying
+// the other persons mentioned, like the members of a sharing on their own
+// instances, is out of scope: the comments are not replicated by the
+// sharings.
+func notifyMentions(inst *instance.Instance, file *vfs.FileDoc, comment *Comment, before []Mention) {
+	if cbMention == nil {
+		return
+	}
+	for _, m := range before {
+		if m.IsOwner(inst) {
+			return
+		}
+	}
+	if comment.IsFrom(CommentAuthor{Instance: inst.PageURL("", nil)}) {
+		return
+	}
+	for _, m := range comment.Mentions {
+		if m.IsOwner(inst) {
+			go cbMention(inst, file, comment)
+			return
+		}
+	}
+}
+
+func publishComment(inst *instance.Instance, comment *Comment, action string) {
+	event := Event{
+		"doctype":    consts.NotesComments,
+		"action":     action,
+		"comment_id": comment.DocID,
+		"thread_id":  comment.ThreadID,
+		"author":     comment.Author,
+		"content":    comment.Content,
+		"mentions":   comment.Mentions,
+		"resolved":   comment.Resolved,
+		"created_at": comment.CreatedAt,
+		"updated_at": comment.UpdatedAt,
+	}
+	if comment.Anchor != nil {
+		event["anchor"] = comment.Anchor
+	}
+	event.SetID(comment.NoteID)
+	event.publish(inst)
+}
+
+// ListComments returns the comments of a note, sorted by creation date. The
+// anchors of the discussions are computed from the annotation marks of the
+// current version of the note.
+func ListComments(inst *instance.Instance, file *vfs.FileDoc) ([]*Comment, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := getComments(inst, file.ID())
+	if err != nil {
+		return nil, err
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	anchors := findAnchors(content, doc.Version)
+	for _, c := range comments {
+		if !c.IsThread() || c.Anchor == nil {
+			continue
+		}
+		if anchor, ok := anchors[c.DocID]; ok {
+			c.Anchor = anchor
+		} else {
+			c.Anchor.Orphan = true
+		}
+	}
+	return comments, nil
+}
+
+// getComments is the same as ListComments, but with the notes lock already
+// acquired and without computing the anchors.
+func getComments(db prefixer.Prefixer, fileID string) ([]*Comment, error) {
+	var comments []*Comment
+	req := couchdb.AllDocsRequest{
+		Limit:    10000,
+		StartKey: startkey(fileID),
+		EndKey:   endkey(fileID),
+	}
+	if err := couchdb.GetAllDocs(db, consts.NotesComments, &req, &comments); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return comments, nil
+		}
+		return nil, err
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	return comments, nil
+}
+
+func getComment(db prefixer.Prefixer, fileID, commentID string) (*Comment, error) {
+	if !strings.HasPrefix(commentID, startkey(fileID)) {
+		return nil, ErrCommentNotFound
+	}
+	var comment Comment
+	if err := couchdb.GetDoc(db, consts.NotesComments, commentID, &comment); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// findAnchors looks for the annotation marks in the content, and returns the
+// ranges that they cover indexed by the identifier of their discussion.
+func findAnchors(content *model.Node, version int64) map[string]*CommentAnchor {
+	anchors := make(map[string]*CommentAnchor)
+	content.NodesBetween(0, content.Content.Size, func(node *model.Node, pos int, _ *model.Node, _ int) bool {
+		if !node.IsInline() {
+			return true
+		}
+		for _, mark := range node.Marks {
+			if mark.Type.Name != annotationMark {
+				continue
+			}
+			id, _ := mark.Attrs["id"].(string)
+			if id == "" {
+				continue
+			}
+			end := pos + node.NodeSize()
+			if anchor, ok := anchors[id]; ok {
+				if pos < anchor.From {
+					anchor.From = pos
+				}
+				if end > anchor.To {
+					anchor.To = end
+				}
+			} else {
+				anchors[id] = &CommentAnchor{From: pos, To: end, Version: version}
+			}
+		}
+		return true
+	})
+	for _, anchor := range anchors {
+		anchor.Quote = content.TextBetween(anchor.From, anchor.To, " ")
+	}
+	return anchors
+}
+
+// CreateComment adds a comment to a note. If the comment has a thread_id, it
+// is a reply in this discussion. Else, it starts a new discussion, and the
+// anchor is required: its positions are rebased from the version given by the
+// client to the current version of the note, and an annotation mark is added
+// to the content for this range.
+func CreateComment(inst *instance.Instance, file *vfs.FileDoc, comment *Comment) (*Comment, error) {
+	comment.Content = strings.TrimSpace(comment.Content)
+	if comment.Content == "" {
+		return nil, ErrEmptyComment
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	uuidv7, _ := uuid.NewV7()
+	comment.DocID = startkey(file.ID()) + uuidv7.String()
+	comment.DocRev = ""
+	comment.NoteID = file.ID()
+	comment.Resolved = false
+	comment.ResolvedAt = nil
+	comment.ResolvedBy = nil
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+
+	if comment.ThreadID != "" {
+		thread, err := getComment(inst, file.ID(), comment.ThreadID)
+		if err != nil {
+			return nil, err
+		}
+		if !thread.IsThread() {
+			return nil, ErrCommentNotFound
+		}
+		comment.Anchor = nil
+	} else {
+		if comment.Anchor == nil {
+			return nil, ErrInvalidAnchor
+		}
+		comment.ThreadID = comment.DocID
+		if err := addAnnotation(inst, doc, comment); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := couchdb.CreateNamedDocWithDB(inst, comment); err != nil {
+		return nil, err
+	}
+	publishComment(inst, comment, "created")
+	notifyMentions(inst, file, comment, nil)
+	return comment, nil
+}
+
+// addAnnotation adds the annotation mark for a new discussion to the note
+// content, via a step that is saved and sent to the editors like the steps
+// from the clients.
+func addAnnotation(inst *instance.Instance, doc *Document, comment *Comment) error {
+	schema, err := doc.Schema()
+	if err != nil {
+		return ErrInvalidSchema
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return err
+	}
+
+	anchor := comment.Anchor
+	from, to, err := mapRange(inst, doc, anchor.Version, anchor.From, anchor.To)
+	if err != nil {
+		return err
+	}
+	if from < 0 || to > content.Content.Size {
+		return ErrInvalidAnchor
+	}
+
+	mark, err := model.MarkFromJSON(schema, map[string]interface{}{
+		"type": annotationMark,
+		"attrs": map[string]interface{}{
+			"id":             comment.ThreadID,
+			"annotationType": "inlineComment",
+		},
+	})
+	if err != nil {
+		return ErrNoAnnotationMark
+	}
+	if err := applyStackStep(inst, doc, transform.NewAddMarkStep(from, to, mark)); err != nil {
+		return err
+	}
+
+	content, err = doc.Content()
+	if err != nil {
+		return err
+	}
+	if found, ok := findAnchors(content, doc.Version)[comment.ThreadID]; ok {
+		comment.Anchor = found
+	} else {
+		return ErrInvalidAnchor
+	}
+	return nil
+}
+
+// removeAnnotation removes the annotation mark of a discussion from the note
+// content.
+func removeAnnotation(inst *instance.Instance, doc *Document, threadID string) error {
+	schema, err := doc.Schema()
+	if err != nil {
+		return ErrInvalidSchema
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return err
+	}
+	anchor, ok := findAnchors(content, doc.Version)[threadID]
+	if !ok {
+		return nil
+	}
+	mark, err := model.MarkFromJSON(schema, map[string]interface{}{
+		"type": annotationMark,
+		"attrs": map[string]interface{}{
+			"id":             threadID,
+			"annotationType": "inlineComment",
+		},
+	})
+	if err != nil {
+		return nil
+	}
+	return applyStackStep(inst, doc, transform.NewRemoveMarkStep(anchor.From, anchor.To, mark))
+}
+
+// applyStackStep applies a step made by the stack on the note, saves it, and
+// sends it to the editors, who will rebase their local changes on it.
+func applyStackStep(inst *instance.Instance, doc *Document, step transform.Step) error {
+	// The step is serialized to JSON and back to have the same types as the
+	// steps sent by the clients.
+	raw, err := json.Marshal(step.ToJSON())
+	if err != nil {
+		return err
+	}
+	var s Step
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	s["sessionID"] = stackSessionID
+	steps := []Step{s}
+	if err := apply(inst, doc, steps); err != nil {
+		return err
+	}
+	if err := saveSteps(inst, steps); err != nil {
+		return err
+	}
+	publishSteps(inst, doc.ID(), steps)
+	return saveToCache(inst, doc)
+}
+
+// UpdateComment changes the content and the mentions of a comment.
+func UpdateComment(inst *instance.Instance, file *vfs.FileDoc, commentID string, patch *Comment) (*Comment, error) {
+	content := strings.TrimSpace(patch.Content)
+	if content == "" {
+		return nil, ErrEmptyComment
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	comment, err := getComment(inst, file.ID(), commentID)
+	if err != nil {
+		return nil, err
+	}
+	before := comment.Mentions
+	comment.Content = content
+	comment.Mentions = patch.Mentions
+	comment.UpdatedAt = time.Now().UTC()
+	if err := couchdb.UpdateDoc(inst, comment); err != nil {
+		return nil, err
+	}
+	publishComment(inst, comment, "updated")
+	notifyMentions(inst, file, comment, before)
+	return comment, nil
+}
+
+// GetComment returns the comment with the given identifier for the note.
+func GetComment(inst *instance.Instance, file *vfs.FileDoc, commentID string) (*Comment, error) {
+	return getComment(inst, file.ID(), commentID)
+}
+
+// ResolveThread marks a discussion as resolved (or reopens it if resolved is
+// false). The annotation mark is kept in the content, so that the discussion
+// can be reopened later.
+func ResolveThread(inst *instance.Instance, file *vfs.FileDoc, threadID string, resolved bool, by CommentAuthor) (*Comment, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	thread, err := getComment(inst, file.ID(), threadID)
+	if err != nil {
+		return nil, err
+	}
+	if !thread.IsThread() {
+		return nil, ErrCommentNotFound
+	}
+	if thread.Resolved == resolved {
+		return thread, nil
+	}
+
+	now := time.Now().UTC()
+	thread.Resolved = resolved
+	thread.UpdatedAt = now
+	if resolved {
+		thread.ResolvedAt = &now
+		thread.ResolvedBy = &by
+	} else {
+		thread.ResolvedAt = nil
+		thread.ResolvedBy = nil
+	}
+	if err := couchdb.UpdateDoc(inst, thread); err != nil {
+		return nil, err
+	}
+	action := "reopened"
+	if resolved {
+		action = "resolved"
+	}
+	publishComment(inst, thread, action)
+	return thread, nil
+}
+
+// DeleteComment removes a comment. If it is the first comment of a
+// discussion, the replies are also deleted and the annotation mark is removed
+// from the note content.
+func DeleteComment(inst *instance.Instance, file *vfs.FileDoc, commentID string) error {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	comment, err := getComment(inst, file.ID(), commentID)
+	if err != nil {
+		return err
+	}
+	if !comment.IsThread() {
+		if err := couchdb.DeleteDoc(inst, comment); err != nil {
+			return err
+		}
+		publishComment(inst, comment, "deleted")
+		return nil
+	}
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return err
+	}
+	if err := removeAnnotation(inst, doc, comment.DocID); err != nil {
+		return err
+	}
+	comments, err := getComments(inst, file.ID())
+	if err != nil {
+		return err
+	}
+	docs := make([]couchdb.Doc, 0, len(comments))
+	for _, c := range comments {
+		if c.ThreadID == comment.DocID {
+			docs = append(docs, c)
+		}
+	}
+	if err := couchdb.BulkDeleteDocs(inst, consts.NotesComments, docs); err != nil {
+		return err
+	}
+	publishComment(inst, comment, "deleted")
+	return nil
+}
+
+var _ jsonapi.Object = &Comment{}
@@ -0,0 +1,66 @@
+package note
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindAnchors(t *testing.T) {
+	specs := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+
+	content, err := model.NodeFromJSON(schema, map[string]interface{}{
+		"type": "doc",
+		"content": []interface{}{
+			map[string]interface{}{
+				"type": "paragraph",
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "Hello "},
+					map[string]interface{}{
+						"type":  "text",
+						"text":  "beautiful",
+						"marks": []interface{}{map[string]interface{}{"type": "strong"}},
+					},
+					map[string]interface{}{"type": "text", "text": " world"},
+				},
+			},
+			map[string]interface{}{
+				"type": "paragraph",
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "Second paragraph"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	mark, err := model.MarkFromJSON(schema, map[string]interface{}{
+		"type":  annotationMark,
+		"attrs": map[string]interface{}{"id": "note/thread"},
+	})
+	require.NoError(t, err)
+	result := transform.NewAddMarkStep(3, 27, mark).Apply(content)
+	require.Empty(t, result.Failed)
+
+	anchors := findAnchors(result.Doc, 4)
+	require.Contains(t, anchors, "note/thread")
+	anchor := anchors["note/thread"]
+	assert.Equal(t, 3, anchor.From)
+	assert.Equal(t, 27, anchor.To)
+	assert.Equal(t, int64(4), anchor.Version)
+	assert.Equal(t, "llo beautiful world Sec", anchor.Quote)
+
+	// Inserting text inside the annotated range moves its end
+	insert := transform.NewReplaceStep(8, 8, model.NewSlice(model.NewFragment([]*model.Node{schema.Text("very ")}), 0, 0), false)
+	result = insert.Apply(result.Doc)
+	require.Empty(t, result.Failed)
+	anchor = findAnchors(result.Doc, 5)["note/thread"]
+	require.NotNil(t, anchor)
+	assert.Equal(t, 3, anchor.From)
+	assert.Equal(t, 32, anchor.To)
+}
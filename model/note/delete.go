@@ -57,5 +57,23 @@ func deleteNote(db prefixer.Prefixer, noteID string) {
 			}
 			_ = couchdb.BulkDeleteDocs(db, consts.NotesSteps, docs)
 		}
+
+		comments, err := getComments(db, noteID)
+		if err == nil && len(comments) > 0 {
+			docs := make([]couchdb.Doc, 0, len(comments))
+			for _, c := range comments {
+				docs = append(docs, c)
+			}
+			_ = couchdb.BulkDeleteDocs(db, consts.NotesComments, docs)
+		}
+
+		snapshots, err := getSnapshots(db, noteID)
+		if err == nil && len(snapshots) > 0 {
+			docs := make([]couchdb.Doc, 0, len(snapshots))
+			for _, s := range snapshots {
+				docs = append(docs, s)
+			}
+			_ = couchdb.BulkDeleteDocs(db, consts.NotesSnapshots, docs)
+		}
 	}()
 }
@@ -20,4 +20,23 @@ var (
 	ErrTooOld = errors.New("The revision is too old")
 	// ErrMissingSessionID is used when a telepointer has no identifier.
 	ErrMissingSessionID = errors.New("The session id is missing")
+	// ErrInvalidExportFormat is used when a note is exported to an unknown
+	// format.
+	ErrInvalidExportFormat = errors.New("Invalid format for the export")
+	// ErrInvalidImportFormat is used when a document cannot be imported as a
+	// note, because it is not a DOCX or ODT document.
+	ErrInvalidImportFormat = errors.New("Invalid format for the import")
+	// ErrCommentNotFound is used when a comment cannot be found for a note.
+	ErrCommentNotFound = errors.New("Comment not found")
+	// ErrEmptyComment is used when a comment is sent without content.
+	ErrEmptyComment = errors.New("The comment is empty")
+	// ErrInvalidAnchor is used when a comment is anchored to a range of the
+	// note that is empty or no longer exists, or to a version too old to be
+	// rebased.
+	ErrInvalidAnchor = errors.New("Invalid anchor for the comment")
+	// ErrNoAnnotationMark is used when a comment is added to a note with a
+	// schema that doesn't have the annotation mark.
+	ErrNoAnnotationMark = errors.New("The schema of the note has no annotation mark")
+	// ErrSnapshotNotFound is used when a snapshot cannot be found for a note.
+	ErrSnapshotNotFound = errors.New("Snapshot not found")
 )
@@ -0,0 +1,507 @@
+package note
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"  // For decoding the gif images of the notes
+	_ "image/jpeg" // For decoding the jpeg images of the notes
+	_ "image/png"  // For decoding the png images of the notes
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/prosemirror-go/model"
+	_ "golang.org/x/image/webp" // For decoding the webp images of the notes
+)
+
+// The formats that can be used to export a note.
+const (
+	ExportHTML = "html"
+	ExportPDF  = "pdf"
+	ExportDOCX = "docx"
+	ExportODT  = "odt"
+)
+
+// exportRenderer is a function that transforms the content of a note to a
+// file in a given format.
+type exportRenderer func(title string, content *model.Node, images map[string]*exportImage) ([]byte, error)
+
+type exportFormat struct {
+	mime   string
+	render exportRenderer
+}
+
+var exportFormats = map[string]exportFormat{
+	ExportHTML: {mime: "text/html", render: renderHTML},
+	ExportPDF:  {mime: "application/pdf", render: renderPDF},
+	ExportDOCX: {
+		mime:   "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		render: renderDOCX,
+	},
+	ExportODT: {mime: odtMime, render: renderODT},
+}
+
+// Exported is the result of the export of a note.
+type Exported struct {
+	Name    string
+	Mime    string
+	Content []byte
+}
+
+// exportImage is an image of a note, loaded in memory to be embedded in an
+// exported file.
+type exportImage struct {
+	Name   string
+	Mime   string
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Export renders the last version of a note in the given format (html, pdf,
+// docx or odt). The images of the note are embedded in the exported file.
+func Export(inst *instance.Instance, file *vfs.FileDoc, format string) (*Exported, error) {
+	f, ok := exportFormats[format]
+	if !ok {
+		return nil, ErrInvalidExportFormat
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	doc, err := get(inst, file)
+	var images []*Image
+	if err == nil {
+		images, err = getImages(inst, file.ID())
+	}
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := loadExportImages(inst, content, images)
+	if err != nil {
+		return nil, err
+	}
+	body, err := f.render(doc.Title, content, loaded)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(file.DocName, ".cozy-note")
+	return &Exported{
+		Name:    name + "." + format,
+		Mime:    f.mime,
+		Content: body,
+	}, nil
+}
+
+// SaveExport exports a note in the given format, and saves the result as a
+// new file in the VFS. If dirID is empty, the file is created in the same
+// directory as the note.
+func SaveExport(inst *instance.Instance, file *vfs.FileDoc, format, dirID string) (*vfs.FileDoc, error) {
+	exported, err := Export(inst, file, format)
+	if err != nil {
+		return nil, err
+	}
+	if dirID == "" {
+		dirID = file.DirID
+	}
+
+	fs := inst.VFS()
+	name := exported.Name
+	if exists, err := fs.GetIndexer().DirChildExists(dirID, name); err != nil {
+		return nil, err
+	} else if exists {
+		name = vfs.ConflictName(fs, dirID, name, true)
+	}
+	_, class := vfs.ExtractMimeAndClass(exported.Mime)
+	newdoc, err := vfs.NewFileDoc(name, dirID, int64(len(exported.Content)), nil,
+		exported.Mime, class, time.Now(), false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	newdoc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+
+	f, err := fs.CreateFile(newdoc, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(exported.Content)
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newdoc, nil
+}
+
+// loadExportImages reads from the thumbs FS the images that are used in the
+// content of the note.
+func loadExportImages(inst *instance.Instance, content *model.Node, images []*Image) (map[string]*exportImage, error) {
+	used := make(map[string]bool)
+	walkNodes(content, func(node *model.Node) {
+		if node.Type.Name == "media" {
+			if url, ok := node.Attrs["url"].(string); ok {
+				used[url] = true
+			}
+		}
+	})
+
+	loaded := make(map[string]*exportImage)
+	fs := inst.ThumbsFS()
+	for _, img := range images {
+		if !used[img.ID()] {
+			continue
+		}
+		th, err := fs.OpenNoteThumb(img.ID(), consts.NoteImageOriginalFormat)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(th)
+		if errc := th.Close(); err == nil && errc != nil {
+			err = errc
+		}
+		if err != nil {
+			return nil, err
+		}
+		loaded[img.ID()] = newExportImage(img.Name, img.Mime, data, img.Width, img.Height)
+	}
+	return loaded, nil
+}
+
+func newExportImage(name, mime string, data []byte, width, height int) *exportImage {
+	if width == 0 || height == 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+	}
+	return &exportImage{
+		Name:   name,
+		Mime:   mime,
+		Data:   data,
+		Width:  width,
+		Height: height,
+	}
+}
+
+// imageExtension returns the extension to use for an image in an archive.
+func imageExtension(mime string) string {
+	switch mime {
+	case "image/jpeg", "image/jpg":
+		return "jpg"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	case "image/svg+xml":
+		return "svg"
+	case "image/bmp":
+		return "bmp"
+	}
+	return "png"
+}
+
+// scaleImage returns the dimensions of an image, in the same unit as maxWidth,
+// with a ratio for converting pixels to this unit, and without exceeding
+// maxWidth.
+func scaleImage(img *exportImage, ratio, maxWidth float64) (float64, float64) {
+	width, height := float64(img.Width), float64(img.Height)
+	if width <= 0 || height <= 0 {
+		width, height = MaxWidth, MaxWidth*3/4
+	}
+	width *= ratio
+	height *= ratio
+	if width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	return width, height
+}
+
+// walkNodes calls fn for the given node and all its descendants.
+func walkNodes(node *model.Node, fn func(node *model.Node)) {
+	fn(node)
+	if node.Content == nil {
+		return
+	}
+	node.ForEach(func(child *model.Node, _ int, _ int) {
+		walkNodes(child, fn)
+	})
+}
+
+// children returns the list of the children of a node.
+func children(node *model.Node) []*model.Node {
+	var list []*model.Node
+	if node.Content == nil {
+		return list
+	}
+	node.ForEach(func(child *model.Node, _ int, _ int) {
+		list = append(list, child)
+	})
+	return list
+}
+
+// inlineStyle is the style of a text, computed from its marks.
+type inlineStyle struct {
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Strike    bool
+	Code      bool
+	Script    string // "sub" or "sup"
+	Color     string
+	Link      string
+}
+
+func markStyle(marks []*model.Mark) inlineStyle {
+	var style inlineStyle
+	for _, mark := range marks {
+		switch mark.Type.Name {
+		case "strong":
+			style.Bold = true
+		case "em":
+			style.Italic = true
+		case "underline":
+			style.Underline = true
+		case "strike":
+			style.Strike = true
+		case "code":
+			style.Code = true
+		case "subsup":
+			if typ, _ := mark.Attrs["type"].(string); typ == "sub" || typ == "sup" {
+				style.Script = typ
+			}
+		case "textColor":
+			if color, _ := mark.Attrs["color"].(string); isHexColor(color) {
+				style.Color = color
+			}
+		case "link":
+			if href, _ := mark.Attrs["href"].(string); isSafeLink(href) {
+				style.Link = href
+			}
+		}
+	}
+	return style
+}
+
+// blockAlignment returns the alignment of a paragraph or heading: "", "center"
+// or "end".
+func blockAlignment(node *model.Node) string {
+	for _, mark := range node.Marks {
+		if mark.Type.Name == "alignment" {
+			align, _ := mark.Attrs["align"].(string)
+			return align
+		}
+	}
+	return ""
+}
+
+// blockIndentation returns the indentation level of a paragraph or heading.
+func blockIndentation(node *model.Node) int {
+	for _, mark := range node.Marks {
+		if mark.Type.Name == "indentation" {
+			return intAttr(mark.Attrs["level"], 0)
+		}
+	}
+	return 0
+}
+
+func isHexColor(color string) bool {
+	if len(color) != 4 && len(color) != 7 {
+		return false
+	}
+	if color[0] != '#' {
+		return false
+	}
+	for _, c := range color[1:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// hexColor returns the 6 hexadecimal digits of a color, without the #.
+func hexColor(color string) string {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	}
+	return strings.ToUpper(color)
+}
+
+func isSafeLink(href string) bool {
+	lower := strings.ToLower(strings.TrimSpace(href))
+	if lower == "" {
+		return false
+	}
+	i := strings.IndexAny(lower, ":/?#")
+	if i < 0 || lower[i] != ':' {
+		return true // relative link
+	}
+	switch lower[:i] {
+	case "http", "https", "mailto", "tel":
+		return true
+	}
+	return false
+}
+
+func intAttr(value interface{}, defaultValue int) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	case []byte:
+		if i, err := strconv.Atoi(string(v)); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// dateText returns the text for a date node.
+func dateText(node *model.Node) string {
+	ts, _ := node.Attrs["timestamp"].(string)
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return time.Unix(ms/1000, 0).UTC().Format("2006-01-02")
+}
+
+// statusText returns the text for a status node.
+func statusText(node *model.Node) string {
+	text, _ := node.Attrs["text"].(string)
+	return strings.ToUpper(text)
+}
+
+// panelColors are the background and border colors for the panels.
+var panelColors = map[string][2]string{
+	"info":    {"#DEEBFF", "#0052CC"},
+	"note":    {"#EAE6FF", "#5243AA"},
+	"tip":     {"#E3FCEF", "#00875A"},
+	"success": {"#E3FCEF", "#00875A"},
+	"warning": {"#FFFAE6", "#FF8B00"},
+	"error":   {"#FFEBE6", "#DE350B"},
+}
+
+func panelColor(node *model.Node) (background, border string) {
+	typ, _ := node.Attrs["panelType"].(string)
+	colors, ok := panelColors[typ]
+	if !ok {
+		colors = panelColors["info"]
+	}
+	return colors[0], colors[1]
+}
+
+// statusColors are the background colors for the status nodes.
+var statusColors = map[string]string{
+	"neutral": "#DFE1E6",
+	"purple":  "#EAE6FF",
+	"blue":    "#DEEBFF",
+	"red":     "#FFEBE6",
+	"yellow":  "#FFF0B3",
+	"green":   "#E3FCEF",
+}
+
+func statusColor(node *model.Node) string {
+	color, _ := node.Attrs["color"].(string)
+	if c, ok := statusColors[color]; ok {
+		return c
+	}
+	return statusColors["neutral"]
+}
+
+// tableCell is a cell of a table, with its position in the grid.
+type tableCell struct {
+	Node    *model.Node
+	Header  bool
+	Row     int
+	Col     int
+	Rowspan int
+	Colspan int
+}
+
+// tableGrid is the layout of a table: Cells[r][c] is the cell that covers
+// the row r and the column c, or nil if there is no cell here.
+type tableGrid struct {
+	Rows  int
+	Cols  int
+	Cells [][]*tableCell
+}
+
+// IsOrigin returns true if the cell at the row r and column c starts here.
+func (g *tableGrid) IsOrigin(r, c int) bool {
+	cell := g.Cells[r][c]
+	return cell != nil && cell.Row == r && cell.Col == c
+}
+
+// newTableGrid computes the position of the cells of a table, with the
+// rowspan and colspan.
+func newTableGrid(table *model.Node) *tableGrid {
+	var cells []*tableCell
+	occupied := make(map[[2]int]bool)
+	rows, cols := 0, 0
+	for r, row := range children(table) {
+		rows = r + 1
+		c := 0
+		for _, node := range children(row) {
+			for occupied[[2]int{r, c}] {
+				c++
+			}
+			cell := &tableCell{
+				Node:    node,
+				Header:  node.Type.Name == "tableHeader",
+				Row:     r,
+				Col:     c,
+				Rowspan: max(intAttr(node.Attrs["rowspan"], 1), 1),
+				Colspan: max(intAttr(node.Attrs["colspan"], 1), 1),
+			}
+			for i := 0; i < cell.Rowspan; i++ {
+				for j := 0; j < cell.Colspan; j++ {
+					occupied[[2]int{r + i, c + j}] = true
+				}
+			}
+			cells = append(cells, cell)
+			c += cell.Colspan
+			cols = max(cols, c)
+		}
+	}
+
+	grid := &tableGrid{Rows: rows, Cols: cols, Cells: make([][]*tableCell, rows)}
+	for r := range grid.Cells {
+		grid.Cells[r] = make([]*tableCell, cols)
+	}
+	for _, cell := range cells {
+		// A rowspan can't go beyond the last row
+		cell.Rowspan = min(cell.Rowspan, rows-cell.Row)
+		for i := 0; i < cell.Rowspan; i++ {
+			for j := 0; j < cell.Colspan; j++ {
+				grid.Cells[cell.Row+i][cell.Col+j] = cell
+			}
+		}
+	}
+	return grid
+}
@@ -0,0 +1,555 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const (
+	// docxEMUPerPixel is the number of English Metric Units for a pixel at
+	// 96 dpi.
+	docxEMUPerPixel = 9525
+	// docxMaxImageWidth is the width of the text on an A4 page with margins
+	// of 1 inch, in EMU.
+	docxMaxImageWidth = 5733415
+	// docxIndent is the indentation for a level of lists or quotes, in
+	// twentieths of a point.
+	docxIndent = 720
+)
+
+const docxNamespaces = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" ` +
+	`xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" ` +
+	`xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" ` +
+	`xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"`
+
+const docxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:sz w:val="48"/></w:rPr></w:style>
+%s<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F4F5F7"/><w:spacing w:after="0"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0052CC"/><w:u w:val="single"/></w:rPr></w:style>
+<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>` +
+	`<w:top w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/><w:left w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/>` +
+	`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/><w:right w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/>` +
+	`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="C1C7D0"/>` +
+	`</w:tblBorders><w:tblCellMar><w:left w:w="108" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>
+</w:styles>`
+
+// docxHeadingSizes are the font sizes of the headings, in half-points.
+var docxHeadingSizes = []int{40, 32, 28, 26, 24, 22}
+
+// docxBlock is the context for rendering the paragraphs of a block.
+type docxBlock struct {
+	numID      int // 0 for no numbering
+	level      int
+	depth      int // the number of enclosing lists
+	indent     int
+	shading    string
+	borderLeft string
+	bold       bool
+}
+
+type docxRenderer struct {
+	body    strings.Builder
+	images  map[string]*exportImage
+	rels    []string
+	imgRels map[string]string // image id -> relationship id
+	files   map[string][]byte
+	exts    map[string]string
+	nums    []int // the abstract numbering for each w:num
+	drawing int
+}
+
+// renderDOCX exports the content of a note to an Office Open XML document.
+func renderDOCX(title string, content *model.Node, images map[string]*exportImage) ([]byte, error) {
+	r := &docxRenderer{
+		images:  images,
+		imgRels: make(map[string]string),
+		files:   make(map[string][]byte),
+		exts:    make(map[string]string),
+	}
+	r.rels = []string{
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`,
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>`,
+	}
+	// The first numbering is shared by all the bullet lists
+	r.nums = []int{0}
+
+	if title != "" {
+		r.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr>`)
+		r.run(title, inlineStyle{}, false)
+		r.body.WriteString(`</w:p>`)
+	}
+	r.blocks(content, docxBlock{})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", r.contentTypes()},
+		{"_rels/.rels", docxRootRels},
+		{"word/document.xml", r.document()},
+		{"word/styles.xml", fmt.Sprintf(docxStyles, docxHeadingStyles())},
+		{"word/numbering.xml", r.numbering()},
+		{"word/_rels/document.xml.rels", r.relationships()},
+	}
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(r.files))
+	for name := range r.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, err := zw.Create("word/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(r.files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func docxHeadingStyles() string {
+	var sb strings.Builder
+	for i, size := range docxHeadingSizes {
+		fmt.Fprintf(&sb, `<w:style w:type="paragraph" w:styleId="Heading%d"><w:name w:val="heading %d"/>`+
+			`<w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240" w:after="120"/>`+
+			`<w:outlineLvl w:val="%d"/></w:pPr><w:rPr><w:b/><w:sz w:val="%d"/></w:rPr></w:style>`+"\n", i+1, i+1, i, size)
+	}
+	return sb.String()
+}
+
+func (r *docxRenderer) document() string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<w:document ` + docxNamespaces + `><w:body>` + r.body.String() +
+		`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+		`<w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/>` +
+		`</w:sectPr></w:body></w:document>`
+}
+
+func (r *docxRenderer) contentTypes() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sb.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	sb.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	sb.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	exts := make([]string, 0, len(r.exts))
+	for ext := range r.exts {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		fmt.Fprintf(&sb, `<Default Extension="%s" ContentType="%s"/>`, ext, xmlEscape(r.exts[ext]))
+	}
+	sb.WriteString(`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>`)
+	sb.WriteString(`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>`)
+	sb.WriteString(`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>`)
+	sb.WriteString(`</Types>`)
+	return sb.String()
+}
+
+func (r *docxRenderer) relationships() string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		strings.Join(r.rels, "") + `</Relationships>`
+}
+
+func (r *docxRenderer) numbering() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sb.WriteString(`<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+	bullets := []string{"•", "◦", "▪"}
+	for abstract := 0; abstract < 2; abstract++ {
+		fmt.Fprintf(&sb, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstract)
+		for lvl := 0; lvl < 9; lvl++ {
+			format, text := "bullet", bullets[lvl%len(bullets)]
+			if abstract == 1 {
+				format, text = "decimal", fmt.Sprintf("%%%d.", lvl+1)
+			}
+			fmt.Fprintf(&sb, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/>`+
+				`<w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`,
+				lvl, format, text, docxIndent*(lvl+1))
+		}
+		sb.WriteString(`</w:abstractNum>`)
+	}
+	for i, abstract := range r.nums {
+		fmt.Fprintf(&sb, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/>`, i+1, abstract)
+		if abstract == 1 {
+			sb.WriteString(`<w:lvlOverride w:ilvl="0"><w:startOverride w:val="1"/></w:lvlOverride>`)
+		}
+		sb.WriteString(`</w:num>`)
+	}
+	sb.WriteString(`</w:numbering>`)
+	return sb.String()
+}
+
+func (r *docxRenderer) addRelationship(typ, target string, external bool) string {
+	id := fmt.Sprintf("rId%d", len(r.rels)+1)
+	mode := ""
+	if external {
+		mode = ` TargetMode="External"`
+	}
+	r.rels = append(r.rels, fmt.Sprintf(`<Relationship Id="%s" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/%s" Target="%s"%s/>`,
+		id, typ, xmlEscape(target), mode))
+	return id
+}
+
+func (r *docxRenderer) blocks(parent *model.Node, ctx docxBlock) {
+	for _, node := range children(parent) {
+		r.block(node, ctx)
+	}
+}
+
+func (r *docxRenderer) block(node *model.Node, ctx docxBlock) {
+	switch node.Type.Name {
+	case "paragraph":
+		r.paragraph(node, ctx, "")
+	case "heading":
+		level := min(max(intAttr(node.Attrs["level"], 1), 1), 6)
+		r.paragraph(node, ctx, fmt.Sprintf("Heading%d", level))
+	case "bulletList", "orderedList":
+		numID := 1
+		if node.Type.Name == "orderedList" {
+			r.nums = append(r.nums, 1)
+			numID = len(r.nums)
+		}
+		level := min(ctx.depth, 8)
+		for _, item := range children(node) {
+			first := true
+			for _, child := range children(item) {
+				itemCtx := ctx
+				itemCtx.level = level
+				itemCtx.depth = ctx.depth + 1
+				if first && child.Type.Name == "paragraph" {
+					itemCtx.numID = numID
+				} else {
+					itemCtx.numID = 0
+					itemCtx.indent = ctx.indent + docxIndent*(level+1)
+				}
+				first = false
+				r.block(child, itemCtx)
+			}
+		}
+	case "taskList", "decisionList":
+		for _, child := range children(node) {
+			itemCtx := ctx
+			itemCtx.numID = 0
+			itemCtx.indent = ctx.indent + docxIndent/2
+			r.block(child, itemCtx)
+		}
+	case "taskItem":
+		prefix := "☐ "
+		if node.Attrs["state"] == "DONE" {
+			prefix = "☒ "
+		}
+		r.paragraphWithPrefix(node, ctx, prefix)
+	case "decisionItem":
+		r.paragraphWithPrefix(node, ctx, "✍ ")
+	case "blockquote":
+		ctx.indent += docxIndent / 2
+		ctx.borderLeft = "C1C7D0"
+		r.blocks(node, ctx)
+	case "panel":
+		background, border := panelColor(node)
+		ctx.shading = hexColor(background)
+		ctx.borderLeft = hexColor(border)
+		r.blocks(node, ctx)
+	case "codeBlock":
+		r.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Code"/>`)
+		r.paragraphProperties(ctx, "")
+		r.body.WriteString(`</w:pPr>`)
+		for i, line := range strings.Split(node.TextContent(), "\n") {
+			if i > 0 {
+				r.body.WriteString(`<w:r><w:br/></w:r>`)
+			}
+			r.run(line, inlineStyle{}, false)
+		}
+		r.body.WriteString(`</w:p>`)
+	case "rule":
+		r.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="C1C7D0"/></w:pBdr></w:pPr></w:p>`)
+	case "table":
+		r.table(node, ctx)
+	case "mediaSingle":
+		r.blocks(node, ctx)
+	case "media":
+		r.body.WriteString(`<w:p><w:pPr><w:jc w:val="center"/></w:pPr>`)
+		r.media(node)
+		r.body.WriteString(`</w:p>`)
+	default:
+		if node.IsBlock() && node.Type.InlineContent {
+			r.paragraph(node, ctx, "")
+		} else if node.IsBlock() {
+			r.blocks(node, ctx)
+		}
+	}
+}
+
+func (r *docxRenderer) paragraphProperties(ctx docxBlock, align string) {
+	if ctx.numID != 0 {
+		fmt.Fprintf(&r.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, ctx.level, ctx.numID)
+	}
+	if ctx.borderLeft != "" {
+		fmt.Fprintf(&r.body, `<w:pBdr><w:left w:val="single" w:sz="18" w:space="8" w:color="%s"/></w:pBdr>`, ctx.borderLeft)
+	}
+	if ctx.shading != "" {
+		fmt.Fprintf(&r.body, `<w:shd w:val="clear" w:color="auto" w:fill="%s"/>`, ctx.shading)
+	}
+	if ctx.indent > 0 && ctx.numID == 0 {
+		fmt.Fprintf(&r.body, `<w:ind w:left="%d"/>`, ctx.indent)
+	}
+	switch align {
+	case "center":
+		r.body.WriteString(`<w:jc w:val="center"/>`)
+	case "end":
+		r.body.WriteString(`<w:jc w:val="right"/>`)
+	}
+}
+
+func (r *docxRenderer) paragraph(node *model.Node, ctx docxBlock, style string) {
+	if level := blockIndentation(node); level > 0 {
+		ctx.indent += docxIndent * level
+	}
+	r.body.WriteString(`<w:p><w:pPr>`)
+	if style != "" {
+		fmt.Fprintf(&r.body, `<w:pStyle w:val="%s"/>`, style)
+	}
+	r.paragraphProperties(ctx, blockAlignment(node))
+	r.body.WriteString(`</w:pPr>`)
+	r.inline(node, ctx)
+	r.body.WriteString(`</w:p>`)
+}
+
+func (r *docxRenderer) paragraphWithPrefix(node *model.Node, ctx docxBlock, prefix string) {
+	r.body.WriteString(`<w:p><w:pPr>`)
+	r.paragraphProperties(ctx, "")
+	r.body.WriteString(`</w:pPr>`)
+	r.run(prefix, inlineStyle{}, false)
+	r.inline(node, ctx)
+	r.body.WriteString(`</w:p>`)
+}
+
+func (r *docxRenderer) inline(parent *model.Node, ctx docxBlock) {
+	for _, node := range children(parent) {
+		switch node.Type.Name {
+		case "text":
+			style := markStyle(node.Marks)
+			if ctx.bold {
+				style.Bold = true
+			}
+			if style.Link != "" {
+				id := r.addRelationship("hyperlink", style.Link, true)
+				fmt.Fprintf(&r.body, `<w:hyperlink r:id="%s">`, id)
+				r.run(*node.Text, style, true)
+				r.body.WriteString(`</w:hyperlink>`)
+			} else {
+				r.run(*node.Text, style, false)
+			}
+		case "hardBreak":
+			r.body.WriteString(`<w:r><w:br/></w:r>`)
+		case "status":
+			fmt.Fprintf(&r.body, `<w:r><w:rPr><w:b/><w:sz w:val="18"/><w:shd w:val="clear" w:color="auto" w:fill="%s"/></w:rPr>`,
+				hexColor(statusColor(node)))
+			r.text(statusText(node))
+			r.body.WriteString(`</w:r>`)
+		case "date":
+			r.run(dateText(node), inlineStyle{Bold: ctx.bold}, false)
+		case "media":
+			r.media(node)
+		case "mediaSingle":
+			for _, child := range children(node) {
+				r.media(child)
+			}
+		default:
+			r.run(node.TextContent(), inlineStyle{Bold: ctx.bold}, false)
+		}
+	}
+}
+
+func (r *docxRenderer) run(text string, style inlineStyle, link bool) {
+	if text == "" {
+		return
+	}
+	r.body.WriteString(`<w:r><w:rPr>`)
+	if link {
+		r.body.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+	}
+	if style.Code {
+		r.body.WriteString(`<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`)
+	}
+	if style.Bold {
+		r.body.WriteString(`<w:b/>`)
+	}
+	if style.Italic {
+		r.body.WriteString(`<w:i/>`)
+	}
+	if style.Strike {
+		r.body.WriteString(`<w:strike/>`)
+	}
+	if style.Color != "" {
+		fmt.Fprintf(&r.body, `<w:color w:val="%s"/>`, hexColor(style.Color))
+	}
+	if style.Underline {
+		r.body.WriteString(`<w:u w:val="single"/>`)
+	}
+	switch style.Script {
+	case "sub":
+		r.body.WriteString(`<w:vertAlign w:val="subscript"/>`)
+	case "sup":
+		r.body.WriteString(`<w:vertAlign w:val="superscript"/>`)
+	}
+	r.body.WriteString(`</w:rPr>`)
+	for i, part := range strings.Split(text, "\t") {
+		if i > 0 {
+			r.body.WriteString(`<w:tab/>`)
+		}
+		r.text(part)
+	}
+	r.body.WriteString(`</w:r>`)
+}
+
+func (r *docxRenderer) text(text string) {
+	if text == "" {
+		return
+	}
+	r.body.WriteString(`<w:t xml:space="preserve">`)
+	r.body.WriteString(xmlEscape(text))
+	r.body.WriteString(`</w:t>`)
+}
+
+func (r *docxRenderer) media(node *model.Node) {
+	url, _ := node.Attrs["url"].(string)
+	img, ok := r.images[url]
+	if !ok {
+		alt, _ := node.Attrs["alt"].(string)
+		r.run(alt, inlineStyle{Italic: true}, false)
+		return
+	}
+	relID, ok := r.imgRels[url]
+	if !ok {
+		ext := imageExtension(img.Mime)
+		name := fmt.Sprintf("media/image%d.%s", len(r.imgRels)+1, ext)
+		r.files[name] = img.Data
+		r.exts[ext] = img.Mime
+		relID = r.addRelationship("image", name, false)
+		r.imgRels[url] = relID
+	}
+	r.drawing++
+	width, height := scaleImage(img, docxEMUPerPixel, docxMaxImageWidth)
+	cx, cy := int64(width), int64(height)
+	name := xmlEscape(img.Name)
+	fmt.Fprintf(&r.body, `<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="%s"/>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic><pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		cx, cy, r.drawing, name, r.drawing, name, relID, cx, cy)
+}
+
+func (r *docxRenderer) table(node *model.Node, ctx docxBlock) {
+	grid := newTableGrid(node)
+	if grid.Cols == 0 {
+		return
+	}
+	// 9026 is the width of the text on an A4 page with margins of 1 inch
+	colWidth := (9026 - ctx.indent) / grid.Cols
+	r.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/>`)
+	if ctx.indent > 0 {
+		fmt.Fprintf(&r.body, `<w:tblInd w:w="%d" w:type="dxa"/>`, ctx.indent)
+	}
+	r.body.WriteString(`</w:tblPr><w:tblGrid>`)
+	for i := 0; i < grid.Cols; i++ {
+		fmt.Fprintf(&r.body, `<w:gridCol w:w="%d"/>`, colWidth)
+	}
+	r.body.WriteString(`</w:tblGrid>`)
+
+	cellCtx := docxBlock{}
+	for row := 0; row < grid.Rows; row++ {
+		r.body.WriteString(`<w:tr>`)
+		for col := 0; col < grid.Cols; {
+			cell := grid.Cells[row][col]
+			if cell == nil {
+				fmt.Fprintf(&r.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr><w:p/></w:tc>`, colWidth)
+				col++
+				continue
+			}
+			if cell.Col != col {
+				// Should not happen, except for invalid tables
+				col++
+				continue
+			}
+			fmt.Fprintf(&r.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, colWidth*cell.Colspan)
+			if cell.Colspan > 1 {
+				fmt.Fprintf(&r.body, `<w:gridSpan w:val="%d"/>`, cell.Colspan)
+			}
+			if cell.Rowspan > 1 {
+				if cell.Row == row {
+					r.body.WriteString(`<w:vMerge w:val="restart"/>`)
+				} else {
+					r.body.WriteString(`<w:vMerge/>`)
+				}
+			}
+			background, _ := cell.Node.Attrs["background"].(string)
+			if isHexColor(background) {
+				fmt.Fprintf(&r.body, `<w:shd w:val="clear" w:color="auto" w:fill="%s"/>`, hexColor(background))
+			} else if cell.Header {
+				r.body.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="F4F5F7"/>`)
+			}
+			r.body.WriteString(`</w:tcPr>`)
+			if cell.Row == row {
+				// A cell must end with a paragraph
+				cellCtx.bold = cell.Header
+				before := r.body.Len()
+				r.blocks(cell.Node, cellCtx)
+				if r.body.Len() == before || !strings.HasSuffix(r.body.String(), `</w:p>`) {
+					r.body.WriteString(`<w:p/>`)
+				}
+			} else {
+				r.body.WriteString(`<w:p/>`)
+			}
+			r.body.WriteString(`</w:tc>`)
+			col += cell.Colspan
+		}
+		r.body.WriteString(`</w:tr>`)
+	}
+	r.body.WriteString(`</w:tbl>`)
+	// Two consecutive tables would be merged without a paragraph between them
+	r.body.WriteString(`<w:p/>`)
+}
+
+// xmlEscape escapes a string to be used in a XML text node or attribute.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,274 @@
+package note
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const htmlStyle = `
+body { font-family: Lato, Helvetica, Arial, sans-serif; line-height: 1.5; color: #172B4D; }
+.note { max-width: 48rem; margin: 2rem auto; padding: 0 1rem; }
+pre { background: #F4F5F7; padding: .5rem 1rem; overflow-x: auto; }
+code { font-family: "Courier New", monospace; }
+blockquote { border-left: 2px solid #DFE1E6; margin-left: 0; padding-left: 1rem; color: #5E6C84; }
+table { border-collapse: collapse; width: 100%; margin: 1rem 0; }
+th, td { border: 1px solid #C1C7D0; padding: .25rem .5rem; vertical-align: top; text-align: left; }
+th { background: #F4F5F7; }
+figure { margin: 1rem 0; text-align: center; }
+img { max-width: 100%; }
+.panel { border-left: 4px solid; border-radius: 3px; padding: .5rem 1rem; margin: .75rem 0; }
+.task-list, .decision-list { list-style: none; padding-left: 1rem; }
+.task-item input { margin-right: .5rem; }
+.decision-item::before { content: "✍"; margin-right: .5rem; }
+.status { border-radius: 3px; padding: 0 .25rem; font-size: .75em; font-weight: bold; }
+`
+
+type htmlRenderer struct {
+	buf    strings.Builder
+	images map[string]*exportImage
+}
+
+// renderHTML exports the content of a note to a standalone HTML page, with
+// the images embedded as data URLs.
+func renderHTML(title string, content *model.Node, images map[string]*exportImage) ([]byte, error) {
+	r := &htmlRenderer{images: images}
+	r.buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&r.buf, "<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&r.buf, "<style>%s</style>\n", htmlStyle)
+	r.buf.WriteString("</head>\n<body>\n<article class=\"note\">\n")
+	if title != "" {
+		fmt.Fprintf(&r.buf, "<h1 class=\"note-title\">%s</h1>\n", html.EscapeString(title))
+	}
+	r.blocks(content)
+	r.buf.WriteString("</article>\n</body>\n</html>\n")
+	return []byte(r.buf.String()), nil
+}
+
+func (r *htmlRenderer) blocks(parent *model.Node) {
+	for _, node := range children(parent) {
+		r.block(node)
+	}
+}
+
+func (r *htmlRenderer) block(node *model.Node) {
+	switch node.Type.Name {
+	case "paragraph":
+		r.buf.WriteString("<p" + r.blockStyle(node) + ">")
+		r.inline(node)
+		r.buf.WriteString("</p>\n")
+	case "heading":
+		level := min(max(intAttr(node.Attrs["level"], 1), 1), 6)
+		fmt.Fprintf(&r.buf, "<h%d%s>", level, r.blockStyle(node))
+		r.inline(node)
+		fmt.Fprintf(&r.buf, "</h%d>\n", level)
+	case "bulletList":
+		r.buf.WriteString("<ul>\n")
+		r.blocks(node)
+		r.buf.WriteString("</ul>\n")
+	case "orderedList":
+		start := intAttr(node.Attrs["order"], 1)
+		if start != 1 {
+			fmt.Fprintf(&r.buf, "<ol start=\"%d\">\n", start)
+		} else {
+			r.buf.WriteString("<ol>\n")
+		}
+		r.blocks(node)
+		r.buf.WriteString("</ol>\n")
+	case "listItem":
+		r.buf.WriteString("<li>")
+		r.blocks(node)
+		r.buf.WriteString("</li>\n")
+	case "taskList":
+		r.buf.WriteString("<ul class=\"task-list\">\n")
+		for _, child := range children(node) {
+			if child.Type.Name == "taskList" {
+				r.buf.WriteString("<li>")
+				r.block(child)
+				r.buf.WriteString("</li>\n")
+			} else {
+				r.block(child)
+			}
+		}
+		r.buf.WriteString("</ul>\n")
+	case "taskItem":
+		checked := ""
+		if node.Attrs["state"] == "DONE" {
+			checked = " checked"
+		}
+		fmt.Fprintf(&r.buf, "<li class=\"task-item\"><input type=\"checkbox\" disabled%s>", checked)
+		r.inline(node)
+		r.buf.WriteString("</li>\n")
+	case "decisionList":
+		r.buf.WriteString("<ul class=\"decision-list\">\n")
+		r.blocks(node)
+		r.buf.WriteString("</ul>\n")
+	case "decisionItem":
+		r.buf.WriteString("<li class=\"decision-item\">")
+		r.inline(node)
+		r.buf.WriteString("</li>\n")
+	case "blockquote":
+		r.buf.WriteString("<blockquote>\n")
+		r.blocks(node)
+		r.buf.WriteString("</blockquote>\n")
+	case "codeBlock":
+		lang, _ := node.Attrs["language"].(string)
+		if lang != "" {
+			fmt.Fprintf(&r.buf, "<pre><code class=\"language-%s\">", html.EscapeString(lang))
+		} else {
+			r.buf.WriteString("<pre><code>")
+		}
+		r.buf.WriteString(html.EscapeString(node.TextContent()))
+		r.buf.WriteString("</code></pre>\n")
+	case "rule":
+		r.buf.WriteString("<hr>\n")
+	case "panel":
+		typ, _ := node.Attrs["panelType"].(string)
+		background, border := panelColor(node)
+		fmt.Fprintf(&r.buf, "<div class=\"panel panel-%s\" style=\"background-color: %s; border-color: %s\">\n",
+			html.EscapeString(typ), background, border)
+		r.blocks(node)
+		r.buf.WriteString("</div>\n")
+	case "table":
+		r.buf.WriteString("<table>\n")
+		r.blocks(node)
+		r.buf.WriteString("</table>\n")
+	case "tableRow":
+		r.buf.WriteString("<tr>")
+		r.blocks(node)
+		r.buf.WriteString("</tr>\n")
+	case "tableHeader", "tableCell":
+		tag := "td"
+		if node.Type.Name == "tableHeader" {
+			tag = "th"
+		}
+		r.buf.WriteString("<" + tag)
+		if span := intAttr(node.Attrs["colspan"], 1); span > 1 {
+			fmt.Fprintf(&r.buf, " colspan=\"%d\"", span)
+		}
+		if span := intAttr(node.Attrs["rowspan"], 1); span > 1 {
+			fmt.Fprintf(&r.buf, " rowspan=\"%d\"", span)
+		}
+		if color, _ := node.Attrs["background"].(string); isHexColor(color) {
+			fmt.Fprintf(&r.buf, " style=\"background-color: %s\"", color)
+		}
+		r.buf.WriteString(">")
+		r.blocks(node)
+		r.buf.WriteString("</" + tag + ">")
+	case "mediaSingle":
+		r.buf.WriteString("<figure>")
+		r.blocks(node)
+		r.buf.WriteString("</figure>\n")
+	case "media":
+		r.media(node)
+	default:
+		if node.IsBlock() && node.Type.InlineContent {
+			r.buf.WriteString("<div>")
+			r.inline(node)
+			r.buf.WriteString("</div>\n")
+		} else if node.IsBlock() {
+			r.blocks(node)
+		} else {
+			r.inlineNode(node)
+		}
+	}
+}
+
+func (r *htmlRenderer) blockStyle(node *model.Node) string {
+	var styles []string
+	switch blockAlignment(node) {
+	case "center":
+		styles = append(styles, "text-align: center")
+	case "end":
+		styles = append(styles, "text-align: right")
+	}
+	if level := blockIndentation(node); level > 0 {
+		styles = append(styles, fmt.Sprintf("margin-left: %drem", 2*level))
+	}
+	if len(styles) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" style=\"%s\"", strings.Join(styles, "; "))
+}
+
+func (r *htmlRenderer) media(node *model.Node) {
+	url, _ := node.Attrs["url"].(string)
+	alt, _ := node.Attrs["alt"].(string)
+	if img, ok := r.images[url]; ok {
+		if alt == "" {
+			alt = img.Name
+		}
+		data := base64.StdEncoding.EncodeToString(img.Data)
+		fmt.Fprintf(&r.buf, "<img src=\"data:%s;base64,%s\" alt=\"%s\"", html.EscapeString(img.Mime), data, html.EscapeString(alt))
+		if img.Width > 0 {
+			fmt.Fprintf(&r.buf, " width=\"%d\"", min(img.Width, MaxWidth))
+		}
+		r.buf.WriteString(">")
+	} else if node.Attrs["type"] == "external" && isSafeLink(url) {
+		fmt.Fprintf(&r.buf, "<img src=\"%s\" alt=\"%s\">", html.EscapeString(url), html.EscapeString(alt))
+	}
+}
+
+func (r *htmlRenderer) inline(parent *model.Node) {
+	for _, node := range children(parent) {
+		r.inlineNode(node)
+	}
+}
+
+func (r *htmlRenderer) inlineNode(node *model.Node) {
+	switch node.Type.Name {
+	case "text":
+		r.text(*node.Text, markStyle(node.Marks))
+	case "hardBreak":
+		r.buf.WriteString("<br>")
+	case "status":
+		fmt.Fprintf(&r.buf, "<span class=\"status\" style=\"background-color: %s\">%s</span>",
+			statusColor(node), html.EscapeString(statusText(node)))
+	case "date":
+		date := dateText(node)
+		fmt.Fprintf(&r.buf, "<time datetime=\"%s\">%s</time>", date, date)
+	case "media", "mediaSingle":
+		r.block(node)
+	default:
+		r.buf.WriteString(html.EscapeString(node.TextContent()))
+	}
+}
+
+func (r *htmlRenderer) text(text string, style inlineStyle) {
+	var closing []string
+	open := func(tag, attrs string) {
+		r.buf.WriteString("<" + tag + attrs + ">")
+		closing = append(closing, "</"+tag+">")
+	}
+	if style.Link != "" {
+		open("a", fmt.Sprintf(" href=\"%s\"", html.EscapeString(style.Link)))
+	}
+	if style.Bold {
+		open("strong", "")
+	}
+	if style.Italic {
+		open("em", "")
+	}
+	if style.Underline {
+		open("u", "")
+	}
+	if style.Strike {
+		open("s", "")
+	}
+	if style.Code {
+		open("code", "")
+	}
+	if style.Script != "" {
+		open(style.Script, "")
+	}
+	if style.Color != "" {
+		open("span", fmt.Sprintf(" style=\"color: %s\"", style.Color))
+	}
+	r.buf.WriteString(html.EscapeString(text))
+	for i := len(closing) - 1; i >= 0; i-- {
+		r.buf.WriteString(closing[i])
+	}
+}
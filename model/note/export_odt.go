@@ -0,0 +1,520 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const (
+	// odtCmPerPixel is the number of centimeters for a pixel at 96 dpi.
+	odtCmPerPixel = 2.54 / 96
+	// odtMaxImageWidth is the width of the text on an A4 page with margins of
+	// 2cm.
+	odtMaxImageWidth = 17.0
+	// odtIndent is the indentation for a level of lists or quotes, in cm.
+	odtIndent = 0.635
+	// odtMime is the mime type of an OpenDocument text.
+	odtMime = "application/vnd.oasis.opendocument.text"
+)
+
+const odtNamespaces = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" ` +
+	`xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" ` +
+	`xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" ` +
+	`xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" ` +
+	`xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" ` +
+	`xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" ` +
+	`xmlns:xlink="http://www.w3.org/1999/xlink" ` +
+	`xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0" ` +
+	`office:version="1.3"`
+
+// odtHeadingSizes are the font sizes of the headings, in points.
+var odtHeadingSizes = []string{"20pt", "16pt", "14pt", "13pt", "12pt", "11pt"}
+
+// odtParagraph is the properties of an automatic paragraph style.
+type odtParagraph struct {
+	parent     string
+	align      string
+	marginLeft float64
+	background string
+	borderLeft string
+	mono       bool
+}
+
+type odtRenderer struct {
+	body     strings.Builder
+	images   map[string]*exportImage
+	pictures map[string]string // image id -> path in the archive
+	files    map[string][]byte
+	mimes    map[string]string
+	styles   map[string]string // key -> style name
+	defs     []string          // definitions of the automatic styles
+	tables   int
+	frames   int
+}
+
+// renderODT exports the content of a note to an OpenDocument text.
+func renderODT(title string, content *model.Node, images map[string]*exportImage) ([]byte, error) {
+	r := &odtRenderer{
+		images:   images,
+		pictures: make(map[string]string),
+		files:    make(map[string][]byte),
+		mimes:    make(map[string]string),
+		styles:   make(map[string]string),
+	}
+	r.defs = append(r.defs, odtListStyle("L1", false), odtListStyle("L2", true))
+
+	if title != "" {
+		r.body.WriteString(`<text:p text:style-name="Title">`)
+		r.text(title)
+		r.body.WriteString(`</text:p>`)
+	}
+	r.blocks(content, odtParagraph{parent: "Standard"})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// The mimetype must be the first file, and it must not be compressed
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(odtMime)); err != nil {
+		return nil, err
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"content.xml", r.content()},
+		{"styles.xml", odtStyles()},
+		{"META-INF/manifest.xml", r.manifest()},
+	}
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range r.pictureNames() {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(r.files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func odtListStyle(name string, numbered bool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<text:list-style style:name="%s">`, name)
+	bullets := []string{"•", "◦", "▪"}
+	for lvl := 1; lvl <= 10; lvl++ {
+		props := fmt.Sprintf(`<style:list-level-properties text:list-level-position-and-space-mode="label-alignment">`+
+			`<style:list-level-label-alignment text:label-followed-by="listtab" text:list-tab-stop-position="%.3fcm" `+
+			`fo:text-indent="-%.3fcm" fo:margin-left="%.3fcm"/></style:list-level-properties>`,
+			odtIndent*float64(lvl+1), odtIndent, odtIndent*float64(lvl+1))
+		if numbered {
+			fmt.Fprintf(&sb, `<text:list-level-style-number text:level="%d" style:num-suffix="." style:num-format="1">%s</text:list-level-style-number>`,
+				lvl, props)
+		} else {
+			fmt.Fprintf(&sb, `<text:list-level-style-bullet text:level="%d" text:bullet-char="%s">%s</text:list-level-style-bullet>`,
+				lvl, bullets[(lvl-1)%len(bullets)], props)
+		}
+	}
+	sb.WriteString(`</text:list-style>`)
+	return sb.String()
+}
+
+func odtStyles() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<office:document-styles ` + odtNamespaces + `><office:styles>`)
+	sb.WriteString(`<style:default-style style:family="paragraph"><style:paragraph-properties fo:margin-bottom="0.2cm"/>` +
+		`<style:text-properties fo:font-family="Carlito, Calibri, sans-serif" fo:font-size="11pt"/></style:default-style>`)
+	sb.WriteString(`<style:style style:name="Standard" style:family="paragraph" style:class="text"/>`)
+	sb.WriteString(`<style:style style:name="Title" style:family="paragraph" style:parent-style-name="Standard" style:class="chapter">` +
+		`<style:paragraph-properties fo:margin-bottom="0.4cm"/><style:text-properties fo:font-size="24pt" fo:font-weight="bold"/></style:style>`)
+	for i, size := range odtHeadingSizes {
+		fmt.Fprintf(&sb, `<style:style style:name="Heading_20_%d" style:display-name="Heading %d" style:family="paragraph" `+
+			`style:parent-style-name="Standard" style:default-outline-level="%d" style:class="text">`+
+			`<style:paragraph-properties fo:margin-top="0.4cm" fo:margin-bottom="0.2cm" fo:keep-with-next="always"/>`+
+			`<style:text-properties fo:font-size="%s" fo:font-weight="bold"/></style:style>`, i+1, i+1, i+1, size)
+	}
+	sb.WriteString(`</office:styles></office:document-styles>`)
+	return sb.String()
+}
+
+func (r *odtRenderer) content() string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<office:document-content ` + odtNamespaces + `><office:automatic-styles>` +
+		strings.Join(r.defs, "") + `</office:automatic-styles><office:body><office:text>` +
+		r.body.String() + `</office:text></office:body></office:document-content>`
+}
+
+func (r *odtRenderer) pictureNames() []string {
+	names := make([]string, 0, len(r.files))
+	for name := range r.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *odtRenderer) manifest() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.3">`)
+	fmt.Fprintf(&sb, `<manifest:file-entry manifest:full-path="/" manifest:version="1.3" manifest:media-type="%s"/>`,
+		odtMime)
+	sb.WriteString(`<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>`)
+	sb.WriteString(`<manifest:file-entry manifest:full-path="styles.xml" manifest:media-type="text/xml"/>`)
+	for _, name := range r.pictureNames() {
+		fmt.Fprintf(&sb, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`,
+			name, xmlEscape(r.mimes[name]))
+	}
+	sb.WriteString(`</manifest:manifest>`)
+	return sb.String()
+}
+
+// style returns the name of an automatic style, and registers it if needed.
+// The first %s in the definition is replaced by the name of the style.
+func (r *odtRenderer) style(key, prefix, definition string) string {
+	if name, ok := r.styles[key]; ok {
+		return name
+	}
+	name := fmt.Sprintf("%s%d", prefix, len(r.styles)+1)
+	r.styles[key] = name
+	r.defs = append(r.defs, strings.Replace(definition, "%s", name, 1))
+	return name
+}
+
+func (r *odtRenderer) paragraphStyle(p odtParagraph) string {
+	if p == (odtParagraph{parent: p.parent}) {
+		return p.parent
+	}
+	var props strings.Builder
+	switch p.align {
+	case "center":
+		props.WriteString(` fo:text-align="center"`)
+	case "end":
+		props.WriteString(` fo:text-align="end"`)
+	}
+	if p.marginLeft > 0 {
+		fmt.Fprintf(&props, ` fo:margin-left="%.3fcm"`, p.marginLeft)
+	}
+	if p.background != "" {
+		fmt.Fprintf(&props, ` fo:background-color="%s"`, strings.ToLower(p.background))
+	}
+	if p.borderLeft != "" {
+		fmt.Fprintf(&props, ` fo:border-left="0.1cm solid %s" fo:padding-left="0.2cm"`, strings.ToLower(p.borderLeft))
+	}
+	text := ""
+	if p.mono {
+		text = `<style:text-properties fo:font-family="'Courier New', monospace" fo:font-size="10pt"/>`
+	}
+	definition := `<style:style style:name="%s" style:family="paragraph" style:parent-style-name="` + p.parent + `">` +
+		`<style:paragraph-properties` + props.String() + `/>` + text + `</style:style>`
+	return r.style(fmt.Sprintf("P%+v", p), "P", definition)
+}
+
+func (r *odtRenderer) textStyle(s inlineStyle) string {
+	s.Link = ""
+	if s == (inlineStyle{}) {
+		return ""
+	}
+	var props strings.Builder
+	if s.Bold {
+		props.WriteString(` fo:font-weight="bold"`)
+	}
+	if s.Italic {
+		props.WriteString(` fo:font-style="italic"`)
+	}
+	if s.Underline {
+		props.WriteString(` style:text-underline-style="solid" style:text-underline-width="auto" style:text-underline-color="font-color"`)
+	}
+	if s.Strike {
+		props.WriteString(` style:text-line-through-style="solid"`)
+	}
+	if s.Code {
+		props.WriteString(` fo:font-family="'Courier New', monospace"`)
+	}
+	switch s.Script {
+	case "sub":
+		props.WriteString(` style:text-position="sub 58%"`)
+	case "sup":
+		props.WriteString(` style:text-position="super 58%"`)
+	}
+	if s.Color != "" {
+		fmt.Fprintf(&props, ` fo:color="#%s"`, strings.ToLower(hexColor(s.Color)))
+	}
+	definition := `<style:style style:name="%s" style:family="text"><style:text-properties` + props.String() + `/></style:style>`
+	return r.style(fmt.Sprintf("T%+v", s), "T", definition)
+}
+
+func (r *odtRenderer) blocks(parent *model.Node, p odtParagraph) {
+	for _, node := range children(parent) {
+		r.block(node, p)
+	}
+}
+
+func (r *odtRenderer) block(node *model.Node, p odtParagraph) {
+	switch node.Type.Name {
+	case "paragraph":
+		r.paragraph(node, p, "text:p", "")
+	case "heading":
+		level := min(max(intAttr(node.Attrs["level"], 1), 1), 6)
+		p.parent = fmt.Sprintf("Heading_20_%d", level)
+		r.paragraph(node, p, "text:h", fmt.Sprintf(` text:outline-level="%d"`, level))
+	case "bulletList", "orderedList":
+		style := "L1"
+		if node.Type.Name == "orderedList" {
+			style = "L2"
+		}
+		fmt.Fprintf(&r.body, `<text:list text:style-name="%s">`, style)
+		for _, item := range children(node) {
+			r.body.WriteString(`<text:list-item>`)
+			r.blocks(item, p)
+			r.body.WriteString(`</text:list-item>`)
+		}
+		r.body.WriteString(`</text:list>`)
+	case "taskList", "decisionList":
+		p.marginLeft += odtIndent
+		r.blocks(node, p)
+	case "taskItem":
+		prefix := "☐ "
+		if node.Attrs["state"] == "DONE" {
+			prefix = "☑ "
+		}
+		r.paragraphWithPrefix(node, p, prefix)
+	case "decisionItem":
+		r.paragraphWithPrefix(node, p, "✍ ")
+	case "blockquote":
+		p.marginLeft += odtIndent
+		p.borderLeft = "#C1C7D0"
+		r.blocks(node, p)
+	case "panel":
+		p.background, p.borderLeft = panelColor(node)
+		r.blocks(node, p)
+	case "codeBlock":
+		p.mono = true
+		p.background = "#F4F5F7"
+		fmt.Fprintf(&r.body, `<text:p text:style-name="%s">`, r.paragraphStyle(p))
+		r.text(node.TextContent())
+		r.body.WriteString(`</text:p>`)
+	case "rule":
+		name := r.style("rule", "P", `<style:style style:name="%s" style:family="paragraph" style:parent-style-name="Standard">`+
+			`<style:paragraph-properties fo:border-bottom="0.5pt solid #c1c7d0" fo:padding-bottom="0.1cm"/></style:style>`)
+		fmt.Fprintf(&r.body, `<text:p text:style-name="%s"/>`, name)
+	case "table":
+		r.table(node, p)
+	case "mediaSingle":
+		r.blocks(node, p)
+	case "media":
+		p.align = "center"
+		fmt.Fprintf(&r.body, `<text:p text:style-name="%s">`, r.paragraphStyle(p))
+		r.media(node)
+		r.body.WriteString(`</text:p>`)
+	default:
+		if node.IsBlock() && node.Type.InlineContent {
+			r.paragraph(node, p, "text:p", "")
+		} else if node.IsBlock() {
+			r.blocks(node, p)
+		}
+	}
+}
+
+func (r *odtRenderer) paragraph(node *model.Node, p odtParagraph, tag, attrs string) {
+	p.align = blockAlignment(node)
+	if level := blockIndentation(node); level > 0 {
+		p.marginLeft += 2 * odtIndent * float64(level)
+	}
+	fmt.Fprintf(&r.body, `<%s text:style-name="%s"%s>`, tag, r.paragraphStyle(p), attrs)
+	r.inline(node)
+	fmt.Fprintf(&r.body, `</%s>`, tag)
+}
+
+func (r *odtRenderer) paragraphWithPrefix(node *model.Node, p odtParagraph, prefix string) {
+	fmt.Fprintf(&r.body, `<text:p text:style-name="%s">`, r.paragraphStyle(p))
+	r.text(prefix)
+	r.inline(node)
+	r.body.WriteString(`</text:p>`)
+}
+
+func (r *odtRenderer) inline(parent *model.Node) {
+	for _, node := range children(parent) {
+		switch node.Type.Name {
+		case "text":
+			style := markStyle(node.Marks)
+			if style.Link != "" {
+				fmt.Fprintf(&r.body, `<text:a xlink:type="simple" xlink:href="%s">`, xmlEscape(style.Link))
+			}
+			if name := r.textStyle(style); name != "" {
+				fmt.Fprintf(&r.body, `<text:span text:style-name="%s">`, name)
+				r.text(*node.Text)
+				r.body.WriteString(`</text:span>`)
+			} else {
+				r.text(*node.Text)
+			}
+			if style.Link != "" {
+				r.body.WriteString(`</text:a>`)
+			}
+		case "hardBreak":
+			r.body.WriteString(`<text:line-break/>`)
+		case "status":
+			background := statusColor(node)
+			name := r.style("status"+background, "T", `<style:style style:name="%s" style:family="text">`+
+				`<style:text-properties fo:font-weight="bold" fo:font-size="9pt" fo:background-color="`+
+				strings.ToLower(background)+`"/></style:style>`)
+			fmt.Fprintf(&r.body, `<text:span text:style-name="%s">`, name)
+			r.text(statusText(node))
+			r.body.WriteString(`</text:span>`)
+		case "date":
+			r.text(dateText(node))
+		case "media":
+			r.media(node)
+		case "mediaSingle":
+			for _, child := range children(node) {
+				r.media(child)
+			}
+		default:
+			r.text(node.TextContent())
+		}
+	}
+}
+
+// text writes a text, with the spaces, tabs and new lines encoded as
+// elements, as they are not preserved in the text nodes of ODF.
+func (r *odtRenderer) text(text string) {
+	spaces := 0
+	flush := func() {
+		switch {
+		case spaces == 1:
+			r.body.WriteString(" ")
+		case spaces > 1:
+			fmt.Fprintf(&r.body, ` <text:s text:c="%d"/>`, spaces-1)
+		}
+		spaces = 0
+	}
+	var word strings.Builder
+	writeWord := func() {
+		if word.Len() > 0 {
+			r.body.WriteString(xmlEscape(word.String()))
+			word.Reset()
+		}
+	}
+	for _, c := range text {
+		switch c {
+		case ' ':
+			writeWord()
+			spaces++
+		case '\t':
+			writeWord()
+			flush()
+			r.body.WriteString(`<text:tab/>`)
+		case '\n':
+			writeWord()
+			flush()
+			r.body.WriteString(`<text:line-break/>`)
+		default:
+			flush()
+			word.WriteRune(c)
+		}
+	}
+	writeWord()
+	flush()
+}
+
+func (r *odtRenderer) media(node *model.Node) {
+	url, _ := node.Attrs["url"].(string)
+	img, ok := r.images[url]
+	if !ok {
+		alt, _ := node.Attrs["alt"].(string)
+		r.text(alt)
+		return
+	}
+	name, ok := r.pictures[url]
+	if !ok {
+		name = fmt.Sprintf("Pictures/image%d.%s", len(r.pictures)+1, imageExtension(img.Mime))
+		r.pictures[url] = name
+		r.files[name] = img.Data
+		r.mimes[name] = img.Mime
+	}
+	r.frames++
+	width, height := scaleImage(img, odtCmPerPixel, odtMaxImageWidth)
+	fmt.Fprintf(&r.body, `<draw:frame draw:name="Image%d" text:anchor-type="as-char" svg:width="%.3fcm" svg:height="%.3fcm">`+
+		`<draw:image xlink:href="%s" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad"/></draw:frame>`,
+		r.frames, width, height, name)
+}
+
+func (r *odtRenderer) table(node *model.Node, p odtParagraph) {
+	grid := newTableGrid(node)
+	if grid.Cols == 0 {
+		return
+	}
+	r.tables++
+	tableStyle := r.style(fmt.Sprintf("table%.3f", p.marginLeft), "Tbl",
+		`<style:style style:name="%s" style:family="table"><style:table-properties `+
+			fmt.Sprintf(`style:width="%.3fcm" fo:margin-left="%.3fcm"`, odtMaxImageWidth-p.marginLeft, p.marginLeft)+
+			` table:align="left"/></style:style>`)
+	fmt.Fprintf(&r.body, `<table:table table:name="Table%d" table:style-name="%s">`, r.tables, tableStyle)
+	fmt.Fprintf(&r.body, `<table:table-column table:number-columns-repeated="%d"/>`, grid.Cols)
+	for row := 0; row < grid.Rows; row++ {
+		r.body.WriteString(`<table:table-row>`)
+		for col := 0; col < grid.Cols; col++ {
+			cell := grid.Cells[row][col]
+			if cell == nil {
+				fmt.Fprintf(&r.body, `<table:table-cell table:style-name="%s"><text:p/></table:table-cell>`, r.cellStyle(""))
+				continue
+			}
+			if !grid.IsOrigin(row, col) {
+				r.body.WriteString(`<table:covered-table-cell/>`)
+				continue
+			}
+			background, _ := cell.Node.Attrs["background"].(string)
+			if !isHexColor(background) {
+				background = ""
+				if cell.Header {
+					background = "#F4F5F7"
+				}
+			}
+			fmt.Fprintf(&r.body, `<table:table-cell table:style-name="%s" office:value-type="string"`, r.cellStyle(background))
+			if cell.Colspan > 1 {
+				fmt.Fprintf(&r.body, ` table:number-columns-spanned="%d"`, cell.Colspan)
+			}
+			if cell.Rowspan > 1 {
+				fmt.Fprintf(&r.body, ` table:number-rows-spanned="%d"`, cell.Rowspan)
+			}
+			r.body.WriteString(`>`)
+			before := r.body.Len()
+			r.blocks(cell.Node, odtParagraph{parent: "Standard"})
+			if r.body.Len() == before {
+				r.body.WriteString(`<text:p/>`)
+			}
+			r.body.WriteString(`</table:table-cell>`)
+		}
+		r.body.WriteString(`</table:table-row>`)
+	}
+	r.body.WriteString(`</table:table>`)
+}
+
+func (r *odtRenderer) cellStyle(background string) string {
+	props := `fo:border="0.5pt solid #c1c7d0" fo:padding="0.1cm"`
+	if background != "" {
+		props += fmt.Sprintf(` fo:background-color="#%s"`, strings.ToLower(hexColor(background)))
+	}
+	return r.style("cell"+background, "C", `<style:style style:name="%s" style:family="table-cell">`+
+		`<style:table-cell-properties `+props+`/></style:style>`)
+}
@@ -0,0 +1,913 @@
+package note
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const (
+	// The dimensions of an A4 page, in points.
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+	// pdfFontSize is the size of the font for the paragraphs, in points.
+	pdfFontSize = 11.0
+	// pdfLineHeight is the ratio between the height of a line and the size
+	// of its font.
+	pdfLineHeight = 1.35
+	// pdfIndent is the indentation for lists and quotes, in points.
+	pdfIndent = 18.0
+	// pdfPointPerPixel is the number of points for a pixel at 96 dpi.
+	pdfPointPerPixel = 0.75
+)
+
+// The fonts of the text, see pdfFontFiles.
+const (
+	pdfRegular = iota
+	pdfBold
+	pdfItalic
+	pdfBoldItalic
+	pdfMono
+)
+
+// pdfHeadingSizes are the font sizes of the headings, in points.
+var pdfHeadingSizes = []float64{20, 16, 14, 13, 12, 11}
+
+// pdfLine is a block with a fixed height, that can be drawn on a page. The
+// pages are filled with lines, from top to bottom.
+type pdfLine struct {
+	height   float64
+	baseline float64 // the distance from the top to the baseline of the text
+	draw     func(p *bytes.Buffer, x, top float64)
+}
+
+// pdfRun is a text with a style.
+type pdfRun struct {
+	text       string
+	font       int
+	size       float64
+	color      string
+	background string
+	underline  bool
+	strike     bool
+	script     string
+}
+
+func (run pdfRun) sameStyle(other pdfRun) bool {
+	other.text = run.text
+	return run == other
+}
+
+func (run pdfRun) width() float64 {
+	return pdfTextWidth(run.text, run.font, run.fontSize())
+}
+
+func (run pdfRun) fontSize() float64 {
+	if run.script != "" {
+		return run.size * 0.7
+	}
+	return run.size
+}
+
+// pdfImage is an image XObject.
+type pdfImage struct {
+	name       string
+	width      int
+	height     int
+	colorSpace string
+	filter     string
+	data       []byte
+}
+
+type pdfRenderer struct {
+	images   map[string]*exportImage
+	xobjects map[string]*pdfImage // image id -> XObject, nil if not supported
+	order    []*pdfImage
+	pages    []*bytes.Buffer
+	bold     bool // used for the header cells of the tables
+}
+
+// renderPDF exports the content of a note to a PDF document. It embeds the Go
+// fonts, and the characters that are not in these fonts are replaced by a
+// question mark.
+func renderPDF(title string, content *model.Node, images map[string]*exportImage) ([]byte, error) {
+	if err := loadPDFFonts(); err != nil {
+		return nil, err
+	}
+	r := &pdfRenderer{
+		images:   images,
+		xobjects: make(map[string]*pdfImage),
+	}
+	width := pdfPageWidth - 2*pdfMargin
+	var lines []pdfLine
+	if title != "" {
+		run := pdfRun{text: pdfClean(title), font: pdfBold, size: 24}
+		lines = append(lines, r.wrap([]pdfRun{run}, width, "")...)
+		lines = append(lines, pdfLine{height: 12})
+	}
+	lines = append(lines, r.blocks(content, width)...)
+	r.paginate(lines)
+	return r.document(title)
+}
+
+// paginate draws the lines on the pages.
+func (r *pdfRenderer) paginate(lines []pdfLine) {
+	top := pdfPageHeight - pdfMargin
+	page := r.newPage()
+	y := top
+	for _, line := range lines {
+		if y-line.height < pdfMargin && y < top {
+			page = r.newPage()
+			y = top
+		}
+		if line.draw == nil && y == top {
+			continue // No spacing at the top of a page
+		}
+		if line.draw != nil {
+			line.draw(page, pdfMargin, y)
+		}
+		y -= line.height
+	}
+}
+
+func (r *pdfRenderer) newPage() *bytes.Buffer {
+	page := &bytes.Buffer{}
+	r.pages = append(r.pages, page)
+	return page
+}
+
+// document serializes the pages and the resources to a PDF file.
+func (r *pdfRenderer) document(title string) ([]byte, error) {
+	var buf bytes.Buffer
+	// Only the fonts used in the pages are embedded. The texts are written
+	// as hexadecimal strings, so the font operators can't be confused with
+	// the content of the note.
+	var fonts []int
+	for i := range pdfFonts {
+		operator := []byte(fmt.Sprintf("BT /F%d ", i+1))
+		for _, page := range r.pages {
+			if bytes.Contains(page.Bytes(), operator) {
+				fonts = append(fonts, i)
+				break
+			}
+		}
+	}
+	// Each font has 5 objects: the Type0 font, the CIDFont, the font
+	// descriptor, the TrueType file, and the ToUnicode map.
+	firstImage := 4 + 5*len(fonts)
+	firstPage := firstImage + len(r.order)
+	infoID := firstPage + 2*len(r.pages)
+	offsets := make([]int, infoID+1)
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	begin := func(id int) {
+		offsets[id] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", id)
+	}
+	end := func() { buf.WriteString("\nendobj\n") }
+	stream := func(dict string, data []byte) {
+		fmt.Fprintf(&buf, "<< %s /Length %d >>\nstream\n", dict, len(data))
+		buf.Write(data)
+		buf.WriteString("\nendstream")
+	}
+
+	begin(1)
+	buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>")
+	end()
+
+	begin(2)
+	buf.WriteString("<< /Type /Pages /Kids [")
+	for i := range r.pages {
+		fmt.Fprintf(&buf, " %d 0 R", firstPage+2*i)
+	}
+	fmt.Fprintf(&buf, " ] /Count %d >>", len(r.pages))
+	end()
+
+	begin(3)
+	buf.WriteString("<< /Font <<")
+	for i, font := range fonts {
+		fmt.Fprintf(&buf, " /F%d %d 0 R", font+1, 4+5*i)
+	}
+	buf.WriteString(" >>")
+	if len(r.order) > 0 {
+		buf.WriteString(" /XObject <<")
+		for i, img := range r.order {
+			fmt.Fprintf(&buf, " /%s %d 0 R", img.name, firstImage+i)
+		}
+		buf.WriteString(" >>")
+	}
+	buf.WriteString(" >>")
+	end()
+
+	for i, font := range fonts {
+		f := pdfFonts[font]
+		id := 4 + 5*i
+		begin(id)
+		fmt.Fprintf(&buf, "<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			f.name, id+1, id+4)
+		end()
+		begin(id + 1)
+		fmt.Fprintf(&buf, "<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /W %s >>",
+			f.name, id+2, f.widthsArray())
+		end()
+		begin(id + 2)
+		fmt.Fprintf(&buf, "<< %s /FontFile2 %d 0 R >>", f.descriptor, id+3)
+		end()
+		begin(id + 3)
+		stream(fmt.Sprintf("/Filter /FlateDecode /Length1 %d", f.fileLength), f.file)
+		end()
+		begin(id + 4)
+		stream("/Filter /FlateDecode", f.toUnicode)
+		end()
+	}
+
+	for i, img := range r.order {
+		begin(firstImage + i)
+		stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s",
+			img.width, img.height, img.colorSpace, img.filter), img.data)
+		end()
+	}
+
+	for i, page := range r.pages {
+		begin(firstPage + 2*i)
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources 3 0 R /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1)
+		end()
+		begin(firstPage + 2*i + 1)
+		data, err := pdfCompress(page.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		stream("/Filter /FlateDecode", data)
+		end()
+	}
+
+	begin(infoID)
+	fmt.Fprintf(&buf, "<< /Title %s /Producer (Cozy) >>", pdfUTF16(title))
+	end()
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+	for _, offset := range offsets[1:] {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets), infoID, xref)
+	return buf.Bytes(), nil
+}
+
+func (r *pdfRenderer) blocks(parent *model.Node, width float64) []pdfLine {
+	var lines []pdfLine
+	for _, node := range children(parent) {
+		lines = append(lines, r.block(node, width)...)
+	}
+	return lines
+}
+
+func (r *pdfRenderer) block(node *model.Node, width float64) []pdfLine {
+	switch node.Type.Name {
+	case "paragraph":
+		return r.paragraph(node, pdfRun{size: pdfFontSize}, width, 6)
+	case "heading":
+		level := min(max(intAttr(node.Attrs["level"], 1), 1), 6)
+		base := pdfRun{font: pdfBold, size: pdfHeadingSizes[level-1]}
+		lines := []pdfLine{{height: 8}}
+		return append(lines, r.paragraph(node, base, width, 4)...)
+	case "bulletList", "orderedList":
+		var lines []pdfLine
+		for i, item := range children(node) {
+			marker := "•"
+			if node.Type.Name == "orderedList" {
+				marker = fmt.Sprintf("%d.", i+1)
+			}
+			itemLines := r.blocks(item, width-pdfIndent)
+			itemLines = pdfWithMarker(itemLines, func(p *bytes.Buffer, x, baseline float64) {
+				run := pdfRun{text: marker, size: pdfFontSize}
+				pdfDrawText(p, run, x+pdfIndent-run.width()-4, baseline)
+			})
+			lines = append(lines, pdfShift(itemLines, pdfIndent)...)
+		}
+		return lines
+	case "taskList", "decisionList":
+		var lines []pdfLine
+		for _, child := range children(node) {
+			if child.Type.Name == "taskList" {
+				lines = append(lines, pdfShift(r.block(child, width-pdfIndent), pdfIndent)...)
+			} else {
+				lines = append(lines, r.block(child, width)...)
+			}
+		}
+		return append(lines, pdfLine{height: 4})
+	case "taskItem":
+		done := node.Attrs["state"] == "DONE"
+		lines := r.wrap(r.inlineRuns(node, pdfRun{size: pdfFontSize}), width-pdfIndent, "")
+		lines = pdfWithMarker(lines, func(p *bytes.Buffer, x, baseline float64) {
+			fmt.Fprintf(p, "0.4 0.45 0.5 RG 0.8 w %.2f %.2f 8 8 re S\n", x+2, baseline-1)
+			if done {
+				fmt.Fprintf(p, "0 0.53 0.35 RG 1.2 w %.2f %.2f m %.2f %.2f l %.2f %.2f l S\n",
+					x+3.5, baseline+3, x+5.5, baseline+1, x+9, baseline+6)
+			}
+		})
+		return pdfShift(lines, pdfIndent)
+	case "decisionItem":
+		lines := r.wrap(r.inlineRuns(node, pdfRun{size: pdfFontSize}), width-pdfIndent, "")
+		lines = pdfWithMarker(lines, func(p *bytes.Buffer, x, baseline float64) {
+			pdfDrawText(p, pdfRun{text: "»", font: pdfBold, size: pdfFontSize, color: "#00875A"}, x+3, baseline)
+		})
+		return pdfShift(lines, pdfIndent)
+	case "blockquote":
+		lines := r.blocks(node, width-14)
+		lines = pdfDecorate(lines, func(p *bytes.Buffer, x, top, height float64) {
+			pdfFill(p, "#C1C7D0", x+2, top-height, 2, height)
+		})
+		return pdfShift(lines, 14)
+	case "panel":
+		background, border := panelColor(node)
+		lines := []pdfLine{{height: 6}}
+		lines = append(lines, r.blocks(node, width-24)...)
+		lines = append(lines, pdfLine{height: 6})
+		lines = pdfShift(lines, 14)
+		lines = pdfDecorate(lines, func(p *bytes.Buffer, x, top, height float64) {
+			pdfFill(p, background, x, top-height, width, height)
+			pdfFill(p, border, x, top-height, 3, height)
+		})
+		return append(lines, pdfLine{height: 6})
+	case "codeBlock":
+		return r.codeBlock(node, width)
+	case "rule":
+		return []pdfLine{{height: 14, draw: func(p *bytes.Buffer, x, top float64) {
+			pdfFill(p, "#C1C7D0", x, top-7, width, 0.8)
+		}}}
+	case "table":
+		return r.table(node, width)
+	case "mediaSingle":
+		return r.blocks(node, width)
+	case "media":
+		return r.media(node, width)
+	default:
+		if node.IsBlock() && node.Type.InlineContent {
+			return r.paragraph(node, pdfRun{size: pdfFontSize}, width, 6)
+		} else if node.IsBlock() {
+			return r.blocks(node, width)
+		}
+	}
+	return nil
+}
+
+func (r *pdfRenderer) paragraph(node *model.Node, base pdfRun, width, spacing float64) []pdfLine {
+	indent := pdfIndent * float64(blockIndentation(node))
+	runs := r.inlineRuns(node, base)
+	lines := pdfShift(r.wrap(runs, width-indent, blockAlignment(node)), indent)
+	return append(lines, pdfLine{height: spacing})
+}
+
+func (r *pdfRenderer) inlineRuns(parent *model.Node, base pdfRun) []pdfRun {
+	if r.bold {
+		base.font = pdfBold
+	}
+	var runs []pdfRun
+	for _, node := range children(parent) {
+		run := base
+		switch node.Type.Name {
+		case "text":
+			style := markStyle(node.Marks)
+			bold := style.Bold || base.font == pdfBold || base.font == pdfBoldItalic
+			italic := style.Italic || base.font == pdfItalic || base.font == pdfBoldItalic
+			switch {
+			case style.Code:
+				run.font = pdfMono
+			case bold && italic:
+				run.font = pdfBoldItalic
+			case bold:
+				run.font = pdfBold
+			case italic:
+				run.font = pdfItalic
+			}
+			run.color = style.Color
+			if style.Link != "" {
+				run.color = "#0052CC"
+				run.underline = true
+			}
+			run.underline = run.underline || style.Underline
+			run.strike = style.Strike
+			run.script = style.Script
+			run.text = pdfClean(*node.Text)
+		case "hardBreak":
+			run.text = "\n"
+		case "status":
+			run.font = pdfBold
+			run.size = base.size * 0.8
+			run.background = statusColor(node)
+			run.text = pdfClean(" " + statusText(node) + " ")
+		case "date":
+			run.text = pdfClean(dateText(node))
+		case "media", "mediaSingle":
+			continue
+		default:
+			run.text = pdfClean(node.TextContent())
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// wrap splits the runs in lines that fit in the given width.
+func (r *pdfRenderer) wrap(runs []pdfRun, width float64, align string) []pdfLine {
+	var tokens []pdfRun
+	for _, run := range runs {
+		tokens = append(tokens, pdfTokenize(run)...)
+	}
+
+	var lines []pdfLine
+	var current []pdfRun
+	lineWidth := 0.0
+	size := pdfFontSize
+	if len(runs) > 0 {
+		size = runs[0].size
+	}
+	flush := func() {
+		for len(current) > 0 && current[len(current)-1].text == " " {
+			lineWidth -= current[len(current)-1].width()
+			current = current[:len(current)-1]
+		}
+		lines = append(lines, pdfTextLine(current, lineWidth, width, size, align))
+		current = nil
+		lineWidth = 0
+	}
+
+	for _, token := range tokens {
+		switch token.text {
+		case "\n":
+			flush()
+			continue
+		case " ":
+			if len(current) == 0 {
+				continue
+			}
+		}
+		w := token.width()
+		if lineWidth+w > width && len(current) > 0 {
+			flush()
+			if token.text == " " {
+				continue
+			}
+		}
+		// Split the words that are too long for a line
+		for w > width && utf8.RuneCountInString(token.text) > 1 {
+			chars := []rune(token.text)
+			n := len(chars) - 1
+			for n > 1 && pdfTextWidth(string(chars[:n]), token.font, token.fontSize()) > width {
+				n--
+			}
+			part := token
+			part.text = string(chars[:n])
+			current = append(current, part)
+			lineWidth = part.width()
+			flush()
+			token.text = string(chars[n:])
+			w = token.width()
+		}
+		current = append(current, token)
+		lineWidth += w
+	}
+	if len(current) > 0 || len(lines) == 0 {
+		flush()
+	}
+	return lines
+}
+
+// pdfTokenize splits a run in words, spaces and new lines.
+func pdfTokenize(run pdfRun) []pdfRun {
+	var tokens []pdfRun
+	start := 0
+	text := run.text
+	for i := 0; i < len(text); i++ {
+		if text[i] != ' ' && text[i] != '\n' {
+			continue
+		}
+		if i > start {
+			word := run
+			word.text = text[start:i]
+			tokens = append(tokens, word)
+		}
+		sep := run
+		sep.text = text[i : i+1]
+		tokens = append(tokens, sep)
+		start = i + 1
+	}
+	if start < len(text) {
+		word := run
+		word.text = text[start:]
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// pdfTextLine returns a line that draws the given runs.
+func pdfTextLine(runs []pdfRun, lineWidth, width, defaultSize float64, align string) pdfLine {
+	size := 0.0
+	var merged []pdfRun
+	for _, run := range runs {
+		size = math.Max(size, run.size)
+		if n := len(merged); n > 0 && merged[n-1].sameStyle(run) {
+			merged[n-1].text += run.text
+		} else {
+			merged = append(merged, run)
+		}
+	}
+	if size == 0 {
+		size = defaultSize
+	}
+	height := size * pdfLineHeight
+	baseline := (height-size)/2 + size*0.8
+	offset := 0.0
+	switch align {
+	case "center":
+		offset = (width - lineWidth) / 2
+	case "end":
+		offset = width - lineWidth
+	}
+	return pdfLine{
+		height:   height,
+		baseline: baseline,
+		draw: func(p *bytes.Buffer, x, top float64) {
+			x += offset
+			for _, run := range merged {
+				pdfDrawText(p, run, x, top-baseline)
+				x += run.width()
+			}
+		},
+	}
+}
+
+// pdfDrawText draws a run, with its baseline at the given position.
+func pdfDrawText(p *bytes.Buffer, run pdfRun, x, baseline float64) {
+	size := run.fontSize()
+	width := run.width()
+	switch run.script {
+	case "sub":
+		baseline -= run.size * 0.15
+	case "sup":
+		baseline += run.size * 0.35
+	}
+	if run.background != "" {
+		pdfFill(p, run.background, x, baseline-size*0.3, width, size*1.25)
+	}
+	color := run.color
+	if color == "" {
+		color = "#172B4D"
+	}
+	fmt.Fprintf(p, "BT /F%d %.2f Tf %s rg %.2f %.2f Td %s Tj ET\n",
+		run.font+1, size, pdfColor(color), x, baseline, pdfFonts[run.font].encode(run.text))
+	if run.underline {
+		pdfFill(p, color, x, baseline-size*0.15, width, size*0.06)
+	}
+	if run.strike {
+		pdfFill(p, color, x, baseline+size*0.28, width, size*0.06)
+	}
+}
+
+func (r *pdfRenderer) codeBlock(node *model.Node, width float64) []pdfLine {
+	const size = 9.5
+	const padding = 6.0
+	perLine := max(int((width-2*padding)/(size*0.6)), 1)
+	var lines []pdfLine
+	lines = append(lines, pdfLine{height: padding})
+	for _, text := range strings.Split(node.TextContent(), "\n") {
+		chars := []rune(pdfClean(strings.ReplaceAll(text, "\t", "    ")))
+		for {
+			part := chars
+			if len(part) > perLine {
+				part = chars[:perLine]
+			}
+			run := pdfRun{text: string(part), font: pdfMono, size: size}
+			lines = append(lines, pdfShift([]pdfLine{pdfTextLine([]pdfRun{run}, 0, 0, size, "")}, padding)...)
+			chars = chars[len(part):]
+			if len(chars) == 0 {
+				break
+			}
+		}
+	}
+	lines = append(lines, pdfLine{height: padding})
+	lines = pdfDecorate(lines, func(p *bytes.Buffer, x, top, height float64) {
+		pdfFill(p, "#F4F5F7", x, top-height, width, height)
+	})
+	return append(lines, pdfLine{height: 6})
+}
+
+func (r *pdfRenderer) media(node *model.Node, width float64) []pdfLine {
+	url, _ := node.Attrs["url"].(string)
+	img, ok := r.images[url]
+	var xobject *pdfImage
+	if ok {
+		xobject = r.xobject(url, img)
+	}
+	if xobject == nil {
+		alt, _ := node.Attrs["alt"].(string)
+		if alt == "" {
+			return nil
+		}
+		run := pdfRun{text: pdfClean(alt), font: pdfItalic, size: pdfFontSize}
+		return append(r.wrap([]pdfRun{run}, width, "center"), pdfLine{height: 6})
+	}
+
+	w, h := scaleImage(img, pdfPointPerPixel, width)
+	if maxHeight := pdfPageHeight - 2*pdfMargin - 12; h > maxHeight {
+		w = w * maxHeight / h
+		h = maxHeight
+	}
+	return []pdfLine{{height: h + 12, draw: func(p *bytes.Buffer, x, top float64) {
+		fmt.Fprintf(p, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", w, h, x+(width-w)/2, top-6-h, xobject.name)
+	}}}
+}
+
+// xobject returns the image XObject for an image of the note. The JPEG
+// images are embedded as is, the other formats are converted to RGB.
+func (r *pdfRenderer) xobject(id string, img *exportImage) *pdfImage {
+	if xobject, ok := r.xobjects[id]; ok {
+		return xobject
+	}
+	xobject := &pdfImage{name: fmt.Sprintf("Im%d", len(r.order)+1)}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		r.xobjects[id] = nil
+		return nil
+	}
+	xobject.width, xobject.height = cfg.Width, cfg.Height
+	if format == "jpeg" && (cfg.ColorModel == color.YCbCrModel || cfg.ColorModel == color.GrayModel) {
+		xobject.filter = "DCTDecode"
+		xobject.colorSpace = "DeviceRGB"
+		if cfg.ColorModel == color.GrayModel {
+			xobject.colorSpace = "DeviceGray"
+		}
+		xobject.data = img.Data
+	} else {
+		decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			r.xobjects[id] = nil
+			return nil
+		}
+		bounds := decoded.Bounds()
+		xobject.width, xobject.height = bounds.Dx(), bounds.Dy()
+		pixels := make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				// Blend the transparent pixels with a white background
+				c := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+				a := uint32(c.A)
+				blend := func(v uint8) byte {
+					return byte((uint32(v)*a + 255*(255-a)) / 255)
+				}
+				pixels = append(pixels, blend(c.R), blend(c.G), blend(c.B))
+			}
+		}
+		data, err := pdfCompress(pixels)
+		if err != nil {
+			r.xobjects[id] = nil
+			return nil
+		}
+		xobject.filter = "FlateDecode"
+		xobject.colorSpace = "DeviceRGB"
+		xobject.data = data
+	}
+	r.xobjects[id] = xobject
+	r.order = append(r.order, xobject)
+	return xobject
+}
+
+func (r *pdfRenderer) table(node *model.Node, width float64) []pdfLine {
+	grid := newTableGrid(node)
+	if grid.Cols == 0 {
+		return nil
+	}
+	const padding = 4.0
+	colWidth := width / float64(grid.Cols)
+
+	// Layout the content of the cells
+	contents := make(map[*tableCell][]pdfLine)
+	heights := make([]float64, grid.Rows)
+	for row := range heights {
+		heights[row] = pdfFontSize*pdfLineHeight + 2*padding
+	}
+	var spanning []*tableCell
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			if !grid.IsOrigin(row, col) {
+				continue
+			}
+			cell := grid.Cells[row][col]
+			r.bold = cell.Header
+			lines := r.blocks(cell.Node, colWidth*float64(cell.Colspan)-2*padding)
+			r.bold = false
+			for len(lines) > 0 && lines[len(lines)-1].draw == nil {
+				lines = lines[:len(lines)-1]
+			}
+			contents[cell] = lines
+			if cell.Rowspan == 1 {
+				heights[row] = math.Max(heights[row], pdfHeight(lines)+2*padding)
+			} else {
+				spanning = append(spanning, cell)
+			}
+		}
+	}
+	for _, cell := range spanning {
+		total := 0.0
+		for i := 0; i < cell.Rowspan; i++ {
+			total += heights[cell.Row+i]
+		}
+		if missing := pdfHeight(contents[cell]) + 2*padding - total; missing > 0 {
+			heights[cell.Row+cell.Rowspan-1] += missing
+		}
+	}
+
+	// Group the rows that are linked by a rowspan, as they must be drawn on
+	// the same page
+	var lines []pdfLine
+	for start := 0; start < grid.Rows; {
+		end := start
+		for row := start; row <= end; row++ {
+			for col := 0; col < grid.Cols; col++ {
+				if cell := grid.Cells[row][col]; cell != nil {
+					end = max(end, cell.Row+cell.Rowspan-1)
+				}
+			}
+		}
+		first, last := start, end
+		height := 0.0
+		for row := first; row <= last; row++ {
+			height += heights[row]
+		}
+		lines = append(lines, pdfLine{height: height, draw: func(p *bytes.Buffer, x, top float64) {
+			tops := make([]float64, grid.Rows+1)
+			tops[first] = top
+			for row := first; row <= last; row++ {
+				tops[row+1] = tops[row] - heights[row]
+			}
+			for row := first; row <= last; row++ {
+				for col := 0; col < grid.Cols; col++ {
+					cell := grid.Cells[row][col]
+					cx := x + colWidth*float64(col)
+					if cell == nil {
+						pdfStroke(p, "#C1C7D0", cx, tops[row+1], colWidth, heights[row])
+						continue
+					}
+					if !grid.IsOrigin(row, col) {
+						continue
+					}
+					cw := colWidth * float64(cell.Colspan)
+					cy := tops[row+cell.Rowspan]
+					ch := tops[row] - cy
+					background, _ := cell.Node.Attrs["background"].(string)
+					if isHexColor(background) {
+						pdfFill(p, background, cx, cy, cw, ch)
+					} else if cell.Header {
+						pdfFill(p, "#F4F5F7", cx, cy, cw, ch)
+					}
+					y := tops[row] - padding
+					for _, line := range contents[cell] {
+						if line.draw != nil {
+							line.draw(p, cx+padding, y)
+						}
+						y -= line.height
+					}
+					pdfStroke(p, "#C1C7D0", cx, cy, cw, ch)
+				}
+			}
+		}})
+		start = end + 1
+	}
+	return append(lines, pdfLine{height: 8})
+}
+
+// pdfShift moves the lines to the right.
+func pdfShift(lines []pdfLine, dx float64) []pdfLine {
+	shifted := make([]pdfLine, len(lines))
+	for i, line := range lines {
+		shifted[i] = line
+		if draw := line.draw; draw != nil {
+			shifted[i].draw = func(p *bytes.Buffer, x, top float64) {
+				draw(p, x+dx, top)
+			}
+		}
+	}
+	return shifted
+}
+
+// pdfDecorate calls fn before drawing each line, for drawing a background or
+// a border for example.
+func pdfDecorate(lines []pdfLine, fn func(p *bytes.Buffer, x, top, height float64)) []pdfLine {
+	decorated := make([]pdfLine, len(lines))
+	for i, line := range lines {
+		draw, height := line.draw, line.height
+		decorated[i] = line
+		decorated[i].draw = func(p *bytes.Buffer, x, top float64) {
+			fn(p, x, top, height)
+			if draw != nil {
+				draw(p, x, top)
+			}
+		}
+	}
+	return decorated
+}
+
+// pdfWithMarker calls fn with the position of the baseline of the first line
+// of text, for drawing a bullet or a checkbox for example.
+func pdfWithMarker(lines []pdfLine, fn func(p *bytes.Buffer, x, baseline float64)) []pdfLine {
+	for i, line := range lines {
+		if line.draw == nil {
+			continue
+		}
+		draw, baseline := line.draw, line.baseline
+		if baseline == 0 {
+			baseline = pdfFontSize
+		}
+		lines[i].draw = func(p *bytes.Buffer, x, top float64) {
+			fn(p, x-pdfIndent, top-baseline)
+			draw(p, x, top)
+		}
+		break
+	}
+	return lines
+}
+
+func pdfHeight(lines []pdfLine) float64 {
+	height := 0.0
+	for _, line := range lines {
+		height += line.height
+	}
+	return height
+}
+
+func pdfFill(p *bytes.Buffer, color string, x, y, width, height float64) {
+	fmt.Fprintf(p, "%s rg %.2f %.2f %.2f %.2f re f\n", pdfColor(color), x, y, width, height)
+}
+
+func pdfStroke(p *bytes.Buffer, color string, x, y, width, height float64) {
+	fmt.Fprintf(p, "%s RG 0.6 w %.2f %.2f %.2f %.2f re S\n", pdfColor(color), x, y, width, height)
+}
+
+// pdfColor converts a color like #RRGGBB to the PDF syntax.
+func pdfColor(color string) string {
+	hex := hexColor(color)
+	var rgb [3]float64
+	for i := range rgb {
+		var v int
+		_, _ = fmt.Sscanf(hex[2*i:2*i+2], "%02x", &v)
+		rgb[i] = float64(v) / 255
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", rgb[0], rgb[1], rgb[2])
+}
+
+// pdfClean removes the control characters of a text, except the new lines,
+// and replaces the tabulations by spaces.
+func pdfClean(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\n':
+			buf.WriteByte('\n')
+		case c == '\t':
+			buf.WriteString("    ")
+		case c >= ' ' && c != 0x7f:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// pdfUTF16 returns a text string in UTF-16 for the metadata of the document.
+func pdfUTF16(s string) string {
+	var buf strings.Builder
+	buf.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&buf, "%04X", u)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
+
+// pdfTextWidth returns the width of a text, in points.
+func pdfTextWidth(s string, font int, size float64) float64 {
+	return float64(pdfFonts[font].width(s)) * size / 1000
+}
+
+func pdfCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
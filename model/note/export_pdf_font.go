@@ -0,0 +1,227 @@
+package note
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfFontFiles are the TrueType fonts embedded in the PDF documents, in the
+// order of the pdfRegular, pdfBold, ... constants. The Go fonts cover the
+// Latin, Greek and Cyrillic scripts.
+var pdfFontFiles = []struct {
+	name string
+	ttf  []byte
+}{
+	{"GoRegular", goregular.TTF},
+	{"GoBold", gobold.TTF},
+	{"GoItalic", goitalic.TTF},
+	{"GoBoldItalic", gobolditalic.TTF},
+	{"GoMono", gomono.TTF},
+}
+
+// pdfFont is a TrueType font embedded as a CIDFont with the Identity-H
+// encoding: the text is written with the indexes of the glyphs, and the
+// ToUnicode map allows the PDF readers to extract the text.
+type pdfFont struct {
+	name       string
+	file       []byte // the compressed TrueType file
+	fileLength int
+	glyphs     map[rune]uint16
+	widths     []int // the advance of the glyphs, in thousandths of the font size
+	missing    uint16
+	descriptor string
+	toUnicode  []byte // the compressed ToUnicode CMap
+}
+
+var (
+	pdfFonts     []*pdfFont
+	pdfFontsErr  error
+	pdfFontsOnce sync.Once
+)
+
+// loadPDFFonts parses the fonts on the first call. It is done lazily, as it
+// is only useful for the PDF exports.
+func loadPDFFonts() error {
+	pdfFontsOnce.Do(func() {
+		fonts := make([]*pdfFont, len(pdfFontFiles))
+		for i, file := range pdfFontFiles {
+			f, err := parsePDFFont(file.name, file.ttf)
+			if err != nil {
+				pdfFontsErr = err
+				return
+			}
+			fonts[i] = f
+		}
+		pdfFonts = fonts
+	})
+	return pdfFontsErr
+}
+
+func parsePDFFont(name string, ttf []byte) (*pdfFont, error) {
+	f, err := sfnt.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	// With 1000 pixels per em, the metrics are in thousandths of the font
+	// size, as expected by PDF.
+	ppem := fixed.I(1000)
+
+	glyphs := make(map[rune]uint16)
+	runes := make(map[uint16]rune)
+	for r := rune(' '); r <= 0xffff; r++ {
+		if r >= 0xd800 && r < 0xe000 {
+			continue // surrogates
+		}
+		idx, err := f.GlyphIndex(&buf, r)
+		if err != nil || idx == 0 {
+			continue
+		}
+		glyphs[r] = uint16(idx)
+		if _, ok := runes[uint16(idx)]; !ok {
+			runes[uint16(idx)] = r
+		}
+	}
+
+	widths := make([]int, f.NumGlyphs())
+	for i := range widths {
+		advance, err := f.GlyphAdvance(&buf, sfnt.GlyphIndex(i), ppem, font.HintingNone)
+		if err != nil {
+			return nil, err
+		}
+		widths[i] = advance.Round()
+	}
+
+	bounds, err := f.Bounds(&buf, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := f.Metrics(&buf, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	flags := 32 // Nonsymbolic
+	italicAngle := 0.0
+	if post := f.PostTable(); post != nil {
+		if post.IsFixedPitch {
+			flags |= 1
+		}
+		if post.ItalicAngle != 0 {
+			flags |= 64
+			italicAngle = post.ItalicAngle
+		}
+	}
+	// The y axis of sfnt goes down, and the one of PDF goes up.
+	descriptor := fmt.Sprintf("/Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%d %d %d %d] /ItalicAngle %.1f /Ascent %d /Descent %d /CapHeight %d /StemV 80",
+		name, flags, bounds.Min.X.Round(), -bounds.Max.Y.Round(), bounds.Max.X.Round(), -bounds.Min.Y.Round(),
+		italicAngle, metrics.Ascent.Round(), -metrics.Descent.Round(), metrics.CapHeight.Round())
+
+	file, err := pdfCompress(ttf)
+	if err != nil {
+		return nil, err
+	}
+	toUnicode, err := pdfCompress(pdfToUnicode(runes))
+	if err != nil {
+		return nil, err
+	}
+	return &pdfFont{
+		name:       name,
+		file:       file,
+		fileLength: len(ttf),
+		glyphs:     glyphs,
+		widths:     widths,
+		missing:    glyphs['?'],
+		descriptor: descriptor,
+		toUnicode:  toUnicode,
+	}, nil
+}
+
+// glyph returns the index of the glyph for a character, or the one of the
+// question mark if the font has no glyph for it.
+func (f *pdfFont) glyph(r rune) uint16 {
+	if idx, ok := f.glyphs[r]; ok {
+		return idx
+	}
+	return f.missing
+}
+
+// width returns the width of a text, in thousandths of the font size.
+func (f *pdfFont) width(s string) int {
+	total := 0
+	for _, r := range s {
+		if idx := int(f.glyph(r)); idx < len(f.widths) {
+			total += f.widths[idx]
+		}
+	}
+	return total
+}
+
+// encode returns a text as a hexadecimal string of glyph indexes.
+func (f *pdfFont) encode(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('<')
+	for _, r := range s {
+		fmt.Fprintf(&buf, "%04X", f.glyph(r))
+	}
+	buf.WriteByte('>')
+	return buf.String()
+}
+
+// widthsArray returns the W array of the CIDFont.
+func (f *pdfFont) widthsArray() string {
+	var buf strings.Builder
+	buf.WriteString("[0 [")
+	for i, w := range f.widths {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%d", w)
+	}
+	buf.WriteString("]]")
+	return buf.String()
+}
+
+// pdfToUnicode returns a CMap that maps the glyph indexes to the characters.
+func pdfToUnicode(runes map[uint16]rune) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	buf.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	buf.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	buf.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	var entries []string
+	for idx := 0; idx <= 0xffff; idx++ {
+		r, ok := runes[uint16(idx)]
+		if !ok {
+			continue
+		}
+		var dst strings.Builder
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&dst, "%04X", u)
+		}
+		entries = append(entries, fmt.Sprintf("<%04X> <%s>\n", idx, dst.String()))
+	}
+	// A bfchar section can't have more than 100 entries
+	for len(entries) > 0 {
+		n := min(len(entries), 100)
+		fmt.Fprintf(&buf, "%d beginbfchar\n", n)
+		for _, entry := range entries[:n] {
+			buf.WriteString(entry)
+		}
+		buf.WriteString("endbfchar\n")
+		entries = entries[n:]
+	}
+	buf.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return buf.Bytes()
+}
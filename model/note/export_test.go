@@ -0,0 +1,226 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exportMarkdown = `# A heading
+
+Some **bold**, _italic_ and [a link](https://cozy.io/) (and a € sign).
+
+- first
+- second
+  1. nested
+
+:warning: a warning panel
+
+✍ a decision
+
+- [ ] a todo task
+- [X] a done task
+
+________________________________________{.table}
+
+________________________________________{.tableRow}
+
+____________________{.tableHeader}
+
+Name
+
+____________________{.tableHeader}
+
+Value
+
+________________________________________{.tableRow}
+
+____________________{.tableCell colspan=2}
+
+Merged
+
+________________________________________{.tableEnd}
+
+![my image](note-id/image-id)
+`
+
+func exportFixture(t *testing.T) (*model.Node, map[string]*exportImage) {
+	schemaSpecs := DefaultSchemaSpecs()
+	specs := model.SchemaSpecFromJSON(schemaSpecs)
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+	content, err := parseFile(strings.NewReader(exportMarkdown), schema)
+	require.NoError(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	images := map[string]*exportImage{
+		"note-id/image-id": newExportImage("image.png", "image/png", buf.Bytes(), 0, 0),
+	}
+	return content, images
+}
+
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[f.Name] = content
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".rels") {
+			assertWellFormed(t, f.Name, content)
+		}
+	}
+	return files
+}
+
+func assertWellFormed(t *testing.T, name string, content []byte) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err, name)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	content, images := exportFixture(t)
+	out, err := renderHTML("My <note>", content, images)
+	require.NoError(t, err)
+	html := string(out)
+	assert.Contains(t, html, "<title>My &lt;note&gt;</title>")
+	assert.Contains(t, html, "<h1>A heading</h1>")
+	assert.Contains(t, html, `<a href="https://cozy.io/">a link</a>`)
+	assert.Contains(t, html, `<div class="panel panel-warning"`)
+	assert.Contains(t, html, `<li class="decision-item">a decision</li>`)
+	assert.Contains(t, html, `<input type="checkbox" disabled>a todo task`)
+	assert.Contains(t, html, `<input type="checkbox" disabled checked>a done task`)
+	assert.Contains(t, html, `<td colspan="2">`)
+	assert.Contains(t, html, `<img src="data:image/png;base64,`)
+	assert.Contains(t, html, ` width="4"`)
+}
+
+func TestExportDOCX(t *testing.T) {
+	content, images := exportFixture(t)
+	out, err := renderDOCX("My note", content, images)
+	require.NoError(t, err)
+	files := readZip(t, out)
+	require.Contains(t, files, "[Content_Types].xml")
+	require.Contains(t, files, "word/media/image1.png")
+	doc := string(files["word/document.xml"])
+	assert.Contains(t, doc, `<w:pStyle w:val="Heading1"/>`)
+	assert.Contains(t, doc, `<w:gridSpan w:val="2"/>`)
+	assert.Contains(t, doc, "☒ ")
+	assert.Contains(t, doc, "✍ ")
+	assert.Contains(t, doc, `<w:shd w:val="clear" w:color="auto" w:fill="FFFAE6"/>`)
+	assert.Contains(t, doc, `<wp:extent cx="38100" cy="28575"/>`)
+	rels := string(files["word/_rels/document.xml.rels"])
+	assert.Contains(t, rels, `Target="https://cozy.io/" TargetMode="External"`)
+	assert.Contains(t, string(files["word/numbering.xml"]), `<w:num w:numId="2">`)
+}
+
+func TestExportODT(t *testing.T) {
+	content, images := exportFixture(t)
+	out, err := renderODT("My note", content, images)
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	assert.Equal(t, "mimetype", zr.File[0].Name)
+	assert.Equal(t, zip.Store, zr.File[0].Method)
+	files := readZip(t, out)
+	assert.Equal(t, odtMime, string(files["mimetype"]))
+	require.Contains(t, files, "Pictures/image1.png")
+	doc := string(files["content.xml"])
+	assert.Contains(t, doc, `<text:h text:style-name="Heading_20_1" text:outline-level="1">A heading</text:h>`)
+	assert.Contains(t, doc, `table:number-columns-spanned="2"`)
+	assert.Contains(t, doc, `<table:covered-table-cell/>`)
+	assert.Contains(t, doc, `<text:a xlink:type="simple" xlink:href="https://cozy.io/">`)
+	assert.Contains(t, doc, "☑ ")
+	assert.Contains(t, doc, `fo:background-color="#fffae6"`)
+	assert.Contains(t, string(files["META-INF/manifest.xml"]), `manifest:full-path="Pictures/image1.png"`)
+}
+
+func TestExportPDF(t *testing.T) {
+	content, images := exportFixture(t)
+	out, err := renderPDF("My note", content, images)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+
+	// Check that the cross-reference table points to the objects
+	matches := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	require.Len(t, matches, 2)
+	xref, err := strconv.Atoi(string(matches[1]))
+	require.NoError(t, err)
+	lines := strings.Split(string(out[xref:]), "\n")
+	require.Equal(t, "xref", lines[0])
+	var count int
+	_, err = fmt.Sscanf(lines[1], "0 %d", &count)
+	require.NoError(t, err)
+	for id := 1; id < count; id++ {
+		offset, err := strconv.Atoi(lines[2+id][:10])
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj\n", id))), "object %d", id)
+	}
+	assert.Contains(t, string(out), "/Subtype /Image /Width 4 /Height 3")
+	assert.Contains(t, string(out), "/Count 1")
+	assert.Contains(t, string(out), "/Subtype /Type0 /BaseFont /GoRegular /Encoding /Identity-H")
+	assert.Contains(t, string(out), "/FontFile2")
+}
+
+func TestPDFFonts(t *testing.T) {
+	require.NoError(t, loadPDFFonts())
+	regular := pdfFonts[pdfRegular]
+	question := regular.glyph('?')
+	assert.NotZero(t, question)
+	for _, c := range "aé€αж" {
+		assert.NotEqual(t, question, regular.glyph(c), "glyph for %c", c)
+	}
+	assert.Equal(t, question, regular.glyph('☃'))
+	assert.Equal(t, fmt.Sprintf("<%04X%04X>", regular.glyph('ж'), question), regular.encode("ж☃"))
+
+	mono := pdfFonts[pdfMono]
+	assert.Equal(t, mono.width("iiii"), mono.width("MMMM"))
+	assert.Less(t, regular.width("iiii"), regular.width("MMMM"))
+}
+
+func TestPDFWrap(t *testing.T) {
+	require.NoError(t, loadPDFFonts())
+	r := &pdfRenderer{}
+	run := pdfRun{text: pdfClean("lorem ipsum dolor sit amet"), size: 10}
+	width := pdfTextWidth("lorem ipsum", pdfRegular, 10)
+	lines := r.wrap([]pdfRun{run}, width, "")
+	assert.Len(t, lines, 3)
+
+	long := pdfRun{text: strings.Repeat("x", 100), size: 10}
+	lines = r.wrap([]pdfRun{long}, 100, "")
+	assert.Len(t, lines, 5)
+
+	// The long words with non-ASCII characters are split too
+	long = pdfRun{text: strings.Repeat("ж", 100), size: 10}
+	lines = r.wrap([]pdfRun{long}, 100, "")
+	assert.Greater(t, len(lines), 1)
+
+	assert.Equal(t, "café    €\n", pdfClean("café\t€\x01\n"))
+}
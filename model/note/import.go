@@ -2,6 +2,8 @@ package note
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -23,6 +25,26 @@ import (
 const MaxMarkdownSize = 2 * 1024 * 1024
 
 func ImportFile(inst *instance.Instance, newdoc, olddoc *vfs.FileDoc, body io.ReadCloser) error {
+	// The DOCX and ODT documents are converted to notes
+	var reader io.Reader = body
+	br := bufio.NewReader(body)
+	if magic, _ := br.Peek(4); isZip(magic) {
+		data, err := io.ReadAll(io.LimitReader(br, MaxOfficeSize+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > MaxOfficeSize {
+			return vfs.ErrFileTooBig
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err == nil && detectOffice(zr) != nil {
+			return importOffice(inst, newdoc, olddoc, zr)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = br
+	}
+
 	schemaSpecs := DefaultSchemaSpecs()
 	specs := model.SchemaSpecFromJSON(schemaSpecs)
 	schema, err := model.NewSchema(&specs)
@@ -43,7 +65,7 @@ func ImportFile(inst *instance.Instance, newdoc, olddoc *vfs.FileDoc, body io.Re
 		return err
 	}
 
-	reader := io.TeeReader(body, file)
+	reader = io.TeeReader(reader, file)
 	content, _, err := importReader(inst, newdoc, reader, schema)
 
 	if content != nil {
@@ -120,6 +142,46 @@ func ImportImages(inst *instance.Instance, olddoc *vfs.FileDoc) error {
 	return err
 }
 
+// ImportText creates a note from a plain text, with a paragraph for each
+// line. It is used for the body of the inbound mails.
+func ImportText(inst *instance.Instance, title, dirID, text string) (*vfs.FileDoc, error) {
+	schemaSpecs := DefaultSchemaSpecs()
+	specs := model.SchemaSpecFromJSON(schemaSpecs)
+	schema, err := model.NewSchema(&specs)
+	if err != nil {
+		return nil, err
+	}
+	content, err := textDoc(schema, text)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		Title:      title,
+		DirID:      dirID,
+		SchemaSpec: schemaSpecs,
+	}
+	doc.SetContent(content)
+	return Create(inst, doc)
+}
+
+func textDoc(schema *model.Schema, text string) (*model.Node, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Trim(text, "\n")
+	var blocks []*model.Node
+	for _, line := range strings.Split(text, "\n") {
+		var inline []*model.Node
+		if line = strings.TrimRight(line, " \t"); line != "" {
+			inline = append(inline, schema.Text(line))
+		}
+		paragraph, err := schema.Node("paragraph", nil, inline)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, paragraph)
+	}
+	return officeDoc(schema, blocks)
+}
+
 func importReader(inst *instance.Instance, doc *vfs.FileDoc, reader io.Reader, schema *model.Schema) (*model.Node, []*Image, error) {
 	buf := &bytes.Buffer{}
 	var hasImages bool
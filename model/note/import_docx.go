@@ -0,0 +1,389 @@
+package note
+
+import (
+	"archive/zip"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// docxStyle is what is used by the import from a paragraph style of a DOCX.
+type docxStyle struct {
+	basedOn string
+	heading int
+	numID   string
+	ilvl    int
+}
+
+// docxHeadingName matches the names of the built-in styles for headings.
+var docxHeadingName = regexp.MustCompile(`^heading ([1-9])$`)
+
+type docxParser struct {
+	schema  *model.Schema
+	images  *officeImages
+	rels    map[string]string // relationship id -> target
+	links   map[string]bool   // relationship id -> external target
+	styles  map[string]*docxStyle
+	ordered map[string]map[int]bool // numId -> ilvl -> ordered list
+}
+
+// parseDOCX imports an Office Open XML document.
+func parseDOCX(zr *zip.Reader, schema *model.Schema, save officeImageSaver) ([]*model.Node, error) {
+	p := &docxParser{
+		schema:  schema,
+		images:  newOfficeImages(zr, save),
+		rels:    make(map[string]string),
+		links:   make(map[string]bool),
+		styles:  make(map[string]*docxStyle),
+		ordered: make(map[string]map[int]bool),
+	}
+	if data, err := readZipFile(zr, "word/_rels/document.xml.rels", MaxMarkdownSize); err == nil {
+		if root, err := parseXML(data); err == nil {
+			p.readRelationships(root)
+		}
+	}
+	if data, err := readZipFile(zr, "word/styles.xml", MaxMarkdownSize); err == nil {
+		if root, err := parseXML(data); err == nil {
+			p.readStyles(root)
+		}
+	}
+	if data, err := readZipFile(zr, "word/numbering.xml", MaxMarkdownSize); err == nil {
+		if root, err := parseXML(data); err == nil {
+			p.readNumbering(root)
+		}
+	}
+
+	data, err := readZipFile(zr, "word/document.xml", MaxOfficeSize)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, ErrInvalidImportFormat
+	}
+	b := newOfficeBuilder(schema)
+	if err := p.blocks(b, root.child("body")); err != nil {
+		return nil, err
+	}
+	return b.build()
+}
+
+func (p *docxParser) readRelationships(root *xmlElement) {
+	for _, rel := range root.Children {
+		if rel.Name.Local != "Relationship" {
+			continue
+		}
+		id, target := rel.attr("Id"), rel.attr("Target")
+		if rel.attr("TargetMode") == "External" {
+			p.rels[id] = target
+			p.links[id] = true
+		} else if strings.HasPrefix(target, "/") {
+			p.rels[id] = strings.TrimPrefix(target, "/")
+		} else {
+			p.rels[id] = path.Join("word", target)
+		}
+	}
+}
+
+func (p *docxParser) readStyles(root *xmlElement) {
+	for _, el := range root.Children {
+		if el.Name.Local != "style" || el.attr("type") != "paragraph" {
+			continue
+		}
+		style := &docxStyle{basedOn: el.child("basedOn").attr("val")}
+		name := strings.ToLower(el.child("name").attr("val"))
+		if m := docxHeadingName.FindStringSubmatch(name); m != nil {
+			style.heading = officeInt(m[1], 0)
+		} else if name == "title" {
+			style.heading = 1
+		} else if lvl := el.path("pPr", "outlineLvl"); lvl != nil {
+			if n := officeInt(lvl.attr("val"), 9); n < 9 {
+				style.heading = n + 1
+			}
+		}
+		if numPr := el.path("pPr", "numPr"); numPr != nil {
+			style.numID = numPr.child("numId").attr("val")
+			style.ilvl = officeInt(numPr.child("ilvl").attr("val"), 0)
+		}
+		p.styles[el.attr("styleId")] = style
+	}
+}
+
+func (p *docxParser) readNumbering(root *xmlElement) {
+	abstracts := make(map[string]map[int]bool)
+	for _, el := range root.Children {
+		if el.Name.Local != "abstractNum" {
+			continue
+		}
+		levels := make(map[int]bool)
+		for _, lvl := range el.Children {
+			if lvl.Name.Local != "lvl" {
+				continue
+			}
+			format := lvl.child("numFmt").attr("val")
+			levels[officeInt(lvl.attr("ilvl"), 0)] = format != "bullet" && format != "none" && format != ""
+		}
+		abstracts[el.attr("abstractNumId")] = levels
+	}
+	for _, el := range root.Children {
+		if el.Name.Local == "num" {
+			p.ordered[el.attr("numId")] = abstracts[el.child("abstractNumId").attr("val")]
+		}
+	}
+}
+
+// style returns the paragraph style with the given id, with the heading
+// level and numbering inherited from the styles it is based on.
+func (p *docxParser) style(id string) docxStyle {
+	var style docxStyle
+	for i := 0; i < 10 && id != ""; i++ {
+		s, ok := p.styles[id]
+		if !ok {
+			break
+		}
+		if style.heading == 0 {
+			style.heading = s.heading
+		}
+		if style.numID == "" {
+			style.numID, style.ilvl = s.numID, s.ilvl
+		}
+		id = s.basedOn
+	}
+	return style
+}
+
+func (p *docxParser) blocks(b *officeBuilder, parent *xmlElement) error {
+	if parent == nil {
+		return nil
+	}
+	for _, el := range parent.Children {
+		var err error
+		switch el.Name.Local {
+		case "p":
+			err = p.paragraph(b, el)
+		case "tbl":
+			err = p.table(b, el)
+		case "sdt":
+			err = p.blocks(b, el.child("sdtContent"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *docxParser) paragraph(b *officeBuilder, el *xmlElement) error {
+	pPr := el.child("pPr")
+	style := p.style(pPr.child("pStyle").attr("val"))
+	if numPr := pPr.child("numPr"); numPr != nil {
+		style.numID = numPr.child("numId").attr("val")
+		style.ilvl = officeInt(numPr.child("ilvl").attr("val"), 0)
+	}
+
+	in := newOfficeInline(p.schema)
+	if err := p.runs(in, el, inlineStyle{}); err != nil {
+		return err
+	}
+
+	inList := false
+	if style.numID != "" && style.numID != "0" {
+		ordered := p.ordered[style.numID][style.ilvl]
+		b.addListItem(style.ilvl, ordered, b.paragraph(0, in.nodes))
+		inList = true
+	} else if !in.isBlank() {
+		b.add(b.paragraph(style.heading, in.nodes))
+	}
+	for _, img := range in.images {
+		if inList {
+			b.addToListItem(b.image(img))
+		} else {
+			b.add(b.image(img))
+		}
+	}
+	return nil
+}
+
+func (p *docxParser) runs(in *officeInline, parent *xmlElement, style inlineStyle) error {
+	if parent == nil {
+		return nil
+	}
+	for _, el := range parent.Children {
+		switch el.Name.Local {
+		case "r":
+			if err := p.run(in, el, style); err != nil {
+				return err
+			}
+		case "hyperlink":
+			linkStyle := style
+			if id := el.attr("id"); p.links[id] && isSafeLink(p.rels[id]) {
+				linkStyle.Link = p.rels[id]
+			}
+			if err := p.runs(in, el, linkStyle); err != nil {
+				return err
+			}
+		case "ins", "smartTag", "fldSimple", "customXml":
+			if err := p.runs(in, el, style); err != nil {
+				return err
+			}
+		case "sdt":
+			if err := p.runs(in, el.child("sdtContent"), style); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *docxParser) run(in *officeInline, el *xmlElement, style inlineStyle) error {
+	if rPr := el.child("rPr"); rPr != nil {
+		style.Bold = style.Bold || docxOn(rPr.child("b"))
+		style.Italic = style.Italic || docxOn(rPr.child("i"))
+		style.Strike = style.Strike || docxOn(rPr.child("strike")) || docxOn(rPr.child("dstrike"))
+		if u := rPr.child("u"); u != nil && u.attr("val") != "none" {
+			style.Underline = true
+		}
+		switch rPr.child("vertAlign").attr("val") {
+		case "superscript":
+			style.Script = "sup"
+		case "subscript":
+			style.Script = "sub"
+		}
+		if color := "#" + rPr.child("color").attr("val"); isHexColor(color) {
+			style.Color = color
+		}
+	}
+	for _, child := range el.Children {
+		switch child.Name.Local {
+		case "t":
+			in.text(docxText(child), style)
+		case "tab", "ptab":
+			in.text(" ", style)
+		case "noBreakHyphen":
+			in.text("-", style)
+		case "br":
+			if typ := child.attr("type"); typ == "" || typ == "textWrapping" {
+				in.hardBreak()
+			}
+		case "cr":
+			in.hardBreak()
+		case "drawing", "pict", "object":
+			id := child.find("blip").attr("embed")
+			if id == "" {
+				id = child.find("imagedata").attr("id")
+			}
+			if target, ok := p.rels[id]; ok && !p.links[id] {
+				img, err := p.images.get(target)
+				if err != nil {
+					return err
+				}
+				in.image(img)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *docxParser) table(b *officeBuilder, el *xmlElement) error {
+	var rows [][]*officeCell
+	merged := make(map[int]*officeCell) // column -> cell with a vertical merge
+	for _, tr := range el.Children {
+		if tr.Name.Local != "tr" {
+			continue
+		}
+		trPr := tr.child("trPr")
+		header := docxOn(trPr.child("tblHeader"))
+		col := officeInt(trPr.child("gridBefore").attr("val"), 0)
+		var row []*officeCell
+		for _, tc := range tr.Children {
+			if tc.Name.Local != "tc" {
+				continue
+			}
+			tcPr := tc.child("tcPr")
+			span := max(officeInt(tcPr.child("gridSpan").attr("val"), 1), 1)
+			vMerge := tcPr.child("vMerge")
+			if vMerge != nil && vMerge.attr("val") != "restart" {
+				if origin := merged[col]; origin != nil {
+					origin.rowspan++
+					col += span
+					continue
+				}
+			}
+
+			cb := newOfficeBuilder(p.schema)
+			if err := p.cellBlocks(cb, tc); err != nil {
+				return err
+			}
+			content, err := cb.build()
+			if err != nil {
+				return err
+			}
+			cell := &officeCell{header: header, colspan: span, rowspan: 1, content: content}
+			if vMerge != nil {
+				merged[col] = cell
+			} else {
+				delete(merged, col)
+			}
+			row = append(row, cell)
+			col += span
+		}
+		rows = append(rows, row)
+	}
+	b.table(rows)
+	return nil
+}
+
+// cellBlocks reads the content of a cell. The tables can't be nested in the
+// notes, so the content of a nested table is added to the cell.
+func (p *docxParser) cellBlocks(b *officeBuilder, parent *xmlElement) error {
+	if parent == nil {
+		return nil
+	}
+	for _, el := range parent.Children {
+		var err error
+		switch el.Name.Local {
+		case "p":
+			err = p.paragraph(b, el)
+		case "tbl":
+			for _, tr := range el.Children {
+				for _, tc := range tr.Children {
+					if tc.Name.Local == "tc" {
+						if err = p.cellBlocks(b, tc); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		case "sdt":
+			err = p.cellBlocks(b, el.child("sdtContent"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// docxOn returns true if a toggle property is present and not disabled.
+func docxOn(el *xmlElement) bool {
+	if el == nil {
+		return false
+	}
+	switch el.attr("val") {
+	case "0", "false", "off", "none":
+		return false
+	}
+	return true
+}
+
+func docxText(el *xmlElement) string {
+	var sb strings.Builder
+	for _, child := range el.Children {
+		if child.isText() {
+			sb.WriteString(child.Text)
+		}
+	}
+	return sb.String()
+}
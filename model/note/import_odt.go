@@ -0,0 +1,365 @@
+package note
+
+import (
+	"archive/zip"
+	"regexp"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// odtStyle is what is used by the import from a style of an ODT.
+type odtStyle struct {
+	parent  string
+	heading int
+	text    inlineStyle
+}
+
+// odtHeadingName matches the names of the styles for headings.
+var odtHeadingName = regexp.MustCompile(`^Heading_20_([1-9])$`)
+
+// odtSpaces matches the white spaces that are collapsed in ODF.
+var odtSpaces = regexp.MustCompile(`[ \t\r\n]+`)
+
+type odtParser struct {
+	schema *model.Schema
+	images *officeImages
+	styles map[string]*odtStyle
+	lists  map[string]map[int]bool // list style -> level -> ordered list
+}
+
+// parseODT imports an OpenDocument text.
+func parseODT(zr *zip.Reader, schema *model.Schema, save officeImageSaver) ([]*model.Node, error) {
+	p := &odtParser{
+		schema: schema,
+		images: newOfficeImages(zr, save),
+		styles: make(map[string]*odtStyle),
+		lists:  make(map[string]map[int]bool),
+	}
+	if data, err := readZipFile(zr, "styles.xml", MaxMarkdownSize); err == nil {
+		if root, err := parseXML(data); err == nil {
+			p.readStyles(root.child("styles"))
+			p.readStyles(root.child("automatic-styles"))
+		}
+	}
+
+	data, err := readZipFile(zr, "content.xml", MaxOfficeSize)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, ErrInvalidImportFormat
+	}
+	p.readStyles(root.child("automatic-styles"))
+	b := newOfficeBuilder(schema)
+	if err := p.blocks(b, root.path("body", "text")); err != nil {
+		return nil, err
+	}
+	return b.build()
+}
+
+func (p *odtParser) readStyles(parent *xmlElement) {
+	if parent == nil {
+		return
+	}
+	for _, el := range parent.Children {
+		switch el.Name.Local {
+		case "style":
+			name := el.attr("name")
+			style := &odtStyle{parent: el.attr("parent-style-name")}
+			if m := odtHeadingName.FindStringSubmatch(name); m != nil {
+				style.heading = officeInt(m[1], 0)
+			} else if name == "Title" {
+				style.heading = 1
+			} else if lvl := el.attr("default-outline-level"); lvl != "" {
+				style.heading = officeInt(lvl, 0)
+			}
+			if props := el.child("text-properties"); props != nil {
+				style.text = odtTextStyle(props)
+			}
+			p.styles[name] = style
+		case "list-style":
+			levels := make(map[int]bool)
+			for _, lvl := range el.Children {
+				level := officeInt(lvl.attr("level"), 1)
+				levels[level] = lvl.Name.Local == "list-level-style-number"
+			}
+			p.lists[el.attr("name")] = levels
+		}
+	}
+}
+
+func odtTextStyle(props *xmlElement) inlineStyle {
+	var style inlineStyle
+	switch weight := props.attr("font-weight"); weight {
+	case "bold", "600", "700", "800", "900":
+		style.Bold = true
+	}
+	switch props.attr("font-style") {
+	case "italic", "oblique":
+		style.Italic = true
+	}
+	if u := props.attr("text-underline-style"); u != "" && u != "none" {
+		style.Underline = true
+	}
+	if s := props.attr("text-line-through-style"); s != "" && s != "none" {
+		style.Strike = true
+	}
+	position := props.attr("text-position")
+	if strings.HasPrefix(position, "super") {
+		style.Script = "sup"
+	} else if strings.HasPrefix(position, "sub") {
+		style.Script = "sub"
+	}
+	if color := props.attr("color"); isHexColor(color) {
+		style.Color = color
+	}
+	return style
+}
+
+// heading returns the heading level for a paragraph style, or 0.
+func (p *odtParser) heading(name string) int {
+	for i := 0; i < 10 && name != ""; i++ {
+		style, ok := p.styles[name]
+		if !ok {
+			break
+		}
+		if style.heading > 0 {
+			return style.heading
+		}
+		name = style.parent
+	}
+	return 0
+}
+
+func (p *odtParser) blocks(b *officeBuilder, parent *xmlElement) error {
+	if parent == nil {
+		return nil
+	}
+	for _, el := range parent.Children {
+		var err error
+		switch el.Name.Local {
+		case "p", "h":
+			err = p.paragraph(b, el, -1, false)
+		case "list":
+			err = p.list(b, el, 0, "")
+		case "table":
+			err = p.table(b, el)
+		case "section", "index-body", "table-of-content", "alphabetical-index", "bibliography":
+			err = p.blocks(b, el)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paragraph reads a text:p or text:h. If level is positive or zero, the
+// paragraph starts a new list item at this level.
+func (p *odtParser) paragraph(b *officeBuilder, el *xmlElement, level int, ordered bool) error {
+	in := newOfficeInline(p.schema)
+	if err := p.inline(in, el, inlineStyle{}); err != nil {
+		return err
+	}
+
+	if level >= 0 {
+		b.addListItem(level, ordered, b.paragraph(0, in.nodes))
+	} else if !in.isBlank() {
+		heading := 0
+		if el.Name.Local == "h" {
+			heading = max(officeInt(el.attr("outline-level"), 1), 1)
+		} else {
+			heading = p.heading(el.attr("style-name"))
+		}
+		b.add(b.paragraph(heading, in.nodes))
+	}
+	for _, img := range in.images {
+		if level >= 0 {
+			b.addToListItem(b.image(img))
+		} else {
+			b.add(b.image(img))
+		}
+	}
+	return nil
+}
+
+func (p *odtParser) list(b *officeBuilder, el *xmlElement, level int, style string) error {
+	if name := el.attr("style-name"); name != "" {
+		style = name
+	}
+	ordered := p.lists[style][level+1]
+	for _, item := range el.Children {
+		if item.Name.Local != "list-item" && item.Name.Local != "list-header" {
+			continue
+		}
+		first := true
+		for _, child := range item.Children {
+			var err error
+			switch child.Name.Local {
+			case "p", "h":
+				if first {
+					err = p.paragraph(b, child, level, ordered)
+					first = false
+				} else {
+					in := newOfficeInline(p.schema)
+					if err = p.inline(in, child, inlineStyle{}); err == nil {
+						b.addToListItem(b.paragraph(0, in.nodes))
+						for _, img := range in.images {
+							b.addToListItem(b.image(img))
+						}
+					}
+				}
+			case "list":
+				err = p.list(b, child, level+1, style)
+				first = false
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *odtParser) inline(in *officeInline, parent *xmlElement, style inlineStyle) error {
+	for _, el := range parent.Children {
+		if el.isText() {
+			text := odtSpaces.ReplaceAllString(el.Text, " ")
+			if in.endsWithSpace() {
+				text = strings.TrimLeft(text, " ")
+			}
+			in.text(text, style)
+			continue
+		}
+		var err error
+		switch el.Name.Local {
+		case "s":
+			in.text(strings.Repeat(" ", min(max(officeInt(el.attr("c"), 1), 1), 100)), style)
+		case "tab":
+			in.text(" ", style)
+		case "line-break":
+			in.hardBreak()
+		case "span":
+			spanStyle := style
+			if s, ok := p.styles[el.attr("style-name")]; ok {
+				spanStyle = mergeInlineStyles(style, s.text)
+			}
+			err = p.inline(in, el, spanStyle)
+		case "a":
+			linkStyle := style
+			if href := el.attr("href"); isSafeLink(href) {
+				linkStyle.Link = href
+			}
+			err = p.inline(in, el, linkStyle)
+		case "frame":
+			if href := el.child("image").attr("href"); href != "" {
+				var img *Image
+				img, err = p.images.get(strings.TrimPrefix(href, "./"))
+				in.image(img)
+			}
+		case "note", "annotation", "bookmark", "bookmark-start", "bookmark-end", "soft-page-break":
+			// Ignored
+		default:
+			err = p.inline(in, el, style)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *odtParser) table(b *officeBuilder, el *xmlElement) error {
+	var rows [][]*officeCell
+	var readRows func(parent *xmlElement, header bool) error
+	readRows = func(parent *xmlElement, header bool) error {
+		for _, child := range parent.Children {
+			switch child.Name.Local {
+			case "table-header-rows":
+				if err := readRows(child, true); err != nil {
+					return err
+				}
+			case "table-rows", "table-row-group":
+				if err := readRows(child, header); err != nil {
+					return err
+				}
+			case "table-row":
+				row, err := p.tableRow(child, header)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, row)
+			}
+		}
+		return nil
+	}
+	if err := readRows(el, false); err != nil {
+		return err
+	}
+	b.table(rows)
+	return nil
+}
+
+func (p *odtParser) tableRow(tr *xmlElement, header bool) ([]*officeCell, error) {
+	var row []*officeCell
+	for _, tc := range tr.Children {
+		if tc.Name.Local != "table-cell" {
+			continue
+		}
+		cb := newOfficeBuilder(p.schema)
+		if err := p.cellBlocks(cb, tc); err != nil {
+			return nil, err
+		}
+		content, err := cb.build()
+		if err != nil {
+			return nil, err
+		}
+		repeat := min(max(officeInt(tc.attr("number-columns-repeated"), 1), 1), 64)
+		for i := 0; i < repeat; i++ {
+			row = append(row, &officeCell{
+				header:  header,
+				colspan: max(officeInt(tc.attr("number-columns-spanned"), 1), 1),
+				rowspan: max(officeInt(tc.attr("number-rows-spanned"), 1), 1),
+				content: content,
+			})
+		}
+	}
+	return row, nil
+}
+
+// cellBlocks reads the content of a cell. The tables can't be nested in the
+// notes, so the content of a nested table is added to the cell.
+func (p *odtParser) cellBlocks(b *officeBuilder, parent *xmlElement) error {
+	for _, el := range parent.Children {
+		var err error
+		switch el.Name.Local {
+		case "p", "h":
+			err = p.paragraph(b, el, -1, false)
+		case "list":
+			err = p.list(b, el, 0, "")
+		case "table", "table-header-rows", "table-rows", "table-row", "table-cell", "section":
+			err = p.cellBlocks(b, el)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeInlineStyles returns the style of a text inside a span.
+func mergeInlineStyles(outer, inner inlineStyle) inlineStyle {
+	outer.Bold = outer.Bold || inner.Bold
+	outer.Italic = outer.Italic || inner.Italic
+	outer.Underline = outer.Underline || inner.Underline
+	outer.Strike = outer.Strike || inner.Strike
+	if inner.Script != "" {
+		outer.Script = inner.Script
+	}
+	if inner.Color != "" {
+		outer.Color = inner.Color
+	}
+	return outer
+}
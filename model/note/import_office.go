@@ -0,0 +1,648 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/filetype"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/gofrs/uuid/v5"
+)
+
+// MaxOfficeSize is the maximal size of a DOCX or ODT document that can be
+// imported as a note.
+const MaxOfficeSize = 50 * 1024 * 1024
+
+// officeImageSaver is used by the parsers of office documents to save the
+// images as images of the note.
+type officeImageSaver func(name, mime string, data []byte) (*Image, error)
+
+// officeParser transforms an office document to the blocks of a note.
+type officeParser func(zr *zip.Reader, schema *model.Schema, save officeImageSaver) ([]*model.Node, error)
+
+// importableImageMimes are the mime types of the images that can be imported
+// from an office document. The other images (like EMF or WMF) are ignored.
+var importableImageMimes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// ImportOffice creates a new note from a DOCX or ODT file. The note is
+// created in the directory dirID, or in the same directory as the file if
+// dirID is empty.
+func ImportOffice(inst *instance.Instance, file *vfs.FileDoc, dirID string) (*vfs.FileDoc, error) {
+	if file.ByteSize > MaxOfficeSize {
+		return nil, vfs.ErrFileTooBig
+	}
+	fs := inst.VFS()
+	f, err := fs.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, MaxOfficeSize+1))
+	if errc := f.Close(); err == nil && errc != nil {
+		err = errc
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxOfficeSize {
+		return nil, vfs.ErrFileTooBig
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil || detectOffice(zr) == nil {
+		return nil, ErrInvalidImportFormat
+	}
+
+	if dirID == "" {
+		dirID = file.DirID
+	}
+	name := strings.TrimSuffix(file.DocName, path.Ext(file.DocName)) + ".cozy-note"
+	if exists, err := fs.GetIndexer().DirChildExists(dirID, name); err != nil {
+		return nil, err
+	} else if exists {
+		name = vfs.ConflictName(fs, dirID, name, true)
+	}
+	newdoc, err := vfs.NewFileDoc(name, dirID, 0, nil, consts.NoteMimeType,
+		"text", time.Now(), false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	newdoc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+
+	if err := importOffice(inst, newdoc, nil, zr); err != nil {
+		return nil, err
+	}
+	if err := SetupTrigger(inst, newdoc.ID()); err != nil {
+		return nil, err
+	}
+	return newdoc, nil
+}
+
+// importOffice converts an office document to a note, saves its images, and
+// writes the note in the VFS.
+func importOffice(inst *instance.Instance, newdoc, olddoc *vfs.FileDoc, zr *zip.Reader) error {
+	parse := detectOffice(zr)
+	if parse == nil {
+		return ErrInvalidImportFormat
+	}
+	schemaSpecs := DefaultSchemaSpecs()
+	specs := model.SchemaSpecFromJSON(schemaSpecs)
+	schema, err := model.NewSchema(&specs)
+	if err != nil {
+		return err
+	}
+
+	// We need a fileID for saving images
+	if newdoc.ID() == "" {
+		uuidv7, _ := uuid.NewV7()
+		newdoc.SetID(uuidv7.String())
+	}
+	oldImages, _ := getImages(inst, newdoc.ID())
+
+	var images []*Image
+	save := func(name, mime string, data []byte) (*Image, error) {
+		upload, err := NewImageUpload(inst, newdoc, name, mime)
+		if err != nil {
+			return nil, err
+		}
+		_, err = upload.Write(data)
+		if cerr := upload.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, upload.Image)
+		return upload.Image, nil
+	}
+	blocks, err := parse(zr, schema, save)
+	var content *model.Node
+	if err == nil {
+		content, err = officeDoc(schema, blocks)
+	}
+	if err != nil {
+		for _, img := range images {
+			img.ToRemove = true
+		}
+		cleanImages(inst, images)
+		return err
+	}
+
+	md := []byte(markdownSerializer(images).Serialize(content))
+	body := md
+	if hasImages(images) {
+		if body, err = buildArchive(inst, md, images); err != nil {
+			return err
+		}
+	}
+	newdoc.ByteSize = int64(len(body))
+	newdoc.MD5Sum = nil
+	fillMetadata(newdoc, olddoc, schemaSpecs, content)
+
+	file, err := inst.VFS().CreateFile(newdoc, olddoc)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(body)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if olddoc != nil {
+		purgeAllSteps(inst, olddoc.DocID)
+	}
+	for _, img := range oldImages {
+		img.seen = false
+		img.ToRemove = true
+	}
+	cleanImages(inst, oldImages)
+	return nil
+}
+
+// detectOffice returns the parser for the given office document, or nil if
+// the format is not supported.
+func detectOffice(zr *zip.Reader) officeParser {
+	for _, f := range zr.File {
+		switch f.Name {
+		case "word/document.xml":
+			return parseDOCX
+		case "mimetype":
+			mime, err := readZipFile(zr, f.Name, 256)
+			if err == nil && strings.HasPrefix(string(mime), odtMime) {
+				return parseODT
+			}
+		}
+	}
+	return nil
+}
+
+func isZip(buf []byte) bool {
+	return len(buf) >= 4 && buf[0] == 'P' && buf[1] == 'K' && buf[2] == 3 && buf[3] == 4
+}
+
+// readZipFile returns the content of a file in a zip archive, or
+// errNotInArchive if there is no file with this name.
+func readZipFile(zr *zip.Reader, name string, limit int64) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > uint64(limit) {
+			return nil, vfs.ErrFileTooBig
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, limit))
+		if errc := rc.Close(); err == nil && errc != nil {
+			err = errc
+		}
+		return data, err
+	}
+	return nil, errNotInArchive
+}
+
+var errNotInArchive = errors.New("file not found in the archive")
+
+// officeDoc returns the doc node for the given blocks.
+func officeDoc(schema *model.Schema, blocks []*model.Node) (*model.Node, error) {
+	if len(blocks) == 0 {
+		paragraph, err := schema.Node("paragraph", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		blocks = []*model.Node{paragraph}
+	}
+	return schema.Node("doc", nil, blocks)
+}
+
+// xmlElement is a node of an XML tree. The text nodes have no name.
+type xmlElement struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []*xmlElement
+	Text     string
+}
+
+// parseXML reads an XML document, and returns its root element. The
+// namespaces are not checked, only the local names are used.
+func parseXML(data []byte) (*xmlElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := &xmlElement{}
+	stack := []*xmlElement{root}
+	for {
+		tok, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &xmlElement{Name: t.Name, Attrs: t.Attr}
+			parent.Children = append(parent.Children, el)
+			stack = append(stack, el)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &xmlElement{Text: string(t)})
+		}
+	}
+	for _, el := range root.Children {
+		if el.Name.Local != "" {
+			return el, nil
+		}
+	}
+	return nil, ErrInvalidImportFormat
+}
+
+// isText returns true for the text nodes.
+func (e *xmlElement) isText() bool {
+	return e.Name.Local == ""
+}
+
+// attr returns the value of the attribute with the given local name.
+func (e *xmlElement) attr(local string) string {
+	if e == nil {
+		return ""
+	}
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// child returns the first child element with the given local name.
+func (e *xmlElement) child(local string) *xmlElement {
+	if e == nil {
+		return nil
+	}
+	for _, child := range e.Children {
+		if child.Name.Local == local {
+			return child
+		}
+	}
+	return nil
+}
+
+// find returns the first element with the given local name in the
+// descendants of this element.
+func (e *xmlElement) find(local string) *xmlElement {
+	if e == nil {
+		return nil
+	}
+	for _, child := range e.Children {
+		if child.Name.Local == local {
+			return child
+		}
+		if found := child.find(local); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// path returns the element found by following the given local names.
+func (e *xmlElement) path(locals ...string) *xmlElement {
+	for _, local := range locals {
+		e = e.child(local)
+	}
+	return e
+}
+
+// officeCell is a cell of a table from an office document.
+type officeCell struct {
+	header  bool
+	colspan int
+	rowspan int
+	content []*model.Node
+}
+
+// officeList is a list being built from the paragraphs of an office
+// document.
+type officeList struct {
+	ordered bool
+	items   [][]*model.Node
+}
+
+// officeBuilder builds the blocks of a note from an office document. The
+// list items are given as flat paragraphs with a level, like in DOCX, and the
+// builder nests them in the lists. The first error is kept, and the next
+// calls do nothing.
+type officeBuilder struct {
+	schema *model.Schema
+	blocks []*model.Node
+	lists  []*officeList
+	err    error
+}
+
+func newOfficeBuilder(schema *model.Schema) *officeBuilder {
+	return &officeBuilder{schema: schema}
+}
+
+// node creates a node, or returns nil if it fails.
+func (b *officeBuilder) node(name string, attrs map[string]interface{}, content []*model.Node) *model.Node {
+	if b.err != nil {
+		return nil
+	}
+	node, err := b.schema.Node(name, attrs, content)
+	if err != nil {
+		b.err = err
+		return nil
+	}
+	return node
+}
+
+// add appends a block at the top level.
+func (b *officeBuilder) add(node *model.Node) {
+	b.closeLists(0)
+	if node != nil {
+		b.blocks = append(b.blocks, node)
+	}
+}
+
+// addListItem starts a new list item with a paragraph. The level starts at
+// 0 for the items of a list that is not nested.
+func (b *officeBuilder) addListItem(level int, ordered bool, paragraph *model.Node) {
+	if paragraph == nil {
+		return
+	}
+	level = min(max(level, 0), 8)
+	b.closeLists(level + 1)
+	if len(b.lists) == level+1 && b.lists[level].ordered != ordered {
+		b.closeLists(level)
+	}
+	for len(b.lists) < level+1 {
+		if n := len(b.lists); n > 0 && len(b.lists[n-1].items) == 0 {
+			// A nested list must be inside an item
+			empty := b.node("paragraph", nil, nil)
+			b.lists[n-1].items = append(b.lists[n-1].items, []*model.Node{empty})
+		}
+		b.lists = append(b.lists, &officeList{ordered: ordered})
+	}
+	list := b.lists[level]
+	list.items = append(list.items, []*model.Node{paragraph})
+}
+
+// addToListItem adds a paragraph or an image to the current list item, or at
+// the top level if there is no list.
+func (b *officeBuilder) addToListItem(node *model.Node) {
+	if node == nil {
+		return
+	}
+	if len(b.lists) == 0 {
+		b.add(node)
+		return
+	}
+	list := b.lists[len(b.lists)-1]
+	last := len(list.items) - 1
+	list.items[last] = append(list.items[last], node)
+}
+
+// inList returns true if a list item can receive more content.
+func (b *officeBuilder) inList() bool {
+	return len(b.lists) > 0
+}
+
+// closeLists closes the lists until there are only n lists opened.
+func (b *officeBuilder) closeLists(n int) {
+	for len(b.lists) > n {
+		list := b.lists[len(b.lists)-1]
+		b.lists = b.lists[:len(b.lists)-1]
+		items := make([]*model.Node, 0, len(list.items))
+		for _, content := range list.items {
+			items = append(items, b.node("listItem", nil, content))
+		}
+		name := "bulletList"
+		if list.ordered {
+			name = "orderedList"
+		}
+		node := b.node(name, nil, items)
+		if node == nil {
+			continue
+		}
+		if len(b.lists) == 0 {
+			b.blocks = append(b.blocks, node)
+		} else {
+			parent := b.lists[len(b.lists)-1]
+			last := len(parent.items) - 1
+			parent.items[last] = append(parent.items[last], node)
+		}
+	}
+}
+
+// paragraph creates a paragraph, or a heading if level is between 1 and 6.
+func (b *officeBuilder) paragraph(level int, inline []*model.Node) *model.Node {
+	if level > 0 {
+		level = min(level, 6)
+		return b.node("heading", map[string]interface{}{"level": float64(level)}, inline)
+	}
+	return b.node("paragraph", nil, inline)
+}
+
+// image creates the block for an image of the note.
+func (b *officeBuilder) image(img *Image) *model.Node {
+	media := b.node("media", map[string]interface{}{
+		"url":  img.ID(),
+		"alt":  img.Name,
+		"id":   "",
+		"type": "external",
+	}, nil)
+	return b.node("mediaSingle", map[string]interface{}{"layout": "center"}, []*model.Node{media})
+}
+
+// table adds a table with the given cells.
+func (b *officeBuilder) table(rows [][]*officeCell) {
+	var nodes []*model.Node
+	for _, row := range rows {
+		var cells []*model.Node
+		for _, cell := range row {
+			content := cell.content
+			if len(content) == 0 {
+				content = []*model.Node{b.node("paragraph", nil, nil)}
+			}
+			// The numbers are float64, like in the JSON of the notes
+			attrs := map[string]interface{}{}
+			if cell.colspan > 1 {
+				attrs["colspan"] = float64(cell.colspan)
+			}
+			if cell.rowspan > 1 {
+				attrs["rowspan"] = float64(cell.rowspan)
+			}
+			name := "tableCell"
+			if cell.header {
+				name = "tableHeader"
+			}
+			cells = append(cells, b.node(name, attrs, content))
+		}
+		if len(cells) > 0 {
+			nodes = append(nodes, b.node("tableRow", nil, cells))
+		}
+	}
+	if len(nodes) > 0 {
+		b.add(b.node("table", nil, nodes))
+	}
+}
+
+// build returns the blocks, after closing the lists.
+func (b *officeBuilder) build() ([]*model.Node, error) {
+	b.closeLists(0)
+	return b.blocks, b.err
+}
+
+// officeInline collects the inline content of a paragraph.
+type officeInline struct {
+	schema *model.Schema
+	nodes  []*model.Node
+	images []*Image
+}
+
+func newOfficeInline(schema *model.Schema) *officeInline {
+	return &officeInline{schema: schema}
+}
+
+// text adds a text with the marks for the given style.
+func (in *officeInline) text(text string, style inlineStyle) {
+	if text == "" {
+		return
+	}
+	in.nodes = append(in.nodes, in.schema.Text(text, styleMarks(in.schema, style)))
+}
+
+// hardBreak adds a line break.
+func (in *officeInline) hardBreak() {
+	if node, err := in.schema.Node("hardBreak", nil, nil); err == nil {
+		in.nodes = append(in.nodes, node)
+	}
+}
+
+// image adds an image: the images are blocks in the notes, they will be
+// added after the paragraph.
+func (in *officeInline) image(img *Image) {
+	if img != nil {
+		in.images = append(in.images, img)
+	}
+}
+
+// isBlank returns true if there is no text in the paragraph.
+func (in *officeInline) isBlank() bool {
+	for _, node := range in.nodes {
+		if node.IsText() && strings.TrimSpace(*node.Text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// endsWithSpace returns true if the paragraph is empty or if its last
+// character is a space.
+func (in *officeInline) endsWithSpace() bool {
+	if len(in.nodes) == 0 {
+		return true
+	}
+	last := in.nodes[len(in.nodes)-1]
+	return !last.IsText() || strings.HasSuffix(*last.Text, " ")
+}
+
+// styleMarks returns the marks for an inline style. It is the reverse of
+// markStyle.
+func styleMarks(schema *model.Schema, style inlineStyle) []*model.Mark {
+	var marks []*model.Mark
+	add := func(name string, attrs map[string]interface{}) {
+		if typ, err := schema.MarkType(name); err == nil {
+			marks = append(marks, typ.Create(attrs))
+		}
+	}
+	if style.Link != "" {
+		add("link", map[string]interface{}{"href": style.Link})
+	}
+	if style.Bold {
+		add("strong", nil)
+	}
+	if style.Italic {
+		add("em", nil)
+	}
+	if style.Underline {
+		add("underline", nil)
+	}
+	if style.Strike {
+		add("strike", nil)
+	}
+	if style.Script != "" {
+		add("subsup", map[string]interface{}{"type": style.Script})
+	}
+	if style.Color != "" && style.Link == "" {
+		add("textColor", map[string]interface{}{"color": "#" + strings.ToLower(hexColor(style.Color))})
+	}
+	return model.MarkSetFrom(marks)
+}
+
+// officeImages caches the images of an office document, as an image can be
+// used several times.
+type officeImages struct {
+	zr     *zip.Reader
+	save   officeImageSaver
+	images map[string]*Image
+}
+
+func newOfficeImages(zr *zip.Reader, save officeImageSaver) *officeImages {
+	return &officeImages{zr: zr, save: save, images: make(map[string]*Image)}
+}
+
+// get returns the image for the given path in the archive, or nil if it
+// cannot be imported.
+func (oi *officeImages) get(name string) (*Image, error) {
+	if img, ok := oi.images[name]; ok {
+		return img, nil
+	}
+	oi.images[name] = nil
+	mime := filetype.ByExtension(path.Ext(name))
+	if !importableImageMimes[mime] {
+		return nil, nil
+	}
+	data, err := readZipFile(oi.zr, name, MaxImageWeight)
+	if errors.Is(err, errNotInArchive) || errors.Is(err, vfs.ErrFileTooBig) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	img, err := oi.save(path.Base(name), mime, data)
+	if err != nil {
+		return nil, err
+	}
+	oi.images[name] = img
+	return img, nil
+}
+
+// officeInt parses an integer attribute.
+func officeInt(value string, defaultValue int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return n
+	}
+	return defaultValue
+}
@@ -0,0 +1,155 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func importFixture(t *testing.T, render exportRenderer) (*model.Node, []*Image) {
+	content, images := exportFixture(t)
+	out, err := render("My note", content, images)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	parse := detectOffice(zr)
+	require.NotNil(t, parse)
+
+	specs := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+	var saved []*Image
+	save := func(name, mime string, data []byte) (*Image, error) {
+		img := &Image{DocID: "note-id/imported", Name: name, Mime: mime}
+		saved = append(saved, img)
+		return img, nil
+	}
+	blocks, err := parse(zr, schema, save)
+	require.NoError(t, err)
+	doc, err := officeDoc(schema, blocks)
+	require.NoError(t, err)
+	return doc, saved
+}
+
+func assertImported(t *testing.T, doc *model.Node, images []*Image) {
+	types := make(map[string]int)
+	walkNodes(doc, func(node *model.Node) {
+		types[node.Type.Name]++
+	})
+	assert.Equal(t, 2, types["heading"]) // The title and the heading
+	assert.Equal(t, 1, types["orderedList"])
+	assert.GreaterOrEqual(t, types["bulletList"], 1)
+	assert.Equal(t, 1, types["table"])
+	assert.Equal(t, 2, types["tableRow"])
+	assert.Equal(t, 1, types["mediaSingle"])
+
+	md := markdownSerializer(images).Serialize(doc)
+	assert.Contains(t, md, "# A heading")
+	assert.Contains(t, md, "**bold**")
+	assert.Contains(t, md, "[a link](https://cozy.io/)")
+	assert.Contains(t, md, "(and a € sign)")
+	assert.Contains(t, md, "{.tableCell colspan=2}")
+	assert.Contains(t, md, "![image1.png](note-id/imported)")
+
+	require.Len(t, images, 1)
+	assert.Equal(t, "image/png", images[0].Mime)
+}
+
+func TestImportDOCX(t *testing.T) {
+	doc, images := importFixture(t, renderDOCX)
+	assertImported(t, doc, images)
+}
+
+func TestImportODT(t *testing.T) {
+	doc, images := importFixture(t, renderODT)
+	assertImported(t, doc, images)
+}
+
+func TestOfficeBuilderNestsLists(t *testing.T) {
+	specs := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+
+	b := newOfficeBuilder(schema)
+	item := func(text string) *model.Node {
+		return b.paragraph(0, []*model.Node{schema.Text(text)})
+	}
+	b.addListItem(0, false, item("one"))
+	b.addListItem(2, true, item("deep"))
+	b.addListItem(0, false, item("two"))
+	b.addListItem(0, true, item("three"))
+	b.add(item("after"))
+	blocks, err := b.build()
+	require.NoError(t, err)
+	doc, err := officeDoc(schema, blocks)
+	require.NoError(t, err)
+
+	md := markdownSerializer(nil).Serialize(doc)
+	assert.Equal(t, "* one\n\n  1. \n\n     1. deep\n\n* two\n\n1. three\n\nafter", md)
+}
+
+func TestImportDOCXStylesAndMergedCells(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"word/styles.xml": `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+			`<w:style w:type="paragraph" w:styleId="Titre2"><w:name w:val="heading 2"/></w:style></w:styles>`,
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:pPr><w:pStyle w:val="Titre2"/></w:pPr><w:r><w:t>Agenda</w:t></w:r></w:p>` +
+			`<w:p/>` +
+			`<w:tbl>` +
+			`<w:tr><w:trPr><w:tblHeader/></w:trPr><w:tc><w:p><w:r><w:t>A</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>B</w:t></w:r></w:p></w:tc></w:tr>` +
+			`<w:tr><w:tc><w:tcPr><w:vMerge w:val="restart"/></w:tcPr><w:p><w:r><w:t>Tall</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p></w:tc></w:tr>` +
+			`<w:tr><w:tc><w:tcPr><w:vMerge/></w:tcPr><w:p/></w:tc><w:tc><w:p><w:r><w:t>2</w:t></w:r></w:p></w:tc></w:tr>` +
+			`</w:tbl></w:body></w:document>`,
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	specs := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+	blocks, err := parseDOCX(zr, schema, nil)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	assert.Equal(t, "heading", blocks[0].Type.Name)
+	assert.Equal(t, float64(2), blocks[0].Attrs["level"])
+
+	md := markdownSerializer(nil).Serialize(blocks[1])
+	assert.Contains(t, md, "{.tableHeader}\n\nA")
+	assert.Contains(t, md, "{.tableCell rowspan=2}\n\nTall")
+	assert.Equal(t, 3, blocks[1].ChildCount())
+	row, err := blocks[1].Child(2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, row.ChildCount())
+}
+
+func TestTextDoc(t *testing.T) {
+	specs := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+
+	doc, err := textDoc(schema, "\r\nHello Bob,\r\n\r\nHere is the receipt.  \r\n-- \r\nAlice\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 5, doc.ChildCount())
+	md := markdownSerializer(nil).Serialize(doc)
+	assert.Contains(t, md, "Hello Bob,")
+	assert.Contains(t, md, "Here is the receipt.")
+	assert.Contains(t, md, "Alice")
+
+	doc, err = textDoc(schema, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, doc.ChildCount())
+}
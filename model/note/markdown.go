@@ -150,6 +150,7 @@ func markdownSerializer(images []*Image) *markdown.Serializer {
 		"strike":      {Open: "~~", Close: "~~", ExpelEnclosingWhitespace: true},
 		"indentation": {Open: "    ", Close: "", ExpelEnclosingWhitespace: true},
 		"breakout":    {Open: "", Close: "", ExpelEnclosingWhitespace: true},
+		"annotation":  {Open: "", Close: "", ExpelEnclosingWhitespace: true},
 		"underline":   {Open: "[", Close: "]{.underlined}", ExpelEnclosingWhitespace: true},
 		"subsup": {
 			Open: "[",
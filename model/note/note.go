@@ -11,7 +11,6 @@ import (
 	"io"
 	"os"
 	"path"
-	"runtime"
 	"strings"
 	"time"
 
@@ -731,20 +730,31 @@ func Update(inst *instance.Instance, fileID string) error {
 		return err
 	}
 
-	oldVersion, _ := old.Metadata["version"].(float64)
+	file, err := persist(inst, doc, old)
+	if err != nil {
+		return err
+	}
+	maybeTakePeriodicSnapshot(inst, doc, file)
+	return nil
+}
+
+// persist writes the changes on a note to its file if there are some, and
+// returns the up-to-date file.
+func persist(inst *instance.Instance, doc *Document, old *vfs.FileDoc) (*vfs.FileDoc, error) {
+	oldVersion, _ := versionFromMetadata(old)
 	if doc.Title == old.Metadata["title"] &&
-		doc.Version == int64(oldVersion) &&
+		doc.Version == oldVersion &&
 		consts.NoteMimeType == old.Mime {
 		// Nothing to do
-		return nil
+		return old, nil
 	}
 
-	_, err = writeFile(inst, doc, old)
+	file, err := writeFile(inst, doc, old)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	purgeOldSteps(inst, fileID)
-	return nil
+	purgeOldSteps(inst, old.ID())
+	return file, nil
 }
 
 // UpdateSchema updates the schema of a note, and invalidates the previous steps.
@@ -766,27 +776,7 @@ func UpdateSchema(inst *instance.Instance, file *vfs.FileDoc, schema map[string]
 		return nil, err
 	}
 
-	// Purging all steps can take a few seconds, so it is better to do that in
-	// a goroutine to avoid blocking the user that wants to read their note.
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				var err error
-				switch r := r.(type) {
-				case error:
-					err = r
-				default:
-					err = fmt.Errorf("%v", r)
-				}
-				stack := make([]byte, 4<<10) // 4 KB
-				length := runtime.Stack(stack, false)
-				log := inst.Logger().WithNamespace("note").WithField("panic", true)
-				log.Errorf("PANIC RECOVER %s: %s", err.Error(), stack[:length])
-			}
-		}()
-		purgeAllSteps(inst, doc.ID())
-	}()
-
+	purgeAllStepsInBackground(inst, doc.ID())
 	return updated, nil
 }
 
@@ -274,6 +274,27 @@ const defaultSchemaString = `
           "unsupported": {}
         }
       }
+    ],
+    [
+      "annotation",
+      {
+        "attrs": {
+          "annotationType": {
+            "default": "inlineComment"
+          },
+          "id": {
+            "default": ""
+          }
+        },
+        "excludes": "",
+        "group": "annotation",
+        "inclusive": false,
+        "parseDOM": [
+          {
+            "tag": "span[data-mark-type=\"annotation\"]"
+          }
+        ]
+      }
     ]
   ],
   "nodes": [
@@ -334,7 +355,7 @@ const defaultSchemaString = `
       {
         "content": "inline*",
         "group": "block",
-        "marks": "strong code em link strike subsup textColor typeAheadQuery underline unsupportedMark unsupportedNodeAttribute",
+        "marks": "strong code em link strike subsup textColor typeAheadQuery underline unsupportedMark unsupportedNodeAttribute annotation",
         "parseDOM": [
           {
             "tag": "p"
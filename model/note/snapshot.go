@@ -0,0 +1,491 @@
+package note
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	// SnapshotManual is the kind of the snapshots asked by the user.
+	SnapshotManual = "manual"
+	// SnapshotPeriodic is the kind of the snapshots taken regularly by the
+	// stack when the note is persisted.
+	SnapshotPeriodic = "periodic"
+	// SnapshotBeforeRestore is the kind of the snapshots taken just before
+	// restoring another snapshot, to make it possible to undo the restore.
+	SnapshotBeforeRestore = "before-restore"
+
+	// snapshotTag is the tag added to the versions of the note file used for
+	// the snapshots, so that they are not cleaned by the VFS.
+	snapshotTag = "note-snapshot"
+
+	snapshotInterval     = 1 * time.Hour
+	maxPeriodicSnapshots = 48
+)
+
+// Snapshot is a saved state of a note, that can be restored later. The
+// content is kept in a version of the note file (io.cozy.files.versions) with
+// the same identifier, and this document adds the information for the notes.
+type Snapshot struct {
+	DocID     string    `json:"_id,omitempty"`
+	DocRev    string    `json:"_rev,omitempty"`
+	NoteID    string    `json:"note_id"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name,omitempty"`
+	Title     string    `json:"title"`
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ID returns the snapshot qualified identifier
+func (s *Snapshot) ID() string { return s.DocID }
+
+// Rev returns the snapshot revision
+func (s *Snapshot) Rev() string { return s.DocRev }
+
+// DocType returns the snapshot type
+func (s *Snapshot) DocType() string { return consts.NotesSnapshots }
+
+// Clone implements couchdb.Doc
+func (s *Snapshot) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID changes the snapshot qualified identifier
+func (s *Snapshot) SetID(id string) { s.DocID = id }
+
+// SetRev changes the snapshot revision
+func (s *Snapshot) SetRev(rev string) { s.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (s *Snapshot) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (s *Snapshot) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (s *Snapshot) Relationships() jsonapi.RelationshipMap {
+	return jsonapi.RelationshipMap{
+		"version": jsonapi.Relationship{
+			Data: couchdb.DocReference{
+				ID:   s.DocID,
+				Type: consts.FilesVersions,
+			},
+		},
+	}
+}
+
+// DiffPart is a part of the difference between the texts of two states of a
+// note: some lines that have been deleted, inserted, or kept.
+type DiffPart struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// ListSnapshots returns the snapshots of a note, the oldest first.
+func ListSnapshots(inst *instance.Instance, file *vfs.FileDoc) ([]*Snapshot, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	return getSnapshots(inst, file.ID())
+}
+
+// getSnapshots is the same as ListSnapshots, but with the notes lock already
+// acquired.
+func getSnapshots(db prefixer.Prefixer, fileID string) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	req := couchdb.AllDocsRequest{
+		Limit:    1000,
+		StartKey: startkey(fileID),
+		EndKey:   endkey(fileID),
+	}
+	if err := couchdb.GetAllDocs(db, consts.NotesSnapshots, &req, &snapshots); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return snapshots, nil
+		}
+		return nil, err
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+func getSnapshot(db prefixer.Prefixer, fileID, snapshotID string) (*Snapshot, error) {
+	if !strings.HasPrefix(snapshotID, startkey(fileID)) {
+		return nil, ErrSnapshotNotFound
+	}
+	var snapshot Snapshot
+	if err := couchdb.GetDoc(db, consts.NotesSnapshots, snapshotID, &snapshot); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// snapshotDocument returns the note document saved in the version of the
+// file for this snapshot.
+func snapshotDocument(inst *instance.Instance, file *vfs.FileDoc, snapshot *Snapshot) (*Document, error) {
+	version, err := vfs.FindVersion(inst, snapshot.DocID)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	tmp := file.Clone().(*vfs.FileDoc)
+	tmp.Metadata = version.Metadata
+	return fromMetadata(tmp)
+}
+
+// CreateSnapshot takes a snapshot of the current state of a note. The changes
+// not yet persisted are first written to the file, and the content of the
+// file is then kept in a version.
+func CreateSnapshot(inst *instance.Instance, file *vfs.FileDoc, name string) (*Snapshot, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	return createSnapshot(inst, doc, file, SnapshotManual, name)
+}
+
+func createSnapshot(inst *instance.Instance, doc *Document, file *vfs.FileDoc, kind, name string) (*Snapshot, error) {
+	file, err := persist(inst, doc, file)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := inst.VFS()
+	version := vfs.NewVersion(file)
+	if existing, err := getSnapshot(inst, file.ID(), version.DocID); err == nil {
+		// The same state of the note has already been saved
+		if kind == SnapshotManual && (existing.Kind != SnapshotManual || name != "") {
+			existing.Kind = kind
+			existing.Name = name
+			if err := couchdb.UpdateDoc(inst, existing); err != nil {
+				return nil, err
+			}
+		}
+		return existing, nil
+	}
+
+	version.Tags = append(version.Tags, snapshotTag)
+	if old, err := vfs.FindVersion(inst, version.DocID); err == nil {
+		// The version already exists, we just need to protect it
+		old.Tags = append(old.Tags, snapshotTag)
+		if err := couchdb.UpdateDoc(inst, old); err != nil {
+			return nil, err
+		}
+	} else {
+		content, err := fs.OpenFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.ImportFileVersion(version, content); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot := &Snapshot{
+		DocID:     version.DocID,
+		NoteID:    file.ID(),
+		Kind:      kind,
+		Name:      name,
+		Title:     doc.Title,
+		Version:   doc.Version,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := couchdb.CreateNamedDocWithDB(inst, snapshot); err != nil {
+		return nil, err
+	}
+	if kind == SnapshotPeriodic {
+		prunePeriodicSnapshots(inst, file)
+	}
+	return snapshot, nil
+}
+
+// maybeTakePeriodicSnapshot takes a snapshot of the note if the last one is
+// old enough.
+func maybeTakePeriodicSnapshot(inst *instance.Instance, doc *Document, file *vfs.FileDoc) {
+	snapshots, err := getSnapshots(inst, file.ID())
+	if err != nil {
+		return
+	}
+	if len(snapshots) > 0 {
+		last := snapshots[len(snapshots)-1]
+		if last.Version == doc.Version || time.Since(last.CreatedAt) < snapshotInterval {
+			return
+		}
+	}
+	if _, err := createSnapshot(inst, doc, file, SnapshotPeriodic, ""); err != nil {
+		inst.Logger().WithNamespace("notes").
+			Warnf("Cannot take a snapshot of note %s: %s", file.ID(), err)
+	}
+}
+
+// prunePeriodicSnapshots removes the oldest periodic snapshots when there are
+// too many of them. The manual snapshots are kept until the user deletes them.
+func prunePeriodicSnapshots(inst *instance.Instance, file *vfs.FileDoc) {
+	snapshots, err := getSnapshots(inst, file.ID())
+	if err != nil {
+		return
+	}
+	var periodics []*Snapshot
+	for _, s := range snapshots {
+		if s.Kind == SnapshotPeriodic {
+			periodics = append(periodics, s)
+		}
+	}
+	for i := 0; i < len(periodics)-maxPeriodicSnapshots; i++ {
+		if err := deleteSnapshot(inst, file, periodics[i]); err != nil {
+			inst.Logger().WithNamespace("notes").
+				Warnf("Cannot delete snapshot %s: %s", periodics[i].DocID, err)
+		}
+	}
+}
+
+// DeleteSnapshot removes a snapshot, and the version of the file that has
+// its content.
+func DeleteSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID string) error {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	snapshot, err := getSnapshot(inst, file.ID(), snapshotID)
+	if err != nil {
+		return err
+	}
+	return deleteSnapshot(inst, file, snapshot)
+}
+
+func deleteSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshot *Snapshot) error {
+	if version, err := vfs.FindVersion(inst, snapshot.DocID); err == nil {
+		if err := inst.VFS().CleanOldVersion(file.ID(), version); err != nil {
+			return err
+		}
+	}
+	return couchdb.DeleteDoc(inst, snapshot)
+}
+
+// GetSnapshotText returns the text of a note for the given snapshot.
+func GetSnapshotText(inst *instance.Instance, file *vfs.FileDoc, snapshotID string) (string, error) {
+	snapshot, err := getSnapshot(inst, file.ID(), snapshotID)
+	if err != nil {
+		return "", err
+	}
+	doc, err := snapshotDocument(inst, file, snapshot)
+	if err != nil {
+		return "", err
+	}
+	return doc.Text()
+}
+
+// DiffSnapshot compares the text of a snapshot with the text of another
+// snapshot, or with the current state of the note if otherID is empty. The
+// differences are computed line by line.
+func DiffSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID, otherID string) ([]DiffPart, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	snapshot, err := getSnapshot(inst, file.ID(), snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	before, err := snapshotDocument(inst, file, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *Document
+	if otherID == "" {
+		after, err = get(inst, file)
+	} else {
+		var other *Snapshot
+		other, err = getSnapshot(inst, file.ID(), otherID)
+		if err == nil {
+			after, err = snapshotDocument(inst, file, other)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	beforeText, err := before.Text()
+	if err != nil {
+		return nil, err
+	}
+	afterText, err := after.Text()
+	if err != nil {
+		return nil, err
+	}
+	return diffLines(beforeText, afterText), nil
+}
+
+func diffLines(before, after string) []DiffPart {
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(before, after)
+	diffs := dmp.DiffMain(a, b, false)
+	diffs = dmp.DiffCharsToLines(diffs, lines)
+	parts := make([]DiffPart, 0, len(diffs))
+	for _, d := range diffs {
+		var op string
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = "delete"
+		case diffmatchpatch.DiffInsert:
+			op = "insert"
+		default:
+			op = "equal"
+		}
+		parts = append(parts, DiffPart{Op: op, Text: d.Text})
+	}
+	return parts
+}
+
+// RestoreSnapshot replaces the content of a note by the content of a
+// snapshot. A snapshot of the current state is taken before, so that the
+// restore can be undone.
+//
+// When the schema has not changed, the restore is made via a step that
+// replaces the whole document: the editors will receive it like the other
+// steps, and they can rebase their local changes on it. Else, the steps are
+// invalidated like for a change of schema, and the editors will have to reload
+// the note.
+func RestoreSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID string) (*vfs.FileDoc, error) {
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	snapshot, err := getSnapshot(inst, file.ID(), snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := snapshotDocument(inst, file, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := createSnapshot(inst, doc, file, SnapshotBeforeRestore, ""); err != nil {
+		return nil, err
+	}
+	file, err = inst.VFS().FileByID(file.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Title != restored.Title {
+		doc.Title = restored.Title
+		publishUpdatedTitle(inst, file.ID(), doc.Title, stackSessionID)
+	}
+
+	if !sameSchema(doc.SchemaSpec, restored.SchemaSpec) {
+		restored.Version = doc.Version + 1
+		updated, err := writeFile(inst, restored, file)
+		if err != nil {
+			return nil, err
+		}
+		publishRestored(inst, restored, snapshot)
+		purgeAllStepsInBackground(inst, file.ID())
+		return updated, nil
+	}
+
+	content, err := restored.Content()
+	if err != nil {
+		return nil, err
+	}
+	current, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	slice := model.NewSlice(content.Content, 0, 0)
+	step := transform.NewReplaceStep(0, current.Content.Size, slice, false)
+	if err := applyStackStep(inst, doc, step); err != nil {
+		return nil, err
+	}
+	publishRestored(inst, doc, snapshot)
+	return doc.asFile(inst, file), nil
+}
+
+func sameSchema(a, b map[string]interface{}) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	if erra != nil || errb != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return string(ja) == string(jb)
+}
+
+func publishRestored(inst *instance.Instance, doc *Document, snapshot *Snapshot) {
+	event := Event{
+		"doctype":     consts.NotesSnapshots,
+		"action":      "restored",
+		"snapshot_id": snapshot.DocID,
+		"version":     doc.Version,
+		"sessionID":   stackSessionID,
+	}
+	event.SetID(doc.ID())
+	event.publish(inst)
+}
+
+// purgeAllStepsInBackground purges the steps of a note in a goroutine, as it
+// can take a few seconds, and it is better to not block the user that wants to
+// read their note.
+func purgeAllStepsInBackground(inst *instance.Instance, fileID string) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				var err error
+				switch r := r.(type) {
+				case error:
+					err = r
+				default:
+					err = fmt.Errorf("%v", r)
+				}
+				stack := make([]byte, 4<<10) // 4 KB
+				length := runtime.Stack(stack, false)
+				log := inst.Logger().WithNamespace("note").WithField("panic", true)
+				log.Errorf("PANIC RECOVER %s: %s", err.Error(), stack[:length])
+			}
+		}()
+		purgeAllSteps(inst, fileID)
+	}()
+}
+
+var _ jsonapi.Object = &Snapshot{}
@@ -0,0 +1,26 @@
+package note
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	before := "Title\nFirst line\nSecond line\n"
+	after := "Title\nFirst line\nA new line\nSecond line\n"
+	parts := diffLines(before, after)
+	assert.Equal(t, []DiffPart{
+		{Op: "equal", Text: "Title\nFirst line\n"},
+		{Op: "insert", Text: "A new line\n"},
+		{Op: "equal", Text: "Second line\n"},
+	}, parts)
+
+	parts = diffLines(after, "Title\n")
+	assert.Equal(t, []DiffPart{
+		{Op: "equal", Text: "Title\n"},
+		{Op: "delete", Text: "First line\nA new line\nSecond line\n"},
+	}, parts)
+
+	assert.Empty(t, diffLines("", ""))
+}
@@ -2,6 +2,7 @@ package note
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -210,6 +211,43 @@ func apply(inst *instance.Instance, doc *Document, steps []Step) error {
 	return nil
 }
 
+// mapRange takes a range of positions in the given version of the note, and
+// maps it to the current version of the document by following the steps that
+// have been applied since then. It allows a client to send positions computed
+// before its local steps have been rebased on the steps of the other editors.
+func mapRange(inst *instance.Instance, doc *Document, version int64, from, to int) (int, int, error) {
+	if version > doc.Version {
+		return 0, 0, ErrCannotApply
+	}
+	if version < doc.Version {
+		schema, err := doc.Schema()
+		if err != nil {
+			return 0, 0, ErrInvalidSchema
+		}
+		steps, err := getSteps(inst, doc.ID(), version)
+		if errors.Is(err, ErrTooOld) {
+			// The steps to rebase the positions have been purged.
+			return 0, 0, ErrInvalidAnchor
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, s := range steps {
+			step, err := transform.StepFromJSON(schema, s)
+			if err != nil {
+				return 0, 0, ErrInvalidSteps
+			}
+			mapping := step.GetMap()
+			from = mapping.Map(from, 1)
+			to = mapping.Map(to, -1)
+		}
+	}
+	if from >= to {
+		return 0, 0, ErrInvalidAnchor
+	}
+	return from, to, nil
+}
+
 func saveSteps(inst *instance.Instance, steps []Step) error {
 	olds := make([]interface{}, len(steps))
 	news := make([]interface{}, len(steps))
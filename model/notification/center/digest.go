@@ -0,0 +1,121 @@
+package center
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/mail"
+)
+
+// maxDigestNotifications is the maximal number of notifications listed in a
+// digest.
+const maxDigestNotifications = 200
+
+// SendDigest sends a mail with the notifications that have been kept for the
+// digest since the previous one. At most maxDigestNotifications are listed,
+// and the next digest starts with the other ones.
+func SendDigest(inst *instance.Instance) error {
+	prefs, err := GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	if prefs.Digest == nil {
+		return nil
+	}
+
+	now := time.Now()
+	since := now.Add(-digestPeriod(prefs.Digest.Frequency))
+	if prefs.LastDigest != nil {
+		since = *prefs.LastDigest
+	}
+	var notifs []*notification.Notification
+	req := &couchdb.FindRequest{
+		UseIndex: "by-digest",
+		Selector: mango.And(
+			mango.Equal("digest", true),
+			mango.Gt("created_at", since),
+			mango.Lte("created_at", now),
+		),
+		Sort: mango.SortBy{
+			{Field: "digest", Direction: mango.Asc},
+			{Field: "created_at", Direction: mango.Asc},
+		},
+		Limit: maxDigestNotifications,
+	}
+	err = couchdb.FindDocs(inst, consts.Notifications, req, &notifs)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+
+	if len(notifs) > 0 {
+		email := buildDigestMail(inst, notifs)
+		msg, err := job.NewMessage(email)
+		if err != nil {
+			return err
+		}
+		_, err = job.System().PushJob(inst, &job.JobRequest{
+			WorkerType: "sendmail",
+			Message:    msg,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// When the digest is full, the next one starts after the last listed
+	// notification, so that the other ones are not lost.
+	last := now
+	if len(notifs) == maxDigestNotifications {
+		last = notifs[len(notifs)-1].CreatedAt
+	}
+	prefs.LastDigest = &last
+	return couchdb.UpdateDoc(inst, prefs)
+}
+
+func digestPeriod(frequency string) time.Duration {
+	if frequency == DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func buildDigestMail(inst *instance.Instance, notifs []*notification.Notification) *mail.Options {
+	title := inst.Translate("Notifications Digest Subject", len(notifs))
+	var text, rich strings.Builder
+	text.WriteString(title + "\n\n")
+	fmt.Fprintf(&rich, "<p>%s</p><ul>", html.EscapeString(title))
+	for _, n := range notifs {
+		text.WriteString("- " + n.Title + "\n")
+		rich.WriteString("<li><strong>" + html.EscapeString(n.Title) + "</strong>")
+		if n.Message != "" {
+			text.WriteString("  " + n.Message + "\n")
+			rich.WriteString("<br>" + html.EscapeString(n.Message))
+		}
+		if n.Slug != "" {
+			link := inst.SubDomain(n.Slug).String()
+			text.WriteString("  " + link + "\n")
+			fmt.Fprintf(&rich, `<br><a href="%s">%s</a>`,
+				html.EscapeString(link),
+				html.EscapeString(inst.Translate("Notifications Digest Link")))
+		}
+		rich.WriteString("</li>")
+	}
+	rich.WriteString("</ul>")
+
+	return &mail.Options{
+		Mode:    mail.ModeFromStack,
+		Subject: title,
+		Parts: []*mail.Part{
+			{Body: text.String(), Type: "text/plain"},
+			{Body: rich.String(), Type: "text/html"},
+		},
+	}
+}
@@ -12,4 +12,7 @@ var (
 	// ErrCategoryNotFound is used when sending a notification from an unknown
 	// category.
 	ErrCategoryNotFound = errors.New("Notification category does not exist")
+	// ErrBadPreferences is used when the notification preferences are not
+	// valid.
+	ErrBadPreferences = errors.New("Notification preferences are not valid")
 )
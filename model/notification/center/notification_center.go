@@ -3,6 +3,7 @@ package center
 import (
 	"errors"
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
@@ -10,7 +11,9 @@ import (
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/webpush"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
@@ -34,6 +37,9 @@ const (
 	// NotificationAntivirusAlert category for sending alert when antivirus
 	// scanning detects an issue with a file (infected, too large, or error).
 	NotificationAntivirusAlert = "antivirus-alert"
+	// NotificationNoteMention category for sending alert when the owner of
+	// the instance is mentioned in a comment on a note.
+	NotificationNoteMention = "note-mention"
 )
 
 var (
@@ -63,6 +69,11 @@ var (
 			Stateful:     false,
 			MailTemplate: "notifications_antivirus",
 		},
+		NotificationNoteMention: {
+			Description: "Notify when the owner is mentioned in a comment on a note",
+			Collapsible: false,
+			Stateful:    false,
+		},
 	}
 )
 
@@ -127,6 +138,36 @@ func init() {
 		}
 		PushStack(i.DomainName(), NotificationOAuthClients, n)
 	})
+
+	note.RegisterMentionCallback(func(i *instance.Instance, file *vfs.FileDoc, comment *note.Comment) {
+		noteName := strings.TrimSuffix(file.DocName, ".cozy-note")
+		noteLink := i.SubDomain(consts.NotesSlug)
+		noteLink.Fragment = "/n/" + file.ID()
+		redirectLink := consts.NotesSlug + "/#/n/" + file.ID()
+
+		title := i.Translate("Notifications Note Mention Title", comment.Author.Name, noteName)
+		n := &notification.Notification{
+			Title:   title,
+			Message: comment.Content,
+			Slug:    consts.NotesSlug,
+			Content: fmt.Sprintf("%s\n\n%s\n\n%s", title, comment.Content, noteLink.String()),
+			ContentHTML: fmt.Sprintf(`<p>%s</p><blockquote>%s</blockquote><p><a href="%s">%s</a></p>`,
+				html.EscapeString(title),
+				html.EscapeString(comment.Content),
+				html.EscapeString(noteLink.String()),
+				html.EscapeString(i.Translate("Notifications Note Mention Link"))),
+			Data: map[string]interface{}{
+				// For mobile push notification
+				"appName":      "",
+				"redirectLink": redirectLink,
+			},
+			PreferredChannels: []string{"mobile"},
+		}
+		if err := PushStack(i.DomainName(), NotificationNoteMention, n); err != nil {
+			i.Logger().WithNamespace("notifications").
+				Warnf("Cannot send notification for mention: %s", err)
+		}
+	})
 }
 
 // PushStack creates and sends a new notification where the source is the stack.
@@ -267,8 +308,19 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 		}
 	}
 
-	preferredChannels := ensureMailFallback(n.PreferredChannels)
+	prefs, err := GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	asked := ensureMailFallback(n.PreferredChannels)
+	preferredChannels, digest := prefs.filterChannels(n, asked)
+	webPush := prefs.webPushEnabled(n, asked)
 	at := n.At
+	if at == "" && prefs.QuietHours != nil {
+		if end := prefs.endOfQuietHours(time.Now(), instanceLocation(inst)); !end.IsZero() {
+			at = end.Format(time.RFC3339)
+		}
+	}
 
 	n.NID = ""
 	n.NRev = ""
@@ -277,22 +329,40 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 	n.LastSent = lastSent
 	n.PreferredChannels = nil
 	n.At = ""
+	n.Digest = digest && !skipNotification
 
 	if err := couchdb.CreateDoc(inst, n); err != nil {
 		return err
 	}
-	if skipNotification {
+	// The notifications kept for the digest are only sent with it.
+	if skipNotification || n.Digest {
 		return nil
 	}
 
 	var errm error
 	log := inst.Logger().WithNamespace("notifications")
+
+	// The browsers are notified in addition to the other channels, even when
+	// the user has disabled the mobile channel.
+	mobile := false
+	for _, channel := range preferredChannels {
+		if channel == "mobile" {
+			mobile = true
+			break
+		}
+	}
+	if !mobile && webPush && p != nil && webpush.HasSubscription(inst) {
+		if err := sendPush(inst, p, n, at, false, true); err != nil {
+			log.Errorf("Error while sending web push %#v: %v. Error: %v", p, n.State, err)
+		}
+	}
+
 	for _, channel := range preferredChannels {
 		switch channel {
 		case "mobile":
 			if p != nil {
 				log.Infof("Sending push %#v: %v", p, n.State)
-				err := sendPush(inst, p, n, at)
+				err := sendPush(inst, p, n, at, true, webPush)
 				if err == nil {
 					return nil
 				}
@@ -342,15 +412,19 @@ func findLastNotification(inst *instance.Instance, source string) (*notification
 	return notifs[0], nil
 }
 
+// sendPush pushes a job for the push worker, that sends the notification to
+// the mobile devices and/or to the browsers with Web Push.
 func sendPush(inst *instance.Instance,
 	p *notification.Properties,
 	n *notification.Notification,
 	at string,
+	devices, browsers bool,
 ) error {
-	if !hasNotifiableDevice(inst) {
+	devices = devices && hasNotifiableDevice(inst)
+	browsers = browsers && webpush.HasSubscription(inst)
+	if !devices && !browsers {
 		return errors.New("No device with push notification")
 	}
-	email := buildMailMessage(p, n)
 	push := PushMessage{
 		NotificationID: n.ID(),
 		Source:         n.Source(),
@@ -360,7 +434,9 @@ func sendPush(inst *instance.Instance,
 		Sound:          n.Sound,
 		Data:           n.Data,
 		Collapsible:    p.Collapsible,
-		MailFallback:   email,
+		NoDevices:      !devices,
+		NoWebPush:      !browsers,
+		MailFallback:   fallbackMail(p, n),
 	}
 	msg, err := job.NewMessage(&push)
 	if err != nil {
@@ -390,11 +466,10 @@ func sendSMS(inst *instance.Instance,
 	n *notification.Notification,
 	at string,
 ) error {
-	email := buildMailMessage(p, n)
 	msg, err := job.NewMessage(&SMS{
 		NotificationID: n.ID(),
 		Message:        n.Message,
-		MailFallback:   email,
+		MailFallback:   fallbackMail(p, n),
 	})
 	if err != nil {
 		return err
@@ -427,6 +502,15 @@ func buildMailMessage(p *notification.Properties, n *notification.Notification)
 	return &email
 }
 
+// fallbackMail returns the mail to send when a push notification or a SMS
+// cannot be sent, except when the mail will be sent in the digest.
+func fallbackMail(p *notification.Properties, n *notification.Notification) *mail.Options {
+	if n.Digest {
+		return nil
+	}
+	return buildMailMessage(p, n)
+}
+
 func pushJobOrTrigger(inst *instance.Instance, msg job.Message, worker, at string) error {
 	if at == "" {
 		_, err := job.System().PushJob(inst, &job.JobRequest{
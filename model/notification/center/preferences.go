@@ -0,0 +1,357 @@
+package center
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/settings/common"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+const (
+	// DigestDaily is the frequency for sending a digest every day.
+	DigestDaily = "daily"
+	// DigestWeekly is the frequency for sending a digest every week.
+	DigestWeekly = "weekly"
+
+	// AllCategories is the key in the categories of the preferences that
+	// applies to the categories that are not listed.
+	AllCategories = "*"
+
+	// DigestWorker is the worker type for sending the digests.
+	DigestWorker = "notifications-digest"
+
+	defaultDigestHour = 8
+)
+
+// knownChannels are the channels that can be disabled by the user. The
+// webpush channel is for the browsers: the notifications are sent to them
+// when the mobile channel is asked, but the user can disable it separately.
+var knownChannels = map[string]bool{"mobile": true, "webpush": true, "mail": true, "sms": true}
+
+// Preferences is the document where the user chooses how they are notified:
+// the channels for each category, the quiet hours, and the digest.
+type Preferences struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	// Categories are indexed by the slug of the app and the category, like
+	// "drive/sharing", or just the category for the notifications without
+	// an app.
+	Categories map[string]*CategoryPreferences `json:"categories,omitempty"`
+	QuietHours *QuietHours                     `json:"quiet_hours,omitempty"`
+	Digest     *DigestSettings                 `json:"digest,omitempty"`
+
+	DigestTriggerID string     `json:"digest_trigger_id,omitempty"`
+	LastDigest      *time.Time `json:"last_digest,omitempty"`
+}
+
+// CategoryPreferences are the preferences of the user for a category of
+// notifications.
+type CategoryPreferences struct {
+	// Channels can disable some channels: a channel that is not in this map
+	// is enabled.
+	Channels map[string]bool `json:"channels,omitempty"`
+	// Digest is true when the mails for this category are replaced by the
+	// digest.
+	Digest bool `json:"digest,omitempty"`
+}
+
+// QuietHours is a period of the day, in the timezone of the instance, where
+// the notifications are delayed. Start and End use the 15:04 format, and
+// Start can be after End for a period that includes midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// DigestSettings are the frequency and the moment of the digest. Weekday is
+// only used for the weekly digest, with 0 for Sunday.
+type DigestSettings struct {
+	Frequency string `json:"frequency"`
+	Hour      int    `json:"hour"`
+	Weekday   int    `json:"weekday,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (p *Preferences) ID() string { return p.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (p *Preferences) Rev() string { return p.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (p *Preferences) DocType() string { return consts.Settings }
+
+// Clone implements couchdb.Doc
+func (p *Preferences) Clone() couchdb.Doc {
+	cloned := *p
+	cloned.Categories = make(map[string]*CategoryPreferences, len(p.Categories))
+	for k, v := range p.Categories {
+		cat := *v
+		cat.Channels = make(map[string]bool, len(v.Channels))
+		for ch, enabled := range v.Channels {
+			cat.Channels[ch] = enabled
+		}
+		cloned.Categories[k] = &cat
+	}
+	if p.QuietHours != nil {
+		quiet := *p.QuietHours
+		cloned.QuietHours = &quiet
+	}
+	if p.Digest != nil {
+		digest := *p.Digest
+		cloned.Digest = &digest
+	}
+	if p.LastDigest != nil {
+		last := *p.LastDigest
+		cloned.LastDigest = &last
+	}
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (p *Preferences) SetID(id string) { p.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (p *Preferences) SetRev(rev string) { p.DocRev = rev }
+
+// Fetch implements permissions.Fetcher
+func (p *Preferences) Fetch(field string) []string { return nil }
+
+// GetPreferences returns the notification preferences of the user. If they
+// have not been saved, the default preferences (everything is sent
+// immediately) are returned.
+func GetPreferences(inst *instance.Instance) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := couchdb.GetDoc(inst, consts.Settings, consts.NotificationsSettingsID, prefs)
+	if couchdb.IsNotFoundError(err) {
+		return &Preferences{DocID: consts.NotificationsSettingsID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SavePreferences validates and saves the notification preferences. The
+// trigger for the digest is created, updated or removed accordingly.
+func SavePreferences(inst *instance.Instance, prefs *Preferences) error {
+	if err := prefs.validate(); err != nil {
+		return err
+	}
+	old, err := GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	prefs.DocID = consts.NotificationsSettingsID
+	prefs.DocRev = old.DocRev
+	prefs.DigestTriggerID = old.DigestTriggerID
+	prefs.LastDigest = old.LastDigest
+	if err := prefs.updateDigestTrigger(inst); err != nil {
+		return err
+	}
+	if prefs.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(inst, prefs)
+	}
+	return couchdb.UpdateDoc(inst, prefs)
+}
+
+func (p *Preferences) validate() error {
+	for _, cat := range p.Categories {
+		if cat == nil {
+			return ErrBadPreferences
+		}
+		for ch := range cat.Channels {
+			if !knownChannels[ch] {
+				return ErrBadPreferences
+			}
+		}
+	}
+	if q := p.QuietHours; q != nil {
+		if _, err := time.Parse("15:04", q.Start); err != nil {
+			return ErrBadPreferences
+		}
+		if _, err := time.Parse("15:04", q.End); err != nil {
+			return ErrBadPreferences
+		}
+	}
+	if d := p.Digest; d != nil {
+		switch d.Frequency {
+		case "":
+			p.Digest = nil
+		case DigestDaily, DigestWeekly:
+			if d.Hour < 0 || d.Hour > 23 || d.Weekday < 0 || d.Weekday > 6 {
+				return ErrBadPreferences
+			}
+		default:
+			return ErrBadPreferences
+		}
+	}
+	return nil
+}
+
+// category returns the preferences for the category of a notification, or
+// nil if the user has not set preferences for it.
+func (p *Preferences) category(n *notification.Notification) *CategoryPreferences {
+	key := n.Category
+	if n.Slug != "" {
+		key = n.Slug + "/" + n.Category
+	}
+	if cat, ok := p.Categories[key]; ok {
+		return cat
+	}
+	return p.Categories[AllCategories]
+}
+
+// filterChannels removes the channels disabled by the user for the
+// notification. It also returns true if the notification must be kept for the
+// digest instead of being sent.
+func (p *Preferences) filterChannels(n *notification.Notification, channels []string) ([]string, bool) {
+	cat := p.category(n)
+	if cat == nil {
+		return channels, false
+	}
+	mail, ok := cat.Channels["mail"]
+	digest := cat.Digest && p.Digest != nil && (!ok || mail)
+	filtered := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		if enabled, ok := cat.Channels[ch]; ok && !enabled {
+			continue
+		}
+		if ch == "mail" && digest {
+			continue
+		}
+		filtered = append(filtered, ch)
+	}
+	return filtered, digest
+}
+
+// webPushEnabled returns true if the notification can be sent to the
+// browsers: the mobile channel must have been asked for the notification, and
+// the webpush channel must not have been disabled by the user.
+func (p *Preferences) webPushEnabled(n *notification.Notification, channels []string) bool {
+	asked := false
+	for _, ch := range channels {
+		if ch == "mobile" {
+			asked = true
+			break
+		}
+	}
+	if !asked {
+		return false
+	}
+	cat := p.category(n)
+	if cat == nil {
+		return true
+	}
+	enabled, ok := cat.Channels["webpush"]
+	return !ok || enabled
+}
+
+// endOfQuietHours returns the moment when the quiet hours will end if now is
+// inside them, or a zero time.
+func (p *Preferences) endOfQuietHours(now time.Time, loc *time.Location) time.Time {
+	if p.QuietHours == nil {
+		return time.Time{}
+	}
+	start, err := time.Parse("15:04", p.QuietHours.Start)
+	if err != nil {
+		return time.Time{}
+	}
+	end, err := time.Parse("15:04", p.QuietHours.End)
+	if err != nil {
+		return time.Time{}
+	}
+	now = now.In(loc)
+	minutes := now.Hour()*60 + now.Minute()
+	startMin := start.Hour()*60 + start.Minute()
+	endMin := end.Hour()*60 + end.Minute()
+
+	var quiet bool
+	if startMin <= endMin {
+		quiet = minutes >= startMin && minutes < endMin
+	} else {
+		quiet = minutes >= startMin || minutes < endMin
+	}
+	if !quiet {
+		return time.Time{}
+	}
+	y, m, d := now.Date()
+	at := time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, loc)
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// digestCron returns the arguments of the @cron trigger for the digest.
+func (p *Preferences) digestCron(loc *time.Location) string {
+	hour := p.Digest.Hour
+	if hour < 0 || hour > 23 {
+		hour = defaultDigestHour
+	}
+	if p.Digest.Frequency == DigestWeekly {
+		return fmt.Sprintf("CRON_TZ=%s 0 0 %d * * %d", loc, hour, p.Digest.Weekday)
+	}
+	return fmt.Sprintf("CRON_TZ=%s 0 0 %d * * *", loc, hour)
+}
+
+func (p *Preferences) updateDigestTrigger(inst *instance.Instance) error {
+	sched := job.System()
+	if p.Digest == nil {
+		if p.DigestTriggerID != "" {
+			err := sched.DeleteTrigger(inst, p.DigestTriggerID)
+			if err != nil && err != job.ErrNotFoundTrigger {
+				return err
+			}
+			p.DigestTriggerID = ""
+		}
+		return nil
+	}
+
+	args := p.digestCron(instanceLocation(inst))
+	if p.DigestTriggerID != "" {
+		t, err := sched.GetTrigger(inst, p.DigestTriggerID)
+		if err == nil {
+			if t.Infos().Arguments == args {
+				return nil
+			}
+			return sched.UpdateCron(inst, t, args)
+		}
+		if err != job.ErrNotFoundTrigger {
+			return err
+		}
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@cron",
+		WorkerType: DigestWorker,
+		Arguments:  args,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := sched.AddTrigger(t); err != nil {
+		return err
+	}
+	p.DigestTriggerID = t.Infos().TID
+	return nil
+}
+
+// instanceLocation returns the timezone of the instance.
+func instanceLocation(inst *instance.Instance) *time.Location {
+	tz := common.DefaultTimezone
+	if doc, err := inst.SettingsDocument(); err == nil {
+		if s, ok := doc.M["tz"].(string); ok && s != "" {
+			tz = s
+		}
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
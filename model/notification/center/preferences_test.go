@@ -0,0 +1,143 @@
+package center
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterChannels(t *testing.T) {
+	prefs := &Preferences{
+		Categories: map[string]*CategoryPreferences{
+			"drive/sharing": {Channels: map[string]bool{"mobile": false}},
+			"banks/balance": {Digest: true},
+			"settings/muted": {Channels: map[string]bool{
+				"mobile": false, "mail": false, "sms": false,
+			}},
+			AllCategories: {Channels: map[string]bool{"sms": false}},
+		},
+	}
+	channels := []string{"mobile", "sms", "mail"}
+
+	n := &notification.Notification{Slug: "drive", Category: "sharing"}
+	filtered, digest := prefs.filterChannels(n, channels)
+	assert.Equal(t, []string{"sms", "mail"}, filtered)
+	assert.False(t, digest)
+
+	n = &notification.Notification{Slug: "settings", Category: "muted"}
+	filtered, digest = prefs.filterChannels(n, channels)
+	assert.Empty(t, filtered)
+	assert.False(t, digest)
+
+	n = &notification.Notification{Slug: "photos", Category: "album"}
+	filtered, _ = prefs.filterChannels(n, channels)
+	assert.Equal(t, []string{"mobile", "mail"}, filtered)
+
+	// The digest is used only when its frequency has been chosen
+	n = &notification.Notification{Slug: "banks", Category: "balance"}
+	filtered, digest = prefs.filterChannels(n, channels)
+	assert.Equal(t, channels, filtered)
+	assert.False(t, digest)
+	prefs.Digest = &DigestSettings{Frequency: DigestDaily, Hour: 8}
+	filtered, digest = prefs.filterChannels(n, channels)
+	assert.Equal(t, []string{"mobile", "sms"}, filtered)
+	assert.True(t, digest)
+
+	empty := &Preferences{}
+	filtered, digest = empty.filterChannels(n, channels)
+	assert.Equal(t, channels, filtered)
+	assert.False(t, digest)
+}
+
+func TestWebPushEnabled(t *testing.T) {
+	prefs := &Preferences{
+		Categories: map[string]*CategoryPreferences{
+			"drive/sharing": {Channels: map[string]bool{"mobile": false}},
+			"banks/balance": {Channels: map[string]bool{"webpush": false}},
+		},
+	}
+	n := &notification.Notification{Slug: "drive", Category: "sharing"}
+	assert.True(t, prefs.webPushEnabled(n, []string{"mobile", "mail"}))
+	assert.False(t, prefs.webPushEnabled(n, []string{"mail"}))
+
+	n = &notification.Notification{Slug: "banks", Category: "balance"}
+	assert.False(t, prefs.webPushEnabled(n, []string{"mobile", "mail"}))
+	filtered, _ := prefs.filterChannels(n, []string{"mobile", "mail"})
+	assert.Equal(t, []string{"mobile", "mail"}, filtered)
+
+	empty := &Preferences{}
+	assert.True(t, empty.webPushEnabled(n, []string{"mobile"}))
+}
+
+func TestEndOfQuietHours(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	prefs := &Preferences{QuietHours: &QuietHours{Start: "22:00", End: "07:30"}}
+
+	// 23:15 in Paris
+	now := time.Date(2026, 10, 19, 21, 15, 0, 0, time.UTC)
+	end := prefs.endOfQuietHours(now, loc)
+	assert.Equal(t, time.Date(2026, 10, 20, 7, 30, 0, 0, loc), end)
+
+	// 06:00 in Paris
+	now = time.Date(2026, 10, 20, 4, 0, 0, 0, time.UTC)
+	end = prefs.endOfQuietHours(now, loc)
+	assert.Equal(t, time.Date(2026, 10, 20, 7, 30, 0, 0, loc), end)
+
+	// 12:00 in Paris
+	now = time.Date(2026, 10, 20, 10, 0, 0, 0, time.UTC)
+	assert.True(t, prefs.endOfQuietHours(now, loc).IsZero())
+
+	prefs.QuietHours = &QuietHours{Start: "12:00", End: "14:00"}
+	end = prefs.endOfQuietHours(now, loc)
+	assert.Equal(t, time.Date(2026, 10, 20, 14, 0, 0, 0, loc), end)
+}
+
+func TestValidatePreferences(t *testing.T) {
+	prefs := &Preferences{
+		Categories: map[string]*CategoryPreferences{
+			"drive/sharing": {Channels: map[string]bool{"mail": false}},
+		},
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00"},
+		Digest:     &DigestSettings{Frequency: DigestWeekly, Hour: 18, Weekday: 5},
+	}
+	assert.NoError(t, prefs.validate())
+
+	prefs.Digest = &DigestSettings{Frequency: ""}
+	assert.NoError(t, prefs.validate())
+	assert.Nil(t, prefs.Digest)
+
+	prefs.Digest = &DigestSettings{Frequency: "hourly"}
+	assert.Equal(t, ErrBadPreferences, prefs.validate())
+	prefs.Digest = &DigestSettings{Frequency: DigestDaily, Hour: 24}
+	assert.Equal(t, ErrBadPreferences, prefs.validate())
+	prefs.Digest = nil
+
+	prefs.QuietHours = &QuietHours{Start: "10pm", End: "07:00"}
+	assert.Equal(t, ErrBadPreferences, prefs.validate())
+	prefs.QuietHours = nil
+
+	prefs.Categories["drive/sharing"].Channels["pigeon"] = true
+	assert.Equal(t, ErrBadPreferences, prefs.validate())
+}
+
+func TestDigestCron(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	prefs := &Preferences{Digest: &DigestSettings{Frequency: DigestDaily, Hour: 8}}
+	args := prefs.digestCron(loc)
+	assert.Equal(t, "CRON_TZ=Europe/Paris 0 0 8 * * *", args)
+	_, err = job.NewCronTrigger(&job.TriggerInfos{Type: "@cron", Arguments: args})
+	assert.NoError(t, err)
+
+	prefs.Digest = &DigestSettings{Frequency: DigestWeekly, Hour: 18, Weekday: 5}
+	args = prefs.digestCron(loc)
+	assert.Equal(t, "CRON_TZ=Europe/Paris 0 0 18 * * 5", args)
+	_, err = job.NewCronTrigger(&job.TriggerInfos{Type: "@cron", Arguments: args})
+	assert.NoError(t, err)
+}
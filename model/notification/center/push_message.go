@@ -16,6 +16,12 @@ type PushMessage struct {
 	Sound          string `json:"sound,omitempty"`
 	Collapsible    bool   `json:"collapsible,omitempty"`
 
+	// NoDevices is true when the user has disabled the mobile channel, but
+	// not the webpush one: only the browsers are notified.
+	NoDevices bool `json:"no_devices,omitempty"`
+	// NoWebPush is true when the user has disabled the webpush channel.
+	NoWebPush bool `json:"no_web_push,omitempty"`
+
 	Data map[string]interface{} `json:"data,omitempty"`
 
 	MailFallback *mail.Options `json:"mail_fallback,omitempty"`
@@ -58,6 +58,10 @@ type Notification struct {
 	PreferredChannels []string `json:"preferred_channels,omitempty"`
 	At                string   `json:"at,omitempty"`
 
+	// Digest is true when the mail for this notification is sent in the
+	// digest instead of immediately.
+	Digest bool `json:"digest,omitempty"`
+
 	// XXX retro-compatible fields for sending rich mail
 	Content     string `json:"content,omitempty"`
 	ContentHTML string `json:"content_html,omitempty"`
@@ -0,0 +1,96 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// recordSize is the size of the single record of the encrypted payload.
+	recordSize = 4096
+
+	// headerSize is the size of the header of the aes128gcm content coding:
+	// the salt (16), the record size (4), the length of the key id (1) and
+	// the public key of the server (65).
+	headerSize = 16 + 4 + 1 + 65
+
+	// MaxPayloadSize is the maximal size of a payload before the encryption.
+	// The push services must accept a body of 4096 bytes, which includes the
+	// header, the padding delimiter and the AEAD tag (RFC 8291 section 4).
+	MaxPayloadSize = 4096 - headerSize - 1 - 16
+)
+
+// ErrPayloadTooLarge is used when the payload does not fit in a record.
+var ErrPayloadTooLarge = errors.New("The Web Push payload is too large")
+
+// encrypt returns the payload encrypted for the browser with the aes128gcm
+// content coding (RFC 8188), and the keys derived as explained in RFC 8291.
+func encrypt(payload []byte, browserKey *ecdh.PublicKey, authSecret []byte) ([]byte, error) {
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encryptWith(payload, browserKey, authSecret, serverKey, salt)
+}
+
+func encryptWith(payload []byte, browserKey *ecdh.PublicKey, authSecret []byte,
+	serverKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	secret, err := serverKey.ECDH(browserKey)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic := browserKey.Bytes()
+	asPublic := serverKey.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdf.Key(sha256.New, secret, authSecret, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, that is also the last one (delimiter 0x02)
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	var buf bytes.Buffer
+	buf.Write(salt)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(recordSize))
+	buf.WriteByte(byte(len(asPublic)))
+	buf.Write(asPublic)
+	buf.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return buf.Bytes(), nil
+}
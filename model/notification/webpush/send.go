@@ -0,0 +1,109 @@
+package webpush
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+)
+
+// defaultTTL is how long a push service keeps a message for a browser that
+// is offline.
+const defaultTTL = 24 * time.Hour
+
+// httpClient is used to send the messages to the push services. The
+// endpoints are given by the browsers, so the client is protected against
+// SSRF.
+var httpClient = safehttp.DefaultClient
+
+// Message is the payload of a notification, as received by the service
+// worker of the browser.
+type Message struct {
+	NotificationID string                 `json:"notification_id,omitempty"`
+	Source         string                 `json:"source,omitempty"`
+	Slug           string                 `json:"slug,omitempty"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body,omitempty"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+}
+
+// Options are the parameters of the request to the push service.
+type Options struct {
+	// Urgency is one of very-low, low, normal and high.
+	Urgency string
+	// Topic is used by the push service to replace a pending message with the
+	// same topic. It must be at most 32 characters from the base64url
+	// alphabet.
+	Topic string
+	TTL   time.Duration
+}
+
+// Send encrypts the message and sends it to the push service of the
+// subscription. ErrGone is returned if the subscription is no longer valid:
+// it should be deleted.
+func Send(sub *Subscription, msg *Message, opts Options) error {
+	key, err := vapidKey()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	browserKey, err := sub.browserKey()
+	if err != nil {
+		return ErrInvalidSubscription
+	}
+	authSecret, err := decodeKey(sub.Keys.Auth)
+	if err != nil {
+		return ErrInvalidSubscription
+	}
+	body, err := encrypt(payload, browserKey, authSecret)
+	if err != nil {
+		return err
+	}
+
+	subject := config.GetConfig().Notifications.VAPIDSubject
+	auth, err := vapidAuthorization(sub.Endpoint, subject, key, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.FormatInt(int64(ttl/time.Second), 10))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	}
+	return fmt.Errorf("webpush: push service responded with %d", res.StatusCode)
+}
@@ -0,0 +1,200 @@
+// Package webpush is for the notifications sent to the browsers with the Web
+// Push protocol (RFC 8030). The push services are authenticated with VAPID
+// (RFC 8292), and the payloads are encrypted for the browser (RFC 8291).
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+const authSecretLength = 16
+
+var (
+	// ErrNotConfigured is used when the stack has no VAPID key.
+	ErrNotConfigured = errors.New("Web Push is not configured")
+	// ErrInvalidSubscription is used when the endpoint or the keys of a
+	// subscription are not valid.
+	ErrInvalidSubscription = errors.New("Invalid Web Push subscription")
+	// ErrGone is used when the push service says that the subscription has
+	// expired or has been removed.
+	ErrGone = errors.New("The Web Push subscription is no longer valid")
+)
+
+// Keys are the keys given by the browser to encrypt the payloads, encoded in
+// base64url.
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Subscription is a browser that can receive the notifications. It is linked
+// to the OAuth client or the session that has registered it, and it is
+// removed when they are revoked.
+type Subscription struct {
+	DocID     string    `json:"_id,omitempty"`
+	DocRev    string    `json:"_rev,omitempty"`
+	Endpoint  string    `json:"endpoint"`
+	Keys      Keys      `json:"keys"`
+	ClientID  string    `json:"client_id,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ID returns the subscription qualified identifier
+func (s *Subscription) ID() string { return s.DocID }
+
+// Rev returns the subscription revision
+func (s *Subscription) Rev() string { return s.DocRev }
+
+// DocType returns the subscription document type
+func (s *Subscription) DocType() string { return consts.WebPushSubscriptions }
+
+// Clone implements couchdb.Doc
+func (s *Subscription) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID changes the subscription qualified identifier
+func (s *Subscription) SetID(id string) { s.DocID = id }
+
+// SetRev changes the subscription revision
+func (s *Subscription) SetRev(rev string) { s.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (s *Subscription) Fetch(field string) []string { return nil }
+
+// subscriptionID returns the identifier of a subscription: there is only one
+// document per endpoint.
+func subscriptionID(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *Subscription) validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrInvalidSubscription
+	}
+	if _, err := s.browserKey(); err != nil {
+		return ErrInvalidSubscription
+	}
+	if auth, err := decodeKey(s.Keys.Auth); err != nil || len(auth) != authSecretLength {
+		return ErrInvalidSubscription
+	}
+	if s.ClientID == "" && s.SessionID == "" {
+		return ErrInvalidSubscription
+	}
+	return nil
+}
+
+func (s *Subscription) browserKey() (*ecdh.PublicKey, error) {
+	raw, err := decodeKey(s.Keys.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.P256().NewPublicKey(raw)
+}
+
+// decodeKey accepts the base64url encoding, with or without padding, that is
+// used by the browsers for the keys.
+func decodeKey(key string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+}
+
+// Subscribe saves the subscription of a browser. If the browser has already
+// subscribed, its keys and its owner are updated.
+func Subscribe(inst *instance.Instance, sub *Subscription) error {
+	if err := sub.validate(); err != nil {
+		return err
+	}
+	sub.DocID = subscriptionID(sub.Endpoint)
+	sub.DocRev = ""
+	sub.CreatedAt = time.Now().UTC()
+
+	old := &Subscription{}
+	err := couchdb.GetDoc(inst, consts.WebPushSubscriptions, sub.DocID, old)
+	if err == nil {
+		sub.DocRev = old.DocRev
+		sub.CreatedAt = old.CreatedAt
+		return couchdb.UpdateDoc(inst, sub)
+	}
+	if !couchdb.IsNotFoundError(err) {
+		return err
+	}
+	return couchdb.CreateNamedDocWithDB(inst, sub)
+}
+
+// Unsubscribe removes the subscription for the given endpoint.
+func Unsubscribe(inst *instance.Instance, endpoint string) error {
+	sub := &Subscription{}
+	err := couchdb.GetDoc(inst, consts.WebPushSubscriptions, subscriptionID(endpoint), sub)
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, sub)
+}
+
+// Delete removes the subscription.
+func (s *Subscription) Delete(inst *instance.Instance) error {
+	return couchdb.DeleteDoc(inst, s)
+}
+
+// List returns the subscriptions of the instance. The subscriptions of the
+// OAuth clients and sessions that no longer exist are removed.
+func List(inst *instance.Instance) ([]*Subscription, error) {
+	var subs []*Subscription
+	err := couchdb.GetAllDocs(inst, consts.WebPushSubscriptions, nil, &subs)
+	if couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	valid := subs[:0]
+	for _, sub := range subs {
+		if sub.isRevoked(inst) {
+			_ = sub.Delete(inst)
+			continue
+		}
+		valid = append(valid, sub)
+	}
+	return valid, nil
+}
+
+// HasSubscription returns true if at least one browser has subscribed to the
+// notifications of the instance.
+func HasSubscription(inst *instance.Instance) bool {
+	if !IsConfigured() {
+		return false
+	}
+	subs, err := List(inst)
+	return err == nil && len(subs) > 0
+}
+
+func (s *Subscription) isRevoked(inst *instance.Instance) bool {
+	if s.ClientID != "" {
+		_, err := oauth.FindClient(inst, s.ClientID)
+		return couchdb.IsNotFoundError(err)
+	}
+	sess := &session.Session{}
+	err := couchdb.GetDoc(inst, consts.Sessions, s.SessionID, sess)
+	if err != nil {
+		return couchdb.IsNotFoundError(err)
+	}
+	return sess.OlderThan(session.SessionMaxAge)
+}
@@ -0,0 +1,103 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// vapidValidity is the validity of the JWT sent to the push services. It
+// must not be more than 24 hours.
+const vapidValidity = 12 * time.Hour
+
+// GenerateVAPIDKeys returns a new pair of keys for the VAPID authentication,
+// encoded in base64url. The private key is for the configuration of the
+// stack, and the public key is the applicationServerKey for the browsers.
+func GenerateVAPIDKeys() (privateKey, publicKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey, err = encodePublicKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return encodePrivateKey(key), publicKey, nil
+}
+
+// IsConfigured returns true if the stack has a VAPID key for Web Push.
+func IsConfigured() bool {
+	return config.GetConfig().Notifications.VAPIDPrivateKey != ""
+}
+
+// PublicKey returns the VAPID public key of the stack, that the browsers use
+// as the applicationServerKey to subscribe.
+func PublicKey() (string, error) {
+	key, err := vapidKey()
+	if err != nil {
+		return "", err
+	}
+	return encodePublicKey(key)
+}
+
+func vapidKey() (*ecdsa.PrivateKey, error) {
+	encoded := config.GetConfig().Notifications.VAPIDPrivateKey
+	if encoded == "" {
+		return nil, ErrNotConfigured
+	}
+	raw, err := decodeKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	return key, nil
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) string {
+	raw, _ := key.Bytes()
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func encodePublicKey(key *ecdsa.PrivateKey) (string, error) {
+	raw, err := key.PublicKey.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// vapidAuthorization returns the value of the Authorization header for a
+// request to the push service of the endpoint (RFC 8292).
+func vapidAuthorization(endpoint, subject string, key *ecdsa.PrivateKey, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidValidity).Unix(),
+	}
+	if subject != "" {
+		claims["sub"] = subject
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+	public, err := encodePublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + strings.TrimRight(public, "="), nil
+}
@@ -0,0 +1,95 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(t *testing.T, s string) []byte {
+	data, err := decodeKey(s)
+	require.NoError(t, err)
+	return data
+}
+
+// The example of the appendix A of RFC 8291
+func TestEncrypt(t *testing.T) {
+	serverKey, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	require.NoError(t, err)
+	browserKey, err := ecdh.P256().NewPublicKey(b64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"))
+	require.NoError(t, err)
+	authSecret := b64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := b64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := encryptWith([]byte("When I grow up, I want to be a watermelon"),
+		browserKey, authSecret, serverKey, salt)
+	require.NoError(t, err)
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	assert.Equal(t, expected, base64.RawURLEncoding.EncodeToString(body))
+
+	body, err = encryptWith(make([]byte, MaxPayloadSize), browserKey, authSecret, serverKey, salt)
+	require.NoError(t, err)
+	assert.Len(t, body, 4096)
+	_, err = encryptWith(make([]byte, MaxPayloadSize+1), browserKey, authSecret, serverKey, salt)
+	assert.Equal(t, ErrPayloadTooLarge, err)
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	private, public, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), b64(t, private))
+	require.NoError(t, err)
+
+	now := time.Now()
+	auth, err := vapidAuthorization("https://push.example.net/push/abc123?x=y",
+		"mailto:admin@example.org", key, now)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(auth, "vapid t="))
+	token, k, ok := strings.Cut(strings.TrimPrefix(auth, "vapid t="), ", k=")
+	require.True(t, ok)
+	assert.Equal(t, public, k)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://push.example.net", claims["aud"])
+	assert.Equal(t, "mailto:admin@example.org", claims["sub"])
+	assert.EqualValues(t, now.Add(vapidValidity).Unix(), claims["exp"])
+}
+
+func TestValidateSubscription(t *testing.T) {
+	sub := &Subscription{
+		Endpoint: "https://push.example.net/push/abc123",
+		Keys: Keys{
+			P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+			Auth:   "BTBZMqHH6r4Tts7J_aSIgg==",
+		},
+		SessionID: "a1b2c3",
+	}
+	assert.NoError(t, sub.validate())
+
+	sub.Endpoint = "http://push.example.net/push/abc123"
+	assert.Equal(t, ErrInvalidSubscription, sub.validate())
+	sub.Endpoint = "https://push.example.net/push/abc123"
+
+	sub.Keys.Auth = "BTBZMqHH"
+	assert.Equal(t, ErrInvalidSubscription, sub.validate())
+	sub.Keys.Auth = "BTBZMqHH6r4Tts7J_aSIgg"
+
+	sub.Keys.P256dh = "BCVxsr7N"
+	assert.Equal(t, ErrInvalidSubscription, sub.validate())
+	sub.Keys.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+
+	sub.SessionID = ""
+	assert.Equal(t, ErrInvalidSubscription, sub.validate())
+}
@@ -18,18 +18,21 @@ var blockList = map[string]bool{
 	consts.Instances:             none,
 	consts.AccountTypes:          none,
 	consts.KonnectorsMaintenance: none,
+	consts.AppsRollouts:          none,
 	consts.RemoteSecrets:         none,
 
 	// Only stack can manipulate them
-	consts.Sessions:            none,
-	consts.Permissions:         none,
-	consts.Intents:             none,
-	consts.OAuthClients:        none,
-	consts.OAuthAccessCodes:    none,
-	consts.Archives:            none,
-	consts.Sharings:            none,
-	consts.Shared:              none,
-	consts.SoftDeletedAccounts: none,
+	consts.Sessions:             none,
+	consts.Permissions:          none,
+	consts.Intents:              none,
+	consts.OAuthClients:         none,
+	consts.OAuthAccessCodes:     none,
+	consts.Archives:             none,
+	consts.Sharings:             none,
+	consts.Shared:               none,
+	consts.SoftDeletedAccounts:  none,
+	consts.WebPushSubscriptions: none,
+	consts.Webhooks:             none,
 
 	// Synthetic doctypes (API only)
 	consts.CertifiedCarbonCopy:     none,
@@ -54,18 +57,28 @@ var blockList = map[string]bool{
 	consts.AppLogs:             none,
 
 	// Only stack can write them
-	consts.Jobs:              readable,
-	consts.Triggers:          readable,
-	consts.Apps:              readable,
-	consts.Konnectors:        readable,
-	consts.Files:             readable,
-	consts.FilesVersions:     readable,
-	consts.Notifications:     readable,
-	consts.RemoteRequests:    readable,
-	consts.SessionsLogins:    readable,
-	consts.NotesSteps:        readable,
-	consts.NotesImages:       readable,
-	consts.BitwardenContacts: readable,
+	consts.Jobs:                 readable,
+	consts.JobsDeadLetters:      readable,
+	consts.CSPReports:           readable,
+	consts.Triggers:             readable,
+	consts.Apps:                 readable,
+	consts.Konnectors:           readable,
+	consts.Files:                readable,
+	consts.FilesVersions:        readable,
+	consts.Notifications:        readable,
+	consts.RemoteRequests:       readable,
+	consts.SessionsLogins:       readable,
+	consts.NotesSteps:           readable,
+	consts.NotesImages:          readable,
+	consts.NotesComments:        readable,
+	consts.NotesSnapshots:       readable,
+	consts.MailSuppressions:     readable,
+	consts.InboundMails:         readable,
+	consts.WebhooksDeliveries:   readable,
+	consts.TakeoutMigrations:    readable,
+	consts.NextcloudSyncs:       readable,
+	consts.NextcloudSyncReports: readable,
+	consts.BitwardenContacts:    readable,
 }
 
 // CheckReadable will abort the context and returns false if the doctype
@@ -0,0 +1,195 @@
+// Package photo is for the auto albums: the photos are grouped in events by
+// their dates and places, and an io.cozy.photos.albums document is created for
+// each event, with references from the files of the photos. It also has the
+// perceptual hashes of the photos, to find the duplicates.
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+// WorkerType is the type of the worker that updates the auto albums.
+const WorkerType = "photos-clustering"
+
+// SettingsID is the identifier of the io.cozy.photos.settings document for the
+// auto albums.
+const SettingsID = "auto-albums"
+
+// maxPending is the maximal number of photos kept for the next clustering,
+// when they are not enough to make an album.
+const maxPending = 500
+
+var (
+	// ErrInvalidOptions is used when the options of the clustering are not
+	// valid.
+	ErrInvalidOptions = errors.New("invalid options for the auto albums")
+)
+
+// Album is an io.cozy.photos.albums document. The albums created by the
+// clustering have auto set to true.
+type Album struct {
+	DocID     string           `json:"_id,omitempty"`
+	DocRev    string           `json:"_rev,omitempty"`
+	Name      string           `json:"name"`
+	CreatedAt time.Time        `json:"created_at"`
+	Auto      bool             `json:"auto,omitempty"`
+	Period    *Period          `json:"period,omitempty"`
+	GPS       *GPS             `json:"gps,omitempty"`
+	Place     *geocoding.Place `json:"place,omitempty"`
+}
+
+// Period is the range of dates of the photos of an auto album.
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ID implements the couchdb.Doc interface
+func (a *Album) ID() string { return a.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (a *Album) Rev() string { return a.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (a *Album) DocType() string { return consts.PhotosAlbums }
+
+// SetID implements the couchdb.Doc interface
+func (a *Album) SetID(id string) { a.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (a *Album) SetRev(rev string) { a.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (a *Album) Clone() couchdb.Doc {
+	cloned := *a
+	if a.Period != nil {
+		period := *a.Period
+		cloned.Period = &period
+	}
+	if a.GPS != nil {
+		gps := *a.GPS
+		cloned.GPS = &gps
+	}
+	if a.Place != nil {
+		place := *a.Place
+		cloned.Place = &place
+	}
+	return &cloned
+}
+
+// albumName returns the name of an auto album, from its period and place,
+// like "Paris, 19 - 21 October 2026".
+func albumName(period *Period, place *geocoding.Place) string {
+	start, end := period.Start, period.End
+	var name string
+	switch {
+	case start.Year() != end.Year():
+		name = fmt.Sprintf("%s - %s", start.Format("2 January 2006"), end.Format("2 January 2006"))
+	case start.Month() != end.Month():
+		name = fmt.Sprintf("%s - %s", start.Format("2 January"), end.Format("2 January 2006"))
+	case start.Day() != end.Day():
+		name = fmt.Sprintf("%d - %s", start.Day(), end.Format("2 January 2006"))
+	default:
+		name = start.Format("2 January 2006")
+	}
+	if place != nil && place.City != "" {
+		name = place.City + ", " + name
+	}
+	return name
+}
+
+// Settings is the io.cozy.photos.settings document for the auto albums. It
+// has the options of the clustering, and its state.
+type Settings struct {
+	DocID       string     `json:"_id,omitempty"`
+	DocRev      string     `json:"_rev,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	TriggerID   string     `json:"trigger_id,omitempty"`
+	MaxGap      string     `json:"max_gap,omitempty"`
+	MaxDistance float64    `json:"max_distance,omitempty"`
+	MinSize     int        `json:"min_size,omitempty"`
+	LastSeq     string     `json:"last_seq,omitempty"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	// Pending are the identifiers of the photos that were not enough to
+	// make an album in the previous clustering.
+	Pending []string `json:"pending,omitempty"`
+}
+
+// ID implements the couchdb.Doc interface
+func (s *Settings) ID() string { return s.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (s *Settings) Rev() string { return s.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (s *Settings) DocType() string { return consts.PhotosSettings }
+
+// SetID implements the couchdb.Doc interface
+func (s *Settings) SetID(id string) { s.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (s *Settings) SetRev(rev string) { s.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (s *Settings) Clone() couchdb.Doc {
+	cloned := *s
+	if s.LastRunAt != nil {
+		t := *s.LastRunAt
+		cloned.LastRunAt = &t
+	}
+	cloned.Pending = make([]string, len(s.Pending))
+	copy(cloned.Pending, s.Pending)
+	return &cloned
+}
+
+// Links implements the jsonapi.Object interface
+func (s *Settings) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/photos/albums/auto"}
+}
+
+// Relationships implements the jsonapi.Object interface
+func (s *Settings) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Included implements the jsonapi.Object interface
+func (s *Settings) Included() []jsonapi.Object { return nil }
+
+var (
+	_ couchdb.Doc    = (*Album)(nil)
+	_ couchdb.Doc    = (*Settings)(nil)
+	_ jsonapi.Object = (*Settings)(nil)
+)
+
+// Options returns the options of the clustering, with the default values for
+// the missing ones.
+func (s *Settings) Options() Options {
+	opts := DefaultOptions
+	if d, err := time.ParseDuration(s.MaxGap); err == nil && d > 0 {
+		opts.MaxGap = d
+	}
+	if s.MaxDistance > 0 {
+		opts.MaxDistance = s.MaxDistance
+	}
+	if s.MinSize > 0 {
+		opts.MinSize = s.MinSize
+	}
+	return opts
+}
+
+func (s *Settings) validate() error {
+	if s.MaxGap != "" {
+		if d, err := time.ParseDuration(s.MaxGap); err != nil || d <= 0 {
+			return ErrInvalidOptions
+		}
+	}
+	if s.MaxDistance < 0 || s.MinSize < 0 {
+		return ErrInvalidOptions
+	}
+	return nil
+}
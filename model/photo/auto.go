@@ -0,0 +1,401 @@
+package photo
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+)
+
+// changesBatchSize is the number of changes of the files read at once.
+const changesBatchSize = 1000
+
+// debounce is the delay between an upload and the update of the auto albums,
+// to group the photos uploaded together.
+const debounce = "5m"
+
+// GetSettings returns the settings of the auto albums. They are disabled when
+// the document does not exist yet.
+func GetSettings(inst *instance.Instance) (*Settings, error) {
+	s := &Settings{}
+	err := couchdb.GetDoc(inst, consts.PhotosSettings, SettingsID, s)
+	if couchdb.IsNotFoundError(err) {
+		return &Settings{DocID: SettingsID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Settings) save(inst *instance.Instance) error {
+	if s.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(inst, s)
+	}
+	return couchdb.UpdateDoc(inst, s)
+}
+
+// Enable turns on the auto albums, with the given options of the clustering.
+// A trigger updates the albums after the uploads of images, and a first job
+// is pushed for the existing photos.
+func Enable(inst *instance.Instance, opts *Settings) (*Settings, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	s, err := GetSettings(inst)
+	if err != nil {
+		return nil, err
+	}
+	s.MaxGap = opts.MaxGap
+	s.MaxDistance = opts.MaxDistance
+	s.MinSize = opts.MinSize
+	if s.TriggerID == "" {
+		t, err := job.NewTrigger(inst, job.TriggerInfos{
+			Type:       "@event",
+			WorkerType: WorkerType,
+			Arguments:  consts.Files + ":CREATED:image:class",
+			Debounce:   debounce,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := job.System().AddTrigger(t); err != nil {
+			return nil, err
+		}
+		s.TriggerID = t.Infos().TID
+	}
+	s.Enabled = true
+	if err := s.save(inst); err != nil {
+		return nil, err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{WorkerType: WorkerType})
+	return s, err
+}
+
+// Disable turns off the auto albums. The albums already created are kept.
+func Disable(inst *instance.Instance) (*Settings, error) {
+	s, err := GetSettings(inst)
+	if err != nil {
+		return nil, err
+	}
+	if s.TriggerID != "" {
+		err := job.System().DeleteTrigger(inst, s.TriggerID)
+		if err != nil && err != job.ErrNotFoundTrigger {
+			return nil, err
+		}
+		s.TriggerID = ""
+	}
+	if s.DocRev == "" {
+		return s, nil
+	}
+	s.Enabled = false
+	if err := s.save(inst); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// UpdateAutoAlbums looks at the images uploaded since the last update: they
+// are added to an auto album of the same event if there is one, or grouped in
+// new auto albums. The albums created by the users are never modified, and
+// the photos are looked at only once, so a photo removed from an auto album
+// by the user is not added again.
+func UpdateAutoAlbums(inst *instance.Instance) error {
+	mu := config.Lock().LongOperation(inst, "photos/auto-albums")
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	s, err := GetSettings(inst)
+	if err != nil || !s.Enabled {
+		return err
+	}
+	startedAt := time.Now().UTC()
+	opts := s.Options()
+	photos, seq, err := newPhotos(inst, s)
+	if err != nil {
+		return err
+	}
+	albums, err := autoAlbums(inst)
+	if err != nil {
+		return err
+	}
+	log := inst.Logger().WithNamespace("photos")
+
+	var remaining []*Photo
+	found := make(map[*Album][]*Photo)
+	for _, p := range photos {
+		if album := findAlbum(albums, p, opts); album != nil {
+			found[album] = append(found[album], p)
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	for album, list := range found {
+		ok, err := extendAlbum(inst, album, list)
+		if err == nil && ok {
+			err = addReferences(inst, album, list)
+		}
+		if err != nil {
+			log.Warnf("Cannot add photos to the album %s: %s", album.DocID, err)
+		}
+		if !ok {
+			remaining = append(remaining, list...)
+		}
+	}
+
+	var pending []string
+	for _, cluster := range Cluster(remaining, opts) {
+		if len(cluster) < opts.MinSize {
+			for _, p := range cluster {
+				pending = append(pending, p.FileID)
+			}
+			continue
+		}
+		if err := createAlbum(inst, cluster); err != nil {
+			return err
+		}
+	}
+	if len(pending) > maxPending {
+		pending = pending[len(pending)-maxPending:]
+	}
+
+	s.LastSeq = seq
+	s.LastRunAt = &startedAt
+	s.Pending = pending
+	return s.save(inst)
+}
+
+// newPhotos returns the photos to cluster: the ones that were pending in the
+// previous run, and the images uploaded since then that are not in an album.
+func newPhotos(inst *instance.Instance, s *Settings) ([]*Photo, string, error) {
+	fs := inst.VFS()
+	seen := make(map[string]bool)
+	var photos []*Photo
+	for _, id := range s.Pending {
+		file, err := fs.FileByID(id)
+		if err != nil {
+			continue
+		}
+		if p := toPhoto(file); p != nil {
+			photos = append(photos, p)
+			seen[id] = true
+		}
+	}
+
+	seq := s.LastSeq
+	for {
+		res, err := couchdb.GetChanges(inst, &couchdb.ChangesRequest{
+			DocType:     consts.Files,
+			IncludeDocs: true,
+			Since:       seq,
+			Limit:       changesBatchSize,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		for _, change := range res.Results {
+			if change.Deleted || seen[change.DocID] {
+				continue
+			}
+			var file vfs.FileDoc
+			if err := jsonDocToFile(change.Doc, &file); err != nil {
+				continue
+			}
+			if !uploadedAfter(&file, s.LastRunAt) {
+				continue
+			}
+			if p := toPhoto(&file); p != nil {
+				photos = append(photos, p)
+				seen[change.DocID] = true
+			}
+		}
+		seq = res.LastSeq
+		if res.Pending == 0 || len(res.Results) == 0 {
+			return photos, seq, nil
+		}
+	}
+}
+
+func jsonDocToFile(doc couchdb.JSONDoc, file *vfs.FileDoc) error {
+	data, err := json.Marshal(doc.M)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, file)
+}
+
+// uploadedAfter returns true if the file has been uploaded after the given
+// date, or if there is no date.
+func uploadedAfter(file *vfs.FileDoc, date *time.Time) bool {
+	if date == nil {
+		return true
+	}
+	uploadedAt := file.CreatedAt
+	if file.CozyMetadata != nil && file.CozyMetadata.UploadedAt != nil {
+		uploadedAt = *file.CozyMetadata.UploadedAt
+	}
+	return uploadedAt.After(*date)
+}
+
+// toPhoto returns the photo for a file, or nil if the file can't be added to
+// an auto album: it is not an image, it is in the trash, or it is already in
+// an album.
+func toPhoto(file *vfs.FileDoc) *Photo {
+	if file.Type != consts.FileType || file.Class != "image" || file.Trashed {
+		return nil
+	}
+	for _, ref := range file.ReferencedBy {
+		if ref.Type == consts.PhotosAlbums {
+			return nil
+		}
+	}
+	p := &Photo{FileID: file.DocID, Datetime: file.CreatedAt}
+	if file.Metadata == nil {
+		return p
+	}
+	if dt, ok := file.Metadata["datetime"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, dt); err == nil {
+			p.Datetime = t
+		}
+	}
+	if gps, ok := file.Metadata["gps"].(map[string]interface{}); ok {
+		lat, okLat := gps["lat"].(float64)
+		long, okLong := gps["long"].(float64)
+		if okLat && okLong {
+			p.GPS = &GPS{Lat: lat, Long: long}
+		}
+	}
+	if place, ok := file.Metadata["place"].(map[string]interface{}); ok {
+		city, _ := place["city"].(string)
+		country, _ := place["country"].(string)
+		if city != "" {
+			p.Place = &geocoding.Place{City: city, Country: country}
+		}
+	}
+	return p
+}
+
+// autoAlbums returns the albums created by the clustering.
+func autoAlbums(inst *instance.Instance) ([]*Album, error) {
+	var all []*Album
+	err := couchdb.GetAllDocs(inst, consts.PhotosAlbums, nil, &all)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	var albums []*Album
+	for _, album := range all {
+		if album.Auto && album.Period != nil {
+			albums = append(albums, album)
+		}
+	}
+	return albums, nil
+}
+
+// findAlbum returns the auto album of the same event as the photo, if any.
+func findAlbum(albums []*Album, p *Photo, opts Options) *Album {
+	for _, album := range albums {
+		start := album.Period.Start.Add(-opts.MaxGap)
+		end := album.Period.End.Add(opts.MaxGap)
+		if p.Datetime.Before(start) || p.Datetime.After(end) {
+			continue
+		}
+		if opts.farFrom(album.GPS, p.GPS) {
+			continue
+		}
+		return album
+	}
+	return nil
+}
+
+// createAlbum creates an auto album for a cluster of photos.
+func createAlbum(inst *instance.Instance, cluster []*Photo) error {
+	album := &Album{
+		CreatedAt: time.Now().UTC(),
+		Auto:      true,
+		Period: &Period{
+			Start: cluster[0].Datetime,
+			End:   cluster[len(cluster)-1].Datetime,
+		},
+	}
+	for _, p := range cluster {
+		if album.GPS == nil && p.GPS != nil {
+			album.GPS = p.GPS
+		}
+		if album.Place == nil && p.Place != nil {
+			album.Place = p.Place
+		}
+	}
+	album.Name = albumName(album.Period, album.Place)
+	if err := couchdb.CreateDoc(inst, album); err != nil {
+		return err
+	}
+	return addReferences(inst, album, cluster)
+}
+
+// addReferences adds references from the files of the photos to the album.
+func addReferences(inst *instance.Instance, album *Album, photos []*Photo) error {
+	mu := config.Lock().ReadWrite(inst, "vfs")
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	fs := inst.VFS()
+	ref := couchdb.DocReference{Type: consts.PhotosAlbums, ID: album.DocID}
+	for _, p := range photos {
+		file, err := fs.FileByID(p.FileID)
+		if err != nil {
+			continue
+		}
+		old := file.Clone().(*vfs.FileDoc)
+		file.AddReferencedBy(ref)
+		if err := couchdb.UpdateDocWithOld(inst, file, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extendAlbum updates the period of an auto album for some new photos. The
+// document is patched, to keep the fields added by the applications. It
+// returns false if the album is no longer an auto album.
+func extendAlbum(inst *instance.Instance, album *Album, photos []*Photo) (bool, error) {
+	var doc couchdb.JSONDoc
+	if err := couchdb.GetDoc(inst, consts.PhotosAlbums, album.DocID, &doc); err != nil {
+		return false, err
+	}
+	// The user may have changed the album since it has been loaded
+	if auto, _ := doc.M["auto"].(bool); !auto {
+		album.Auto = false
+		return false, nil
+	}
+	for _, p := range photos {
+		if p.Datetime.Before(album.Period.Start) {
+			album.Period.Start = p.Datetime
+		}
+		if p.Datetime.After(album.Period.End) {
+			album.Period.End = p.Datetime
+		}
+		if album.GPS == nil && p.GPS != nil {
+			album.GPS = p.GPS
+		}
+	}
+	doc.Type = consts.PhotosAlbums
+	doc.M["period"] = album.Period
+	if album.GPS != nil {
+		doc.M["gps"] = album.GPS
+	}
+	if err := couchdb.UpdateDoc(inst, &doc); err != nil {
+		return false, err
+	}
+	album.DocRev = doc.Rev()
+	return true, nil
+}
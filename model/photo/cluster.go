@@ -0,0 +1,85 @@
+package photo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+)
+
+// Photo is an image file, with the metadata used for the clustering.
+type Photo struct {
+	FileID   string
+	Datetime time.Time
+	// GPS is nil for the photos without coordinates
+	GPS   *GPS
+	Place *geocoding.Place
+}
+
+// GPS are the coordinates where a photo has been taken.
+type GPS struct {
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// Options are the parameters of the clustering.
+type Options struct {
+	// MaxGap is the maximal duration between two consecutive photos of an
+	// album.
+	MaxGap time.Duration
+	// MaxDistance is the maximal distance, in meters, between two
+	// consecutive photos with GPS coordinates of an album.
+	MaxDistance float64
+	// MinSize is the minimal number of photos for an album.
+	MinSize int
+}
+
+// DefaultOptions are the options used when the settings don't have theirs.
+var DefaultOptions = Options{
+	MaxGap:      6 * time.Hour,
+	MaxDistance: 50000,
+	MinSize:     5,
+}
+
+// farFrom returns true if the two photos are too far to be in the same album.
+// The photos without GPS coordinates are close to any photo.
+func (o Options) farFrom(a, b *GPS) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return geocoding.Distance(a.Lat, a.Long, b.Lat, b.Long) > o.MaxDistance
+}
+
+// Cluster groups the photos in events: a new event starts when there is a gap
+// of more than MaxGap since the previous photo, or when the photo has been
+// taken more than MaxDistance away from the last located photo of the event.
+// The photos are sorted by date, and the clusters smaller than MinSize are
+// returned too.
+func Cluster(photos []*Photo, opts Options) [][]*Photo {
+	sorted := make([]*Photo, len(photos))
+	copy(sorted, photos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Datetime.Before(sorted[j].Datetime)
+	})
+
+	var clusters [][]*Photo
+	var current []*Photo
+	var lastGPS *GPS
+	for _, p := range sorted {
+		if len(current) > 0 {
+			prev := current[len(current)-1]
+			if p.Datetime.Sub(prev.Datetime) > opts.MaxGap || opts.farFrom(lastGPS, p.GPS) {
+				clusters = append(clusters, current)
+				current, lastGPS = nil, nil
+			}
+		}
+		current = append(current, p)
+		if p.GPS != nil {
+			lastGPS = p.GPS
+		}
+	}
+	if len(current) > 0 {
+		clusters = append(clusters, current)
+	}
+	return clusters
+}
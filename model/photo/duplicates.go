@@ -0,0 +1,316 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+// DuplicateDistance is the maximal distance between the hashes of two photos
+// for them to be considered as duplicates.
+const DuplicateDistance = 6
+
+// filesBatchSize is the number of files fetched at once to check the
+// duplicates.
+const filesBatchSize = 500
+
+// HashDoc is an io.cozy.photos.hashes document, with the perceptual hash of
+// a photo. Its identifier is the one of the file. The md5sum of the content is
+// kept to ignore the hashes of the files that have been modified since.
+type HashDoc struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+	Hash   string `json:"hash"`
+	MD5Sum []byte `json:"md5sum"`
+}
+
+// ID implements the couchdb.Doc interface
+func (h *HashDoc) ID() string { return h.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (h *HashDoc) Rev() string { return h.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (h *HashDoc) DocType() string { return consts.PhotosHashes }
+
+// SetID implements the couchdb.Doc interface
+func (h *HashDoc) SetID(id string) { h.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (h *HashDoc) SetRev(rev string) { h.DocRev = rev }
+
+// Clone implements the couchdb.Doc interface
+func (h *HashDoc) Clone() couchdb.Doc {
+	cloned := *h
+	cloned.MD5Sum = make([]byte, len(h.MD5Sum))
+	copy(cloned.MD5Sum, h.MD5Sum)
+	return &cloned
+}
+
+// DuplicateGroup is a group of photos that look the same. The files are
+// sorted by size, and the first one is the one to keep: the savings are the
+// sizes of the other ones.
+type DuplicateGroup struct {
+	DocID   string           `json:"_id,omitempty"`
+	Files   []*DuplicateFile `json:"files"`
+	Size    int64            `json:"size"`
+	Savings int64            `json:"savings"`
+}
+
+// DuplicateFile is a photo of a group of duplicates.
+type DuplicateFile struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	DirID string `json:"dir_id"`
+	Mime  string `json:"mime"`
+	Size  int64  `json:"size"`
+	Hash  string `json:"hash"`
+}
+
+// ID implements the couchdb.Doc interface
+func (g *DuplicateGroup) ID() string { return g.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (g *DuplicateGroup) Rev() string { return "" }
+
+// DocType implements the couchdb.Doc interface
+func (g *DuplicateGroup) DocType() string { return consts.PhotosDuplicates }
+
+// SetID implements the couchdb.Doc interface
+func (g *DuplicateGroup) SetID(id string) { g.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (g *DuplicateGroup) SetRev(rev string) {}
+
+// Clone implements the couchdb.Doc interface
+func (g *DuplicateGroup) Clone() couchdb.Doc {
+	cloned := *g
+	cloned.Files = make([]*DuplicateFile, len(g.Files))
+	for i, f := range g.Files {
+		file := *f
+		cloned.Files[i] = &file
+	}
+	return &cloned
+}
+
+// Links implements the jsonapi.Object interface
+func (g *DuplicateGroup) Links() *jsonapi.LinksList { return nil }
+
+// Relationships implements the jsonapi.Object interface
+func (g *DuplicateGroup) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Included implements the jsonapi.Object interface
+func (g *DuplicateGroup) Included() []jsonapi.Object { return nil }
+
+var (
+	_ couchdb.Doc    = (*HashDoc)(nil)
+	_ jsonapi.Object = (*DuplicateGroup)(nil)
+)
+
+// SaveHash saves the perceptual hash of a photo in the index.
+func SaveHash(inst *instance.Instance, file *vfs.FileDoc, hash Hash) error {
+	doc := &HashDoc{}
+	err := couchdb.GetDoc(inst, consts.PhotosHashes, file.ID(), doc)
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		return err
+	}
+	if doc.Hash == hash.String() && bytes.Equal(doc.MD5Sum, file.MD5Sum) {
+		return nil
+	}
+	doc.DocID = file.ID()
+	doc.Hash = hash.String()
+	doc.MD5Sum = file.MD5Sum
+	if doc.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(inst, doc)
+	}
+	return couchdb.UpdateDoc(inst, doc)
+}
+
+// DeleteHash removes the perceptual hash of a file from the index.
+func DeleteHash(inst *instance.Instance, fileID string) error {
+	doc := &HashDoc{}
+	err := couchdb.GetDoc(inst, consts.PhotosHashes, fileID, doc)
+	if couchdb.IsNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, doc)
+}
+
+// allHashes returns the documents of the index of the perceptual hashes.
+func allHashes(inst *instance.Instance) ([]*HashDoc, error) {
+	var docs []*HashDoc
+	err := couchdb.ForeachDocs(inst, consts.PhotosHashes, func(_ string, data json.RawMessage) error {
+		doc := &HashDoc{}
+		if err := json.Unmarshal(data, doc); err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// HashedFiles returns the set of the identifiers of the files in the index of
+// the perceptual hashes.
+func HashedFiles(inst *instance.Instance) (map[string]bool, error) {
+	docs, err := allHashes(inst)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		ids[doc.DocID] = true
+	}
+	return ids, nil
+}
+
+// FindDuplicates returns the groups of photos that look the same, with the
+// largest savings first. The files in the trash, and the ones modified since
+// their hash has been computed, are ignored.
+func FindDuplicates(inst *instance.Instance) ([]*DuplicateGroup, error) {
+	docs, err := allHashes(inst)
+	if err != nil {
+		return nil, err
+	}
+	var indexed []*HashDoc
+	var hashes []Hash
+	for _, doc := range docs {
+		if h, err := ParseHash(doc.Hash); err == nil {
+			indexed = append(indexed, doc)
+			hashes = append(hashes, h)
+		}
+	}
+
+	candidates := groupHashes(hashes, DuplicateDistance)
+	var ids []string
+	for _, indexes := range candidates {
+		for _, idx := range indexes {
+			ids = append(ids, indexed[idx].DocID)
+		}
+	}
+	files, err := filesByIDs(inst, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*DuplicateGroup
+	for _, indexes := range candidates {
+		group := &DuplicateGroup{}
+		for _, idx := range indexes {
+			doc := indexed[idx]
+			file, ok := files[doc.DocID]
+			if !ok || file.Trashed || !bytes.Equal(file.MD5Sum, doc.MD5Sum) {
+				continue
+			}
+			group.Files = append(group.Files, &DuplicateFile{
+				ID:    file.ID(),
+				Name:  file.DocName,
+				DirID: file.DirID,
+				Mime:  file.Mime,
+				Size:  file.ByteSize,
+				Hash:  doc.Hash,
+			})
+		}
+		if len(group.Files) < 2 {
+			continue
+		}
+		sort.SliceStable(group.Files, func(i, j int) bool {
+			return group.Files[i].Size > group.Files[j].Size
+		})
+		group.DocID = group.Files[0].ID
+		for _, f := range group.Files {
+			group.Size += f.Size
+		}
+		group.Savings = group.Size - group.Files[0].Size
+		groups = append(groups, group)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Savings > groups[j].Savings
+	})
+	return groups, nil
+}
+
+// filesByIDs fetches the files with the given identifiers. The missing files
+// are not in the returned map.
+func filesByIDs(inst *instance.Instance, ids []string) (map[string]*vfs.FileDoc, error) {
+	files := make(map[string]*vfs.FileDoc, len(ids))
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > filesBatchSize {
+			batch = batch[:filesBatchSize]
+		}
+		ids = ids[len(batch):]
+		var docs []*vfs.FileDoc
+		req := &couchdb.AllDocsRequest{Keys: batch}
+		if err := couchdb.GetAllDocs(inst, consts.Files, req, &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if doc != nil && doc.Type == consts.FileType {
+				files[doc.ID()] = doc
+			}
+		}
+	}
+	return files, nil
+}
+
+// groupHashes returns the groups of indexes of the hashes that are at most at
+// the given distance of the first hash of their group. A group is built
+// around a representative, and not by chaining the near matches, so two
+// photos of the same group are never further than twice the distance. The
+// hashes are split in 8 bands of 8 bits: two hashes at a distance less than 8
+// have at least one band in common, so only the hashes that share a band are
+// compared.
+func groupHashes(hashes []Hash, distance int) [][]int {
+	var buckets [8]map[uint8][]int
+	for band := range buckets {
+		buckets[band] = make(map[uint8][]int)
+		for i, h := range hashes {
+			key := uint8(h >> (8 * band))
+			buckets[band][key] = append(buckets[band][key], i)
+		}
+	}
+
+	assigned := make([]bool, len(hashes))
+	var groups [][]int
+	for i, h := range hashes {
+		if assigned[i] {
+			continue
+		}
+		group := []int{i}
+		seen := map[int]bool{i: true}
+		for band := range buckets {
+			for _, j := range buckets[band][uint8(h>>(8*band))] {
+				if assigned[j] || seen[j] {
+					continue
+				}
+				seen[j] = true
+				if h.Distance(hashes[j]) <= distance {
+					group = append(group, j)
+				}
+			}
+		}
+		if len(group) < 2 {
+			continue
+		}
+		sort.Ints(group)
+		for _, j := range group {
+			assigned[j] = true
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
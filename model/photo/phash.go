@@ -0,0 +1,99 @@
+package photo
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"strconv"
+
+	// Registers the decoder for the thumbnails
+	_ "image/jpeg"
+)
+
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// Hash is a perceptual hash of an image: two images that look the same, even
+// with different resolutions, formats or compressions, have hashes with a
+// small Hamming distance.
+//
+// It is a difference hash (dHash): the image is reduced to 9x8 pixels in
+// grayscale, and each bit tells if a pixel is brighter than its right
+// neighbour.
+type Hash uint64
+
+// ComputeHash returns the perceptual hash of an image.
+func ComputeHash(img image.Image) Hash {
+	var gray [hashHeight][hashWidth]float64
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+	// Each pixel of the reduced image is the mean of a block of pixels of
+	// the image, to be less sensitive to the noise and the resize algorithm.
+	for y := 0; y < hashHeight; y++ {
+		y0 := bounds.Min.Y + y*h/hashHeight
+		y1 := bounds.Min.Y + (y+1)*h/hashHeight
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < hashWidth; x++ {
+			x0 := bounds.Min.X + x*w/hashWidth
+			x1 := bounds.Min.X + (x+1)*w/hashWidth
+			if x1 == x0 {
+				x1++
+			}
+			var sum float64
+			for j := y0; j < y1; j++ {
+				for i := x0; i < x1; i++ {
+					r, g, b, _ := img.At(i, j).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			gray[y][x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	var hash Hash
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashFromThumbnail decodes a thumbnail, and returns its perceptual hash.
+func HashFromThumbnail(r io.Reader) (Hash, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+	return ComputeHash(img), nil
+}
+
+// ParseHash parses a hash serialized with its String method.
+func ParseHash(s string) (Hash, error) {
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return Hash(h), nil
+}
+
+// String returns the hash as 16 hexadecimal digits.
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance returns the number of bits that differ between the two hashes.
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
@@ -0,0 +1,211 @@
+package photo
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	paris  = &GPS{Lat: 48.8584, Long: 2.2945}
+	louvre = &GPS{Lat: 48.8606, Long: 2.3376}
+	lyon   = &GPS{Lat: 45.7640, Long: 4.8357}
+)
+
+func at(day, hour int) time.Time {
+	return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC)
+}
+
+func ids(cluster []*Photo) []string {
+	var list []string
+	for _, p := range cluster {
+		list = append(list, p.FileID)
+	}
+	return list
+}
+
+func TestCluster(t *testing.T) {
+	photos := []*Photo{
+		{FileID: "c", Datetime: at(19, 14), GPS: louvre},
+		{FileID: "a", Datetime: at(19, 9), GPS: paris},
+		{FileID: "b", Datetime: at(19, 11)},
+		// Same day, but in another city
+		{FileID: "d", Datetime: at(19, 18), GPS: lyon},
+		{FileID: "e", Datetime: at(19, 20)},
+		// After a long gap
+		{FileID: "f", Datetime: at(21, 10)},
+	}
+	clusters := Cluster(photos, DefaultOptions)
+	assert.Len(t, clusters, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, ids(clusters[0]))
+	assert.Equal(t, []string{"d", "e"}, ids(clusters[1]))
+	assert.Equal(t, []string{"f"}, ids(clusters[2]))
+
+	opts := DefaultOptions
+	opts.MaxGap = 72 * time.Hour
+	opts.MaxDistance = 500000
+	clusters = Cluster(photos, opts)
+	assert.Len(t, clusters, 1)
+
+	assert.Empty(t, Cluster(nil, DefaultOptions))
+}
+
+func TestFindAlbum(t *testing.T) {
+	album := &Album{
+		DocID:  "album",
+		Auto:   true,
+		Period: &Period{Start: at(19, 9), End: at(19, 14)},
+		GPS:    paris,
+	}
+	albums := []*Album{album}
+	assert.Equal(t, album, findAlbum(albums, &Photo{Datetime: at(19, 17), GPS: louvre}, DefaultOptions))
+	assert.Equal(t, album, findAlbum(albums, &Photo{Datetime: at(19, 5)}, DefaultOptions))
+	assert.Nil(t, findAlbum(albums, &Photo{Datetime: at(19, 21)}, DefaultOptions))
+	assert.Nil(t, findAlbum(albums, &Photo{Datetime: at(19, 12), GPS: lyon}, DefaultOptions))
+}
+
+func TestAlbumName(t *testing.T) {
+	place := &geocoding.Place{City: "Paris", Country: "FR"}
+	assert.Equal(t, "Paris, 19 October 2026", albumName(&Period{Start: at(19, 9), End: at(19, 20)}, place))
+	assert.Equal(t, "19 - 21 October 2026", albumName(&Period{Start: at(19, 9), End: at(21, 20)}, nil))
+	end := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "30 October - 2 November 2026", albumName(&Period{Start: at(30, 9), End: end}, nil))
+	end = time.Date(2027, 1, 2, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2026, 12, 30, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "30 December 2026 - 2 January 2027", albumName(&Period{Start: start, End: end}, nil))
+}
+
+func TestToPhoto(t *testing.T) {
+	file := &vfs.FileDoc{
+		Type:      consts.FileType,
+		DocID:     "photo",
+		Class:     "image",
+		CreatedAt: at(20, 0),
+		Metadata: vfs.Metadata{
+			"datetime": "2026-10-19T09:00:00Z",
+			"gps":      map[string]interface{}{"lat": 48.8584, "long": 2.2945},
+			"place":    map[string]interface{}{"city": "Paris", "country": "FR"},
+		},
+	}
+	p := toPhoto(file)
+	assert.Equal(t, &Photo{
+		FileID:   "photo",
+		Datetime: at(19, 9),
+		GPS:      paris,
+		Place:    &geocoding.Place{City: "Paris", Country: "FR"},
+	}, p)
+
+	file.Metadata = nil
+	assert.Equal(t, at(20, 0), toPhoto(file).Datetime)
+
+	file.ReferencedBy = []couchdb.DocReference{{Type: consts.PhotosAlbums, ID: "album"}}
+	assert.Nil(t, toPhoto(file))
+	file.ReferencedBy = nil
+	file.Trashed = true
+	assert.Nil(t, toPhoto(file))
+	file.Trashed = false
+	file.Class = "pdf"
+	assert.Nil(t, toPhoto(file))
+}
+
+func TestUploadedAfter(t *testing.T) {
+	lastRun := at(20, 0)
+	file := &vfs.FileDoc{CreatedAt: at(1, 0)}
+	assert.True(t, uploadedAfter(file, nil))
+	assert.False(t, uploadedAfter(file, &lastRun))
+	uploadedAt := at(21, 0)
+	file.CozyMetadata = &vfs.FilesCozyMetadata{UploadedAt: &uploadedAt}
+	assert.True(t, uploadedAfter(file, &lastRun))
+}
+
+func TestSettingsOptions(t *testing.T) {
+	s := &Settings{}
+	assert.NoError(t, s.validate())
+	assert.Equal(t, DefaultOptions, s.Options())
+
+	s = &Settings{MaxGap: "12h", MaxDistance: 1000, MinSize: 2}
+	assert.NoError(t, s.validate())
+	assert.Equal(t, Options{MaxGap: 12 * time.Hour, MaxDistance: 1000, MinSize: 2}, s.Options())
+
+	assert.Equal(t, ErrInvalidOptions, (&Settings{MaxGap: "soon"}).validate())
+	assert.Equal(t, ErrInvalidOptions, (&Settings{MaxGap: "-1h"}).validate())
+	assert.Equal(t, ErrInvalidOptions, (&Settings{MinSize: -1}).validate())
+}
+
+// scene draws the same picture at any resolution, with a pattern that
+// depends on the seed.
+func scene(width, height int, seed float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			l := 127 + 127*math.Sin(seed*u*7+v*5)*math.Cos(seed*v*3-u*2)
+			img.Set(x, y, color.RGBA{uint8(l), uint8(255 - l), uint8(l / 2), 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := ComputeHash(scene(640, 480, 1))
+	resized := ComputeHash(scene(96, 72, 1))
+	other := ComputeHash(scene(640, 480, 3))
+	assert.LessOrEqual(t, original.Distance(resized), DuplicateDistance)
+	assert.Greater(t, original.Distance(other), DuplicateDistance)
+
+	// A JPEG compression doesn't change much the hash
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, scene(640, 480, 1), &jpeg.Options{Quality: 40}))
+	compressed, err := HashFromThumbnail(&buf)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, original.Distance(compressed), DuplicateDistance)
+
+	_, err = HashFromThumbnail(bytes.NewReader([]byte("not an image")))
+	assert.Error(t, err)
+
+	parsed, err := ParseHash(original.String())
+	assert.NoError(t, err)
+	assert.Equal(t, original, parsed)
+	assert.Len(t, Hash(42).String(), 16)
+	_, err = ParseHash("xyz")
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, Hash(0).Distance(0))
+	assert.Equal(t, 64, Hash(0).Distance(^Hash(0)))
+}
+
+func TestGroupHashes(t *testing.T) {
+	hashes := []Hash{
+		0x00000000000000ff,
+		0xffffffff00000000,
+		0x00000000000000fe, // 1 bit from the first one
+		0x0f0f0f0f0f0f0f0f,
+		0xffffffff000000f0, // 4 bits from the second one
+		0x00000000000001fe, // 1 bit from the third one
+	}
+	groups := groupHashes(hashes, DuplicateDistance)
+	assert.Equal(t, [][]int{{0, 2, 5}, {1, 4}}, groups)
+
+	assert.Empty(t, groupHashes(hashes, 0))
+
+	// The near matches are not chained: the third hash is at 4 bits of the
+	// second one, but at 8 bits of the first one, which is the representative.
+	chain := []Hash{
+		0x0000000000000000,
+		0x000000000000000f,
+		0x00000000000000ff,
+	}
+	assert.Equal(t, [][]int{{0, 1}}, groupHashes(chain, DuplicateDistance))
+	assert.Empty(t, groupHashes(nil, DuplicateDistance))
+}
@@ -79,4 +79,7 @@ var (
 	ErrFileInTrash = errors.New("Cannot share trashed file")
 	// ErrSystemFolder is used when trying to share a system folder
 	ErrSystemFolder = errors.New("Cannot share system folder")
+	// ErrExternalMount is used when trying to share an external mount, or a
+	// file inside it
+	ErrExternalMount = errors.New("Cannot share an external mount")
 )
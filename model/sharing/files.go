@@ -19,6 +19,7 @@ import (
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/metadata"
 	multierror "github.com/hashicorp/go-multierror"
@@ -35,6 +36,15 @@ func isTrashed(doc couchdb.JSONDoc) bool {
 	return strings.HasPrefix(doc.Get("path").(string), vfs.TrashDirName+"/")
 }
 
+// isExternalMount returns true for the directory where an external storage
+// is mounted.
+func isExternalMount(doc couchdb.JSONDoc) bool {
+	if doc.Type != consts.Files || doc.Get("type") != consts.DirType {
+		return false
+	}
+	return doc.Get("mount") != nil
+}
+
 // MakeXorKey generates a key for transforming the file identifiers
 func MakeXorKey() []byte {
 	random := crypto.GenerateRandomBytes(8)
@@ -394,6 +404,60 @@ func (s *Sharing) RemoveReferenceForSharing(inst *instance.Instance, rule *Rule)
 	return indexer.UpdateFileDoc(file, newdoc)
 }
 
+// checkExternalMounts returns an error if a rule of the sharing is on an
+// external mount, on a file inside it, or on a directory that has an external
+// mount in its descendants: their content is not in CouchDB, and can't be
+// replicated to the other members.
+func (s *Sharing) checkExternalMounts(inst *instance.Instance) error {
+	for _, rule := range s.Rules {
+		if !rule.FilesByID() {
+			continue
+		}
+		for _, val := range rule.Values {
+			if vfs.IsMountedID(val) {
+				return ErrExternalMount
+			}
+			dir, err := inst.VFS().DirByID(val)
+			if err != nil {
+				continue
+			}
+			if dir.Mount != nil {
+				return ErrExternalMount
+			}
+			inside, err := hasMountInside(inst, dir)
+			if err != nil {
+				return err
+			}
+			if inside {
+				return ErrExternalMount
+			}
+		}
+	}
+	return nil
+}
+
+// hasMountInside returns true if a descendant of the directory is an external
+// mount.
+func hasMountInside(inst *instance.Instance, dir *vfs.DirDoc) (bool, error) {
+	prefix := dir.Fullpath
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var mounts []*vfs.DirDoc
+	req := &couchdb.FindRequest{
+		UseIndex: "dir-by-path",
+		Selector: mango.And(
+			mango.StartWith("path", prefix),
+			mango.Exists("mount"),
+		),
+		Limit: 1,
+	}
+	if err := couchdb.FindDocs(inst, consts.Files, req, &mounts); err != nil {
+		return false, err
+	}
+	return len(mounts) > 0, nil
+}
+
 // ValidateDriveRoot validates that a file or folder can be used to create a
 // shared drive.
 func ValidateDriveRoot(inst *instance.Instance, rootID string) (*vfs.DirDoc, *vfs.FileDoc, error) {
@@ -405,6 +469,10 @@ func ValidateDriveRoot(inst *instance.Instance, rootID string) (*vfs.DirDoc, *vf
 		return nil, nil, ErrSystemFolder
 	}
 
+	if vfs.IsMountedID(rootID) {
+		return nil, nil, ErrExternalMount
+	}
+
 	fs := inst.VFS()
 	dir, file, err := fs.DirOrFileByID(rootID)
 	if err != nil {
@@ -420,6 +488,9 @@ func ValidateDriveRoot(inst *instance.Instance, rootID string) (*vfs.DirDoc, *vf
 		if strings.HasPrefix(dir.Fullpath, vfs.TrashDirName+"/") {
 			return nil, nil, ErrSystemFolder
 		}
+		if dir.Mount != nil {
+			return nil, nil, ErrExternalMount
+		}
 		if err := checkRootForSharing(dir.ReferencedBy, ErrFolderAlreadyShared); err != nil {
 			return nil, nil, err
 		}
@@ -102,6 +102,19 @@ func TestNonNilFileDocs(t *testing.T) {
 	assert.Equal(t, []*vfs.FileDoc{noteFile, textFile}, docs)
 }
 
+func TestIsExternalMount(t *testing.T) {
+	mountDir := couchdb.JSONDoc{Type: consts.Files, M: map[string]interface{}{
+		"type":  consts.DirType,
+		"mount": map[string]interface{}{"provider": "webdav"},
+	}}
+	assert.True(t, isExternalMount(mountDir))
+
+	dir := couchdb.JSONDoc{Type: consts.Files, M: map[string]interface{}{
+		"type": consts.DirType,
+	}}
+	assert.False(t, isExternalMount(dir))
+}
+
 func TestFiles(t *testing.T) {
 	if testing.Short() {
 		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
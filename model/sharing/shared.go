@@ -375,6 +375,12 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 		if skip, err := isTheSharingDirectory(inst, msg, evt); err != nil || skip {
 			return err
 		}
+		// The content of an external mount is not in CouchDB, and the mount
+		// directory, with its account, must not be sent to the members, even
+		// if it has been moved inside a shared directory.
+		if isExternalMount(evt.Doc) {
+			return nil
+		}
 		var err error
 		removed, err = isNoLongerShared(inst, msg, evt)
 		if err != nil {
@@ -484,6 +484,9 @@ func (s *Sharing) Create(inst *instance.Instance) (*permission.Permission, error
 	if err := s.ValidateRules(); err != nil {
 		return nil, err
 	}
+	if err := s.checkExternalMounts(inst); err != nil {
+		return nil, err
+	}
 	if s.Drive {
 		if !IsValidDriveRootType(s.DriveRootType) {
 			return nil, ErrInvalidRule
@@ -0,0 +1,155 @@
+package takeout
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// entry is a regular file of an archive. Its content can be read only during
+// the call of the walk function.
+type entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Content io.Reader
+}
+
+// archive is a zip or tar.gz file, opened for reading.
+type archive interface {
+	// Totals returns the number of files and their total size, if it is known
+	// before reading the archive.
+	Totals() (files, bytes int64, ok bool)
+	// Walk calls fn for each regular file of the archive, in the order of the
+	// archive.
+	Walk(fn func(e *entry) error) error
+}
+
+// openArchive detects the format of an archive from its first bytes.
+func openArchive(r io.ReaderAt, size int64) (archive, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, ErrInvalidSource
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, ErrInvalidSource
+		}
+		return &zipArchive{zr}, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return &tarArchive{io.NewSectionReader(r, 0, size)}, nil
+	default:
+		return nil, ErrInvalidSource
+	}
+}
+
+type zipArchive struct {
+	r *zip.Reader
+}
+
+func (z *zipArchive) Totals() (int64, int64, bool) {
+	var files, size int64
+	for _, f := range z.r.File {
+		if f.Mode().IsRegular() {
+			files++
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	return files, size, true
+}
+
+func (z *zipArchive) Walk(fn func(e *entry) error) error {
+	for _, f := range z.r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		name, ok := cleanEntryName(f.Name)
+		if !ok {
+			continue
+		}
+		content, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(&entry{
+			Name:    name,
+			Size:    int64(f.UncompressedSize64),
+			ModTime: f.Modified,
+			Content: content,
+		})
+		if errc := content.Close(); err == nil {
+			err = errc
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type tarArchive struct {
+	r io.Reader
+}
+
+func (t *tarArchive) Totals() (int64, int64, bool) {
+	return 0, 0, false
+}
+
+func (t *tarArchive) Walk(fn func(e *entry) error) error {
+	gr, err := gzip.NewReader(t.r)
+	if err != nil {
+		return ErrInvalidSource
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, ok := cleanEntryName(hdr.Name)
+		if !ok {
+			continue
+		}
+		err = fn(&entry{
+			Name:    name,
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime,
+			Content: tr,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanEntryName returns the name of an entry as a relative path without
+// "." and ".." segments, and false for the names that can't be imported.
+func cleanEntryName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, "/")
+	for _, part := range strings.Split(name, "/") {
+		// The metadata added by macOS to the zip files
+		if part == "__MACOSX" || part == ".DS_Store" {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,517 @@
+package takeout
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/ical"
+)
+
+// takeoutRoot is the root directory of the archives of Google Takeout.
+const takeoutRoot = "Takeout"
+
+// maxDataSize is the maximal size of a vCard or iCalendar file.
+const maxDataSize = 50 << 20
+
+// saveInterval is the minimal delay between two saves of the progress.
+const saveInterval = 3 * time.Second
+
+var (
+	errCanceled = errors.New("the import has been canceled")
+	errQuota    = errors.New("there is not enough space left to import the archive")
+)
+
+// The names of the products in the Google Takeout archives, in the main
+// languages.
+var (
+	driveProducts  = []string{"Drive", "Google Drive"}
+	photosProducts = []string{"Google Photos", "Google Fotos", "Google Foto", "Google Photo"}
+)
+
+// systemLabels are the labels of Google Contacts that are not groups.
+var systemLabels = []string{"myContacts", "starred"}
+
+// importedPhoto is a photo imported from Google Photos, for which the
+// metadata of the sidecar will be added at the end of the import.
+type importedPhoto struct {
+	entryPath string
+	fileID    string
+}
+
+// pendingContact is a contact read from a vCard, saved at the end of the
+// import when all the groups are known.
+type pendingContact struct {
+	contact *contact.Contact
+	groups  []string
+}
+
+type importer struct {
+	inst      *instance.Instance
+	fs        vfs.VFS
+	doc       *Migration
+	dirs      map[string]*vfs.DirDoc
+	sidecars  sidecars
+	photos    []importedPhoto
+	cards     map[string]bool
+	contacts  []pendingContact
+	calendars []*calendar.Calendar
+	lastSave  time.Time
+}
+
+// Run imports the archives of a migration. It is called by the worker.
+func Run(inst *instance.Instance, migrationID string) error {
+	doc, err := Get(inst, migrationID)
+	if err != nil {
+		return err
+	}
+	if doc.IsTerminal() {
+		return nil
+	}
+	now := time.Now().UTC()
+	doc.Status = MigrationStatusRunning
+	doc.StartedAt = &now
+	if err := couchdb.UpdateDoc(inst, doc); err != nil {
+		return err
+	}
+
+	imp := &importer{
+		inst:     inst,
+		fs:       inst.VFS(),
+		doc:      doc,
+		dirs:     make(map[string]*vfs.DirDoc),
+		sidecars: make(sidecars),
+		cards:    make(map[string]bool),
+		lastSave: time.Now(),
+	}
+	err = imp.run()
+
+	finishedAt := time.Now().UTC()
+	doc.FinishedAt = &finishedAt
+	switch {
+	case errors.Is(err, errCanceled):
+		doc.Status = MigrationStatusCanceled
+		doc.CanceledAt = &finishedAt
+		err = nil
+	case err != nil:
+		doc.Status = MigrationStatusFailed
+		doc.addError("", err)
+	default:
+		doc.Status = MigrationStatusCompleted
+	}
+	if errs := imp.save(true); errs != nil && !errors.Is(errs, errCanceled) {
+		inst.Logger().WithNamespace("takeout").
+			Warnf("Cannot save the migration %s: %s", doc.DocID, errs)
+	}
+	return err
+}
+
+func (imp *importer) run() error {
+	for _, id := range imp.doc.FileIDs {
+		file, err := imp.fs.FileByID(id)
+		if err != nil {
+			return err
+		}
+		content, err := imp.fs.OpenFile(file)
+		if err != nil {
+			return err
+		}
+		err = imp.importArchive(content, file.ByteSize)
+		if errc := content.Close(); err == nil {
+			err = errc
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range imp.doc.Paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			err = imp.importArchive(f, info.Size())
+		}
+		if errc := f.Close(); err == nil {
+			err = errc
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := imp.applySidecars(); err != nil {
+		return err
+	}
+	if err := imp.saveContacts(); err != nil {
+		return err
+	}
+	return imp.save(true)
+}
+
+func (imp *importer) importArchive(r io.ReaderAt, size int64) error {
+	arch, err := openArchive(r, size)
+	if err != nil {
+		return err
+	}
+	files, bytes, known := arch.Totals()
+	if known {
+		imp.doc.Progress.FilesTotal += files
+		imp.doc.Progress.BytesTotal += bytes
+		if err := imp.save(true); err != nil {
+			return err
+		}
+	}
+	return arch.Walk(func(e *entry) error {
+		if !known {
+			imp.doc.Progress.FilesTotal++
+			imp.doc.Progress.BytesTotal += e.Size
+		}
+		if err := imp.importEntry(e); err != nil {
+			return err
+		}
+		return imp.save(false)
+	})
+}
+
+// importEntry imports a file of an archive. The archives of Google Takeout
+// have a directory by product, and the other archives are imported as a tree
+// of files.
+func (imp *importer) importEntry(e *entry) error {
+	parts := strings.Split(e.Name, "/")
+	if parts[0] != takeoutRoot {
+		_, _, err := imp.importFile(path.Join(imp.doc.TargetDir, e.Name), e)
+		return err
+	}
+	if len(parts) < 3 {
+		imp.doc.addSkipped(e.Name, "unsupported", e.Size)
+		return nil
+	}
+
+	product, rest := parts[1], path.Join(parts[2:]...)
+	ext := strings.ToLower(path.Ext(e.Name))
+	switch {
+	case isOneOf(product, driveProducts):
+		_, _, err := imp.importFile(path.Join(imp.doc.TargetDir, "Drive", rest), e)
+		return err
+	case isOneOf(product, photosProducts):
+		if ext == ".json" {
+			if sc := readSidecar(e.Content); sc != nil {
+				imp.sidecars.add(e.Name, sc)
+				imp.imported(e)
+			} else {
+				imp.doc.addSkipped(e.Name, "metadata", e.Size)
+			}
+			return nil
+		}
+		file, created, err := imp.importFile(path.Join(imp.doc.TargetDir, "Photos", rest), e)
+		if file != nil {
+			imp.photos = append(imp.photos, importedPhoto{entryPath: e.Name, fileID: file.DocID})
+		}
+		if created {
+			imp.doc.Progress.PhotosImported++
+		}
+		return err
+	case ext == ".vcf":
+		return imp.readContacts(e)
+	case ext == ".ics":
+		return imp.importCalendar(e)
+	default:
+		imp.doc.addSkipped(e.Name, "unsupported", e.Size)
+		return nil
+	}
+}
+
+func (imp *importer) imported(e *entry) {
+	imp.doc.Progress.FilesImported++
+	imp.doc.Progress.BytesImported += e.Size
+}
+
+// importFile writes the content of an entry in a file of the VFS. A file that
+// already exists with the same size is kept, so that an import can be
+// retried: it is returned, but created is false.
+func (imp *importer) importFile(fullpath string, e *entry) (file *vfs.FileDoc, created bool, err error) {
+	dir, err := imp.ensureDir(path.Dir(fullpath))
+	if err != nil {
+		return nil, false, err
+	}
+	name := path.Base(fullpath)
+	if existing, err := imp.fs.FileByPath(fullpath); err == nil {
+		if existing.ByteSize == e.Size {
+			imp.doc.addSkipped(e.Name, "already imported", e.Size)
+			return existing, false, nil
+		}
+		name = vfs.ConflictName(imp.fs, dir.DocID, name, true)
+	}
+
+	mime, class := vfs.ExtractMimeAndClassFromFilename(name)
+	modTime := e.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	doc, err := vfs.NewFileDoc(name, dir.DocID, e.Size, nil, mime, class, modTime,
+		false, // executable
+		false, // trashed
+		false, // encrypted
+		nil,   // tags
+	)
+	if err != nil {
+		imp.doc.addError(e.Name, err)
+		imp.doc.addSkipped(e.Name, "invalid name", e.Size)
+		return nil, false, nil
+	}
+	doc.CozyMetadata = vfs.NewCozyMetadata(imp.inst.PageURL("/", nil))
+
+	fd, err := imp.fs.CreateFile(doc, nil)
+	if err == nil {
+		_, err = io.Copy(fd, e.Content)
+		if errc := fd.Close(); err == nil {
+			err = errc
+		}
+	}
+	switch {
+	case errors.Is(err, vfs.ErrFileTooBig):
+		return nil, false, errQuota
+	case errors.Is(err, vfs.ErrMaxFileSize):
+		imp.doc.addSkipped(e.Name, "too big", e.Size)
+		return nil, false, nil
+	case err != nil:
+		imp.doc.addError(e.Name, err)
+		imp.doc.addSkipped(e.Name, "error", e.Size)
+		return nil, false, nil
+	}
+	imp.imported(e)
+	return doc, true, nil
+}
+
+func (imp *importer) ensureDir(fullpath string) (*vfs.DirDoc, error) {
+	if dir, ok := imp.dirs[fullpath]; ok {
+		return dir, nil
+	}
+	dir, err := vfs.MkdirAll(imp.fs, fullpath)
+	if err != nil {
+		return nil, err
+	}
+	imp.dirs[fullpath] = dir
+	return dir, nil
+}
+
+// readContacts reads the vCards of a file. The same contact is in the file
+// of each of its labels, so the duplicates are ignored.
+func (imp *importer) readContacts(e *entry) error {
+	cards, err := ical.ParseAll(io.LimitReader(e.Content, maxDataSize))
+	if err != nil {
+		imp.doc.addError(e.Name, err)
+		imp.doc.addSkipped(e.Name, "invalid vCard", e.Size)
+		return nil
+	}
+	for _, card := range cards {
+		if card.Name != "VCARD" {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := ical.Encode(&buf, card); err != nil {
+			continue
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		key := hex.EncodeToString(sum[:])
+		if imp.cards[key] {
+			continue
+		}
+		imp.cards[key] = true
+
+		pending := pendingContact{contact: contact.FromVCard(card)}
+		for _, p := range card.GetAll("CATEGORIES") {
+			for _, label := range p.Texts() {
+				label = strings.TrimSpace(label)
+				if label != "" && !isOneOf(label, systemLabels) {
+					pending.groups = append(pending.groups, label)
+				}
+			}
+		}
+		imp.contacts = append(imp.contacts, pending)
+	}
+	imp.imported(e)
+	return nil
+}
+
+// saveContacts creates the groups and the contacts read from the vCards. The
+// contacts with an email address already known are not imported again.
+func (imp *importer) saveContacts() error {
+	if len(imp.contacts) == 0 {
+		return nil
+	}
+	var groups []*contact.Group
+	err := couchdb.GetAllDocs(imp.inst, consts.Groups, nil, &groups)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	groupIDs := make(map[string]string)
+	for _, g := range groups {
+		groupIDs[g.Name()] = g.ID()
+	}
+
+	for _, pending := range imp.contacts {
+		c := pending.contact
+		if addr, err := c.ToMailAddress(); err == nil {
+			if _, err := contact.FindByEmail(imp.inst, addr.Email); err == nil {
+				continue
+			}
+		}
+		for _, name := range pending.groups {
+			id, ok := groupIDs[name]
+			if !ok {
+				g := contact.NewGroup()
+				g.M["name"] = name
+				if err := couchdb.CreateDoc(imp.inst, g); err != nil {
+					return err
+				}
+				id = g.ID()
+				groupIDs[name] = id
+			}
+			c.AddGroup(id)
+		}
+		if err := couchdb.CreateDoc(imp.inst, c); err != nil {
+			imp.doc.addError("", fmt.Errorf("contact %s: %w", c.PrimaryName(), err))
+			continue
+		}
+		imp.doc.Progress.ContactsImported++
+		if err := imp.save(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importCalendar imports the events of an iCalendar file in the calendar with
+// the same name, which is created if needed.
+func (imp *importer) importCalendar(e *entry) error {
+	data, err := io.ReadAll(io.LimitReader(e.Content, maxDataSize))
+	if err != nil {
+		return err
+	}
+	vcal, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		imp.doc.addError(e.Name, err)
+		imp.doc.addSkipped(e.Name, "invalid iCalendar", e.Size)
+		return nil
+	}
+	name := strings.TrimSpace(vcal.Text("X-WR-CALNAME"))
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(e.Name), path.Ext(e.Name))
+	}
+	cal, err := imp.findOrCreateCalendar(name, vcal.Text("X-WR-TIMEZONE"))
+	if err != nil {
+		return err
+	}
+	res, err := calendar.Import(imp.inst, cal, bytes.NewReader(data))
+	if err != nil {
+		imp.doc.addError(e.Name, err)
+		imp.doc.addSkipped(e.Name, "invalid iCalendar", e.Size)
+		return nil
+	}
+	imp.doc.Progress.EventsImported += int64(res.Created + res.Updated)
+	imp.imported(e)
+	return nil
+}
+
+func (imp *importer) findOrCreateCalendar(name, timezone string) (*calendar.Calendar, error) {
+	if imp.calendars == nil {
+		calendars, err := calendar.List(imp.inst)
+		if err != nil {
+			return nil, err
+		}
+		imp.calendars = append([]*calendar.Calendar{}, calendars...)
+	}
+	for _, cal := range imp.calendars {
+		if cal.Name == name {
+			return cal, nil
+		}
+	}
+	cal := &calendar.Calendar{Name: name, Timezone: timezone}
+	err := calendar.Create(imp.inst, cal)
+	if errors.Is(err, calendar.ErrInvalidCalendar) && timezone != "" {
+		cal.Timezone = ""
+		err = calendar.Create(imp.inst, cal)
+	}
+	if err != nil {
+		return nil, err
+	}
+	imp.calendars = append(imp.calendars, cal)
+	return cal, nil
+}
+
+// applySidecars adds the metadata of the JSON sidecars to the photos. It is
+// done at the end, as the sidecars can be before or after their photo in the
+// archives, or even in another part of the export.
+func (imp *importer) applySidecars() error {
+	for _, photo := range imp.photos {
+		sc := imp.sidecars.find(photo.entryPath)
+		if sc == nil {
+			continue
+		}
+		olddoc, err := imp.fs.FileByID(photo.fileID)
+		if err != nil {
+			continue
+		}
+		newdoc := olddoc.Clone().(*vfs.FileDoc)
+		if newdoc.Metadata == nil {
+			newdoc.Metadata = vfs.NewMetadata()
+		}
+		for k, v := range sc.metadata() {
+			newdoc.Metadata[k] = v
+		}
+		if err := imp.fs.UpdateFileDoc(olddoc, newdoc); err != nil {
+			imp.doc.addError(photo.entryPath, err)
+			continue
+		}
+		imp.doc.Progress.PhotosWithMeta++
+		if err := imp.save(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save updates the tracking document, at most every few seconds unless force
+// is true. It returns errCanceled when a cancel has been requested.
+func (imp *importer) save(force bool) error {
+	if !force && time.Since(imp.lastSave) < saveInterval {
+		return nil
+	}
+	imp.lastSave = time.Now()
+	current, err := Get(imp.inst, imp.doc.DocID)
+	if err != nil {
+		return err
+	}
+	imp.doc.DocRev = current.DocRev
+	if current.CancelRequested {
+		imp.doc.CancelRequested = true
+		if !imp.doc.IsTerminal() {
+			return errCanceled
+		}
+	}
+	return couchdb.UpdateDoc(imp.inst, imp.doc)
+}
+
+func isOneOf(value string, list []string) bool {
+	for _, item := range list {
+		if strings.EqualFold(value, item) {
+			return true
+		}
+	}
+	return false
+}
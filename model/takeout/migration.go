@@ -0,0 +1,341 @@
+// Package takeout is for importing the archives exported from other clouds,
+// like Google Takeout: the files of Drive go in the file tree, the photos are
+// imported with the metadata of their JSON sidecars, the vCards become
+// contacts and the iCalendar files become calendars.
+package takeout
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+// WorkerType is the type of the worker that imports the archives.
+const WorkerType = "takeout"
+
+const (
+	MigrationStatusPending   = "pending"
+	MigrationStatusRunning   = "running"
+	MigrationStatusCompleted = "completed"
+	MigrationStatusFailed    = "failed"
+	MigrationStatusCanceled  = "canceled"
+)
+
+// DefaultTargetDir is the directory where the files are imported when no
+// target directory is given.
+const DefaultTargetDir = "/Takeout"
+
+// maxReported is the maximal number of errors and skipped files kept in the
+// migration document, to keep it small for the huge archives.
+const maxReported = 100
+
+// migrationLockName is the per-instance lock held while checking that no
+// other import is running and creating the tracking document.
+const migrationLockName = "takeout/migration"
+
+var (
+	// ErrMigrationConflict is used when an import is already in progress.
+	ErrMigrationConflict = errors.New("an import is already in progress")
+	// ErrMigrationNotFound is used when the tracking document can't be found.
+	ErrMigrationNotFound = errors.New("import not found")
+	// ErrMigrationAlreadyTerminal is used when cancel is requested on an
+	// import that has already reached a terminal state.
+	ErrMigrationAlreadyTerminal = errors.New("import already in a terminal state")
+	// ErrNoSource is used when no archive is given for an import.
+	ErrNoSource = errors.New("no archive to import")
+	// ErrInvalidSource is used when an archive is not a zip or tar.gz file.
+	ErrInvalidSource = errors.New("the archive must be a zip or tar.gz file")
+	// ErrInvalidTargetDir is used when the target directory is not a clean
+	// absolute path.
+	ErrInvalidTargetDir = errors.New("target_dir must be a clean absolute path")
+)
+
+// Migration is the io.cozy.takeout.migrations tracking document. The archives
+// are read in order, as a large export can be split in several parts.
+type Migration struct {
+	DocID           string            `json:"_id,omitempty"`
+	DocRev          string            `json:"_rev,omitempty"`
+	Status          string            `json:"status"`
+	TargetDir       string            `json:"target_dir"`
+	FileIDs         []string          `json:"file_ids,omitempty"`
+	Paths           []string          `json:"paths,omitempty"`
+	Progress        MigrationProgress `json:"progress"`
+	Errors          []MigrationError  `json:"errors"`
+	Skipped         []SkippedFile     `json:"skipped"`
+	StartedAt       *time.Time        `json:"started_at"`
+	FinishedAt      *time.Time        `json:"finished_at"`
+	CancelRequested bool              `json:"cancel_requested,omitempty"`
+	CanceledAt      *time.Time        `json:"canceled_at,omitempty"`
+}
+
+// MigrationProgress has the counters of an import. The totals are known
+// upfront for the zip archives, and grow while the tar.gz archives are read.
+type MigrationProgress struct {
+	FilesImported    int64 `json:"files_imported"`
+	FilesSkipped     int64 `json:"files_skipped"`
+	FilesTotal       int64 `json:"files_total"`
+	BytesImported    int64 `json:"bytes_imported"`
+	BytesTotal       int64 `json:"bytes_total"`
+	PhotosImported   int64 `json:"photos_imported"`
+	PhotosWithMeta   int64 `json:"photos_with_metadata"`
+	ContactsImported int64 `json:"contacts_imported"`
+	EventsImported   int64 `json:"events_imported"`
+}
+
+// MigrationError is an error for a file of the archive, or for the whole
+// import when Path is empty.
+type MigrationError struct {
+	Path    string    `json:"path,omitempty"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// SkippedFile is a file of the archive that has not been imported.
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+func (m *Migration) ID() string        { return m.DocID }
+func (m *Migration) Rev() string       { return m.DocRev }
+func (m *Migration) DocType() string   { return consts.TakeoutMigrations }
+func (m *Migration) SetID(id string)   { m.DocID = id }
+func (m *Migration) SetRev(rev string) { m.DocRev = rev }
+
+func (m *Migration) Clone() couchdb.Doc {
+	cloned := *m
+	cloned.FileIDs = append([]string(nil), m.FileIDs...)
+	cloned.Paths = append([]string(nil), m.Paths...)
+	cloned.Errors = append([]MigrationError{}, m.Errors...)
+	cloned.Skipped = append([]SkippedFile{}, m.Skipped...)
+	if m.StartedAt != nil {
+		t := *m.StartedAt
+		cloned.StartedAt = &t
+	}
+	if m.FinishedAt != nil {
+		t := *m.FinishedAt
+		cloned.FinishedAt = &t
+	}
+	if m.CanceledAt != nil {
+		t := *m.CanceledAt
+		cloned.CanceledAt = &t
+	}
+	return &cloned
+}
+
+// Fetch implements the permission.Fetcher interface
+func (m *Migration) Fetch(field string) []string {
+	switch field {
+	case "status":
+		return []string{m.Status}
+	}
+	return nil
+}
+
+// IsTerminal reports whether the import has reached a final state
+// (completed, failed, or canceled).
+func (m *Migration) IsTerminal() bool {
+	switch m.Status {
+	case MigrationStatusCompleted, MigrationStatusFailed, MigrationStatusCanceled:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Migration) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/takeout/migrations/" + m.DocID}
+}
+func (m *Migration) Relationships() jsonapi.RelationshipMap { return nil }
+func (m *Migration) Included() []jsonapi.Object             { return nil }
+
+var (
+	_ couchdb.Doc    = (*Migration)(nil)
+	_ jsonapi.Object = (*Migration)(nil)
+)
+
+func (m *Migration) addError(entryPath string, err error) {
+	if len(m.Errors) < maxReported {
+		m.Errors = append(m.Errors, MigrationError{
+			Path:    entryPath,
+			Message: err.Error(),
+			At:      time.Now().UTC(),
+		})
+	}
+}
+
+func (m *Migration) addSkipped(entryPath, reason string, size int64) {
+	m.Progress.FilesSkipped++
+	if len(m.Skipped) < maxReported {
+		m.Skipped = append(m.Skipped, SkippedFile{
+			Path:   entryPath,
+			Reason: reason,
+			Size:   size,
+		})
+	}
+}
+
+// StartOptions are the archives to import and the target directory. The
+// archives can be files of the VFS of the instance, or local files on the
+// server (only for the admin API).
+type StartOptions struct {
+	FileIDs   []string
+	Paths     []string
+	TargetDir string
+}
+
+// Start checks the archives, creates the tracking document and pushes the job
+// for the import.
+func Start(inst *instance.Instance, opts StartOptions) (*Migration, error) {
+	if len(opts.FileIDs) == 0 && len(opts.Paths) == 0 {
+		return nil, ErrNoSource
+	}
+	targetDir := strings.TrimRight(opts.TargetDir, "/")
+	if targetDir == "" {
+		targetDir = DefaultTargetDir
+	}
+	if !strings.HasPrefix(targetDir, "/") || path.Clean(targetDir) != targetDir ||
+		strings.HasPrefix(targetDir, vfs.TrashDirName) {
+		return nil, ErrInvalidTargetDir
+	}
+	fs := inst.VFS()
+	for _, id := range opts.FileIDs {
+		file, err := fs.FileByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if file.Trashed || !isArchiveName(file.DocName) {
+			return nil, ErrInvalidSource
+		}
+	}
+	for _, p := range opts.Paths {
+		if info, err := os.Stat(p); err != nil {
+			return nil, err
+		} else if info.IsDir() || !isArchiveName(p) {
+			return nil, ErrInvalidSource
+		}
+	}
+
+	mutex := config.Lock().ReadWrite(inst, migrationLockName)
+	if err := mutex.Lock(); err != nil {
+		return nil, err
+	}
+	defer mutex.Unlock()
+
+	if active, err := FindActiveMigration(inst); err != nil {
+		return nil, err
+	} else if active != nil {
+		return nil, ErrMigrationConflict
+	}
+
+	doc := &Migration{
+		Status:    MigrationStatusPending,
+		TargetDir: targetDir,
+		FileIDs:   opts.FileIDs,
+		Paths:     opts.Paths,
+		Errors:    []MigrationError{},
+		Skipped:   []SkippedFile{},
+	}
+	if err := couchdb.CreateDoc(inst, doc); err != nil {
+		return nil, err
+	}
+	msg, err := job.NewMessage(map[string]string{"migration_id": doc.DocID})
+	if err == nil {
+		_, err = job.System().PushJob(inst, &job.JobRequest{
+			WorkerType: WorkerType,
+			Message:    msg,
+		})
+	}
+	if err != nil {
+		_ = doc.markFailed(inst, err)
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Get returns the tracking document with the given identifier.
+func Get(inst *instance.Instance, id string) (*Migration, error) {
+	doc := &Migration{}
+	if err := couchdb.GetDoc(inst, consts.TakeoutMigrations, id, doc); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrMigrationNotFound
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// FindActiveMigration returns the first pending or running import, or (nil,
+// nil) if none.
+func FindActiveMigration(inst *instance.Instance) (*Migration, error) {
+	var docs []*Migration
+	req := &couchdb.FindRequest{
+		UseIndex: "by-status",
+		Selector: mango.In("status", []interface{}{
+			MigrationStatusPending,
+			MigrationStatusRunning,
+		}),
+		Limit: 1,
+	}
+	err := couchdb.FindDocs(inst, consts.TakeoutMigrations, req, &docs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) || couchdb.IsNoUsableIndexError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return docs[0], nil
+}
+
+// Cancel asks the worker to stop the import. The worker checks the flag
+// regularly and marks the import as canceled.
+func Cancel(inst *instance.Instance, id string) (*Migration, error) {
+	doc, err := Get(inst, id)
+	if err != nil {
+		return nil, err
+	}
+	if doc.IsTerminal() {
+		return nil, ErrMigrationAlreadyTerminal
+	}
+	doc.CancelRequested = true
+	if err := couchdb.UpdateDoc(inst, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (m *Migration) markFailed(inst *instance.Instance, cause error) error {
+	now := time.Now().UTC()
+	m.Status = MigrationStatusFailed
+	if m.FinishedAt == nil {
+		m.FinishedAt = &now
+	}
+	m.addError("", cause)
+	return couchdb.UpdateDoc(inst, m)
+}
+
+// isArchiveName returns true for the names of the zip and tar.gz files.
+func isArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tgz", ".tar.gz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package takeout
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// maxSidecarSize is the maximal size of a JSON sidecar. The bigger JSON files
+// are not the metadata of a photo.
+const maxSidecarSize = 1 << 20
+
+// sidecarSuffix is the suffix added by Google Photos to the name of the
+// photos for their JSON sidecar, since 2024. It is often truncated.
+const sidecarSuffix = "supplemental-metadata"
+
+// editedSuffixes are the suffixes (in several languages) of the photos edited
+// in Google Photos: they share the sidecar of the original photo.
+var editedSuffixes = []string{"-edited", "-effects", "-bearbeitet", "-modifié", "-editado", "-modificato"}
+
+// duplicateNumber matches the number added by Google Photos to the names that
+// are used several times in an album, like "IMG_1234(1).jpg".
+var duplicateNumber = regexp.MustCompile(`^(.*)\((\d+)\)$`)
+
+// timestamp is a date in a sidecar, as a number of seconds in a string.
+type timestamp struct {
+	Timestamp string `json:"timestamp"`
+}
+
+func (t *timestamp) time() (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(t.Timestamp, 10, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0).UTC(), true
+}
+
+type geoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+func (g *geoData) isSet() bool {
+	return g != nil && (g.Latitude != 0 || g.Longitude != 0)
+}
+
+// sidecar is the JSON file with the metadata of a photo or video exported
+// from Google Photos.
+type sidecar struct {
+	Title          string     `json:"title"`
+	Description    string     `json:"description"`
+	PhotoTakenTime *timestamp `json:"photoTakenTime"`
+	CreationTime   *timestamp `json:"creationTime"`
+	GeoData        *geoData   `json:"geoData"`
+	GeoDataExif    *geoData   `json:"geoDataExif"`
+	Favorited      bool       `json:"favorited"`
+}
+
+// readSidecar parses a JSON file of Google Photos, and returns nil if it is
+// not the sidecar of a photo (like the metadata.json of the albums).
+func readSidecar(r io.Reader) *sidecar {
+	var s sidecar
+	if err := json.NewDecoder(io.LimitReader(r, maxSidecarSize)).Decode(&s); err != nil {
+		return nil
+	}
+	if s.Title == "" || (s.PhotoTakenTime == nil && s.CreationTime == nil) {
+		return nil
+	}
+	return &s
+}
+
+// metadata returns the metadata of the sidecar, in the format used by the
+// extractors of the VFS.
+func (s *sidecar) metadata() vfs.Metadata {
+	meta := vfs.Metadata{}
+	if t, ok := s.PhotoTakenTime.time(); ok {
+		meta["datetime"] = t
+	} else if t, ok := s.CreationTime.time(); ok {
+		meta["datetime"] = t
+	}
+	geo := s.GeoData
+	if !geo.isSet() {
+		geo = s.GeoDataExif
+	}
+	if geo.isSet() {
+		meta["gps"] = map[string]float64{
+			"lat":  geo.Latitude,
+			"long": geo.Longitude,
+		}
+	}
+	if desc := strings.TrimSpace(s.Description); desc != "" {
+		meta["description"] = desc
+	}
+	if s.Favorited {
+		meta[consts.FavoriteKey] = true
+	}
+	return meta
+}
+
+// sidecarKey returns the key of a JSON sidecar from its name, like the one of
+// the photo it describes: "IMG_1234.jpg.supplemental-metadata(1).json" and
+// "IMG_1234.jpg(1).json" become "IMG_1234.jpg(1)".
+func sidecarKey(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	number := ""
+	if m := duplicateNumber.FindStringSubmatch(name); m != nil {
+		name, number = m[1], "("+m[2]+")"
+	}
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		suffix := name[i+1:]
+		if suffix != "" && strings.HasPrefix(sidecarSuffix, suffix) {
+			name = name[:i]
+		}
+	}
+	return name + number
+}
+
+// photoKeys returns the keys of the sidecars that can describe a photo, from
+// the most to the least specific: "IMG_1234(1).jpg" can be described by
+// "IMG_1234.jpg(1)", and "IMG_1234-edited.jpg" by "IMG_1234.jpg".
+func photoKeys(name string) []string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	number := ""
+	if m := duplicateNumber.FindStringSubmatch(base); m != nil {
+		base, number = m[1], "("+m[2]+")"
+	}
+	keys := []string{base + ext + number}
+	for _, suffix := range editedSuffixes {
+		if strings.HasSuffix(strings.ToLower(base), suffix) {
+			keys = append(keys, base[:len(base)-len(suffix)]+ext+number)
+		}
+	}
+	return keys
+}
+
+// sidecars are the sidecars of the photos, by directory of the archive.
+type sidecars map[string]map[string]*sidecar
+
+func (s sidecars) add(entryPath string, sc *sidecar) {
+	dir := path.Dir(entryPath)
+	if s[dir] == nil {
+		s[dir] = make(map[string]*sidecar)
+	}
+	s[dir][sidecarKey(path.Base(entryPath))] = sc
+}
+
+// find returns the sidecar of a photo, or nil. The names of the sidecars are
+// truncated by Google when they are too long, and the title of the sidecar
+// can be used when there is no duplicate in the album.
+func (s sidecars) find(entryPath string) *sidecar {
+	dir, name := path.Dir(entryPath), path.Base(entryPath)
+	inDir := s[dir]
+	if len(inDir) == 0 {
+		return nil
+	}
+	keys := photoKeys(name)
+	for _, key := range keys {
+		if sc, ok := inDir[key]; ok {
+			return sc
+		}
+	}
+
+	var byTitle, byPrefix *sidecar
+	titles, prefixes := 0, 0
+	for key, sc := range inDir {
+		if sc.Title == name {
+			byTitle = sc
+			titles++
+		}
+		if len(key) >= 30 && strings.HasPrefix(keys[0], key) {
+			byPrefix = sc
+			prefixes++
+		}
+	}
+	if titles == 1 {
+		return byTitle
+	}
+	if prefixes == 1 {
+		return byPrefix
+	}
+	return nil
+}
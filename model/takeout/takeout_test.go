@@ -0,0 +1,165 @@
+package takeout
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecarKey(t *testing.T) {
+	assert.Equal(t, "IMG_1234.jpg", sidecarKey("IMG_1234.jpg.json"))
+	assert.Equal(t, "IMG_1234.jpg", sidecarKey("IMG_1234.jpg.supplemental-metadata.json"))
+	assert.Equal(t, "IMG_1234.jpg", sidecarKey("IMG_1234.jpg.supplemental-met.json"))
+	assert.Equal(t, "IMG_1234.jpg(1)", sidecarKey("IMG_1234.jpg(1).json"))
+	assert.Equal(t, "IMG_1234.jpg(1)", sidecarKey("IMG_1234.jpg.supplemental-metadata(1).json"))
+}
+
+func TestPhotoKeys(t *testing.T) {
+	assert.Equal(t, []string{"IMG_1234.jpg"}, photoKeys("IMG_1234.jpg"))
+	assert.Equal(t, []string{"IMG_1234.jpg(1)"}, photoKeys("IMG_1234(1).jpg"))
+	assert.Equal(t, []string{"IMG_1234-edited.jpg", "IMG_1234.jpg"}, photoKeys("IMG_1234-edited.jpg"))
+}
+
+func TestSidecarsFind(t *testing.T) {
+	s := sidecars{}
+	first := &sidecar{Title: "IMG_1234.jpg"}
+	second := &sidecar{Title: "IMG_1234.jpg"}
+	long := &sidecar{Title: "a very long name for a photo taken in the mountains.jpg"}
+	s.add("Takeout/Google Photos/Album/IMG_1234.jpg.supplemental-metadata.json", first)
+	s.add("Takeout/Google Photos/Album/IMG_1234.jpg.supplemental-metadata(1).json", second)
+	s.add("Takeout/Google Photos/Album/a very long name for a photo taken in th.json", long)
+
+	assert.Same(t, first, s.find("Takeout/Google Photos/Album/IMG_1234.jpg"))
+	assert.Same(t, second, s.find("Takeout/Google Photos/Album/IMG_1234(1).jpg"))
+	assert.Same(t, first, s.find("Takeout/Google Photos/Album/IMG_1234-edited.jpg"))
+	assert.Same(t, long, s.find("Takeout/Google Photos/Album/a very long name for a photo taken in the mountains.jpg"))
+	assert.Nil(t, s.find("Takeout/Google Photos/Other/IMG_1234.jpg"))
+	assert.Nil(t, s.find("Takeout/Google Photos/Album/IMG_5678.jpg"))
+}
+
+func TestSidecarMetadata(t *testing.T) {
+	sc := readSidecar(strings.NewReader(`{
+  "title": "IMG_1234.jpg",
+  "description": " Sunset ",
+  "creationTime": {"timestamp": "1700000500"},
+  "photoTakenTime": {"timestamp": "1700000000"},
+  "geoData": {"latitude": 0, "longitude": 0},
+  "geoDataExif": {"latitude": 45.1885, "longitude": 5.7245},
+  "favorited": true
+}`))
+	require.NotNil(t, sc)
+	meta := sc.metadata()
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), meta["datetime"])
+	assert.Equal(t, map[string]float64{"lat": 45.1885, "long": 5.7245}, meta["gps"])
+	assert.Equal(t, "Sunset", meta["description"])
+	assert.Equal(t, true, meta[consts.FavoriteKey])
+
+	// The metadata.json of an album is not a sidecar
+	assert.Nil(t, readSidecar(strings.NewReader(`{"title": "Album", "description": ""}`)))
+	assert.Nil(t, readSidecar(strings.NewReader(`not json`)))
+}
+
+var testFiles = map[string]string{
+	"Takeout/Drive/notes.txt":                    "hello",
+	"Takeout/Drive/../../escape.txt":             "escaped",
+	"__MACOSX/Takeout/Drive/._notes.txt":         "resource fork",
+	"Takeout/Google Photos/Album/IMG_1234.jpg":   "jpeg",
+	"Takeout/Contacts/All Contacts/contacts.vcf": "BEGIN:VCARD\r\nEND:VCARD\r\n",
+}
+
+func walkAll(t *testing.T, a archive) map[string]string {
+	found := map[string]string{}
+	err := a.Walk(func(e *entry) error {
+		content, err := io.ReadAll(e.Content)
+		require.NoError(t, err)
+		assert.Equal(t, e.Size, int64(len(content)))
+		found[e.Name] = string(content)
+		return nil
+	})
+	require.NoError(t, err)
+	return found
+}
+
+func expectedFiles() map[string]string {
+	return map[string]string{
+		"Takeout/Drive/notes.txt": "hello",
+		"escape.txt":              "escaped",
+		"Takeout/Google Photos/Album/IMG_1234.jpg":   "jpeg",
+		"Takeout/Contacts/All Contacts/contacts.vcf": "BEGIN:VCARD\r\nEND:VCARD\r\n",
+	}
+}
+
+func TestZipArchive(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.Create("Takeout/Drive/")
+	require.NoError(t, err)
+	for name, content := range testFiles {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	a, err := openArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files, size, ok := a.Totals()
+	assert.True(t, ok)
+	assert.EqualValues(t, len(testFiles), files)
+	var total int
+	for _, content := range testFiles {
+		total += len(content)
+	}
+	assert.EqualValues(t, total, size)
+	assert.Equal(t, expectedFiles(), walkAll(t, a))
+}
+
+func TestTarGzArchive(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "Takeout/Drive/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}))
+	for name, content := range testFiles {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	a, err := openArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	_, _, ok := a.Totals()
+	assert.False(t, ok)
+	assert.Equal(t, expectedFiles(), walkAll(t, a))
+}
+
+func TestInvalidArchive(t *testing.T) {
+	data := []byte("this is not an archive")
+	_, err := openArchive(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	assert.True(t, isArchiveName("takeout-001.zip"))
+	assert.True(t, isArchiveName("export.TAR.GZ"))
+	assert.True(t, isArchiveName("export.tgz"))
+	assert.False(t, isArchiveName("photo.jpg"))
+}
@@ -43,6 +43,10 @@ type DirDoc struct {
 
 	Metadata     Metadata           `json:"metadata,omitempty"`
 	CozyMetadata *FilesCozyMetadata `json:"cozyMetadata,omitempty"`
+
+	// Mount is set for the directories whose content is served live from an
+	// external storage.
+	Mount *MountInfo `json:"mount,omitempty"`
 }
 
 // ID returns the directory qualified identifier
@@ -70,6 +74,10 @@ func (d *DirDoc) Clone() couchdb.Doc {
 	if d.CozyMetadata != nil {
 		cloned.CozyMetadata = d.CozyMetadata.Clone()
 	}
+	if d.Mount != nil {
+		mount := *d.Mount
+		cloned.Mount = &mount
+	}
 	return &cloned
 }
 
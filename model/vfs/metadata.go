@@ -24,6 +24,8 @@ import (
 	"github.com/bradfitz/latlong"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/shortcut"
 	"github.com/cozy/goexif2/exif"
 	"github.com/cozy/goexif2/tiff"
@@ -33,7 +35,7 @@ import (
 // MetadataExtractorVersion is the version number of the metadata extractor.
 // It will be used later to know which files can be re-examined to get more
 // metadata when the extractor is improved.
-const MetadataExtractorVersion = 2
+const MetadataExtractorVersion = 3
 
 // Metadata is a list of metadata specific to each mimetype:
 // id3 for music, exif for jpegs, etc.
@@ -101,20 +103,42 @@ type MetaExtractor interface {
 	Result() Metadata
 }
 
-// NewMetaExtractor returns an extractor for metadata if the mime type has one,
-// or null else
-func NewMetaExtractor(doc *FileDoc) *MetaExtractor {
-	var e MetaExtractor
-	switch doc.Mime {
-	case "image/jpeg":
-		e = NewExifExtractor(doc.CreatedAt, true)
-	case "image/heic", "image/heif":
-		e = NewExifExtractor(doc.CreatedAt, false)
-	case "image/png", "image/gif":
-		e = NewImageExtractor(doc.CreatedAt)
-	case "audio/mp3", "audio/mpeg", "audio/ogg", "audio/x-m4a", "audio/flac":
-		e = NewAudioExtractor()
-	case consts.ShortcutMimeType:
+// MetaExtractorFactory returns an extractor for the given file, or nil if the
+// file can't be examined.
+type MetaExtractorFactory func(doc *FileDoc) MetaExtractor
+
+var metaExtractors = make(map[string]MetaExtractorFactory)
+
+// RegisterMetaExtractor registers a factory of extractors for the given mime
+// types. It replaces the factory previously registered for the same mime
+// types, and it must be called at initialization, before any file is written.
+func RegisterMetaExtractor(factory MetaExtractorFactory, mimes ...string) {
+	for _, mime := range mimes {
+		metaExtractors[mime] = factory
+	}
+}
+
+// HasMetaExtractor returns true if an extractor has been registered for the
+// given mime type.
+func HasMetaExtractor(mime string) bool {
+	_, ok := metaExtractors[mime]
+	return ok
+}
+
+func init() {
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewExifExtractor(doc.CreatedAt, true)
+	}, "image/jpeg")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewExifExtractor(doc.CreatedAt, false)
+	}, "image/heic", "image/heif")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewImageExtractor(doc.CreatedAt)
+	}, "image/png", "image/gif")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewAudioExtractor()
+	}, "audio/mp3", "audio/mpeg", "audio/ogg", "audio/x-m4a", "audio/flac")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
 		var instance string
 		if doc.CozyMetadata != nil {
 			instance = doc.CozyMetadata.CreatedOn
@@ -123,14 +147,104 @@ func NewMetaExtractor(doc *FileDoc) *MetaExtractor {
 		if doc.Metadata != nil {
 			target, _ = doc.Metadata["target"].(map[string]interface{})
 		}
-		e = NewShortcutExtractor(instance, target)
+		return NewShortcutExtractor(instance, target)
+	}, consts.ShortcutMimeType)
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewPDFExtractor()
+	}, "application/pdf")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewOfficeExtractor()
+	}, officeMimeTypes...)
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewVideoExtractor(doc.CreatedAt)
+	}, "video/mp4", "video/quicktime", "video/x-m4v", "video/3gpp")
+	RegisterMetaExtractor(func(doc *FileDoc) MetaExtractor {
+		return NewTrackExtractor()
+	}, GPXMimeType, KMLMimeType)
+}
+
+// NewMetaExtractor returns an extractor for metadata if the mime type has one,
+// or null else
+func NewMetaExtractor(doc *FileDoc) *MetaExtractor {
+	factory, ok := metaExtractors[doc.Mime]
+	if !ok {
+		return nil
 	}
-	if e != nil {
+	if e := factory(doc); e != nil {
 		return &e
 	}
 	return nil
 }
 
+// StreamExtractor is an extractor that runs a parse function in a goroutine,
+// on the content pushed to the extractor. The parse function can return
+// before reading all the content: the next writes will fail with
+// io.ErrClosedPipe.
+type StreamExtractor struct {
+	w     *io.PipeWriter
+	r     *io.PipeReader
+	ch    chan interface{}
+	name  string
+	parse func(r io.Reader) (Metadata, error)
+}
+
+// NewStreamExtractor returns an extractor that calls the parse function. The
+// name is used in the error messages.
+func NewStreamExtractor(name string, parse func(r io.Reader) (Metadata, error)) *StreamExtractor {
+	e := &StreamExtractor{name: name, parse: parse}
+	e.r, e.w = io.Pipe()
+	e.ch = make(chan interface{})
+	go e.Start()
+	return e
+}
+
+// Start is used in a goroutine to start the metadata extraction
+func (e *StreamExtractor) Start() {
+	var meta Metadata
+	var err error
+	defer func() {
+		r := recover()
+		if errc := e.r.Close(); err == nil {
+			err = errc
+		}
+		if r != nil {
+			e.ch <- fmt.Errorf("metadata: recovered from %s extracting: %s", e.name, r)
+		} else if err != nil {
+			e.ch <- err
+		} else {
+			e.ch <- meta
+		}
+	}()
+	meta, err = e.parse(e.r)
+}
+
+// Write is called to push some bytes to the extractor
+func (e *StreamExtractor) Write(p []byte) (n int, err error) {
+	return e.w.Write(p)
+}
+
+// Close is called when all the bytes has been pushed, to finalize the extraction
+func (e *StreamExtractor) Close() error {
+	return e.w.Close()
+}
+
+// Abort is called when the extractor can be discarded
+func (e *StreamExtractor) Abort(err error) {
+	_ = e.w.CloseWithError(err)
+	<-e.ch
+}
+
+// Result is called to get the extracted metadata
+func (e *StreamExtractor) Result() Metadata {
+	m := NewMetadata()
+	if meta, ok := (<-e.ch).(Metadata); ok {
+		for k, v := range meta {
+			m[k] = v
+		}
+	}
+	return m
+}
+
 // ImageExtractor is used to extract width/height from images
 type ImageExtractor struct {
 	w         *io.PipeWriter
@@ -292,6 +406,7 @@ func (e *ExifExtractor) Result() Metadata {
 						"lat":  lat,
 						"long": long,
 					}
+					addPlace(m, lat, long)
 					if localTZ {
 						if loc := lookupLocation(latlong.LookupZoneName(lat, long)); loc != nil {
 							if t, err := exifDateTimeInLocation(x, loc); err == nil {
@@ -379,6 +494,34 @@ func lookupLocation(zone string) *time.Location {
 	return loc
 }
 
+var geocoder struct {
+	sync.Once
+	dataset *geocoding.Dataset
+}
+
+// addPlace adds the nearest city for the GPS coordinates to the metadata, if
+// a geocoding dataset has been configured.
+func addPlace(m Metadata, lat, long float64) {
+	geocoder.Do(func() {
+		cfg := config.GetConfig()
+		if cfg == nil || cfg.GeocodingDB == "" {
+			return
+		}
+		dataset, err := geocoding.Open(cfg.GeocodingDB)
+		if err != nil {
+			logger.WithNamespace("metadata").Errorf("cannot open the geocoding dataset: %s", err)
+			return
+		}
+		geocoder.dataset = dataset
+	})
+	if geocoder.dataset == nil {
+		return
+	}
+	if place := geocoder.dataset.Lookup(lat, long); place != nil {
+		m["place"] = place
+	}
+}
+
 // AudioExtractor is used to extract album/artist/etc. from audio
 type AudioExtractor struct {
 	w  *io.PipeWriter
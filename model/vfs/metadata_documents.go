@@ -0,0 +1,456 @@
+package vfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDocumentSize is the maximal size of the PDF and office documents that
+// are examined to extract their metadata, as they are parsed in memory.
+const maxDocumentSize = 32 << 20
+
+// maxObjectStreamSize is the maximal size of a decompressed object stream in
+// a PDF.
+const maxObjectStreamSize = 8 << 20
+
+// errDocumentTooLarge is used when a document is too large to be examined.
+var errDocumentTooLarge = errors.New("metadata: document too large")
+
+var officeMimeTypes = []string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"application/vnd.oasis.opendocument.text",
+	"application/vnd.oasis.opendocument.spreadsheet",
+	"application/vnd.oasis.opendocument.presentation",
+	"application/vnd.oasis.opendocument.graphics",
+}
+
+func readDocument(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxDocumentSize {
+		return nil, errDocumentTooLarge
+	}
+	return buf, nil
+}
+
+// NewPDFExtractor returns an extractor for the number of pages, the title and
+// the author of PDF files.
+func NewPDFExtractor() *StreamExtractor {
+	return NewStreamExtractor("pdf", func(r io.Reader) (Metadata, error) {
+		buf, err := readDocument(r)
+		if err != nil {
+			return nil, err
+		}
+		return parsePDF(buf), nil
+	})
+}
+
+var (
+	pdfObjectRegexp = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfPagesRegexp  = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfPageRegexp   = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfCountRegexp  = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfInfoRegexp   = regexp.MustCompile(`/Info\s+(\d+)\s+\d+\s+R`)
+	pdfObjStmRegexp = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfFirstRegexp  = regexp.MustCompile(`/First\s+(\d+)`)
+	pdfNRegexp      = regexp.MustCompile(`/N\s+(\d+)`)
+)
+
+// parsePDF looks at the dictionaries of the objects of a PDF, including the
+// objects compressed in object streams, to find the page tree and the
+// document information dictionary. It is not a complete PDF parser, but it is
+// enough for the metadata.
+func parsePDF(buf []byte) Metadata {
+	if !bytes.HasPrefix(buf, []byte("%PDF-")) {
+		return nil
+	}
+	objects := make(map[int][]byte)
+	var streams [][]byte
+	locs := pdfObjectRegexp.FindAllSubmatchIndex(buf, -1)
+	for _, loc := range locs {
+		num, err := strconv.Atoi(string(buf[loc[2]:loc[3]]))
+		if err != nil {
+			continue
+		}
+		rest := buf[loc[1]:]
+		end := bytes.Index(rest, []byte("endobj"))
+		if end < 0 {
+			end = len(rest)
+		}
+		dict := rest[:end]
+		if idx := bytes.Index(dict, []byte("stream")); idx >= 0 {
+			if pdfObjStmRegexp.Match(dict[:idx]) {
+				streams = append(streams, rest)
+			}
+			dict = dict[:idx]
+		}
+		// With incremental updates, the last version of an object wins
+		objects[num] = dict
+	}
+	for _, stream := range streams {
+		for num, dict := range parsePDFObjectStream(stream) {
+			if _, ok := objects[num]; !ok {
+				objects[num] = dict
+			}
+		}
+	}
+
+	m := Metadata{}
+	pages, leaves := 0, 0
+	for _, dict := range objects {
+		if pdfPagesRegexp.Match(dict) {
+			if match := pdfCountRegexp.FindSubmatch(dict); match != nil {
+				if count, err := strconv.Atoi(string(match[1])); err == nil && count > pages {
+					pages = count
+				}
+			}
+		} else if pdfPageRegexp.Match(dict) {
+			leaves++
+		}
+	}
+	if pages == 0 {
+		pages = leaves
+	}
+	if pages > 0 {
+		m["pages"] = pages
+	}
+
+	// The strings of an encrypted PDF can't be read without the key
+	if bytes.Contains(buf, []byte("/Encrypt")) {
+		return m
+	}
+	infos := pdfInfoRegexp.FindAllSubmatch(buf, -1)
+	if len(infos) == 0 {
+		return m
+	}
+	num, _ := strconv.Atoi(string(infos[len(infos)-1][1]))
+	if info, ok := objects[num]; ok {
+		if title := pdfStringEntry(info, "/Title"); title != "" {
+			m["title"] = title
+		}
+		if author := pdfStringEntry(info, "/Author"); author != "" {
+			m["author"] = author
+		}
+		if date := pdfDateEntry(info, "/CreationDate"); !date.IsZero() {
+			m["datetime"] = date
+		}
+	}
+	return m
+}
+
+// parsePDFObjectStream returns the dictionaries of the objects compressed in
+// an object stream. The stream starts with its own dictionary.
+func parsePDFObjectStream(stream []byte) map[int][]byte {
+	idx := bytes.Index(stream, []byte("stream"))
+	dict := stream[:idx]
+	if !bytes.Contains(dict, []byte("/FlateDecode")) {
+		return nil
+	}
+	first := pdfFirstRegexp.FindSubmatch(dict)
+	n := pdfNRegexp.FindSubmatch(dict)
+	if first == nil || n == nil {
+		return nil
+	}
+	offset, _ := strconv.Atoi(string(first[1]))
+	count, _ := strconv.Atoi(string(n[1]))
+
+	data := bytes.TrimLeft(stream[idx+len("stream"):], "\r\n")
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	content, _ := io.ReadAll(io.LimitReader(zr, maxObjectStreamSize))
+	if offset > len(content) {
+		return nil
+	}
+
+	// The header is a list of pairs: object number and offset
+	header := strings.Fields(string(content[:offset]))
+	if len(header) < 2*count {
+		return nil
+	}
+	objects := make(map[int][]byte, count)
+	for i := 0; i < count; i++ {
+		num, err1 := strconv.Atoi(header[2*i])
+		start, err2 := strconv.Atoi(header[2*i+1])
+		if err1 != nil || err2 != nil {
+			return objects
+		}
+		end := len(content) - offset
+		if i+1 < count {
+			if next, err := strconv.Atoi(header[2*i+3]); err == nil {
+				end = next
+			}
+		}
+		if start < 0 || start > end || offset+end > len(content) {
+			return objects
+		}
+		objects[num] = content[offset+start : offset+end]
+	}
+	return objects
+}
+
+// pdfStringEntry returns the value of a string entry in a PDF dictionary.
+func pdfStringEntry(dict []byte, key string) string {
+	idx := bytes.Index(dict, []byte(key))
+	if idx < 0 {
+		return ""
+	}
+	rest := bytes.TrimLeft(dict[idx+len(key):], " \t\r\n")
+	var raw []byte
+	switch {
+	case bytes.HasPrefix(rest, []byte("(")):
+		raw = pdfLiteralString(rest[1:])
+	case bytes.HasPrefix(rest, []byte("<")) && !bytes.HasPrefix(rest, []byte("<<")):
+		raw = pdfHexString(rest[1:])
+	default:
+		return ""
+	}
+	return strings.TrimSpace(decodePDFText(raw))
+}
+
+func pdfLiteralString(s []byte) []byte {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return out
+			}
+			depth--
+		case '\\':
+			i++
+			if i >= len(s) {
+				return out
+			}
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			case '\n':
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := 0
+				for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+					n = n*8 + int(s[i]-'0')
+					i++
+				}
+				i--
+				c = byte(n)
+			default:
+				c = s[i]
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func pdfHexString(s []byte) []byte {
+	end := bytes.IndexByte(s, '>')
+	if end < 0 {
+		return nil
+	}
+	var digits []byte
+	for _, c := range s[:end] {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(n)
+	}
+	return out
+}
+
+// decodePDFText decodes a PDF text string, which is in UTF-16 when it starts
+// with a byte order mark, and in PDFDocEncoding (close to Latin-1) else.
+func decodePDFText(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xfe, 0xff}), bytes.HasPrefix(raw, []byte{0xff, 0xfe}):
+		bigEndian := raw[0] == 0xfe
+		raw = raw[2:]
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+			} else {
+				units[i] = uint16(raw[2*i+1])<<8 | uint16(raw[2*i])
+			}
+		}
+		return string(utf16.Decode(units))
+	case bytes.HasPrefix(raw, []byte{0xef, 0xbb, 0xbf}):
+		return string(raw[3:])
+	case utf8.Valid(raw):
+		return string(raw)
+	}
+	runes := make([]rune, len(raw))
+	for i, c := range raw {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// pdfDateEntry parses a date in the PDF format, like D:20261019103000+02'00'.
+func pdfDateEntry(dict []byte, key string) time.Time {
+	date := strings.TrimPrefix(pdfStringEntry(dict, key), "D:")
+	date = strings.ReplaceAll(date, "'", "")
+	if idx := strings.IndexByte(date, 'Z'); idx >= 0 {
+		date = date[:idx+1]
+	}
+	for _, layout := range []string{"20060102150405Z0700", "20060102150405Z", "20060102150405", "20060102"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// NewOfficeExtractor returns an extractor for the title, author, creation
+// date and statistics of the office documents, in the OOXML (docx, xlsx,
+// pptx) and OpenDocument (odt, ods, odp) formats.
+func NewOfficeExtractor() *StreamExtractor {
+	return NewStreamExtractor("office", func(r io.Reader) (Metadata, error) {
+		buf, err := readDocument(r)
+		if err != nil {
+			return nil, err
+		}
+		z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return nil, err
+		}
+		return parseOffice(z)
+	})
+}
+
+// The XML names without a namespace match the elements from any namespace.
+type ooxmlCore struct {
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	Created  string `xml:"created"`
+	Modified string `xml:"modified"`
+}
+
+type ooxmlApp struct {
+	Pages  int `xml:"Pages"`
+	Words  int `xml:"Words"`
+	Slides int `xml:"Slides"`
+}
+
+type odfMeta struct {
+	Title          string `xml:"meta>title"`
+	InitialCreator string `xml:"meta>initial-creator"`
+	Creator        string `xml:"meta>creator"`
+	CreationDate   string `xml:"meta>creation-date"`
+	Statistic      struct {
+		Pages  int `xml:"page-count,attr"`
+		Words  int `xml:"word-count,attr"`
+		Tables int `xml:"table-count,attr"`
+	} `xml:"meta>document-statistic"`
+}
+
+func parseOffice(z *zip.Reader) (Metadata, error) {
+	m := Metadata{}
+	var core ooxmlCore
+	if err := decodeZipXML(z, "docProps/core.xml", &core); err == nil {
+		setString(m, "title", core.Title)
+		setString(m, "author", core.Creator)
+		if t := parseOfficeDate(core.Created); !t.IsZero() {
+			m["datetime"] = t
+		}
+		var app ooxmlApp
+		if err := decodeZipXML(z, "docProps/app.xml", &app); err == nil {
+			setInt(m, "pages", app.Pages)
+			setInt(m, "words", app.Words)
+			setInt(m, "slides", app.Slides)
+		}
+		return m, nil
+	}
+
+	var meta odfMeta
+	if err := decodeZipXML(z, "meta.xml", &meta); err != nil {
+		return nil, err
+	}
+	setString(m, "title", meta.Title)
+	if meta.InitialCreator != "" {
+		m["author"] = strings.TrimSpace(meta.InitialCreator)
+	} else {
+		setString(m, "author", meta.Creator)
+	}
+	if t := parseOfficeDate(meta.CreationDate); !t.IsZero() {
+		m["datetime"] = t
+	}
+	setInt(m, "pages", meta.Statistic.Pages)
+	setInt(m, "words", meta.Statistic.Words)
+	setInt(m, "tables", meta.Statistic.Tables)
+	return m, nil
+}
+
+func decodeZipXML(z *zip.Reader, name string, v interface{}) error {
+	f, err := z.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return xml.NewDecoder(io.LimitReader(f, maxObjectStreamSize)).Decode(v)
+}
+
+// parseOfficeDate parses the W3CDTF dates of OOXML, and the dates without
+// timezone of OpenDocument.
+func parseOfficeDate(date string) time.Time {
+	date = strings.TrimSpace(date)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func setString(m Metadata, key, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		m[key] = value
+	}
+}
+
+func setInt(m Metadata, key string, value int) {
+	if value > 0 {
+		m[key] = value
+	}
+}
@@ -1,9 +1,15 @@
 package vfs_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +18,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/tests/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMetadata(t *testing.T) {
@@ -104,3 +111,212 @@ func TestMetadata(t *testing.T) {
 		assert.Equal(t, "drive", app)
 	})
 }
+
+func extractMetadata(t *testing.T, mime string, content io.Reader) vfs.Metadata {
+	createdAt := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	extractor := vfs.NewMetaExtractor(&vfs.FileDoc{Mime: mime, CreatedAt: createdAt})
+	require.NotNil(t, extractor)
+	_, err := io.Copy(*extractor, content)
+	assert.True(t, err == nil || errors.Is(err, io.ErrClosedPipe))
+	require.NoError(t, (*extractor).Close())
+	meta := (*extractor).Result()
+	assert.Equal(t, vfs.MetadataExtractorVersion, meta["extractor_version"])
+	return meta
+}
+
+func TestRegisterMetaExtractor(t *testing.T) {
+	assert.False(t, vfs.HasMetaExtractor("application/x-test"))
+	vfs.RegisterMetaExtractor(func(doc *vfs.FileDoc) vfs.MetaExtractor {
+		return vfs.NewStreamExtractor("test", func(r io.Reader) (vfs.Metadata, error) {
+			buf, err := io.ReadAll(r)
+			return vfs.Metadata{"size": len(buf), "name": doc.DocName}, err
+		})
+	}, "application/x-test")
+	assert.True(t, vfs.HasMetaExtractor("application/x-test"))
+
+	doc := &vfs.FileDoc{DocName: "foo.test", Mime: "application/x-test"}
+	extractor := vfs.NewMetaExtractor(doc)
+	require.NotNil(t, extractor)
+	_, err := io.WriteString(*extractor, "hello")
+	assert.NoError(t, err)
+	assert.NoError(t, (*extractor).Close())
+	meta := (*extractor).Result()
+	assert.Equal(t, 5, meta["size"])
+	assert.Equal(t, "foo.test", meta["name"])
+
+	assert.Nil(t, vfs.NewMetaExtractor(&vfs.FileDoc{Mime: "application/x-unknown"}))
+}
+
+func TestPDFMetadataExtractor(t *testing.T) {
+	f, err := os.Open("../../tests/fixtures/dev-desktop.pdf")
+	require.NoError(t, err)
+	defer f.Close()
+	meta := extractMetadata(t, "application/pdf", f)
+	assert.Equal(t, 1, meta["pages"])
+
+	// A PDF with its page tree in a compressed object stream, and an
+	// information dictionary with escaped and UTF-16 strings
+	pages := "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>\n"
+	page := "<< /Type /Page /Parent 2 0 R >>\n"
+	header := fmt.Sprintf("2 0 3 %d 4 %d\n", len(pages), len(pages)+len(page))
+	var objstm bytes.Buffer
+	zw := zlib.NewWriter(&objstm)
+	_, _ = io.WriteString(zw, header+pages+page+page)
+	require.NoError(t, zw.Close())
+	pdf := "%PDF-1.7\n" +
+		"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+		fmt.Sprintf("5 0 obj\n<< /Type /ObjStm /N 3 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", len(header), objstm.Len()) +
+		objstm.String() + "\nendstream\nendobj\n" +
+		"6 0 obj\n<< /Title <FEFF00C9007400E9> /Author (Alice \\(A.\\) Liddell\\041) /CreationDate (D:20261019103000+02'00') >>\nendobj\n" +
+		"trailer\n<< /Root 1 0 R /Info 6 0 R >>\n%%EOF\n"
+	meta = extractMetadata(t, "application/pdf", strings.NewReader(pdf))
+	assert.Equal(t, 2, meta["pages"])
+	assert.Equal(t, "Été", meta["title"])
+	assert.Equal(t, "Alice (A.) Liddell!", meta["author"])
+	assert.Equal(t, time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC), meta["datetime"].(time.Time).UTC())
+}
+
+func TestOfficeMetadataExtractor(t *testing.T) {
+	docx := zipFiles(t, map[string]string{
+		"docProps/core.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <dc:title>Quarterly report</dc:title>
+  <dc:creator>Bob</dc:creator>
+  <dcterms:created xsi:type="dcterms:W3CDTF">2026-10-01T08:00:00Z</dcterms:created>
+</cp:coreProperties>`,
+		"docProps/app.xml": `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Pages>3</Pages><Words>512</Words></Properties>`,
+	})
+	meta := extractMetadata(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", docx)
+	assert.Equal(t, "Quarterly report", meta["title"])
+	assert.Equal(t, "Bob", meta["author"])
+	assert.Equal(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC), meta["datetime"])
+	assert.Equal(t, 3, meta["pages"])
+	assert.Equal(t, 512, meta["words"])
+
+	odt := zipFiles(t, map[string]string{
+		"meta.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <office:meta>
+    <dc:title>Minutes</dc:title>
+    <meta:initial-creator>Carol</meta:initial-creator>
+    <dc:creator>Dave</dc:creator>
+    <meta:creation-date>2026-09-30T14:00:00.123</meta:creation-date>
+    <meta:document-statistic meta:page-count="2" meta:word-count="300"/>
+  </office:meta>
+</office:document-meta>`,
+	})
+	meta = extractMetadata(t, "application/vnd.oasis.opendocument.text", odt)
+	assert.Equal(t, "Minutes", meta["title"])
+	assert.Equal(t, "Carol", meta["author"])
+	assert.Equal(t, time.Date(2026, 9, 30, 14, 0, 0, 123000000, time.UTC), meta["datetime"])
+	assert.Equal(t, 2, meta["pages"])
+	assert.Equal(t, 300, meta["words"])
+}
+
+func zipFiles(t *testing.T, files map[string]string) io.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return &buf
+}
+
+func TestVideoMetadataExtractor(t *testing.T) {
+	box := func(kind string, content ...[]byte) []byte {
+		data := bytes.Join(content, nil)
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header, uint32(len(data)+8))
+		copy(header[4:], kind)
+		return append(header, data...)
+	}
+	u32 := func(values ...uint32) []byte {
+		buf := make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.BigEndian.PutUint32(buf[4*i:], v)
+		}
+		return buf
+	}
+	// 2026-10-19 10:00:00 UTC, in seconds since 1904
+	created := uint32(time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC).Unix() + 2082844800)
+	mvhd := box("mvhd", u32(0, created, created, 1000, 12345), make([]byte, 80))
+	// A portrait video: the matrix rotates it by 90°
+	matrix := u32(0, 1<<16, 0, 0xffff0000, 0, 0, 0, 0, 1<<30)
+	tkhd := box("tkhd", u32(0, created, created, 1, 0, 12345), make([]byte, 16), matrix, u32(1920<<16, 1080<<16))
+	video := box("trak", tkhd, box("mdia",
+		box("hdlr", u32(0, 0), []byte("vide"), make([]byte, 12)),
+		box("minf", box("stbl", box("stsd", u32(0, 1), box("hvc1", make([]byte, 78)))))))
+	audio := box("trak", box("mdia",
+		box("hdlr", u32(0, 0), []byte("soun"), make([]byte, 12)),
+		box("minf", box("stbl", box("stsd", u32(0, 1), box("mp4a", make([]byte, 28)))))))
+	udta := box("udta", box("\xa9xyz", []byte{0, 18, 0x15, 0xc7}, []byte("+48.8584+002.2945/")))
+	mp4 := bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), u32(512), []byte("isomiso2")),
+		box("mdat", make([]byte, 1<<20)),
+		box("moov", mvhd, video, audio, udta),
+	}, nil)
+
+	meta := extractMetadata(t, "video/mp4", bytes.NewReader(mp4))
+	assert.Equal(t, time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC), meta["datetime"])
+	assert.Equal(t, 12.345, meta["duration"])
+	assert.Equal(t, 1080, meta["width"])
+	assert.Equal(t, 1920, meta["height"])
+	assert.Equal(t, "hevc", meta["codec"])
+	assert.Equal(t, "aac", meta["audio_codec"])
+	assert.Equal(t, map[string]float64{"lat": 48.8584, "long": 2.2945}, meta["gps"])
+
+	// Not a video: the metadata are the default ones
+	meta = extractMetadata(t, "video/mp4", strings.NewReader("not a video"))
+	assert.Nil(t, meta["duration"])
+}
+
+func TestTrackMetadataExtractor(t *testing.T) {
+	gpx := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <metadata><name>Morning run</name><time>2026-10-19T12:00:00Z</time></metadata>
+  <trk>
+    <trkseg>
+      <trkpt lat="48.8584" lon="2.2945"><time>2026-10-19T07:00:00Z</time></trkpt>
+      <trkpt lat="48.8606" lon="2.3376"><time>2026-10-19T07:20:00Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="48.8530" lon="2.3499"><time>2026-10-19T07:40:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+	meta := extractMetadata(t, vfs.GPXMimeType, strings.NewReader(gpx))
+	assert.Equal(t, "Morning run", meta["title"])
+	assert.Equal(t, 3, meta["points"])
+	assert.InDelta(t, 3170, meta["distance"], 20)
+	assert.Equal(t, time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC), meta["started_at"])
+	assert.Equal(t, time.Date(2026, 10, 19, 7, 40, 0, 0, time.UTC), meta["ended_at"])
+	assert.Equal(t, 2400.0, meta["duration"])
+	assert.Equal(t, map[string]float64{"lat": 48.8584, "long": 2.2945}, meta["gps"])
+	assert.Equal(t, map[string]float64{
+		"north": 48.8606, "south": 48.8530, "east": 2.3499, "west": 2.2945,
+	}, meta["bounds"])
+
+	kml := `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+  <Document>
+    <name>Hike</name>
+    <Placemark>
+      <LineString>
+        <coordinates>
+          6.8652,45.8326,4800 6.8700,45.8400,4500
+        </coordinates>
+      </LineString>
+    </Placemark>
+  </Document>
+</kml>`
+	meta = extractMetadata(t, vfs.KMLMimeType, strings.NewReader(kml))
+	assert.Equal(t, "Hike", meta["title"])
+	assert.Equal(t, 2, meta["points"])
+	assert.InDelta(t, 910, meta["distance"], 20)
+	assert.Equal(t, map[string]float64{"lat": 45.8326, "long": 6.8652}, meta["gps"])
+	assert.Nil(t, meta["started_at"])
+}
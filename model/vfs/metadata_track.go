@@ -0,0 +1,209 @@
+package vfs
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/geocoding"
+)
+
+const (
+	// GPXMimeType is the mime type of the GPS tracks in the GPX format.
+	GPXMimeType = "application/gpx+xml"
+	// KMLMimeType is the mime type of the Google Earth files.
+	KMLMimeType = "application/vnd.google-earth.kml+xml"
+)
+
+// NewTrackExtractor returns an extractor for the GPS tracks, in the GPX and
+// KML formats. It computes the number of points, the distance, the start and
+// end times, and the bounds of the track.
+func NewTrackExtractor() *StreamExtractor {
+	return NewStreamExtractor("track", parseTrack)
+}
+
+// trackStats accumulates the points of a track.
+type trackStats struct {
+	title    string
+	points   int
+	distance float64
+	start    time.Time
+	end      time.Time
+	first    [2]float64
+	last     [2]float64
+	north    float64
+	south    float64
+	east     float64
+	west     float64
+	// newSegment is true when the next point starts a new segment, and
+	// the distance from the previous point must not be counted.
+	newSegment bool
+}
+
+func (s *trackStats) addPoint(lat, long float64) {
+	if math.IsNaN(lat) || math.IsNaN(long) || math.Abs(lat) > 90 || math.Abs(long) > 180 {
+		return
+	}
+	if s.points == 0 {
+		s.first = [2]float64{lat, long}
+		s.north, s.south, s.east, s.west = lat, lat, long, long
+	} else {
+		if !s.newSegment {
+			s.distance += geocoding.Distance(s.last[0], s.last[1], lat, long)
+		}
+		s.north = math.Max(s.north, lat)
+		s.south = math.Min(s.south, lat)
+		s.east = math.Max(s.east, long)
+		s.west = math.Min(s.west, long)
+	}
+	s.last = [2]float64{lat, long}
+	s.points++
+	s.newSegment = false
+}
+
+func (s *trackStats) addTime(value string) {
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value))
+	if err != nil {
+		return
+	}
+	if s.start.IsZero() || t.Before(s.start) {
+		s.start = t
+	}
+	if t.After(s.end) {
+		s.end = t
+	}
+}
+
+func (s *trackStats) metadata() Metadata {
+	m := Metadata{}
+	setString(m, "title", s.title)
+	if s.points == 0 {
+		return m
+	}
+	m["points"] = s.points
+	m["distance"] = math.Round(s.distance)
+	m["bounds"] = map[string]float64{
+		"north": s.north,
+		"south": s.south,
+		"east":  s.east,
+		"west":  s.west,
+	}
+	m["gps"] = map[string]float64{
+		"lat":  s.first[0],
+		"long": s.first[1],
+	}
+	addPlace(m, s.first[0], s.first[1])
+	if !s.start.IsZero() {
+		m["datetime"] = s.start
+		m["started_at"] = s.start
+		m["ended_at"] = s.end
+		m["duration"] = s.end.Sub(s.start).Seconds()
+	}
+	return m
+}
+
+// parseTrack reads the XML tokens of a GPX or KML file. In GPX, the points
+// are the attributes of the trkpt, rtept and wpt elements. In KML, they are
+// the lon,lat[,alt] tuples of the coordinates elements, or the "lon lat alt"
+// of the gx:coord elements.
+func parseTrack(r io.Reader) (Metadata, error) {
+	stats := &trackStats{}
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	var stack []string
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, name)
+			switch name {
+			case "trkseg", "rte", "LineString", "Track":
+				stats.newSegment = true
+			case "trkpt", "rtept", "wpt":
+				var lat, long float64 = math.NaN(), math.NaN()
+				for _, attr := range tok.Attr {
+					switch attr.Name.Local {
+					case "lat":
+						lat, _ = strconv.ParseFloat(attr.Value, 64)
+					case "lon":
+						long, _ = strconv.ParseFloat(attr.Value, 64)
+					}
+				}
+				if name == "wpt" {
+					stats.newSegment = true
+				}
+				stats.addPoint(lat, long)
+			case "name":
+				// The name of the file, or of its first track
+				if stats.title == "" && (parent == "metadata" || parent == "trk" ||
+					parent == "Document" || parent == "Placemark") {
+					var title string
+					if err := decoder.DecodeElement(&title, &tok); err == nil {
+						stats.title = title
+					}
+					stack = stack[:len(stack)-1]
+				}
+			case "time", "when":
+				// The time in the metadata of a GPX file is its creation date
+				if name == "time" && parent != "trkpt" && parent != "rtept" && parent != "wpt" {
+					continue
+				}
+				var value string
+				if err := decoder.DecodeElement(&value, &tok); err == nil {
+					stats.addTime(value)
+				}
+				stack = stack[:len(stack)-1]
+			case "coordinates":
+				var value string
+				if err := decoder.DecodeElement(&value, &tok); err == nil {
+					if parent != "LineString" && parent != "LinearRing" {
+						stats.newSegment = true
+					}
+					for _, tuple := range strings.Fields(value) {
+						parts := strings.Split(tuple, ",")
+						if len(parts) >= 2 {
+							stats.addPoint(parseCoordinate(parts[1]), parseCoordinate(parts[0]))
+						}
+					}
+				}
+				stack = stack[:len(stack)-1]
+			case "coord":
+				var value string
+				if err := decoder.DecodeElement(&value, &tok); err == nil {
+					parts := strings.Fields(value)
+					if len(parts) >= 2 {
+						stats.addPoint(parseCoordinate(parts[1]), parseCoordinate(parts[0]))
+					}
+				}
+				stack = stack[:len(stack)-1]
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return stats.metadata(), nil
+}
+
+func parseCoordinate(value string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
@@ -0,0 +1,274 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxMovieBoxSize is the maximal size of the moov box of a video, which is
+// kept in memory to be parsed.
+const maxMovieBoxSize = 64 << 20
+
+var errInvalidVideo = errors.New("metadata: invalid video")
+
+// The dates in the MP4 and QuickTime files are in seconds since 1904.
+var mp4Epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var videoCodecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"jpeg": "mjpeg",
+	"apcn": "prores",
+	"apch": "prores",
+	"apcs": "prores",
+}
+
+var audioCodecs = map[string]string{
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"alac": "alac",
+	"fLaC": "flac",
+	"sowt": "pcm",
+	"twos": "pcm",
+	"lpcm": "pcm",
+}
+
+// NewVideoExtractor returns an extractor for the duration, resolution, codec,
+// creation date and location of the videos in the MP4 and QuickTime formats.
+// The media data are skipped, and only the movie box is read in memory.
+func NewVideoExtractor(createdAt time.Time) *StreamExtractor {
+	return NewStreamExtractor("video", func(r io.Reader) (Metadata, error) {
+		m, err := parseMP4(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m["datetime"]; !ok {
+			m["datetime"] = createdAt
+		}
+		return m, nil
+	})
+}
+
+type mp4Box struct {
+	kind string
+	data []byte
+}
+
+// readMP4BoxHeader reads the header of a box, and returns its type and the
+// size of its content (-1 if the box extends to the end of the file).
+func readMP4BoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	kind := string(header[4:])
+	switch size {
+	case 0:
+		return kind, -1, nil
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large[:])) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, errInvalidVideo
+	}
+	return kind, size, nil
+}
+
+func parseMP4(r io.Reader) (Metadata, error) {
+	for {
+		kind, size, err := readMP4BoxHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "moov" {
+			if size < 0 || size > maxMovieBoxSize {
+				return nil, errInvalidVideo
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			return parseMovieBox(data), nil
+		}
+		if size < 0 {
+			return nil, errInvalidVideo
+		}
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mp4Children splits the content of a container box in boxes.
+func mp4Children(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{kind: kind, data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func mp4Child(data []byte, path ...string) []byte {
+	for _, kind := range path {
+		found := false
+		for _, box := range mp4Children(data) {
+			if box.kind == kind {
+				data, found = box.data, true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return data
+}
+
+func parseMovieBox(moov []byte) Metadata {
+	m := Metadata{}
+	if mvhd := mp4Child(moov, "mvhd"); len(mvhd) >= 20 {
+		var created uint64
+		var timescale uint32
+		var duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			created = binary.BigEndian.Uint64(mvhd[4:12])
+			timescale = binary.BigEndian.Uint32(mvhd[20:24])
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			created = uint64(binary.BigEndian.Uint32(mvhd[4:8]))
+			timescale = binary.BigEndian.Uint32(mvhd[12:16])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if created > 0 {
+			m["datetime"] = mp4Epoch.Add(time.Duration(created) * time.Second)
+		}
+		if timescale > 0 {
+			seconds := float64(duration) / float64(timescale)
+			m["duration"] = math.Round(seconds*1000) / 1000
+		}
+	}
+
+	for _, box := range mp4Children(moov) {
+		if box.kind != "trak" {
+			continue
+		}
+		hdlr := mp4Child(box.data, "mdia", "hdlr")
+		stsd := mp4Child(box.data, "mdia", "minf", "stbl", "stsd")
+		if len(hdlr) < 12 || len(stsd) < 16 {
+			continue
+		}
+		format := string(stsd[12:16])
+		switch string(hdlr[8:12]) {
+		case "vide":
+			if _, ok := m["codec"]; ok {
+				continue
+			}
+			m["codec"] = mp4CodecName(videoCodecs, format)
+			if width, height, ok := parseTrackHeader(mp4Child(box.data, "tkhd")); ok {
+				m["width"] = width
+				m["height"] = height
+			}
+		case "soun":
+			if _, ok := m["audio_codec"]; !ok {
+				m["audio_codec"] = mp4CodecName(audioCodecs, format)
+			}
+		}
+	}
+
+	if xyz := mp4Child(moov, "udta", "\xa9xyz"); len(xyz) > 4 {
+		if lat, long, ok := parseISO6709(string(xyz[4:])); ok {
+			m["gps"] = map[string]float64{
+				"lat":  lat,
+				"long": long,
+			}
+			addPlace(m, lat, long)
+		}
+	}
+	return m
+}
+
+func mp4CodecName(codecs map[string]string, format string) string {
+	if name, ok := codecs[format]; ok {
+		return name
+	}
+	return format
+}
+
+// parseTrackHeader returns the dimensions of a track, as displayed: they are
+// swapped for the videos rotated by a quarter turn, like the portrait videos
+// of the phones.
+func parseTrackHeader(tkhd []byte) (int, int, bool) {
+	// The matrix and dimensions are at the end of the box
+	if len(tkhd) < 44 {
+		return 0, 0, false
+	}
+	matrix := tkhd[len(tkhd)-44 : len(tkhd)-8]
+	width := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+	height := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+	if width == 0 || height == 0 {
+		return 0, 0, false
+	}
+	a := int32(binary.BigEndian.Uint32(matrix[0:4]))
+	b := int32(binary.BigEndian.Uint32(matrix[4:8]))
+	if a == 0 && b != 0 {
+		width, height = height, width
+	}
+	return width, height, true
+}
+
+var iso6709Regexp = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// parseISO6709 parses a location like +48.8584+002.2945+035.000/ in the
+// decimal degrees format of ISO 6709.
+func parseISO6709(s string) (float64, float64, bool) {
+	match := iso6709Regexp.FindStringSubmatch(s)
+	if match == nil {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || math.Abs(lat) > 90 {
+		return 0, 0, false
+	}
+	long, err := strconv.ParseFloat(match[2], 64)
+	if err != nil || math.Abs(long) > 180 {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
@@ -0,0 +1,62 @@
+package vfs
+
+import (
+	"encoding/base64"
+	"path"
+	"strings"
+)
+
+// MountInfo describes the external storage mounted on a directory. The
+// credentials are kept in an io.cozy.accounts document.
+type MountInfo struct {
+	// Provider is the kind of external storage, like webdav or sftp
+	Provider string `json:"provider"`
+	// AccountID is the identifier of the io.cozy.accounts document
+	AccountID string `json:"account_id"`
+	// RemotePath is the path of the mounted directory on the external storage
+	RemotePath string `json:"remote_path"`
+	// HostKey is the fingerprint of the SSH host key, recorded on the first
+	// connection for SFTP.
+	HostKey string `json:"host_key,omitempty"`
+}
+
+// mountedIDSeparator separates the identifier of the mount directory from
+// the encoded relative path in the identifiers of the mounted files. It can't
+// appear in a CouchDB identifier generated by the stack.
+const mountedIDSeparator = "~"
+
+// MountedID returns the identifier of a file or directory inside an external
+// mount, from the identifier of the mount directory and the relative path.
+// These identifiers are not stored in CouchDB.
+func MountedID(mountID, rel string) string {
+	rel = strings.Trim(path.Clean("/"+rel), "/")
+	if rel == "" {
+		return mountID
+	}
+	return mountID + mountedIDSeparator + base64.RawURLEncoding.EncodeToString([]byte(rel))
+}
+
+// SplitMountedID returns the identifier of the mount directory and the
+// relative path from an identifier built by MountedID.
+func SplitMountedID(id string) (mountID, rel string, ok bool) {
+	mountID, encoded, found := strings.Cut(id, mountedIDSeparator)
+	if !found || mountID == "" || encoded == "" {
+		return "", "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	rel = strings.Trim(path.Clean("/"+string(decoded)), "/")
+	if rel == "" {
+		return "", "", false
+	}
+	return mountID, rel, true
+}
+
+// IsMountedID returns true if the identifier is the one of a file or
+// directory inside an external mount.
+func IsMountedID(id string) bool {
+	_, _, ok := SplitMountedID(id)
+	return ok
+}
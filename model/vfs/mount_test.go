@@ -0,0 +1,29 @@
+package vfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountedID(t *testing.T) {
+	assert.Equal(t, "mount-id", MountedID("mount-id", ""))
+	assert.Equal(t, "mount-id", MountedID("mount-id", "/"))
+
+	id := MountedID("mount-id", "/Photos/2026/été.jpg")
+	mountID, rel, ok := SplitMountedID(id)
+	assert.True(t, ok)
+	assert.Equal(t, "mount-id", mountID)
+	assert.Equal(t, "Photos/2026/été.jpg", rel)
+	assert.True(t, IsMountedID(id))
+
+	_, rel, ok = SplitMountedID(MountedID("mount-id", "a/../../b"))
+	assert.True(t, ok)
+	assert.Equal(t, "b", rel)
+
+	assert.False(t, IsMountedID("mount-id"))
+	assert.False(t, IsMountedID("mount-id~"))
+	assert.False(t, IsMountedID("~cGhvdG9z"))
+	assert.False(t, IsMountedID("mount-id~not base64!"))
+	assert.False(t, IsMountedID("io.cozy.files.root-dir"))
+}
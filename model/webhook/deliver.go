@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+)
+
+// deliveryTimeout is the maximal duration of a request to an endpoint.
+const deliveryTimeout = 10 * time.Second
+
+// httpClient is used to post the payloads. The URLs are given by the
+// integrators, so the client is protected against SSRF.
+var httpClient = safehttp.DefaultClient
+
+// Event is a change of a document, as received by the worker.
+type Event struct {
+	Verb   string          `json:"verb"`
+	Doc    json.RawMessage `json:"doc"`
+	OldDoc json.RawMessage `json:"old,omitempty"`
+}
+
+// Payload is the JSON body of the requests sent to the endpoints.
+type Payload struct {
+	WebhookID  string          `json:"webhook_id"`
+	DeliveryID string          `json:"delivery_id"`
+	Domain     string          `json:"domain"`
+	Doctype    string          `json:"doctype"`
+	Verb       string          `json:"verb"`
+	Doc        json.RawMessage `json:"doc"`
+	OldDoc     json.RawMessage `json:"old,omitempty"`
+	Timestamp  int64           `json:"timestamp"`
+}
+
+// DeliveryError is returned when the endpoint could not be reached or has not
+// accepted the event.
+type DeliveryError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("webhook: delivery failed: %s", e.Err)
+	}
+	return fmt.Sprintf("webhook: endpoint responded with %d", e.StatusCode)
+}
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// Retryable returns false if the endpoint has refused the event in a way that
+// will not change on a later attempt.
+func (e *DeliveryError) Retryable() bool {
+	switch e.StatusCode {
+	case 0, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// Sign returns the value of the X-Cozy-Signature header for a payload: the
+// HMAC-SHA256 of the timestamp and the body, with the secret of the webhook.
+func Sign(secret string, timestamp int64, body []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver posts the event to the endpoint of the webhook, and logs the
+// delivery. The deliveryID is the same for the retries of an event, so that
+// the endpoint can ignore the duplicates. The webhook is disabled after
+// MaxFailures consecutive failures.
+func (w *Webhook) Deliver(ctx context.Context, inst *instance.Instance, deliveryID string, evt *Event) error {
+	if w.Disabled {
+		return ErrDisabled
+	}
+	allowed, err := w.sourceAllowed(inst)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		if err := w.disable(inst, "the source of the webhook can no longer read the doctype"); err != nil {
+			return err
+		}
+		w.UpdatedAt = time.Now().UTC()
+		if err := couchdb.UpdateDoc(inst, w); err != nil {
+			return err
+		}
+		return ErrDisabled
+	}
+
+	now := time.Now()
+	payload := &Payload{
+		WebhookID:  w.DocID,
+		DeliveryID: deliveryID,
+		Domain:     inst.Domain,
+		Doctype:    w.Doctype,
+		Verb:       evt.Verb,
+		Doc:        evt.Doc,
+		OldDoc:     evt.OldDoc,
+		Timestamp:  now.Unix(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var docID struct {
+		ID string `json:"_id"`
+	}
+	_ = json.Unmarshal(evt.Doc, &docID)
+	delivery := &Delivery{
+		WebhookID:  w.DocID,
+		JobID:      deliveryID,
+		Verb:       evt.Verb,
+		DocumentID: docID.ID,
+		CreatedAt:  now.UTC(),
+	}
+	delivery.StatusCode, err = w.post(ctx, body, payload)
+	delivery.Duration = time.Since(now).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+	} else if !delivery.Success() {
+		delivery.Error = http.StatusText(delivery.StatusCode)
+	}
+
+	if err := couchdb.CreateDoc(inst, delivery); err != nil {
+		inst.Logger().WithNamespace("webhook").
+			Warnf("Cannot save the delivery for %s: %s", w.DocID, err)
+	} else if err := pruneDeliveries(inst, w.DocID); err != nil {
+		inst.Logger().WithNamespace("webhook").
+			Infof("Cannot prune the deliveries for %s: %s", w.DocID, err)
+	}
+	if err := w.recordResult(inst, delivery); err != nil {
+		inst.Logger().WithNamespace("webhook").
+			Warnf("Cannot update the webhook %s: %s", w.DocID, err)
+	}
+
+	if delivery.Success() {
+		return nil
+	}
+	return &DeliveryError{StatusCode: delivery.StatusCode, Err: err}
+}
+
+func (w *Webhook) post(ctx context.Context, body []byte, payload *Payload) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", build.UserAgent())
+	req.Header.Set("X-Cozy-Webhook-Id", payload.WebhookID)
+	req.Header.Set("X-Cozy-Delivery-Id", payload.DeliveryID)
+	req.Header.Set("X-Cozy-Event", payload.Doctype+":"+payload.Verb)
+	req.Header.Set("X-Cozy-Signature", Sign(w.Secret, payload.Timestamp, body))
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	return res.StatusCode, nil
+}
+
+// recordResult updates the counter of consecutive failures of the webhook,
+// and disables it when there are too many of them. The document is reloaded
+// on conflicts, as several deliveries can be made in parallel.
+func (w *Webhook) recordResult(inst *instance.Instance, delivery *Delivery) error {
+	for i := 0; i < 3; i++ {
+		at := delivery.CreatedAt
+		w.LastDeliveryAt = &at
+		if delivery.Success() {
+			w.Failures = 0
+		} else {
+			w.Failures++
+			if w.Failures >= MaxFailures && !w.Disabled {
+				reason := fmt.Sprintf("%d consecutive failed deliveries", w.Failures)
+				if err := w.disable(inst, reason); err != nil {
+					return err
+				}
+			}
+		}
+		err := couchdb.UpdateDoc(inst, w)
+		if !couchdb.IsConflictError(err) {
+			return err
+		}
+		fresh, err := Get(inst, w.DocID)
+		if err != nil {
+			return err
+		}
+		*w = *fresh
+	}
+	return nil
+}
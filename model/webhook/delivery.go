@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// maxDeliveries is the number of deliveries kept in the logs for a webhook.
+const maxDeliveries = 100
+
+// Delivery is the log of an attempt to deliver an event to a webhook.
+type Delivery struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	WebhookID  string    `json:"webhook_id"`
+	JobID      string    `json:"job_id,omitempty"`
+	Verb       string    `json:"verb"`
+	DocumentID string    `json:"doc_id"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ID returns the delivery qualified identifier
+func (d *Delivery) ID() string { return d.DocID }
+
+// Rev returns the delivery revision
+func (d *Delivery) Rev() string { return d.DocRev }
+
+// DocType returns the delivery document type
+func (d *Delivery) DocType() string { return consts.WebhooksDeliveries }
+
+// Clone implements couchdb.Doc
+func (d *Delivery) Clone() couchdb.Doc {
+	cloned := *d
+	return &cloned
+}
+
+// SetID changes the delivery qualified identifier
+func (d *Delivery) SetID(id string) { d.DocID = id }
+
+// SetRev changes the delivery revision
+func (d *Delivery) SetRev(rev string) { d.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (d *Delivery) Fetch(field string) []string {
+	if field == "webhook_id" {
+		return []string{d.WebhookID}
+	}
+	return nil
+}
+
+// Success returns true if the event has been accepted by the endpoint.
+func (d *Delivery) Success() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// ListDeliveries returns the last deliveries of a webhook, the most recent
+// first.
+func ListDeliveries(inst *instance.Instance, webhookID string, limit int) ([]*Delivery, error) {
+	if limit <= 0 || limit > maxDeliveries {
+		limit = maxDeliveries
+	}
+	var deliveries []*Delivery
+	req := &couchdb.FindRequest{
+		UseIndex: "by-webhook-id",
+		Selector: mango.Equal("webhook_id", webhookID),
+		Sort: mango.SortBy{
+			{Field: "webhook_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit: limit,
+	}
+	err := couchdb.FindDocs(inst, consts.WebhooksDeliveries, req, &deliveries)
+	if couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	return deliveries, err
+}
+
+// pruneDeliveries removes the oldest deliveries of a webhook when there are
+// more than maxDeliveries in the logs.
+func pruneDeliveries(inst *instance.Instance, webhookID string) error {
+	var deliveries []*Delivery
+	req := &couchdb.FindRequest{
+		UseIndex: "by-webhook-id",
+		Selector: mango.Equal("webhook_id", webhookID),
+		Sort: mango.SortBy{
+			{Field: "webhook_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Skip:  maxDeliveries,
+		Limit: 100,
+	}
+	if err := couchdb.FindDocs(inst, consts.WebhooksDeliveries, req, &deliveries); err != nil {
+		return err
+	}
+	return deleteAll(inst, deliveries)
+}
+
+// deleteDeliveries removes all the deliveries of a webhook.
+func deleteDeliveries(inst *instance.Instance, webhookID string) error {
+	for {
+		var deliveries []*Delivery
+		req := &couchdb.FindRequest{
+			UseIndex: "by-webhook-id",
+			Selector: mango.Equal("webhook_id", webhookID),
+			Limit:    1000,
+		}
+		err := couchdb.FindDocs(inst, consts.WebhooksDeliveries, req, &deliveries)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		if err := deleteAll(inst, deliveries); err != nil {
+			return err
+		}
+	}
+}
+
+func deleteAll(inst *instance.Instance, deliveries []*Delivery) error {
+	docs := make([]couchdb.Doc, len(deliveries))
+	for i, d := range deliveries {
+		docs[i] = d
+	}
+	return couchdb.BulkDeleteDocs(inst, consts.WebhooksDeliveries, docs)
+}
@@ -0,0 +1,331 @@
+// Package webhook is for the outgoing webhooks: an integrator can register
+// an HTTPS endpoint that receives signed POST requests when the documents of
+// a doctype are created, updated or deleted.
+package webhook
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// WorkerType is the type of the worker that delivers the webhooks.
+const WorkerType = "outgoing-webhook"
+
+// MaxFailures is the number of consecutive failed deliveries after which a
+// webhook is disabled.
+const MaxFailures = 20
+
+// secretLength is the number of random bytes of the secret used to sign the
+// payloads.
+const secretLength = 32
+
+var (
+	// ErrInvalidWebhook is used when the URL or the rule of a webhook is not
+	// valid.
+	ErrInvalidWebhook = errors.New("Invalid webhook")
+	// ErrDisabled is used when a delivery is asked for a disabled webhook.
+	ErrDisabled = errors.New("The webhook is disabled")
+)
+
+// allVerbs are the verbs of the realtime events that can be used for a
+// webhook.
+var allVerbs = []string{
+	realtime.EventCreate,
+	realtime.EventUpdate,
+	realtime.EventDelete,
+}
+
+// Webhook is an HTTPS endpoint where the changes of the documents of a
+// doctype are posted. The filtering is done by an @event trigger, and the
+// deliveries are made by the outgoing-webhook worker.
+type Webhook struct {
+	DocID          string     `json:"_id,omitempty"`
+	DocRev         string     `json:"_rev,omitempty"`
+	URL            string     `json:"url"`
+	Secret         string     `json:"secret,omitempty"`
+	Doctype        string     `json:"doctype"`
+	Verbs          []string   `json:"verbs"`
+	Selector       string     `json:"selector,omitempty"`
+	Values         []string   `json:"values,omitempty"`
+	TriggerID      string     `json:"trigger_id,omitempty"`
+	Disabled       bool       `json:"disabled,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	Failures       int        `json:"failures,omitempty"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	SourceID       string     `json:"source_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ID returns the webhook qualified identifier
+func (w *Webhook) ID() string { return w.DocID }
+
+// Rev returns the webhook revision
+func (w *Webhook) Rev() string { return w.DocRev }
+
+// DocType returns the webhook document type
+func (w *Webhook) DocType() string { return consts.Webhooks }
+
+// Clone implements couchdb.Doc
+func (w *Webhook) Clone() couchdb.Doc {
+	cloned := *w
+	cloned.Verbs = make([]string, len(w.Verbs))
+	copy(cloned.Verbs, w.Verbs)
+	if w.Values != nil {
+		cloned.Values = make([]string, len(w.Values))
+		copy(cloned.Values, w.Values)
+	}
+	if w.LastDeliveryAt != nil {
+		at := *w.LastDeliveryAt
+		cloned.LastDeliveryAt = &at
+	}
+	return &cloned
+}
+
+// SetID changes the webhook qualified identifier
+func (w *Webhook) SetID(id string) { w.DocID = id }
+
+// SetRev changes the webhook revision
+func (w *Webhook) SetRev(rev string) { w.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (w *Webhook) Fetch(field string) []string {
+	switch field {
+	case "doctype":
+		return []string{w.Doctype}
+	case "source_id":
+		return []string{w.SourceID}
+	}
+	return nil
+}
+
+// Message is the message of the jobs for the outgoing-webhook worker.
+type Message struct {
+	WebhookID string `json:"webhook_id"`
+}
+
+// validate checks the URL and the rule of the webhook, and normalizes the
+// verbs.
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Host == "" || u.User != nil {
+		return ErrInvalidWebhook
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || !build.IsDevRelease()) {
+		return ErrInvalidWebhook
+	}
+	if permission.CheckDoctypeName(w.Doctype, false) != nil {
+		return ErrInvalidWebhook
+	}
+	// A webhook on the deliveries would be called in a loop, and the jobs
+	// and triggers have the messages and arguments of the other webhooks.
+	switch w.Doctype {
+	case consts.Webhooks, consts.WebhooksDeliveries, consts.Jobs, consts.Triggers:
+		return ErrInvalidWebhook
+	}
+
+	if len(w.Verbs) == 0 {
+		w.Verbs = allVerbs
+	}
+	verbs := make([]string, 0, len(w.Verbs))
+	for _, verb := range allVerbs {
+		for _, v := range w.Verbs {
+			if strings.ToUpper(v) == verb {
+				verbs = append(verbs, verb)
+				break
+			}
+		}
+	}
+	if len(verbs) != len(w.Verbs) {
+		return ErrInvalidWebhook
+	}
+	w.Verbs = verbs
+
+	// The selector and the values are serialized in the arguments of the
+	// trigger, where the colons, spaces and commas are separators.
+	if strings.ContainsAny(w.Selector, ": ,") {
+		return ErrInvalidWebhook
+	}
+	if w.Selector != "" && len(w.Values) == 0 {
+		return ErrInvalidWebhook
+	}
+	for _, v := range w.Values {
+		if v == "" || strings.ContainsAny(v, ": ,") {
+			return ErrInvalidWebhook
+		}
+	}
+	return nil
+}
+
+// triggerArguments returns the arguments of the @event trigger used for the
+// filtering of the events, like io.cozy.contacts:CREATED,UPDATED:work:tags
+func (w *Webhook) triggerArguments() string {
+	args := w.Doctype + ":" + strings.Join(w.Verbs, ",")
+	if len(w.Values) > 0 {
+		args += ":" + strings.Join(w.Values, ",")
+		if w.Selector != "" {
+			args += ":" + w.Selector
+		}
+	}
+	return args
+}
+
+// Create registers a new webhook, with a random secret for signing the
+// payloads.
+func Create(inst *instance.Instance, w *Webhook) error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	w.DocID = ""
+	w.DocRev = ""
+	w.Secret = hex.EncodeToString(crypto.GenerateRandomBytes(secretLength))
+	w.TriggerID = ""
+	w.Disabled = false
+	w.DisabledReason = ""
+	w.Failures = 0
+	w.LastDeliveryAt = nil
+	w.CreatedAt = now
+	w.UpdatedAt = now
+	if err := couchdb.CreateDoc(inst, w); err != nil {
+		return err
+	}
+	if err := w.addTrigger(inst); err != nil {
+		_ = couchdb.DeleteDoc(inst, w)
+		return err
+	}
+	return couchdb.UpdateDoc(inst, w)
+}
+
+// Get returns the webhook with the given identifier.
+func Get(inst *instance.Instance, id string) (*Webhook, error) {
+	w := &Webhook{}
+	if err := couchdb.GetDoc(inst, consts.Webhooks, id, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// List returns all the webhooks of the instance.
+func List(inst *instance.Instance) ([]*Webhook, error) {
+	var hooks []*Webhook
+	err := couchdb.GetAllDocs(inst, consts.Webhooks, nil, &hooks)
+	if couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	return hooks, err
+}
+
+// Delete removes the webhook, its trigger and the logs of its deliveries.
+func (w *Webhook) Delete(inst *instance.Instance) error {
+	if err := w.deleteTrigger(inst); err != nil {
+		return err
+	}
+	if err := deleteDeliveries(inst, w.DocID); err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(inst, w)
+}
+
+// Enable reactivates a webhook that has been disabled after too many failed
+// deliveries.
+func (w *Webhook) Enable(inst *instance.Instance) error {
+	if !w.Disabled {
+		return nil
+	}
+	w.Disabled = false
+	w.DisabledReason = ""
+	w.Failures = 0
+	w.UpdatedAt = time.Now().UTC()
+	if err := w.addTrigger(inst); err != nil {
+		return err
+	}
+	return couchdb.UpdateDoc(inst, w)
+}
+
+// disable stops the deliveries for the webhook.
+func (w *Webhook) disable(inst *instance.Instance, reason string) error {
+	if err := w.deleteTrigger(inst); err != nil {
+		return err
+	}
+	w.Disabled = true
+	w.DisabledReason = reason
+	return nil
+}
+
+// sourceAllowed returns false if the application or the OAuth client that
+// has created the webhook can no longer read all the documents of the
+// doctype, as the permissions are only checked when the webhook is created:
+// the application may have been uninstalled or updated with fewer
+// permissions, and the OAuth client may have been revoked.
+func (w *Webhook) sourceAllowed(inst *instance.Instance) (bool, error) {
+	var perm *permission.Permission
+	var err error
+	switch {
+	case w.SourceID == "":
+		// Created via the CLI
+		return true, nil
+	case strings.HasPrefix(w.SourceID, consts.Apps+"/"):
+		perm, err = permission.GetForWebapp(inst, strings.TrimPrefix(w.SourceID, consts.Apps+"/"))
+	case strings.HasPrefix(w.SourceID, consts.Konnectors+"/"):
+		perm, err = permission.GetForKonnector(inst, strings.TrimPrefix(w.SourceID, consts.Konnectors+"/"))
+	case strings.Contains(w.SourceID, "/"):
+		return true, nil
+	default:
+		// The scope of the tokens is not known here, only that the client
+		// has not been revoked.
+		_, err = oauth.FindClient(inst, w.SourceID)
+		if couchdb.IsNotFoundError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if couchdb.IsNotFoundError(err) || errors.Is(err, permission.ErrExpiredToken) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return perm.Permissions.AllowWholeType(permission.GET, w.Doctype), nil
+}
+
+func (w *Webhook) addTrigger(inst *instance.Instance) error {
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@event",
+		WorkerType: WorkerType,
+		Arguments:  w.triggerArguments(),
+	}, &Message{WebhookID: w.DocID})
+	if err != nil {
+		return err
+	}
+	if err := job.System().AddTrigger(t); err != nil {
+		return err
+	}
+	w.TriggerID = t.Infos().TID
+	return nil
+}
+
+func (w *Webhook) deleteTrigger(inst *instance.Instance) error {
+	if w.TriggerID == "" {
+		return nil
+	}
+	err := job.System().DeleteTrigger(inst, w.TriggerID)
+	if err != nil && err != job.ErrNotFoundTrigger {
+		return err
+	}
+	w.TriggerID = ""
+	return nil
+}
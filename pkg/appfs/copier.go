@@ -46,13 +46,14 @@ type aferoCopier struct {
 	started bool
 }
 
-// NewSwiftCopier defines a Copier storing data into a swift container.
+// NewSwiftCopier defines a Copier storing data into a swift container. An
+// integrity manifest is stored with the files of the application.
 func NewSwiftCopier(conn *swift.Connection, appsType consts.AppType) Copier {
-	return &swiftCopier{
+	return newIntegrityCopier(&swiftCopier{
 		c:         conn,
 		container: containerName(appsType),
 		ctx:       context.Background(),
-	}
+	})
 }
 
 func (f *swiftCopier) Exist(slug, version, shasum string) (bool, error) {
@@ -153,9 +154,10 @@ func (f *swiftCopier) Commit() (err error) {
 }
 
 // NewAferoCopier defines a copier using an afero.Fs filesystem to store the
-// application data.
+// application data. An integrity manifest is stored with the files of the
+// application.
 func NewAferoCopier(fs afero.Fs) Copier {
-	return &aferoCopier{fs: fs}
+	return newIntegrityCopier(&aferoCopier{fs: fs})
 }
 
 func (f *aferoCopier) Exist(slug, version, shasum string) (bool, error) {
@@ -0,0 +1,229 @@
+package appfs
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// IntegrityFileName is the name of the file with the integrity manifest of an
+// application, stored with its files.
+const IntegrityFileName = ".cozy-integrity.json"
+
+// ErrTampered is used when a file of an application does not match the
+// integrity manifest written when the application was installed.
+var ErrTampered = errors.New("appfs: the file does not match the integrity manifest")
+
+// Integrity is the manifest with the hashes of the files of an application,
+// in the Subresource Integrity format (sha384-<base64>). The keys are the
+// paths of the files, starting with a slash.
+type Integrity struct {
+	Files map[string]string `json:"files"`
+}
+
+// IntegrityHash returns the hash of a content in the Subresource Integrity
+// format.
+func IntegrityHash(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Check returns ErrTampered if the content is not the one of the file when the
+// application was installed. The files that were not in the application are
+// refused too.
+func (in *Integrity) Check(file string, content []byte) error {
+	expected, ok := in.Files[path.Join("/", file)]
+	if !ok || expected != IntegrityHash(content) {
+		return fmt.Errorf("%w: %s", ErrTampered, path.Join("/", file))
+	}
+	return nil
+}
+
+func isIntegrityFile(file string) bool {
+	return path.Join("/", file) == "/"+IntegrityFileName
+}
+
+type integrityCopier struct {
+	Copier
+	integrity *Integrity
+}
+
+// newIntegrityCopier returns a Copier that computes the hashes of the copied
+// files, and stores them in an integrity manifest with the files of the
+// application when committing. The file servers use this manifest to check
+// that the files have not been modified since they were installed.
+func newIntegrityCopier(c Copier) Copier {
+	return &integrityCopier{Copier: c}
+}
+
+func (f *integrityCopier) Start(slug, version, shasum string) (bool, error) {
+	f.integrity = &Integrity{Files: make(map[string]string)}
+	return f.Copier.Start(slug, version, shasum)
+}
+
+func (f *integrityCopier) Copy(stat os.FileInfo, src io.Reader) error {
+	if isIntegrityFile(stat.Name()) {
+		// The manifest is computed by the copier, not taken from the
+		// package.
+		_, err := io.Copy(io.Discard, src)
+		return err
+	}
+	h := sha512.New384()
+	if err := f.Copier.Copy(stat, io.TeeReader(src, h)); err != nil {
+		return err
+	}
+	sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	f.integrity.Files[path.Join("/", stat.Name())] = "sha384-" + sum
+	return nil
+}
+
+func (f *integrityCopier) Commit() error {
+	content, err := json.Marshal(f.integrity)
+	if err != nil {
+		_ = f.Copier.Abort()
+		return err
+	}
+	stat := NewFileInfo(IntegrityFileName, int64(len(content)), 0640)
+	if err := f.Copier.Copy(stat, bytes.NewReader(content)); err != nil {
+		_ = f.Copier.Abort()
+		return err
+	}
+	return f.Copier.Commit()
+}
+
+// integrityCacheSize is the number of entries kept in the caches for the
+// integrity manifests and the checked files.
+const integrityCacheSize = 1024
+
+var (
+	integrities      *lru.Cache[string, *Integrity]
+	checkedFiles     *lru.Cache[string, struct{}]
+	initIntegrityLRU sync.Once
+)
+
+func initIntegrityCaches() {
+	initIntegrityLRU.Do(func() {
+		var err error
+		integrities, err = lru.New[string, *Integrity](integrityCacheSize)
+		if err != nil {
+			panic(err)
+		}
+		checkedFiles, err = lru.New[string, struct{}](integrityCacheSize)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+// WithIntegrity returns a file server that checks the integrity manifest of
+// an application against its hash, recorded in CouchDB when the application
+// was installed (see ManifestIntegrity). A manifest that has been removed or
+// modified since then is refused, like a tampered file. An empty hash is used
+// for the applications installed before the hash was recorded.
+func WithIntegrity(s FileServer, hash string) FileServer {
+	if hash == "" {
+		return s
+	}
+	switch s := s.(type) {
+	case *aferoServer:
+		clone := *s
+		clone.integrity = hash
+		return &clone
+	case *swiftServer:
+		clone := *s
+		clone.integrity = hash
+		return &clone
+	}
+	return s
+}
+
+// ManifestIntegrity returns the hash of the integrity manifest of an
+// application, or an empty string if the application has no manifest.
+func ManifestIntegrity(s FileServer, slug, version, shasum string) (string, error) {
+	raw, err := readIntegrityFile(s, slug, version, shasum)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return IntegrityHash(raw), nil
+}
+
+func readIntegrityFile(s FileServer, slug, version, shasum string) ([]byte, error) {
+	f, err := s.Open(slug, version, shasum, IntegrityFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// loadIntegrity returns the integrity manifest of an application, or nil if
+// the application was installed without one (before the manifests were
+// introduced, or for the development of an app with --appdir). When the hash
+// of the manifest is known, the manifest must match it. The manifests are
+// cached, as the files of a version of an application never change.
+func loadIntegrity(s FileServer, key, slug, version, shasum, expected string) (*Integrity, error) {
+	initIntegrityCaches()
+	key += "#" + expected
+	if in, ok := integrities.Get(key); ok {
+		return in, nil
+	}
+	var in *Integrity
+	raw, err := readIntegrityFile(s, slug, version, shasum)
+	switch {
+	case err == nil:
+		if expected != "" && IntegrityHash(raw) != expected {
+			return nil, fmt.Errorf("%w: /%s", ErrTampered, IntegrityFileName)
+		}
+		in = &Integrity{}
+		if err := json.Unmarshal(raw, in); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		if expected != "" {
+			return nil, fmt.Errorf("%w: /%s is missing", ErrTampered, IntegrityFileName)
+		}
+	default:
+		return nil, err
+	}
+	integrities.Add(key, in)
+	return in, nil
+}
+
+// checkIntegrity checks a file of an application against the integrity
+// manifest. The appKey identifies the application version in the storage,
+// and the fileKey identifies the content of the file, to not check it again
+// on each request. The content function returns the uncompressed content.
+func checkIntegrity(s FileServer, expected, appKey, fileKey, slug, version, shasum, file string, content func() ([]byte, error)) error {
+	if isIntegrityFile(file) {
+		return nil
+	}
+	in, err := loadIntegrity(s, appKey, slug, version, shasum, expected)
+	if err != nil || in == nil {
+		return err
+	}
+	fileKey += "#" + expected
+	if _, ok := checkedFiles.Get(fileKey); ok {
+		return nil
+	}
+	b, err := content()
+	if err != nil {
+		return err
+	}
+	if err := in.Check(file, b); err != nil {
+		return err
+	}
+	checkedFiles.Add(fileKey, struct{}{})
+	return nil
+}
@@ -43,11 +43,13 @@ type swiftServer struct {
 	c         *swift.Connection
 	container string
 	ctx       context.Context
+	integrity string
 }
 
 type aferoServer struct {
-	mkPath func(slug, version, shasum, file string) string
-	fs     afero.Fs
+	mkPath    func(slug, version, shasum, file string) string
+	fs        afero.Fs
+	integrity string
 }
 
 type brotliReadCloser struct {
@@ -126,29 +128,49 @@ func NewSwiftFileServer(conn *swift.Connection, appsType consts.AppType) FileSer
 	}
 }
 
-func (s *swiftServer) openWithCache(objName string) (io.ReadCloser, swift.Headers, error) {
+func (s *swiftServer) getWithCache(objName string) (cacheEntry, error) {
 	entry, ok := cache.Get(objName)
 	if !ok {
 		f, h, err := s.c.ObjectOpen(s.ctx, s.container, objName, false, nil)
 		if err != nil {
-			return f, h, err
+			return entry, err
 		}
+		defer f.Close()
 		entry.headers = h
 		entry.content, err = io.ReadAll(f)
 		if err != nil {
-			return nil, h, err
+			return entry, err
 		}
 		cache.Add(objName, entry)
 	}
+	return entry, nil
+}
+
+// openWithCache opens a file of an application, and checks it against the
+// integrity manifest of the application.
+func (s *swiftServer) openWithCache(slug, version, shasum, file string) (io.ReadCloser, swift.Headers, error) {
+	objName := s.makeObjectName(slug, version, shasum, file)
+	entry, err := s.getWithCache(objName)
+	if err != nil {
+		return nil, nil, wrapSwiftErr(err)
+	}
+	appKey := s.container + "/" + s.makeObjectName(slug, version, shasum, "")
+	fileKey := s.container + "/" + objName + "@" + entry.headers["Etag"]
+	err = checkIntegrity(s, s.integrity, appKey, fileKey, slug, version, shasum, file, func() ([]byte, error) {
+		encoding := entry.headers.ObjectMetadata()["content-encoding"]
+		return decodeContent(entry.content, encoding)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 	f := io.NopCloser(bytes.NewReader(entry.content))
 	return f, entry.headers, nil
 }
 
 func (s *swiftServer) Open(slug, version, shasum, file string) (io.ReadCloser, error) {
-	objName := s.makeObjectName(slug, version, shasum, file)
-	f, h, err := s.openWithCache(objName)
+	f, h, err := s.openWithCache(slug, version, shasum, file)
 	if err != nil {
-		return nil, wrapSwiftErr(err)
+		return nil, err
 	}
 	o := h.ObjectMetadata()
 	contentEncoding := o["content-encoding"]
@@ -161,10 +183,9 @@ func (s *swiftServer) Open(slug, version, shasum, file string) (io.ReadCloser, e
 }
 
 func (s *swiftServer) ServeFileContent(w http.ResponseWriter, req *http.Request, slug, version, shasum, file string) error {
-	objName := s.makeObjectName(slug, version, shasum, file)
-	f, h, err := s.openWithCache(objName)
+	f, h, err := s.openWithCache(slug, version, shasum, file)
 	if err != nil {
-		return wrapSwiftErr(err)
+		return err
 	}
 	defer f.Close()
 
@@ -273,7 +294,7 @@ func (s *swiftServer) FilesList(slug, version, shasum string) ([]string, error)
 	filtered := names[:0]
 	for _, n := range names {
 		n = strings.TrimPrefix(n, prefix)
-		if n != "" {
+		if n != "" && !isIntegrityFile(n) {
 			filtered = append(filtered, n)
 		}
 	}
@@ -320,12 +341,54 @@ func (s *aferoServer) openFile(filepath string) (afero.File, int, error) {
 	return f, compression, err
 }
 
+// checkIntegrity checks a file of an application against the integrity
+// manifest of the application. The file is rewinded after being read.
+func (s *aferoServer) checkIntegrity(slug, version, shasum, file string, f afero.File, compression int) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	appKey := s.storageKey(s.mkPath(slug, version, shasum, ""))
+	fileKey := fmt.Sprintf("%s@%d-%d", s.storageKey(f.Name()), stat.Size(), stat.ModTime().UnixNano())
+	return checkIntegrity(s, s.integrity, appKey, fileKey, slug, version, shasum, file, func() ([]byte, error) {
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		switch compression {
+		case gzipped:
+			return decodeContent(raw, "gzip")
+		case brotlied:
+			return decodeContent(raw, "br")
+		}
+		return raw, nil
+	})
+}
+
+// storageKey returns a key for the given path that is unique for the
+// storages of all the instances.
+func (s *aferoServer) storageKey(name string) string {
+	if fs, ok := s.fs.(*afero.BasePathFs); ok {
+		if realpath, err := fs.RealPath(name); err == nil {
+			return realpath
+		}
+	}
+	return fmt.Sprintf("%p:%s", s.fs, name)
+}
+
 func (s *aferoServer) Open(slug, version, shasum, file string) (io.ReadCloser, error) {
 	filepath := s.mkPath(slug, version, shasum, file)
 	f, compression, err := s.openFile(filepath)
 	if err != nil {
 		return nil, err
 	}
+	if err = s.checkIntegrity(slug, version, shasum, file, f, compression); err != nil {
+		f.Close()
+		return nil, err
+	}
 	switch compression {
 	case uncompressed:
 		return f, nil
@@ -340,15 +403,19 @@ func (s *aferoServer) Open(slug, version, shasum, file string) (io.ReadCloser, e
 
 func (s *aferoServer) ServeFileContent(w http.ResponseWriter, req *http.Request, slug, version, shasum, file string) error {
 	filepath := s.mkPath(slug, version, shasum, file)
-	return s.serveFileContent(w, req, filepath)
-}
-
-func (s *aferoServer) serveFileContent(w http.ResponseWriter, req *http.Request, filepath string) error {
 	f, compression, err := s.openFile(filepath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	if err = s.checkIntegrity(slug, version, shasum, file, f, compression); err != nil {
+		return err
+	}
+	return s.serveFileContent(w, req, filepath, f, compression)
+}
+
+func (s *aferoServer) serveFileContent(w http.ResponseWriter, req *http.Request, filepath string, f afero.File, compression int) error {
+	var err error
 
 	var content io.Reader
 	var size int64
@@ -442,7 +509,9 @@ func (s *aferoServer) FilesList(slug, version, shasum string) ([]string, error)
 			name := strings.TrimPrefix(path, rootPath)
 			name = strings.TrimSuffix(name, ".gz")
 			name = strings.TrimSuffix(name, ".br")
-			names = append(names, name)
+			if !isIntegrityFile(name) {
+				names = append(names, name)
+			}
 		}
 		return nil
 	})
@@ -458,6 +527,23 @@ func defaultMakePath(slug, version, shasum, file string) string {
 	return path.Join(basepath, filepath)
 }
 
+// decodeContent returns the uncompressed content of a file stored with the
+// given content-encoding.
+func decodeContent(raw []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "br":
+		return io.ReadAll(brotli.NewReader(bytes.NewReader(raw)))
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return io.ReadAll(gr)
+	}
+	return raw, nil
+}
+
 func acceptBrotliEncoding(req *http.Request) bool {
 	return strings.Contains(req.Header.Get(echo.HeaderAcceptEncoding), "br")
 }
@@ -1,12 +1,19 @@
 package appfs
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_serveContent(t *testing.T) {
@@ -40,3 +47,132 @@ func Test_serveContent(t *testing.T) {
 		assert.Equal(t, "10", w.Result().Header.Get("Content-Length"))
 	})
 }
+
+func TestIntegrity(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	copier := NewAferoCopier(fs)
+	exists, err := copier.Start("drive", "1.0.0", "abc")
+	require.NoError(t, err)
+	require.False(t, exists)
+	for name, content := range map[string]string{
+		"index.html":  "<html></html>",
+		"js/app.js":   "console.log('drive')",
+		"/style.css":  "body {}",
+		"manifest.io": "{}",
+	} {
+		info := NewFileInfo(name, int64(len(content)), 0640)
+		require.NoError(t, copier.Copy(info, strings.NewReader(content)))
+	}
+	require.NoError(t, copier.Commit())
+
+	server := NewAferoFileServer(fs, nil)
+	serve := func(file string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "http://drive.example.org"+file, nil)
+		w := httptest.NewRecorder()
+		err := server.ServeFileContent(w, r, "drive", "1.0.0", "abc", file)
+		return w, err
+	}
+
+	t.Run("Manifest", func(t *testing.T) {
+		f, err := server.Open("drive", "1.0.0", "abc", IntegrityFileName)
+		require.NoError(t, err)
+		defer f.Close()
+		var in Integrity
+		require.NoError(t, json.NewDecoder(f).Decode(&in))
+		assert.Len(t, in.Files, 4)
+		assert.Equal(t, IntegrityHash([]byte("console.log('drive')")), in.Files["/js/app.js"])
+		assert.NoError(t, in.Check("style.css", []byte("body {}")))
+		assert.ErrorIs(t, in.Check("/style.css", []byte("body { color: red }")), ErrTampered)
+		assert.ErrorIs(t, in.Check("/other.js", []byte("")), ErrTampered)
+
+		names, err := server.FilesList("drive", "1.0.0", "abc")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/index.html", "/js/app.js", "/style.css", "/manifest.io"}, names)
+	})
+
+	t.Run("ServeIntactFile", func(t *testing.T) {
+		w, err := serve("/js/app.js")
+		require.NoError(t, err)
+		assert.Equal(t, "console.log('drive')", w.Body.String())
+
+		f, err := server.Open("drive", "1.0.0", "abc", "/index.html")
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "<html></html>", string(content))
+	})
+
+	t.Run("RefuseTamperedFile", func(t *testing.T) {
+		// Wait to be sure that the modification time is not the same
+		time.Sleep(10 * time.Millisecond)
+		tampered := &bytes.Buffer{}
+		bw := brotli.NewWriter(tampered)
+		_, _ = bw.Write([]byte("alert('pwned')"))
+		require.NoError(t, bw.Close())
+		require.NoError(t, afero.WriteFile(fs, "/drive/1.0.0-abc/js/app.js.br", tampered.Bytes(), 0640))
+
+		w, err := serve("/js/app.js")
+		assert.ErrorIs(t, err, ErrTampered)
+		assert.Empty(t, w.Body.String())
+		_, err = server.Open("drive", "1.0.0", "abc", "/js/app.js")
+		assert.ErrorIs(t, err, ErrTampered)
+
+		require.NoError(t, afero.WriteFile(fs, "/drive/1.0.0-abc/evil.js", []byte("alert('pwned')"), 0640))
+		_, err = serve("/evil.js")
+		assert.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("WithoutManifest", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(fs, "/legacy/1.0.0/index.html", []byte("<html></html>"), 0640))
+		r := httptest.NewRequest(http.MethodGet, "http://legacy.example.org/index.html", nil)
+		w := httptest.NewRecorder()
+		err := server.ServeFileContent(w, r, "legacy", "1.0.0", "", "/index.html")
+		require.NoError(t, err)
+		assert.Equal(t, "<html></html>", w.Body.String())
+	})
+
+	t.Run("RefuseTamperedManifest", func(t *testing.T) {
+		copier := NewAferoCopier(fs)
+		_, err := copier.Start("notes", "2.0.0", "def")
+		require.NoError(t, err)
+		content := "<html></html>"
+		require.NoError(t, copier.Copy(NewFileInfo("index.html", int64(len(content)), 0640), strings.NewReader(content)))
+		require.NoError(t, copier.Commit())
+
+		hash, err := ManifestIntegrity(server, "notes", "2.0.0", "def")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "sha384-"))
+		checked := WithIntegrity(server, hash)
+		open := func() error {
+			f, err := checked.Open("notes", "2.0.0", "def", "/index.html")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}
+		require.NoError(t, open())
+
+		// A manifest rewritten for a tampered file is refused
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, afero.WriteFile(fs, "/notes/2.0.0-def/index.html.br", brotliContent(t, "<script>alert('pwned')</script>"), 0640))
+		forged := `{"files":{"/index.html":"` + IntegrityHash([]byte("<script>alert('pwned')</script>")) + `"}}`
+		require.NoError(t, afero.WriteFile(fs, "/notes/2.0.0-def/"+IntegrityFileName+".br", brotliContent(t, forged), 0640))
+		integrities.Purge()
+		assert.ErrorIs(t, open(), ErrTampered)
+
+		// And a removed manifest too
+		require.NoError(t, fs.Remove("/notes/2.0.0-def/"+IntegrityFileName+".br"))
+		integrities.Purge()
+		assert.ErrorIs(t, open(), ErrTampered)
+	})
+}
+
+func brotliContent(t *testing.T, content string) []byte {
+	buf := &bytes.Buffer{}
+	bw := brotli.NewWriter(buf)
+	_, err := bw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, bw.Close())
+	return buf.Bytes()
+}
@@ -5,6 +5,8 @@ package config
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -112,6 +114,7 @@ type Config struct {
 	NoReplyName           string
 	ReplyTo               string
 	GeoDB                 string
+	GeocodingDB           string
 	PasswordResetInterval time.Duration
 
 	RemoteAssets         map[string]string
@@ -127,9 +130,12 @@ type Config struct {
 	Konnectors             Konnectors
 	Mail                   *gomail.DialerOptions
 	MailPerContext         map[string]interface{}
+	MailTransport          MailTransport
+	InboundMail            InboundMail
 	CampaignMail           *gomail.DialerOptions
 	CampaignMailPerContext map[string]interface{}
 	Move                   Move
+	Backup                 Backup
 	Notifications          Notifications
 	Flagship               Flagship
 
@@ -150,6 +156,10 @@ type Config struct {
 	Registries     map[string][]*url.URL
 	Clouderies     map[string]ClouderyConfig
 
+	// AppsSignatures are the public keys pinned to verify the signatures of
+	// the packages of the applications.
+	AppsSignatures []AppsSignatureKeys
+
 	RabbitMQ RabbitMQ
 
 	// SafeHTTPTrustedNetworks is a list of private CIDRs that safehttp
@@ -161,11 +171,54 @@ type Config struct {
 	CSPDisabled   bool
 	CSPAllowList  map[string]string
 	CSPPerContext map[string]map[string]string
+	CSPApps       map[string]CSPApp
 
 	AssetsPollingDisabled bool
 	AssetsPollingInterval time.Duration
 }
 
+// CSPApp is the override of the CSP policy of a webapp by the administrator.
+type CSPApp struct {
+	// Sources are the sources added to the policy of the app, by directive
+	// (script, img, connect, etc.), like for csp_allowlist.
+	Sources map[string]string
+	// IgnoreManifest is true when the sources declared in the manifest of
+	// the app must not be used.
+	IgnoreManifest bool
+	// ReportOnly is true when the policy with the sources of the app must
+	// only be reported, while the current policy is still enforced.
+	ReportOnly bool
+}
+
+// AppsSignatureKeys are the ed25519 public keys of the publishers of the
+// applications, pinned for a context or for a registry. When neither is set,
+// the keys are used for all the contexts.
+type AppsSignatureKeys struct {
+	Context  string `mapstructure:"context" yaml:"context"`
+	Registry string `mapstructure:"registry" yaml:"registry"`
+	// Keys are the public keys encoded in base64.
+	Keys []string `mapstructure:"keys" yaml:"keys"`
+	// Required is true when the packages without a valid signature must be
+	// refused.
+	Required bool `mapstructure:"required" yaml:"required"`
+}
+
+// PublicKeys returns the decoded public keys.
+func (k AppsSignatureKeys) PublicKeys() ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(k.Keys))
+	for _, key := range k.Keys {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid size for the ed25519 public key %q", key)
+		}
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+	return keys, nil
+}
+
 // RabbitMQ contains configuration for the RabbitMQ consumers.
 type RabbitMQ struct {
 	Enabled   bool                    `mapstructure:"enabled" yaml:"enabled"`
@@ -275,6 +328,19 @@ type Move struct {
 	URL string
 }
 
+// Backup contains the configuration for the incremental backups of the
+// instances.
+type Backup struct {
+	// EncryptorKey is the path to the NaCl key used to encrypt the backups
+	// of the instances that don't have their own key.
+	EncryptorKey string
+	// FullEvery is the number of incremental snapshots after which a new
+	// full snapshot is taken.
+	FullEvery int
+	// Retention is how long the snapshots are kept.
+	Retention time.Duration
+}
+
 // Office contains the configuration for collaborative edition of office
 // documents
 type Office struct {
@@ -310,6 +376,9 @@ type Notifications struct {
 	HuaweiGetTokenURL     string
 	HuaweiSendMessagesURL string
 
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+
 	Contexts map[string]SMS
 }
 
@@ -403,6 +472,51 @@ func GetSharingNotificationsConfig(contextName string) SharingNotificationsConfi
 	return defaultConfig
 }
 
+// MailTransport contains the configuration for how the mails are delivered:
+// the transport to use, the DKIM signature, and the secret for the webhook
+// where the bounces and complaints are reported.
+type MailTransport struct {
+	// Transport is "smtp" (default), "sendmail" or "http"
+	Transport    string        `mapstructure:"transport"`
+	SendmailPath string        `mapstructure:"sendmail_path"`
+	HTTP         MailHTTPRelay `mapstructure:"http"`
+	DKIM         DKIM          `mapstructure:"dkim"`
+	BounceSecret string        `mapstructure:"bounce_secret"`
+}
+
+// MailHTTPRelay is the configuration for sending the mails to an HTTP API.
+type MailHTTPRelay struct {
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+}
+
+// DKIM is the configuration for signing the outgoing mails.
+type DKIM struct {
+	Domain         string `mapstructure:"domain"`
+	Selector       string `mapstructure:"selector"`
+	PrivateKeyPath string `mapstructure:"private_key"`
+}
+
+// InboundMail contains the configuration for receiving mails: the mails sent
+// to <instance domain>+<token>@<domain> are stored in the instance.
+type InboundMail struct {
+	Domain  string `mapstructure:"domain"`
+	MaxSize int64  `mapstructure:"max_size"`
+}
+
+// GetMailTransport returns the mail transport configuration for the given
+// context: the keys of mail.contexts.<contextName> override the global ones.
+func GetMailTransport(contextName string) MailTransport {
+	if config == nil {
+		return MailTransport{}
+	}
+	cfg := config.MailTransport
+	if ctxConfig, ok := config.MailPerContext[contextName].(map[string]interface{}); ok {
+		_ = mapstructure.Decode(ctxConfig, &cfg)
+	}
+	return cfg
+}
+
 // SMS contains the configuration to send notifications by SMS.
 type SMS struct {
 	Provider string
@@ -444,10 +558,15 @@ type OIDCAppTokenExchangeAppConfig struct {
 
 // Worker contains the configuration fields for a specific worker type.
 type Worker struct {
-	WorkerType   string
-	Concurrency  *int
-	MaxExecCount *int
-	Timeout      *time.Duration
+	WorkerType    string
+	Concurrency   *int
+	MaxExecCount  *int
+	Timeout       *time.Duration
+	RetryDelay    *time.Duration
+	MaxRetryDelay *time.Duration
+	Backoff       *string
+	Jitter        *float64
+	DeadLetter    *bool
 }
 
 // GetRedis returns a [redis.UniversalClient] for the given db.
@@ -1026,6 +1145,48 @@ func UseViper(v *viper.Viper) error {
 								}
 								w.Timeout = &d
 							}
+						case "retry_delay", "max_retry_delay":
+							if delay, ok := v.(string); ok {
+								var d time.Duration
+								d, err = time.ParseDuration(delay)
+								if err != nil {
+									return fmt.Errorf("config: could not parse %s duration for worker %q: %s",
+										k, workerType, err)
+								}
+								if k == "retry_delay" {
+									w.RetryDelay = &d
+								} else {
+									w.MaxRetryDelay = &d
+								}
+							}
+						case "backoff":
+							if backoff, ok := v.(string); ok {
+								if backoff != "exponential" && backoff != "constant" {
+									return fmt.Errorf("config: invalid backoff %q for worker %q",
+										backoff, workerType)
+								}
+								w.Backoff = &backoff
+							}
+						case "jitter":
+							var jitter float64
+							switch j := v.(type) {
+							case float64:
+								jitter = j
+							case int:
+								jitter = float64(j)
+							default:
+								return fmt.Errorf("config: invalid jitter %v for worker %q",
+									v, workerType)
+							}
+							if jitter < 0 || jitter > 1 {
+								return fmt.Errorf("config: the jitter for worker %q must be between 0 and 1",
+									workerType)
+							}
+							w.Jitter = &jitter
+						case "dead_letter":
+							if deadLetter, ok := v.(bool); ok {
+								w.DeadLetter = &deadLetter
+							}
 						default:
 							return fmt.Errorf("config: unknown key %q",
 								"jobs.workers."+workerType+"."+k)
@@ -1072,6 +1233,28 @@ func UseViper(v *viper.Viper) error {
 		}
 	}
 
+	cspApps := map[string]CSPApp{}
+	for slug, value := range v.GetStringMap("csp_apps") {
+		rules, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		app := CSPApp{Sources: map[string]string{}}
+		for key, rule := range rules {
+			switch key {
+			case "ignore_manifest":
+				app.IgnoreManifest, _ = rule.(bool)
+			case "report_only":
+				app.ReportOnly, _ = rule.(bool)
+			default:
+				if list, ok := rule.(string); ok {
+					app.Sources[key] = list
+				}
+			}
+		}
+		cspApps[slug] = app
+	}
+
 	cacheStorage := cache.New(cacheRedis)
 	avatars := avatar.NewService(cacheStorage, v.GetString("jobs.imagemagick_convert_cmd"))
 	pdfService := pdf.NewService(v.GetString("jobs.ghostscript_cmd"))
@@ -1099,6 +1282,16 @@ func UseViper(v *viper.Viper) error {
 		LocalName:                 v.GetString("mail.local_name"),
 	}
 
+	var mailTransport MailTransport
+	if err := v.UnmarshalKey("mail", &mailTransport); err != nil {
+		return fmt.Errorf("failed to decode the mail config: %w", err)
+	}
+
+	var inboundMail InboundMail
+	if err := v.UnmarshalKey("inbound_mail", &inboundMail); err != nil {
+		return fmt.Errorf("failed to decode the inbound_mail config: %w", err)
+	}
+
 	// Setup campaign mail SMTP server
 	var campaignMail *gomail.DialerOptions
 	if host := v.GetString("campaign_mail.host"); host != "" {
@@ -1144,6 +1337,7 @@ func UseViper(v *viper.Viper) error {
 		NoReplyName:           v.GetString("mail.noreply_name"),
 		ReplyTo:               v.GetString("mail.reply_to"),
 		GeoDB:                 v.GetString("geodb"),
+		GeocodingDB:           v.GetString("geocoding_db"),
 		PasswordResetInterval: v.GetDuration("password_reset_interval"),
 
 		RemoteAssets: v.GetStringMapString("remote_assets"),
@@ -1173,6 +1367,11 @@ func UseViper(v *viper.Viper) error {
 		Move: Move{
 			URL: v.GetString("move.url"),
 		},
+		Backup: Backup{
+			EncryptorKey: v.GetString("backup.encryptor_key"),
+			FullEvery:    v.GetInt("backup.full_every"),
+			Retention:    v.GetDuration("backup.retention"),
+		},
 		Notifications: Notifications{
 			Development: v.GetBool("notifications.development"),
 
@@ -1186,6 +1385,9 @@ func UseViper(v *viper.Viper) error {
 			HuaweiGetTokenURL:     v.GetString("notifications.huawei_get_token"),
 			HuaweiSendMessagesURL: v.GetString("notifications.huawei_send_message"),
 
+			VAPIDPrivateKey: v.GetString("notifications.vapid_private_key"),
+			VAPIDSubject:    v.GetString("notifications.vapid_subject"),
+
 			Contexts: makeSMS(v.GetStringMap("notifications.contexts")),
 		},
 		Flagship: Flagship{
@@ -1205,6 +1407,8 @@ func UseViper(v *viper.Viper) error {
 		CacheStorage:           cacheStorage,
 		Mail:                   mail,
 		MailPerContext:         v.GetStringMap("mail.contexts"),
+		MailTransport:          mailTransport,
+		InboundMail:            inboundMail,
 		CampaignMail:           campaignMail,
 		CampaignMailPerContext: v.GetStringMap("campaign_mail.contexts"),
 		Contexts:               v.GetStringMap("contexts"),
@@ -1215,6 +1419,7 @@ func UseViper(v *viper.Viper) error {
 
 		CSPAllowList:  cspAllowList,
 		CSPPerContext: cspPerContext,
+		CSPApps:       cspApps,
 
 		AssetsPollingDisabled: v.GetBool("assets_polling_disabled"),
 		AssetsPollingInterval: v.GetDuration("assets_polling_interval"),
@@ -1235,6 +1440,16 @@ func UseViper(v *viper.Viper) error {
 		return fmt.Errorf(`failed to parse the config for "rabbitmq": %w`, err)
 	}
 
+	err = v.UnmarshalKey("apps_signatures", &config.AppsSignatures)
+	if err != nil {
+		return fmt.Errorf(`failed to parse the config for "apps_signatures": %w`, err)
+	}
+	for _, keys := range config.AppsSignatures {
+		if _, err = keys.PublicKeys(); err != nil {
+			return fmt.Errorf(`invalid key in the config for "apps_signatures": %w`, err)
+		}
+	}
+
 	config.SafeHTTPTrustedNetworks = v.GetStringSlice("safe_http.trusted_private_networks")
 	if err = safehttp.SetTrustedPrivateNetworks(config.SafeHTTPTrustedNetworks); err != nil {
 		return fmt.Errorf("invalid safe_http.trusted_private_networks config: %w", err)
@@ -30,6 +30,7 @@ func TestConfigUnmarshal(t *testing.T) {
 	assert.Equal(t, cfg.NoReplyName, "My Twake")
 	assert.Equal(t, cfg.ReplyTo, "support@cozycloud.cc")
 	assert.Equal(t, cfg.GeoDB, "/geo/db/path")
+	assert.Equal(t, cfg.GeocodingDB, "/geo/cities500.txt")
 	assert.Equal(t, cfg.PasswordResetInterval, time.Hour)
 
 	// Assets
@@ -299,6 +300,19 @@ func TestConfigUnmarshal(t *testing.T) {
 		},
 	}, cfg.Clouderies)
 
+	// Apps signatures
+	assert.EqualValues(t, []AppsSignatureKeys{
+		{
+			Registry: "https://registry-url-1",
+			Keys:     []string{"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="},
+			Required: true,
+		},
+		{
+			Context: "example",
+			Keys:    []string{"ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8="},
+		},
+	}, cfg.AppsSignatures)
+
 	// CSPs
 	assert.Equal(t, true, cfg.CSPDisabled)
 	assert.EqualValues(t, map[string]string{
@@ -314,6 +328,16 @@ func TestConfigUnmarshal(t *testing.T) {
 			"connect": "https://connect-url",
 		},
 	}, cfg.CSPPerContext)
+	assert.EqualValues(t, map[string]CSPApp{
+		"maps": {
+			Sources:    map[string]string{"img": "https://tiles-url"},
+			ReportOnly: true,
+		},
+		"drive": {
+			Sources:        map[string]string{},
+			IgnoreManifest: true,
+		},
+	}, cfg.CSPApps)
 
 	// SafeHTTP
 	assert.Equal(t, []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}, cfg.SafeHTTPTrustedNetworks)
@@ -693,3 +717,42 @@ func TestSafeHTTPConfig(t *testing.T) {
 		assert.Empty(t, GetConfig().SafeHTTPTrustedNetworks)
 	})
 }
+
+func TestGetMailTransport(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+
+	config = &Config{
+		MailTransport: MailTransport{
+			Transport: "smtp",
+			DKIM: DKIM{
+				Domain:         "example.org",
+				Selector:       "cozy",
+				PrivateKeyPath: "/etc/cozy/dkim.pem",
+			},
+		},
+		MailPerContext: map[string]interface{}{
+			"beta": map[string]interface{}{
+				"host":          "smtp.beta.example",
+				"transport":     "sendmail",
+				"sendmail_path": "/usr/bin/sendmail",
+				"dkim": map[string]interface{}{
+					"domain": "beta.example",
+				},
+			},
+		},
+	}
+
+	cfg := GetMailTransport("beta")
+	assert.Equal(t, "sendmail", cfg.Transport)
+	assert.Equal(t, "/usr/bin/sendmail", cfg.SendmailPath)
+	assert.Equal(t, "beta.example", cfg.DKIM.Domain)
+	assert.Equal(t, "cozy", cfg.DKIM.Selector)
+
+	cfg = GetMailTransport("default")
+	assert.Equal(t, "smtp", cfg.Transport)
+	assert.Equal(t, "example.org", cfg.DKIM.Domain)
+
+	config = nil
+	assert.Empty(t, GetMailTransport("beta").Transport)
+}
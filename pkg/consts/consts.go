@@ -35,8 +35,14 @@ const (
 	ClientsUsageID = "io.cozy.settings.clients-usage"
 	// DiskUsageID is the id of the settings JSON-API response for disk-usage
 	DiskUsageID = "io.cozy.settings.disk-usage"
+	// InboundMailSettingsID is the id of the settings document for the
+	// mails received by the instance
+	InboundMailSettingsID = "io.cozy.settings.inbound-mail"
 	// InstanceSettingsID is the id of settings document for the instance
 	InstanceSettingsID = "io.cozy.settings.instance"
+	// NotificationsSettingsID is the id of the settings document with the
+	// notification preferences of the user
+	NotificationsSettingsID = "io.cozy.settings.notifications"
 	// CapabilitiesSettingsID is the id of the settings document with the
 	// capabilities for a given instance
 	CapabilitiesSettingsID = "io.cozy.settings.capabilities"
@@ -16,6 +16,12 @@ const (
 	AppsOpenParameters = "io.cozy.apps.open"
 	// AppLogs doc type for logs sent by apps and konnectors
 	AppLogs = "io.cozy.apps.logs"
+	// CSPReports doc type for the violations of the CSP policies of the
+	// webapps, reported by the browsers
+	CSPReports = "io.cozy.apps.csp_reports"
+	// AppsRollouts doc type for the staged rollouts of the updates of the
+	// applications (global database)
+	AppsRollouts = "io.cozy.apps.rollouts"
 	// Konnectors doc type for konnector application manifests
 	Konnectors = "io.cozy.konnectors"
 	// KonnectorsMaintenance doc type for maintenance of konnectors.
@@ -24,6 +30,9 @@ const (
 	Archives = "io.cozy.files.archives"
 	// Exports doc type for global exports archives
 	Exports = "io.cozy.exports"
+	// Backups doc type for the global documents of the snapshots of the
+	// incremental backups
+	Backups = "io.cozy.backups"
 	// ExportsRequests doc type for a request to move to another Cozy
 	ExportsRequests = "io.cozy.exports.requests"
 	// Imports doc type for global exports archives
@@ -52,16 +61,41 @@ const (
 	DirSizes = "io.cozy.files.sizes"
 	// PhotosAlbums doc type for photos albums
 	PhotosAlbums = "io.cozy.photos.albums"
+	// PhotosSettings doc type for the settings of the photos, like the
+	// clustering of the auto albums
+	PhotosSettings = "io.cozy.photos.settings"
+	// PhotosHashes doc type for the perceptual hashes of the photos, used to
+	// find the duplicates
+	PhotosHashes = "io.cozy.photos.hashes"
+	// PhotosDuplicates is a synthetic doctype, used for the groups of
+	// duplicate photos
+	PhotosDuplicates = "io.cozy.photos.duplicates"
 	// Intents doc type for intents persisted in couchdb
 	Intents = "io.cozy.intents"
 	// Jobs doc type for queued jobs
 	Jobs = "io.cozy.jobs"
 	// JobEvents doc type for real time events sent by jobs
 	JobEvents = "io.cozy.jobs.events"
+	// JobsDeadLetters doc type for the jobs that have failed permanently
+	JobsDeadLetters = "io.cozy.jobs.dead_letters"
 	// Support doc type for sending mail to the support
 	Support = "io.cozy.support"
+	// MailSuppressions doc type is used for the email addresses where the
+	// stack must not send mails, after a bounce or a complaint.
+	MailSuppressions = "io.cozy.mails.suppressions"
+	// InboundMails doc type is used for the mails received by the instance.
+	InboundMails = "io.cozy.mails.inbound"
 	// Notifications doc type for notifications
 	Notifications = "io.cozy.notifications"
+	// Webhooks doc type is used for the outgoing webhooks, where the changes
+	// of the documents are posted.
+	Webhooks = "io.cozy.webhooks"
+	// WebhooksDeliveries doc type is used for the logs of the deliveries of
+	// the outgoing webhooks.
+	WebhooksDeliveries = "io.cozy.webhooks.deliveries"
+	// WebPushSubscriptions doc type is used for the subscriptions of the
+	// browsers to the Web Push notifications.
+	WebPushSubscriptions = "io.cozy.notifications.webpush"
 	// OAuthAccessCodes doc type for OAuth2 access codes
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
 	// OAuthClients doc type for OAuth2 clients
@@ -72,6 +106,13 @@ const (
 	Contacts = "io.cozy.contacts"
 	// Groups of contacts doc type for sharing
 	Groups = "io.cozy.contacts.groups"
+	// Calendars doc type is used for the calendars, that contain the events
+	// and the tasks.
+	Calendars = "io.cozy.calendar.calendars"
+	// CalendarEvents doc type is used for the events of the calendars.
+	CalendarEvents = "io.cozy.calendar.events"
+	// CalendarTodos doc type is used for the tasks of the calendars.
+	CalendarTodos = "io.cozy.calendar.todos"
 	// RemoteRequests doc type for logging requests to remote websites
 	RemoteRequests = "io.cozy.remote.requests"
 	// RemoteSecrets doc type for secrets used by remote doctypes
@@ -131,6 +172,12 @@ const (
 	NotesURL = "io.cozy.notes.url"
 	// NotesImages doc type used for images used by a note
 	NotesImages = "io.cozy.notes.images"
+	// NotesComments doc type is used for the comments and discussions on a
+	// note.
+	NotesComments = "io.cozy.notes.comments"
+	// NotesSnapshots doc type is used for the saved states of a note that can
+	// be restored.
+	NotesSnapshots = "io.cozy.notes.snapshots"
 	// OfficeURL doc type is used to return the URL where an office document can be edited.
 	OfficeURL = "io.cozy.office.url"
 	// AuthConfirmations doc type used for realtime events when confirming
@@ -145,6 +192,15 @@ const (
 	// NextcloudMigrations doc type is used to track bulk Nextcloud to Cozy
 	// migrations orchestrated by the external migration service.
 	NextcloudMigrations = "io.cozy.nextcloud.migrations"
+	// NextcloudSyncs doc type is used for the folders kept in sync with a
+	// folder of a Nextcloud account.
+	NextcloudSyncs = "io.cozy.nextcloud.syncs"
+	// NextcloudSyncReports doc type is used for the reports of the runs of the
+	// synchronisation with Nextcloud.
+	NextcloudSyncReports = "io.cozy.nextcloud.syncs.reports"
+	// TakeoutMigrations doc type is used to track the imports of the archives
+	// exported from other clouds, like Google Takeout.
+	TakeoutMigrations = "io.cozy.takeout.migrations"
 	// ChatAssistants doc type for AI chat assistants.
 	ChatAssistants = "io.cozy.ai.chat.assistants"
 	// ChatConversations doc type is used for a chat between the user and a chatbot.
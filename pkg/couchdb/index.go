@@ -14,7 +14,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 38
+const IndexViewsVersion int = 43
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -60,6 +60,20 @@ var Indexes = []*mango.Index{
 	// date
 	mango.MakeIndex(consts.Notifications, "by-source-id", mango.IndexDef{Fields: []string{"source_id", "created_at"}}),
 
+	// Used to lookup the events and the tasks of a calendar, for the time
+	// ranges, the imports and the CalDAV resources
+	mango.MakeIndex(consts.CalendarEvents, "by-calendar-and-start", mango.IndexDef{Fields: []string{"calendar_id", "start"}}),
+	mango.MakeIndex(consts.CalendarEvents, "by-calendar-and-uid", mango.IndexDef{Fields: []string{"calendar_id", "uid"}}),
+	mango.MakeIndex(consts.CalendarEvents, "by-calendar-and-filename", mango.IndexDef{Fields: []string{"calendar_id", "filename"}}),
+	mango.MakeIndex(consts.CalendarTodos, "by-calendar-and-uid", mango.IndexDef{Fields: []string{"calendar_id", "uid"}}),
+	mango.MakeIndex(consts.CalendarTodos, "by-calendar-and-filename", mango.IndexDef{Fields: []string{"calendar_id", "filename"}}),
+
+	// Used to lookup the deliveries of an outgoing webhook
+	mango.MakeIndex(consts.WebhooksDeliveries, "by-webhook-id", mango.IndexDef{Fields: []string{"webhook_id", "created_at"}}),
+
+	// Used to lookup the notifications for the digest
+	mango.MakeIndex(consts.Notifications, "by-digest", mango.IndexDef{Fields: []string{"digest", "created_at"}}),
+
 	// Used to find the myself document
 	mango.MakeIndex(consts.Contacts, "by-me", mango.IndexDef{Fields: []string{"me"}}),
 
@@ -76,6 +90,12 @@ var Indexes = []*mango.Index{
 	// Used to detect an already in-flight Nextcloud migration when a user
 	// tries to start a new one.
 	mango.MakeIndex(consts.NextcloudMigrations, "by-status", mango.IndexDef{Fields: []string{"status"}}),
+
+	// Used to lookup the reports of a synchronisation with Nextcloud
+	mango.MakeIndex(consts.NextcloudSyncReports, "by-sync-id", mango.IndexDef{Fields: []string{"sync_id", "started_at"}}),
+
+	// Used to detect an already in-flight import of a Google Takeout archive.
+	mango.MakeIndex(consts.TakeoutMigrations, "by-status", mango.IndexDef{Fields: []string{"status"}}),
 }
 
 // DiskUsageView is the view used for computing the disk usage for files
@@ -321,6 +341,7 @@ func IndexesByDoctype(doctype string) []*mango.Index {
 // properly.
 var globalIndexes = []*mango.Index{
 	mango.MakeIndex(consts.Exports, "by-domain", mango.IndexDef{Fields: []string{"domain", "created_at"}}),
+	mango.MakeIndex(consts.Backups, "by-domain", mango.IndexDef{Fields: []string{"domain", "created_at"}}),
 	mango.MakeIndex(consts.Instances, "by-oidcid", mango.IndexDef{Fields: []string{"oidc_id"}}),
 	mango.MakeIndex(consts.Instances, "by-olddomain", mango.IndexDef{Fields: []string{"old_domain"}}),
 	mango.MakeIndex(consts.Instances, "by-orgdomain", mango.IndexDef{Fields: []string{"org_domain"}}),
@@ -22,6 +22,10 @@ func ByExtension(ext string) string {
 		return consts.NoteMimeType
 	case ".url":
 		return consts.ShortcutMimeType
+	case ".gpx":
+		return "application/gpx+xml"
+	case ".kml":
+		return "application/vnd.google-earth.kml+xml"
 	}
 	mimeParts := strings.SplitN(mime.TypeByExtension(ext), ";", 2)
 	return strings.TrimSpace(mimeParts[0])
@@ -0,0 +1,154 @@
+// Package geocoding finds the nearest city for some GPS coordinates, with an
+// offline dataset of cities from GeoNames (https://www.geonames.org/), like
+// cities500.txt or cities15000.txt.
+package geocoding
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxDistance is the maximal distance, in meters, between some coordinates and
+// a city for this city to be used as their place.
+const MaxDistance = 30000
+
+const earthRadius = 6371000 // in meters
+
+// ErrInvalidDataset is used when the dataset is not in the GeoNames format.
+var ErrInvalidDataset = errors.New("geocoding: invalid dataset")
+
+// Place is the result of a reverse geocoding.
+type Place struct {
+	City    string `json:"city"`
+	Country string `json:"country"` // ISO-3166 code
+}
+
+type city struct {
+	name    string
+	country string
+	lat     float64
+	long    float64
+}
+
+// The cities are indexed in a grid of cells of one degree.
+type cell struct {
+	lat  int
+	long int
+}
+
+func cellOf(lat, long float64) cell {
+	return cell{int(math.Floor(lat)), int(math.Floor(long))}
+}
+
+// Dataset is a list of cities, indexed for the lookups.
+type Dataset struct {
+	cities []city
+	grid   map[cell][]int
+}
+
+// Open loads the dataset from a GeoNames file.
+func Open(filename string) (*Dataset, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads a dataset in the GeoNames format: one city per line, with
+// tab-separated fields (id, name, ascii name, alternate names, latitude,
+// longitude, feature class, feature code, country code, etc.).
+func Load(r io.Reader) (*Dataset, error) {
+	d := &Dataset{grid: make(map[cell][]int)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 9 {
+			return nil, ErrInvalidDataset
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, ErrInvalidDataset
+		}
+		long, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, ErrInvalidDataset
+		}
+		c := cellOf(lat, long)
+		d.grid[c] = append(d.grid[c], len(d.cities))
+		d.cities = append(d.cities, city{
+			name:    fields[1],
+			country: fields[8],
+			lat:     lat,
+			long:    long,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Len returns the number of cities in the dataset.
+func (d *Dataset) Len() int {
+	return len(d.cities)
+}
+
+// Lookup returns the nearest city for the given coordinates, or nil if there
+// is no city closer than MaxDistance.
+func (d *Dataset) Lookup(lat, long float64) *Place {
+	if math.IsNaN(lat) || math.IsNaN(long) || math.Abs(lat) > 90 || math.Abs(long) > 180 {
+		return nil
+	}
+
+	// The number of cells to look at around the coordinates depends on the
+	// latitude, as the meridians get closer near the poles.
+	degree := earthRadius * math.Pi / 180
+	dLat := int(math.Ceil(MaxDistance / degree))
+	dLong := 180
+	if cos := math.Cos((math.Abs(lat) + float64(dLat)) * math.Pi / 180); cos > 0 {
+		dLong = min(int(math.Ceil(MaxDistance/(degree*cos))), 180)
+	}
+
+	center := cellOf(lat, long)
+	best, bestDistance := -1, float64(MaxDistance)
+	for i := center.lat - dLat; i <= center.lat+dLat; i++ {
+		for j := center.long - dLong; j <= center.long+dLong; j++ {
+			// Wrap around the antimeridian
+			k := (j+180+360)%360 - 180
+			for _, idx := range d.grid[cell{i, k}] {
+				c := d.cities[idx]
+				if dist := Distance(lat, long, c.lat, c.long); dist <= bestDistance {
+					best, bestDistance = idx, dist
+				}
+			}
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return &Place{City: d.cities[best].name, Country: d.cities[best].country}
+}
+
+// Distance returns the distance in meters between two points, with the
+// haversine formula.
+func Distance(lat1, long1, lat2, long2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (long2 - long1) * math.Pi / 180
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
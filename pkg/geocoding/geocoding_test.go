@@ -0,0 +1,37 @@
+package geocoding
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cities = "2988507\tParis\tParis\t\t48.85341\t2.3488\tP\tPPLC\tFR\t\t11\t75\t751\t75056\t2138551\t\t42\tEurope/Paris\t2024-01-01\n" +
+	"2996944\tLyon\tLyon\t\t45.74846\t4.84671\tP\tPPLA\tFR\t\t84\t69\t691\t69123\t522969\t\t170\tEurope/Paris\t2024-01-01\n" +
+	"2203055\tRabi\tRabi\t\t-16.45\t179.98\tP\tPPL\tFJ\t\t03\t\t\t\t5000\t\t6\tPacific/Fiji\t2024-01-01\n"
+
+func TestLookup(t *testing.T) {
+	d, err := Load(strings.NewReader(cities))
+	require.NoError(t, err)
+	assert.Equal(t, 3, d.Len())
+
+	// Eiffel tower
+	assert.Equal(t, &Place{City: "Paris", Country: "FR"}, d.Lookup(48.8584, 2.2945))
+	// Near Villeurbanne, across a cell border
+	assert.Equal(t, &Place{City: "Lyon", Country: "FR"}, d.Lookup(45.77, 4.88))
+	// Across the antimeridian
+	assert.Equal(t, &Place{City: "Rabi", Country: "FJ"}, d.Lookup(-16.45, -179.98))
+	// Too far from any city
+	assert.Nil(t, d.Lookup(47.0, 3.0))
+	assert.Nil(t, d.Lookup(91, 0))
+
+	_, err = Load(strings.NewReader("not\ta\tdataset\n"))
+	assert.Equal(t, ErrInvalidDataset, err)
+}
+
+func TestDistance(t *testing.T) {
+	assert.InDelta(t, 393000, Distance(48.85341, 2.3488, 45.74846, 4.84671), 1000)
+	assert.Equal(t, 0.0, Distance(10, 20, 10, 20))
+}
@@ -0,0 +1,372 @@
+// Package ical is a parser and an encoder for the iCalendar format (RFC 5545),
+// with the expansion of the recurrence rules.
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximal length in octets of a line, before folding.
+const maxLineLength = 75
+
+// ErrInvalidFormat is used when the data is not a valid iCalendar stream.
+var ErrInvalidFormat = errors.New("ical: invalid format")
+
+// Params are the parameters of a property, indexed by their upper-cased name.
+type Params map[string][]string
+
+// Get returns the first value of a parameter.
+func (p Params) Get(name string) string {
+	if values := p[strings.ToUpper(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set replaces the values of a parameter.
+func (p Params) Set(name string, values ...string) {
+	p[strings.ToUpper(name)] = values
+}
+
+// Property is a content line of a component. The value is kept as it is in
+// the stream (without the folding), and the helpers can be used to decode it.
+type Property struct {
+	Name   string
+	Params Params
+	Value  string
+}
+
+// NewProperty returns a property with the given raw value.
+func NewProperty(name, value string) *Property {
+	return &Property{Name: strings.ToUpper(name), Params: Params{}, Value: value}
+}
+
+// Param returns the first value of a parameter of the property.
+func (p *Property) Param(name string) string {
+	return p.Params.Get(name)
+}
+
+// Text returns the value of a property of type TEXT, unescaped.
+func (p *Property) Text() string {
+	return UnescapeText(p.Value)
+}
+
+// Texts returns the values of a property with a list of TEXT (like
+// CATEGORIES), unescaped.
+func (p *Property) Texts() []string {
+	var values []string
+	for _, v := range splitUnescaped(p.Value, ',') {
+		if v != "" {
+			values = append(values, UnescapeText(v))
+		}
+	}
+	return values
+}
+
+// Fields returns the components of a structured value (like N or ADR in a
+// vCard), unescaped.
+func (p *Property) Fields() []string {
+	fields := splitUnescaped(p.Value, ';')
+	for i, f := range fields {
+		fields[i] = UnescapeText(f)
+	}
+	return fields
+}
+
+// Component is a component (VCALENDAR, VEVENT, etc.) with its properties and
+// its sub-components, in the order of the stream.
+type Component struct {
+	Name       string
+	Props      []*Property
+	Components []*Component
+}
+
+// NewComponent returns an empty component.
+func NewComponent(name string) *Component {
+	return &Component{Name: strings.ToUpper(name)}
+}
+
+// Get returns the first property with the given name, or nil.
+func (c *Component) Get(name string) *Property {
+	name = strings.ToUpper(name)
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetAll returns all the properties with the given name.
+func (c *Component) GetAll(name string) []*Property {
+	name = strings.ToUpper(name)
+	var props []*Property
+	for _, p := range c.Props {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Text returns the unescaped value of the first property with the given name,
+// or an empty string.
+func (c *Component) Text(name string) string {
+	if p := c.Get(name); p != nil {
+		return p.Text()
+	}
+	return ""
+}
+
+// Add appends a property to the component.
+func (c *Component) Add(p *Property) {
+	c.Props = append(c.Props, p)
+}
+
+// AddText appends a property of type TEXT, if the text is not empty.
+func (c *Component) AddText(name, text string) {
+	if text != "" {
+		c.Add(NewProperty(name, EscapeText(text)))
+	}
+}
+
+// Del removes all the properties with the given name.
+func (c *Component) Del(name string) {
+	name = strings.ToUpper(name)
+	props := c.Props[:0]
+	for _, p := range c.Props {
+		if p.Name != name {
+			props = append(props, p)
+		}
+	}
+	c.Props = props
+}
+
+// Children returns the sub-components with the given name.
+func (c *Component) Children(name string) []*Component {
+	name = strings.ToUpper(name)
+	var children []*Component
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// AddComponent appends a sub-component.
+func (c *Component) AddComponent(child *Component) {
+	c.Components = append(c.Components, child)
+}
+
+// Parse reads an iCalendar stream and returns its top-level component
+// (usually a VCALENDAR).
+func Parse(r io.Reader) (*Component, error) {
+	roots, err := ParseAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) != 1 {
+		return nil, ErrInvalidFormat
+	}
+	return roots[0], nil
+}
+
+// ParseAll reads a stream with several top-level components, like the vCard
+// files (RFC 6350) that use the same format for their content lines.
+func ParseAll(r io.Reader) ([]*Component, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var stack []*Component
+	var roots []*Component
+	var current strings.Builder
+	flush := func() error {
+		line := current.String()
+		current.Reset()
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return err
+		}
+		switch prop.Name {
+		case "BEGIN":
+			comp := NewComponent(prop.Value)
+			if len(stack) > 0 {
+				stack[len(stack)-1].AddComponent(comp)
+			} else {
+				roots = append(roots, comp)
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return ErrInvalidFormat
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return ErrInvalidFormat
+			}
+			stack[len(stack)-1].Add(prop)
+		}
+		return nil
+	}
+
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			current.WriteString(line[1:])
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		current.WriteString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 || len(stack) > 0 {
+		return nil, ErrInvalidFormat
+	}
+	return roots, nil
+}
+
+// parseLine parses an unfolded content line: name *(";" param) ":" value
+func parseLine(line string) (*Property, error) {
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, ErrInvalidFormat
+	}
+	prop := NewProperty(line[:i], "")
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, ErrInvalidFormat
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var values []string
+		for {
+			var value string
+			if strings.HasPrefix(line, `"`) {
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					return nil, ErrInvalidFormat
+				}
+				value = line[1 : end+1]
+				line = line[end+2:]
+			} else {
+				end := strings.IndexAny(line, ",;:")
+				if end < 0 {
+					return nil, ErrInvalidFormat
+				}
+				value = line[:end]
+				line = line[end:]
+			}
+			values = append(values, value)
+			if !strings.HasPrefix(line, ",") {
+				break
+			}
+			line = line[1:]
+		}
+		prop.Params[name] = append(prop.Params[name], values...)
+		if line == "" {
+			return nil, ErrInvalidFormat
+		}
+		i = 0
+	}
+	if line[i] != ':' {
+		return nil, ErrInvalidFormat
+	}
+	prop.Value = line[i+1:]
+	return prop, nil
+}
+
+// Encode writes the component as an iCalendar stream, with the lines folded
+// at 75 octets.
+func Encode(w io.Writer, c *Component) error {
+	var buf bytes.Buffer
+	encodeComponent(&buf, c)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeComponent(buf *bytes.Buffer, c *Component) {
+	writeLine(buf, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		writeLine(buf, encodeProperty(p))
+	}
+	for _, child := range c.Components {
+		encodeComponent(buf, child)
+	}
+	writeLine(buf, "END:"+c.Name)
+}
+
+func encodeProperty(p *Property) string {
+	var sb strings.Builder
+	sb.WriteString(p.Name)
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := p.Params[name]
+		if len(values) == 0 {
+			continue
+		}
+		sb.WriteString(";")
+		sb.WriteString(name)
+		sb.WriteString("=")
+		for i, v := range values {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			v = strings.ReplaceAll(v, `"`, "'")
+			if strings.ContainsAny(v, ";:,") {
+				v = `"` + v + `"`
+			}
+			sb.WriteString(v)
+		}
+	}
+	sb.WriteString(":")
+	sb.WriteString(p.Value)
+	return sb.String()
+}
+
+// writeLine writes a content line, folded without splitting the UTF-8
+// characters.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		// The space at the beginning of the continuation line counts
+		limit = maxLineLength - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
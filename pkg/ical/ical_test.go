@@ -0,0 +1,182 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:event-1@example.org\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261019T100000\r\n" +
+	"DTEND;TZID=Europe/Paris:20261019T113000\r\n" +
+	"SUMMARY:Weekly meeting\\, with the team\r\n" +
+	"DESCRIPTION:First line\\nSecond line that is long enough to be folded by t\r\n" +
+	" he encoder\r\n" +
+	"ATTENDEE;CN=\"Doe, Jane\";PARTSTAT=ACCEPTED:mailto:jane@example.org\r\n" +
+	"CATEGORIES:work,team\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=10\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(sample))
+	require.NoError(t, err)
+	assert.Equal(t, "VCALENDAR", cal.Name)
+	assert.Equal(t, "2.0", cal.Text("VERSION"))
+
+	events := cal.Children("VEVENT")
+	require.Len(t, events, 1)
+	event := events[0]
+	assert.Equal(t, "Weekly meeting, with the team", event.Text("SUMMARY"))
+	assert.Equal(t, "First line\nSecond line that is long enough to be folded by the encoder",
+		event.Text("DESCRIPTION"))
+	assert.Equal(t, []string{"work", "team"}, event.Get("CATEGORIES").Texts())
+
+	attendee := event.Get("ATTENDEE")
+	require.NotNil(t, attendee)
+	assert.Equal(t, "Doe, Jane", attendee.Param("cn"))
+	assert.Equal(t, "mailto:jane@example.org", attendee.Value)
+
+	start, allDay, err := event.Get("DTSTART").DateTime(nil)
+	require.NoError(t, err)
+	assert.False(t, allDay)
+	assert.Equal(t, "Europe/Paris", start.Location().String())
+	assert.Equal(t, time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC), start.UTC())
+
+	alarms := event.Children("VALARM")
+	require.Len(t, alarms, 1)
+	d, err := ParseDuration(alarms[0].Text("TRIGGER"))
+	require.NoError(t, err)
+	assert.Equal(t, -15*time.Minute, d)
+
+	_, err = Parse(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.Equal(t, ErrInvalidFormat, err)
+	_, err = Parse(strings.NewReader("SUMMARY:no component\r\n"))
+	assert.Equal(t, ErrInvalidFormat, err)
+}
+
+func TestParseAll(t *testing.T) {
+	cards, err := ParseAll(strings.NewReader("BEGIN:VCARD\r\nVERSION:3.0\r\n" +
+		"N:Doe;Jane;;Dr.;\r\nFN:Jane Doe\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:John\r\n" +
+		"ADR;TYPE=HOME:;;1 Main St\\, Apt 2;Paris;;75001;France\r\nEND:VCARD\r\n"))
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+	assert.Equal(t, "Jane Doe", cards[0].Text("FN"))
+	assert.Equal(t, []string{"Doe", "Jane", "", "Dr.", ""}, cards[0].Get("N").Fields())
+	adr := cards[1].Get("ADR")
+	assert.Equal(t, "HOME", adr.Param("type"))
+	assert.Equal(t, []string{"", "", "1 Main St, Apt 2", "Paris", "", "75001", "France"}, adr.Fields())
+
+	_, err = Parse(strings.NewReader("BEGIN:VCARD\r\nEND:VCARD\r\nBEGIN:VCARD\r\nEND:VCARD\r\n"))
+	assert.Equal(t, ErrInvalidFormat, err)
+}
+
+func TestEncode(t *testing.T) {
+	cal, err := Parse(strings.NewReader(sample))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, cal))
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineLength)
+	}
+
+	again, err := Parse(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, cal, again)
+
+	// The folding must not split the multi-bytes characters
+	event := NewComponent("VEVENT")
+	event.AddText("SUMMARY", strings.Repeat("é", 100))
+	buf.Reset()
+	require.NoError(t, Encode(&buf, event))
+	parsed, err := Parse(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("é", 100), parsed.Text("SUMMARY"))
+}
+
+func TestDateTimeProperties(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	prop := NewDateTimeProperty("DTSTART", time.Date(2026, 10, 19, 10, 0, 0, 0, paris), false)
+	assert.Equal(t, "Europe/Paris", prop.Param("TZID"))
+	assert.Equal(t, "20261019T100000", prop.Value)
+
+	prop = NewDateTimeProperty("DTSTART", time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC), false)
+	assert.Equal(t, "20261019T080000Z", prop.Value)
+
+	prop = NewDateTimeProperty("DTSTART", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), true)
+	assert.Equal(t, "DATE", prop.Param("VALUE"))
+	assert.Equal(t, "20261019", prop.Value)
+	day, allDay, err := prop.DateTime(paris)
+	require.NoError(t, err)
+	assert.True(t, allDay)
+	assert.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), day)
+
+	exdate := &Property{Name: "EXDATE", Params: Params{"TZID": {"/mozilla.org/20050126_1/Europe/Paris"}},
+		Value: "20261026T100000,20261102T100000"}
+	times, _, err := exdate.DateTimes(nil)
+	require.NoError(t, err)
+	require.Len(t, times, 2)
+	assert.Equal(t, time.Date(2026, 11, 2, 10, 0, 0, 0, paris), times[1])
+}
+
+func TestDuration(t *testing.T) {
+	for s, d := range map[string]time.Duration{
+		"PT15M":      15 * time.Minute,
+		"-PT1H30M":   -90 * time.Minute,
+		"P1W":        7 * 24 * time.Hour,
+		"P1DT2H":     26 * time.Hour,
+		"+PT0S":      0,
+		"P2DT3H4M5S": 2*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second,
+	} {
+		parsed, err := ParseDuration(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, d, parsed, s)
+		again, err := ParseDuration(FormatDuration(d))
+		require.NoError(t, err)
+		assert.Equal(t, d, again)
+	}
+	for _, s := range []string{"", "P", "PT", "15M", "P1H", "PT1D", "P1DT"} {
+		_, err := ParseDuration(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	tz := VTimezone(paris, 2026)
+	assert.Equal(t, "Europe/Paris", tz.Text("TZID"))
+
+	daylight := tz.Children("DAYLIGHT")
+	require.Len(t, daylight, 1)
+	assert.Equal(t, "20260329T020000", daylight[0].Text("DTSTART"))
+	assert.Equal(t, "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", daylight[0].Text("RRULE"))
+	assert.Equal(t, "+0100", daylight[0].Text("TZOFFSETFROM"))
+	assert.Equal(t, "+0200", daylight[0].Text("TZOFFSETTO"))
+
+	standard := tz.Children("STANDARD")
+	require.Len(t, standard, 1)
+	assert.Equal(t, "20261025T030000", standard[0].Text("DTSTART"))
+	assert.Equal(t, "+0200", standard[0].Text("TZOFFSETFROM"))
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	tz = VTimezone(tokyo, 2026)
+	require.Len(t, tz.Children("STANDARD"), 1)
+	assert.Equal(t, "+0900", tz.Children("STANDARD")[0].Text("TZOFFSETTO"))
+}
@@ -0,0 +1,444 @@
+package ical
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEmptyPeriods is the number of consecutive periods without any
+// occurrence after which the expansion of a rule is stopped (for rules like
+// FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30 that never match).
+const maxEmptyPeriods = 1000
+
+// ErrUnsupportedRRule is used for the recurrence rules with parts that are
+// not supported, like BYHOUR or FREQ=MINUTELY.
+var ErrUnsupportedRRule = errors.New("ical: unsupported recurrence rule")
+
+// Frequency is the FREQ part of a recurrence rule.
+type Frequency string
+
+const (
+	// Daily is for the events that repeat each day.
+	Daily Frequency = "DAILY"
+	// Weekly is for the events that repeat each week.
+	Weekly Frequency = "WEEKLY"
+	// Monthly is for the events that repeat each month.
+	Monthly Frequency = "MONTHLY"
+	// Yearly is for the events that repeat each year.
+	Yearly Frequency = "YEARLY"
+)
+
+// WeekdayNum is a day of the BYDAY part, like 2MO (the second monday) or
+// -1FR (the last friday). N is 0 for all the days of the period.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// RRule is a recurrence rule. The frequencies from DAILY to YEARLY are
+// supported, with the INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH,
+// BYSETPOS and WKST parts.
+type RRule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+// ParseRRule parses the value of a RRULE property. The location is used for
+// an UNTIL with a floating time or a date, that is inclusive.
+func ParseRRule(value string, loc *time.Location) (*RRule, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	r := &RRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, ErrInvalidFormat
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(val))
+			switch r.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return nil, ErrUnsupportedRRule
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+			if err == nil && r.Interval < 1 {
+				err = ErrInvalidFormat
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+			if err == nil && r.Count < 1 {
+				err = ErrInvalidFormat
+			}
+		case "UNTIL":
+			r.Until, err = parseUntil(val, loc)
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				d = strings.ToUpper(strings.TrimSpace(d))
+				if len(d) < 2 {
+					return nil, ErrInvalidFormat
+				}
+				day, ok := weekdays[d[len(d)-2:]]
+				if !ok {
+					return nil, ErrInvalidFormat
+				}
+				wd := WeekdayNum{Day: day}
+				if n := d[:len(d)-2]; n != "" {
+					wd.N, err = strconv.Atoi(n)
+					if err != nil || wd.N == 0 {
+						return nil, ErrInvalidFormat
+					}
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(val, 31)
+		case "BYMONTH":
+			r.ByMonth, err = parseInts(val, 12)
+			for _, m := range r.ByMonth {
+				if m < 1 {
+					err = ErrInvalidFormat
+				}
+			}
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(val, 366)
+		case "WKST":
+			day, ok := weekdays[strings.ToUpper(val)]
+			if !ok {
+				return nil, ErrInvalidFormat
+			}
+			r.WeekStart = day
+		default:
+			return nil, ErrUnsupportedRRule
+		}
+		if err != nil {
+			return nil, ErrInvalidFormat
+		}
+	}
+	if r.Freq == "" {
+		return nil, ErrInvalidFormat
+	}
+	return r, nil
+}
+
+func parseUntil(val string, loc *time.Location) (time.Time, error) {
+	switch {
+	case len(val) == len(dateLayout):
+		t, err := time.ParseInLocation(dateLayout, val, loc)
+		if err != nil {
+			return t, err
+		}
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	case strings.HasSuffix(val, "Z"):
+		return time.ParseInLocation(utcDateTimeLayout, val, time.UTC)
+	default:
+		return time.ParseInLocation(dateTimeLayout, val, loc)
+	}
+}
+
+func parseInts(val string, max int) ([]int, error) {
+	var ints []int
+	for _, s := range strings.Split(val, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n == 0 || n > max || n < -max {
+			return nil, ErrInvalidFormat
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// IsInfinite returns true if the rule has no COUNT and no UNTIL.
+func (r *RRule) IsInfinite() bool {
+	return r.Count == 0 && r.Until.IsZero()
+}
+
+// Between returns the start times of the occurrences of a recurrence that
+// begins at dtstart, for the occurrences starting before the to parameter and
+// not before the from parameter. The dtstart is always the first occurrence.
+// At most limit occurrences are returned.
+func (r *RRule) Between(dtstart, from, to time.Time, limit int) []time.Time {
+	var times []time.Time
+	r.iterate(dtstart, func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			times = append(times, t)
+		}
+		return len(times) < limit
+	})
+	return times
+}
+
+// Last returns the start of the last occurrence of a finite recurrence. It
+// returns false if the recurrence is infinite.
+func (r *RRule) Last(dtstart time.Time) (time.Time, bool) {
+	if r.IsInfinite() {
+		return time.Time{}, false
+	}
+	last := dtstart
+	r.iterate(dtstart, func(t time.Time) bool {
+		last = t
+		return true
+	})
+	return last, true
+}
+
+// iterate calls fn for each occurrence in chronological order, until fn
+// returns false or the end of the recurrence.
+func (r *RRule) iterate(dtstart time.Time, fn func(time.Time) bool) {
+	count := 0
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		count++
+		if !fn(t) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+	if !emit(dtstart) {
+		return
+	}
+
+	empty := 0
+	for k := 0; empty < maxEmptyPeriods; k++ {
+		candidates := r.period(dtstart, k)
+		if len(candidates) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, t := range candidates {
+			if !t.After(dtstart) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// period returns the sorted occurrences of the k-th period of the rule.
+func (r *RRule) period(dtstart time.Time, k int) []time.Time {
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hh, mm, ss, 0, loc)
+	}
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		day := at(y, m, d+k*r.Interval)
+		if r.matchMonth(day.Month()) && r.matchMonthDay(day) && r.matchWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+		return days
+	case Weekly:
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(y, m, d-offset+7*k*r.Interval)
+		wanted := []time.Weekday{dtstart.Weekday()}
+		if len(r.ByDay) > 0 {
+			wanted = wanted[:0]
+			for _, wd := range r.ByDay {
+				wanted = append(wanted, wd.Day)
+			}
+		}
+		for _, wd := range wanted {
+			shift := (int(wd) - int(r.WeekStart) + 7) % 7
+			day := weekStart.AddDate(0, 0, shift)
+			if r.matchMonth(day.Month()) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		idx := int(m) - 1 + k*r.Interval
+		year, month := y+idx/12, time.Month(idx%12+1)
+		if r.matchMonth(month) {
+			days = r.monthDays(year, month, d, at)
+		}
+	case Yearly:
+		year := y + k*r.Interval
+		switch {
+		case len(r.ByMonth) > 0:
+			for _, month := range r.ByMonth {
+				days = append(days, r.monthDays(year, time.Month(month), d, at)...)
+			}
+		case len(r.ByMonthDay) > 0:
+			for month := time.January; month <= time.December; month++ {
+				days = append(days, r.monthDays(year, month, d, at)...)
+			}
+		case len(r.ByDay) > 0:
+			days = r.yearWeekdays(year, at)
+		default:
+			if d <= daysIn(year, m) {
+				days = append(days, at(year, m, d))
+			}
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	days = dedup(days)
+	return r.applySetPos(days)
+}
+
+// monthDays returns the days of a month that match the BYMONTHDAY and BYDAY
+// parts, or the day of dtstart if there are no such parts.
+func (r *RRule) monthDays(year int, month time.Month, dtstartDay int, at func(int, time.Month, int) time.Time) []time.Time {
+	n := daysIn(year, month)
+	var days []time.Time
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if dtstartDay <= n {
+			days = append(days, at(year, month, dtstartDay))
+		}
+		return days
+	}
+	for day := 1; day <= n; day++ {
+		t := at(year, month, day)
+		if len(r.ByMonthDay) > 0 && !r.matchMonthDay(t) {
+			continue
+		}
+		if len(r.ByDay) > 0 && !matchNthWeekday(r.ByDay, t.Weekday(), day, n) {
+			continue
+		}
+		days = append(days, t)
+	}
+	return days
+}
+
+// yearWeekdays returns the days of the year that match BYDAY, where the
+// ordinals are relative to the year.
+func (r *RRule) yearWeekdays(year int, at func(int, time.Month, int) time.Time) []time.Time {
+	n := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	var days []time.Time
+	for yd := 1; yd <= n; yd++ {
+		t := at(year, time.January, yd)
+		if matchNthWeekday(r.ByDay, t.Weekday(), yd, n) {
+			days = append(days, t)
+		}
+	}
+	return days
+}
+
+// matchNthWeekday returns true if the day, at the position pos in a period
+// of n days, matches one of the weekdays.
+func matchNthWeekday(byDay []WeekdayNum, wd time.Weekday, pos, n int) bool {
+	for _, d := range byDay {
+		if d.Day != wd {
+			continue
+		}
+		switch {
+		case d.N == 0:
+			return true
+		case d.N > 0 && (pos-1)/7+1 == d.N:
+			return true
+		case d.N < 0 && (n-pos)/7+1 == -d.N:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchMonth(m time.Month) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range r.ByMonth {
+		if time.Month(month) == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	n := daysIn(t.Year(), t.Month())
+	for _, md := range r.ByMonthDay {
+		if md == t.Day() || (md < 0 && n+1+md == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchWeekday(wd time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d.Day == wd {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) applySetPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 || len(days) == 0 {
+		return days
+	}
+	var selected []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(days) + pos
+		}
+		if i >= 0 && i < len(days) {
+			selected = append(selected, days[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return dedup(selected)
+}
+
+func dedup(days []time.Time) []time.Time {
+	if len(days) < 2 {
+		return days
+	}
+	out := days[:1]
+	for _, t := range days[1:] {
+		if !t.Equal(out[len(out)-1]) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,126 @@
+package ical
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dates(times []time.Time) []string {
+	out := make([]string, len(times))
+	for i, t := range times {
+		out[i] = t.Format("2006-01-02 15:04")
+	}
+	return out
+}
+
+func expand(t *testing.T, rule string, dtstart time.Time, limit int) []string {
+	r, err := ParseRRule(rule, dtstart.Location())
+	require.NoError(t, err, rule)
+	far := dtstart.AddDate(10, 0, 0)
+	return dates(r.Between(dtstart, dtstart, far, limit))
+}
+
+func TestParseRRule(t *testing.T) {
+	r, err := ParseRRule("FREQ=MONTHLY;INTERVAL=2;BYDAY=2TU,-1FR;UNTIL=20270101T000000Z;WKST=SU", nil)
+	require.NoError(t, err)
+	assert.Equal(t, Monthly, r.Freq)
+	assert.Equal(t, 2, r.Interval)
+	assert.Equal(t, []WeekdayNum{{2, time.Tuesday}, {-1, time.Friday}}, r.ByDay)
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), r.Until)
+	assert.Equal(t, time.Sunday, r.WeekStart)
+	assert.False(t, r.IsInfinite())
+
+	_, err = ParseRRule("FREQ=MINUTELY", nil)
+	assert.Equal(t, ErrUnsupportedRRule, err)
+	_, err = ParseRRule("FREQ=DAILY;BYHOUR=9", nil)
+	assert.Equal(t, ErrUnsupportedRRule, err)
+	_, err = ParseRRule("INTERVAL=2", nil)
+	assert.Equal(t, ErrInvalidFormat, err)
+	_, err = ParseRRule("FREQ=WEEKLY;BYDAY=XX", nil)
+	assert.Equal(t, ErrInvalidFormat, err)
+	_, err = ParseRRule("FREQ=MONTHLY;BYMONTHDAY=32", nil)
+	assert.Equal(t, ErrInvalidFormat, err)
+}
+
+func TestExpand(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	// Monday, 19 October 2026
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, paris)
+
+	assert.Equal(t, []string{
+		"2026-10-19 10:00", "2026-10-21 10:00", "2026-10-23 10:00",
+	}, expand(t, "FREQ=DAILY;INTERVAL=2;COUNT=3", start, 100))
+
+	// The wall clock is kept after the change of time on 25 October
+	assert.Equal(t, []string{
+		"2026-10-19 10:00", "2026-10-21 10:00", "2026-10-26 10:00", "2026-10-28 10:00",
+	}, expand(t, "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4", start, 100))
+	r, err := ParseRRule("FREQ=WEEKLY;COUNT=2", paris)
+	require.NoError(t, err)
+	times := r.Between(start, start, start.AddDate(1, 0, 0), 10)
+	require.Len(t, times, 2)
+	assert.Equal(t, 7*24*time.Hour+time.Hour, times[1].Sub(times[0]))
+
+	assert.Equal(t, []string{
+		"2026-10-26 10:00", "2026-11-09 10:00", "2026-11-23 10:00",
+	}, expand(t, "FREQ=WEEKLY;INTERVAL=2;UNTIL=20261123T090000Z;BYDAY=MO", start.AddDate(0, 0, 7), 100))
+
+	// The third Monday of each month
+	assert.Equal(t, []string{
+		"2026-10-19 10:00", "2026-11-16 10:00", "2026-12-21 10:00",
+	}, expand(t, "FREQ=MONTHLY;BYDAY=3MO", start, 3))
+
+	// The last working day of each month
+	assert.Equal(t, []string{
+		"2026-10-19 10:00", "2026-10-30 10:00", "2026-11-30 10:00", "2026-12-31 10:00", "2027-01-29 10:00",
+	}, expand(t, "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", start, 5))
+
+	// The 31st is skipped for the shorter months
+	day31 := time.Date(2027, 1, 31, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{
+		"2027-01-31 09:00", "2027-03-31 09:00", "2027-05-31 09:00",
+	}, expand(t, "FREQ=MONTHLY;COUNT=3", day31, 100))
+	assert.Equal(t, []string{
+		"2027-01-31 09:00", "2027-02-28 09:00", "2027-03-31 09:00",
+	}, expand(t, "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", day31, 100))
+
+	// Birthdays on 29 February
+	leap := time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{
+		"2028-02-29 00:00", "2032-02-29 00:00",
+	}, expand(t, "FREQ=YEARLY;COUNT=2", leap, 100))
+
+	// Thanksgiving in the USA
+	thanksgiving := time.Date(2026, 11, 26, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{
+		"2026-11-26 12:00", "2027-11-25 12:00", "2028-11-23 12:00",
+	}, expand(t, "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=3", thanksgiving, 100))
+
+	// A rule that never matches does not loop forever
+	never := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"2026-01-01 00:00"},
+		expand(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", never, 100))
+}
+
+func TestBetweenAndLast(t *testing.T) {
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	r, err := ParseRRule("FREQ=DAILY", nil)
+	require.NoError(t, err)
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{
+		"2026-03-01 09:00", "2026-03-02 09:00", "2026-03-03 09:00",
+	}, dates(r.Between(start, from, to, 100)))
+	_, ok := r.Last(start)
+	assert.False(t, ok)
+
+	r, err = ParseRRule("FREQ=WEEKLY;UNTIL=20260126", nil)
+	require.NoError(t, err)
+	last, ok := r.Last(start)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, 1, 26, 9, 0, 0, 0, time.UTC), last)
+}
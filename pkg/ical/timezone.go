@@ -0,0 +1,102 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// VTimezone returns a VTIMEZONE component for a location, with the
+// transitions of the given year described as yearly rules. The calendar
+// clients need it for the DATE-TIME with a TZID.
+func VTimezone(loc *time.Location, year int) *Component {
+	tz := NewComponent("VTIMEZONE")
+	tz.Add(NewProperty("TZID", loc.String()))
+
+	transitions := findTransitions(loc, year)
+	if len(transitions) == 0 {
+		t := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		name, offset := t.Zone()
+		std := NewComponent("STANDARD")
+		std.Add(NewProperty("DTSTART", "19700101T000000"))
+		std.Add(NewProperty("TZOFFSETFROM", formatOffset(offset)))
+		std.Add(NewProperty("TZOFFSETTO", formatOffset(offset)))
+		std.AddText("TZNAME", name)
+		tz.AddComponent(std)
+		return tz
+	}
+
+	for _, t := range transitions {
+		_, before := t.Add(-time.Second).Zone()
+		name, after := t.Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		comp := NewComponent(kind)
+		// DTSTART is the local time of the transition, with the old offset
+		local := t.UTC().Add(time.Duration(before) * time.Second)
+		comp.Add(NewProperty("DTSTART", local.Format(dateTimeLayout)))
+		comp.Add(NewProperty("RRULE", yearlyRule(local)))
+		comp.Add(NewProperty("TZOFFSETFROM", formatOffset(before)))
+		comp.Add(NewProperty("TZOFFSETTO", formatOffset(after)))
+		comp.AddText("TZNAME", name)
+		tz.AddComponent(comp)
+	}
+	return tz
+}
+
+// findTransitions returns the instants of the year where the offset of the
+// location changes, to the second.
+func findTransitions(loc *time.Location, year int) []time.Time {
+	var transitions []time.Time
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc)
+	prev := start
+	for t := start.Add(24 * time.Hour); !t.After(end); t = t.Add(24 * time.Hour) {
+		_, o1 := prev.Zone()
+		_, o2 := t.Zone()
+		if o1 != o2 {
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.Zone(); o == o1 {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			transitions = append(transitions, hi.Truncate(time.Second))
+		}
+		prev = t
+	}
+	return transitions
+}
+
+// yearlyRule returns a rule like FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU for the
+// day of a transition.
+func yearlyRule(t time.Time) string {
+	n := (t.Day()-1)/7 + 1
+	if t.Day()+7 > daysIn(t.Year(), t.Month()) {
+		n = -1
+	}
+	day := ""
+	for name, wd := range weekdays {
+		if wd == t.Weekday() {
+			day = name
+		}
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", int(t.Month()), n, day)
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, (seconds%3600)/60)
+	if rest := seconds % 60; rest != 0 {
+		s += fmt.Sprintf("%02d", rest)
+	}
+	return s
+}
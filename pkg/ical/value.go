@@ -0,0 +1,276 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout        = "20060102"
+	dateTimeLayout    = "20060102T150405"
+	utcDateTimeLayout = "20060102T150405Z"
+)
+
+// EscapeText escapes a value of type TEXT.
+func EscapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// UnescapeText decodes a value of type TEXT.
+func UnescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	escaped := false
+	for _, c := range s {
+		if escaped {
+			switch c {
+			case 'n', 'N':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteRune(c)
+			}
+			escaped = false
+			continue
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// splitUnescaped splits a value on the separators that are not escaped.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// LoadLocation returns the location for a TZID. The IANA names are used
+// directly, and the prefixed names (like /mozilla.org/20050126_1/Europe/Paris)
+// are tried with their last segments. The VTIMEZONE definitions are not
+// interpreted, so the location is nil if the TZID is not known.
+func LoadLocation(tzid string) *time.Location {
+	tzid = strings.Trim(tzid, `"/ `)
+	if tzid == "" {
+		return nil
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	parts := strings.Split(tzid, "/")
+	for i := 1; i < len(parts); i++ {
+		if loc, err := time.LoadLocation(strings.Join(parts[i:], "/")); err == nil {
+			return loc
+		}
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// windowsZones are the most common timezone names used by Outlook.
+var windowsZones = map[string]string{
+	"W. Europe Standard Time":      "Europe/Berlin",
+	"Romance Standard Time":        "Europe/Paris",
+	"Central Europe Standard Time": "Europe/Budapest",
+	"GMT Standard Time":            "Europe/London",
+	"Eastern Standard Time":        "America/New_York",
+	"Central Standard Time":        "America/Chicago",
+	"Mountain Standard Time":       "America/Denver",
+	"Pacific Standard Time":        "America/Los_Angeles",
+	"UTC":                          "UTC",
+}
+
+// DateTime returns the value of a DATE or DATE-TIME property. The allDay
+// result is true for a DATE. The floating times and the unknown timezones use
+// the default location.
+func (p *Property) DateTime(defaultLoc *time.Location) (t time.Time, allDay bool, err error) {
+	times, allDay, err := p.DateTimes(defaultLoc)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(times) == 0 {
+		return time.Time{}, false, ErrInvalidFormat
+	}
+	return times[0], allDay, nil
+}
+
+// DateTimes returns the values of a property with a list of DATE or
+// DATE-TIME (like EXDATE).
+func (p *Property) DateTimes(defaultLoc *time.Location) ([]time.Time, bool, error) {
+	if defaultLoc == nil {
+		defaultLoc = time.UTC
+	}
+	loc := defaultLoc
+	if tzid := p.Param("TZID"); tzid != "" {
+		if l := LoadLocation(tzid); l != nil {
+			loc = l
+		}
+	}
+	allDay := strings.EqualFold(p.Param("VALUE"), "DATE")
+	var times []time.Time
+	for _, v := range strings.Split(p.Value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		var t time.Time
+		var err error
+		switch {
+		case allDay || len(v) == len(dateLayout):
+			allDay = true
+			t, err = time.ParseInLocation(dateLayout, v, time.UTC)
+		case strings.HasSuffix(v, "Z"):
+			t, err = time.ParseInLocation(utcDateTimeLayout, v, time.UTC)
+		default:
+			t, err = time.ParseInLocation(dateTimeLayout, v, loc)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("ical: invalid date %q: %w", v, err)
+		}
+		times = append(times, t)
+	}
+	return times, allDay, nil
+}
+
+// NewDateTimeProperty returns a property for a DATE-TIME (with its TZID if
+// the location is not UTC), or a DATE if allDay is true.
+func NewDateTimeProperty(name string, t time.Time, allDay bool) *Property {
+	return NewDateTimesProperty(name, []time.Time{t}, allDay)
+}
+
+// NewDateTimesProperty returns a property with a list of DATE-TIME or DATE.
+// All the times must be in the same location.
+func NewDateTimesProperty(name string, times []time.Time, allDay bool) *Property {
+	prop := NewProperty(name, "")
+	values := make([]string, len(times))
+	for i, t := range times {
+		switch {
+		case allDay:
+			values[i] = t.Format(dateLayout)
+		case t.Location() == time.UTC:
+			values[i] = t.Format(utcDateTimeLayout)
+		default:
+			values[i] = t.Format(dateTimeLayout)
+		}
+	}
+	if allDay {
+		prop.Params.Set("VALUE", "DATE")
+	} else if len(times) > 0 && times[0].Location() != time.UTC {
+		prop.Params.Set("TZID", times[0].Location().String())
+	}
+	prop.Value = strings.Join(values, ",")
+	return prop
+}
+
+// ParseDuration parses a value of type DURATION, like -PT15M or P1W.
+func ParseDuration(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("ical: invalid duration %q", s)
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, invalid
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T':
+			if inTime || num != "" {
+				return 0, invalid
+			}
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, invalid
+		}
+		num = ""
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, invalid
+		}
+	}
+	if num != "" || strings.HasSuffix(s, "T") {
+		return 0, invalid
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// FormatDuration returns the DURATION value for a duration.
+func FormatDuration(d time.Duration) string {
+	var sb strings.Builder
+	if d < 0 {
+		sb.WriteString("-")
+		d = -d
+	}
+	sb.WriteString("P")
+	if d == 0 {
+		sb.WriteString("T0S")
+		return sb.String()
+	}
+	if days := d / (24 * time.Hour); days > 0 {
+		sb.WriteString(strconv.FormatInt(int64(days), 10) + "D")
+		d -= days * 24 * time.Hour
+	}
+	if d > 0 {
+		sb.WriteString("T")
+		if h := d / time.Hour; h > 0 {
+			sb.WriteString(strconv.FormatInt(int64(h), 10) + "H")
+			d -= h * time.Hour
+		}
+		if m := d / time.Minute; m > 0 {
+			sb.WriteString(strconv.FormatInt(int64(m), 10) + "M")
+			d -= m * time.Minute
+		}
+		if s := d / time.Second; s > 0 {
+			sb.WriteString(strconv.FormatInt(int64(s), 10) + "S")
+		}
+	}
+	return sb.String()
+}
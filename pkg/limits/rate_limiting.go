@@ -77,6 +77,21 @@ const (
 	MagicLinkType
 	// ResendOnboardingMailType is used for resending the onboarding link by email
 	ResendOnboardingMailType
+	// CSPReportType is used for the violations of the CSP policies reported
+	// by the browsers
+	CSPReportType
+	// AppIntegrityAlertType is used for the alerts sent to the administrator
+	// when a file of an application has been tampered
+	AppIntegrityAlertType
+	// RolloutClientErrorType is used for the instances where the client side
+	// of an application logs errors, for a version being rolled out
+	RolloutClientErrorType
+	// RolloutJobFailureType is used for the instances where the service jobs
+	// of an application fail, for a version being rolled out
+	RolloutJobFailureType
+	// RolloutInstanceSignalType is used to count an instance only once for a
+	// health signal of a version being rolled out
+	RolloutInstanceSignalType
 )
 
 type counterConfig struct {
@@ -230,6 +245,36 @@ var configs = []counterConfig{
 		Limit:  2,
 		Period: 1 * time.Hour,
 	},
+	// CSPReportType
+	{
+		Prefix: "csp-report",
+		Limit:  500,
+		Period: 1 * time.Hour,
+	},
+	// AppIntegrityAlertType
+	{
+		Prefix: "app-integrity-alert",
+		Limit:  1,
+		Period: 24 * time.Hour,
+	},
+	// RolloutClientErrorType
+	{
+		Prefix: "rollout-client-error",
+		Limit:  20,
+		Period: 1 * time.Hour,
+	},
+	// RolloutJobFailureType
+	{
+		Prefix: "rollout-job-failure",
+		Limit:  10,
+		Period: 1 * time.Hour,
+	},
+	// RolloutInstanceSignalType
+	{
+		Prefix: "rollout-instance-signal",
+		Limit:  1,
+		Period: 1 * time.Hour,
+	},
 }
 
 // Counter is an interface for counting number of attempts that can be used to
@@ -295,6 +340,14 @@ func (r *RateLimiter) ResetCounter(p prefixer.Prefixer, ct CounterType) {
 	_ = r.counter.Reset(key)
 }
 
+// ResetCounterKey sets again to zero the counter for the given type and key.
+func (r *RateLimiter) ResetCounterKey(customKey string, ct CounterType) {
+	cfg := configs[ct]
+	key := cfg.Prefix + ":" + customKey
+
+	_ = r.counter.Reset(key)
+}
+
 // IsLimitReachedOrExceeded return true if the limit has been reached or
 // exceeded, false otherwise.
 func IsLimitReachedOrExceeded(err error) bool {
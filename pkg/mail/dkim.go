@@ -0,0 +1,210 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DKIMHeaders is the list of the headers that are signed with DKIM, when they
+// are present in the mail.
+var DKIMHeaders = []string{
+	"From", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "X-Cozy",
+}
+
+// DKIMSigner can add a DKIM-Signature header to a mail (RFC 6376), with the
+// relaxed canonicalization for both the headers and the body.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// NewDKIMSigner returns a signer for the given domain and selector, with a
+// private key (RSA or Ed25519) in the PEM format.
+func NewDKIMSigner(domain, selector string, pemKey []byte) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: missing domain or selector")
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM block for the private key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: invalid private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("dkim: unsupported private key")
+	}
+	return &DKIMSigner{Domain: domain, Selector: selector, Key: signer}, nil
+}
+
+// Sign returns the mail with a DKIM-Signature header added at the top.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	var algo string
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algo = "ed25519-sha256"
+	default:
+		return nil, errors.New("dkim: unsupported private key")
+	}
+
+	msg = toCRLF(msg)
+	header, body := splitMail(msg)
+	bodyHash := sha256.Sum256(DKIMRelaxedBody(body))
+	fields := parseHeaderFields(header)
+
+	var names []string
+	var signed bytes.Buffer
+	used := make(map[int]bool)
+	for _, name := range DKIMHeaders {
+		// When a header appears several times, the instances are signed from
+		// the bottom to the top (RFC 6376 section 5.4.2).
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			names = append(names, strings.ToLower(name))
+			signed.WriteString(DKIMRelaxedHeader(fields[i]))
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algo, s.Domain, s.Selector, time.Now().Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	sigHeader := "DKIM-Signature: " + value
+	signed.WriteString(strings.TrimSuffix(DKIMRelaxedHeader(sigHeader+"\r\n"), "\r\n"))
+
+	hash := sha256.Sum256(signed.Bytes())
+	var sig []byte
+	var err error
+	if algo == "rsa-sha256" {
+		sig, err = s.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	} else {
+		sig, err = s.Key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: cannot sign: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigHeader)
+	out.WriteString(base64.StdEncoding.EncodeToString(sig))
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// DKIMRelaxedHeader returns a header field (with its CRLF) canonicalized with
+// the relaxed algorithm.
+func DKIMRelaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return name + ":" + value + "\r\n"
+}
+
+// DKIMRelaxedBody returns the body canonicalized with the relaxed algorithm.
+func DKIMRelaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	var buf bytes.Buffer
+	empty := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			empty++
+			continue
+		}
+		for ; empty > 0; empty-- {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(strings.Join(splitWSP(line), " "))
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// splitWSP splits a line on the sequences of white spaces, but keeps an empty
+// first element if the line starts with a white space.
+func splitWSP(line string) []string {
+	parts := strings.FieldsFunc(line, isWSP)
+	if len(line) > 0 && isWSP(rune(line[0])) {
+		parts = append([]string{""}, parts...)
+	}
+	return parts
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+// toCRLF converts the bare LF line endings to CRLF.
+func toCRLF(msg []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(msg))
+	for i, c := range msg {
+		if c == '\n' && (i == 0 || msg[i-1] != '\r') {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(c)
+	}
+	return buf.Bytes()
+}
+
+// splitMail returns the header and the body of a mail.
+func splitMail(msg []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		return nil, msg[2:]
+	}
+	idx := bytes.Index(msg, []byte("\r\n\r\n"))
+	if idx < 0 {
+		return msg, nil
+	}
+	return msg[:idx+2], msg[idx+4:]
+}
+
+// parseHeaderFields returns the header fields, with their continuation lines
+// and the final CRLF.
+func parseHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimRight(name, " \t")
+}
@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// Example from RFC 6376 section 3.4.5
+	assert.Equal(t, "a:X\r\n", DKIMRelaxedHeader("A: X\r\n"))
+	assert.Equal(t, "b:Y Z\r\n", DKIMRelaxedHeader("B : Y\t\r\n\tZ  \r\n"))
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+	assert.Equal(t, " C\r\nD E\r\n", string(DKIMRelaxedBody(body)))
+	assert.Empty(t, DKIMRelaxedBody([]byte("\r\n\r\n")))
+}
+
+func TestDKIMSign(t *testing.T) {
+	msg := "From: Alice <alice@example.org>\n" +
+		"To: bob@example.net\n" +
+		"Subject: Hello\n" +
+		"  world\n" +
+		"X-Unsigned: foo\n" +
+		"\n" +
+		"Hi Bob,  \n\nbye\n\n"
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	})
+	signer, err := NewDKIMSigner("example.org", "cozy", rsaPEM)
+	require.NoError(t, err)
+	signed, err := signer.Sign([]byte(msg))
+	require.NoError(t, err)
+	hash, sig, tags := verifyDKIM(t, string(signed))
+	assert.Equal(t, "rsa-sha256", tags["a"])
+	assert.NoError(t, rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, hash, sig))
+
+	pub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	signer, err = NewDKIMSigner("example.org", "cozy", edPEM)
+	require.NoError(t, err)
+	signed, err = signer.Sign([]byte(msg))
+	require.NoError(t, err)
+	hash, sig, tags = verifyDKIM(t, string(signed))
+	assert.Equal(t, "ed25519-sha256", tags["a"])
+	assert.True(t, ed25519.Verify(pub, hash, sig))
+
+	_, err = NewDKIMSigner("example.org", "", rsaPEM)
+	assert.Error(t, err)
+	_, err = NewDKIMSigner("example.org", "cozy", []byte("not a key"))
+	assert.Error(t, err)
+}
+
+// verifyDKIM checks the body hash of a signed mail, and returns the hash of
+// the signed headers with the signature to check.
+func verifyDKIM(t *testing.T, signed string) ([]byte, []byte, map[string]string) {
+	header, body, ok := strings.Cut(signed, "\r\n\r\n")
+	require.True(t, ok)
+	fields := parseHeaderFields([]byte(header + "\r\n"))
+	require.True(t, strings.HasPrefix(fields[0], "DKIM-Signature: "))
+
+	tags := make(map[string]string)
+	value := strings.TrimPrefix(strings.TrimSpace(fields[0]), "DKIM-Signature: ")
+	for _, tag := range strings.Split(value, "; ") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[k] = v
+	}
+	assert.Equal(t, "example.org", tags["d"])
+	assert.Equal(t, "cozy", tags["s"])
+	assert.Equal(t, "relaxed/relaxed", tags["c"])
+	assert.Equal(t, "from:to:subject", tags["h"])
+
+	bodyHash := sha256.Sum256([]byte("Hi Bob,\r\n\r\nbye\r\n"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(bodyHash[:]), tags["bh"])
+	assert.Equal(t, string(DKIMRelaxedBody([]byte(body))), "Hi Bob,\r\n\r\nbye\r\n")
+
+	var data strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for _, field := range fields[1:] {
+			if strings.EqualFold(fieldName(field), name) {
+				data.WriteString(DKIMRelaxedHeader(field))
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(fields[0], "b=")
+	data.WriteString(DKIMRelaxedHeader(unsigned + "\r\n"))
+	hash := sha256.Sum256([]byte(strings.TrimSuffix(data.String(), "\r\n")))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	require.NoError(t, err)
+	return hash[:], sig, tags
+}
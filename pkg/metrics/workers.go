@@ -9,6 +9,8 @@ const (
 	WorkerExecResultSuccess = "success"
 	// WorkerExecResultErrored for errored result label
 	WorkerExecResultErrored = "errored"
+	// WorkerExecResultCancelled for cancelled result label
+	WorkerExecResultCancelled = "cancelled"
 )
 
 // WorkerExecDurations is a histogram metric of the execution duration in
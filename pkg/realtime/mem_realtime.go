@@ -58,32 +58,7 @@ func (h *memHub) SubscribeFirehose() *Subscriber {
 }
 
 func (h *memHub) subscribe(sub *Subscriber, key string) {
-	h.Lock()
-	go func() {
-		defer h.Unlock()
-
-		h.addTopic(sub, key)
-
-		w := &toWatch{sub, ""}
-		for {
-			it, exists := h.topics[key]
-			if !exists {
-				it = newTopic()
-				h.topics[key] = it
-			}
-
-			select {
-			case it.subscribe <- w:
-				return
-			case running := <-it.running:
-				logger.WithNamespace("realtime").
-					Warnf("unexpected state: subscribe with running=%v", running)
-				if !running {
-					delete(h.topics, key)
-				}
-			}
-		}
-	}()
+	h.addWatcher(key, &toWatch{sub: sub}, "subscribe")
 }
 
 func (h *memHub) unsubscribe(sub *Subscriber, key string) {
@@ -98,7 +73,7 @@ func (h *memHub) unsubscribe(sub *Subscriber, key string) {
 
 		h.removeTopic(sub, key)
 
-		w := &toWatch{sub, ""}
+		w := &toWatch{sub: sub}
 		select {
 		case it.unsubscribe <- w:
 			if running := <-it.running; !running {
@@ -115,13 +90,28 @@ func (h *memHub) unsubscribe(sub *Subscriber, key string) {
 }
 
 func (h *memHub) watch(sub *Subscriber, key, id string) {
+	h.addWatcher(key, &toWatch{sub: sub, id: id}, "watch")
+}
+
+func (h *memHub) unwatch(sub *Subscriber, key, id string) {
+	h.removeWatcher(key, &toWatch{sub: sub, id: id}, "unwatch")
+}
+
+func (h *memHub) filter(sub *Subscriber, key string, sel *Selector) {
+	h.addWatcher(key, &toWatch{sub: sub, selector: sel}, "filter")
+}
+
+func (h *memHub) unfilter(sub *Subscriber, key string, sel *Selector) {
+	h.removeWatcher(key, &toWatch{sub: sub, selector: sel}, "unfilter")
+}
+
+func (h *memHub) addWatcher(key string, w *toWatch, action string) {
 	h.Lock()
 	go func() {
 		defer h.Unlock()
 
-		h.addTopic(sub, key)
+		h.addTopic(w.sub, key)
 
-		w := &toWatch{sub, id}
 		for {
 			it, exists := h.topics[key]
 			if !exists {
@@ -134,7 +124,7 @@ func (h *memHub) watch(sub *Subscriber, key, id string) {
 				return
 			case running := <-it.running:
 				logger.WithNamespace("realtime").
-					Warnf("unexpected state: watch with running=%v", running)
+					Warnf("unexpected state: %s with running=%v", action, running)
 				if !running {
 					delete(h.topics, key)
 				}
@@ -143,7 +133,7 @@ func (h *memHub) watch(sub *Subscriber, key, id string) {
 	}()
 }
 
-func (h *memHub) unwatch(sub *Subscriber, key, id string) {
+func (h *memHub) removeWatcher(key string, w *toWatch, action string) {
 	h.Lock()
 	go func() {
 		defer h.Unlock()
@@ -153,7 +143,6 @@ func (h *memHub) unwatch(sub *Subscriber, key, id string) {
 			return
 		}
 
-		w := &toWatch{sub, id}
 		select {
 		case it.unsubscribe <- w:
 			if running := <-it.running; !running {
@@ -161,7 +150,7 @@ func (h *memHub) unwatch(sub *Subscriber, key, id string) {
 			}
 		case running := <-it.running:
 			logger.WithNamespace("realtime").
-				Warnf("unexpected state: unwatch with running=%v", running)
+				Warnf("unexpected state: %s with running=%v", action, running)
 			if !running {
 				delete(h.topics, key)
 			}
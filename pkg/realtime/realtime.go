@@ -79,6 +79,8 @@ type Hub interface {
 	unsubscribe(sub *Subscriber, key string)
 	watch(sub *Subscriber, key, id string)
 	unwatch(sub *Subscriber, key, id string)
+	filter(sub *Subscriber, key string, sel *Selector)
+	unfilter(sub *Subscriber, key string, sel *Selector)
 	close(sub *Subscriber)
 }
 
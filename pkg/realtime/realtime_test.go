@@ -215,6 +215,57 @@ func TestSubscribeWatchUnwatch(t *testing.T) {
 	assert.Equal(t, "id2", e.Doc.ID())
 }
 
+func TestFilter(t *testing.T) {
+	h := newMemHub()
+	sub := h.Subscriber(testingDB)
+	defer sub.Close()
+
+	sel, err := NewSelector(map[string]interface{}{"dir_id": "dir1"})
+	assert.NoError(t, err)
+	sub.Filter("io.cozy.files", sel)
+	time.Sleep(1 * time.Millisecond)
+
+	h.Publish(testingDB, EventCreate, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file1", "dir_id": "dir2"},
+	}, nil)
+	h.Publish(testingDB, EventUpdate, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file2", "dir_id": "dir2"},
+	}, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file2", "dir_id": "dir1"},
+	})
+	h.Publish(testingDB, EventCreate, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file3", "dir_id": "dir1"},
+	}, nil)
+
+	// file2 has been moved out of dir1: only its old version is sent
+	e := <-sub.Channel
+	assert.Equal(t, "file2", e.Doc.ID())
+	assert.Equal(t, EventDelete, e.Verb)
+	assert.Equal(t, "dir1", e.Doc.(*JSONDoc).M["dir_id"])
+	assert.Nil(t, e.OldDoc)
+	e = <-sub.Channel
+	assert.Equal(t, "file3", e.Doc.ID())
+	assert.Equal(t, EventCreate, e.Verb)
+
+	sub.Unfilter("io.cozy.files", sel)
+	sub.Watch("io.cozy.files", "file5")
+	time.Sleep(1 * time.Millisecond)
+	h.Publish(testingDB, EventCreate, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file4", "dir_id": "dir1"},
+	}, nil)
+	h.Publish(testingDB, EventCreate, &JSONDoc{
+		Type: "io.cozy.files",
+		M:    map[string]interface{}{"_id": "file5", "dir_id": "dir2"},
+	}, nil)
+	e = <-sub.Channel
+	assert.Equal(t, "file5", e.Doc.ID())
+}
+
 func TestRedisRealtime(t *testing.T) {
 	if testing.Short() {
 		t.Skip("a redis is required for this test: test skipped due to the use of --short flag")
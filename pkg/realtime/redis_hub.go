@@ -148,7 +148,7 @@ func (h *redisHub) Subscriber(db prefixer.Prefixer) *Subscriber {
 
 func (h *redisHub) SubscribeFirehose() *Subscriber {
 	sub := newSubscriber(h, globalPrefixer)
-	h.firehose.subscribe <- &toWatch{sub, ""}
+	h.firehose.subscribe <- &toWatch{sub: sub}
 	return sub
 }
 
@@ -157,7 +157,7 @@ func (h *redisHub) subscribe(sub *Subscriber, key string) {
 }
 
 func (h *redisHub) unsubscribe(sub *Subscriber, key string) {
-	h.firehose.unsubscribe <- &toWatch{sub, ""}
+	h.firehose.unsubscribe <- &toWatch{sub: sub}
 	<-h.firehose.running
 }
 
@@ -169,6 +169,14 @@ func (h *redisHub) unwatch(sub *Subscriber, key, id string) {
 	panic("not reachable code")
 }
 
+func (h *redisHub) filter(sub *Subscriber, key string, sel *Selector) {
+	panic("not reachable code")
+}
+
+func (h *redisHub) unfilter(sub *Subscriber, key string, sel *Selector) {
+	panic("not reachable code")
+}
+
 func (h *redisHub) close(sub *Subscriber) {
 	h.unsubscribe(sub, "*")
 }
@@ -0,0 +1,347 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrInvalidSelector is returned when a selector can't be parsed
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// Selector is a predicate on the documents of the events. It uses a subset of
+// the mango syntax of CouchDB: implicit equality, $eq, $ne, $in, $nin,
+// $exists, $gt, $gte, $lt, $lte, $elemMatch, $and, $or, $nor and $not.
+//
+// When the value of a field is an array, an equality matches if one of the
+// items is equal to the expected value.
+type Selector struct {
+	raw  string
+	cond condition
+}
+
+// condition is the compiled form of a selector, or a part of it.
+type condition func(value interface{}) bool
+
+// NewSelector compiles a mango selector.
+func NewSelector(selector map[string]interface{}) (*Selector, error) {
+	if len(selector) == 0 {
+		return nil, ErrInvalidSelector
+	}
+	cond, err := compileObject(selector)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(selector)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{raw: string(raw), cond: cond}, nil
+}
+
+// String returns the JSON representation of the selector.
+func (s *Selector) String() string {
+	return s.raw
+}
+
+// Match returns true if the document matches the selector.
+func (s *Selector) Match(doc Doc) bool {
+	return s.match(docToMap(doc))
+}
+
+func (s *Selector) match(doc map[string]interface{}) bool {
+	if doc == nil {
+		return false
+	}
+	return s.cond(doc)
+}
+
+// docToMap returns the fields of the document as a map, by using its JSON
+// representation.
+func docToMap(doc Doc) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	if j, ok := doc.(*JSONDoc); ok && j != nil {
+		return j.M
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+func compileObject(obj map[string]interface{}) (condition, error) {
+	conds := make([]condition, 0, len(obj))
+	for key, arg := range obj {
+		var cond condition
+		var err error
+		if strings.HasPrefix(key, "$") {
+			cond, err = compileLogic(key, arg)
+		} else {
+			cond, err = compileField(key, arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return allOf(conds), nil
+}
+
+func compileLogic(op string, arg interface{}) (condition, error) {
+	if op == "$not" {
+		obj, ok := arg.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: $not expects an object", ErrInvalidSelector)
+		}
+		cond, err := compileObject(obj)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool { return !cond(v) }, nil
+	}
+
+	list, ok := arg.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%w: %s expects a non-empty array", ErrInvalidSelector, op)
+	}
+	conds := make([]condition, len(list))
+	for i, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects an array of objects", ErrInvalidSelector, op)
+		}
+		cond, err := compileObject(obj)
+		if err != nil {
+			return nil, err
+		}
+		conds[i] = cond
+	}
+
+	switch op {
+	case "$and":
+		return allOf(conds), nil
+	case "$or":
+		return anyOf(conds), nil
+	case "$nor":
+		or := anyOf(conds)
+		return func(v interface{}) bool { return !or(v) }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown operator %s", ErrInvalidSelector, op)
+}
+
+func compileField(field string, arg interface{}) (condition, error) {
+	path := strings.Split(field, ".")
+	cond, err := compileOperators(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) bool {
+		value, exists := lookup(v, path)
+		if !exists {
+			value = missing{}
+		}
+		return cond(value)
+	}, nil
+}
+
+// missing is used as the value of a field that is not present in the
+// document. It allows to make the difference with a null value.
+type missing struct{}
+
+func compileOperators(arg interface{}) (condition, error) {
+	obj, ok := arg.(map[string]interface{})
+	if !ok || !hasOperators(obj) {
+		return func(v interface{}) bool { return equal(v, arg) }, nil
+	}
+
+	conds := make([]condition, 0, len(obj))
+	for op, operand := range obj {
+		cond, err := compileOperator(op, operand)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return allOf(conds), nil
+}
+
+func compileOperator(op string, operand interface{}) (condition, error) {
+	switch op {
+	case "$eq":
+		return func(v interface{}) bool { return equal(v, operand) }, nil
+	case "$ne":
+		return func(v interface{}) bool { return !equal(v, operand) }, nil
+	case "$in", "$nin":
+		list, ok := operand.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects an array", ErrInvalidSelector, op)
+		}
+		in := func(v interface{}) bool {
+			for _, item := range list {
+				if equal(v, item) {
+					return true
+				}
+			}
+			return false
+		}
+		if op == "$nin" {
+			return func(v interface{}) bool { return !in(v) }, nil
+		}
+		return in, nil
+	case "$exists":
+		expected, ok := operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: $exists expects a boolean", ErrInvalidSelector)
+		}
+		return func(v interface{}) bool {
+			_, isMissing := v.(missing)
+			return expected != isMissing
+		}, nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return func(v interface{}) bool {
+			cmp, ok := compare(v, operand)
+			if !ok {
+				return false
+			}
+			switch op {
+			case "$gt":
+				return cmp > 0
+			case "$gte":
+				return cmp >= 0
+			case "$lt":
+				return cmp < 0
+			default:
+				return cmp <= 0
+			}
+		}, nil
+	case "$elemMatch":
+		obj, ok := operand.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: $elemMatch expects an object", ErrInvalidSelector)
+		}
+		var cond condition
+		var err error
+		if hasOperators(obj) {
+			cond, err = compileOperators(obj)
+		} else {
+			cond, err = compileObject(obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool {
+			list, ok := v.([]interface{})
+			if !ok {
+				return false
+			}
+			for _, item := range list {
+				if cond(item) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "$not":
+		cond, err := compileOperators(operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool { return !cond(v) }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown operator %s", ErrInvalidSelector, op)
+}
+
+func hasOperators(obj map[string]interface{}) bool {
+	for k := range obj {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func allOf(conds []condition) condition {
+	return func(v interface{}) bool {
+		for _, cond := range conds {
+			if !cond(v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func anyOf(conds []condition) condition {
+	return func(v interface{}) bool {
+		for _, cond := range conds {
+			if cond(v) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func lookup(v interface{}, path []string) (interface{}, bool) {
+	for _, part := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func equal(value, expected interface{}) bool {
+	if _, ok := value.(missing); ok {
+		return false
+	}
+	if reflect.DeepEqual(value, expected) {
+		return true
+	}
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if reflect.DeepEqual(item, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compare(value, operand interface{}) (int, bool) {
+	switch a := value.(type) {
+	case float64:
+		b, ok := operand.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := operand.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector(t *testing.T) {
+	doc := &JSONDoc{
+		Type: "io.cozy.files",
+		M: map[string]interface{}{
+			"_id":    "file1",
+			"type":   "file",
+			"dir_id": "dir1",
+			"size":   float64(1234),
+			"tags":   []interface{}{"foo", "bar"},
+			"referenced_by": []interface{}{
+				map[string]interface{}{"type": "io.cozy.photos.albums", "id": "album1"},
+			},
+			"metadata": map[string]interface{}{"width": float64(640)},
+		},
+	}
+
+	tests := []struct {
+		selector string
+		match    bool
+	}{
+		{`{"dir_id": "dir1"}`, true},
+		{`{"dir_id": "dir2"}`, false},
+		{`{"dir_id": "dir1", "type": "directory"}`, false},
+		{`{"dir_id": {"$eq": "dir1"}}`, true},
+		{`{"dir_id": {"$ne": "dir1"}}`, false},
+		{`{"dir_id": {"$in": ["dir2", "dir1"]}}`, true},
+		{`{"dir_id": {"$nin": ["dir2", "dir1"]}}`, false},
+		{`{"trashed": {"$exists": false}}`, true},
+		{`{"trashed": {"$exists": true}}`, false},
+		{`{"size": {"$gt": 1000, "$lte": 1234}}`, true},
+		{`{"size": {"$lt": 1000}}`, false},
+		{`{"tags": "bar"}`, true},
+		{`{"metadata.width": 640}`, true},
+		{`{"metadata.height": 640}`, false},
+		{`{"referenced_by": {"$elemMatch": {"type": "io.cozy.photos.albums", "id": "album1"}}}`, true},
+		{`{"referenced_by": {"$elemMatch": {"id": "album2"}}}`, false},
+		{`{"$or": [{"dir_id": "dir2"}, {"tags": "foo"}]}`, true},
+		{`{"$and": [{"dir_id": "dir1"}, {"tags": "baz"}]}`, false},
+		{`{"$nor": [{"dir_id": "dir2"}, {"tags": "baz"}]}`, true},
+		{`{"$not": {"dir_id": "dir1"}}`, false},
+		{`{"size": {"$not": {"$gt": 2000}}}`, true},
+	}
+
+	for _, test := range tests {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.selector), &m))
+		sel, err := NewSelector(m)
+		require.NoError(t, err, test.selector)
+		assert.Equal(t, test.match, sel.Match(doc), test.selector)
+	}
+}
+
+func TestInvalidSelector(t *testing.T) {
+	invalids := []string{
+		`{}`,
+		`{"dir_id": {"$foo": "bar"}}`,
+		`{"$or": {"dir_id": "dir1"}}`,
+		`{"dir_id": {"$in": "dir1"}}`,
+		`{"trashed": {"$exists": "yes"}}`,
+	}
+	for _, invalid := range invalids {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(invalid), &m))
+		_, err := NewSelector(m)
+		assert.ErrorIs(t, err, ErrInvalidSelector, invalid)
+	}
+}
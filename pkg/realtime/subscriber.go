@@ -60,6 +60,26 @@ func (sub *Subscriber) Unwatch(doctype, id string) {
 	sub.hub.unwatch(sub, key, id)
 }
 
+// Filter adds a listener for events on the documents of a doctype that
+// match the given selector (the new or the old version of the document).
+func (sub *Subscriber) Filter(doctype string, sel *Selector) {
+	if sub.hub == nil {
+		return
+	}
+	key := topicKey(sub, doctype)
+	sub.hub.filter(sub, key, sel)
+}
+
+// Unfilter removes a listener for events on the documents of a doctype that
+// match the given selector.
+func (sub *Subscriber) Unfilter(doctype string, sel *Selector) {
+	if sub.hub == nil {
+		return
+	}
+	key := topicKey(sub, doctype)
+	sub.hub.unfilter(sub, key, sel)
+}
+
 // Close will unsubscribe to all topics and the subscriber should no longer be
 // used after that.
 func (sub *Subscriber) Close() {
@@ -1,13 +1,15 @@
 package realtime
 
 type filter struct {
-	whole bool // true if the events for the whole doctype should be sent
-	ids   []string
+	whole     bool // true if the events for the whole doctype should be sent
+	ids       []string
+	selectors []*Selector
 }
 
 type toWatch struct {
-	sub *Subscriber
-	id  string // empty string means the whole doctype
+	sub      *Subscriber
+	id       string    // empty string means the whole doctype
+	selector *Selector // optional, to filter the events on the doctype
 }
 
 type topic struct {
@@ -49,7 +51,48 @@ func (t *topic) loop() {
 }
 
 func (t *topic) publish(e *Event) {
+	// The documents are converted to maps only if a selector needs them, and
+	// only once for all the subscribers.
+	var doc, old map[string]interface{}
+	converted := false
+	matchSelectors := func(selectors []*Selector, onOld bool) bool {
+		if !converted {
+			doc = docToMap(e.Doc)
+			old = docToMap(e.OldDoc)
+			converted = true
+		}
+		d := doc
+		if onOld {
+			d = old
+		}
+		for _, sel := range selectors {
+			if sel.match(d) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// When only the old version of the document matches the selector, the
+	// document has left the selection: the new version may be out of the
+	// permissions of the subscriber, so a DELETED event is sent with the old
+	// version instead.
+	var left *Event
+	leftEvent := func() *Event {
+		if left == nil {
+			left = &Event{
+				Cluster: e.Cluster,
+				Domain:  e.Domain,
+				Prefix:  e.Prefix,
+				Verb:    EventDelete,
+				Doc:     e.OldDoc,
+			}
+		}
+		return left
+	}
+
 	for s, f := range t.subs {
+		evt := e
 		ok := false
 		if f.whole {
 			ok = true
@@ -60,10 +103,17 @@ func (t *topic) publish(e *Event) {
 					break
 				}
 			}
+			if !ok && len(f.selectors) > 0 {
+				ok = matchSelectors(f.selectors, false)
+				if !ok && e.OldDoc != nil && matchSelectors(f.selectors, true) {
+					ok = true
+					evt = leftEvent()
+				}
+			}
 		}
 		if ok {
 			select {
-			case s.Channel <- e:
+			case s.Channel <- evt:
 			case <-s.running: // the subscriber has been closed
 			}
 		}
@@ -72,7 +122,14 @@ func (t *topic) publish(e *Event) {
 
 func (t *topic) doSubscribe(w *toWatch) {
 	f := t.subs[w.sub]
-	if w.id == "" {
+	if w.selector != nil {
+		for _, sel := range f.selectors {
+			if sel.raw == w.selector.raw {
+				return
+			}
+		}
+		f.selectors = append(f.selectors, w.selector)
+	} else if w.id == "" {
 		f.whole = true
 	} else {
 		f.ids = append(f.ids, w.id)
@@ -81,20 +138,33 @@ func (t *topic) doSubscribe(w *toWatch) {
 }
 
 func (t *topic) doUnsubscribe(w *toWatch) {
-	if w.id == "" {
+	f, ok := t.subs[w.sub]
+	if !ok {
+		return
+	}
+	if w.selector != nil {
+		selectors := f.selectors[:0]
+		for _, sel := range f.selectors {
+			if sel.raw != w.selector.raw {
+				selectors = append(selectors, sel)
+			}
+		}
+		f.selectors = selectors
+	} else if w.id == "" {
 		delete(t.subs, w.sub)
-	} else if f, ok := t.subs[w.sub]; ok {
+		return
+	} else {
 		ids := f.ids[:0]
 		for _, id := range f.ids {
 			if id != w.id {
 				ids = append(ids, id)
 			}
 		}
-		if len(ids) == 0 && !f.whole {
-			delete(t.subs, w.sub)
-		} else {
-			f.ids = ids
-			t.subs[w.sub] = f
-		}
+		f.ids = ids
+	}
+	if len(f.ids) == 0 && len(f.selectors) == 0 && !f.whole {
+		delete(t.subs, w.sub)
+	} else {
+		t.subs[w.sub] = f
 	}
 }
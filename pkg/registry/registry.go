@@ -32,6 +32,11 @@ type Version struct {
 	Size      string          `json:"size"`
 	Manifest  json.RawMessage `json:"manifest"`
 	TarPrefix string          `json:"tar_prefix"`
+	// Signature is the ed25519 signature of the package by its publisher,
+	// encoded in base64.
+	Signature string `json:"signature,omitempty"`
+	// Registry is the registry where the version has been found.
+	Registry *url.URL `json:"-"`
 }
 
 // A MaintenanceOptions defines options about a maintenance
@@ -90,19 +95,7 @@ func GetVersion(slug, version string, registries []*url.URL) (*Version, error) {
 	requestURI := fmt.Sprintf("/registry/%s/%s",
 		url.PathEscape(slug),
 		url.PathEscape(version))
-	resp, ok, err := fetchUntilFound(latestVersionClient, registries, requestURI, WithCache)
-	if err != nil {
-		return nil, err
-	}
-	if !ok {
-		return nil, errVersionNotFound
-	}
-	defer resp.Body.Close()
-	var v *Version
-	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
-		return nil, err
-	}
-	return v, nil
+	return fetchVersion(registries, requestURI)
 }
 
 // GetLatestVersion returns the latest version available from the list of
@@ -112,19 +105,35 @@ func GetLatestVersion(slug, channel string, registries []*url.URL) (*Version, er
 	requestURI := fmt.Sprintf("/registry/%s/%s/latest",
 		url.PathEscape(slug),
 		url.PathEscape(channel))
-	resp, ok, err := fetchUntilFound(latestVersionClient, registries, requestURI, WithCache)
+	return fetchVersion(registries, requestURI)
+}
+
+// fetchVersion returns the version from the first registry where it is
+// found, with this registry.
+func fetchVersion(registries []*url.URL, requestURI string) (*Version, error) {
+	ref, err := url.Parse(requestURI)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return nil, errVersionNotFound
-	}
-	defer resp.Body.Close()
-	var v *Version
-	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
-		return nil, err
+	for _, registry := range registries {
+		resp, ok, err := fetch(latestVersionClient, registry, ref, WithCache)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		defer resp.Body.Close()
+		var v *Version
+		if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			return nil, err
+		}
+		if v != nil {
+			v.Registry = registry
+		}
+		return v, nil
 	}
-	return v, nil
+	return nil, errVersionNotFound
 }
 
 // GetApplication returns an application from his slug
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -93,7 +94,24 @@ var ClientWithKeepAlive = &http.Client{
 	Transport: transportWithKeepAlive,
 }
 
+// NewDialer returns a dialer for the protocols other than HTTP, like SFTP.
+// It has the same restrictions as the HTTP clients, except that the allowed
+// ports are the given ones instead of 80 and 443.
+func NewDialer(ports ...string) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			return checkAddress(network, address, ports)
+		},
+	}
+}
+
 func safeControl(network string, address string, conn syscall.RawConn) error {
+	return checkAddress(network, address, []string{"80", "443"})
+}
+
+func checkAddress(network string, address string, ports []string) error {
 	if !(network == "tcp4" || network == "tcp6") {
 		return fmt.Errorf("%s is not a safe network type", network)
 	}
@@ -135,7 +153,7 @@ func safeControl(network string, address string, conn syscall.RawConn) error {
 		return fmt.Errorf("%s is not a public IP address", ipaddress)
 	}
 
-	if port != "80" && port != "443" {
+	if !slices.Contains(ports, port) {
 		return fmt.Errorf("%s is not a safe port number", port)
 	}
 
@@ -148,3 +148,19 @@ func TestSafeControl(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestNewDialer(t *testing.T) {
+	build.BuildMode = build.ModeProd
+	t.Cleanup(resetTrustedNetworks)
+
+	control := NewDialer("22").Control
+	assert.NoError(t, control("tcp4", "8.8.8.8:22", nil))
+
+	err := control("tcp4", "8.8.8.8:443", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a safe port number")
+
+	err = control("tcp4", "192.168.1.1:22", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a public IP address")
+}
@@ -150,7 +150,8 @@ func (c *Client) Put(
 	}
 	defer res.Body.Close()
 	switch res.StatusCode {
-	case 201:
+	case 201, 204:
+		// 204 is used when an existing file has been overwritten
 		return nil
 	case 401, 403:
 		return ErrInvalidAuth
@@ -200,10 +201,38 @@ type Download struct {
 
 func (c *Client) List(path string) ([]Item, error) {
 	path = fixSlashes(path)
+	items, err := c.propfind(path, "1")
+	if err != nil {
+		return nil, err
+	}
+	// We want only the children, not the directory itself
+	children := items[:0]
+	for _, item := range items {
+		if item.Href != path {
+			children = append(children, item)
+		}
+	}
+	return children, nil
+}
+
+// Stat returns the properties of the file or directory at path, like its
+// ETag.
+func (c *Client) Stat(path string) (*Item, error) {
+	items, err := c.propfind(fixSlashes(path), "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	return &items[0], nil
+}
+
+func (c *Client) propfind(path, depth string) ([]Item, error) {
 	headers := map[string]string{
 		"Content-Type": "application/xml;charset=UTF-8",
 		"Accept":       "application/xml",
-		"Depth":        "1",
+		"Depth":        depth,
 	}
 	payload := strings.NewReader(ListFilesPayload)
 	res, err := c.req("PROPFIND", path, 0, headers, payload)
@@ -235,7 +264,6 @@ func (c *Client) List(path string) ([]Item, error) {
 
 	var items []Item
 	for _, response := range multistatus.Responses {
-		// We want only the children, not the directory itself
 		parts := strings.Split(strings.TrimPrefix(response.Href, c.BasePath), "/")
 		for i, part := range parts {
 			if p, err := url.PathUnescape(part); err == nil {
@@ -243,9 +271,6 @@ func (c *Client) List(path string) ([]Item, error) {
 			}
 		}
 		href := strings.Join(parts, "/")
-		if href == path {
-			continue
-		}
 
 		for _, props := range response.Props {
 			// Only looks for the HTTP/1.1 200 OK status
@@ -265,6 +290,7 @@ func (c *Client) List(path string) ([]Item, error) {
 			}
 			if props.Type.Local == "" {
 				item.Type = "file"
+				item.MD5 = md5FromChecksums(props.Checksums)
 				if props.Size != "" {
 					if size, err := strconv.ParseUint(props.Size, 10, 64); err == nil {
 						item.Size = size
@@ -353,6 +379,20 @@ type Item struct {
 	ContentType  string
 	LastModified string
 	ETag         string
+	// MD5 is the checksum of the file in hexadecimal, when Nextcloud knows
+	// it (the checksums are only computed for some uploads).
+	MD5 string
+}
+
+// md5FromChecksums returns the MD5 checksum from the oc:checksums property,
+// like "SHA1:f572d3... MD5:0c7d7b... ADLER32:2f8a0b...".
+func md5FromChecksums(checksums string) string {
+	for _, checksum := range strings.Fields(checksums) {
+		if algo, sum, ok := strings.Cut(checksum, ":"); ok && strings.EqualFold(algo, "MD5") {
+			return strings.ToLower(sum)
+		}
+	}
+	return ""
 }
 
 type multistatus struct {
@@ -377,6 +417,7 @@ type props struct {
 	LastModified string   `xml:"prop>getlastmodified"`
 	ETag         string   `xml:"prop>getetag"`
 	FileID       string   `xml:"prop>fileid"`
+	Checksums    string   `xml:"prop>checksums>checksum"`
 }
 
 const ListFilesPayload = `<?xml version="1.0"?>
@@ -389,6 +430,7 @@ const ListFilesPayload = `<?xml version="1.0"?>
         <d:getcontentlength />
         <d:getcontenttype />
         <oc:fileid />
+        <oc:checksums />
         <nc:trashbin-filename />
         <nc:trashbin-original-location />
   </d:prop>
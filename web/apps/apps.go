@@ -158,10 +158,10 @@ func downloadHandler(appType consts.AppType) echo.HandlerFunc {
 			if man.FromAppsDir {
 				fs = app.FSForAppDir(slug)
 			} else {
-				fs = app.AppsFileServer(inst)
+				fs = appfs.WithIntegrity(app.AppsFileServer(inst), man.Integrity())
 			}
 		case consts.KonnectorType:
-			fs = app.KonnectorsFileServer(inst)
+			fs = appfs.WithIntegrity(app.KonnectorsFileServer(inst), man.Integrity())
 		}
 
 		return fs.ServeCodeTarball(c.Response(), c.Request(), slug, version, man.Checksum())
@@ -254,12 +254,16 @@ func logsHandler(appType consts.AppType) echo.HandlerFunc {
 		}
 
 		clientSide := false
+		version := ""
 		if appType == consts.KonnectorType {
 			man, err := app.GetKonnectorBySlug(inst, slug)
 			if err != nil {
 				return wrapAppsError(err)
 			}
 			clientSide = man.ClientSide()
+			version = man.Version()
+		} else if man, err := app.GetWebappBySlug(inst, slug); err == nil {
+			version = man.Version()
 		}
 
 		var logs []AppLog
@@ -287,6 +291,12 @@ func logsHandler(appType consts.AppType) echo.HandlerFunc {
 			}
 
 			l.Log(level, log.Msg)
+
+			// The errors of the client side are a health signal for the
+			// rollout of the version.
+			if level == logger.ErrorLevel {
+				app.ReportFailure(inst.Domain, slug, version, limits.RolloutClientErrorType)
+			}
 		}
 
 		return c.NoContent(http.StatusNoContent)
@@ -675,11 +685,11 @@ func iconHandler(appType consts.AppType) echo.HandlerFunc {
 			if a.FromAppsDir {
 				fs = app.FSForAppDir(slug)
 			} else {
-				fs = app.AppsFileServer(instance)
+				fs = appfs.WithIntegrity(app.AppsFileServer(instance), a.Integrity())
 			}
 		case consts.KonnectorType:
 			filepath = path.Join("/", a.Icon())
-			fs = app.KonnectorsFileServer(instance)
+			fs = appfs.WithIntegrity(app.KonnectorsFileServer(instance), a.Integrity())
 		}
 
 		err = fs.ServeFileContent(c.Response(), c.Request(),
@@ -851,7 +861,7 @@ func wrapAppsError(err error) error {
 	switch err {
 	case app.ErrInvalidSlugName:
 		return jsonapi.InvalidParameter("slug", err)
-	case app.ErrAlreadyExists:
+	case app.ErrAlreadyExists, app.ErrDeferredByRollout:
 		return jsonapi.Conflict(err)
 	case app.ErrNotFound:
 		return jsonapi.NotFound(err)
@@ -865,8 +875,12 @@ func wrapAppsError(err error) error {
 		return jsonapi.BadRequest(err)
 	case app.ErrMissingSource:
 		return jsonapi.BadRequest(err)
-	case app.ErrLinkedAppExists:
+	case app.ErrLinkedAppExists, app.ErrNoPreviousVersion:
 		return jsonapi.BadRequest(err)
+	case app.ErrRolloutNotFound:
+		return jsonapi.NotFound(err)
+	case app.ErrUnsignedPackage, app.ErrBadSignature:
+		return jsonapi.Forbidden(err)
 	case limits.ErrRateLimitReached,
 		limits.ErrRateLimitExceeded:
 		return jsonapi.BadRequest(err)
@@ -0,0 +1,123 @@
+package apps
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+type apiRollout struct {
+	*app.Rollout
+}
+
+// Links is part of the jsonapi.Object interface
+func (r *apiRollout) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (r *apiRollout) Relationships() jsonapi.RelationshipMap {
+	return jsonapi.RelationshipMap{}
+}
+
+// Included is part of the jsonapi.Object interface
+func (r *apiRollout) Included() []jsonapi.Object { return nil }
+
+// apiRollout is a jsonapi.Object
+var _ jsonapi.Object = (*apiRollout)(nil)
+
+func wrapRolloutError(err error) error {
+	if errors.Is(err, app.ErrInvalidRollout) {
+		return jsonapi.BadRequest(err)
+	}
+	return wrapAppsError(err)
+}
+
+func listRollouts(c echo.Context) error {
+	list, err := app.ListRollouts()
+	if err != nil {
+		return err
+	}
+	objs := make([]jsonapi.Object, len(list))
+	for i, r := range list {
+		objs[i] = &apiRollout{r}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func getRollout(c echo.Context) error {
+	r, err := app.GetRollout(c.Param("slug"), c.QueryParam("Context"))
+	if err != nil {
+		return wrapRolloutError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiRollout{r}, nil)
+}
+
+func putRollout(c echo.Context) error {
+	var r app.Rollout
+	if _, err := jsonapi.Bind(c.Request().Body, &r); err != nil {
+		return jsonapi.BadJSON()
+	}
+	r.Slug = c.Param("slug")
+	r.Context = c.QueryParam("Context")
+	if err := app.SaveRollout(&r); err != nil {
+		return wrapRolloutError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiRollout{&r}, nil)
+}
+
+func deleteRollout(c echo.Context) error {
+	if err := app.DeleteRollout(c.Param("slug"), c.QueryParam("Context")); err != nil {
+		return wrapRolloutError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func setRolloutState(state string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reason := c.QueryParam("Reason")
+		r, err := app.SetRolloutState(c.Param("slug"), c.QueryParam("Context"), state, reason)
+		if err != nil {
+			return wrapRolloutError(err)
+		}
+		return jsonapi.Data(c, http.StatusOK, &apiRollout{r}, nil)
+	}
+}
+
+// rollbackInstance rolls back an application to its previous version for a
+// single instance, without waiting for the instance to be used.
+func rollbackInstance(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.QueryParam("Domain"))
+	if err != nil {
+		return jsonapi.NotFound(err)
+	}
+	appType := consts.WebappType
+	if c.QueryParam("Type") == "konnector" {
+		appType = consts.KonnectorType
+	}
+	man, err := app.GetBySlug(inst, c.Param("slug"), appType)
+	if err != nil {
+		return wrapAppsError(err)
+	}
+	man, err = app.Rollback(inst, man)
+	if err != nil {
+		return wrapAppsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiApp{man}, nil)
+}
+
+// RolloutsAdminRoutes sets the routing for the admin interface to configure
+// the staged rollouts of the updates of the applications.
+func RolloutsAdminRoutes(router *echo.Group) {
+	router.GET("/rollouts", listRollouts)
+	router.GET("/rollouts/:slug", getRollout)
+	router.PUT("/rollouts/:slug", putRollout)
+	router.DELETE("/rollouts/:slug", deleteRollout)
+	router.POST("/rollouts/:slug/pause", setRolloutState(app.RolloutPaused))
+	router.POST("/rollouts/:slug/resume", setRolloutState(app.RolloutRunning))
+	router.POST("/rollouts/:slug/rollback", setRolloutState(app.RolloutRolledBack))
+	router.POST("/:slug/rollback", rollbackInstance)
+}
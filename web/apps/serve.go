@@ -4,18 +4,21 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/feature"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/intent"
+	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/session"
 	csettings "github.com/cozy/cozy-stack/model/settings"
@@ -26,6 +29,8 @@ import (
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/mail"
 	"github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/cozy/cozy-stack/web/auth"
 	"github.com/cozy/cozy-stack/web/middlewares"
@@ -83,7 +88,8 @@ func Serve(c echo.Context) error {
 		}
 		fallthrough
 	case app.Ready:
-		return ServeAppFile(c, i, app.AppsFileServer(i), webapp)
+		fs := appfs.WithIntegrity(app.AppsFileServer(i), webapp.Integrity())
+		return ServeAppFile(c, i, fs, webapp)
 	default:
 		return echo.NewHTTPError(http.StatusServiceUnavailable, "Application is not ready")
 	}
@@ -145,6 +151,57 @@ func handleIntent(c echo.Context, i *instance.Instance, slug, intentID string) {
 	}
 }
 
+// setAppCSP adds the sources of the webapp to the CSP policy of its index, and
+// the endpoint where the browsers report the violations.
+func setAppCSP(c echo.Context, i *instance.Instance, webapp *app.WebappManifest) {
+	if config.GetConfig().CSPDisabled {
+		return
+	}
+	policy := webapp.CSPPolicy()
+	enforced := c.Response().Header().Get(echo.HeaderContentSecurityPolicy)
+	directives := make([]string, 0, len(policy.Sources))
+	for directive := range policy.Sources {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+	for _, directive := range directives {
+		middlewares.AppendCSPSources(c, directive, policy.Sources[directive]...)
+	}
+	if policy.ReportOnly {
+		middlewares.SetCSPReportOnly(c, enforced)
+	}
+	reportURL := i.PageURL("/csp/report", url.Values{"slug": {webapp.Slug()}})
+	middlewares.SetCSPReporting(c, reportURL)
+}
+
+// refuseTamperedFile logs the error for a file of a webapp that does not
+// match its integrity manifest, sends an alert to the administrator, and
+// returns the error for the client.
+func refuseTamperedFile(i *instance.Instance, slug, version string, err error) error {
+	i.Logger().WithNamespace("apps").
+		Errorf("Refusing to serve a file of %s %s: %s", slug, version, err)
+	alert := config.GetConfig().AlertAddr
+	limit := config.GetRateLimiter().CheckRateLimit(i, limits.AppIntegrityAlertType)
+	if alert != "" && limit == nil {
+		msg, errm := job.NewMessage(mail.Options{
+			Mode:    mail.ModeFromUser,
+			To:      []*mail.Address{{Name: "Support", Email: alert}},
+			Subject: "Tampered application file on " + i.Domain,
+			Parts: []*mail.Part{{
+				Type: "text/plain",
+				Body: fmt.Sprintf("Instance: %s\nApplication: %s %s\n\n%s\n", i.Domain, slug, version, err),
+			}},
+		})
+		if errm == nil {
+			_, _ = job.System().PushJob(i, &job.JobRequest{
+				WorkerType: "sendmail",
+				Message:    msg,
+			})
+		}
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "The file has been modified since the installation of the application")
+}
+
 // ServeAppFile will serve the requested file using the specified application
 // manifest and appfs.FileServer context.
 //
@@ -234,6 +291,9 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 			}
 			return echo.NewHTTPError(http.StatusNotFound, "Asset not found")
 		}
+		if errors.Is(err, appfs.ErrTampered) {
+			return refuseTamperedFile(i, slug, version, err)
+		}
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err)
 		}
@@ -273,10 +333,14 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 		// stack for cleaning.
 		middlewares.AppendCSPRule(c, "frame-ancestors", i.PageURL("/", nil))
 	}
+	setAppCSP(c, i, webapp)
 
 	// For index file, we inject the locale, the stack domain, and a token if the
 	// user is connected
 	content, err := fs.Open(slug, version, shasum, filepath)
+	if errors.Is(err, appfs.ErrTampered) {
+		return refuseTamperedFile(i, slug, version, err)
+	}
 	if err != nil {
 		return err
 	}
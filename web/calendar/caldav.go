@@ -0,0 +1,1017 @@
+package calendar
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/ical"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	davNS       = "DAV:"
+	caldavNS    = "urn:ietf:params:xml:ns:caldav"
+	calserverNS = "http://calendarserver.org/ns/"
+	appleNS     = "http://apple.com/ns/ical/"
+
+	xmlContentType = "application/xml; charset=utf-8"
+
+	principalPath = "/dav/principals/me/"
+	homePath      = "/dav/calendars/"
+
+	// maxResourceSize is the maximal size of a CalDAV resource sent by a
+	// client.
+	maxResourceSize = 1 << 20
+)
+
+var prefixes = map[string]string{
+	davNS:       "d",
+	caldavNS:    "c",
+	calserverNS: "cs",
+	appleNS:     "ic",
+}
+
+func dav(local string) xml.Name       { return xml.Name{Space: davNS, Local: local} }
+func caldav(local string) xml.Name    { return xml.Name{Space: caldavNS, Local: local} }
+func calserver(local string) xml.Name { return xml.Name{Space: calserverNS, Local: local} }
+func apple(local string) xml.Name     { return xml.Name{Space: appleNS, Local: local} }
+
+// prop is a property of a PROPFIND or PROPPATCH request, with its raw value.
+type prop struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+func (p prop) text() string {
+	var s struct {
+		Text string `xml:",chardata"`
+	}
+	_ = xml.Unmarshal([]byte("<x>"+p.Inner+"</x>"), &s)
+	return strings.TrimSpace(s.Text)
+}
+
+type propList struct {
+	Props []prop `xml:",any"`
+}
+
+func (l *propList) names() []xml.Name {
+	if l == nil {
+		return nil
+	}
+	names := make([]xml.Name, len(l.Props))
+	for i, p := range l.Props {
+		names[i] = p.XMLName
+	}
+	return names
+}
+
+type propfindRequest struct {
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *propList `xml:"DAV: prop"`
+}
+
+type propertyUpdate struct {
+	Set    []propList `xml:"DAV: set>prop"`
+	Remove []propList `xml:"DAV: remove>prop"`
+}
+
+type timeRangeFilter struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type compFilter struct {
+	Name      string           `xml:"name,attr"`
+	TimeRange *timeRangeFilter `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	Comps     []compFilter     `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type reportRequest struct {
+	XMLName   xml.Name
+	Prop      *propList        `xml:"DAV: prop"`
+	Hrefs     []string         `xml:"DAV: href"`
+	Filter    *compFilter      `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+	TimeRange *timeRangeFilter `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+// response is a response of a multistatus, with the properties grouped by
+// status.
+type response struct {
+	Href      string
+	Status    int
+	Propstats map[int][]string
+}
+
+func (r *response) render(sb *strings.Builder) {
+	sb.WriteString("<d:response><d:href>")
+	sb.WriteString(escape(r.Href))
+	sb.WriteString("</d:href>")
+	if r.Status != 0 {
+		sb.WriteString("<d:status>" + statusLine(r.Status) + "</d:status>")
+	}
+	for _, status := range []int{http.StatusOK, http.StatusForbidden, http.StatusNotFound, http.StatusFailedDependency} {
+		props := r.Propstats[status]
+		if len(props) == 0 {
+			continue
+		}
+		sb.WriteString("<d:propstat><d:prop>")
+		for _, p := range props {
+			sb.WriteString(p)
+		}
+		sb.WriteString("</d:prop><d:status>" + statusLine(status) + "</d:status></d:propstat>")
+	}
+	sb.WriteString("</d:response>")
+}
+
+// propGetter returns the raw XML value of a property, and false if the
+// resource does not have this property.
+type propGetter func(name xml.Name) (string, bool)
+
+func newResponse(href string, names []xml.Name, get propGetter) *response {
+	r := &response{Href: href, Propstats: make(map[int][]string)}
+	for _, name := range names {
+		if value, ok := get(name); ok {
+			r.Propstats[http.StatusOK] = append(r.Propstats[http.StatusOK], element(name, value))
+		} else {
+			r.Propstats[http.StatusNotFound] = append(r.Propstats[http.StatusNotFound], element(name, ""))
+		}
+	}
+	return r
+}
+
+func element(name xml.Name, value string) string {
+	prefix, ok := prefixes[name.Space]
+	open := prefix + ":" + name.Local
+	if !ok {
+		prefix = "x"
+		open = "x:" + name.Local + ` xmlns:x="` + escape(name.Space) + `"`
+	}
+	if value == "" {
+		return "<" + open + "/>"
+	}
+	return "<" + open + ">" + value + "</" + prefix + ":" + name.Local + ">"
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func href(p string) string {
+	return "<d:href>" + escape(p) + "</d:href>"
+}
+
+func statusLine(code int) string {
+	return "HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code)
+}
+
+func writeMultistatus(c echo.Context, responses []*response) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"` +
+		` xmlns:cs="http://calendarserver.org/ns/" xmlns:ic="http://apple.com/ns/ical/">`)
+	for _, r := range responses {
+		r.render(&sb)
+	}
+	sb.WriteString("</d:multistatus>")
+	return c.Blob(http.StatusMultiStatus, xmlContentType, []byte(sb.String()))
+}
+
+// writePrecondition sends an error with the precondition that has failed.
+func writePrecondition(c echo.Context, status int, name xml.Name) error {
+	body := xml.Header + `<d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+		element(name, "") + "</d:error>"
+	return c.Blob(status, xmlContentType, []byte(body))
+}
+
+func calendarHref(id string) string {
+	return homePath + url.PathEscape(id) + "/"
+}
+
+func objectHref(calendarID, filename string) string {
+	return calendarHref(calendarID) + url.PathEscape(filename)
+}
+
+func pathParam(c echo.Context, name string) string {
+	value := c.Param(name)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+// needPermission asks the CalDAV clients to send their credentials: the
+// token is used as the password of the basic authentication.
+func needPermission(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, err := middlewares.GetPermission(c); err != nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Cozy"`)
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return next(c)
+	}
+}
+
+func allowed(c echo.Context, verb permission.Verb, o permission.Fetcher) bool {
+	return middlewares.Allow(c, verb, o) == nil
+}
+
+func davOptions(c echo.Context) error {
+	h := c.Response().Header()
+	h.Set("DAV", "1, 3, calendar-access")
+	h.Set(echo.HeaderAllow, "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, PROPPATCH, REPORT, MKCALENDAR")
+	return c.NoContent(http.StatusOK)
+}
+
+// requestedProps returns the properties asked by a PROPFIND, or the default
+// ones for allprop and an empty body.
+func requestedProps(c echo.Context, defaults []xml.Name) ([]xml.Name, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxResourceSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return defaults, nil
+	}
+	var req propfindRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req.Prop == nil {
+		return defaults, nil
+	}
+	return req.Prop.names(), nil
+}
+
+func depth(c echo.Context) int {
+	if c.Request().Header.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+// commonProp returns the properties that the clients can ask on every
+// resource to discover the principal and the calendar home.
+func commonProp(name xml.Name) (string, bool) {
+	switch name {
+	case dav("current-user-principal"), dav("principal-URL"), dav("owner"):
+		return href(principalPath), true
+	case caldav("calendar-home-set"):
+		return href(homePath), true
+	}
+	return "", false
+}
+
+func propfindRoot(c echo.Context) error {
+	names, err := requestedProps(c, []xml.Name{dav("resourcetype"), dav("current-user-principal")})
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	r := newResponse(c.Request().URL.Path, names, func(name xml.Name) (string, bool) {
+		if name == dav("resourcetype") {
+			return "<d:collection/>", true
+		}
+		return commonProp(name)
+	})
+	return writeMultistatus(c, []*response{r})
+}
+
+func propfindPrincipal(c echo.Context) error {
+	names, err := requestedProps(c, []xml.Name{
+		dav("resourcetype"), dav("displayname"), dav("current-user-principal"),
+		caldav("calendar-home-set"), caldav("calendar-user-address-set"),
+	})
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	inst := middlewares.GetInstance(c)
+	r := newResponse(principalPath, names, func(name xml.Name) (string, bool) {
+		switch name {
+		case dav("resourcetype"):
+			return "<d:principal/>", true
+		case dav("displayname"):
+			publicName, _ := inst.SettingsPublicName()
+			return escape(publicName), publicName != ""
+		case caldav("calendar-user-address-set"):
+			email, _ := inst.SettingsEMail()
+			return href("mailto:" + email), email != ""
+		}
+		return commonProp(name)
+	})
+	return writeMultistatus(c, []*response{r})
+}
+
+var calendarProps = []xml.Name{
+	dav("resourcetype"), dav("displayname"), dav("getetag"), dav("current-user-privilege-set"),
+	caldav("calendar-description"), caldav("supported-calendar-component-set"),
+	calserver("getctag"), apple("calendar-color"),
+}
+
+func propfindHome(c echo.Context) error {
+	names, err := requestedProps(c, calendarProps)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	responses := []*response{
+		newResponse(homePath, names, func(name xml.Name) (string, bool) {
+			switch name {
+			case dav("resourcetype"):
+				return "<d:collection/>", true
+			case dav("displayname"):
+				return "Calendars", true
+			}
+			return commonProp(name)
+		}),
+	}
+	if depth(c) > 0 {
+		inst := middlewares.GetInstance(c)
+		calendars, err := calendar.EnsureDefault(inst)
+		if err != nil {
+			return err
+		}
+		for _, cal := range calendars {
+			if allowed(c, permission.GET, cal) {
+				responses = append(responses, newResponse(calendarHref(cal.ID()), names, calendarGetter(c, cal)))
+			}
+		}
+	}
+	return writeMultistatus(c, responses)
+}
+
+func calendarGetter(c echo.Context, cal *calendar.Calendar) propGetter {
+	inst := middlewares.GetInstance(c)
+	var ctag string
+	getCTag := func() (string, bool) {
+		if ctag == "" {
+			var err error
+			if ctag, err = cal.CTag(inst); err != nil {
+				return "", false
+			}
+		}
+		return ctag, true
+	}
+	return func(name xml.Name) (string, bool) {
+		switch name {
+		case dav("resourcetype"):
+			return "<d:collection/><c:calendar/>", true
+		case dav("displayname"):
+			return escape(cal.Name), true
+		case caldav("calendar-description"):
+			return escape(cal.Description), cal.Description != ""
+		case apple("calendar-color"):
+			return escape(cal.Color), cal.Color != ""
+		case caldav("calendar-timezone"):
+			if cal.Timezone == "" {
+				return "", false
+			}
+			var buf bytes.Buffer
+			vcal := ical.NewComponent("VCALENDAR")
+			vcal.Add(ical.NewProperty("VERSION", "2.0"))
+			vcal.Add(ical.NewProperty("PRODID", calendar.ProdID))
+			vcal.AddComponent(ical.VTimezone(cal.Location(), time.Now().Year()))
+			if err := ical.Encode(&buf, vcal); err != nil {
+				return "", false
+			}
+			return escape(buf.String()), true
+		case caldav("supported-calendar-component-set"):
+			return `<c:comp name="VEVENT"/><c:comp name="VTODO"/>`, true
+		case caldav("supported-calendar-data"):
+			return `<c:calendar-data content-type="text/calendar" version="2.0"/>`, true
+		case caldav("max-resource-size"):
+			return strconv.Itoa(maxResourceSize), true
+		case dav("supported-report-set"):
+			return "<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:free-busy-query/></d:report></d:supported-report>", true
+		case dav("current-user-privilege-set"):
+			privileges := "<d:privilege><d:read/></d:privilege>" +
+				"<d:privilege><c:read-free-busy/></d:privilege>"
+			if allowed(c, permission.PUT, &calendar.Event{CalendarID: cal.ID()}) {
+				privileges += "<d:privilege><d:write/></d:privilege>" +
+					"<d:privilege><d:write-content/></d:privilege>" +
+					"<d:privilege><d:bind/></d:privilege>" +
+					"<d:privilege><d:unbind/></d:privilege>"
+			}
+			if allowed(c, permission.PATCH, cal) {
+				privileges += "<d:privilege><d:write-properties/></d:privilege>"
+			}
+			return privileges, true
+		case calserver("getctag"):
+			return getCTag()
+		case dav("getetag"):
+			tag, ok := getCTag()
+			return escape(`"` + tag + `"`), ok
+		}
+		return commonProp(name)
+	}
+}
+
+func objectGetter(obj *calendar.Object) propGetter {
+	var data []byte
+	encode := func() ([]byte, bool) {
+		if data == nil {
+			var buf bytes.Buffer
+			if err := calendar.WriteObject(&buf, obj); err != nil {
+				return nil, false
+			}
+			data = buf.Bytes()
+		}
+		return data, true
+	}
+	return func(name xml.Name) (string, bool) {
+		switch name {
+		case dav("resourcetype"):
+			return "", true
+		case dav("getetag"):
+			return escape(obj.ETag()), true
+		case dav("getcontenttype"):
+			return escape(objectContentType(obj)), true
+		case dav("getlastmodified"):
+			return obj.UpdatedAt().UTC().Format(http.TimeFormat), true
+		case dav("getcontentlength"):
+			b, ok := encode()
+			return strconv.Itoa(len(b)), ok
+		case caldav("calendar-data"):
+			b, ok := encode()
+			return escape(string(b)), ok
+		}
+		return "", false
+	}
+}
+
+func objectContentType(obj *calendar.Object) string {
+	if obj.Event != nil {
+		return "text/calendar; charset=utf-8; component=VEVENT"
+	}
+	return "text/calendar; charset=utf-8; component=VTODO"
+}
+
+// objectDoc returns the document of an object, for checking the
+// permissions.
+func objectDoc(obj *calendar.Object) permission.Fetcher {
+	if obj.Event != nil {
+		return obj.Event
+	}
+	return obj.Todo
+}
+
+// findDAVCalendar returns the calendar of the URL, or sends an error.
+func findDAVCalendar(c echo.Context, verb permission.Verb) (*calendar.Calendar, error) {
+	inst := middlewares.GetInstance(c)
+	cal, err := calendar.Get(inst, pathParam(c, "id"))
+	if couchdb.IsNotFoundError(err) {
+		return nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(c, verb, cal) {
+		return nil, echo.NewHTTPError(http.StatusForbidden)
+	}
+	return cal, nil
+}
+
+// visibleObjects returns the events and tasks of the calendar that can be
+// read with the permissions of the request.
+func visibleObjects(c echo.Context, objects []*calendar.Object) []*calendar.Object {
+	var visible []*calendar.Object
+	for _, obj := range objects {
+		if allowed(c, permission.GET, objectDoc(obj)) {
+			visible = append(visible, obj)
+		}
+	}
+	return visible
+}
+
+func propfindCalendar(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	names, err := requestedProps(c, calendarProps)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	responses := []*response{newResponse(calendarHref(cal.ID()), names, calendarGetter(c, cal))}
+	if depth(c) > 0 {
+		inst := middlewares.GetInstance(c)
+		objects, err := calendar.ListObjects(inst, cal.ID())
+		if err != nil {
+			return err
+		}
+		for _, obj := range visibleObjects(c, objects) {
+			responses = append(responses, newResponse(objectHref(cal.ID(), obj.Filename()), names, objectGetter(obj)))
+		}
+	}
+	return writeMultistatus(c, responses)
+}
+
+func proppatchCalendar(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.PATCH)
+	if err != nil {
+		return err
+	}
+	var update propertyUpdate
+	if err := xml.NewDecoder(io.LimitReader(c.Request().Body, maxResourceSize)).Decode(&update); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var applied, rejected []xml.Name
+	apply := func(p prop, value string) {
+		switch p.XMLName {
+		case dav("displayname"):
+			if value == "" {
+				rejected = append(rejected, p.XMLName)
+				return
+			}
+			cal.Name = value
+		case caldav("calendar-description"):
+			cal.Description = value
+		case apple("calendar-color"):
+			cal.Color = value
+		case caldav("calendar-timezone"):
+			tz := ""
+			if value != "" {
+				vcal, err := ical.Parse(strings.NewReader(value))
+				if err != nil || len(vcal.Children("VTIMEZONE")) == 0 {
+					rejected = append(rejected, p.XMLName)
+					return
+				}
+				loc := ical.LoadLocation(vcal.Children("VTIMEZONE")[0].Text("TZID"))
+				if loc == nil {
+					rejected = append(rejected, p.XMLName)
+					return
+				}
+				tz = loc.String()
+			}
+			cal.Timezone = tz
+		default:
+			rejected = append(rejected, p.XMLName)
+			return
+		}
+		applied = append(applied, p.XMLName)
+	}
+	for _, list := range update.Set {
+		for _, p := range list.Props {
+			apply(p, p.text())
+		}
+	}
+	for _, list := range update.Remove {
+		for _, p := range list.Props {
+			apply(p, "")
+		}
+	}
+
+	r := &response{Href: calendarHref(cal.ID()), Propstats: make(map[int][]string)}
+	status := http.StatusOK
+	if len(rejected) > 0 {
+		status = http.StatusFailedDependency
+		for _, name := range rejected {
+			r.Propstats[http.StatusForbidden] = append(r.Propstats[http.StatusForbidden], element(name, ""))
+		}
+	} else {
+		inst := middlewares.GetInstance(c)
+		if err := cal.Update(inst); err != nil {
+			return err
+		}
+	}
+	for _, name := range applied {
+		r.Propstats[status] = append(r.Propstats[status], element(name, ""))
+	}
+	return writeMultistatus(c, []*response{r})
+}
+
+func mkcalendar(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Calendars); err != nil {
+		return c.NoContent(http.StatusForbidden)
+	}
+	inst := middlewares.GetInstance(c)
+	id := pathParam(c, "id")
+	if _, err := calendar.Get(inst, id); err == nil {
+		return c.NoContent(http.StatusMethodNotAllowed)
+	}
+	cal := &calendar.Calendar{DocID: id, Name: id}
+	var req struct {
+		Set []propList `xml:"DAV: set>prop"`
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxResourceSize))
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+	}
+	for _, list := range req.Set {
+		for _, p := range list.Props {
+			switch p.XMLName {
+			case dav("displayname"):
+				if name := p.text(); name != "" {
+					cal.Name = name
+				}
+			case caldav("calendar-description"):
+				cal.Description = p.text()
+			case apple("calendar-color"):
+				cal.Color = p.text()
+			case caldav("calendar-timezone"):
+				if vcal, err := ical.Parse(strings.NewReader(p.text())); err == nil {
+					for _, tz := range vcal.Children("VTIMEZONE") {
+						if loc := ical.LoadLocation(tz.Text("TZID")); loc != nil {
+							cal.Timezone = loc.String()
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := calendar.Create(inst, cal); err != nil {
+		if err == calendar.ErrInvalidCalendar {
+			return c.NoContent(http.StatusForbidden)
+		}
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, calendarHref(cal.ID()))
+	return c.NoContent(http.StatusCreated)
+}
+
+func deleteDAVCalendar(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.CalendarEvents); err != nil {
+		return c.NoContent(http.StatusForbidden)
+	}
+	inst := middlewares.GetInstance(c)
+	if err := cal.Delete(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func reportCalendar(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	var req reportRequest
+	if err := xml.NewDecoder(io.LimitReader(c.Request().Body, maxResourceSize)).Decode(&req); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	inst := middlewares.GetInstance(c)
+	names := req.Prop.names()
+	if len(names) == 0 {
+		names = []xml.Name{dav("getetag")}
+	}
+
+	switch req.XMLName {
+	case caldav("calendar-multiget"):
+		var responses []*response
+		for _, h := range req.Hrefs {
+			filename, ok := hrefFilename(h, cal.ID())
+			if !ok {
+				responses = append(responses, &response{Href: h, Status: http.StatusNotFound})
+				continue
+			}
+			obj, err := calendar.FindObject(inst, cal.ID(), filename)
+			if err == calendar.ErrObjectNotFound || (err == nil && !allowed(c, permission.GET, objectDoc(obj))) {
+				responses = append(responses, &response{Href: h, Status: http.StatusNotFound})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			responses = append(responses, newResponse(objectHref(cal.ID(), obj.Filename()), names, objectGetter(obj)))
+		}
+		return writeMultistatus(c, responses)
+
+	case caldav("calendar-query"):
+		objects, err := queryObjects(inst, cal, req.Filter)
+		if err != nil {
+			return err
+		}
+		responses := []*response{}
+		for _, obj := range visibleObjects(c, objects) {
+			responses = append(responses, newResponse(objectHref(cal.ID(), obj.Filename()), names, objectGetter(obj)))
+		}
+		return writeMultistatus(c, responses)
+
+	case caldav("free-busy-query"):
+		if !allowed(c, permission.GET, &calendar.Event{CalendarID: cal.ID()}) {
+			return c.NoContent(http.StatusForbidden)
+		}
+		if req.TimeRange == nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		from, to, err := parseTimeRange(req.TimeRange)
+		if err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		periods, err := calendar.FreeBusy(inst, []*calendar.Calendar{cal}, from, to)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := ical.Encode(&buf, calendar.FreeBusyComponent(periods, from, to)); err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, calendar.ContentType, buf.Bytes())
+	}
+	return writePrecondition(c, http.StatusForbidden, dav("supported-report"))
+}
+
+// queryObjects returns the objects of a calendar that match the filter of a
+// calendar-query. Only the component names and the time ranges are used.
+func queryObjects(inst *instance.Instance, cal *calendar.Calendar, filter *compFilter) ([]*calendar.Object, error) {
+	if filter == nil || len(filter.Comps) == 0 {
+		return calendar.ListObjects(inst, cal.ID())
+	}
+	var objects []*calendar.Object
+	for _, comp := range filter.Comps {
+		var from, to time.Time
+		if comp.TimeRange != nil {
+			var err error
+			if from, to, err = parseTimeRange(comp.TimeRange); err != nil {
+				return nil, err
+			}
+		}
+		switch strings.ToUpper(comp.Name) {
+		case "VEVENT":
+			var events []*calendar.Event
+			var err error
+			if comp.TimeRange != nil {
+				events, err = calendar.FindEvents(inst, cal.ID(), from, to)
+			} else {
+				events, err = calendar.FindAllEvents(inst, cal.ID())
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range events {
+				if comp.TimeRange == nil || len(e.Occurrences(from, to)) > 0 {
+					objects = append(objects, &calendar.Object{Event: e})
+				}
+			}
+		case "VTODO":
+			todos, err := calendar.FindAllTodos(inst, cal.ID())
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range todos {
+				if comp.TimeRange != nil {
+					if t.Due != nil && t.Due.Before(from) {
+						continue
+					}
+					if t.Start != nil && !t.Start.Before(to) {
+						continue
+					}
+				}
+				objects = append(objects, &calendar.Object{Todo: t})
+			}
+		}
+	}
+	return objects, nil
+}
+
+func parseTimeRange(tr *timeRangeFilter) (time.Time, time.Time, error) {
+	const layout = "20060102T150405Z"
+	from := time.Time{}
+	to := time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	var err error
+	if tr.Start != "" {
+		if from, err = time.Parse(layout, tr.Start); err != nil {
+			return from, to, err
+		}
+	}
+	if tr.End != "" {
+		if to, err = time.Parse(layout, tr.End); err != nil {
+			return from, to, err
+		}
+	}
+	if !to.After(from) {
+		return from, to, errors.New("Invalid time range")
+	}
+	return from, to, nil
+}
+
+// hrefFilename returns the name of the resource for an href of a
+// calendar-multiget, if it is in the calendar.
+func hrefFilename(h, calendarID string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(h))
+	if err != nil {
+		return "", false
+	}
+	dir, file := path.Split(u.Path)
+	if file == "" || dir != calendarHref(calendarID) && dir != homePath+calendarID+"/" {
+		return "", false
+	}
+	return file, true
+}
+
+func findDAVObject(c echo.Context, cal *calendar.Calendar) (*calendar.Object, error) {
+	inst := middlewares.GetInstance(c)
+	obj, err := calendar.FindObject(inst, cal.ID(), pathParam(c, "object"))
+	if err == calendar.ErrObjectNotFound {
+		return nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	return obj, err
+}
+
+func getObject(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	obj, err := findDAVObject(c, cal)
+	if err != nil {
+		return err
+	}
+	if !allowed(c, permission.GET, objectDoc(obj)) {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var buf bytes.Buffer
+	if err := calendar.WriteObject(&buf, obj); err != nil {
+		return err
+	}
+	h := c.Response().Header()
+	h.Set(echo.HeaderLastModified, obj.UpdatedAt().UTC().Format(http.TimeFormat))
+	h.Set("ETag", obj.ETag())
+	return c.Blob(http.StatusOK, objectContentType(obj), buf.Bytes())
+}
+
+func propfindObject(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	obj, err := findDAVObject(c, cal)
+	if err != nil {
+		return err
+	}
+	if !allowed(c, permission.GET, objectDoc(obj)) {
+		return c.NoContent(http.StatusForbidden)
+	}
+	names, err := requestedProps(c, []xml.Name{
+		dav("resourcetype"), dav("getetag"), dav("getcontenttype"), dav("getlastmodified"),
+	})
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	r := newResponse(objectHref(cal.ID(), obj.Filename()), names, objectGetter(obj))
+	return writeMultistatus(c, []*response{r})
+}
+
+func putObject(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxResourceSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxResourceSize {
+		return writePrecondition(c, http.StatusForbidden, caldav("max-resource-size"))
+	}
+	obj, err := calendar.ParseObject(bytes.NewReader(body), cal)
+	if err != nil {
+		return writePrecondition(c, http.StatusForbidden, caldav("valid-calendar-data"))
+	}
+
+	inst := middlewares.GetInstance(c)
+	filename := pathParam(c, "object")
+	existing, err := calendar.FindObject(inst, cal.ID(), filename)
+	if err == calendar.ErrObjectNotFound {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if !checkPreconditions(c, existing) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+
+	if obj.Event != nil {
+		obj.Event.CalendarID = cal.ID()
+	} else {
+		obj.Todo.CalendarID = cal.ID()
+	}
+	if existing != nil {
+		if !allowed(c, permission.PUT, objectDoc(existing)) {
+			return c.NoContent(http.StatusForbidden)
+		}
+	}
+	verb := permission.PUT
+	if existing == nil {
+		verb = permission.POST
+	}
+	if !allowed(c, verb, objectDoc(obj)) {
+		return c.NoContent(http.StatusForbidden)
+	}
+
+	switch err := calendar.SaveObject(inst, cal, filename, obj, existing); err {
+	case nil:
+	case calendar.ErrUIDConflict:
+		return writePrecondition(c, http.StatusForbidden, caldav("no-uid-conflict"))
+	case calendar.ErrInvalidEvent:
+		return writePrecondition(c, http.StatusForbidden, caldav("valid-calendar-object-resource"))
+	default:
+		if couchdb.IsConflictError(err) {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		return err
+	}
+	c.Response().Header().Set("ETag", obj.ETag())
+	if existing == nil {
+		return c.NoContent(http.StatusCreated)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func deleteObject(c echo.Context) error {
+	cal, err := findDAVCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	obj, err := findDAVObject(c, cal)
+	if err != nil {
+		return err
+	}
+	if !checkPreconditions(c, obj) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	if !allowed(c, permission.DELETE, objectDoc(obj)) {
+		return c.NoContent(http.StatusForbidden)
+	}
+	inst := middlewares.GetInstance(c)
+	if err := obj.Delete(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkPreconditions returns false if the If-Match or If-None-Match headers
+// do not match the existing resource.
+func checkPreconditions(c echo.Context, existing *calendar.Object) bool {
+	req := c.Request()
+	if match := req.Header.Get("If-Match"); match != "" {
+		if existing == nil {
+			return false
+		}
+		if match != "*" && match != existing.ETag() {
+			return false
+		}
+	}
+	if noneMatch := req.Header.Get("If-None-Match"); noneMatch != "" && existing != nil {
+		if noneMatch == "*" || noneMatch == existing.ETag() {
+			return false
+		}
+	}
+	return true
+}
+
+// DAVRoutes sets the routing for the CalDAV server.
+func DAVRoutes(router *echo.Group) {
+	for _, p := range []string{"", "/", "/principals/me", "/principals/me/", "/calendars", "/calendars/",
+		"/calendars/:id", "/calendars/:id/", "/calendars/:id/:object"} {
+		router.OPTIONS(p, davOptions)
+	}
+
+	group := router.Group("", needPermission)
+	for _, p := range []string{"", "/"} {
+		group.Add("PROPFIND", p, propfindRoot)
+	}
+	for _, p := range []string{"/principals/me", "/principals/me/"} {
+		group.Add("PROPFIND", p, propfindPrincipal)
+	}
+	for _, p := range []string{"/calendars", "/calendars/"} {
+		group.Add("PROPFIND", p, propfindHome)
+	}
+	for _, p := range []string{"/calendars/:id", "/calendars/:id/"} {
+		group.Add("PROPFIND", p, propfindCalendar)
+		group.Add("PROPPATCH", p, proppatchCalendar)
+		group.Add("REPORT", p, reportCalendar)
+		group.Add("MKCALENDAR", p, mkcalendar)
+		group.DELETE(p, deleteDAVCalendar)
+	}
+	group.GET("/calendars/:id/:object", getObject)
+	group.HEAD("/calendars/:id/:object", getObject)
+	group.Add("PROPFIND", "/calendars/:id/:object", propfindObject)
+	group.PUT("/calendars/:id/:object", putObject)
+	group.DELETE("/calendars/:id/:object", deleteObject)
+}
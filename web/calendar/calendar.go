@@ -0,0 +1,467 @@
+// Package calendar is for the routes of the calendars: the JSON-API to manage
+// them, and the CalDAV server for the calendar clients.
+package calendar
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// maxRange is the maximal duration of the time range of the queries on the
+// occurrences and the free/busy.
+const maxRange = 366 * 24 * time.Hour
+
+type apiCalendar struct {
+	*calendar.Calendar
+}
+
+func (c *apiCalendar) Relationships() jsonapi.RelationshipMap { return nil }
+func (c *apiCalendar) Included() []jsonapi.Object             { return nil }
+func (c *apiCalendar) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/calendar/calendars/" + c.ID()}
+}
+
+type apiEvent struct {
+	*calendar.Event
+}
+
+func (e *apiEvent) Relationships() jsonapi.RelationshipMap { return nil }
+func (e *apiEvent) Included() []jsonapi.Object             { return nil }
+func (e *apiEvent) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/calendar/calendars/" + e.CalendarID + "/events/" + e.ID()}
+}
+
+func createCalendar(c echo.Context) error {
+	cal := &calendar.Calendar{}
+	if _, err := jsonapi.Bind(c.Request().Body, cal); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Calendars); err != nil {
+		return err
+	}
+	cal.DocID = ""
+	inst := middlewares.GetInstance(c)
+	if err := calendar.Create(inst, cal); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiCalendar{cal}, nil)
+}
+
+func listCalendars(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Calendars); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	calendars, err := calendar.EnsureDefault(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	objs := make([]jsonapi.Object, len(calendars))
+	for i, cal := range calendars {
+		objs[i] = &apiCalendar{cal}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func getCalendar(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiCalendar{cal}, nil)
+}
+
+func updateCalendar(c echo.Context) error {
+	cal, err := findCalendar(c, permission.PATCH)
+	if err != nil {
+		return err
+	}
+	patch := &calendar.Calendar{}
+	if _, err := jsonapi.Bind(c.Request().Body, patch); err != nil {
+		return err
+	}
+	if patch.Name != "" {
+		cal.Name = patch.Name
+	}
+	cal.Description = patch.Description
+	cal.Color = patch.Color
+	cal.Timezone = patch.Timezone
+	inst := middlewares.GetInstance(c)
+	if err := cal.Update(inst); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiCalendar{cal}, nil)
+}
+
+func deleteCalendar(c echo.Context) error {
+	cal, err := findCalendar(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.CalendarEvents); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := cal.Delete(inst); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func listOccurrences(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.GET, &calendar.Event{CalendarID: cal.ID()}); err != nil {
+		return err
+	}
+	from, to, err := timeRange(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	events, err := calendar.FindEvents(inst, cal.ID(), from, to)
+	if err != nil {
+		return wrapError(err)
+	}
+	occurrences := []*calendar.Occurrence{}
+	for _, e := range events {
+		occurrences = append(occurrences, e.Occurrences(from, to)...)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": occurrences})
+}
+
+func createEvent(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	e := &calendar.Event{}
+	if _, err := jsonapi.Bind(c.Request().Body, e); err != nil {
+		return err
+	}
+	e.DocID = ""
+	e.DocRev = ""
+	e.CalendarID = cal.ID()
+	if err := middlewares.Allow(c, permission.POST, e); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := calendar.SaveEvent(inst, e); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiEvent{e}, nil)
+}
+
+func getEvent(c echo.Context) error {
+	e, err := findEvent(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiEvent{e}, nil)
+}
+
+func updateEvent(c echo.Context) error {
+	old, err := findEvent(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+	e := &calendar.Event{}
+	if _, err := jsonapi.Bind(c.Request().Body, e); err != nil {
+		return err
+	}
+	e.DocID = old.DocID
+	if e.DocRev == "" {
+		e.DocRev = old.DocRev
+	}
+	e.CalendarID = old.CalendarID
+	e.CreatedAt = old.CreatedAt
+	if e.Filename == "" {
+		e.Filename = old.Filename
+	}
+	if e.UID == "" {
+		e.UID = old.UID
+	}
+	inst := middlewares.GetInstance(c)
+	if err := calendar.SaveEvent(inst, e); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiEvent{e}, nil)
+}
+
+func deleteEvent(c echo.Context) error {
+	e, err := findEvent(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := calendar.DeleteEvent(inst, e); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func importFile(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.POST, &calendar.Event{CalendarID: cal.ID()}); err != nil {
+		return err
+	}
+	var args struct {
+		FileID string `json:"file_id"`
+	}
+	if err := c.Bind(&args); err != nil || args.FileID == "" {
+		return jsonapi.BadRequest(errors.New("Missing file_id"))
+	}
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(args.FileID)
+	if err != nil {
+		return jsonapi.NotFound(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+	res, err := calendar.ImportFile(inst, cal, file)
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func exportFile(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.GET, &calendar.Event{CalendarID: cal.ID()}); err != nil {
+		return err
+	}
+	var args struct {
+		DirID string `json:"dir_id"`
+	}
+	if err := c.Bind(&args); err != nil || args.DirID == "" {
+		return jsonapi.BadRequest(errors.New("Missing dir_id"))
+	}
+	inst := middlewares.GetInstance(c)
+	dir, err := inst.VFS().DirByID(args.DirID)
+	if err != nil {
+		return jsonapi.NotFound(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.POST, dir); err != nil {
+		return err
+	}
+	file, err := calendar.ExportToFile(inst, cal, dir.ID())
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusCreated, echo.Map{"file_id": file.ID(), "name": file.DocName})
+}
+
+func downloadICS(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.GET, &calendar.Event{CalendarID: cal.ID()}); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, calendar.ContentType)
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+strings.ReplaceAll(cal.Name, `"`, "")+`.ics"`)
+	res.WriteHeader(http.StatusOK)
+	return calendar.Export(inst, cal, res)
+}
+
+func freeBusy(c echo.Context) error {
+	from, to, err := timeRange(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	var calendars []*calendar.Calendar
+	if ids := c.QueryParam("calendars"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			cal, err := calendar.Get(inst, id)
+			if err != nil {
+				return wrapError(err)
+			}
+			calendars = append(calendars, cal)
+		}
+	} else {
+		calendars, err = calendar.List(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+	for _, cal := range calendars {
+		if err := middlewares.Allow(c, permission.GET, &calendar.Event{CalendarID: cal.ID()}); err != nil {
+			return err
+		}
+	}
+	periods, err := calendar.FreeBusy(inst, calendars, from, to)
+	if err != nil {
+		return wrapError(err)
+	}
+	if periods == nil {
+		periods = []*calendar.Period{}
+	}
+	return c.JSON(http.StatusOK, echo.Map{"data": periods})
+}
+
+// shareCalendar creates a sharing for a calendar, with its events and tasks,
+// and sends the invitations to the recipients.
+func shareCalendar(c echo.Context) error {
+	cal, err := findCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	var args struct {
+		Description string   `json:"description"`
+		Recipients  []string `json:"recipients"`
+		Groups      []string `json:"groups"`
+		ReadOnly    bool     `json:"read_only"`
+	}
+	if _, err := jsonapi.Bind(c.Request().Body, &args); err != nil {
+		return err
+	}
+	if len(args.Recipients) == 0 && len(args.Groups) == 0 {
+		return jsonapi.BadRequest(errors.New("Missing recipients"))
+	}
+	perm, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	s := &sharing.Sharing{
+		Description: args.Description,
+		Rules:       calendar.SharingRules(cal, !args.ReadOnly),
+	}
+	if s.Description == "" {
+		s.Description = cal.Name
+	}
+	for _, r := range s.Rules {
+		pr := permission.Rule{
+			Type:     r.DocType,
+			Verbs:    permission.ALL,
+			Selector: r.Selector,
+			Values:   r.Values,
+		}
+		if !perm.Permissions.RuleInSubset(pr) {
+			return middlewares.ErrForbidden
+		}
+	}
+	slug := ""
+	if perm.Type == permission.TypeWebapp {
+		slug = strings.TrimPrefix(perm.SourceID, consts.Apps+"/")
+	}
+
+	inst := middlewares.GetInstance(c)
+	if err := s.BeOwner(inst, slug); err != nil {
+		return err
+	}
+	if err := s.AddGroupsAndContacts(inst, args.Groups, args.Recipients, args.ReadOnly); err != nil {
+		return err
+	}
+	perms, err := s.Create(inst)
+	if err != nil {
+		return err
+	}
+	if err := s.SendInvitations(inst, perms); err != nil {
+		return err
+	}
+	as := &sharing.APISharing{Sharing: s}
+	return jsonapi.Data(c, http.StatusCreated, as, nil)
+}
+
+func findCalendar(c echo.Context, verb permission.Verb) (*calendar.Calendar, error) {
+	inst := middlewares.GetInstance(c)
+	cal, err := calendar.Get(inst, c.Param("id"))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if err := middlewares.Allow(c, verb, cal); err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+func findEvent(c echo.Context, verb permission.Verb) (*calendar.Event, error) {
+	inst := middlewares.GetInstance(c)
+	e, err := calendar.GetEvent(inst, c.Param("event-id"))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if e.CalendarID != c.Param("id") {
+		return nil, jsonapi.NotFound(errors.New("Event not found"))
+	}
+	if err := middlewares.Allow(c, verb, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// timeRange returns the time range given by the start and end query
+// parameters.
+func timeRange(c echo.Context) (time.Time, time.Time, error) {
+	from, err := time.Parse(time.RFC3339, c.QueryParam("start"))
+	if err != nil {
+		return time.Time{}, time.Time{}, jsonapi.InvalidParameter("start", err)
+	}
+	to, err := time.Parse(time.RFC3339, c.QueryParam("end"))
+	if err != nil {
+		return time.Time{}, time.Time{}, jsonapi.InvalidParameter("end", err)
+	}
+	if !to.After(from) || to.Sub(from) > maxRange {
+		return time.Time{}, time.Time{}, jsonapi.InvalidParameter("end", errors.New("Invalid time range"))
+	}
+	return from, to, nil
+}
+
+func wrapError(err error) error {
+	if couchdb.IsNotFoundError(err) {
+		return jsonapi.NotFound(err)
+	}
+	if couchdb.IsConflictError(err) {
+		return jsonapi.Conflict(err)
+	}
+	switch err {
+	case calendar.ErrInvalidCalendar, calendar.ErrInvalidEvent, calendar.ErrInvalidObject:
+		return jsonapi.BadRequest(err)
+	case calendar.ErrUIDConflict:
+		return jsonapi.Conflict(err)
+	}
+	return err
+}
+
+// Routes sets the routing for the calendars.
+func Routes(router *echo.Group) {
+	router.GET("/calendars", listCalendars)
+	router.POST("/calendars", createCalendar)
+	router.GET("/calendars/:id", getCalendar)
+	router.PATCH("/calendars/:id", updateCalendar)
+	router.DELETE("/calendars/:id", deleteCalendar)
+	router.GET("/calendars/:id/occurrences", listOccurrences)
+	router.POST("/calendars/:id/events", createEvent)
+	router.GET("/calendars/:id/events/:event-id", getEvent)
+	router.PUT("/calendars/:id/events/:event-id", updateEvent)
+	router.DELETE("/calendars/:id/events/:event-id", deleteEvent)
+	router.POST("/calendars/:id/import", importFile)
+	router.POST("/calendars/:id/export", exportFile)
+	router.GET("/calendars/:id/ics", downloadICS)
+	router.POST("/calendars/:id/sharings", shareCalendar)
+	router.GET("/freebusy", freeBusy)
+}
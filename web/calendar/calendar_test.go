@@ -0,0 +1,183 @@
+package calendar
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/labstack/echo/v4"
+)
+
+const event = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.org\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261019T093000\r\n" +
+	"DTEND;TZID=Europe/Paris:20261019T094500\r\n" +
+	"SUMMARY:Stand-up\r\n" +
+	"RRULE:FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalendar(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	setup.GetTestInstance(&lifecycle.Options{
+		Email:      "alice@example.com",
+		PublicName: "Alice",
+	})
+	scope := consts.Calendars + " " + consts.CalendarEvents + " " + consts.CalendarTodos
+	_, token := setup.GetTestClient(scope)
+	ts := setup.GetTestServerMultipleRoutes(map[string]func(*echo.Group){
+		"/calendar": Routes,
+		"/dav":      DAVRoutes,
+	})
+	t.Cleanup(ts.Close)
+
+	var calendarID string
+
+	t.Run("CreateCalendar", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		obj := e.POST("/calendar/calendars").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{"data": {"type": "io.cozy.calendar.calendars",
+				"attributes": {"name": "Work", "timezone": "Europe/Paris"}}}`)).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		data := obj.Value("data").Object()
+		data.ValueEqual("type", consts.Calendars)
+		data.Path("$.attributes.name").Equal("Work")
+		calendarID = data.Value("id").String().NotEmpty().Raw()
+	})
+
+	t.Run("Authentication", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.Request("PROPFIND", "/dav/calendars/").
+			Expect().Status(401).
+			Header("WWW-Authenticate").Contains("Basic")
+	})
+
+	t.Run("PutObject", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.PUT("/dav/calendars/"+calendarID+"/standup.ics").
+			WithBasicAuth("alice", token).
+			WithHeader("Content-Type", "text/calendar").
+			WithHeader("If-None-Match", "*").
+			WithBytes([]byte(event)).
+			Expect().Status(201).
+			Header("ETag").NotEmpty()
+
+		// The resource already exists
+		e.PUT("/dav/calendars/"+calendarID+"/standup.ics").
+			WithBasicAuth("alice", token).
+			WithHeader("If-None-Match", "*").
+			WithBytes([]byte(event)).
+			Expect().Status(412)
+
+		// Another resource with the same UID
+		e.PUT("/dav/calendars/"+calendarID+"/other.ics").
+			WithBasicAuth("alice", token).
+			WithBytes([]byte(event)).
+			Expect().Status(403).
+			Body().Contains("no-uid-conflict")
+
+		e.PUT("/dav/calendars/"+calendarID+"/invalid.ics").
+			WithBasicAuth("alice", token).
+			WithBytes([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")).
+			Expect().Status(403).
+			Body().Contains("valid-calendar-data")
+	})
+
+	t.Run("PropfindAndReport", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.Request("PROPFIND", "/dav/calendars/").
+			WithBasicAuth("alice", token).
+			WithHeader("Depth", "1").
+			WithBytes([]byte(`<?xml version="1.0"?><d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+				<d:prop><d:displayname/><cs:getctag/></d:prop></d:propfind>`)).
+			Expect().Status(http.StatusMultiStatus).
+			Body().Contains("<d:displayname>Work</d:displayname>").Contains("<cs:getctag>")
+
+		e.Request("PROPFIND", "/dav/calendars/"+calendarID+"/").
+			WithBasicAuth("alice", token).
+			WithHeader("Depth", "1").
+			WithBytes([]byte(`<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`)).
+			Expect().Status(http.StatusMultiStatus).
+			Body().Contains("/dav/calendars/" + calendarID + "/standup.ics")
+
+		e.Request("REPORT", "/dav/calendars/"+calendarID+"/").
+			WithBasicAuth("alice", token).
+			WithBytes([]byte(`<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+				<d:prop><d:getetag/><c:calendar-data/></d:prop>
+				<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">
+				<c:time-range start="20261020T000000Z" end="20261021T000000Z"/>
+				</c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`)).
+			Expect().Status(http.StatusMultiStatus).
+			Body().Contains("UID:standup@example.org")
+
+		e.Request("REPORT", "/dav/calendars/"+calendarID+"/").
+			WithBasicAuth("alice", token).
+			WithBytes([]byte(`<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+				<d:prop><c:calendar-data/></d:prop>
+				<d:href>/dav/calendars/` + calendarID + `/standup.ics</d:href>
+				<d:href>/dav/calendars/` + calendarID + `/missing.ics</d:href>
+				</c:calendar-multiget>`)).
+			Expect().Status(http.StatusMultiStatus).
+			Body().Contains("SUMMARY:Stand-up").Contains("404 Not Found")
+	})
+
+	t.Run("Occurrences", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		obj := e.GET("/calendar/calendars/"+calendarID+"/occurrences").
+			WithQuery("start", "2026-10-23T00:00:00Z").
+			WithQuery("end", "2026-10-27T00:00:00Z").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		// Friday and Monday, not the week-end
+		occurrences := obj.Value("data").Array()
+		occurrences.Length().Equal(2)
+		occurrences.First().Object().ValueEqual("start", "2026-10-23T07:30:00Z")
+		// After the change of time
+		occurrences.Last().Object().ValueEqual("start", "2026-10-26T08:30:00Z")
+	})
+
+	t.Run("FreeBusy", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		obj := e.GET("/calendar/freebusy").
+			WithQuery("start", "2026-10-19T00:00:00Z").
+			WithQuery("end", "2026-10-20T00:00:00Z").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		periods := obj.Value("data").Array()
+		periods.Length().Equal(1)
+		periods.First().Object().ValueEqual("end", "2026-10-19T07:45:00Z")
+	})
+
+	t.Run("DeleteObject", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.DELETE("/dav/calendars/"+calendarID+"/standup.ics").
+			WithBasicAuth("alice", token).
+			WithHeader("If-Match", `"1-wrong"`).
+			Expect().Status(412)
+		e.DELETE("/dav/calendars/"+calendarID+"/standup.ics").
+			WithBasicAuth("alice", token).
+			Expect().Status(204)
+		e.GET("/dav/calendars/"+calendarID+"/standup.ics").
+			WithBasicAuth("alice", token).
+			Expect().Status(404)
+	})
+}
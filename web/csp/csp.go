@@ -0,0 +1,192 @@
+// Package csp is for the endpoint where the browsers report the violations of
+// the CSP policies of the webapps, and for the route to list them.
+package csp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxReportSize is the maximal size of the body of a request with
+	// reports.
+	maxReportSize = 64 << 10
+	// maxReportsPerRequest is the maximal number of reports saved for a
+	// request, the other ones are ignored.
+	maxReportsPerRequest = 20
+)
+
+// legacyReport is the body sent by the browsers for the report-uri directive,
+// with the application/csp-report content-type.
+type legacyReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is a report sent by the browsers for the report-to
+// directive, with the application/reports+json content-type. The body is a
+// list of reports.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+type apiCSPReport struct {
+	*app.CSPReport
+}
+
+func (r *apiCSPReport) Links() *jsonapi.LinksList              { return nil }
+func (r *apiCSPReport) Relationships() jsonapi.RelationshipMap { return nil }
+func (r *apiCSPReport) Included() []jsonapi.Object             { return nil }
+
+var _ jsonapi.Object = (*apiCSPReport)(nil)
+
+// parseReports reads the violations from the body of a request sent by a
+// browser, in the format of report-uri or of report-to.
+func parseReports(body []byte) ([]*app.CSPReport, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var list []reportingAPIReport
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		var reports []*app.CSPReport
+		for _, r := range list {
+			if r.Type != "csp-violation" || !app.IsCSPDirective(r.Body.EffectiveDirective) {
+				continue
+			}
+			reports = append(reports, &app.CSPReport{
+				Directive:   r.Body.EffectiveDirective,
+				BlockedURI:  r.Body.BlockedURL,
+				DocumentURI: r.Body.DocumentURL,
+				SourceFile:  r.Body.SourceFile,
+				LineNumber:  r.Body.LineNumber,
+				Disposition: r.Body.Disposition,
+			})
+		}
+		return reports, nil
+	}
+
+	var legacy legacyReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	r := legacy.Report
+	directive := r.EffectiveDirective
+	if directive == "" {
+		// Some browsers put the sources of the policy after the name
+		if fields := strings.Fields(r.ViolatedDirective); len(fields) > 0 {
+			directive = fields[0]
+		}
+	}
+	if !app.IsCSPDirective(directive) {
+		return nil, nil
+	}
+	return []*app.CSPReport{{
+		Directive:   directive,
+		BlockedURI:  r.BlockedURI,
+		DocumentURI: r.DocumentURI,
+		SourceFile:  r.SourceFile,
+		LineNumber:  r.LineNumber,
+		Disposition: r.Disposition,
+	}}, nil
+}
+
+// Report is the endpoint where the browsers send the violations of the CSP
+// policy of a webapp. It doesn't need to be authenticated, as the browsers
+// don't send the credentials with the reports, but each report is counted by
+// the rate-limiting, and only the first reports of a request are saved.
+func Report(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	slug := c.QueryParam("slug")
+	if slug == "" {
+		return jsonapi.InvalidParameter("slug", errors.New("Missing slug"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxReportSize))
+	if err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	reports, err := parseReports(body)
+	if err != nil {
+		return jsonapi.BadJSON()
+	}
+	if len(reports) > maxReportsPerRequest {
+		reports = reports[:maxReportsPerRequest]
+	}
+	for i := range reports {
+		err := config.GetRateLimiter().CheckRateLimit(inst, limits.CSPReportType)
+		if limits.IsLimitReachedOrExceeded(err) {
+			if i == 0 {
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests")
+			}
+			reports = reports[:i]
+			break
+		}
+	}
+	if len(reports) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if _, err := app.GetWebappBySlug(inst, slug); err != nil {
+		return jsonapi.NotFound(err)
+	}
+	for _, report := range reports {
+		report.Slug = slug
+		if err := app.AddCSPReport(inst, report); err != nil {
+			inst.Logger().WithNamespace("csp").
+				Infof("Cannot save a CSP report for %s: %s", slug, err)
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// listReports returns the violations of the CSP policies, optionally filtered
+// on a webapp.
+func listReports(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.CSPReports); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	reports, err := app.ListCSPReports(inst, c.QueryParam("slug"))
+	if err != nil {
+		return jsonapi.InternalServerError(err)
+	}
+	objs := make([]jsonapi.Object, len(reports))
+	for i, report := range reports {
+		objs[i] = &apiCSPReport{report}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// Routes sets the routing for the CSP reports.
+func Routes(router *echo.Group) {
+	router.GET("/reports", listReports)
+}
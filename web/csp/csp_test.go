@@ -0,0 +1,74 @@
+package csp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReports(t *testing.T) {
+	t.Run("ReportURI", func(t *testing.T) {
+		body := `{"csp-report": {
+			"document-uri": "https://alice-maps.cozy.example/",
+			"violated-directive": "img-src",
+			"effective-directive": "img-src",
+			"blocked-uri": "https://tiles.example.org/1/2/3.png",
+			"source-file": "https://alice-maps.cozy.example/app.js",
+			"line-number": 42,
+			"disposition": "enforce"
+		}}`
+		reports, err := parseReports([]byte(body))
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "img-src", reports[0].Directive)
+		assert.Equal(t, "https://tiles.example.org/1/2/3.png", reports[0].BlockedURI)
+		assert.Equal(t, "https://alice-maps.cozy.example/", reports[0].DocumentURI)
+		assert.Equal(t, 42, reports[0].LineNumber)
+		assert.Equal(t, "enforce", reports[0].Disposition)
+
+		reports, err = parseReports([]byte(`{"csp-report": {"violated-directive": "script-src-elem"}}`))
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "script-src-elem", reports[0].Directive)
+
+		reports, err = parseReports([]byte(`{}`))
+		assert.NoError(t, err)
+		assert.Empty(t, reports)
+
+		reports, err = parseReports([]byte(`{"csp-report": {"violated-directive": "img-src 'self' data:"}}`))
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "img-src", reports[0].Directive)
+
+		reports, err = parseReports([]byte(`{"csp-report": {"violated-directive": "not-a-directive"}}`))
+		assert.NoError(t, err)
+		assert.Empty(t, reports)
+	})
+
+	t.Run("ReportTo", func(t *testing.T) {
+		body := ` [
+			{"type": "csp-violation", "age": 10, "body": {
+				"documentURL": "https://alice-maps.cozy.example/",
+				"effectiveDirective": "connect-src",
+				"blockedURL": "https://api.example.org/v1",
+				"disposition": "report"
+			}},
+			{"type": "csp-violation", "body": {"effectiveDirective": "<script>"}},
+			{"type": "deprecation", "body": {}}
+		]`
+		reports, err := parseReports([]byte(body))
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "connect-src", reports[0].Directive)
+		assert.Equal(t, "https://api.example.org/v1", reports[0].BlockedURI)
+		assert.Equal(t, "report", reports[0].Disposition)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := parseReports([]byte(`not json`))
+		assert.Error(t, err)
+		_, err = parseReports([]byte(`[{"type": 1}]`))
+		assert.Error(t, err)
+	})
+}
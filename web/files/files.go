@@ -22,6 +22,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/mount"
 	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/permission"
@@ -98,6 +99,13 @@ func Create(c echo.Context, sharedDrive *sharing.Sharing) error {
 }
 
 func CreationHandler(c echo.Context) error {
+	mountDir, rel, err := lookupMount(middlewares.GetInstance(c).VFS(), c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if mountDir != nil {
+		return createInMount(c, mountDir, rel)
+	}
 	return Create(c, nil)
 }
 
@@ -323,6 +331,13 @@ func OverwriteFileContent(c echo.Context, sharedDrive *sharing.Sharing) error {
 }
 
 func OverwriteFileContentHandler(c echo.Context) error {
+	if fileID := c.Param("file-id"); vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(middlewares.GetInstance(c).VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return overwriteMounted(c, mountDir, rel)
+	}
 	return OverwriteFileContent(c, nil)
 }
 
@@ -420,6 +435,13 @@ func FileCopyHandler(c echo.Context) error {
 // It can be used to modify the file or directory metadata, as well as
 // moving and renaming it in the filesystem.
 func ModifyMetadataByIDHandler(c echo.Context) error {
+	if fileID := c.Param("file-id"); vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(middlewares.GetInstance(c).VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return patchMounted(c, mountDir, rel)
+	}
 	patch, err := getPatch(c, c.Param("file-id"), "")
 	if err != nil {
 		return WrapVfsError(err)
@@ -738,6 +760,11 @@ func applyPatch(c echo.Context, fs vfs.VFS, patch *docPatch) (err error) {
 			file, err = vfs.TrashFile(fs, file)
 		}
 	} else {
+		if patch.DirID != nil {
+			if err = checkMoveTarget(fs, *patch.DirID); err != nil {
+				return wrapMountError(err)
+			}
+		}
 		if dir != nil {
 			oldDirName := dir.DocName
 			UpdateDirCozyMetadata(c, dir)
@@ -777,6 +804,15 @@ func applyPatches(c echo.Context, fs vfs.VFS, patches []*docPatch) (errors []*js
 		if err = checkPerm(c, permission.PATCH, dir, file); err != nil {
 			return
 		}
+		if patch.DirID != nil && !patch.Delete && !patch.Trash {
+			if errm := checkMoveTarget(fs, *patch.DirID); errm != nil {
+				jsonapiError := jsonapi.BadRequest(errm)
+				jsonapiError.Source.Parameter = "_id"
+				jsonapiError.Source.Pointer = patch.docID
+				errors = append(errors, jsonapiError)
+				continue
+			}
+		}
 		var errp error
 		if patch.Delete {
 			if dir != nil {
@@ -829,6 +865,13 @@ func ReadMetadataFromIDHandler(c echo.Context) error {
 	}
 
 	fileID := c.Param("file-id")
+	if vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(instance.VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return readMountedMetadata(c, mountDir, rel)
+	}
 
 	dir, file, err := instance.VFS().DirOrFileByID(fileID)
 	if err != nil {
@@ -847,6 +890,9 @@ func ReadMetadataFromIDHandler(c echo.Context) error {
 		}
 	}
 
+	if dir != nil && dir.Mount != nil {
+		return mountedDirData(c, dir, "")
+	}
 	if dir != nil {
 		return DirData(c, http.StatusOK, dir, nil)
 	}
@@ -858,6 +904,13 @@ func GetChildrenHandler(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 
 	fileID := c.Param("file-id")
+	if vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(instance.VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return mountedDirDataList(c, mountDir, rel)
+	}
 
 	dir, file, err := instance.VFS().DirOrFileByID(fileID)
 	if err != nil {
@@ -871,6 +924,9 @@ func GetChildrenHandler(c echo.Context) error {
 	if file != nil {
 		return jsonapi.Errorf(http.StatusBadRequest, "cant read children of file %v", fileID)
 	}
+	if dir.Mount != nil {
+		return mountedDirDataList(c, dir, "")
+	}
 
 	return dirDataList(c, http.StatusOK, dir)
 }
@@ -945,6 +1001,14 @@ func ReadMetadataFromPath(c echo.Context, sharedDrive *sharing.Sharing) error {
 func ReadFileContentFromIDHandler(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 
+	if fileID := c.Param("file-id"); vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(instance.VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return serveMountedFile(c, mountDir, rel)
+	}
+
 	doc, err := instance.VFS().FileByID(c.Param("file-id"))
 	if err != nil {
 		return WrapVfsError(err)
@@ -1046,6 +1110,14 @@ func RevertFileVersion(c echo.Context) error {
 func HeadDirOrFile(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 
+	if fileID := c.Param("file-id"); vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(instance.VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return headMounted(c, mountDir, rel)
+	}
+
 	dir, file, err := instance.VFS().DirOrFileByID(c.Param("file-id"))
 	if err != nil {
 		return WrapVfsError(err)
@@ -1471,6 +1543,13 @@ func Trash(c echo.Context, sharedDrive *sharing.Sharing) error {
 }
 
 func TrashHandler(c echo.Context) error {
+	if fileID := c.Param("file-id"); vfs.IsMountedID(fileID) {
+		mountDir, rel, err := mount.Resolve(middlewares.GetInstance(c).VFS(), fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		return removeMounted(c, mountDir, rel)
+	}
 	return Trash(c, nil)
 }
 
@@ -2179,6 +2258,7 @@ func Routes(router *echo.Group) {
 	router.PATCH("/", ModifyMetadataByIDInBatchHandler)
 
 	router.POST("/shared-drives", SharedDrivesCreationHandler)
+	router.POST("/mounts", CreateMountHandler)
 	router.POST("/", CreationHandler)
 	router.POST("/:file-id", CreationHandler)
 	router.PUT("/:file-id", OverwriteFileContentHandler)
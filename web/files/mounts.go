@@ -0,0 +1,430 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/mount"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/filetype"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type mountAttrs struct {
+	Name  string         `json:"name"`
+	DirID string         `json:"dir_id"`
+	Mount *vfs.MountInfo `json:"mount"`
+}
+
+// CreateMountHandler is the handler for POST /files/mounts. It creates a
+// directory where an external storage (WebDAV or SFTP) is mounted.
+func CreateMountHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Files); err != nil {
+		return err
+	}
+	var attrs mountAttrs
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if attrs.Mount == nil || attrs.Mount.AccountID == "" {
+		return jsonapi.InvalidAttribute("mount", errors.New("the mount must have a provider and an account_id"))
+	}
+	inst := middlewares.GetInstance(c)
+
+	// The mount uses the credentials of the account, so the client must be
+	// allowed to read it.
+	var account couchdb.JSONDoc
+	if err := couchdb.GetDoc(inst, consts.Accounts, attrs.Mount.AccountID, &account); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return wrapMountError(mount.ErrAccountNotFound)
+		}
+		return err
+	}
+	account.Type = consts.Accounts
+	if err := middlewares.Allow(c, permission.GET, &account); err != nil {
+		return err
+	}
+
+	doc, err := mount.Create(inst, attrs.Name, attrs.DirID, attrs.Mount)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, NewDir(doc, nil), nil)
+}
+
+// lookupMount returns the mount directory and the relative path if the
+// identifier is the one of a mount directory, or of a file or directory
+// inside a mount. Else, the returned directory is nil.
+func lookupMount(fs vfs.VFS, id string) (*vfs.DirDoc, string, error) {
+	if vfs.IsMountedID(id) {
+		return mount.Resolve(fs, id)
+	}
+	if id == "" || id == consts.RootDirID || id == consts.TrashDirID {
+		return nil, "", nil
+	}
+	dir, err := fs.DirByID(id)
+	if err != nil || dir.Mount == nil {
+		// The errors are reported by the handlers for the normal files
+		return nil, "", nil
+	}
+	return dir, "", nil
+}
+
+// checkMoveTarget returns an error if a file or directory of the Cozy is moved
+// inside a mount.
+func checkMoveTarget(fs vfs.VFS, dirID string) error {
+	if vfs.IsMountedID(dirID) {
+		return mount.ErrCrossMount
+	}
+	if dir, err := fs.DirByID(dirID); err == nil && dir.Mount != nil {
+		return mount.ErrCrossMount
+	}
+	return nil
+}
+
+// openMount checks the permission on the mount directory, and returns a
+// provider for its external storage. The permissions on the content of a
+// mount are the ones of the mount directory.
+func openMount(c echo.Context, v permission.Verb, mountDir *vfs.DirDoc) (mount.Provider, error) {
+	if err := checkPerm(c, v, mountDir, nil); err != nil {
+		return nil, err
+	}
+	return mount.Open(middlewares.GetInstance(c), mountDir.Mount)
+}
+
+// mountedObject returns the JSON-API object for an entry of a mount.
+func mountedObject(c echo.Context, mountDir *vfs.DirDoc, rel string, e *mount.Entry) jsonapi.Object {
+	if e.Dir {
+		return NewDir(mount.DirDoc(mountDir, rel, e), nil)
+	}
+	return NewFile(mount.FileDoc(mountDir, rel, e), middlewares.GetInstance(c), nil)
+}
+
+// mountedChildren returns the directory and its children, sorted by name.
+func mountedChildren(provider mount.Provider, mountDir *vfs.DirDoc, rel string) (*vfs.DirDoc, []*mount.Entry, error) {
+	doc := mountDir
+	if rel != "" {
+		e, err := provider.Stat(rel)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !e.Dir {
+			return nil, nil, jsonapi.BadRequest(errors.New("cant read children of file"))
+		}
+		doc = mount.DirDoc(mountDir, rel, e)
+	}
+	entries, err := provider.List(rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return doc, entries, nil
+}
+
+// mountedDirData is the equivalent of DirData for a directory of a mount. The
+// children are not paginated.
+func mountedDirData(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.GET, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	doc, entries, err := mountedChildren(provider, mountDir, rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+
+	relsData := make([]couchdb.DocReference, 0, len(entries))
+	included := make([]jsonapi.Object, 0, len(entries))
+	for _, e := range entries {
+		child := mountedObject(c, mountDir, path.Join(rel, e.Name), e)
+		relsData = append(relsData, couchdb.DocReference{ID: child.ID(), Type: consts.Files})
+		included = append(included, child)
+	}
+	count := len(entries)
+	d := NewDir(doc, nil)
+	d.rel = jsonapi.RelationshipMap{
+		"parent": jsonapi.Relationship{
+			Links: &jsonapi.LinksList{
+				Self: "/files/" + doc.DirID,
+			},
+			Data: couchdb.DocReference{
+				ID:   doc.DirID,
+				Type: consts.Files,
+			},
+		},
+		"contents": jsonapi.Relationship{
+			Meta: &jsonapi.Meta{Count: &count},
+			Links: &jsonapi.LinksList{
+				Self: "/files/" + doc.DocID + "/relationships/contents",
+			},
+			Data: relsData,
+		},
+	}
+	d.included = included
+	return jsonapi.Data(c, http.StatusOK, d, nil)
+}
+
+// mountedDirDataList is the equivalent of dirDataList for a directory of a
+// mount.
+func mountedDirDataList(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.GET, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	_, entries, err := mountedChildren(provider, mountDir, rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	included := make([]jsonapi.Object, 0, len(entries))
+	for _, e := range entries {
+		included = append(included, mountedObject(c, mountDir, path.Join(rel, e.Name), e))
+	}
+	count := len(entries)
+	meta := jsonapi.Meta{Count: &count}
+	return jsonapi.DataListWithMeta(c, http.StatusOK, meta, included, nil)
+}
+
+// readMountedMetadata handles GET /files/:file-id for a file or directory
+// inside a mount.
+func readMountedMetadata(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	if rel == "" {
+		return mountedDirData(c, mountDir, rel)
+	}
+	provider, err := openMount(c, permission.GET, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	e, err := provider.Stat(rel)
+	provider.Close()
+	if err != nil {
+		return wrapMountError(err)
+	}
+	if e.Dir {
+		return mountedDirData(c, mountDir, rel)
+	}
+	return jsonapi.Data(c, http.StatusOK, mountedObject(c, mountDir, rel, e), nil)
+}
+
+// headMounted handles HEAD /files/:file-id for a file or directory inside a
+// mount.
+func headMounted(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.GET, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	_, err = provider.Stat(rel)
+	return wrapMountError(err)
+}
+
+// serveMountedFile handles GET /files/download/:file-id for a file inside a
+// mount. The content is streamed from the external storage.
+func serveMountedFile(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.GET, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	e, err := provider.Stat(rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	if e.Dir {
+		return jsonapi.BadRequest(errors.New("cant download a directory"))
+	}
+
+	disposition := "inline"
+	if c.QueryParam("Dl") == "1" {
+		disposition = "attachment"
+	}
+	mime, _ := vfs.ExtractMimeAndClassFromFilename(e.Name)
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, mime)
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(e.Size, 10))
+	header.Set(echo.HeaderContentDisposition, vfs.ContentDisposition(disposition, e.Name))
+	header.Set(echo.HeaderLastModified, e.UpdatedAt.Format(http.TimeFormat))
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(http.StatusOK)
+	}
+
+	content, err := provider.Open(rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer content.Close()
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response(), content)
+	return err
+}
+
+// createInMount handles POST /files/:dir-id for a directory of a mount. The
+// disk quota of the Cozy is not used by the external storages.
+func createInMount(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	name := c.QueryParam("Name")
+	if err := mount.CheckName(name); err != nil {
+		return WrapVfsError(err)
+	}
+	typ := c.QueryParam("Type")
+	if typ != consts.FileType && typ != consts.DirType {
+		return WrapVfsError(ErrDocTypeInvalid)
+	}
+
+	provider, err := openMount(c, permission.POST, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	childRel := path.Join(rel, name)
+	if _, err := provider.Stat(childRel); err == nil {
+		return wrapMountError(os.ErrExist)
+	}
+
+	if typ == consts.DirType {
+		err = provider.Mkdir(childRel)
+	} else {
+		err = putInMount(c, provider, childRel, name)
+	}
+	if err != nil {
+		return wrapMountError(err)
+	}
+	e, err := provider.Stat(childRel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, mountedObject(c, mountDir, childRel, e), nil)
+}
+
+// overwriteMounted handles PUT /files/:file-id for a file inside a mount.
+func overwriteMounted(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.PUT, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	e, err := provider.Stat(rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	if e.Dir {
+		return jsonapi.BadRequest(errors.New("cant overwrite a directory"))
+	}
+	if err := putInMount(c, provider, rel, e.Name); err != nil {
+		return wrapMountError(err)
+	}
+	if e, err = provider.Stat(rel); err != nil {
+		return wrapMountError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, mountedObject(c, mountDir, rel, e), nil)
+}
+
+func putInMount(c echo.Context, provider mount.Provider, rel, name string) error {
+	req := c.Request()
+	mime, _ := vfs.ExtractMimeAndClass(req.Header.Get(echo.HeaderContentType))
+	if mime == filetype.DefaultType {
+		mime, _ = vfs.ExtractMimeAndClassFromFilename(name)
+	}
+	return provider.Put(rel, mime, req.ContentLength, req.Body)
+}
+
+// patchMounted handles PATCH /files/:file-id for a file or directory inside a
+// mount. Only the name and the parent can be modified, and the parent must be
+// in the same mount.
+func patchMounted(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	patch, err := getPatch(c, c.Param("file-id"), "")
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	provider, err := openMount(c, permission.PATCH, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	e, err := provider.Stat(rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+
+	parent := path.Dir(rel)
+	if parent == "." {
+		parent = ""
+	}
+	if patch.DirID != nil {
+		if *patch.DirID == mountDir.DocID {
+			parent = ""
+		} else if mountID, p, ok := vfs.SplitMountedID(*patch.DirID); ok && mountID == mountDir.DocID {
+			parent = p
+		} else {
+			return wrapMountError(mount.ErrCrossMount)
+		}
+	}
+	name := e.Name
+	if patch.Name != nil {
+		name = *patch.Name
+		if err := mount.CheckName(name); err != nil {
+			return WrapVfsError(err)
+		}
+	}
+	newRel := path.Join(parent, name)
+	if strings.HasPrefix(newRel+"/", rel+"/") && newRel != rel {
+		return WrapVfsError(vfs.ErrForbiddenDocMove)
+	}
+	if newRel != rel {
+		if err := provider.Rename(rel, newRel); err != nil {
+			return wrapMountError(err)
+		}
+		if e, err = provider.Stat(newRel); err != nil {
+			return wrapMountError(err)
+		}
+	}
+	return jsonapi.Data(c, http.StatusOK, mountedObject(c, mountDir, newRel, e), nil)
+}
+
+// removeMounted handles DELETE /files/:file-id for a file or directory inside
+// a mount. The external storages have no trash: it is deleted.
+func removeMounted(c echo.Context, mountDir *vfs.DirDoc, rel string) error {
+	provider, err := openMount(c, permission.DELETE, mountDir)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	defer provider.Close()
+	e, err := provider.Stat(rel)
+	if err != nil {
+		return wrapMountError(err)
+	}
+	if err := provider.Remove(rel, e.Dir); err != nil {
+		return wrapMountError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func wrapMountError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case mount.ErrAccountNotFound:
+		return jsonapi.NotFound(err)
+	case mount.ErrUnknownProvider, mount.ErrInvalidAccount, mount.ErrNotADirectory,
+		mount.ErrNestedMount, mount.ErrSharedParent, mount.ErrCrossMount:
+		return jsonapi.BadRequest(err)
+	case mount.ErrInvalidAuth:
+		return jsonapi.Unauthorized(err)
+	case mount.ErrHostKeyMismatch:
+		return jsonapi.Errorf(http.StatusBadGateway, "%s", err)
+	case os.ErrPermission:
+		return jsonapi.Forbidden(err)
+	}
+	return WrapVfsError(err)
+}
@@ -402,7 +402,8 @@ func (f *file) MarshalJSON() ([]byte, error) {
 
 func (f *file) Links() *jsonapi.LinksList {
 	links := jsonapi.LinksList{Self: "/files/" + f.doc.DocID}
-	if f.doc.Class == "image" || f.doc.Class == "pdf" {
+	// No thumbnails for the files of an external mount
+	if (f.doc.Class == "image" || f.doc.Class == "pdf") && !vfs.IsMountedID(f.doc.DocID) {
 		if f.thumbSecret == "" {
 			if secret, err := vfs.GetStore().AddThumb(f.instance, f.doc.DocID); err == nil {
 				f.thumbSecret = secret
@@ -0,0 +1,96 @@
+package instances
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/backup"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+func listBackups(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	snapshots, err := backup.List(inst.Domain)
+	if err != nil {
+		return wrapError(err)
+	}
+	if snapshots == nil {
+		snapshots = []*backup.Snapshot{}
+	}
+	for _, s := range snapshots {
+		s.StateKey = nil
+	}
+	return c.JSON(http.StatusOK, snapshots)
+}
+
+func createBackup(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if _, err := backup.RecipientKey(inst); err != nil {
+		return wrapError(err)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "backup",
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusAccepted, j)
+}
+
+func restoreBackup(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	var body struct {
+		DecryptorKey string `json:"decryptor_key"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	opts, err := backup.NewRestoreOptions(inst, c.Param("snapshot-id"), []byte(body.DecryptorKey))
+	if err != nil {
+		return wrapError(err)
+	}
+	msg, err := job.NewMessage(opts)
+	if err != nil {
+		return wrapError(err)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "restore",
+		Message:    msg,
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusAccepted, j)
+}
+
+func setBackupKey(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	var body struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := backup.SetUserKey(inst, body.PublicKey); err != nil {
+		if errors.Is(err, backup.ErrInvalidKey) {
+			return jsonapi.InvalidParameter("public_key", err)
+		}
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -770,6 +770,11 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/export", exporter)
 	router.GET("/:domain/exports/:export-id/data", dataExporter)
 	router.POST("/:domain/import", importer)
+	router.GET("/:domain/backups", listBackups)
+	router.POST("/:domain/backups", createBackup)
+	router.PUT("/:domain/backups/key", setBackupKey)
+	router.POST("/:domain/backups/:snapshot-id/restore", restoreBackup)
+	router.POST("/:domain/takeout", importTakeout)
 	router.GET("/:domain/disk-usage", diskUsage)
 	router.GET("/:domain/prefix", showPrefix)
 	router.GET("/:domain/swift-prefix", getSwiftBucketName)
@@ -791,6 +796,11 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/checks/shared", checkShared)
 	router.POST("/:domain/checks/sharings", checkSharings)
 
+	// Dead letters
+	router.GET("/:domain/jobs/dead-letters", listDeadLetters)
+	router.POST("/:domain/jobs/dead-letters/:id/retry", retryDeadLetter)
+	router.DELETE("/:domain/jobs/dead-letters", purgeDeadLetters)
+
 	// Fixers
 	router.POST("/:domain/fixers/password-defined", passwordDefinedFixer)
 	router.POST("/:domain/fixers/orphan-account", orphanAccountFixer)
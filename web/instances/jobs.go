@@ -0,0 +1,65 @@
+package instances
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+func listDeadLetters(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	letters, err := job.ListDeadLetters(inst, c.QueryParam("worker"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if letters == nil {
+		letters = []*job.DeadLetter{}
+	}
+	return c.JSON(http.StatusOK, letters)
+}
+
+func retryDeadLetter(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	letter, err := job.GetDeadLetter(inst, c.Param("id"))
+	if err != nil {
+		if err == job.ErrNotFoundDeadLetter {
+			return jsonapi.NotFound(err)
+		}
+		return wrapError(err)
+	}
+	j, err := letter.Retry(inst)
+	if err != nil {
+		if err == job.ErrUnknownWorker || err == job.ErrInvalidDeadLetter {
+			return jsonapi.BadRequest(err)
+		}
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, j)
+}
+
+func purgeDeadLetters(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	count, err := job.PurgeDeadLetters(inst, c.QueryParam("worker"))
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"count": count})
+}
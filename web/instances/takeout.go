@@ -0,0 +1,52 @@
+package instances
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/takeout"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+// importTakeout starts the import of archives that are local files on the
+// server, or files already in the VFS of the instance.
+func importTakeout(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	var body struct {
+		Paths     []string `json:"paths"`
+		FileIDs   []string `json:"file_ids"`
+		TargetDir string   `json:"target_dir"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	for _, p := range body.Paths {
+		if !filepath.IsAbs(p) {
+			return jsonapi.BadRequest(errors.New("the paths must be absolute"))
+		}
+	}
+	doc, err := takeout.Start(inst, takeout.StartOptions{
+		FileIDs:   body.FileIDs,
+		Paths:     body.Paths,
+		TargetDir: body.TargetDir,
+	})
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, doc)
+	case os.IsNotExist(err):
+		return jsonapi.NotFound(err)
+	case errors.Is(err, takeout.ErrMigrationConflict):
+		return jsonapi.Conflict(err)
+	case errors.Is(err, takeout.ErrNoSource), errors.Is(err, takeout.ErrInvalidSource),
+		errors.Is(err, takeout.ErrInvalidTargetDir):
+		return jsonapi.BadRequest(err)
+	}
+	return wrapError(err)
+}
@@ -31,19 +31,26 @@ import (
 	// import workers
 	_ "github.com/cozy/cozy-stack/worker/antivirus"
 	_ "github.com/cozy/cozy-stack/worker/archive"
+	_ "github.com/cozy/cozy-stack/worker/backup"
 	"github.com/cozy/cozy-stack/worker/exec"
 	_ "github.com/cozy/cozy-stack/worker/log"
 	_ "github.com/cozy/cozy-stack/worker/mails"
+	_ "github.com/cozy/cozy-stack/worker/metadata"
 	_ "github.com/cozy/cozy-stack/worker/migrations"
 	_ "github.com/cozy/cozy-stack/worker/moves"
+	_ "github.com/cozy/cozy-stack/worker/nextcloud"
 	_ "github.com/cozy/cozy-stack/worker/notes"
+	_ "github.com/cozy/cozy-stack/worker/notifications"
 	_ "github.com/cozy/cozy-stack/worker/oauth"
+	_ "github.com/cozy/cozy-stack/worker/photos"
 	_ "github.com/cozy/cozy-stack/worker/push"
 	_ "github.com/cozy/cozy-stack/worker/rag"
 	_ "github.com/cozy/cozy-stack/worker/share"
 	_ "github.com/cozy/cozy-stack/worker/sms"
+	_ "github.com/cozy/cozy-stack/worker/takeout"
 	_ "github.com/cozy/cozy-stack/worker/thumbnail"
 	_ "github.com/cozy/cozy-stack/worker/trash"
+	_ "github.com/cozy/cozy-stack/worker/webhooks"
 )
 
 type (
@@ -53,6 +60,7 @@ type (
 	apiJobRequest struct {
 		Arguments   json.RawMessage `json:"arguments"`
 		Manual      bool            `json:"manual"`
+		Priority    string          `json:"priority"`
 		ForwardLogs bool            `json:"forward_logs"`
 		Options     *apiJobOptions  `json:"options"`
 	}
@@ -192,7 +200,14 @@ func (h *HTTPHandler) getQueue(c echo.Context) error {
 		objs[i] = apiJob{j}
 	}
 
-	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+	count := len(objs)
+	meta := jsonapi.Meta{Count: &count}
+	if lens, err := job.System().WorkerQueueLenByInstance(workerType); err == nil {
+		depth := lens[instance.Domain]
+		meta.QueueDepth = &depth
+	}
+
+	return jsonapi.DataListWithMeta(c, http.StatusOK, meta, objs, nil)
 }
 
 func (h *HTTPHandler) pushJob(c echo.Context) error {
@@ -210,10 +225,16 @@ func (h *HTTPHandler) pushJob(c echo.Context) error {
 		}
 	}
 
+	priority := job.Priority(req.Priority)
+	if priority != "" && !priority.IsValid() {
+		return jsonapi.InvalidAttribute("priority", job.ErrInvalidPriority)
+	}
+
 	jr := &job.JobRequest{
 		WorkerType:  c.Param("worker-type"),
 		Options:     opts,
 		Manual:      req.Manual,
+		Priority:    priority,
 		ForwardLogs: req.ForwardLogs,
 		Message:     job.Message(req.Arguments),
 	}
@@ -679,6 +700,9 @@ func (h *HTTPHandler) patchJob(c echo.Context) error {
 	if j.WorkerType != "client" {
 		return middlewares.ErrForbidden
 	}
+	if j.State == job.Cancelled {
+		return wrapJobsError(job.ErrJobFinished)
+	}
 
 	req := job.Job{}
 	if _, err := jsonapi.Bind(c.Request().Body, &req); err != nil {
@@ -717,6 +741,23 @@ func (h *HTTPHandler) patchJob(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, apiJob{j}, nil)
 }
 
+func (h *HTTPHandler) cancelJob(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	j, err := job.Get(inst, c.Param("job-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	if err := middlewares.Allow(c, permission.DELETE, j); err != nil {
+		if !allowKonnectorForItsOwnJob(c, j) {
+			return err
+		}
+	}
+	if err := job.System().CancelJob(j); err != nil {
+		return wrapJobsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (h *HTTPHandler) cleanJobs(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	if err := middlewares.AllowWholeType(c, permission.POST, consts.Jobs); err != nil {
@@ -873,6 +914,7 @@ func (h *HTTPHandler) Register(router *echo.Group) {
 	router.DELETE("/purge", h.purgeJobs)
 	router.GET("/:job-id", h.getJob)
 	router.PATCH("/:job-id", h.patchJob)
+	router.DELETE("/:job-id", h.cancelJob)
 }
 
 func wrapJobsError(err error) error {
@@ -884,6 +926,8 @@ func wrapJobsError(err error) error {
 	case job.ErrUnknownTrigger,
 		job.ErrNotCronTrigger:
 		return jsonapi.InvalidAttribute("Type", err)
+	case job.ErrJobFinished:
+		return jsonapi.Conflict(err)
 	case emailer.ErrMissingSubject,
 		emailer.ErrMissingContent,
 		limits.ErrRateLimitReached,
@@ -910,11 +954,19 @@ func checkReservedWorker(worker string) error {
 }
 
 func allowKonnectorForItsOwnTrigger(c echo.Context, infos *job.TriggerInfos) bool {
-	if infos.WorkerType != "konnector" {
+	return allowKonnectorForItsOwnMessage(c, infos.WorkerType, infos.Message)
+}
+
+func allowKonnectorForItsOwnJob(c echo.Context, j *job.Job) bool {
+	return allowKonnectorForItsOwnMessage(c, j.WorkerType, j.Message)
+}
+
+func allowKonnectorForItsOwnMessage(c echo.Context, workerType string, message job.Message) bool {
+	if workerType != "konnector" {
 		return false
 	}
 	var msg map[string]interface{}
-	if errb := json.Unmarshal(infos.Message, &msg); errb != nil {
+	if errb := json.Unmarshal(message, &msg); errb != nil {
 		return false
 	}
 	slug, _ := msg["konnector"].(string)
@@ -100,6 +100,16 @@ func TestJobs(t *testing.T) {
 			Length().IsEqual(0)
 	})
 
+	t.Run("GetQueueDepth", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.GET("/jobs/queue/print").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.meta.queue_depth").Number().IsEqual(0)
+	})
+
 	t.Run("CreateJob", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -166,6 +176,29 @@ func TestJobs(t *testing.T) {
 		attrs := obj.Path("$.data.attributes").Object()
 		attrs.HasValue("worker", "print")
 		attrs.HasValue("manual_execution", true)
+		attrs.HasValue("priority", "interactive")
+	})
+
+	t.Run("CreateJobWithPriority", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.POST("/jobs/queue/print").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{"data": {"attributes": {"arguments": "foobar", "priority": "interactive"}}}`)).
+			Expect().Status(202).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		attrs := obj.Path("$.data.attributes").Object()
+		attrs.HasValue("priority", "interactive")
+		attrs.NotContainsKey("manual_execution")
+
+		e.POST("/jobs/queue/print").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{"data": {"attributes": {"arguments": "foobar", "priority": "urgent"}}}`)).
+			Expect().Status(422)
 	})
 
 	t.Run("CreateJobForReservedWorker", func(t *testing.T) {
@@ -683,6 +716,33 @@ func TestJobs(t *testing.T) {
 			attrs.Value("started_at").String().AsDateTime(time.RFC3339)
 			attrs.Value("finished_at").String().AsDateTime(time.RFC3339)
 		})
+
+		t.Run("CancelAClientJob", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			jobID := e.POST("/jobs/triggers/"+triggerID+"/launch").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(201).
+				JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+				Object().Path("$.data.id").String().NotEmpty().Raw()
+
+			e.DELETE("/jobs/"+jobID).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(204)
+
+			attrs := e.GET("/jobs/"+jobID).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+				Object().Path("$.data.attributes").Object()
+			attrs.HasValue("state", job.Cancelled)
+			attrs.Value("finished_at").String().AsDateTime(time.RFC3339)
+
+			// A finished job can't be cancelled
+			e.DELETE("/jobs/"+jobID).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(409)
+		})
 	})
 
 	t.Run("SendCampaignEmail", func(t *testing.T) {
@@ -0,0 +1,106 @@
+// Package mails is for the webhook where the mail provider reports the
+// bounces and complaints for the mails sent by the stack, and for the route
+// where the mail server gives the received mails.
+package mails
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/inbound"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// bounceReport is the body of a request on the bounces webhook.
+type bounceReport struct {
+	Type   string `json:"type"`
+	Email  string `json:"email"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Bounce is the webhook used to report that a mail has bounced permanently
+// or that the recipient has complained. The email address is added to the
+// suppression list of the instance and marked as invalid in the contacts.
+func Bounce(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	secret := config.GetMailTransport(inst.ContextName).BounceSecret
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	token := strings.TrimPrefix(header, "Bearer ")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return jsonapi.Unauthorized(errors.New("Invalid token"))
+	}
+
+	var report bounceReport
+	if err := c.Bind(&report); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if report.Email == "" {
+		return jsonapi.InvalidAttribute("email", errors.New("Missing email"))
+	}
+	var kind string
+	switch report.Type {
+	case "bounce":
+		kind = contact.SuppressionBounce
+	case "complaint":
+		kind = contact.SuppressionComplaint
+	default:
+		return jsonapi.InvalidAttribute("type", errors.New("Unknown type"))
+	}
+
+	if err := contact.Suppress(inst, report.Email, kind, report.Reason); err != nil {
+		return jsonapi.InternalServerError(err)
+	}
+	inst.Logger().WithNamespace("mails").
+		Infof("%s reported for %s: %s", kind, report.Email, report.Reason)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReceiveMail is used by the mail server to give a mail, in the RFC 5322
+// format, that has been received for an instance. The instance is resolved
+// from the envelope recipient.
+func ReceiveMail(c echo.Context) error {
+	recipient := c.QueryParam("recipient")
+	if recipient == "" {
+		return jsonapi.InvalidParameter("recipient", errors.New("Missing recipient"))
+	}
+	inst, settings, err := inbound.Resolve(recipient)
+	if err != nil {
+		return wrapInboundError(err)
+	}
+	doc, err := inbound.Ingest(inst, settings, c.Request().Body)
+	if err != nil {
+		inst.Logger().WithNamespace("inbound").
+			Infof("Cannot store a received mail: %s", err)
+		return wrapInboundError(err)
+	}
+	return c.JSON(http.StatusCreated, doc)
+}
+
+func wrapInboundError(err error) error {
+	switch err {
+	case inbound.ErrNotConfigured, inbound.ErrInvalidRecipient:
+		return jsonapi.NotFound(err)
+	case inbound.ErrInvalidMessage:
+		return jsonapi.BadRequest(err)
+	case inbound.ErrMessageTooBig, vfs.ErrFileTooBig:
+		return jsonapi.Errorf(http.StatusRequestEntityTooLarge, "%s", err)
+	}
+	return jsonapi.InternalServerError(err)
+}
+
+// Routes sets the routing for the webhooks of the mail provider.
+func Routes(router *echo.Group) {
+	router.POST("/bounces", Bounce)
+}
+
+// AdminRoutes sets the routing for the mail server on the admin API.
+func AdminRoutes(router *echo.Group) {
+	router.POST("/inbound", ReceiveMail)
+}
@@ -0,0 +1,147 @@
+package mails
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/inbound"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/cozy/cozy-stack/web/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBounces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	config.GetConfig().MailTransport.BounceSecret = "s3cr3t"
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	ts := setup.GetTestServer("/mails", Routes)
+	ts.Config.Handler.(*echo.Echo).HTTPErrorHandler = errors.ErrorHandler
+	t.Cleanup(ts.Close)
+
+	require.NoError(t, couchdb.DefineView(inst, couchdb.ContactByEmail))
+	bob, err := contact.Create(inst, contact.CreateOptions{
+		Email: "bob@example.com",
+		Name:  "Bob",
+	})
+	require.NoError(t, err)
+
+	t.Run("WithoutToken", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.POST("/mails/bounces").
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{"type": "bounce", "email": "bob@example.com"}`)).
+			Expect().Status(401)
+	})
+
+	t.Run("UnknownType", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.POST("/mails/bounces").
+			WithHeader("Authorization", "Bearer s3cr3t").
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{"type": "delivered", "email": "bob@example.com"}`)).
+			Expect().Status(422)
+	})
+
+	t.Run("Bounce", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.POST("/mails/bounces").
+			WithHeader("Authorization", "Bearer s3cr3t").
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{"type": "bounce", "email": "bob@example.com", "reason": "550 No such user"}`)).
+			Expect().Status(204)
+
+		suppressed, err := contact.IsSuppressed(inst, "bob@example.com")
+		require.NoError(t, err)
+		assert.True(t, suppressed)
+
+		doc, err := contact.Find(inst, bob.ID())
+		require.NoError(t, err)
+		emails, _ := doc.Get("email").([]interface{})
+		require.Len(t, emails, 1)
+		email, _ := emails[0].(map[string]interface{})
+		assert.Equal(t, true, email["invalid"])
+		assert.Equal(t, contact.SuppressionBounce, email["invalidReason"])
+	})
+}
+
+func TestReceiveMail(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	config.GetConfig().InboundMail.Domain = "in.cozy.example"
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	ts := setup.GetTestServer("/mails", AdminRoutes)
+	ts.Config.Handler.(*echo.Echo).HTTPErrorHandler = errors.ErrorHandler
+	t.Cleanup(ts.Close)
+
+	settings, err := inbound.Enable(inst, "/Receipts", true, false)
+	require.NoError(t, err)
+	recipient := settings.Address(inst)
+
+	raw := "From: shop@example.com\r\n" +
+		"To: " + recipient + "\r\n" +
+		"Subject: Receipt\r\n" +
+		"Message-ID: <receipt-1@example.com>\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Thanks for your purchase\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=receipt.pdf\r\n" +
+		"\r\n" +
+		"%PDF-1.4\r\n" +
+		"--b--\r\n"
+
+	t.Run("UnknownRecipient", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		e.POST("/mails/inbound").
+			WithQuery("recipient", inst.Domain+"+wrong@in.cozy.example").
+			WithBytes([]byte(raw)).
+			Expect().Status(404)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		obj := e.POST("/mails/inbound").
+			WithQuery("recipient", recipient).
+			WithBytes([]byte(raw)).
+			Expect().Status(201).
+			JSON().Object()
+		obj.Value("subject").IsEqual("Receipt")
+		obj.Value("note_id").String().NotEmpty()
+		obj.Value("attachments").Array().Length().IsEqual(1)
+
+		file, err := inst.VFS().FileByPath("/Receipts/receipt.pdf")
+		require.NoError(t, err)
+		assert.Equal(t, "application/pdf", file.Mime)
+		_, err = inst.VFS().FileByPath("/Receipts/Receipt.cozy-note")
+		assert.NoError(t, err)
+
+		// The same mail is not stored twice
+		e.POST("/mails/inbound").
+			WithQuery("recipient", recipient).
+			WithBytes([]byte(raw)).
+			Expect().Status(201)
+		_, err = inst.VFS().FileByPath("/Receipts/receipt (2).pdf")
+		assert.Error(t, err)
+	})
+}
@@ -383,6 +383,85 @@ func appendCSPRule(currentRules, ruleType string, appendedValues ...string) (new
 	return
 }
 
+// AppendCSPSources adds sources to a directive of the CSP policy, like
+// AppendCSPRule. When the directive is not in the policy, the browsers use
+// default-src for it: the sources of default-src are then copied in the new
+// directive, to not restrict it to the new sources.
+func AppendCSPSources(c echo.Context, directive string, sources ...string) {
+	currentRules := c.Response().Header().Get(echo.HeaderContentSecurityPolicy)
+	newRules := appendCSPSources(currentRules, directive, sources...)
+	c.Response().Header().Set(echo.HeaderContentSecurityPolicy, newRules)
+}
+
+func appendCSPSources(currentRules, directive string, sources ...string) string {
+	if cspDirectiveSources(currentRules, directive) == nil {
+		defaults := cspDirectiveSources(currentRules, "default-src")
+		if len(defaults) == 1 && defaults[0] == "'none'" {
+			defaults = nil
+		}
+		sources = append(defaults, sources...)
+	}
+	return appendCSPRule(currentRules, directive, sources...)
+}
+
+// cspDirectiveSources returns the sources of a directive of the CSP policy,
+// or nil if the directive is not in the policy.
+func cspDirectiveSources(rules, directive string) []string {
+	for _, rule := range strings.Split(rules, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) > 0 && fields[0] == directive {
+			return append([]string{}, fields[1:]...)
+		}
+	}
+	return nil
+}
+
+// SetCSPReporting adds the directives to send the violations of the CSP
+// policies, enforced and report-only, to the given URL: report-uri for the old
+// browsers, and report-to with the Reporting-Endpoints header for the new ones.
+func SetCSPReporting(c echo.Context, reportURL string) {
+	h := c.Response().Header()
+	reported := false
+	for _, header := range []string{
+		echo.HeaderContentSecurityPolicy,
+		echo.HeaderContentSecurityPolicyReportOnly,
+	} {
+		rules := h.Get(header)
+		if rules == "" {
+			continue
+		}
+		rules = appendCSPRule(rules, "report-uri", reportURL)
+		rules = appendCSPRule(rules, "report-to", cspReportGroup)
+		h.Set(header, rules)
+		reported = true
+	}
+	if reported {
+		h.Set("Reporting-Endpoints", cspReportGroup+`="`+reportURL+`"`)
+	}
+}
+
+// cspReportGroup is the name of the endpoint for the CSP reports in the
+// Reporting-Endpoints header.
+const cspReportGroup = "csp-endpoint"
+
+// SetCSPReportOnly sends the current CSP policy as a candidate policy in the
+// report-only header, and restores the enforced policy given in parameter:
+// the browsers report the violations of the candidate policy, but still
+// enforce the other one. It can be used to roll out a stricter policy.
+func SetCSPReportOnly(c echo.Context, enforced string) {
+	h := c.Response().Header()
+	candidate := h.Get(echo.HeaderContentSecurityPolicy)
+	if candidate == "" || candidate == enforced {
+		return
+	}
+	h.Set(echo.HeaderContentSecurityPolicyReportOnly, candidate)
+	if enforced != "" {
+		h.Set(echo.HeaderContentSecurityPolicy, enforced)
+	} else {
+		h.Del(echo.HeaderContentSecurityPolicy)
+	}
+}
+
 // safeDomainRe matches hostname values safe to embed in a CSP header
 // (alphanumeric, hyphens, dots — no spaces, semicolons, or other
 // CSP-significant characters that could break or inject new directives).
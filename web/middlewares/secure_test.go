@@ -98,6 +98,43 @@ func TestSecure(t *testing.T) {
 		assert.Equal(t, "script-src 'wasm-unsafe-eval';connect-src blob:;worker-src 'self' blob:;", rec5.Header().Get(echo.HeaderContentSecurityPolicy))
 	})
 
+	t.Run("AppendCSPSources", func(t *testing.T) {
+		r := appendCSPSources("default-src 'self';img-src data:;", "img-src", "https://tiles")
+		assert.Equal(t, "default-src 'self';img-src data: https://tiles;", r)
+
+		r = appendCSPSources("default-src 'self' https://parent;img-src data:;", "media-src", "https://media")
+		assert.Equal(t, "default-src 'self' https://parent;img-src data:;media-src 'self' https://parent https://media;", r)
+
+		r = appendCSPSources("default-src 'none';", "connect-src", "https://api")
+		assert.Equal(t, "default-src 'none';connect-src https://api;", r)
+	})
+
+	t.Run("CSPReporting", func(t *testing.T) {
+		e := echo.New()
+		req, _ := http.NewRequest(echo.GET, "http://app.cozy.local/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Response().Header().Set(echo.HeaderContentSecurityPolicy, "default-src 'self';frame-ancestors 'self' https://cozy.local;")
+		SetCSPReporting(c, "https://cozy.local/csp/report?slug=app")
+		assert.Equal(t, "default-src 'self';frame-ancestors 'self' https://cozy.local;report-uri https://cozy.local/csp/report?slug=app;report-to csp-endpoint;",
+			rec.Header().Get(echo.HeaderContentSecurityPolicy))
+		assert.Equal(t, `csp-endpoint="https://cozy.local/csp/report?slug=app"`, rec.Header().Get("Reporting-Endpoints"))
+
+		req2, _ := http.NewRequest(echo.GET, "http://app.cozy.local/", nil)
+		rec2 := httptest.NewRecorder()
+		c2 := e.NewContext(req2, rec2)
+		enforced := "default-src 'self';frame-ancestors 'self' https://cozy.local;"
+		c2.Response().Header().Set(echo.HeaderContentSecurityPolicy, enforced)
+		AppendCSPSources(c2, "img-src", "https://tiles")
+		SetCSPReportOnly(c2, enforced)
+		SetCSPReporting(c2, "https://cozy.local/csp/report?slug=app")
+		assert.Equal(t, "default-src 'self';frame-ancestors 'self' https://cozy.local;report-uri https://cozy.local/csp/report?slug=app;report-to csp-endpoint;",
+			rec2.Header().Get(echo.HeaderContentSecurityPolicy))
+		reportOnly := rec2.Header().Get(echo.HeaderContentSecurityPolicyReportOnly)
+		assert.Contains(t, reportOnly, "img-src 'self' https://tiles;")
+		assert.Contains(t, reportOnly, "report-to csp-endpoint;")
+	})
+
 	t.Run("AppendCSPRule", func(t *testing.T) {
 		r := appendCSPRule("", "frame-ancestors", "new-rule")
 		assert.Equal(t, "frame-ancestors new-rule;", r)
@@ -275,7 +275,7 @@ func wsImporting(c echo.Context) error {
 			if len(worker) != 1 || worker[0] != "import" || len(state) != 1 {
 				continue
 			}
-			if s := job.State(state[0]); s != job.Done && s != job.Errored {
+			if s := job.State(state[0]); s != job.Done && s != job.Errored && s != job.Cancelled {
 				continue
 			}
 			wsDone(ws, inst)
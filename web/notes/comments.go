@@ -0,0 +1,196 @@
+package notes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// ListComments is the API handler for GET /notes/:id/comments. It returns the
+// comments of the note, with the anchors of the discussions.
+func ListComments(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	comments, err := note.ListComments(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(comments))
+	for i, comment := range comments {
+		objs[i] = comment
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateComment is the API handler for POST /notes/:id/comments. It starts a
+// new discussion anchored to a range of the note, or replies in a discussion.
+func CreateComment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PATCH, file); err != nil {
+		return err
+	}
+
+	comment := &note.Comment{}
+	if _, err := jsonapi.Bind(c.Request().Body, comment); err != nil {
+		return err
+	}
+	comment.Author = getCommentAuthor(c)
+
+	comment, err = note.CreateComment(inst, file, comment)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, comment, nil)
+}
+
+// UpdateComment is the API handler for PATCH /notes/:id/comments/:comment-id.
+// It changes the content of a comment, and can only be used by its author or
+// by the owner of the note.
+func UpdateComment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, comment, err := getFileAndComment(c, permission.PATCH)
+	if err != nil {
+		return err
+	}
+
+	patch := &note.Comment{}
+	if _, err := jsonapi.Bind(c.Request().Body, patch); err != nil {
+		return err
+	}
+
+	comment, err = note.UpdateComment(inst, file, comment.ID(), patch)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, comment, nil)
+}
+
+// DeleteComment is the API handler for DELETE /notes/:id/comments/:comment-id.
+// It removes a comment (and its replies if it starts a discussion), and can
+// only be used by its author or by the owner of the note.
+func DeleteComment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, comment, err := getFileAndComment(c, permission.PATCH)
+	if err != nil {
+		return err
+	}
+
+	if err := note.DeleteComment(inst, file, comment.ID()); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ResolveThread is the API handler for POST
+// /notes/:id/comments/:comment-id/resolve. It marks a discussion as resolved.
+func ResolveThread(c echo.Context) error {
+	return setResolved(c, true)
+}
+
+// ReopenThread is the API handler for POST
+// /notes/:id/comments/:comment-id/reopen. It reopens a resolved discussion.
+func ReopenThread(c echo.Context) error {
+	return setResolved(c, false)
+}
+
+func setResolved(c echo.Context, resolved bool) error {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PATCH, file); err != nil {
+		return err
+	}
+
+	author := getCommentAuthor(c)
+	threadID := c.Param("id") + "/" + c.Param("comment-id")
+	thread, err := note.ResolveThread(inst, file, threadID, resolved, author)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, thread, nil)
+}
+
+// getFileAndComment checks the permission on the note, and that the comment
+// can be modified by the current user: only the author of a comment and the
+// owner of the note can change it.
+func getFileAndComment(c echo.Context, verb permission.Verb) (*vfs.FileDoc, *note.Comment, error) {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, verb, file); err != nil {
+		return nil, nil, err
+	}
+
+	commentID := c.Param("id") + "/" + c.Param("comment-id")
+	comment, err := note.GetComment(inst, file, commentID)
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+
+	author := getCommentAuthor(c)
+	isOwner := author.Instance == inst.PageURL("", nil)
+	if !isOwner && !comment.IsFrom(author) {
+		return nil, nil, jsonapi.Forbidden(errors.New("Only the author can modify this comment"))
+	}
+	return file, comment, nil
+}
+
+// getCommentAuthor returns the author for the comments made with the current
+// request: the member of the sharing when a sharecode is used, or else the
+// owner of the instance.
+func getCommentAuthor(c echo.Context) note.CommentAuthor {
+	inst := middlewares.GetInstance(c)
+	if perm, err := middlewares.GetPermission(c); err == nil {
+		if perm.Type == permission.TypeSharePreview || perm.Type == permission.TypeShareInteract {
+			sharingID := strings.TrimPrefix(perm.SourceID, consts.Sharings+"/")
+			if s, err := sharing.FindSharing(inst, sharingID); err == nil {
+				sharecode := middlewares.GetRequestToken(c)
+				if m, err := s.FindMemberByCode(perm, sharecode); err == nil {
+					return note.CommentAuthor{
+						Name:     m.PrimaryName(),
+						Email:    m.Email,
+						Instance: m.Instance,
+					}
+				}
+			}
+			return note.CommentAuthor{}
+		}
+	}
+
+	name, _ := inst.SettingsPublicName()
+	email, _ := inst.SettingsEMail()
+	return note.CommentAuthor{
+		Name:     name,
+		Email:    email,
+		Instance: inst.PageURL("", nil),
+	}
+}
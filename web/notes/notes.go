@@ -385,6 +385,61 @@ func UpdateNoteSchema(c echo.Context) error {
 	return files.FileData(c, http.StatusOK, file, false, nil, nil)
 }
 
+// ExportNote is the API handler for GET /notes/:id/export?format=xxx. It
+// returns the note converted to HTML, PDF, DOCX or ODT, with its images.
+func ExportNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	exported, err := note.Export(inst, file, c.QueryParam("format"))
+	if err != nil {
+		return wrapError(err)
+	}
+	disposition := vfs.ContentDisposition("attachment", exported.Name)
+	c.Response().Header().Set(echo.HeaderContentDisposition, disposition)
+	return c.Blob(http.StatusOK, exported.Mime, exported.Content)
+}
+
+// SaveNoteExport is the API handler for POST /notes/:id/export?format=xxx.
+// It converts the note like ExportNote, but the result is saved as a new file
+// in the directory given by the dir_id parameter (by default, the directory
+// of the note).
+func SaveNoteExport(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fs := inst.VFS()
+	file, err := fs.FileByID(c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	dirID := c.QueryParam("dir_id")
+	if dirID == "" {
+		dirID = file.DirID
+	}
+	dir, err := fs.DirByID(dirID)
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.POST, dir); err != nil {
+		return err
+	}
+
+	newdoc, err := note.SaveExport(inst, file, c.QueryParam("format"), dir.ID())
+	if err != nil {
+		return wrapError(err)
+	}
+	return files.FileData(c, http.StatusCreated, newdoc, false, nil, nil)
+}
+
 // UploadImage is the API handler for POST /notes/:id/images. It uploads an
 // image for the note.
 func UploadImage(c echo.Context) error {
@@ -500,6 +555,39 @@ func GetImage(c echo.Context) error {
 	return inst.ThumbsFS().ServeNoteThumbContent(c.Response(), c.Request(), imageID)
 }
 
+// ImportNote is the API handler for POST /notes/import/:file-id. It converts
+// a DOCX or ODT file to a new note, saved in the directory given by the dir_id
+// parameter (by default, the directory of the file).
+func ImportNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fs := inst.VFS()
+	file, err := fs.FileByID(c.Param("file-id"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	dirID := c.QueryParam("dir_id")
+	if dirID == "" {
+		dirID = file.DirID
+	}
+	dir, err := fs.DirByID(dirID)
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, permission.POST, dir); err != nil {
+		return err
+	}
+
+	newdoc, err := note.ImportOffice(inst, file, dir.ID())
+	if err != nil {
+		return wrapError(err)
+	}
+	return files.FileData(c, http.StatusCreated, newdoc, false, nil, nil)
+}
+
 // Routes sets the routing for the collaborative edition of notes.
 func Routes(router *echo.Group) {
 	router.POST("", CreateNote)
@@ -508,12 +596,27 @@ func Routes(router *echo.Group) {
 	router.GET("/:id/steps", GetSteps)
 	router.GET("/:id/text", GetNoteText)
 	router.GET("/texts", GetTexts)
+	router.POST("/import/:file-id", ImportNote)
 	router.PATCH("/:id", PatchNote)
 	router.PUT("/:id/title", ChangeTitle)
 	router.PUT("/:id/telepointer", PutTelepointer)
 	router.POST("/:id/sync", ForceNoteSync)
 	router.GET("/:file-id/open", OpenNoteURL)
 	router.PUT("/:id/schema", UpdateNoteSchema)
+	router.GET("/:id/export", ExportNote)
+	router.POST("/:id/export", SaveNoteExport)
+	router.GET("/:id/comments", ListComments)
+	router.POST("/:id/comments", CreateComment)
+	router.PATCH("/:id/comments/:comment-id", UpdateComment)
+	router.DELETE("/:id/comments/:comment-id", DeleteComment)
+	router.POST("/:id/comments/:comment-id/resolve", ResolveThread)
+	router.POST("/:id/comments/:comment-id/reopen", ReopenThread)
+	router.GET("/:id/snapshots", ListSnapshots)
+	router.POST("/:id/snapshots", CreateSnapshot)
+	router.DELETE("/:id/snapshots/:snapshot-id", DeleteSnapshot)
+	router.GET("/:id/snapshots/:snapshot-id/text", GetSnapshotText)
+	router.GET("/:id/snapshots/:snapshot-id/diff", DiffSnapshot)
+	router.POST("/:id/snapshots/:snapshot-id/restore", RestoreSnapshot)
 	router.POST("/:id/images", UploadImage)
 	router.POST("/:id/:image-id/copy", CopyImage)
 	router.GET("/:id/images/:image-id/:secret", GetImage)
@@ -525,8 +628,20 @@ func wrapError(err error) *jsonapi.Error {
 		return jsonapi.InvalidAttribute("schema", err)
 	case note.ErrInvalidFile, sharing.ErrCannotOpenFile:
 		return jsonapi.NotFound(err)
+	case note.ErrInvalidExportFormat:
+		return jsonapi.InvalidParameter("format", err)
+	case note.ErrInvalidImportFormat:
+		return jsonapi.Errorf(http.StatusUnsupportedMediaType, "%s", err)
 	case note.ErrNoSteps, note.ErrInvalidSteps:
 		return jsonapi.BadRequest(err)
+	case note.ErrCommentNotFound, note.ErrSnapshotNotFound:
+		return jsonapi.NotFound(err)
+	case note.ErrEmptyComment:
+		return jsonapi.InvalidAttribute("content", err)
+	case note.ErrInvalidAnchor:
+		return jsonapi.InvalidAttribute("anchor", err)
+	case note.ErrNoAnnotationMark:
+		return jsonapi.InvalidAttribute("schema", err)
 	case note.ErrCannotApply:
 		return jsonapi.Conflict(err)
 	case os.ErrNotExist, vfs.ErrParentDoesNotExist, vfs.ErrParentInTrash:
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -612,6 +613,35 @@ func TestNotes(t *testing.T) {
 		assert.Equal(t, "Hello world", string(buf))
 	})
 
+	t.Run("ExportNote", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.GET("/notes/"+noteID+"/export").
+			WithQuery("format", "rtf").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(400)
+
+		res := e.GET("/notes/"+noteID+"/export").
+			WithQuery("format", "html").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200)
+		res.Header("Content-Type").HasPrefix("text/html")
+		res.Header("Content-Disposition").Contains(".html")
+		res.Body().Contains("Hello world")
+
+		obj := e.POST("/notes/"+noteID+"/export").
+			WithQuery("format", "docx").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		attrs := obj.Path("$.data.attributes").Object()
+		attrs.Value("name").String().HasSuffix(".docx")
+		attrs.HasValue("class", "text")
+		attrs.Value("size").String().NotEqual("0")
+	})
+
 	t.Run("NoteRealtime", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -1058,6 +1088,297 @@ func TestNotes(t *testing.T) {
 			WithHeader("Authorization", "Bearer "+token).
 			Expect().Status(200)
 	})
+
+	t.Run("Comments", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		doc := &note.Document{
+			Title:      "A note with comments",
+			SchemaSpec: note.DefaultSchemaSpecs(),
+			RawContent: map[string]interface{}{
+				"type": "doc",
+				"content": []interface{}{
+					map[string]interface{}{
+						"type": "paragraph",
+						"content": []interface{}{
+							map[string]interface{}{"type": "text", "text": "Hello world"},
+						},
+					},
+				},
+			},
+		}
+		file, err := note.Create(inst, doc)
+		require.NoError(t, err)
+		fileID := file.ID()
+
+		e.POST("/notes/"+fileID+"/comments").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{
+        "data": {
+          "type": "io.cozy.notes.comments",
+          "attributes": { "content": "No anchor" }
+        }
+      }`)).
+			Expect().Status(422)
+
+		obj := e.POST("/notes/"+fileID+"/comments").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{
+        "data": {
+          "type": "io.cozy.notes.comments",
+          "attributes": {
+            "content": "Should we say hi instead?",
+            "anchor": { "from": 1, "to": 6, "version": 0 }
+          }
+        }
+      }`)).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		data := obj.Value("data").Object()
+		data.HasValue("type", consts.NotesComments)
+		threadID := data.Value("id").String().NotEmpty().Raw()
+		attrs := data.Value("attributes").Object()
+		attrs.HasValue("thread_id", threadID)
+		attrs.Path("$.author.name").String().NotEmpty()
+		attrs.Path("$.anchor.quote").IsEqual("Hello")
+		attrs.Path("$.anchor.version").IsEqual(1)
+
+		// The annotation mark has been added via a step
+		steps, err := note.GetSteps(inst, fileID, 0)
+		require.NoError(t, err)
+		require.Len(t, steps, 1)
+		assert.Equal(t, "addMark", steps[0]["stepType"])
+
+		e.POST("/notes/"+fileID+"/comments").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{
+        "data": {
+          "type": "io.cozy.notes.comments",
+          "attributes": { "content": "Yes!", "thread_id": "` + threadID + `" }
+        }
+      }`)).
+			Expect().Status(201)
+
+		obj = e.GET("/notes/"+fileID+"/comments").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		comments := obj.Value("data").Array()
+		comments.Length().IsEqual(2)
+		comments.Value(0).Path("$.attributes.anchor.from").IsEqual(1)
+		comments.Value(0).Path("$.attributes.anchor.to").IsEqual(6)
+		comments.Value(1).Path("$.attributes.thread_id").IsEqual(threadID)
+		comments.Value(1).Path("$.attributes.content").IsEqual("Yes!")
+
+		shortID := strings.TrimPrefix(threadID, fileID+"/")
+		obj = e.POST("/notes/"+fileID+"/comments/"+shortID+"/resolve").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Path("$.data.attributes.resolved").IsEqual(true)
+		obj.Path("$.data.attributes.resolved_at").String().NotEmpty()
+
+		obj = e.POST("/notes/"+fileID+"/comments/"+shortID+"/reopen").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Path("$.data.attributes").Object().NotContainsKey("resolved")
+
+		e.DELETE("/notes/"+fileID+"/comments/"+shortID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(204)
+
+		e.GET("/notes/"+fileID+"/comments").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Value("data").Array().IsEmpty()
+	})
+
+	t.Run("Snapshots", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		doc := &note.Document{
+			Title:      "A note with snapshots",
+			SchemaSpec: note.DefaultSchemaSpecs(),
+			RawContent: map[string]interface{}{
+				"type": "doc",
+				"content": []interface{}{
+					map[string]interface{}{
+						"type": "paragraph",
+						"content": []interface{}{
+							map[string]interface{}{"type": "text", "text": "First line"},
+						},
+					},
+				},
+			},
+		}
+		file, err := note.Create(inst, doc)
+		require.NoError(t, err)
+		fileID := file.ID()
+
+		obj := e.POST("/notes/"+fileID+"/snapshots").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{
+        "data": {
+          "type": "io.cozy.notes.snapshots",
+          "attributes": { "name": "Before the changes" }
+        }
+      }`)).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		data := obj.Value("data").Object()
+		data.HasValue("type", consts.NotesSnapshots)
+		snapshotID := data.Value("id").String().NotEmpty().Raw()
+		data.Path("$.attributes.name").IsEqual("Before the changes")
+		data.Path("$.attributes.kind").IsEqual("manual")
+		data.Path("$.relationships.version.data.type").IsEqual(consts.FilesVersions)
+		shortID := strings.TrimPrefix(snapshotID, fileID+"/")
+
+		e.PATCH("/notes/"+fileID).
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithHeader("If-Match", "0").
+			WithBytes([]byte(`{
+        "data": [{
+          "type": "io.cozy.notes.steps",
+          "attributes": {
+            "sessionID": "543781490137",
+            "stepType": "replace",
+            "from": 12,
+            "to": 12,
+            "slice": {
+              "content": [{
+                "type": "paragraph",
+                "content": [{ "type": "text", "text": "Second line" }]
+              }]
+            }
+          }
+        }]
+      }`)).
+			Expect().Status(200)
+
+		text := e.GET("/notes/"+fileID+"/snapshots/"+shortID+"/text").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			Body().Raw()
+		assert.Contains(t, text, "First line")
+		assert.NotContains(t, text, "Second line")
+
+		obj = e.GET("/notes/"+fileID+"/snapshots/"+shortID+"/diff").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		changes := obj.Value("changes").Array()
+		changes.Value(0).Object().HasValue("op", "equal")
+		changes.Value(1).Object().HasValue("op", "insert")
+		changes.Value(1).Object().Value("text").String().Contains("Second line")
+
+		e.POST("/notes/"+fileID+"/snapshots/"+shortID+"/restore").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200)
+
+		current, err := inst.VFS().FileByID(fileID)
+		require.NoError(t, err)
+		restored, err := note.GetText(inst, current)
+		require.NoError(t, err)
+		assert.Contains(t, restored, "First line")
+		assert.NotContains(t, restored, "Second line")
+
+		// The restore is a step that the editors can rebase on
+		steps, err := note.GetSteps(inst, fileID, 1)
+		require.NoError(t, err)
+		require.Len(t, steps, 1)
+		assert.Equal(t, "replace", steps[0]["stepType"])
+
+		obj = e.GET("/notes/"+fileID+"/snapshots").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		snapshots := obj.Value("data").Array()
+		snapshots.Length().IsEqual(2)
+		snapshots.Value(1).Path("$.attributes.kind").IsEqual("before-restore")
+
+		e.DELETE("/notes/"+fileID+"/snapshots/"+shortID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(204)
+
+		e.GET("/notes/"+fileID+"/snapshots/"+shortID+"/text").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+	})
+
+	t.Run("ImportOfficeDocuments", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		file, err := inst.VFS().FileByID(noteID)
+		require.NoError(t, err)
+		exported, err := note.Export(inst, file, note.ExportDOCX)
+		require.NoError(t, err)
+
+		// Convert the document when it is uploaded as a note
+		obj := e.POST("/files/io.cozy.files.root-dir").
+			WithQuery("Type", "file").
+			WithQuery("Name", "A converted document.cozy-note").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", exported.Mime).
+			WithBytes(exported.Content).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		attrs := obj.Path("$.data.attributes").Object()
+		attrs.HasValue("mime", consts.NoteMimeType)
+		meta := attrs.Value("metadata").Object()
+		meta.HasValue("title", "A converted document")
+		meta.Value("content").Object().NotEmpty()
+		convertedID := obj.Path("$.data.id").String().NotEmpty().Raw()
+		converted, err := inst.VFS().FileByID(convertedID)
+		require.NoError(t, err)
+		text, err := note.GetText(inst, converted)
+		require.NoError(t, err)
+		assert.Contains(t, text, "Hello world")
+
+		// Import a document that is already in the VFS
+		obj = e.POST("/files/io.cozy.files.root-dir").
+			WithQuery("Type", "file").
+			WithQuery("Name", "Meeting.docx").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", exported.Mime).
+			WithBytes(exported.Content).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		docxID := obj.Path("$.data.id").String().NotEmpty().Raw()
+
+		obj = e.POST("/notes/import/"+docxID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		attrs = obj.Path("$.data.attributes").Object()
+		attrs.HasValue("name", "Meeting.cozy-note")
+		attrs.HasValue("mime", consts.NoteMimeType)
+		attrs.Path("$.metadata.title").IsEqual("Meeting")
+
+		// A note is not an office document
+		e.POST("/notes/import/"+noteID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(415)
+	})
 }
 
 func assertInitialNote(t *testing.T, obj *httpexpect.Object) {
@@ -0,0 +1,143 @@
+package notes
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/files"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// ListSnapshots is the API handler for GET /notes/:id/snapshots. It returns
+// the snapshots of the note.
+func ListSnapshots(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.GET)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := note.ListSnapshots(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(snapshots))
+	for i, snapshot := range snapshots {
+		objs[i] = snapshot
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateSnapshot is the API handler for POST /notes/:id/snapshots. It takes a
+// snapshot of the current state of the note.
+func CreateSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+
+	attrs := &note.Snapshot{}
+	if c.Request().ContentLength != 0 {
+		if _, err := jsonapi.Bind(c.Request().Body, attrs); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := note.CreateSnapshot(inst, file, attrs.Name)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, snapshot, nil)
+}
+
+// DeleteSnapshot is the API handler for DELETE
+// /notes/:id/snapshots/:snapshot-id. It removes a snapshot.
+func DeleteSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+
+	if err := note.DeleteSnapshot(inst, file, snapshotID(c)); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetSnapshotText is the API handler for GET
+// /notes/:id/snapshots/:snapshot-id/text. It returns the text of the note for
+// this snapshot.
+func GetSnapshotText(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.GET)
+	if err != nil {
+		return err
+	}
+
+	text, err := note.GetSnapshotText(inst, file, snapshotID(c))
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.String(http.StatusOK, text)
+}
+
+// DiffSnapshot is the API handler for GET
+// /notes/:id/snapshots/:snapshot-id/diff. It compares the text of the snapshot
+// with the current text of the note, or with another snapshot.
+func DiffSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.GET)
+	if err != nil {
+		return err
+	}
+
+	var otherID string
+	if with := c.QueryParam("With"); with != "" {
+		otherID = file.ID() + "/" + with
+	}
+	diff, err := note.DiffSnapshot(inst, file, snapshotID(c), otherID)
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"changes": diff})
+}
+
+// RestoreSnapshot is the API handler for POST
+// /notes/:id/snapshots/:snapshot-id/restore. It replaces the content of the
+// note by the content of the snapshot.
+func RestoreSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	file, err := getNoteFile(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+
+	file, err = note.RestoreSnapshot(inst, file, snapshotID(c))
+	if err != nil {
+		return wrapError(err)
+	}
+	return files.FileData(c, http.StatusOK, file, false, nil, nil)
+}
+
+func getNoteFile(c echo.Context, verb permission.Verb) (*vfs.FileDoc, error) {
+	inst := middlewares.GetInstance(c)
+	file, err := inst.VFS().FileByID(c.Param("id"))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if err := middlewares.AllowVFS(c, verb, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func snapshotID(c echo.Context) string {
+	return c.Param("id") + "/" + c.Param("snapshot-id")
+}
@@ -7,6 +7,9 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/notification/webpush"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -50,13 +53,111 @@ func createHandler(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusCreated, &apiNotif{n}, nil)
 }
 
+type apiPreferences struct {
+	*center.Preferences
+}
+
+func (p *apiPreferences) Relationships() jsonapi.RelationshipMap { return nil }
+func (p *apiPreferences) Included() []jsonapi.Object             { return nil }
+func (p *apiPreferences) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/notifications/preferences"}
+}
+
+func getPreferences(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs, err := center.GetPreferences(inst)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs}, nil)
+}
+
+func updatePreferences(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs := &center.Preferences{}
+	if _, err := jsonapi.Bind(c.Request().Body, prefs); err != nil {
+		return err
+	}
+	if err := center.SavePreferences(inst, prefs); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs}, nil)
+}
+
+func getWebPushKey(c echo.Context) error {
+	key, err := webpush.PublicKey()
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"public_key": key})
+}
+
+func subscribeWebPush(c echo.Context) error {
+	// The browser will receive the content of all the notifications.
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Notifications); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	sub := &webpush.Subscription{}
+	if err := json.NewDecoder(c.Request().Body).Decode(sub); err != nil {
+		return jsonapi.BadJSON()
+	}
+	sub.ClientID, sub.SessionID = "", ""
+	perm, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if client, ok := perm.Client.(*oauth.Client); ok && perm.Type == permission.TypeOauth {
+		sub.ClientID = client.ID()
+	} else if sess, ok := middlewares.GetSession(c); ok {
+		sub.SessionID = sess.ID()
+	} else {
+		return middlewares.ErrForbidden
+	}
+	sub.UserAgent = c.Request().UserAgent()
+	if err := webpush.Subscribe(inst, sub); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func unsubscribeWebPush(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var args struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&args); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if _, err := middlewares.GetPermission(c); err != nil {
+		return err
+	}
+	if err := webpush.Unsubscribe(inst, args.Endpoint); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return jsonapi.NotFound(err)
+		}
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func wrapErrors(err error) error {
 	if err == nil {
 		return nil
 	}
 	switch err {
-	case center.ErrBadNotification:
+	case center.ErrBadNotification, center.ErrBadPreferences:
 		return jsonapi.BadRequest(err)
+	case webpush.ErrInvalidSubscription:
+		return jsonapi.InvalidAttribute("keys", err)
+	case webpush.ErrNotConfigured:
+		return jsonapi.NotFound(err)
 	case center.ErrUnauthorized:
 		return jsonapi.Forbidden(err)
 	case center.ErrNoCategory:
@@ -72,4 +173,9 @@ func wrapErrors(err error) error {
 // Routes sets the routing for the notification service.
 func Routes(router *echo.Group) {
 	router.POST("", createHandler)
+	router.GET("/preferences", getPreferences)
+	router.PUT("/preferences", updatePreferences)
+	router.GET("/webpush/key", getWebPushKey)
+	router.POST("/webpush", subscribeWebPush)
+	router.DELETE("/webpush", unsubscribeWebPush)
 }
@@ -0,0 +1,107 @@
+// Package photos is for the routes to configure the auto albums, and to list
+// the duplicate photos.
+package photos
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/photo"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type autoAlbumsRequest struct {
+	MaxGap      string  `json:"max_gap"`
+	MaxDistance float64 `json:"max_distance"`
+	MinSize     int     `json:"min_size"`
+}
+
+func getAutoAlbums(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.PhotosSettings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	s, err := photo.GetSettings(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, s, nil)
+}
+
+func enableAutoAlbums(c echo.Context) error {
+	// The clustering creates albums and adds references to the files
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.PhotosSettings); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.PhotosAlbums); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.PATCH, consts.Files); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	var body autoAlbumsRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&body); err != nil {
+			return jsonapi.BadRequest(errors.New("invalid JSON body"))
+		}
+	}
+	s, err := photo.Enable(inst, &photo.Settings{
+		MaxGap:      body.MaxGap,
+		MaxDistance: body.MaxDistance,
+		MinSize:     body.MinSize,
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, s, nil)
+}
+
+func disableAutoAlbums(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.PhotosSettings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if _, err := photo.Disable(inst); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// listDuplicates returns the groups of photos that look the same, for example
+// the same photo uploaded in several resolutions or formats.
+func listDuplicates(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Files); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	groups, err := photo.FindDuplicates(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	objs := make([]jsonapi.Object, len(groups))
+	for i, group := range groups {
+		objs[i] = group
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func wrapError(err error) error {
+	if errors.Is(err, photo.ErrInvalidOptions) {
+		return jsonapi.BadRequest(err)
+	}
+	return jsonapi.InternalServerError(err)
+}
+
+// Routes sets the routing for the photos.
+func Routes(router *echo.Group) {
+	router.GET("/albums/auto", getAutoAlbums)
+	router.POST("/albums/auto", enableAutoAlbums)
+	router.DELETE("/albums/auto", disableAutoAlbums)
+	router.GET("/duplicates", listDuplicates)
+}
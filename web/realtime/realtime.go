@@ -2,6 +2,7 @@ package realtime
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -32,7 +33,7 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer
-	maxMessageSize = 1024
+	maxMessageSize = 4096
 )
 
 var upgrader = websocket.Upgrader{
@@ -46,8 +47,9 @@ var upgrader = websocket.Upgrader{
 type command struct {
 	Method  string `json:"method"`
 	Payload struct {
-		Type string `json:"type"`
-		ID   string `json:"id"`
+		Type     string                 `json:"type"`
+		ID       string                 `json:"id"`
+		Selector map[string]interface{} `json:"selector,omitempty"`
 	} `json:"payload"`
 }
 
@@ -122,6 +124,18 @@ func missingType(cmd *command) *wsError {
 	}
 }
 
+func invalidSelector(cmd *command, err error) *wsError {
+	return &wsError{
+		Event: "error",
+		Payload: wsErrorPayload{
+			Status: "400 Bad Request",
+			Code:   "bad request",
+			Title:  fmt.Sprintf("The selector is invalid: %s", err),
+			Source: cmd,
+		},
+	}
+}
+
 func sendErr(ctx context.Context, errc chan *wsError, e *wsError) {
 	select {
 	case errc <- e:
@@ -148,6 +162,84 @@ func authorized(i *instance.Instance, perms permission.Set, permType, id string)
 	}
 }
 
+// authorizedSelector returns true if the permissions allow to receive the
+// events for the documents matching the selector. If the permission set does
+// not cover the whole doctype, the selector must restrict the documents to
+// the values of a rule (or to an allowed directory for files).
+func authorizedSelector(i *instance.Instance, perms permission.Set, permType string, selector map[string]interface{}) bool {
+	if perms.AllowWholeType(permission.GET, permType) {
+		return true
+	}
+	if permType == consts.Files {
+		if dirIDs := selectorValues(selector, "dir_id"); len(dirIDs) > 0 {
+			allowed := true
+			for _, dirID := range dirIDs {
+				if !authorized(i, perms, permType, dirID) {
+					allowed = false
+					break
+				}
+			}
+			if allowed {
+				return true
+			}
+		}
+	}
+	return perms.Some(func(r permission.Rule) bool {
+		if !r.Verbs.Contains(permission.GET) || !permission.MatchType(r, permType) {
+			return false
+		}
+		if r.Selector == "" || len(r.Values) == 0 {
+			return false
+		}
+		values := selectorValues(selector, r.Selector)
+		return len(values) > 0 && r.ValuesContain(values...)
+	})
+}
+
+// selectorValues returns the values that a field must have for a document to
+// match the selector, or nil if the selector does not restrict this field.
+// For referenced_by, the values are in the doctype/id format used by the
+// permissions.
+func selectorValues(selector map[string]interface{}, field string) []string {
+	if and, ok := selector["$and"].([]interface{}); ok {
+		for _, item := range and {
+			if sub, ok := item.(map[string]interface{}); ok {
+				if values := selectorValues(sub, field); len(values) > 0 {
+					return values
+				}
+			}
+		}
+	}
+
+	switch v := selector[field].(type) {
+	case string:
+		return []string{v}
+	case map[string]interface{}:
+		if eq, ok := v["$eq"].(string); ok {
+			return []string{eq}
+		}
+		if in, ok := v["$in"].([]interface{}); ok && len(in) > 0 {
+			values := make([]string, 0, len(in))
+			for _, item := range in {
+				str, ok := item.(string)
+				if !ok {
+					return nil
+				}
+				values = append(values, str)
+			}
+			return values
+		}
+		if elem, ok := v["$elemMatch"].(map[string]interface{}); ok && field == couchdb.SelectorReferencedBy {
+			typ, _ := elem["type"].(string)
+			id, _ := elem["id"].(string)
+			if typ != "" && id != "" {
+				return []string{typ + permission.RefSep + id}
+			}
+		}
+	}
+	return nil
+}
+
 func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *websocket.Conn,
 	ds *realtime.Subscriber, errc chan *wsError, withAuthentication bool) {
 	defer close(errc)
@@ -211,23 +303,45 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 		}
 		// XXX: no permissions are required for io.cozy.sharings.initial_sync
 		// and io.cozy.auth.confirmations
+		var selector *realtime.Selector
+		if cmd.Payload.Selector != nil {
+			if cmd.Payload.ID != "" {
+				sendErr(ctx, errc, invalidSelector(cmd, errors.New("it can't be used with an id")))
+				continue
+			}
+			selector, err = realtime.NewSelector(cmd.Payload.Selector)
+			if err != nil {
+				sendErr(ctx, errc, invalidSelector(cmd, err))
+				continue
+			}
+		}
 		if withAuthentication &&
 			cmd.Payload.Type != consts.SharingsInitialSync &&
 			cmd.Payload.Type != consts.AuthConfirmations {
-			if !authorized(i, pdoc.Permissions, permType, permID) {
+			allowed := false
+			if selector != nil {
+				allowed = authorizedSelector(i, pdoc.Permissions, permType, cmd.Payload.Selector)
+			} else {
+				allowed = authorized(i, pdoc.Permissions, permType, permID)
+			}
+			if !allowed {
 				sendErr(ctx, errc, forbidden(cmd))
 				continue
 			}
 		}
 
 		if method == "SUBSCRIBE" {
-			if cmd.Payload.ID == "" {
+			if selector != nil {
+				ds.Filter(cmd.Payload.Type, selector)
+			} else if cmd.Payload.ID == "" {
 				ds.Subscribe(cmd.Payload.Type)
 			} else {
 				ds.Watch(cmd.Payload.Type, cmd.Payload.ID)
 			}
 		} else if method == "UNSUBSCRIBE" {
-			if cmd.Payload.ID == "" {
+			if selector != nil {
+				ds.Unfilter(cmd.Payload.Type, selector)
+			} else if cmd.Payload.ID == "" {
 				ds.Unsubscribe(cmd.Payload.Type)
 			} else {
 				ds.Unwatch(cmd.Payload.Type, cmd.Payload.ID)
@@ -169,6 +169,45 @@ func TestRealtime(t *testing.T) {
 		payload.ValueEqual("id", "bar-two")
 	})
 
+	t.Run("WSSelector", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		ws := e.GET("/realtime/").
+			WithWebsocketUpgrade().
+			Expect().Status(http.StatusSwitchingProtocols).
+			Websocket()
+		defer ws.Disconnect()
+
+		ws.WriteText(fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, token))
+
+		obj := ws.WriteText(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "selector": { "size": { "$foo": 1 } } }}`).
+			Expect().TextMessage().
+			JSON().Object()
+		obj.ValueEqual("event", "error")
+		obj.Value("payload").Object().ValueEqual("status", "400 Bad Request")
+
+		ws.WriteText(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "selector": { "color": "blue" } }}`)
+		time.Sleep(30 * time.Millisecond)
+
+		h := realtime.GetHub()
+		h.Publish(inst, realtime.EventCreate, &realtime.JSONDoc{
+			Type: "io.cozy.foos",
+			M:    map[string]interface{}{"_id": "foo-red", "color": "red"},
+		}, nil)
+		// No event
+
+		h.Publish(inst, realtime.EventCreate, &realtime.JSONDoc{
+			Type: "io.cozy.foos",
+			M:    map[string]interface{}{"_id": "foo-blue", "color": "blue"},
+		}, nil)
+
+		obj = ws.Expect().TextMessage().JSON().Object()
+		obj.ValueEqual("event", "CREATED")
+		payload := obj.Value("payload").Object()
+		payload.ValueEqual("type", "io.cozy.foos")
+		payload.ValueEqual("id", "foo-blue")
+	})
+
 	t.Run("WSNotify", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
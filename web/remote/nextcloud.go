@@ -401,6 +401,12 @@ func nextcloudRestore(c echo.Context) error {
 
 func nextcloudRoutes(router *echo.Group) {
 	group := router.Group("/nextcloud/:account")
+	group.GET("/syncs", nextcloudListSyncs)
+	group.POST("/syncs", nextcloudCreateSync)
+	group.GET("/syncs/:id", nextcloudGetSync)
+	group.GET("/syncs/:id/reports", nextcloudSyncReports)
+	group.POST("/syncs/:id/run", nextcloudRunSync)
+	group.DELETE("/syncs/:id", nextcloudDeleteSync)
 	group.GET("/trash/*", nextcloudGetTrash)
 	group.DELETE("/trash/*", nextcloudDeleteTrash)
 	group.DELETE("/trash", nextcloudEmptyTrash)
@@ -419,8 +425,10 @@ func wrapNextcloudErrors(err error) error {
 	switch err {
 	case nextcloud.ErrAccountNotFound:
 		return jsonapi.NotFound(err)
-	case nextcloud.ErrInvalidAccount:
+	case nextcloud.ErrInvalidAccount, nextcloud.ErrInvalidSync:
 		return jsonapi.BadRequest(err)
+	case nextcloud.ErrSyncConflict:
+		return jsonapi.Conflict(err)
 	case webdav.ErrInvalidAuth:
 		return jsonapi.Unauthorized(err)
 	case webdav.ErrAlreadyExist, vfs.ErrConflict, os.ErrExist:
@@ -0,0 +1,130 @@
+package remote
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/nextcloud"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type nextcloudSyncRequest struct {
+	RemotePath string `json:"remote_path"`
+	DirID      string `json:"dir_id"`
+	Interval   string `json:"interval,omitempty"`
+}
+
+func nextcloudListSyncs(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Files); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	syncs, err := nextcloud.ListSyncs(inst, c.Param("account"))
+	if err != nil {
+		return wrapNextcloudErrors(err)
+	}
+	objs := make([]jsonapi.Object, len(syncs))
+	for i, s := range syncs {
+		objs[i] = s
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func nextcloudCreateSync(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Files); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	var body nextcloudSyncRequest
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadRequest(errors.New("invalid JSON body"))
+	}
+	if body.DirID == "" || body.RemotePath == "" {
+		return jsonapi.BadRequest(errors.New("dir_id and remote_path are required"))
+	}
+	s, err := nextcloud.CreateSync(inst, c.Param("account"), body.RemotePath, body.DirID, body.Interval)
+	if err != nil {
+		return wrapNextcloudErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, s, nil)
+}
+
+func nextcloudGetSync(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Files); err != nil {
+		return err
+	}
+	s, err := fetchNextcloudSync(c)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, s, nil)
+}
+
+func nextcloudSyncReports(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Files); err != nil {
+		return err
+	}
+	s, err := fetchNextcloudSync(c)
+	if err != nil {
+		return err
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	reports, err := nextcloud.ListSyncReports(middlewares.GetInstance(c), s.ID(), limit)
+	if err != nil {
+		return wrapNextcloudErrors(err)
+	}
+	objs := make([]jsonapi.Object, len(reports))
+	for i, r := range reports {
+		objs[i] = r
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func nextcloudRunSync(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Files); err != nil {
+		return err
+	}
+	s, err := fetchNextcloudSync(c)
+	if err != nil {
+		return err
+	}
+	if _, err := s.PushJob(middlewares.GetInstance(c)); err != nil {
+		return wrapNextcloudErrors(err)
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func nextcloudDeleteSync(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Files); err != nil {
+		return err
+	}
+	s, err := fetchNextcloudSync(c)
+	if err != nil {
+		return err
+	}
+	if err := s.Delete(middlewares.GetInstance(c)); err != nil {
+		return wrapNextcloudErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func fetchNextcloudSync(c echo.Context) (*nextcloud.Sync, error) {
+	s, err := nextcloud.GetSync(middlewares.GetInstance(c), c.Param("id"))
+	if couchdb.IsNotFoundError(err) {
+		return nil, jsonapi.NotFound(errors.New("sync not found"))
+	}
+	if err != nil {
+		return nil, wrapNextcloudErrors(err)
+	}
+	if s.AccountID != c.Param("account") {
+		return nil, jsonapi.NotFound(errors.New("sync not found"))
+	}
+	return s, nil
+}
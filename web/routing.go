@@ -20,15 +20,18 @@ import (
 	"github.com/cozy/cozy-stack/web/apps"
 	"github.com/cozy/cozy-stack/web/auth"
 	"github.com/cozy/cozy-stack/web/bitwarden"
+	"github.com/cozy/cozy-stack/web/calendar"
 	"github.com/cozy/cozy-stack/web/compat"
 	"github.com/cozy/cozy-stack/web/conncheck"
 	"github.com/cozy/cozy-stack/web/contacts"
+	"github.com/cozy/cozy-stack/web/csp"
 	"github.com/cozy/cozy-stack/web/data"
 	"github.com/cozy/cozy-stack/web/errors"
 	"github.com/cozy/cozy-stack/web/files"
 	"github.com/cozy/cozy-stack/web/instances"
 	"github.com/cozy/cozy-stack/web/intents"
 	"github.com/cozy/cozy-stack/web/jobs"
+	"github.com/cozy/cozy-stack/web/mails"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/cozy/cozy-stack/web/move"
 	"github.com/cozy/cozy-stack/web/notes"
@@ -37,6 +40,7 @@ import (
 	"github.com/cozy/cozy-stack/web/office"
 	"github.com/cozy/cozy-stack/web/oidc"
 	"github.com/cozy/cozy-stack/web/permissions"
+	"github.com/cozy/cozy-stack/web/photos"
 	"github.com/cozy/cozy-stack/web/public"
 	"github.com/cozy/cozy-stack/web/realtime"
 	"github.com/cozy/cozy-stack/web/registry"
@@ -47,8 +51,10 @@ import (
 	"github.com/cozy/cozy-stack/web/statik"
 	"github.com/cozy/cozy-stack/web/status"
 	"github.com/cozy/cozy-stack/web/swift"
+	"github.com/cozy/cozy-stack/web/takeout"
 	"github.com/cozy/cozy-stack/web/tools"
 	"github.com/cozy/cozy-stack/web/version"
+	"github.com/cozy/cozy-stack/web/webhooks"
 	"github.com/cozy/cozy-stack/web/wellknown"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -242,6 +248,12 @@ func SetupRoutes(router *echo.Echo, services *stack.Services) error {
 		bitwarden.Routes(router.Group("/bitwarden", mws...))
 		shortcuts.Routes(router.Group("/shortcuts", mws...))
 		ai.Routes(router.Group("/ai", mws...))
+		webhooks.Routes(router.Group("/webhooks", mws...))
+		calendar.Routes(router.Group("/calendar", mws...))
+		calendar.DAVRoutes(router.Group("/dav", mws...))
+		takeout.Routes(router.Group("/takeout", mws...))
+		photos.Routes(router.Group("/photos", mws...))
+		csp.Routes(router.Group("/csp", mws...))
 
 		// The settings routes needs not to be blocked
 		apps.WebappsRoutes(router.Group("/apps", mwsNotBlocked...))
@@ -257,6 +269,12 @@ func SetupRoutes(router *echo.Echo, services *stack.Services) error {
 		// redirection.
 		accounts.Routes(router.Group("/accounts"))
 		oidc.Routes(router.Group("/oidc"))
+
+		// The bounces are reported by the mail provider, without a session.
+		mails.Routes(router.Group("/mails", middlewares.NeedInstance))
+
+		// The browsers send the CSP reports without credentials.
+		router.POST("/csp/report", csp.Report, middlewares.NeedInstance)
 	}
 
 	// other non-authentified routes
@@ -303,10 +321,12 @@ func SetupAdminRoutes(router *echo.Echo) error {
 
 	instances.Routes(router.Group("/instances", mws...))
 	apps.AdminRoutes(router.Group("/konnectors", mws...))
+	apps.RolloutsAdminRoutes(router.Group("/apps", mws...))
 	version.Routes(router.Group("/version", mws...))
 	metrics.Routes(router.Group("/metrics", mws...))
 	oauth.Routes(router.Group("/oauth", mws...))
 	oidc.AdminRoutes(router.Group("/oidc", mws...))
+	mails.AdminRoutes(router.Group("/mails", mws...))
 	realtime.Routes(router.Group("/realtime", mws...))
 	swift.Routes(router.Group("/swift", mws...))
 	tools.Routes(router.Group("/tools", mws...))
@@ -0,0 +1,96 @@
+package settings
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/inbound"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiInboundMail struct {
+	DocID      string `json:"_id,omitempty"`
+	DocRev     string `json:"_rev,omitempty"`
+	Address    string `json:"address"`
+	Folder     string `json:"folder"`
+	CreateNote bool   `json:"create_note"`
+}
+
+func (m *apiInboundMail) ID() string                             { return m.DocID }
+func (m *apiInboundMail) Rev() string                            { return m.DocRev }
+func (m *apiInboundMail) DocType() string                        { return consts.Settings }
+func (m *apiInboundMail) Clone() couchdb.Doc                     { cloned := *m; return &cloned }
+func (m *apiInboundMail) SetID(id string)                        { m.DocID = id }
+func (m *apiInboundMail) SetRev(rev string)                      { m.DocRev = rev }
+func (m *apiInboundMail) Relationships() jsonapi.RelationshipMap { return nil }
+func (m *apiInboundMail) Included() []jsonapi.Object             { return nil }
+func (m *apiInboundMail) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/settings/inbound-mail"}
+}
+func (m *apiInboundMail) Fetch(field string) []string { return nil }
+
+func newInboundMail(inst *instance.Instance, settings *inbound.Settings) *apiInboundMail {
+	return &apiInboundMail{
+		DocID:      settings.ID(),
+		DocRev:     settings.Rev(),
+		Address:    settings.Address(inst),
+		Folder:     settings.Folder,
+		CreateNote: settings.CreateNote,
+	}
+}
+
+func wrapInboundSettingsError(err error) error {
+	switch err {
+	case inbound.ErrNotConfigured, inbound.ErrNotEnabled:
+		return jsonapi.NotFound(err)
+	}
+	return jsonapi.InternalServerError(err)
+}
+
+func (h *HTTPHandler) getInboundMail(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	settings, err := inbound.GetSettings(inst)
+	if err != nil {
+		return wrapInboundSettingsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, newInboundMail(inst, settings), nil)
+}
+
+func (h *HTTPHandler) updateInboundMail(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	var args struct {
+		Folder     string `json:"folder"`
+		CreateNote bool   `json:"create_note"`
+		Regenerate bool   `json:"regenerate"`
+	}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadJSON()
+	}
+	inst := middlewares.GetInstance(c)
+	settings, err := inbound.Enable(inst, args.Folder, args.CreateNote, args.Regenerate)
+	if err != nil {
+		return wrapInboundSettingsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, newInboundMail(inst, settings), nil)
+}
+
+func (h *HTTPHandler) deleteInboundMail(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := inbound.Disable(inst); err != nil {
+		return wrapInboundSettingsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -345,6 +345,9 @@ func (h *HTTPHandler) Register(router *echo.Group) {
 
 	router.GET("/capabilities", h.getCapabilities)
 	router.GET("/external-ties", h.getExternalTies)
+	router.GET("/inbound-mail", h.getInboundMail)
+	router.PUT("/inbound-mail", h.updateInboundMail)
+	router.DELETE("/inbound-mail", h.deleteInboundMail)
 	router.GET("/premium", h.redirectToPremium)
 	router.GET("/instance", h.getInstance)
 	router.PUT("/instance", h.updateInstance)
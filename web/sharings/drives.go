@@ -166,7 +166,7 @@ func wrapDriveRootErrors(err error) error {
 		return jsonapi.NotFound(err)
 	case sharing.ErrFolderAlreadyShared, sharing.ErrFileAlreadyShared:
 		return jsonapi.Conflict(err)
-	case sharing.ErrSystemFolder, sharing.ErrFileInTrash:
+	case sharing.ErrSystemFolder, sharing.ErrFileInTrash, sharing.ErrExternalMount:
 		return jsonapi.BadRequest(err)
 	default:
 		return wrapErrors(err)
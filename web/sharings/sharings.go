@@ -1297,6 +1297,8 @@ func wrapErrors(err error) error {
 		return jsonapi.Conflict(err)
 	case sharing.ErrNotADirectory, sharing.ErrSystemFolder:
 		return jsonapi.InvalidParameter("folder_id", err)
+	case sharing.ErrExternalMount:
+		return jsonapi.BadRequest(err)
 	}
 	logger.WithNamespace("sharing").Warnf("Not wrapped error: %s", err)
 	return err
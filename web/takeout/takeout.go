@@ -0,0 +1,113 @@
+// Package takeout is for the routes to import the archives exported from
+// other clouds, like Google Takeout.
+package takeout
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/takeout"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type migrationRequest struct {
+	FileIDs   []string `json:"file_ids"`
+	TargetDir string   `json:"target_dir,omitempty"`
+}
+
+func startMigration(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.TakeoutMigrations); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	var body migrationRequest
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadRequest(errors.New("invalid JSON body"))
+	}
+	// The archives are read, and their content is written in the target
+	// directory, so the permissions on the files are checked for both.
+	for _, id := range body.FileIDs {
+		file, err := inst.VFS().FileByID(id)
+		if err != nil {
+			return wrapError(err)
+		}
+		if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+			return err
+		}
+	}
+	// The vCard and iCalendar files of the archives are imported as contacts
+	// and calendars, so the permissions on these doctypes are checked too.
+	for _, doctype := range []string{
+		consts.Files,
+		consts.Contacts,
+		consts.Groups,
+		consts.Calendars,
+		consts.CalendarEvents,
+	} {
+		if err := middlewares.AllowWholeType(c, permission.POST, doctype); err != nil {
+			return err
+		}
+	}
+
+	doc, err := takeout.Start(inst, takeout.StartOptions{
+		FileIDs:   body.FileIDs,
+		TargetDir: body.TargetDir,
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, doc, nil)
+}
+
+func getMigration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	doc, err := takeout.Get(inst, c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := middlewares.Allow(c, permission.GET, doc); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, doc, nil)
+}
+
+// cancelMigration sets the cancel flag on the import. The worker sees it the
+// next time it saves its progress, and it is only then that the status of
+// the import becomes canceled.
+func cancelMigration(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.TakeoutMigrations); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	doc, err := takeout.Cancel(inst, c.Param("id"))
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusAccepted, doc, nil)
+}
+
+func wrapError(err error) error {
+	switch {
+	case errors.Is(err, takeout.ErrMigrationNotFound), os.IsNotExist(err):
+		return jsonapi.NotFound(err)
+	case errors.Is(err, takeout.ErrMigrationConflict), errors.Is(err, takeout.ErrMigrationAlreadyTerminal):
+		return jsonapi.Conflict(err)
+	case errors.Is(err, takeout.ErrNoSource), errors.Is(err, takeout.ErrInvalidSource),
+		errors.Is(err, takeout.ErrInvalidTargetDir):
+		return jsonapi.BadRequest(err)
+	}
+	return jsonapi.InternalServerError(err)
+}
+
+// Routes sets the routing for the imports of the archives.
+func Routes(router *echo.Group) {
+	router.POST("/migrations", startMigration)
+	router.GET("/migrations/:id", getMigration)
+	router.POST("/migrations/:id/cancel", cancelMigration)
+}
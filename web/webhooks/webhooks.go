@@ -0,0 +1,179 @@
+// Package webhooks is for the routes to manage the outgoing webhooks.
+package webhooks
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/webhook"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiWebhook struct {
+	*webhook.Webhook
+}
+
+func (w *apiWebhook) Relationships() jsonapi.RelationshipMap { return nil }
+func (w *apiWebhook) Included() []jsonapi.Object             { return nil }
+func (w *apiWebhook) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/webhooks/" + w.ID()}
+}
+
+// newAPIWebhook returns the webhook without its secret, which is only sent in
+// the response of the creation.
+func newAPIWebhook(hook *webhook.Webhook) *apiWebhook {
+	cloned := hook.Clone().(*webhook.Webhook)
+	cloned.Secret = ""
+	return &apiWebhook{cloned}
+}
+
+type apiDelivery struct {
+	*webhook.Delivery
+}
+
+func (d *apiDelivery) Relationships() jsonapi.RelationshipMap { return nil }
+func (d *apiDelivery) Included() []jsonapi.Object             { return nil }
+func (d *apiDelivery) Links() *jsonapi.LinksList              { return nil }
+
+func createWebhook(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	hook := &webhook.Webhook{}
+	if _, err := jsonapi.Bind(c.Request().Body, hook); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Webhooks); err != nil {
+		return err
+	}
+	// The documents are sent to the endpoint, so the webhook can be created
+	// only by someone who can read all the documents of the doctype.
+	if err := permission.CheckReadable(hook.Doctype); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.GET, hook.Doctype); err != nil {
+		return err
+	}
+	perm, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	hook.SourceID = perm.SourceID
+	if err := webhook.Create(inst, hook); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiWebhook{hook}, nil)
+}
+
+func listWebhooks(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Webhooks); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	hooks, err := webhook.List(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	objs := make([]jsonapi.Object, len(hooks))
+	for i, hook := range hooks {
+		objs[i] = newAPIWebhook(hook)
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func getWebhook(c echo.Context) error {
+	hook, err := findWebhook(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, newAPIWebhook(hook), nil)
+}
+
+func deleteWebhook(c echo.Context) error {
+	hook, err := findWebhook(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := hook.Delete(inst); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func enableWebhook(c echo.Context) error {
+	hook, err := findWebhook(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+	// The permission on the target doctype may have been revoked since the
+	// creation of the webhook.
+	if err := middlewares.AllowWholeType(c, permission.GET, hook.Doctype); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := hook.Enable(inst); err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, newAPIWebhook(hook), nil)
+}
+
+func listDeliveries(c echo.Context) error {
+	hook, err := findWebhook(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	limit := 0
+	if l := c.QueryParam("page[limit]"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return jsonapi.InvalidParameter("page[limit]", err)
+		}
+	}
+	inst := middlewares.GetInstance(c)
+	deliveries, err := webhook.ListDeliveries(inst, hook.ID(), limit)
+	if err != nil {
+		return wrapError(err)
+	}
+	objs := make([]jsonapi.Object, len(deliveries))
+	for i, d := range deliveries {
+		objs[i] = &apiDelivery{d}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func findWebhook(c echo.Context, verb permission.Verb) (*webhook.Webhook, error) {
+	inst := middlewares.GetInstance(c)
+	hook, err := webhook.Get(inst, c.Param("id"))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if err := middlewares.Allow(c, verb, hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func wrapError(err error) error {
+	if couchdb.IsNotFoundError(err) {
+		return jsonapi.NotFound(err)
+	}
+	switch err {
+	case webhook.ErrInvalidWebhook:
+		return jsonapi.BadRequest(err)
+	}
+	return err
+}
+
+// Routes sets the routing for the outgoing webhooks.
+func Routes(router *echo.Group) {
+	router.POST("", createWebhook)
+	router.GET("", listWebhooks)
+	router.GET("/:id", getWebhook)
+	router.DELETE("/:id", deleteWebhook)
+	router.POST("/:id/enable", enableWebhook)
+	router.GET("/:id/deliveries", listDeliveries)
+}
@@ -15,8 +15,17 @@ func ChangePassword(c echo.Context) error {
 	return c.Redirect(http.StatusFound, inst.ChangePasswordURL())
 }
 
+// CalDAV is an handler that redirects the calendar clients to the root of
+// the CalDAV server.
+// See https://www.rfc-editor.org/rfc/rfc6764#section-5
+func CalDAV(c echo.Context) error {
+	return c.Redirect(http.StatusMovedPermanently, "/dav/")
+}
+
 // Routes sets the routing for the status service
 func Routes(router *echo.Group) {
 	router.GET("/change-password", ChangePassword)
 	router.HEAD("/change-password", ChangePassword)
+	router.Any("/caldav", CalDAV)
+	router.Add("PROPFIND", "/caldav", CalDAV)
 }
@@ -0,0 +1,85 @@
+// Package backup is for the workers that take the snapshots of the instances
+// and restore them.
+package backup
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/backup"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/move"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "backup",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Timeout:      12 * time.Hour,
+		Reserved:     true,
+		WorkerFunc:   BackupWorker,
+	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "restore",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Timeout:      24 * time.Hour,
+		Reserved:     true,
+		WorkerFunc:   RestoreWorker,
+	})
+}
+
+// BackupWorker is the worker that takes a snapshot of the instance.
+func BackupWorker(c *job.TaskContext) error {
+	s, err := backup.Create(c.Instance, move.SystemArchiver())
+	if err != nil {
+		c.Instance.Logger().WithNamespace("backup").
+			Warnf("Backup failed: %s", err)
+		return err
+	}
+	c.Instance.Logger().WithNamespace("backup").
+		Infof("Snapshot %s: %d documents, %d deletions, %d contents, %d bytes",
+			s.ID(), s.Docs, s.Deletions, s.Blobs, s.Size)
+	return nil
+}
+
+// RestoreWorker is the worker that restores a snapshot of the instance. The
+// instance is blocked during the restore.
+func RestoreWorker(c *job.TaskContext) error {
+	var opts backup.RestoreOptions
+	if err := c.UnmarshalMessage(&opts); err != nil {
+		return err
+	}
+	key, err := opts.Key()
+	if err != nil {
+		return err
+	}
+
+	if err := lifecycle.Block(c.Instance, instance.BlockedImporting.Code); err != nil {
+		return err
+	}
+
+	err = backup.Restore(c.Instance, opts.SnapshotID, key, move.SystemArchiver())
+
+	if erru := lifecycle.Unblock(c.Instance); erru != nil {
+		// Try again
+		time.Sleep(10 * time.Second)
+		inst, errg := instance.Get(c.Instance.Domain)
+		if errg == nil {
+			erru = lifecycle.Unblock(inst)
+		}
+		if err == nil {
+			err = erru
+		}
+	}
+
+	if err != nil {
+		c.Instance.Logger().WithNamespace("backup").
+			Warnf("Restore of %s failed: %s", opts.SnapshotID, err)
+	}
+	return err
+}
@@ -3,12 +3,20 @@
 
 package exec
 
-import "os/exec"
+import (
+	"os/exec"
+	"strconv"
+)
 
 func CreateCmd(cmdStr, workDir string) *exec.Cmd {
 	return exec.Command(cmdStr, workDir)
 }
 
+// KillCmd kills the command and its child processes.
 func KillCmd(c *exec.Cmd) error {
-	return c.Process.Kill()
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(c.Process.Pid))
+	if err := kill.Run(); err != nil {
+		return c.Process.Kill()
+	}
+	return nil
 }
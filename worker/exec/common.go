@@ -35,6 +35,9 @@ func init() {
 		Concurrency:  runtime.NumCPU() * 2,
 		MaxExecCount: 2,
 		Timeout:      defaultTimeout,
+		// The errors of the konnectors and services are reported in the state
+		// of their triggers, and are often expected (wrong credentials, etc.)
+		NoDeadLetter: true,
 	})
 
 	job.AddWorker(&job.WorkerConfig{
@@ -47,6 +50,9 @@ func init() {
 		Concurrency:  runtime.NumCPU() * 2,
 		MaxExecCount: 2,
 		Timeout:      defaultTimeout,
+		// The errors of the konnectors and services are reported in the state
+		// of their triggers, and are often expected (wrong credentials, etc.)
+		NoDeadLetter: true,
 	})
 }
 
@@ -60,6 +66,13 @@ type execWorker interface {
 	Commit(ctx *job.TaskContext, errjob error) error
 }
 
+// inProcessWorker is implemented by the exec workers that can execute some
+// jobs inside the stack process, without an external command.
+type inProcessWorker interface {
+	InProcess() bool
+	RunInProcess(ctx *job.TaskContext, i *instance.Instance) error
+}
+
 func worker(ctx *job.TaskContext) (err error) {
 	worker := ctx.Cookie().(execWorker)
 
@@ -74,6 +87,14 @@ func worker(ctx *job.TaskContext) (err error) {
 		return err
 	}
 
+	if runner, ok := worker.(inProcessWorker); ok && runner.InProcess() {
+		err = runner.RunInProcess(ctx, ctx.Instance)
+		if ctx.IsCancelled() {
+			return job.ErrCancelled
+		}
+		return worker.Error(ctx.Instance, err)
+	}
+
 	cmdStr, env, err := worker.PrepareCmdEnv(ctx, ctx.Instance)
 	if err != nil {
 		worker.Logger(ctx).Errorf("PrepareCmdEnv: %s", err)
@@ -141,6 +162,10 @@ func worker(ctx *job.TaskContext) (err error) {
 		err = ctx.Err()
 		_ = KillCmd(cmd)
 		<-waitDone
+		if ctx.IsCancelled() {
+			log.Infof("The job has been cancelled, the process has been killed")
+			return job.ErrCancelled
+		}
 	}
 
 	return worker.Error(ctx.Instance, err)
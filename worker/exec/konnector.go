@@ -272,7 +272,7 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.TaskContext, i *instance.Insta
 	w.workDir = workDir
 	workFS := afero.NewBasePathFs(osFS, workDir)
 
-	fileServer := app.KonnectorsFileServer(i)
+	fileServer := appfs.WithIntegrity(app.KonnectorsFileServer(i), man.Integrity())
 	err = copyFiles(workFS, fileServer, slug, man.Version(), man.Checksum())
 	if err != nil {
 		return "", cleanDir, err
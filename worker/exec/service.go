@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,10 +12,12 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/appfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/spf13/afero"
 )
@@ -31,10 +34,12 @@ type ServiceOptions struct {
 
 type serviceWorker struct {
 	man     *app.WebappManifest
+	service *app.Service
 	slug    string
 	name    string
 	fields  json.RawMessage
 	workDir string
+	module  []byte // the code of a wasm service
 }
 
 func (w *serviceWorker) PrepareWorkDir(ctx *job.TaskContext, i *instance.Instance) (workDir string, cleanDir func(), err error) {
@@ -113,23 +118,13 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.TaskContext, i *instance.Instanc
 	}
 
 	w.man = man
-
-	osFS := afero.NewOsFs()
-	workDir, err = afero.TempDir(osFS, "", "service-"+slug)
-	if err != nil {
-		return
-	}
-	cleanDir = func() {
-		_ = os.RemoveAll(workDir)
-	}
-	w.workDir = workDir
-	workFS := afero.NewBasePathFs(osFS, workDir)
+	w.service = service
 
 	var fs appfs.FileServer
 	if man.FromAppsDir {
 		fs = app.FSForAppDir(man.Slug())
 	} else {
-		fs = app.AppsFileServer(i)
+		fs = appfs.WithIntegrity(app.AppsFileServer(i), man.Integrity())
 	}
 	src, err := fs.Open(man.Slug(), man.Version(), man.Checksum(), path.Join("/", service.File))
 	if err != nil {
@@ -137,6 +132,24 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.TaskContext, i *instance.Instanc
 	}
 	defer src.Close()
 
+	// The wasm services are executed inside the stack, they don't need a
+	// working directory.
+	if w.InProcess() {
+		w.module, err = io.ReadAll(src)
+		return "", cleanDir, err
+	}
+
+	osFS := afero.NewOsFs()
+	workDir, err = afero.TempDir(osFS, "", "service-"+slug)
+	if err != nil {
+		return
+	}
+	cleanDir = func() {
+		_ = os.RemoveAll(workDir)
+	}
+	w.workDir = workDir
+	workFS := afero.NewBasePathFs(osFS, workDir)
+
 	dst, err := workFS.OpenFile("index.js", os.O_CREATE|os.O_WRONLY, 0640)
 	if err != nil {
 		return
@@ -155,7 +168,9 @@ func (w *serviceWorker) Slug() string {
 	return w.slug
 }
 
-func (w *serviceWorker) PrepareCmdEnv(ctx *job.TaskContext, i *instance.Instance) (cmd string, env []string, err error) {
+// serviceCouchDoc returns the JSON of the CouchDB document that has
+// triggered the service, if any.
+func serviceCouchDoc(ctx *job.TaskContext) ([]byte, error) {
 	type serviceEvent struct {
 		Doc interface{} `json:"doc"`
 	}
@@ -165,9 +180,17 @@ func (w *serviceWorker) PrepareCmdEnv(ctx *job.TaskContext, i *instance.Instance
 	if err := ctx.UnmarshalEvent(&doc); err == nil {
 		marshaled, err = json.Marshal(doc.Doc)
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 	}
+	return marshaled, nil
+}
+
+func (w *serviceWorker) PrepareCmdEnv(ctx *job.TaskContext, i *instance.Instance) (cmd string, env []string, err error) {
+	marshaled, err := serviceCouchDoc(ctx)
+	if err != nil {
+		return "", nil, err
+	}
 
 	payload, err := preparePayload(ctx, w.workDir)
 	if err != nil {
@@ -193,6 +216,66 @@ func (w *serviceWorker) PrepareCmdEnv(ctx *job.TaskContext, i *instance.Instance
 	return
 }
 
+// InProcess returns true if the service is executed inside the stack
+// process, ie for a wasm service.
+func (w *serviceWorker) InProcess() bool {
+	return w.service != nil && w.service.Type == app.ServiceTypeWasm
+}
+
+// RunInProcess executes a wasm service, with the permissions of its
+// application for the host API.
+func (w *serviceWorker) RunInProcess(ctx *job.TaskContext, i *instance.Instance) error {
+	perms, err := permission.GetForWebapp(i, w.man.Slug())
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := serviceCouchDoc(ctx)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if p, err := ctx.UnmarshalPayload(); err == nil {
+		if payload, err = json.Marshal(p); err != nil {
+			return err
+		}
+	}
+
+	env := map[string]string{
+		"COZY_LANGUAGE":   app.ServiceTypeWasm,
+		"COZY_LOCALE":     i.Locale,
+		"COZY_TIME_LIMIT": ctxToTimeLimit(ctx),
+		"COZY_JOB_ID":     ctx.ID(),
+		"COZY_COUCH_DOC":  string(marshaled),
+		"COZY_PAYLOAD":    string(payload),
+		"COZY_FIELDS":     string(w.fields),
+	}
+	if triggerID, ok := ctx.TriggerID(); ok {
+		env["COZY_TRIGGER_ID"] = triggerID
+	}
+
+	log := w.Logger(ctx)
+	var stderrBuf bytes.Buffer
+	defer func() {
+		if stderrBuf.Len() > 0 {
+			log.Errorf("Stderr: %s", stderrBuf.String())
+		}
+	}()
+
+	return runWasm(ctx, &wasmOptions{
+		Module: w.module,
+		Limits: w.service.Limits,
+		Env:    env,
+		Host:   &wasmHost{inst: i, perms: perms.Permissions, log: log},
+		Output: func(line []byte) {
+			if err := w.ScanOutput(ctx, i, line); err != nil {
+				log.Debug(err.Error())
+			}
+		},
+		Stderr: &stderrBuf,
+	})
+}
+
 func (w *serviceWorker) Logger(ctx *job.TaskContext) logger.Logger {
 	log := ctx.Logger().WithField("slug", w.Slug())
 	if w.name != "" {
@@ -239,6 +322,9 @@ func (w *serviceWorker) Commit(ctx *job.TaskContext, errjob error) error {
 		log.Info("Service success")
 	} else {
 		log.Infof("Service failure: %s", errjob)
+		if w.man != nil && ctx.Instance != nil {
+			app.ReportFailure(ctx.Instance.Domain, w.man.Slug(), w.man.Version(), limits.RolloutJobFailureType)
+		}
 	}
 	return nil
 }
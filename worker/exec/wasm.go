@@ -0,0 +1,185 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// wasmDefaultMemory is the memory (in MiB) that a wasm service can use
+	// when its manifest doesn't declare a limit.
+	wasmDefaultMemory = 64
+	// wasmMaxMemory is the maximal memory (in MiB) that a wasm service can
+	// use.
+	wasmMaxMemory = 256
+	// wasmPagesPerMiB is the number of wasm memory pages (64KiB) in a MiB.
+	wasmPagesPerMiB = 16
+)
+
+// ErrWasmExit is returned when a wasm service exits with a non-zero code.
+var ErrWasmExit = errors.New("the service has exited with an error")
+
+// wasmCache is shared by the runtimes to avoid compiling the same module
+// again for each job.
+var wasmCache = wazero.NewCompilationCache()
+
+// wasmOptions are the parameters for the execution of a wasm module.
+type wasmOptions struct {
+	Module []byte
+	Limits *app.ServiceLimits
+	Env    map[string]string
+	Host   *wasmHost
+	Output func(line []byte) // called for each line written on stdout
+	Stderr *bytes.Buffer
+}
+
+// wasmLimits returns the memory (in pages) and the time limit for a service.
+// A zero duration means that the service has no specific time limit (the
+// timeout of the job still applies).
+func wasmLimits(limits *app.ServiceLimits) (uint32, time.Duration, error) {
+	memory := wasmDefaultMemory
+	var timeout time.Duration
+	if limits != nil {
+		if limits.Memory > 0 {
+			memory = limits.Memory
+		}
+		if limits.Timeout != "" {
+			d, err := time.ParseDuration(limits.Timeout)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid timeout for the service: %w", err)
+			}
+			timeout = d
+		}
+	}
+	if memory > wasmMaxMemory {
+		memory = wasmMaxMemory
+	}
+	return uint32(memory * wasmPagesPerMiB), timeout, nil
+}
+
+// runWasm executes the _start function of a wasm module, with the WASI
+// functions and the host functions of the stack. The module is closed when
+// the context is done, which allows to stop the infinite loops.
+func runWasm(ctx context.Context, opts *wasmOptions) error {
+	pages, timeout, err := wasmLimits(opts.Limits)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	rtConfig := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(pages).
+		WithCompilationCache(wasmCache)
+	rt := wazero.NewRuntimeWithConfig(ctx, rtConfig)
+	defer rt.Close(context.Background())
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		return err
+	}
+	if opts.Host != nil {
+		if err := opts.Host.instantiate(ctx, rt); err != nil {
+			return err
+		}
+	}
+
+	compiled, err := rt.CompileModule(ctx, opts.Module)
+	if err != nil {
+		return fmt.Errorf("cannot compile the wasm module: %w", err)
+	}
+
+	stdout := &lineWriter{fn: opts.Output}
+	modConfig := wazero.NewModuleConfig().
+		WithName("service").
+		WithStdout(stdout).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	if opts.Stderr != nil {
+		// Limit the size of stderr to 256Ko, like for the node services
+		modConfig = modConfig.WithStderr(utils.LimitWriterDiscard(opts.Stderr, 256*1024))
+	}
+	for k, v := range opts.Env {
+		modConfig = modConfig.WithEnv(k, v)
+	}
+
+	mod, err := rt.InstantiateModule(ctx, compiled, modConfig)
+	stdout.flush()
+	if mod != nil {
+		_ = mod.Close(context.Background())
+	}
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 0:
+			return nil
+		case sys.ExitCodeDeadlineExceeded:
+			return context.DeadlineExceeded
+		case sys.ExitCodeContextCanceled:
+			return context.Canceled
+		}
+		return fmt.Errorf("%w (code %d)", ErrWasmExit, exitErr.ExitCode())
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// lineWriter is an io.Writer that calls a function for each line written.
+type lineWriter struct {
+	fn  func(line []byte)
+	buf []byte
+}
+
+// maxLineSize is the size after which a line is sent, even if it has no
+// newline character.
+const maxLineSize = 64 * 1024
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLineSize {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) emit(line []byte) {
+	if w.fn != nil && len(line) > 0 {
+		w.fn(line)
+	}
+}
@@ -0,0 +1,380 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmMaxFileSize is the maximal size of a file that a wasm service can read
+// or write via the host API.
+const wasmMaxFileSize = 16 * 1024 * 1024
+
+var (
+	errWasmForbidden     = errors.New("forbidden")
+	errWasmUnknownMethod = errors.New("unknown method")
+	errWasmTooLarge      = errors.New("the file is too large")
+	errWasmConflict      = errors.New("conflict")
+)
+
+// wasmHost is the host API for the wasm services. It exposes a subset of the
+// data, files and realtime APIs, with the permissions of the application.
+//
+// The module can call the "call" function of the "cozy" module with a JSON
+// request, like {"method": "data.get", "params": {...}}. The response is a
+// JSON object with a result or an error field. It is written in the memory of
+// the module, in a buffer allocated by the "cozy_alloc" function that the
+// module must export. The returned value is the pointer to this buffer in the
+// high 32 bits, and its length in the low 32 bits.
+type wasmHost struct {
+	inst  *instance.Instance
+	perms permission.Set
+	log   logger.Logger
+}
+
+type wasmRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type wasmResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (h *wasmHost) instantiate(ctx context.Context, rt wazero.Runtime) error {
+	_, err := rt.NewHostModuleBuilder("cozy").
+		NewFunctionBuilder().WithFunc(h.call).Export("call").
+		Instantiate(ctx)
+	return err
+}
+
+func (h *wasmHost) call(ctx context.Context, m api.Module, ptr, size uint32) uint64 {
+	var res wasmResponse
+	var req wasmRequest
+	if buf, ok := m.Memory().Read(ptr, size); !ok {
+		res.Error = "invalid request buffer"
+	} else if err := json.Unmarshal(buf, &req); err != nil {
+		res.Error = "invalid request: " + err.Error()
+	} else if result, err := h.dispatch(req.Method, req.Params); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Result = result
+	}
+
+	out, err := json.Marshal(res)
+	if err != nil {
+		h.log.Warnf("Cannot marshal the response for %s: %s", req.Method, err)
+		return 0
+	}
+	alloc := m.ExportedFunction("cozy_alloc")
+	if alloc == nil {
+		h.log.Warnf("The wasm module does not export cozy_alloc")
+		return 0
+	}
+	results, err := alloc.Call(ctx, uint64(len(out)))
+	if err != nil || len(results) != 1 {
+		h.log.Warnf("Cannot allocate memory in the wasm module: %v", err)
+		return 0
+	}
+	outPtr := uint32(results[0])
+	if !m.Memory().Write(outPtr, out) {
+		h.log.Warnf("Cannot write the response in the wasm module")
+		return 0
+	}
+	return uint64(outPtr)<<32 | uint64(len(out))
+}
+
+func (h *wasmHost) dispatch(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "data.get":
+		return h.getDoc(params)
+	case "data.find":
+		return h.findDocs(params)
+	case "data.create":
+		return h.createDoc(params)
+	case "data.update":
+		return h.updateDoc(params)
+	case "data.delete":
+		return h.deleteDoc(params)
+	case "files.get":
+		return h.getFile(params)
+	case "files.read":
+		return h.readFile(params)
+	case "files.create":
+		return h.createFile(params)
+	case "realtime.notify":
+		return h.notify(params)
+	}
+	return nil, fmt.Errorf("%w: %q", errWasmUnknownMethod, method)
+}
+
+type wasmDocParams struct {
+	Doctype  string                 `json:"doctype"`
+	ID       string                 `json:"id"`
+	Rev      string                 `json:"rev"`
+	Doc      map[string]interface{} `json:"doc"`
+	Selector mango.Map              `json:"selector"`
+	Limit    int                    `json:"limit"`
+}
+
+func (h *wasmHost) docParams(params json.RawMessage, writable bool) (*wasmDocParams, error) {
+	var p wasmDocParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	check := permission.CheckReadable
+	if writable {
+		check = permission.CheckWritable
+	}
+	if err := check(p.Doctype); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (h *wasmHost) getDoc(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, false)
+	if err != nil {
+		return nil, err
+	}
+	var doc couchdb.JSONDoc
+	if err := couchdb.GetDoc(h.inst, p.Doctype, p.ID, &doc); err != nil {
+		return nil, err
+	}
+	doc.Type = p.Doctype
+	if !h.perms.Allow(permission.GET, &doc) {
+		return nil, errWasmForbidden
+	}
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) findDocs(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, false)
+	if err != nil {
+		return nil, err
+	}
+	if !h.perms.AllowWholeType(permission.GET, p.Doctype) {
+		return nil, errWasmForbidden
+	}
+	limit := p.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	selector := p.Selector
+	if selector == nil {
+		selector = mango.Map{}
+	}
+	req := &couchdb.FindRequest{Selector: selector, Limit: limit}
+	var docs []couchdb.JSONDoc
+	if err := couchdb.FindDocs(h.inst, p.Doctype, req, &docs); err != nil {
+		return nil, err
+	}
+	if docs == nil {
+		docs = []couchdb.JSONDoc{}
+	}
+	return docs, nil
+}
+
+func (h *wasmHost) createDoc(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, true)
+	if err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{M: p.Doc, Type: p.Doctype}
+	if doc.M == nil {
+		doc.M = make(map[string]interface{})
+	}
+	if !h.perms.Allow(permission.POST, &doc) {
+		return nil, errWasmForbidden
+	}
+	if err := couchdb.CreateDoc(h.inst, &doc); err != nil {
+		return nil, err
+	}
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) updateDoc(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, true)
+	if err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{M: p.Doc, Type: p.Doctype}
+	if doc.ID() == "" || doc.Rev() == "" {
+		return nil, errors.New("the document must have an _id and a _rev")
+	}
+	var old couchdb.JSONDoc
+	if err := couchdb.GetDoc(h.inst, p.Doctype, doc.ID(), &old); err != nil {
+		return nil, err
+	}
+	old.Type = p.Doctype
+	if !h.perms.Allow(permission.PUT, &old) || !h.perms.Allow(permission.PUT, &doc) {
+		return nil, errWasmForbidden
+	}
+	if err := couchdb.UpdateDocWithOld(h.inst, &doc, &old); err != nil {
+		return nil, err
+	}
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) deleteDoc(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, true)
+	if err != nil {
+		return nil, err
+	}
+	var doc couchdb.JSONDoc
+	if err := couchdb.GetDoc(h.inst, p.Doctype, p.ID, &doc); err != nil {
+		return nil, err
+	}
+	doc.Type = p.Doctype
+	if p.Rev != "" && p.Rev != doc.Rev() {
+		return nil, errWasmConflict
+	}
+	if !h.perms.Allow(permission.DELETE, &doc) {
+		return nil, errWasmForbidden
+	}
+	if err := couchdb.DeleteDoc(h.inst, &doc); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": doc.ID(), "rev": doc.Rev()}, nil
+}
+
+type wasmFileParams struct {
+	ID      string `json:"id"`
+	DirID   string `json:"dir_id"`
+	Name    string `json:"name"`
+	Mime    string `json:"mime"`
+	Content []byte `json:"content"` // encoded in base64 in JSON
+}
+
+func (h *wasmHost) fileParams(params json.RawMessage) (*wasmFileParams, error) {
+	var p wasmFileParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (h *wasmHost) allowVFS(v permission.Verb, fetcher vfs.Fetcher) error {
+	if err := vfs.Allows(h.inst.VFS(), h.perms, v, fetcher); err != nil {
+		return errWasmForbidden
+	}
+	return nil
+}
+
+func (h *wasmHost) getFile(params json.RawMessage) (interface{}, error) {
+	p, err := h.fileParams(params)
+	if err != nil {
+		return nil, err
+	}
+	dir, file, err := h.inst.VFS().DirOrFileByID(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		if err := h.allowVFS(permission.GET, dir); err != nil {
+			return nil, err
+		}
+		return dir, nil
+	}
+	if err := h.allowVFS(permission.GET, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (h *wasmHost) readFile(params json.RawMessage) (interface{}, error) {
+	p, err := h.fileParams(params)
+	if err != nil {
+		return nil, err
+	}
+	fs := h.inst.VFS()
+	file, err := fs.FileByID(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.allowVFS(permission.GET, file); err != nil {
+		return nil, err
+	}
+	if file.ByteSize > wasmMaxFileSize {
+		return nil, errWasmTooLarge
+	}
+	f, err := fs.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, wasmMaxFileSize))
+}
+
+func (h *wasmHost) createFile(params json.RawMessage) (interface{}, error) {
+	p, err := h.fileParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Content) > wasmMaxFileSize {
+		return nil, errWasmTooLarge
+	}
+	fs := h.inst.VFS()
+	dirID := p.DirID
+	if dirID == "" {
+		dirID = consts.RootDirID
+	}
+	mime, class := vfs.ExtractMimeAndClassFromFilename(p.Name)
+	if p.Mime != "" {
+		mime = p.Mime
+	}
+	newdoc, err := vfs.NewFileDoc(p.Name, dirID, int64(len(p.Content)), nil,
+		mime, class, time.Now(), false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.allowVFS(permission.POST, newdoc); err != nil {
+		return nil, err
+	}
+	f, err := fs.CreateFile(newdoc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(p.Content); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return newdoc, nil
+}
+
+func (h *wasmHost) notify(params json.RawMessage) (interface{}, error) {
+	p, err := h.docParams(params, false)
+	if err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{M: p.Doc, Type: p.Doctype}
+	if doc.M == nil {
+		doc.M = make(map[string]interface{})
+	}
+	if p.ID != "" {
+		doc.SetID(p.ID)
+	}
+	if !h.perms.Allow(permission.POST, &doc) {
+		return nil, errWasmForbidden
+	}
+	realtime.GetHub().Publish(h.inst, realtime.EventNotify, &doc, nil)
+	return map[string]interface{}{"ok": true}, nil
+}
@@ -0,0 +1,112 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wasmModule returns a minimal wasm module, with an exported _start function
+// with the given body, and an optional memory with the given minimal number
+// of pages.
+func wasmModule(body []byte, memoryPages byte) []byte {
+	mod := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+		0x03, 0x02, 0x01, 0x00, // function section
+	}
+	if memoryPages > 0 {
+		mod = append(mod, 0x05, 0x03, 0x01, 0x00, memoryPages) // memory section
+	}
+	mod = append(mod, 0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00) // export section
+	code := append([]byte{0x00}, body...)                                               // no locals
+	code = append(code, 0x0b)                                                           // end
+	mod = append(mod, 0x0a, byte(len(code)+2), 0x01, byte(len(code)))
+	return append(mod, code...)
+}
+
+func TestRunWasm(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		err := runWasm(context.Background(), &wasmOptions{
+			Module: wasmModule(nil, 1),
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Trap", func(t *testing.T) {
+		unreachable := []byte{0x00}
+		err := runWasm(context.Background(), &wasmOptions{
+			Module: wasmModule(unreachable, 0),
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("InvalidModule", func(t *testing.T) {
+		err := runWasm(context.Background(), &wasmOptions{
+			Module: []byte("console.log('hello')"),
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("MemoryLimit", func(t *testing.T) {
+		err := runWasm(context.Background(), &wasmOptions{
+			Module: wasmModule(nil, 32), // 2MiB
+			Limits: &app.ServiceLimits{Memory: 1},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("TimeLimit", func(t *testing.T) {
+		infiniteLoop := []byte{0x03, 0x40, 0x0c, 0x00, 0x0b}
+		start := time.Now()
+		err := runWasm(context.Background(), &wasmOptions{
+			Module: wasmModule(infiniteLoop, 0),
+			Limits: &app.ServiceLimits{Timeout: "100ms"},
+		})
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+func TestWasmLimits(t *testing.T) {
+	pages, timeout, err := wasmLimits(nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, wasmDefaultMemory*wasmPagesPerMiB, pages)
+	assert.Zero(t, timeout)
+
+	pages, timeout, err = wasmLimits(&app.ServiceLimits{Memory: 4096, Timeout: "30s"})
+	require.NoError(t, err)
+	assert.EqualValues(t, wasmMaxMemory*wasmPagesPerMiB, pages)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	_, _, err = wasmLimits(&app.ServiceLimits{Timeout: "forever"})
+	assert.Error(t, err)
+}
+
+func TestWasmHostPermissions(t *testing.T) {
+	h := &wasmHost{perms: permission.Set{}}
+
+	_, err := h.dispatch("data.find", []byte(`{"doctype": "io.cozy.contacts"}`))
+	assert.ErrorIs(t, err, errWasmForbidden)
+
+	_, err = h.dispatch("data.find", []byte(`{"doctype": "io.cozy.permissions"}`))
+	assert.Error(t, err)
+
+	_, err = h.dispatch("exec", []byte(`{}`))
+	assert.ErrorIs(t, err, errWasmUnknownMethod)
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line []byte) { lines = append(lines, string(line)) }}
+	_, _ = w.Write([]byte("{\"type\":\"info\"}\n{\"type\""))
+	_, _ = w.Write([]byte(":\"debug\"}\n\npartial"))
+	w.flush()
+	assert.Equal(t, []string{`{"type":"info"}`, `{"type":"debug"}`, "partial"}, lines)
+}
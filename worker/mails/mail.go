@@ -4,10 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	netmail "net/mail"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/pkg/config/config"
@@ -137,8 +139,22 @@ func SendMail(ctx *job.TaskContext) error {
 	if opts.TemplateName != "" && opts.Locale == "" {
 		opts.Locale = ctx.Instance.Locale
 	}
+	// The suppression list is only used for the mails to third parties and
+	// the campaigns: the mails for the owner of the instance (2FA codes,
+	// password resets, etc.) are always sent.
+	if opts.Mode == mail.ModeFromUser || opts.Mode == mail.ModeCampaign {
+		if err = removeSuppressed(ctx, &opts); err != nil {
+			return err
+		}
+		if len(opts.To) == 0 {
+			return nil
+		}
+	}
 	if err = sendMail(ctx, &opts, ctx.Instance.Domain); err != nil {
 		ctx.Logger().Warnf("sendmail has failed: %s", err)
+		if isPermanentError(err) {
+			ctx.SetNoRetry()
+		}
 	}
 	return err
 }
@@ -261,11 +277,69 @@ func doSendMail(ctx *job.TaskContext, opts *mail.Options, domain string) error {
 		}))
 	}
 
-	dialer := gomail.NewDialer(dialerOptions)
-	if deadline, ok := ctx.Deadline(); ok {
-		dialer.SetDeadline(deadline)
+	return deliver(ctx, dialerOptions, opts.From.Email, toAddresses, email)
+}
+
+// deliver sends the mail with the transport configured for the context of
+// the instance.
+func deliver(ctx *job.TaskContext, dialerOptions *gomail.DialerOptions, from string, to []string, email *gomail.Message) error {
+	var contextName string
+	if ctx.Instance != nil {
+		contextName = ctx.Instance.ContextName
+	}
+	transport, err := newTransport(ctx, config.GetMailTransport(contextName), dialerOptions)
+	if err != nil {
+		return err
+	}
+	sender, err := envelopeAddress(from)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, len(to))
+	for i, addr := range to {
+		recipients[i], err = envelopeAddress(addr)
+		if err != nil {
+			return err
+		}
+	}
+	return transport.Send(sender, recipients, email)
+}
+
+// envelopeAddress returns the addr-spec part of an email address that can be
+// in the "display-name <addr-spec>" format.
+func envelopeAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if !strings.HasSuffix(addr, ">") {
+		return addr, nil
+	}
+	parsed, err := netmail.ParseAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid email address %q: %w", addr, err)
+	}
+	return parsed.Address, nil
+}
+
+// removeSuppressed removes from the recipients the email addresses where a
+// previous mail has bounced, or where the recipient has complained.
+func removeSuppressed(ctx *job.TaskContext, opts *mail.Options) error {
+	to := opts.To[:0]
+	for _, addr := range opts.To {
+		email, err := envelopeAddress(addr.Email)
+		if err != nil {
+			return err
+		}
+		suppressed, err := contact.IsSuppressed(ctx.Instance, email)
+		if err != nil {
+			return err
+		}
+		if suppressed {
+			ctx.Logger().Infof("sendmail: %s is in the suppression list", email)
+			continue
+		}
+		to = append(to, addr)
 	}
-	return dialer.DialAndSend(email)
+	opts.To = to
+	return nil
 }
 
 func addPart(mail *gomail.Message, part *mail.Part) error {
@@ -310,9 +384,5 @@ func sendSupportMail(ctx *job.TaskContext, opts *mail.Options, domain string) er
 	body, _ := opts.TemplateValues["Body"].(string)
 	email.AddAlternative("text/plain", intro+body+"\n")
 
-	dialer := gomail.NewDialer(dialerOptions)
-	if deadline, ok := ctx.Deadline(); ok {
-		dialer.SetDeadline(deadline)
-	}
-	return dialer.DialAndSend(email)
+	return deliver(ctx, dialerOptions, opts.To[0].Email, []string{opts.ReplyTo.Email}, email)
 }
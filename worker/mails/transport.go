@@ -0,0 +1,225 @@
+package mails
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/mail"
+	"github.com/cozy/gomail"
+)
+
+const (
+	// TransportSMTP is the default transport, that sends the mails to an
+	// SMTP server.
+	TransportSMTP = "smtp"
+	// TransportSendmail is the transport that gives the mails to a local
+	// sendmail binary.
+	TransportSendmail = "sendmail"
+	// TransportHTTP is the transport that posts the mails to an HTTP API.
+	TransportHTTP = "http"
+
+	defaultSendmailPath = "/usr/sbin/sendmail"
+)
+
+// Transport is used to deliver a mail to its recipients.
+type Transport interface {
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+// permanentError is used when a mail has been rejected, and trying to send it
+// again will fail in the same way.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isPermanentError returns true if the job must not be retried for this error.
+func isPermanentError(err error) bool {
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return true
+	}
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
+
+// newTransport returns the transport configured for the context. The mails
+// are signed with DKIM if a domain is configured for it.
+func newTransport(ctx context.Context, cfg config.MailTransport, dialer *gomail.DialerOptions) (Transport, error) {
+	var t Transport
+	switch cfg.Transport {
+	case "", TransportSMTP:
+		t = &smtpTransport{ctx: ctx, opts: dialer}
+	case TransportSendmail:
+		path := cfg.SendmailPath
+		if path == "" {
+			path = defaultSendmailPath
+		}
+		t = &sendmailTransport{ctx: ctx, path: path}
+	case TransportHTTP:
+		if cfg.HTTP.URL == "" {
+			return nil, errors.New("Missing URL for the HTTP mail transport")
+		}
+		t = &httpTransport{ctx: ctx, url: cfg.HTTP.URL, token: cfg.HTTP.Token}
+	default:
+		return nil, fmt.Errorf("Unknown mail transport %q", cfg.Transport)
+	}
+
+	if cfg.DKIM.Domain == "" {
+		return t, nil
+	}
+	key, err := os.ReadFile(cfg.DKIM.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read the DKIM private key: %w", err)
+	}
+	signer, err := mail.NewDKIMSigner(cfg.DKIM.Domain, cfg.DKIM.Selector, key)
+	if err != nil {
+		return nil, err
+	}
+	return &dkimTransport{signer: signer, next: t}, nil
+}
+
+// smtpTransport sends the mails to an SMTP server.
+type smtpTransport struct {
+	ctx  context.Context
+	opts *gomail.DialerOptions
+}
+
+func (t *smtpTransport) Send(from string, to []string, msg io.WriterTo) error {
+	dialer := gomail.NewDialer(t.opts)
+	if deadline, ok := t.ctx.Deadline(); ok {
+		dialer.SetDeadline(deadline)
+	}
+	s, err := dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Send(from, to, msg)
+}
+
+// sendmailTransport gives the mails to a local sendmail binary (postfix,
+// exim, msmtp, etc.).
+type sendmailTransport struct {
+	ctx  context.Context
+	path string
+}
+
+// The exit codes of sendmail from sysexits.h for an unknown user or host.
+const (
+	exitNoUser = 67
+	exitNoHost = 68
+)
+
+func (t *sendmailTransport) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	cmd := exec.CommandContext(t.ctx, t.path, args...)
+	cmd.Stdin = &buf
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("sendmail: %w: %s", err, strings.TrimSpace(stderr.String()))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code == exitNoUser || code == exitNoHost {
+			return &permanentError{err}
+		}
+	}
+	return err
+}
+
+// httpTransport posts the mails to an HTTP API. It is a generic relay: the
+// request has the envelope and the raw message (base64 encoded), and an
+// adapter for a specific provider can be plugged behind it.
+type httpTransport struct {
+	ctx   context.Context
+	url   string
+	token string
+}
+
+var httpTransportClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+type httpTransportRequest struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Message string   `json:"message"`
+}
+
+func (t *httpTransport) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	body, err := json.Marshal(&httpTransportRequest{
+		From:    from,
+		To:      to,
+		Message: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(t.ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	res, err := httpTransportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	details, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err = fmt.Errorf("mail API responded with %d: %s", res.StatusCode, strings.TrimSpace(string(details)))
+	if res.StatusCode/100 == 4 && res.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// dkimTransport adds a DKIM signature to the mails before giving them to
+// another transport.
+type dkimTransport struct {
+	signer *mail.DKIMSigner
+	next   Transport
+}
+
+func (t *dkimTransport) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	signed, err := t.signer.Sign(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return t.next.Send(from, to, bytes.NewReader(signed))
+}
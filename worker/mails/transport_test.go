@@ -0,0 +1,155 @@
+package mails
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rawMail = "From: me@me\r\nTo: you@you\r\nSubject: Up?\r\n\r\nHey !!!\r\n"
+
+func TestTransports(t *testing.T) {
+	t.Run("Sendmail", func(t *testing.T) {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "out")
+		script := filepath.Join(dir, "sendmail")
+		content := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s.args\ncat > %s\n"+
+			"case \"$5\" in bad@*) exit 67;; esac\n", out, out)
+		require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+
+		tr, err := newTransport(context.Background(), config.MailTransport{
+			Transport:    TransportSendmail,
+			SendmailPath: script,
+		}, nil)
+		require.NoError(t, err)
+		err = tr.Send("me@me", []string{"you@you"}, strings.NewReader(rawMail))
+		require.NoError(t, err)
+		args, err := os.ReadFile(out + ".args")
+		require.NoError(t, err)
+		assert.Equal(t, "-i -f me@me -- you@you\n", string(args))
+		sent, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, rawMail, string(sent))
+
+		err = tr.Send("me@me", []string{"bad@you"}, strings.NewReader(rawMail))
+		assert.Error(t, err)
+		assert.True(t, isPermanentError(err))
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		status := http.StatusAccepted
+		var received httpTransportRequest
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+
+		tr, err := newTransport(context.Background(), config.MailTransport{
+			Transport: TransportHTTP,
+			HTTP:      config.MailHTTPRelay{URL: ts.URL, Token: "s3cr3t"},
+		}, nil)
+		require.NoError(t, err)
+		require.NoError(t, tr.Send("me@me", []string{"you@you"}, strings.NewReader(rawMail)))
+		assert.Equal(t, "me@me", received.From)
+		assert.Equal(t, []string{"you@you"}, received.To)
+		msg, err := base64.StdEncoding.DecodeString(received.Message)
+		require.NoError(t, err)
+		assert.Equal(t, rawMail, string(msg))
+
+		status = http.StatusBadRequest
+		err = tr.Send("me@me", []string{"you@you"}, strings.NewReader(rawMail))
+		assert.True(t, isPermanentError(err))
+		status = http.StatusServiceUnavailable
+		err = tr.Send("me@me", []string{"you@you"}, strings.NewReader(rawMail))
+		assert.Error(t, err)
+		assert.False(t, isPermanentError(err))
+	})
+
+	t.Run("DKIM", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		keyPath := filepath.Join(t.TempDir(), "dkim.pem")
+		pemKey := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		require.NoError(t, os.WriteFile(keyPath, pemKey, 0o600))
+
+		cfg := config.MailTransport{
+			Transport: TransportSMTP,
+			DKIM: config.DKIM{
+				Domain:         "me",
+				Selector:       "cozy",
+				PrivateKeyPath: keyPath,
+			},
+		}
+		tr, err := newTransport(context.Background(), cfg, nil)
+		require.NoError(t, err)
+		signing, ok := tr.(*dkimTransport)
+		require.True(t, ok)
+
+		var sent bytes.Buffer
+		signing.next = transportFunc(func(from string, to []string, msg []byte) error {
+			sent.Write(msg)
+			return nil
+		})
+		require.NoError(t, tr.Send("me@me", []string{"you@you"}, strings.NewReader(rawMail)))
+		assert.True(t, strings.HasPrefix(sent.String(), "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=me; s=cozy;"))
+		assert.True(t, strings.HasSuffix(sent.String(), rawMail))
+
+		cfg.DKIM.PrivateKeyPath = filepath.Join(t.TempDir(), "missing.pem")
+		_, err = newTransport(context.Background(), cfg, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := newTransport(context.Background(), config.MailTransport{Transport: "pigeon"}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("PermanentSMTPError", func(t *testing.T) {
+		assert.True(t, isPermanentError(&textproto.Error{Code: 550, Msg: "No such user"}))
+		assert.False(t, isPermanentError(&textproto.Error{Code: 451, Msg: "Try again later"}))
+	})
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	addr, err := envelopeAddress(" you@you ")
+	require.NoError(t, err)
+	assert.Equal(t, "you@you", addr)
+	addr, err = envelopeAddress(`"Jane Doe" <jane@example.org>`)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.org", addr)
+	_, err = envelopeAddress("<not an address>")
+	assert.Error(t, err)
+}
+
+type transportFunc func(from string, to []string, msg []byte) error
+
+func (f transportFunc) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	return f(from, to, buf.Bytes())
+}
@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "metadata",
+		Concurrency:  1,
+		MaxExecCount: 1,
+		Reserved:     true,
+		Timeout:      24 * time.Hour,
+		WorkerFunc:   Worker,
+	})
+}
+
+type metadataMsg struct {
+	// Force can be used to examine again the files that have been examined
+	// by the current version of the extractors.
+	Force bool `json:"force"`
+}
+
+// Worker is a worker that examines the existing files to extract their
+// metadata, when they have been uploaded before an extractor was available
+// for their type, or improved.
+func Worker(ctx *job.TaskContext) error {
+	var msg metadataMsg
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	fs := ctx.Instance.VFS()
+	var errm error
+	count := 0
+	err := vfs.Walk(fs, "/", func(name string, dir *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if dir != nil || file.Trashed || !vfs.HasMetaExtractor(file.Mime) {
+			return nil
+		}
+		if !msg.Force && extractorVersion(file) >= vfs.MetadataExtractorVersion {
+			return nil
+		}
+		meta, err := extract(fs, file)
+		if err != nil {
+			errm = multierror.Append(errm, err)
+			return nil
+		}
+		newdoc := file.Clone().(*vfs.FileDoc)
+		vfs.MergeMetadata(newdoc, meta)
+		if err := fs.UpdateFileDoc(file, newdoc); err != nil {
+			errm = multierror.Append(errm, err)
+			return nil
+		}
+		count++
+		return nil
+	})
+	ctx.Logger().Infof("Metadata extracted for %d files", count)
+	if err != nil {
+		return err
+	}
+	return errm
+}
+
+func extractorVersion(file *vfs.FileDoc) int {
+	if file.Metadata == nil {
+		return 0
+	}
+	switch v := file.Metadata["extractor_version"].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+func extract(fs vfs.VFS, file *vfs.FileDoc) (vfs.Metadata, error) {
+	extractor := vfs.NewMetaExtractor(file)
+	if extractor == nil {
+		return nil, nil
+	}
+	e := *extractor
+	f, err := fs.OpenFile(file)
+	if err != nil {
+		e.Abort(err)
+		return nil, err
+	}
+	defer f.Close()
+	// The extractors can stop reading when they have found what they need
+	if _, err := io.Copy(e, f); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		e.Abort(err)
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return e.Result(), nil
+}
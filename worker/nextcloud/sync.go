@@ -0,0 +1,42 @@
+// Package nextcloud is for the worker that keeps a Cozy folder in sync with a
+// Nextcloud folder.
+package nextcloud
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/nextcloud"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:  nextcloud.SyncWorkerType,
+		Concurrency: runtime.NumCPU(),
+		// The trigger will run the synchronisation again later
+		MaxExecCount: 1,
+		Timeout:      2 * time.Hour,
+		Reserved:     true,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker runs the reconciliation of a synchronisation.
+func Worker(ctx *job.TaskContext) error {
+	var msg nextcloud.SyncMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	err := nextcloud.RunSync(ctx.Instance, msg.SyncID)
+	if couchdb.IsNotFoundError(err) {
+		// The sync has been deleted after the job was pushed
+		return nil
+	}
+	if err != nil {
+		ctx.Instance.Logger().WithNamespace("nextcloud").
+			Warnf("Sync %s failed: %s", msg.SyncID, err)
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+// Package notifications is for the worker that sends the digest of the
+// notifications.
+package notifications
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   center.DigestWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Timeout:      1 * time.Minute,
+		Reserved:     true,
+		WorkerFunc:   WorkerDigest,
+	})
+}
+
+// WorkerDigest is the worker that sends the digest of the notifications by
+// mail.
+func WorkerDigest(ctx *job.TaskContext) error {
+	return center.SendDigest(ctx.Instance)
+}
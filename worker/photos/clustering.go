@@ -0,0 +1,32 @@
+// Package photos is for the worker that groups the photos in auto albums.
+package photos
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/photo"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:  photo.WorkerType,
+		Concurrency: runtime.NumCPU(),
+		// The next upload will run the clustering again
+		MaxExecCount: 1,
+		Timeout:      time.Hour,
+		Reserved:     true,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker updates the auto albums with the photos uploaded since its last run.
+func Worker(ctx *job.TaskContext) error {
+	err := photo.UpdateAutoAlbums(ctx.Instance)
+	if err != nil {
+		ctx.Instance.Logger().WithNamespace("photos").
+			Warnf("Cannot update the auto albums: %s", err)
+	}
+	return err
+}
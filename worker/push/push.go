@@ -125,6 +125,16 @@ func Worker(ctx *job.TaskContext) error {
 	if err := ctx.UnmarshalMessage(&msg); err != nil {
 		return err
 	}
+
+	// The browsers receive the notifications in addition to the mobile apps
+	nbBrowsers := 0
+	if !msg.NoWebPush {
+		nbBrowsers = pushToBrowsers(ctx, &msg)
+	}
+	if msg.NoDevices {
+		return nil
+	}
+
 	cs, err := oauth.GetNotifiables(ctx.Instance)
 	if err != nil {
 		return err
@@ -192,7 +202,7 @@ func Worker(ctx *job.TaskContext) error {
 				Warnf("could not send notification on device: %s", err)
 		}
 	}
-	if nbSent > 0 {
+	if nbSent > 0 || nbBrowsers > 0 {
 		return nil
 	}
 
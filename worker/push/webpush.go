@@ -0,0 +1,56 @@
+package push
+
+import (
+	"encoding/base64"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/notification/webpush"
+)
+
+// pushToBrowsers sends the notification to the browsers that have subscribed
+// with Web Push, and returns the number of browsers reached.
+func pushToBrowsers(ctx *job.TaskContext, msg *center.PushMessage) int {
+	if !webpush.IsConfigured() {
+		return 0
+	}
+	subs, err := webpush.List(ctx.Instance)
+	if err != nil {
+		ctx.Logger().Warnf("Cannot list the Web Push subscriptions: %s", err)
+		return 0
+	}
+	if len(subs) == 0 {
+		return 0
+	}
+
+	opts := webpush.Options{Urgency: "normal"}
+	if msg.Priority == "high" {
+		opts.Urgency = "high"
+	}
+	if msg.Collapsible {
+		opts.Topic = base64.RawURLEncoding.EncodeToString(hashSource(msg.Source))
+	}
+	message := &webpush.Message{
+		NotificationID: msg.NotificationID,
+		Source:         msg.Source,
+		Slug:           msg.Slug(),
+		Title:          msg.Title,
+		Body:           msg.Message,
+		Data:           msg.Data,
+	}
+
+	nb := 0
+	for _, sub := range subs {
+		err := webpush.Send(sub, message, opts)
+		if err == webpush.ErrGone {
+			_ = sub.Delete(ctx.Instance)
+			continue
+		}
+		if err != nil {
+			ctx.Logger().Warnf("Could not send Web Push notification: %s", err)
+			continue
+		}
+		nb++
+	}
+	return nb
+}
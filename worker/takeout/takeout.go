@@ -0,0 +1,38 @@
+// Package takeout is for the worker that imports the archives exported from
+// other clouds, like Google Takeout.
+package takeout
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/takeout"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   takeout.WorkerType,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Timeout:      24 * time.Hour,
+		Reserved:     true,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker imports the archives of a migration.
+func Worker(ctx *job.TaskContext) error {
+	var msg struct {
+		MigrationID string `json:"migration_id"`
+	}
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	err := takeout.Run(ctx.Instance, msg.MigrationID)
+	if err != nil {
+		ctx.Instance.Logger().WithNamespace("takeout").
+			Warnf("Import %s failed: %s", msg.MigrationID, err)
+	}
+	return err
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/photo"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -114,6 +115,9 @@ func Worker(ctx *job.TaskContext) error {
 		}
 		return generateThumbnails(ctx, &img.Doc)
 	case "DELETED":
+		if err := photo.DeleteHash(ctx.Instance, img.Doc.ID()); err != nil {
+			log.Debugf("failed to remove the hash of %s: %s", img.Doc.ID(), err)
+		}
 		return removeThumbnails(ctx.Instance, &img.Doc)
 	}
 	return fmt.Errorf("unknown type %s for event", img.Verb)
@@ -135,6 +139,7 @@ func sameImg(doc, old *vfs.FileDoc) bool {
 
 type thumbnailMsg struct {
 	WithMetadata bool `json:"with_metadata"`
+	WithHashes   bool `json:"with_hashes"`
 }
 
 // WorkerCheck is a worker function that checks all the images to generate
@@ -147,6 +152,14 @@ func WorkerCheck(ctx *job.TaskContext) error {
 	fs := ctx.Instance.VFS()
 	fsThumb := ctx.Instance.ThumbsFS()
 	var errm error
+	var hashed map[string]bool
+	if msg.WithHashes {
+		var err error
+		hashed, err = photo.HashedFiles(ctx.Instance)
+		if err != nil {
+			return err
+		}
+	}
 	_ = vfs.Walk(fs, "/", func(name string, dir *vfs.DirDoc, img *vfs.FileDoc, err error) error {
 		if err != nil {
 			return err
@@ -170,6 +183,10 @@ func WorkerCheck(ctx *job.TaskContext) error {
 			if err = generateThumbnails(ctx, img); err != nil {
 				errm = multierror.Append(errm, err)
 			}
+		} else if msg.WithHashes && !hashed[img.ID()] {
+			if err = computeHash(ctx, img); err != nil {
+				errm = multierror.Append(errm, err)
+			}
 		}
 		if msg.WithMetadata {
 			var meta *vfs.Metadata
@@ -288,6 +305,9 @@ func generateThumbnails(ctx *job.TaskContext, img *vfs.FileDoc) error {
 		if err != nil {
 			return err
 		}
+		if small, ok := in.(*bytes.Buffer); ok {
+			saveHash(ctx, img, bytes.NewReader(small.Bytes()))
+		}
 	}
 
 	exists, err := fs.ThumbExists(img, "tiny")
@@ -301,6 +321,45 @@ func generateThumbnails(ctx *job.TaskContext, img *vfs.FileDoc) error {
 	return err
 }
 
+// saveHash computes the perceptual hash of an image from its small thumbnail,
+// and saves it in the index used to find the duplicate photos. A failure is
+// only logged, as the thumbnails are still useful without it.
+func saveHash(ctx *job.TaskContext, img *vfs.FileDoc, thumb io.Reader) {
+	hash, err := photo.HashFromThumbnail(thumb)
+	if err == nil {
+		err = photo.SaveHash(ctx.Instance, img, hash)
+	}
+	if err != nil {
+		ctx.Logger().Infof("cannot save the hash of %s: %s", img.ID(), err)
+	}
+}
+
+// computeHash generates the small thumbnail of an image in memory, to compute
+// its perceptual hash, when the thumbnails have been generated before the
+// hashes were indexed.
+func computeHash(ctx *job.TaskContext, img *vfs.FileDoc) error {
+	if ok := checkByteSize(img); !ok {
+		return nil
+	}
+	in, err := ctx.Instance.VFS().OpenFile(img)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var env []string
+	if tempDir, err := os.MkdirTemp("", "magick"); err == nil {
+		defer os.RemoveAll(tempDir)
+		env = []string{fmt.Sprintf("MAGICK_TEMPORARY_PATH=%s", tempDir)}
+	}
+	var thumb bytes.Buffer
+	if err := generateThumb(ctx, in, &thumb, img.ID(), "small", env); err != nil {
+		return err
+	}
+	saveHash(ctx, img, &thumb)
+	return nil
+}
+
 func checkByteSize(img *vfs.FileDoc) bool {
 	// Do not try to generate thumbnails for images that weight more than 100MB
 	// (or 5MB for PSDs)
@@ -0,0 +1,61 @@
+// Package webhooks is for the worker that delivers the outgoing webhooks.
+package webhooks
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/webhook"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:    webhook.WorkerType,
+		Concurrency:   runtime.NumCPU() * 2,
+		MaxExecCount:  6,
+		Timeout:       1 * time.Minute,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: 1 * time.Hour,
+		Backoff:       job.BackoffExponential,
+		Reserved:      true,
+		// The failures are logged in the deliveries of the webhook, and the
+		// webhook is disabled when there are too many of them.
+		NoDeadLetter: true,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker posts a change of a document to the endpoint of a webhook.
+func Worker(ctx *job.TaskContext) error {
+	var msg webhook.Message
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	var evt webhook.Event
+	if err := ctx.UnmarshalEvent(&evt); err != nil {
+		ctx.SetNoRetry()
+		return err
+	}
+
+	hook, err := webhook.Get(ctx.Instance, msg.WebhookID)
+	if couchdb.IsNotFoundError(err) {
+		// The webhook has been deleted after the job was pushed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = hook.Deliver(ctx, ctx.Instance, ctx.ID(), &evt)
+	if err == webhook.ErrDisabled {
+		return nil
+	}
+	var deliveryErr *webhook.DeliveryError
+	if errors.As(err, &deliveryErr) && (!deliveryErr.Retryable() || hook.Disabled) {
+		ctx.SetNoRetry()
+	}
+	return err
+}